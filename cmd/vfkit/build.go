@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Code-Hex/vz/v3"
+	"github.com/crc-org/vfkit/pkg/build"
+	"github.com/crc-org/vfkit/pkg/config"
+	"github.com/crc-org/vfkit/pkg/naming"
+	"github.com/crc-org/vfkit/pkg/vf"
+	vmstate "github.com/crc-org/vfkit/pkg/vm"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var buildOpts struct {
+	output  string
+	timeout time.Duration
+}
+
+var buildCmd = &cobra.Command{
+	Use:   "build spec.json",
+	Short: "build a disk image by provisioning a base image in a temporary virtual machine",
+	Long: `Boot the base image of the build specification in a temporary virtual machine, run its
+cloud-init and provisioning steps with the vfkit guest agent, and write the compacted disk to the output image.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		spec, err := build.LoadSpecFile(args[0])
+		if err != nil {
+			return err
+		}
+		if buildOpts.output != "" {
+			spec.Output = buildOpts.output
+		}
+		return buildImage(spec, buildOpts.timeout)
+	},
+}
+
+func init() {
+	buildCmd.Flags().StringVar(&buildOpts.output, "output", "", "path of the built image, overrides the 'output' key of the specification")
+	buildCmd.Flags().DurationVar(&buildOpts.timeout, "timeout", time.Hour, "maximum duration of the provisioning")
+	rootCmd.AddCommand(buildCmd)
+}
+
+// newBuildVMConfiguration creates the configuration of the build virtual
+// machine, with the image being built as its last disk.
+func newBuildVMConfiguration(spec *build.Spec) (*config.VirtualMachine, error) {
+	vmConfig, err := config.LoadReader(bytes.NewReader(spec.VM))
+	if err != nil {
+		return nil, fmt.Errorf("vm: %w", err)
+	}
+	if err := vmConfig.AddDevicesFromCmdLine([]string{"virtio-blk,path=" + spec.BuildPath()}); err != nil {
+		return nil, err
+	}
+	vmConfig.EnableGuestAgent()
+	namingTemplate, err := naming.NewTemplate("", "", fmt.Sprintf("build-%d", os.Getpid()))
+	if err != nil {
+		return nil, err
+	}
+	if err := vmConfig.GenerateArtifactPaths(namingTemplate); err != nil {
+		return nil, err
+	}
+
+	return vmConfig, nil
+}
+
+// buildImage builds the image described by spec, the partial image is
+// removed if the build fails.
+func buildImage(spec *build.Spec, timeout time.Duration) (err error) {
+	ignoreBrokenPipes()
+
+	vmConfig, err := newBuildVMConfiguration(spec)
+	if err != nil {
+		return err
+	}
+	log.Infof("preparing %s from %s", spec.BuildPath(), spec.Base)
+	if err := spec.PrepareImage(); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(spec.BuildPath())
+		}
+	}()
+
+	if err := vmConfig.CheckArchitecture(); err != nil {
+		return err
+	}
+	vzVMConfig, err := vmConfig.ToVzVirtualMachineConfig()
+	if err != nil {
+		return err
+	}
+	vzVM, err := vz.NewVirtualMachine(vzVMConfig)
+	if err != nil {
+		return err
+	}
+	vm := vf.NewVirtualMachine(vzVM)
+	vm.ShutdownTimeout = 2 * time.Minute
+	if err := vm.Start(); err != nil {
+		return err
+	}
+	defer func() {
+		if vm.StateMachine().State() != vmstate.StateStopped {
+			_ = vm.Stop()
+		}
+	}()
+	if err := waitForVMState(vm.StateMachine(), vmstate.StateRunning); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	client, err := waitForAgent(ctx, vm.VirtualMachine, spec.AgentPort)
+	if err != nil {
+		return fmt.Errorf("guest agent unreachable, it must be installed in the base image: %w", err)
+	}
+	defer client.Close()
+	if err := spec.Provision(ctx, client, os.Stdout); err != nil {
+		return err
+	}
+
+	log.Infof("shutting down build virtual machine")
+	if err := vm.Shutdown(); err != nil {
+		return err
+	}
+	log.Infof("writing %s", spec.Output)
+
+	return spec.FinishImage()
+}
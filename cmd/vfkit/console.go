@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/crc-org/vfkit/pkg/config"
+	"github.com/crc-org/vfkit/pkg/console"
+	"github.com/crc-org/vfkit/pkg/naming"
+	"github.com/crc-org/vfkit/pkg/output"
+	"github.com/crc-org/vfkit/pkg/util/term"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var attachOpts struct {
+	stateDir string
+	serial   string
+	socket   string
+}
+
+var attachCmd = &cobra.Command{
+	Use:   "attach name",
+	Short: "attach to the serial console of a virtual machine",
+	Long: `Attach the terminal to the serial console of the virtual machine started with --name, which needs a
+virtio-serial device with the 'console' option. Several terminals can be attached at the same time. Press ^] to detach.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if attachOpts.socket != "" {
+			return cobra.NoArgs(cmd, args)
+		}
+		return cobra.ExactArgs(1)(cmd, args)
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		socketPath := attachOpts.socket
+		if socketPath == "" {
+			tmpl, err := naming.NewTemplate("", attachOpts.stateDir, args[0])
+			if err != nil {
+				return err
+			}
+			deviceID := naming.NamedSerialDeviceID(attachOpts.serial)
+			if index, err := strconv.Atoi(attachOpts.serial); err == nil {
+				deviceID = naming.SerialDeviceID(index)
+			}
+			socketPath = tmpl.Path(deviceID, "sock")
+		}
+		if _, err := os.Stat(socketPath); err != nil {
+			return fmt.Errorf("no serial console found, is the virtual machine running with a 'console' virtio-serial device? %w", err)
+		}
+		return attachConsole(socketPath)
+	},
+}
+
+func init() {
+	attachCmd.Flags().StringVar(&attachOpts.stateDir, "state-dir", "", "directory where generated host artifacts are stored (default \"$HOME/.vfkit\")")
+	attachCmd.Flags().StringVar(&attachOpts.serial, "serial", "0", "index of the virtio-serial device, or value of its 'id' option")
+	attachCmd.Flags().StringVar(&attachOpts.socket, "socket", "", "path to the console socket, instead of the generated path of the named virtual machine")
+	rootCmd.AddCommand(attachCmd)
+}
+
+// attachConsole connects the terminal to the console listening on
+// socketPath until ^] is pressed.
+func attachConsole(socketPath string) error {
+	if output.IsTerminal(os.Stdin) {
+		restore, err := term.MakeRaw(int(os.Stdin.Fd()))
+		if err != nil {
+			return err
+		}
+		defer func() { _ = restore() }()
+	}
+	fmt.Fprintf(os.Stderr, "attached to %s, press ^] to detach\r\n", socketPath)
+
+	stdio := struct {
+		io.Reader
+		io.Writer
+	}{console.NewDetachReader(os.Stdin), os.Stdout}
+	err := console.Connect(context.Background(), socketPath, stdio)
+	fmt.Fprint(os.Stderr, "\r\n")
+
+	return err
+}
+
+// startConsoles starts the consoles of the virtio-serial devices of vmConfig.
+// The returned function stops them.
+func startConsoles(vmConfig *config.VirtualMachine) (func(), error) {
+	consoles := vmConfig.SerialConsoles()
+	stop := func() {
+		for _, serialConsole := range consoles {
+			if err := serialConsole.Console.Close(); err != nil {
+				log.Debugf("error closing serial console: %v", err)
+			}
+		}
+	}
+	for _, serialConsole := range consoles {
+		if err := serialConsole.Console.Start(serialConsole.LogFile); err != nil {
+			stop()
+			return nil, err
+		}
+		if err := serialConsole.Console.Listen(serialConsole.SocketPath); err != nil {
+			stop()
+			return nil, err
+		}
+	}
+
+	return stop, nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/crc-org/vfkit/pkg/cmdline"
+	"github.com/crc-org/vfkit/pkg/config"
+	"github.com/crc-org/vfkit/pkg/naming"
+	"github.com/crc-org/vfkit/pkg/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// daemonizedEnv is set in the environment of the background vfkit process
+// started by --daemonize.
+const daemonizedEnv = "VFKIT_DAEMONIZED"
+
+// daemonStartTimeout is how long --daemonize waits for the background vfkit
+// process to write its pid file.
+const daemonStartTimeout = 10 * time.Second
+
+// daemonize starts vfkit again in the background, in a new session detached
+// from the controlling terminal, and returns true. It returns false when
+// called from this background process, or when --daemonize is not used.
+func daemonize(opts *cmdline.Options) (bool, error) {
+	if !opts.Daemonize || os.Getenv(daemonizedEnv) != "" {
+		return false, nil
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return false, err
+	}
+	devNull, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		return false, err
+	}
+	defer devNull.Close()
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), daemonizedEnv+"=1")
+	cmd.Stdin = devNull
+	cmd.Stdout = devNull
+	cmd.Stderr = devNull
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return false, fmt.Errorf("failed to start vfkit in the background: %w", err)
+	}
+
+	if opts.PIDFile != "" {
+		if err := waitForPIDFile(cmd, opts.PIDFile); err != nil {
+			return false, err
+		}
+	}
+	log.Infof("vfkit is running in the background with pid %d", cmd.Process.Pid)
+
+	return true, nil
+}
+
+// waitForPIDFile waits until the background vfkit process started with cmd
+// has written its pid to pidFile, so that it can be found as soon as
+// daemonize returns.
+func waitForPIDFile(cmd *exec.Cmd, pidFile string) error {
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	timeout := time.After(daemonStartTimeout)
+	for {
+		if pid, err := util.ReadPIDFile(pidFile); err == nil && pid == cmd.Process.Pid {
+			return nil
+		}
+		select {
+		case err := <-exited:
+			return fmt.Errorf("vfkit exited while starting in the background (%v), use --log-file to find out why", err)
+		case <-timeout:
+			return fmt.Errorf("timeout waiting for vfkit to write its pid file %s", pidFile)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// writePIDFile writes the pid of vfkit to --pidfile. The returned function
+// removes the file.
+func writePIDFile(opts *cmdline.Options) (func(), error) {
+	if opts.PIDFile == "" {
+		return func() {}, nil
+	}
+	if err := util.WritePIDFile(opts.PIDFile); err != nil {
+		return nil, err
+	}
+
+	return func() {
+		if err := os.Remove(opts.PIDFile); err != nil {
+			log.Debugf("failed to remove pid file: %v", err)
+		}
+	}, nil
+}
+
+// writeInstanceRecord records the pid and REST API URI of vfkit in the state
+// directory of named virtual machines, so that a virtual machine manager can
+// adopt them after a crash. The record also describes the virtual machine
+// for 'vfkit inventory'. All vfkit processes, named or not, also register in
+// the per-user registry directory for client.ListRunningVMs. The returned
+// function removes the records.
+func writeInstanceRecord(opts *cmdline.Options, vmConfig *config.VirtualMachine, labels map[string]string) (func(), error) {
+	macs := []string{}
+	for _, mac := range vmConfig.MACAddresses() {
+		macs = append(macs, mac.String())
+	}
+	digest, err := configDigest(vmConfig)
+	if err != nil {
+		return nil, err
+	}
+	record := &util.InstanceRecord{
+		PID:          os.Getpid(),
+		RestfulURI:   opts.RestfulURI,
+		Version:      vfkitVersion,
+		StartTime:    time.Now(),
+		Name:         opts.Name,
+		Labels:       labels,
+		CPUs:         vmConfig.Vcpus(),
+		MemoryBytes:  vmConfig.MemoryBytes(),
+		Disks:        vmConfig.DiskImagePaths(),
+		MACAddresses: macs,
+		ConfigDigest: digest,
+	}
+
+	paths := []string{}
+	removeRecords := func() {
+		for _, path := range paths {
+			if err := os.Remove(path); err != nil {
+				log.Debugf("failed to remove instance record: %v", err)
+			}
+		}
+	}
+	if opts.Name != "" {
+		namingTemplate, err := naming.NewTemplate(opts.NamingTemplate, opts.StateDir, opts.Name)
+		if err != nil {
+			return nil, err
+		}
+		record.SerialNumber, err = util.ReadOrCreateSerialNumber(namingTemplate.Path(naming.SerialNumberID, "txt"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read serial number: %w", err)
+		}
+		path := namingTemplate.Path(naming.InstanceID, "json")
+		if err := util.WriteInstanceRecord(path, record); err != nil {
+			return nil, fmt.Errorf("virtual machine '%s' is already running: %w", opts.Name, err)
+		}
+		paths = append(paths, path)
+	}
+
+	// the registry is only used for discovery, vfkit can run without it
+	registryDir, err := naming.RegistryDir()
+	if err == nil {
+		path := util.RegistryRecordPath(registryDir, record.PID)
+		err = util.WriteInstanceRecord(path, record)
+		if err == nil {
+			paths = append(paths, path)
+		}
+	}
+	if err != nil {
+		log.Warnf("failed to register in the instance registry: %v", err)
+	}
+
+	return removeRecords, nil
+}
+
+// configDigest returns the sha256 digest of the virtual machine
+// configuration, as reported by the /vm/inspect endpoint.
+func configDigest(vmConfig *config.VirtualMachine) (string, error) {
+	data, err := json.Marshal(vmConfig.Inspect())
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data)), nil
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/crc-org/vfkit/pkg/config"
+)
+
+// printConfig validates vmConfig and writes its effective configuration to
+// out, in the JSON format of the /vm/inspect REST endpoint. It's used by
+// --dry-run, the virtual machine is not started.
+func printConfig(out io.Writer, vmConfig *config.VirtualMachine) error {
+	if err := vmConfig.Validate(); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(vmConfig.Inspect(), "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(out, string(data))
+	return err
+}
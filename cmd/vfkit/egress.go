@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/crc-org/vfkit/pkg/config"
+	"github.com/crc-org/vfkit/pkg/dhcp"
+	"github.com/crc-org/vfkit/pkg/egress"
+	log "github.com/sirupsen/logrus"
+)
+
+// setupEgressRules starts enforcing the egress rules of the virtual machine
+// with pf, it returns nil if there are none. The returned function removes
+// the rules.
+func setupEgressRules(ctx context.Context, vmConfig *config.VirtualMachine) (*egress.Enforcer, func(), error) {
+	policy := vmConfig.EgressPolicy()
+	if policy == nil {
+		return nil, func() {}, nil
+	}
+	if len(vmConfig.MACAddresses()) == 0 {
+		return nil, nil, fmt.Errorf("egress rules need a virtio-net device with a 'mac' option")
+	}
+	lookup, err := guestIPLookup(vmConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	// the virtual machine must not run without its rules
+	anchor, err := egress.NewPFAnchor(fmt.Sprintf("vfkit-%d", os.Getpid()))
+	if err != nil {
+		return nil, nil, fmt.Errorf("egress rules need root privileges to configure pf: %w", err)
+	}
+	removeRules := func() {
+		if err := anchor.Close(); err != nil {
+			log.Warnf("failed to remove egress rules: %v", err)
+		}
+	}
+	enforcer := egress.NewEnforcer(policy, lookup, anchor)
+	// the guest must not send any packet before the rules are loaded
+	natSubnet, err := dhcp.NATSubnet()
+	if err != nil {
+		removeRules()
+		return nil, nil, err
+	}
+	if err := enforcer.LoadPendingRules(natSubnet); err != nil {
+		removeRules()
+		return nil, nil, fmt.Errorf("failed to block the guest network until the egress rules are applied: %w", err)
+	}
+	go enforcer.Run(ctx)
+
+	return enforcer, removeRules, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/crc-org/vfkit/pkg/cmdline"
+	"github.com/crc-org/vfkit/pkg/config"
+	"github.com/crc-org/vfkit/pkg/events"
+	vmstate "github.com/crc-org/vfkit/pkg/vm"
+	log "github.com/sirupsen/logrus"
+)
+
+// newEventLog creates the event log of the virtual machine, mirrored to the
+// --events-file file when it's set.
+func newEventLog(opts *cmdline.Options) (*events.Log, error) {
+	eventLog := events.NewLog(events.DefaultCapacity)
+	if opts.EventsFile != "" {
+		if err := eventLog.MirrorTo(opts.EventsFile); err != nil {
+			return nil, fmt.Errorf("failed to open events file: %w", err)
+		}
+		log.Debugf("recording events in %s", opts.EventsFile)
+	}
+
+	return eventLog, nil
+}
+
+// recordEvent records an event in eventLog, failures are only logged.
+func recordEvent(eventLog *events.Log, eventType string, message string, details interface{}) {
+	if _, err := eventLog.Record(eventType, message, details); err != nil {
+		log.Warnf("failed to record %s event: %v", eventType, err)
+	}
+}
+
+// recordDeviceEvents records the devices of the virtual machine configuration
+// as attached.
+func recordDeviceEvents(eventLog *events.Log, vmConfig *config.VirtualMachine) {
+	for _, device := range vmConfig.Inspect().Devices {
+		recordEvent(eventLog, events.TypeDeviceAttached, fmt.Sprintf("%s device attached", device.Type), device)
+	}
+}
+
+// lifecycleEventType returns the event type of a state change, or false for
+// the transient states which are not recorded.
+func lifecycleEventType(change vmstate.StateChange) (string, bool) {
+	switch change.To {
+	case vmstate.StateRunning:
+		if change.From == vmstate.StatePaused {
+			return events.TypeResumed, true
+		}
+		return events.TypeStarted, true
+	case vmstate.StatePaused:
+		return events.TypePaused, true
+	case vmstate.StateStopped:
+		return events.TypeStopped, true
+	case vmstate.StateError:
+		return events.TypeCrashed, true
+	case vmstate.StateFailed:
+		return events.TypeFailed, true
+	default:
+		return "", false
+	}
+}
+
+// recordLifecycleEvents records the state changes of machine in eventLog until
+// the returned function is called.
+func recordLifecycleEvents(eventLog *events.Log, machine *vmstate.StateMachine) func() {
+	changes, unsubscribe := machine.Subscribe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for change := range changes {
+			eventType, ok := lifecycleEventType(change)
+			if !ok {
+				continue
+			}
+			details := stateChangeDetails{From: change.From, Message: change.Message}
+			recordEvent(eventLog, eventType, fmt.Sprintf("virtual machine %s", change.To), details)
+		}
+	}()
+
+	return func() {
+		unsubscribe()
+		<-done
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/crc-org/vfkit/pkg/config"
+	"github.com/crc-org/vfkit/pkg/gvproxy"
+	log "github.com/sirupsen/logrus"
+)
+
+// startGVProxy starts the gvproxy process configured with --gvproxy, it does
+// nothing if there is none. It must run before the virtual machine
+// configuration is created since the virtio-net device connects to gvproxy.
+// The returned function stops gvproxy.
+func startGVProxy(proxyConfig *config.GVProxy) (func(), error) {
+	if proxyConfig == nil {
+		return func() {}, nil
+	}
+	process, err := gvproxy.Start(proxyConfig.Config())
+	if err != nil {
+		return nil, fmt.Errorf("cannot start gvproxy: %w", err)
+	}
+	stopping := make(chan struct{})
+	go func() {
+		select {
+		case <-process.Done():
+			log.Errorf("gvproxy exited unexpectedly, the guest network is down: %v", process.Err())
+		case <-stopping:
+		}
+	}()
+	log.Infof("gvproxy started (pid %d)", process.PID())
+
+	return func() {
+		close(stopping)
+		if err := process.Stop(); err != nil {
+			log.Warnf("failed to stop gvproxy: %v", err)
+		}
+	}, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+
+	"github.com/crc-org/vfkit/pkg/inventory"
+	"github.com/crc-org/vfkit/pkg/output"
+	"github.com/spf13/cobra"
+)
+
+var inventoryOpts struct {
+	stateDir string
+	output   string
+}
+
+var inventoryCmd = &cobra.Command{
+	Use:   "inventory",
+	Short: "export the virtual machines of a state directory",
+	Long: `Export the virtual machines started with --name, with their serial number, labels,
+configuration, addresses and resource usage, for asset management databases.
+Only the virtual machines using the default naming template are listed.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		printer, err := output.NewPrinter(cmd.OutOrStdout(), inventoryOpts.output)
+		if err != nil {
+			return err
+		}
+		entries, err := inventory.Collect(context.Background(), inventoryOpts.stateDir)
+		if err != nil {
+			return err
+		}
+		return printer.Print(entries, func() (*output.Table, error) {
+			return inventory.Table(entries), nil
+		})
+	},
+}
+
+func init() {
+	inventoryCmd.Flags().StringVar(&inventoryOpts.stateDir, "state-dir", "", "directory where generated host artifacts are stored (default \"$HOME/.vfkit\")")
+	output.AddFlag(inventoryCmd, &inventoryOpts.output)
+	rootCmd.AddCommand(inventoryCmd)
+}
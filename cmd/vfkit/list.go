@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+
+	"github.com/crc-org/vfkit/pkg/inventory"
+	"github.com/crc-org/vfkit/pkg/output"
+	"github.com/spf13/cobra"
+)
+
+var listOpts struct {
+	stateDir string
+	output   string
+}
+
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list the virtual machines of a state directory",
+	Long: `List the virtual machines started with --name, with their state and main resources.
+Only the virtual machines using the default naming template are listed.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		printer, err := output.NewPrinter(cmd.OutOrStdout(), listOpts.output)
+		if err != nil {
+			return err
+		}
+		entries, err := inventory.Collect(context.Background(), listOpts.stateDir)
+		if err != nil {
+			return err
+		}
+		return printer.Print(entries, func() (*output.Table, error) {
+			return inventory.SummaryTable(entries), nil
+		})
+	},
+}
+
+var inspectOpts struct {
+	stateDir string
+	output   string
+}
+
+var inspectCmd = &cobra.Command{
+	Use:   "inspect name",
+	Short: "show the details of a virtual machine",
+	Long:  `Show the configuration, state, addresses and resource usage of the virtual machine started with --name.`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		printer, err := output.NewPrinter(cmd.OutOrStdout(), inspectOpts.output)
+		if err != nil {
+			return err
+		}
+		entry, err := inventory.Lookup(context.Background(), inspectOpts.stateDir, args[0])
+		if err != nil {
+			return err
+		}
+		return printer.Print(entry, nil)
+	},
+}
+
+func init() {
+	listCmd.Flags().StringVar(&listOpts.stateDir, "state-dir", "", "directory where generated host artifacts are stored (default \"$HOME/.vfkit\")")
+	output.AddFlag(listCmd, &listOpts.output)
+	rootCmd.AddCommand(listCmd)
+
+	inspectCmd.Flags().StringVar(&inspectOpts.stateDir, "state-dir", "", "directory where generated host artifacts are stored (default \"$HOME/.vfkit\")")
+	output.AddFlag(inspectCmd, &inspectOpts.output)
+	rootCmd.AddCommand(inspectCmd)
+}
@@ -19,6 +19,7 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/signal"
@@ -28,7 +29,16 @@ import (
 	"github.com/Code-Hex/vz/v3"
 	"github.com/crc-org/vfkit/pkg/cmdline"
 	"github.com/crc-org/vfkit/pkg/config"
+	"github.com/crc-org/vfkit/pkg/journal"
+	"github.com/crc-org/vfkit/pkg/naming"
+	"github.com/crc-org/vfkit/pkg/pressure"
+	"github.com/crc-org/vfkit/pkg/rest"
+	"github.com/crc-org/vfkit/pkg/rest/define"
+	"github.com/crc-org/vfkit/pkg/schedule"
+	"github.com/crc-org/vfkit/pkg/signals"
+	"github.com/crc-org/vfkit/pkg/throttle"
 	"github.com/crc-org/vfkit/pkg/vf"
+	vmstate "github.com/crc-org/vfkit/pkg/vm"
 	"github.com/docker/go-units"
 	log "github.com/sirupsen/logrus"
 )
@@ -55,50 +65,149 @@ func newBootloaderConfiguration(opts *cmdline.Options) (config.Bootloader, error
 	return config.BootloaderFromCmdLine(opts.Bootloader.GetSlice())
 }
 
-func newVMConfiguration(opts *cmdline.Options) (*config.VirtualMachine, error) {
-	bootloader, err := newBootloaderConfiguration(opts)
+// newVMConfigurationFromFile loads the virtual machine configuration from
+// the --config file. Explicitly set command line flags take precedence over
+// the file content.
+func newVMConfigurationFromFile(opts *cmdline.Options) (*config.VirtualMachine, error) {
+	vmConfig, err := config.Load(opts.ConfigPath)
 	if err != nil {
 		return nil, err
 	}
 
+	if opts.Changed("cpus") {
+		vmConfig.SetVcpus(opts.Vcpus)
+	}
+	if opts.Changed("memory") {
+		vmConfig.SetMemoryBytes(opts.Memory.Bytes())
+	}
+	if newLegacyBootloader(opts) != nil || opts.Changed("bootloader") {
+		bootloader, err := newBootloaderConfiguration(opts)
+		if err != nil {
+			return nil, err
+		}
+		vmConfig.SetBootloader(bootloader)
+	}
+
+	return vmConfig, nil
+}
+
+func newVMConfiguration(opts *cmdline.Options) (*config.VirtualMachine, error) {
+	var vmConfig *config.VirtualMachine
+
+	if opts.ConfigPath != "" {
+		var err error
+		if vmConfig, err = newVMConfigurationFromFile(opts); err != nil {
+			return nil, err
+		}
+	} else {
+		bootloader, err := newBootloaderConfiguration(opts)
+		if err != nil {
+			return nil, err
+		}
+		vmConfig = config.NewVirtualMachine(
+			opts.Vcpus,
+			opts.Memory.Bytes(),
+			bootloader,
+		)
+	}
+
+	for _, args := range opts.KernelCmdlineAppend {
+		if err := vmConfig.AppendKernelCmdLine(args); err != nil {
+			return nil, err
+		}
+	}
+
 	log.Info(opts)
-	log.Infof("boot parameters: %+v", bootloader)
+	log.Infof("boot parameters: %+v", vmConfig.Bootloader())
 	log.Info()
 
-	vmConfig := config.NewVirtualMachine(
-		opts.Vcpus,
-		uint64(opts.MemoryMiB*units.MiB),
-		bootloader,
-	)
 	log.Info("virtual machine parameters:")
-	log.Infof("\tvCPUs: %d", opts.Vcpus)
-	log.Infof("\tmemory: %d MiB", opts.MemoryMiB)
+	log.Infof("\tvCPUs: %d", vmConfig.Vcpus())
+	log.Infof("\tmemory: %d MiB", vmConfig.MemoryBytes()/units.MiB)
 	log.Info()
 
-	if err := vmConfig.AddTimeSyncFromCmdLine(opts.TimeSync); err != nil {
-		return nil, err
+	if timesyncOpts := opts.TimeSync.Options(); timesyncOpts != nil {
+		vmConfig.SetTimeSync(timesyncOpts)
 	}
 
 	if err := vmConfig.AddDevicesFromCmdLine(opts.Devices); err != nil {
 		return nil, err
 	}
 
+	if err := vmConfig.AddCdromsFromCmdLine(opts.Cdroms); err != nil {
+		return nil, err
+	}
+
+	if err := vmConfig.AddPortForwardsFromCmdLine(opts.Publish); err != nil {
+		return nil, err
+	}
+
+	if err := vmConfig.AddSwapFromCmdLine(opts.Swap); err != nil {
+		return nil, err
+	}
+
+	if err := vmConfig.AddEgressRulesFromCmdLine(opts.Egress); err != nil {
+		return nil, err
+	}
+
+	if err := vmConfig.AddCacheProxyFromCmdLine(opts.CacheProxy); err != nil {
+		return nil, err
+	}
+
+	if opts.VNC != "" {
+		return nil, fmt.Errorf("--vnc is not supported by vz v3.0.0, it cannot read the display of the virtual machine nor inject input events")
+	}
+
+	if err := vmConfig.AddGVProxyFromCmdLine(opts.GVProxy); err != nil {
+		return nil, err
+	}
+
+	if err := vmConfig.AddPlatformFromCmdLine(opts.Platform); err != nil {
+		return nil, err
+	}
+
+	if err := vmConfig.SetGuestOSFromCmdLine(opts.GuestOS); err != nil {
+		return nil, err
+	}
+
+	namingTemplate, err := naming.NewTemplate(opts.NamingTemplate, opts.StateDir, opts.Name)
+	if err != nil {
+		return nil, err
+	}
+	if err := vmConfig.GenerateArtifactPaths(namingTemplate); err != nil {
+		return nil, err
+	}
+	if opts.DryRun {
+		if err := vmConfig.CheckDiskImages(); err != nil {
+			return nil, err
+		}
+	} else if err := vmConfig.ConvertDiskImages(namingTemplate); err != nil {
+		return nil, err
+	}
+	if err := vmConfig.CheckArchitecture(); err != nil {
+		return nil, err
+	}
+	if err := vmConfig.CheckGuestOS(); err != nil {
+		return nil, err
+	}
+
 	return vmConfig, nil
 }
 
-func waitForVMState(vm *vz.VirtualMachine, state vz.VirtualMachineState) error {
-	signalCh := make(chan os.Signal, 1)
-	signal.Notify(signalCh, syscall.SIGPIPE)
+func waitForVMState(machine *vmstate.StateMachine, state vmstate.State) error {
+	changes, unsubscribe := machine.Subscribe()
+	defer unsubscribe()
 
+	if machine.State() == state {
+		return nil
+	}
 	for {
 		select {
-		case s := <-signalCh:
-			log.Debugf("ignoring signal %v", s)
-		case newState := <-vm.StateChangedNotify():
-			if newState == state {
+		case change := <-changes:
+			if change.To == state {
 				return nil
 			}
-			if newState == vz.VirtualMachineStateError {
+			if change.To == vmstate.StateError {
 				return fmt.Errorf("hypervisor virtualization error")
 			}
 		case <-time.After(5 * time.Second):
@@ -107,58 +216,353 @@ func waitForVMState(vm *vz.VirtualMachine, state vz.VirtualMachineState) error {
 	}
 }
 
-func runVirtualMachine(vmConfig *config.VirtualMachine) error {
+// ignoreBrokenPipes keeps vfkit, and thus the virtual machine, running when
+// the process supervising it exits or is restarted, for example while it's
+// being upgraded. The standard output and error of vfkit are often pipes to
+// the supervisor, writing to them once it's gone raises SIGPIPE, which
+// would kill vfkit. Writes to closed pipes fail with EPIPE instead.
+func ignoreBrokenPipes() {
+	signal.Ignore(syscall.SIGPIPE)
+}
+
+// signalRunner returns the runner of the actions mapped to signals with
+// --signal. For the shutdown and stop actions, terminate is called so that
+// vfkit exits even if the virtual machine was already stopped by its
+// schedule. The guest is given a chance to shut down cleanly before the
+// virtual machine is forcefully stopped.
+func signalRunner(vm *vf.VirtualMachine, terminate func()) signals.Runner {
+	return func(action signals.Action) error {
+		switch action {
+		case signals.ActionShutdown, signals.ActionStop:
+			terminate()
+			if vm.StateMachine().State() == vmstate.StateStopped {
+				return nil
+			}
+			if action == signals.ActionStop {
+				return vm.Stop()
+			}
+			go func() {
+				if err := vm.Shutdown(); err != nil {
+					log.Errorf("failed to stop virtual machine: %v", err)
+					os.Exit(1)
+				}
+			}()
+			return nil
+		case signals.ActionPause:
+			return vm.Pause()
+		case signals.ActionResume:
+			return vm.Resume()
+		case signals.ActionTogglePause:
+			if vm.StateMachine().State() == vmstate.StatePaused {
+				return vm.Resume()
+			}
+			return vm.Pause()
+		default:
+			return fmt.Errorf("unexpected signal action '%s'", action)
+		}
+	}
+}
+
+func newScheduler(vm *vf.VirtualMachine, scheduleOpts []string) (*schedule.Scheduler, error) {
+	entries := []*schedule.Entry{}
+	for _, str := range scheduleOpts {
+		entry, err := schedule.ParseEntry(str)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return schedule.NewScheduler(entries, func(action schedule.Action) error {
+		switch action {
+		case schedule.ActionStart:
+			if vm.StateMachine().State() != vmstate.StateStopped {
+				return nil
+			}
+			return vm.Start()
+		case schedule.ActionStop:
+			if vm.StateMachine().State() == vmstate.StateStopped {
+				return nil
+			}
+			return vm.Shutdown()
+		}
+		return fmt.Errorf("unknown schedule action '%s'", action)
+	}), nil
+}
+
+func runVirtualMachine(vmConfig *config.VirtualMachine, opts *cmdline.Options, j *journal.Journal) error {
+	ignoreBrokenPipes()
+
+	var soak *config.Soak
+	if opts.Soak != "" {
+		var err error
+		if soak, err = config.SoakFromCmdLine(opts.Soak); err != nil {
+			return err
+		}
+	}
+
+	stopGVProxy, err := startGVProxy(vmConfig.GVProxy())
+	if err != nil {
+		return err
+	}
+	defer stopGVProxy()
+
+	removeRAMDisks, err := vmConfig.CreateRAMDisks()
+	if err != nil {
+		return err
+	}
+	defer removeRAMDisks()
+
 	vzVMConfig, err := vmConfig.ToVzVirtualMachineConfig()
 	if err != nil {
 		return err
 	}
 
-	vm, err := vz.NewVirtualMachine(vzVMConfig)
+	vzVM, err := vz.NewVirtualMachine(vzVMConfig)
 	if err != nil {
 		return err
 	}
+	vm := vf.NewVirtualMachine(vzVM)
+	vm.ShutdownTimeout = opts.ShutdownTimeout
+	if opts.Name != "" {
+		vm.Logger = log.WithField("vm", opts.Name)
+	}
+	stateMachine := vm.StateMachine()
+	if j != nil {
+		defer recordStateChanges(j, stateMachine)()
+	}
 
-	err = vm.Start()
+	eventLog, err := newEventLog(opts)
 	if err != nil {
 		return err
 	}
+	defer eventLog.Close()
+	vm.Events = eventLog
+	defer recordLifecycleEvents(eventLog, stateMachine)()
+	recordDeviceEvents(eventLog, vmConfig)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var restart *config.Restart
+	if opts.Restart != "" {
+		if restart, err = config.RestartFromCmdLine(opts.Restart); err != nil {
+			return err
+		}
+	}
+
+	var watchdog *config.Watchdog
+	if opts.Watchdog != "" {
+		if watchdog, err = config.WatchdogFromCmdLine(opts.Watchdog); err != nil {
+			return err
+		}
+	}
+
+	var priority pressure.Priority
+	if opts.Priority != "" {
+		if priority, err = pressure.ParsePriority(opts.Priority); err != nil {
+			return err
+		}
+	}
+
+	if err := applyProcessPolicy(opts); err != nil {
+		return err
+	}
 
-	err = waitForVMState(vm, vz.VirtualMachineStateRunning)
+	signalMapping, err := signals.ParseMapping(opts.Signals)
 	if err != nil {
 		return err
 	}
-	log.Infof("virtual machine is running")
 
-	for _, vsock := range vmConfig.VirtioVsockDevices() {
-		port := vsock.Port
-		socketURL := vsock.SocketURL
-		if socketURL == "" {
-			// the timesync code adds a vsock device without an associated URL.
-			continue
+	var scheduler *schedule.Scheduler
+	if len(opts.Schedule) != 0 {
+		if scheduler, err = newScheduler(vm, opts.Schedule); err != nil {
+			return err
+		}
+	}
+
+	forwarder := vf.NewVsockForwarder(vm.VirtualMachine)
+	shares := []define.Share{}
+	for _, share := range vmConfig.Shares() {
+		shares = append(shares, define.Share(share))
+	}
+
+	egressEnforcer, removeEgressRules, err := setupEgressRules(ctx, vmConfig)
+	if err != nil {
+		return err
+	}
+	defer removeEgressRules()
+
+	stopCacheProxy, err := startCacheProxy(vmConfig.CacheProxy())
+	if err != nil {
+		return err
+	}
+	defer stopCacheProxy()
+
+	stopConsoles, err := startConsoles(vmConfig)
+	if err != nil {
+		return err
+	}
+	defer stopConsoles()
+
+	if opts.RestfulURI != "" {
+		server, err := rest.NewServer(opts.RestfulURI, vm)
+		if err != nil {
+			return err
+		}
+		defer server.Close()
+		server.SetVsockForwarder(forwarder)
+		server.SetShareManager(vf.NewShareManager(shares))
+		server.SetGuestFileManager(vf.NewGuestFiles(vm))
+		server.SetGuestExecutor(vf.NewGuestExecutor(vm))
+		diskManager := vf.NewDiskManager(vmConfig.DiskImagePaths())
+		server.SetDiskManager(diskManager)
+		server.SetSnapshotManager(vf.NewSnapshotManager(vm, diskManager.Disks()))
+		server.SetResources(define.Resources{CPUs: vmConfig.Vcpus(), MemoryBytes: vmConfig.MemoryBytes()})
+		server.SetConfig(vmConfig.Inspect())
+		server.SetEventLog(eventLog)
+		if logPaths := vmConfig.SerialLogPaths(); len(logPaths) != 0 {
+			server.SetConsoleLog(logPaths[0])
+		}
+		if j != nil {
+			server.SetJournal(j)
+		}
+		if macs := vmConfig.MACAddresses(); len(macs) != 0 {
+			lookup, err := guestIPLookup(vmConfig)
+			if err != nil {
+				return err
+			}
+			server.SetGuestIPLookup(macs[0], lookup)
 		}
-		var listenStr string
-		if vsock.Listen {
-			listenStr = " (listening)"
+		if scheduler != nil {
+			server.SetScheduler(scheduler)
 		}
-		log.Infof("Exposing vsock port %d on %s%s", port, socketURL, listenStr)
-		if err := vf.ExposeVsock(vm, port, socketURL, vsock.Listen); err != nil {
-			log.Warnf("error exposing vsock port %d: %v", port, err)
+		if egressEnforcer != nil {
+			server.SetEgressController(egressEnforcer)
 		}
+		server.Start()
 	}
 
-	if err := setupGuestTimeSync(vm, vmConfig.TimeSync()); err != nil {
+	stopSignals := signalMapping.Handle(signalRunner(vm, cancel))
+	defer stopSignals()
+
+	err = vm.Start()
+	if err != nil {
+		return err
+	}
+
+	err = waitForVMState(stateMachine, vmstate.StateRunning)
+	if err != nil {
+		return err
+	}
+	log.Infof("virtual machine is running")
+
+	stopNetboot, err := startNetboot(vmConfig.Netboot())
+	if err != nil {
+		return err
+	}
+	defer stopNetboot()
+
+	for _, forward := range vmConfig.VsockForwards() {
+		if err := forwarder.AddForward(define.VsockForward(forward)); err != nil {
+			log.Warnf("%v", err)
+		}
+	}
+
+	if err := publishPorts(vm, vmConfig); err != nil {
+		return err
+	}
+
+	setupShareNotifications(ctx, vm.VirtualMachine, vmConfig)
+
+	if swap := vmConfig.Swap(); swap != nil {
+		go provisionSwap(ctx, vm.VirtualMachine, swap)
+	}
+
+	if cacheProxy := vmConfig.CacheProxy(); cacheProxy != nil {
+		go configureGuestProxy(ctx, vm.VirtualMachine, cacheProxy)
+	}
+
+	if err := setupGuestTimeSync(vm.VirtualMachine, vmConfig.TimeSync()); err != nil {
 		log.Warnf("Error configuring guest time synchronization")
 		log.Debugf("%v", err)
 	}
 
+	soakErrCh := make(chan error, 1)
+	if soak != nil {
+		go func() {
+			soakErrCh <- runSoakTest(vm, vmConfig, soak)
+		}()
+	} else {
+		soakErrCh <- nil
+	}
+
+	if scheduler != nil {
+		go scheduler.Run(ctx)
+	}
+	if priority != "" {
+		monitor := pressure.NewMonitor(priority, pressure.Actions{
+			Pause:  vm.Pause,
+			Resume: vm.Resume,
+			Running: func() bool {
+				return stateMachine.State() == vmstate.StateRunning
+			},
+		})
+		go monitor.Run(ctx)
+	}
+	if opts.CPULimit != 0 {
+		limiter := throttle.NewLimiter(opts.CPULimit, throttle.Actions{
+			Pause:  vm.Pause,
+			Resume: vm.Resume,
+		})
+		go limiter.Run(ctx)
+	}
+
+	watchdogRestartCh := make(chan struct{}, 1)
+	if watchdog != nil {
+		log.Infof("watchdog enabled, '%s' action after %s without heartbeat", watchdog.Action(), watchdog.Timeout())
+		go runWatchdog(ctx, vm, watchdog, watchdogRestartCh)
+	}
+
+	var crashLoop *vmstate.CrashLoopDetector
+	if restart != nil {
+		crashLoop = vmstate.NewCrashLoopDetector(restart.MaxRetries(), restart.Window())
+	}
+
 	log.Infof("waiting for VM to stop")
 	for {
-		err := waitForVMState(vm, vz.VirtualMachineStateStopped)
-		if err == nil {
-			log.Infof("VM is stopped")
+		state := waitForVMStop(stateMachine)
+		log.Infof("VM is %s", state)
+		if scheduler != nil && state == vmstate.StateStopped && scheduler.LastAction() == schedule.ActionStop {
+			// keep running until the next scheduled start
+			if action, at, ok := scheduler.Next(); ok {
+				log.Infof("VM was stopped by its schedule, next %s at %s", action, at.Format(time.RFC1123))
+			}
+			if _, err := stateMachine.WaitForState(ctx, vmstate.StateStarting, vmstate.StateRunning); err != nil {
+				break
+			}
+			continue
+		}
+		select {
+		case <-watchdogRestartCh:
+			if ctx.Err() == nil {
+				log.Infof("restarting virtual machine stopped by the watchdog")
+				if err := vm.Start(); err != nil {
+					// the virtual machine is now in the error state,
+					// the restart policy applies on the next iteration
+					log.Warnf("failed to restart virtual machine: %v", err)
+				}
+				continue
+			}
+		default:
+		}
+		if restart == nil || ctx.Err() != nil || !restart.ShouldRestart(state == vmstate.StateError, vm.StopRequested()) {
 			break
 		}
+		if err := restartVirtualMachine(ctx, vm, vmConfig, restart, crashLoop); err != nil {
+			return err
+		}
 	}
 
-	return nil
+	return <-soakErrCh
 }
@@ -0,0 +1,50 @@
+//go:build darwin
+// +build darwin
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/crc-org/vfkit/pkg/cmdline"
+	"github.com/crc-org/vfkit/pkg/config"
+	"github.com/spf13/cobra"
+)
+
+func TestConfigFileFlagPrecedence(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "vm.json")
+	cfg := `{"cpus": 2, "memory": "2GiB", "bootloader": {"type": "efi", "variable-store": "/tmp/efistore"}}`
+	if err := os.WriteFile(configPath, []byte(cfg), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	parse := func(args ...string) *cmdline.Options {
+		opts := cmdline.Options{}
+		cmd := &cobra.Command{}
+		cmdline.AddFlags(cmd, &opts)
+		if err := cmd.Flags().Parse(append([]string{"--config", configPath}, args...)); err != nil {
+			t.Fatal("expected no error; got", err)
+		}
+		return &opts
+	}
+
+	// the file content is used when no flag is set
+	vmConfig, err := newVMConfigurationFromFile(parse())
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if _, isEFI := vmConfig.Bootloader().(*config.EFIBootloader); !isEFI || vmConfig.Vcpus() != 2 || vmConfig.MemoryBytes() != 2*1024*1024*1024 {
+		t.Fatalf("unexpected configuration: %d vCPUs, %d bytes, %+v", vmConfig.Vcpus(), vmConfig.MemoryBytes(), vmConfig.Bootloader())
+	}
+
+	// explicit flags take precedence over the file
+	vmConfig, err = newVMConfigurationFromFile(parse("--cpus", "4", "--memory", "4GiB", "--bootloader", "linux,kernel=/tmp/vmlinuz,initrd=/tmp/initrd,cmdline=quiet"))
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if _, isLinux := vmConfig.Bootloader().(*config.LinuxBootloader); !isLinux || vmConfig.Vcpus() != 4 || vmConfig.MemoryBytes() != 4*1024*1024*1024 {
+		t.Fatalf("unexpected configuration: %d vCPUs, %d bytes, %+v", vmConfig.Vcpus(), vmConfig.MemoryBytes(), vmConfig.Bootloader())
+	}
+}
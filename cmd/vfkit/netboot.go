@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/crc-org/vfkit/pkg/config"
+	"github.com/crc-org/vfkit/pkg/dhcp"
+	"github.com/crc-org/vfkit/pkg/netboot"
+)
+
+// netbootListenTimeout is how long to wait for the host address on the NAT
+// network, which is only configured once a virtual machine using NAT is
+// running.
+const netbootListenTimeout = 10 * time.Second
+
+// startNetboot starts the TFTP and HTTP services configured with the
+// 'netboot' option of the EFI bootloader, it does nothing if there are none.
+// It must be called once the virtual machine is running. The returned
+// function stops the services.
+func startNetboot(netbootConfig *config.Netboot) (func(), error) {
+	if netbootConfig == nil {
+		return func() {}, nil
+	}
+	hostIP, err := dhcp.NATHostAddress()
+	if err != nil {
+		return nil, fmt.Errorf("cannot start netboot services: %w", err)
+	}
+	server, err := netboot.NewServer(netbootConfig.Dir())
+	if err != nil {
+		return nil, fmt.Errorf("cannot start netboot services: %w", err)
+	}
+
+	listen := func(port uint16, listenFunc func(string) error) error {
+		if port == 0 {
+			return nil
+		}
+		addr := net.JoinHostPort(hostIP.String(), strconv.Itoa(int(port)))
+		deadline := time.Now().Add(netbootListenTimeout)
+		for {
+			err := listenFunc(addr)
+			if err == nil || !errors.Is(err, syscall.EADDRNOTAVAIL) || time.Now().After(deadline) {
+				return err
+			}
+			time.Sleep(500 * time.Millisecond)
+		}
+	}
+	if err := listen(netbootConfig.TFTPPort(), server.ListenTFTP); err != nil {
+		server.Close()
+		return nil, fmt.Errorf("cannot start netboot TFTP service: %w", err)
+	}
+	if err := listen(netbootConfig.HTTPPort(), server.ListenHTTP); err != nil {
+		server.Close()
+		return nil, fmt.Errorf("cannot start netboot HTTP service: %w", err)
+	}
+
+	return func() {
+		server.Close()
+	}, nil
+}
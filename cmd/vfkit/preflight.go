@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"github.com/crc-org/vfkit/pkg/client"
+	"github.com/crc-org/vfkit/pkg/output"
+)
+
+// runPreflight prints the result of the preflight checks of this vfkit
+// binary, and fails when one of them failed.
+func runPreflight(out io.Writer) error {
+	vfkitPath, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	report := client.PreflightWithOptions(client.PreflightOptions{VfkitPath: vfkitPath})
+
+	table := output.NewTable("CHECK", "STATUS", "MESSAGE")
+	table.ColumnColor = func(column int, value string) output.Color {
+		switch {
+		case column != 1:
+			return output.NoColor
+		case value == "ok":
+			return output.Green
+		default:
+			return output.Red
+		}
+	}
+	for _, check := range report.Checks {
+		status := "ok"
+		if !check.Passed {
+			status = "failed"
+		}
+		message := check.Message
+		if check.Hint != "" {
+			message += " (" + check.Hint + ")"
+		}
+		table.AddRow(check.Name, status, message)
+	}
+	if err := table.Write(out, output.ColorEnabled(out)); err != nil {
+		return err
+	}
+	if !report.Passed() {
+		return errors.New("preflight checks failed")
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/Code-Hex/vz/v3"
+	"github.com/crc-org/vfkit/pkg/config"
+	"github.com/crc-org/vfkit/pkg/proxy"
+	log "github.com/sirupsen/logrus"
+)
+
+// startCacheProxy starts the caching proxy configured with --cache-proxy, it
+// does nothing if there is none. The returned function stops the proxy.
+func startCacheProxy(proxyConfig *config.CacheProxy) (func(), error) {
+	if proxyConfig == nil {
+		return func() {}, nil
+	}
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", proxyConfig.Port()))
+	if errors.Is(err, syscall.EADDRINUSE) {
+		// virtual machines started with the same options share the proxy
+		// of the first one
+		log.Infof("port %d is in use, assuming it is the caching proxy of another vfkit instance", proxyConfig.Port())
+		return func() {}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot start caching proxy: %w", err)
+	}
+	cache, err := proxy.NewCache(proxyConfig.CacheDir(), int64(proxyConfig.MaxSizeBytes()))
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("cannot start caching proxy: %w", err)
+	}
+	cachingProxy := proxy.New(cache, []*net.IPNet{proxyConfig.Allow()})
+	go func() {
+		if err := cachingProxy.Serve(listener); err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Warnf("caching proxy stopped: %v", err)
+		}
+	}()
+	log.Infof("caching proxy listening on port %d, cache in %s", proxyConfig.Port(), proxyConfig.CacheDir())
+
+	return func() {
+		stats := cachingProxy.Stats()
+		log.Debugf("caching proxy: %d hits, %d misses", stats.Hits, stats.Misses)
+		listener.Close()
+	}, nil
+}
+
+// configureGuestProxy configures the guest to use the caching proxy once the
+// guest agent is reachable.
+func configureGuestProxy(ctx context.Context, vm *vz.VirtualMachine, proxyConfig *config.CacheProxy) {
+	client, err := waitForAgent(ctx, vm, proxyConfig.AgentPort())
+	if err != nil {
+		log.Warnf("cannot configure guest proxy, guest agent unreachable: %v", err)
+		return
+	}
+	defer client.Close()
+
+	log.Infof("Configuring guest to use the caching proxy")
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	if err := client.ConfigureProxy(ctx, proxyConfig.Port()); err != nil {
+		log.Warnf("%v", err)
+	}
+}
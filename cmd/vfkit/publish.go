@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/crc-org/vfkit/pkg/config"
+	"github.com/crc-org/vfkit/pkg/dhcp"
+	"github.com/crc-org/vfkit/pkg/vf"
+	log "github.com/sirupsen/logrus"
+)
+
+// guestIPLookup returns a function looking up the guest IP address on the NAT
+// network in the DHCP leases of the host.
+func guestIPLookup(vmConfig *config.VirtualMachine) (func() (net.IP, error), error) {
+	macs := vmConfig.MACAddresses()
+	if len(macs) == 0 {
+		return nil, fmt.Errorf("publishing ports over NAT needs a virtio-net device with a 'mac' option")
+	}
+	mac := macs[0]
+
+	return func() (net.IP, error) {
+		lease, err := dhcp.FindLease(dhcp.DefaultLeasesPath, mac)
+		if err != nil {
+			return nil, err
+		}
+		return lease.IPAddress, nil
+	}, nil
+}
+
+// publishPorts forwards the host ports set with --publish to the guest.
+func publishPorts(vm *vf.VirtualMachine, vmConfig *config.VirtualMachine) error {
+	var guestIP func() (net.IP, error)
+	for _, pf := range vmConfig.PortForwards() {
+		log.Infof("Publishing %s", pf)
+		switch pf.Transport() {
+		case config.PortForwardVsock:
+			if _, err := vf.PublishVsockPort(vm.VirtualMachine, pf.HostAddress(), pf.GuestPort()); err != nil {
+				return fmt.Errorf("failed to publish %s: %w", pf.HostAddress(), err)
+			}
+		default:
+			if guestIP == nil {
+				var err error
+				if guestIP, err = guestIPLookup(vmConfig); err != nil {
+					return err
+				}
+			}
+			if _, err := vf.PublishTCPPort(pf.HostAddress(), guestIP, pf.GuestPort()); err != nil {
+				return fmt.Errorf("failed to publish %s: %w", pf.HostAddress(), err)
+			}
+		}
+	}
+
+	return nil
+}
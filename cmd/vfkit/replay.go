@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/crc-org/vfkit/pkg/cmdline"
+	"github.com/crc-org/vfkit/pkg/journal"
+	"github.com/crc-org/vfkit/pkg/naming"
+	"github.com/crc-org/vfkit/pkg/output"
+	vmstate "github.com/crc-org/vfkit/pkg/vm"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var replayOpts struct {
+	name           string
+	stateDir       string
+	namingTemplate string
+	output         string
+}
+
+var replayCmd = &cobra.Command{
+	Use:   "replay [journal]",
+	Short: "print the journal of a virtual machine as a timeline",
+	Long: `Print the REST API calls and lifecycle events recorded when vfkit runs with --journal.
+The journal of the virtual machine named with --name is used when no path is given.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		printer, err := output.NewPrinter(cmd.OutOrStdout(), replayOpts.output)
+		if err != nil {
+			return err
+		}
+		path := ""
+		if len(args) == 1 {
+			path = args[0]
+		} else {
+			path, err = journalPath(replayOpts.namingTemplate, replayOpts.stateDir, replayOpts.name)
+			if err != nil {
+				return err
+			}
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		entries, err := journal.ReadEntries(file)
+		if err != nil {
+			return err
+		}
+		return printer.Print(entries, func() (*output.Table, error) {
+			return journal.Timeline(entries)
+		})
+	},
+}
+
+func init() {
+	replayCmd.Flags().StringVar(&replayOpts.name, "name", "", "name of the virtual machine (default \"default\")")
+	replayCmd.Flags().StringVar(&replayOpts.stateDir, "state-dir", "", "directory where generated host artifacts are stored (default \"$HOME/.vfkit\")")
+	replayCmd.Flags().StringVar(&replayOpts.namingTemplate, "naming-template", "", "template for the paths of generated host artifacts (default \"{statedir}/{vm}/{device-id}.{ext}\")")
+	output.AddFlag(replayCmd, &replayOpts.output)
+	rootCmd.AddCommand(replayCmd)
+}
+
+// journalPath returns the path of the journal of the virtual machine, it's
+// generated like the other host artifacts.
+func journalPath(template, stateDir, vmName string) (string, error) {
+	namingTemplate, err := naming.NewTemplate(template, stateDir, vmName)
+	if err != nil {
+		return "", err
+	}
+	return namingTemplate.Path("journal", "jsonl"), nil
+}
+
+// stateChangeDetails is the journal entry details of a state change.
+type stateChangeDetails struct {
+	From    vmstate.State `json:"from"`
+	Message string        `json:"message,omitempty"`
+}
+
+// withJournal calls run with the journal of the virtual machine when
+// --journal is used, or with nil. The start and exit of vfkit are recorded.
+func withJournal(opts *cmdline.Options, run func(j *journal.Journal) error) error {
+	if !opts.Journal {
+		return run(nil)
+	}
+	path, err := journalPath(opts.NamingTemplate, opts.StateDir, opts.Name)
+	if err != nil {
+		return err
+	}
+	j, err := journal.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open journal: %w", err)
+	}
+	defer j.Close()
+	log.Debugf("recording journal in %s", path)
+
+	startDetails := map[string]interface{}{
+		"version": vfkitVersion,
+		"args":    os.Args[1:],
+	}
+	if err := j.Record(journal.KindLifecycle, "vfkit started", startDetails); err != nil {
+		log.Warnf("failed to record vfkit start in journal: %v", err)
+	}
+	runErr := run(j)
+	var exitDetails interface{}
+	if runErr != nil {
+		exitDetails = map[string]string{"error": runErr.Error()}
+	}
+	if err := j.Record(journal.KindLifecycle, "vfkit exited", exitDetails); err != nil {
+		log.Warnf("failed to record vfkit exit in journal: %v", err)
+	}
+
+	return runErr
+}
+
+// recordStateChanges appends the state changes of machine to j until the
+// returned function is called.
+func recordStateChanges(j *journal.Journal, machine *vmstate.StateMachine) func() {
+	changes, unsubscribe := machine.Subscribe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for change := range changes {
+			err := j.Append(journal.Entry{
+				Time:    change.Time,
+				Kind:    journal.KindLifecycle,
+				Action:  fmt.Sprintf("state %s", change.To),
+				Details: stateChangeDetails{From: change.From, Message: change.Message},
+			})
+			if err != nil {
+				log.Warnf("failed to record state change in journal: %v", err)
+			}
+		}
+	}()
+
+	return func() {
+		unsubscribe()
+		<-done
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/crc-org/vfkit/pkg/config"
+	"github.com/crc-org/vfkit/pkg/vf"
+	vmstate "github.com/crc-org/vfkit/pkg/vm"
+	log "github.com/sirupsen/logrus"
+)
+
+// consoleExcerptSize is the maximum size of the console output attached to
+// the crash loop event.
+const consoleExcerptSize = 4096
+
+// consoleExcerpt returns the end of the log file of the first virtio-serial
+// device, or an empty string if there is none.
+func consoleExcerpt(vmConfig *config.VirtualMachine) string {
+	paths := vmConfig.SerialLogPaths()
+	if len(paths) == 0 {
+		return ""
+	}
+	file, err := os.Open(paths[0])
+	if err != nil {
+		log.Debugf("failed to open console log: %v", err)
+		return ""
+	}
+	defer file.Close()
+
+	if info, err := file.Stat(); err == nil && info.Size() > consoleExcerptSize {
+		if _, err := file.Seek(-consoleExcerptSize, io.SeekEnd); err != nil {
+			return ""
+		}
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		log.Debugf("failed to read console log: %v", err)
+		return ""
+	}
+
+	return string(data)
+}
+
+// waitForVMStop waits until the virtual machine is stopped or has failed, and
+// returns its state.
+func waitForVMStop(machine *vmstate.StateMachine) vmstate.State {
+	for {
+		err := waitForVMState(machine, vmstate.StateStopped)
+		if err == nil {
+			return vmstate.StateStopped
+		}
+		if state := machine.State(); state == vmstate.StateError {
+			return state
+		}
+	}
+}
+
+// restartVirtualMachine restarts vm after the delay configured in restart.
+// If the virtual machine is in a crash loop, it's marked as failed with the
+// end of its console output, and an error is returned.
+func restartVirtualMachine(ctx context.Context, vm *vf.VirtualMachine, vmConfig *config.VirtualMachine, restart *config.Restart, crashLoop *vmstate.CrashLoopDetector) error {
+	if crashLoop.RecordFailure(time.Now()) {
+		msg := fmt.Sprintf("virtual machine stopped more than %d times in %s", restart.MaxRetries(), restart.Window())
+		if excerpt := consoleExcerpt(vmConfig); excerpt != "" {
+			msg = fmt.Sprintf("%s, last console output:\n%s", msg, excerpt)
+		}
+		if err := vm.StateMachine().SetStateWithMessage(vmstate.StateFailed, msg); err != nil {
+			log.Debugf("%v", err)
+		}
+		return fmt.Errorf("virtual machine is in a crash loop, not restarting it")
+	}
+
+	log.Infof("restarting virtual machine in %s", restart.Delay())
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-time.After(restart.Delay()):
+	}
+	if err := vm.Start(); err != nil {
+		// the virtual machine is now in the error state, the next
+		// iteration of the main loop will try again
+		log.Warnf("failed to restart virtual machine: %v", err)
+	}
+
+	return nil
+}
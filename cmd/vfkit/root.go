@@ -5,10 +5,13 @@ import (
 	"os"
 
 	"github.com/crc-org/vfkit/pkg/cmdline"
+	"github.com/crc-org/vfkit/pkg/journal"
+	"github.com/crc-org/vfkit/pkg/logging"
+	"github.com/crc-org/vfkit/pkg/util"
 	"github.com/spf13/cobra"
 )
 
-const vfkitVersion = "0.0.4"
+const vfkitVersion = "0.1.0"
 
 var opts = &cmdline.Options{}
 
@@ -18,11 +21,51 @@ var rootCmd = &cobra.Command{
 	Long: `A hypervisor written in Go using Apple's virtualization framework to run linux virtual machines.
                 Complete documentation is available at https://github.com/crc-org/vfkit`,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := opts.SetFromEnv(os.LookupEnv); err != nil {
+			return err
+		}
+		closeLog, err := logging.Setup(logging.Options{
+			Level:  opts.LogLevel,
+			Format: opts.LogFormat,
+			File:   opts.LogFile,
+		})
+		if err != nil {
+			return err
+		}
+		defer closeLog()
+
+		if opts.Preflight {
+			return runPreflight(cmd.OutOrStdout())
+		}
 		vmConfig, err := newVMConfiguration(opts)
 		if err != nil {
 			return err
 		}
-		return runVirtualMachine(vmConfig)
+		labels, err := util.ParseLabels(opts.Labels)
+		if err != nil {
+			return err
+		}
+		if opts.DryRun {
+			return printConfig(cmd.OutOrStdout(), vmConfig)
+		}
+		// configuration errors are reported before going to the background
+		if daemonized, err := daemonize(opts); daemonized || err != nil {
+			return err
+		}
+		removePIDFile, err := writePIDFile(opts)
+		if err != nil {
+			return err
+		}
+		defer removePIDFile()
+		removeInstanceRecord, err := writeInstanceRecord(opts, vmConfig, labels)
+		if err != nil {
+			return err
+		}
+		defer removeInstanceRecord()
+
+		return withJournal(opts, func(j *journal.Journal) error {
+			return runVirtualMachine(vmConfig, opts, j)
+		})
 	},
 	Version: vfkitVersion,
 }
@@ -37,6 +80,12 @@ func init() {
 }
 
 func Execute() {
+	args, err := cmdline.ExpandArgsFiles(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	rootCmd.SetArgs(args)
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
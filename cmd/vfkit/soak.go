@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/crc-org/vfkit/pkg/config"
+	"github.com/crc-org/vfkit/pkg/dhcp"
+	"github.com/crc-org/vfkit/pkg/vf"
+	vmstate "github.com/crc-org/vfkit/pkg/vm"
+	log "github.com/sirupsen/logrus"
+)
+
+type soakCheck struct {
+	name string
+	run  func() error
+}
+
+type soakResult struct {
+	Time  time.Time `json:"time"`
+	Check string    `json:"check"`
+	Error string    `json:"error,omitempty"`
+}
+
+func vsockSoakChecks(vm *vf.VirtualMachine, vmConfig *config.VirtualMachine) []soakCheck {
+	checks := []soakCheck{}
+	for _, vsock := range vmConfig.VsockForwards() {
+		vsock := vsock
+		if vsock.Listen {
+			// the guest initiates these connections, the best we can do is to
+			// check the host side is still there
+			checks = append(checks, soakCheck{
+				name: fmt.Sprintf("vsock socket %s", vsock.SocketURL),
+				run: func() error {
+					_, err := os.Stat(vsock.SocketURL)
+					return err
+				},
+			})
+			continue
+		}
+		checks = append(checks, soakCheck{
+			name: fmt.Sprintf("vsock port %d", vsock.Port),
+			run: func() error {
+				conn, err := vf.ConnectVsockSync(vm.VirtualMachine, vsock.Port)
+				if err != nil {
+					return err
+				}
+				return conn.Close()
+			},
+		})
+	}
+
+	return checks
+}
+
+func diskSoakChecks(vmConfig *config.VirtualMachine) []soakCheck {
+	checks := []soakCheck{}
+	for _, imagePath := range vmConfig.DiskImagePaths() {
+		imagePath := imagePath
+		checks = append(checks, soakCheck{
+			name: fmt.Sprintf("disk image %s", imagePath),
+			run: func() error {
+				file, err := os.Open(imagePath)
+				if err != nil {
+					return err
+				}
+				defer file.Close()
+				buf := make([]byte, 512)
+				_, err = file.ReadAt(buf, 0)
+				return err
+			},
+		})
+	}
+
+	return checks
+}
+
+func networkSoakChecks(vmConfig *config.VirtualMachine) []soakCheck {
+	checks := []soakCheck{}
+	for _, mac := range vmConfig.MACAddresses() {
+		mac := mac
+		checks = append(checks, soakCheck{
+			name: fmt.Sprintf("network interface %s", mac),
+			run: func() error {
+				lease, err := dhcp.FindLease(dhcp.DefaultLeasesPath, mac)
+				if err != nil {
+					return err
+				}
+				out, err := exec.Command("ping", "-c", "1", "-t", "5", lease.IPAddress.String()).CombinedOutput()
+				if err != nil {
+					return fmt.Errorf("failed to ping %s: %v: %s", lease.IPAddress, err, out)
+				}
+				return nil
+			},
+		})
+	}
+
+	return checks
+}
+
+func runSoakChecks(checks []soakCheck, report io.Writer) int {
+	failures := 0
+	encoder := json.NewEncoder(report)
+	for _, check := range checks {
+		result := soakResult{
+			Time:  time.Now(),
+			Check: check.name,
+		}
+		if err := check.run(); err != nil {
+			failures++
+			result.Error = err.Error()
+			log.Warnf("soak: %s check failed: %v", check.name, err)
+		} else {
+			log.Debugf("soak: %s check succeeded", check.name)
+		}
+		if err := encoder.Encode(result); err != nil {
+			log.Debugf("soak: failed to record check result: %v", err)
+		}
+	}
+
+	return failures
+}
+
+// runSoakTest periodically checks the vsock, disk and network devices of the
+// virtual machine until soak.Duration() has elapsed, and then shuts it down.
+// It returns an error if any of the checks failed.
+func runSoakTest(vm *vf.VirtualMachine, vmConfig *config.VirtualMachine, soak *config.Soak) error {
+	var report io.Writer = io.Discard
+	if soak.LogFilePath() != "" {
+		file, err := os.OpenFile(soak.LogFilePath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		report = file
+	}
+
+	checks := []soakCheck{}
+	checks = append(checks, vsockSoakChecks(vm, vmConfig)...)
+	checks = append(checks, diskSoakChecks(vmConfig)...)
+	checks = append(checks, networkSoakChecks(vmConfig)...)
+	log.Infof("soak: running %d checks every %s for %s", len(checks), soak.Interval(), soak.Duration())
+
+	ticker := time.NewTicker(soak.Interval())
+	defer ticker.Stop()
+	deadline := time.After(soak.Duration())
+	changes, unsubscribe := vm.StateMachine().Subscribe()
+	defer unsubscribe()
+
+	rounds, failures := 0, 0
+	for done := false; !done; {
+		select {
+		case <-ticker.C:
+			rounds++
+			failures += runSoakChecks(checks, report)
+		case change := <-changes:
+			if change.To == vmstate.StateStopped || change.To == vmstate.StateError {
+				return fmt.Errorf("soak: virtual machine %s after %d rounds of checks", change.To, rounds)
+			}
+		case <-deadline:
+			done = true
+		}
+	}
+
+	log.Infof("soak: %d rounds of checks completed, %d failures", rounds, failures)
+	if err := vm.Shutdown(); err != nil {
+		return err
+	}
+	if failures != 0 {
+		return fmt.Errorf("soak: %d checks failed", failures)
+	}
+
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/crc-org/vfkit/pkg/client"
+	"github.com/crc-org/vfkit/pkg/output"
+	"github.com/spf13/cobra"
+)
+
+var statsOpts struct {
+	stateDir string
+	output   string
+}
+
+var statsCmd = &cobra.Command{
+	Use:   "stats name",
+	Short: "show the resource usage of a running virtual machine",
+	Long: `Show the CPU, memory and energy usage of the virtual machine started with --name.
+The virtual machine must have the REST API enabled with --restful-uri.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		printer, err := output.NewPrinter(cmd.OutOrStdout(), statsOpts.output)
+		if err != nil {
+			return err
+		}
+		instance, err := client.AdoptInstance(statsOpts.stateDir, args[0])
+		if err != nil {
+			return err
+		}
+		if instance.RestClient == nil {
+			return fmt.Errorf("virtual machine '%s' was started without --restful-uri", args[0])
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		stats, err := instance.RestClient.Stats(ctx)
+		if err != nil {
+			return err
+		}
+		return printer.Print(stats, nil)
+	},
+}
+
+func init() {
+	statsCmd.Flags().StringVar(&statsOpts.stateDir, "state-dir", "", "directory where generated host artifacts are stored (default \"$HOME/.vfkit\")")
+	output.AddFlag(statsCmd, &statsOpts.output)
+	rootCmd.AddCommand(statsCmd)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/Code-Hex/vz/v3"
+	"github.com/crc-org/vfkit/pkg/agent"
+	"github.com/crc-org/vfkit/pkg/config"
+	"github.com/crc-org/vfkit/pkg/vf"
+	log "github.com/sirupsen/logrus"
+)
+
+// agentWaitTimeout is how long vfkit waits for the guest agent to be
+// reachable after the virtual machine started.
+const agentWaitTimeout = 5 * time.Minute
+
+// waitForAgent connects to the guest agent on vsock port, retrying until the
+// guest agent is started or agentWaitTimeout is reached.
+func waitForAgent(ctx context.Context, vm *vz.VirtualMachine, port uint) (*agent.Client, error) {
+	ctx, cancel := context.WithTimeout(ctx, agentWaitTimeout)
+	defer cancel()
+	for {
+		conn, err := vf.ConnectVsockSync(vm, port)
+		if err == nil {
+			client := agent.NewClient(conn)
+			pingCtx, pingCancel := context.WithTimeout(ctx, 10*time.Second)
+			_, err = client.Ping(pingCtx)
+			pingCancel()
+			if err == nil {
+				return client, nil
+			}
+			_ = client.Close()
+		}
+		log.Debugf("guest agent not reachable on vsock port %d: %v", port, err)
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+// provisionSwap creates and enables the swapfile configured with --swap once
+// the guest agent is reachable.
+func provisionSwap(ctx context.Context, vm *vz.VirtualMachine, swap *config.Swap) {
+	client, err := waitForAgent(ctx, vm, swap.AgentPort())
+	if err != nil {
+		log.Warnf("cannot provision guest swapfile, guest agent unreachable: %v", err)
+		return
+	}
+	defer client.Close()
+
+	log.Infof("Provisioning guest swapfile %s", swap.Path())
+	// creating a large swapfile with dd can be slow
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+	defer cancel()
+	if err := client.ProvisionSwap(ctx, swap.Path(), swap.SizeBytes()); err != nil {
+		log.Warnf("%v", err)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/crc-org/vfkit/pkg/cmdline"
+	"github.com/crc-org/vfkit/pkg/throttle"
+	"github.com/crc-org/vfkit/pkg/vf"
+	log "github.com/sirupsen/logrus"
+)
+
+// applyProcessPolicy applies the --qos-class and --io-priority options to
+// the vfkit process.
+func applyProcessPolicy(opts *cmdline.Options) error {
+	if opts.QoSClass != "" {
+		qos, err := throttle.ParseQoSClass(opts.QoSClass)
+		if err != nil {
+			return err
+		}
+		if err := vf.SetQoSClass(qos); err != nil {
+			return fmt.Errorf("failed to set QoS class: %w", err)
+		}
+		log.Infof("QoS class set to %s", qos)
+	}
+	if opts.IOPriority != "" {
+		priority, err := throttle.ParseIOPriority(opts.IOPriority)
+		if err != nil {
+			return err
+		}
+		if err := vf.SetIOPriority(priority); err != nil {
+			return fmt.Errorf("failed to set I/O priority: %w", err)
+		}
+		log.Infof("I/O priority set to %s", priority)
+	}
+
+	return nil
+}
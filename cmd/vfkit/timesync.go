@@ -2,12 +2,16 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"encoding/json"
 	"fmt"
 	"net"
 	"time"
 
 	"github.com/Code-Hex/vz/v3"
+	"github.com/crc-org/vfkit/pkg/agent"
 	"github.com/crc-org/vfkit/pkg/config"
+	timesyncpkg "github.com/crc-org/vfkit/pkg/timesync"
 	"github.com/crc-org/vfkit/pkg/vf"
 	sleepnotifier "github.com/prashantgupta24/mac-sleep-notifier/notifier"
 	log "github.com/sirupsen/logrus"
@@ -34,13 +38,104 @@ func syncGuestTime(conn net.Conn) error {
 	return nil
 }
 
-func watchWakeupNotifications(vm *vz.VirtualMachine, vsockPort uint) {
+func syncGuestTimeWithAgent(conn net.Conn) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	log.Debugf("setting guest time with the vfkit guest agent")
+	return agent.NewClient(conn).SetTime(ctx, time.Now())
+}
+
+// getGuestTime returns the guest clock read with qemu-guest-agent.
+func getGuestTime(conn net.Conn) (time.Time, error) {
+	qemugaCmd := `{"execute": "guest-get-time"}` + "\n"
+
+	log.Debugf("sending %s to qemu-guest-agent", qemugaCmd)
+	if _, err := conn.Write([]byte(qemugaCmd)); err != nil {
+		return time.Time{}, err
+	}
+	response, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return time.Time{}, err
+	}
+	var result struct {
+		Return *int64 `json:"return"`
+	}
+	if err := json.Unmarshal([]byte(response), &result); err != nil || result.Return == nil {
+		return time.Time{}, fmt.Errorf("Unexpected response from qemu-guest-agent: %s", response)
+	}
+
+	return time.Unix(0, *result.Return), nil
+}
+
+func getGuestTimeWithAgent(conn net.Conn) (time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return agent.NewClient(conn).GetTime(ctx)
+}
+
+// correctGuestDrift sets the guest clock when it drifted from the host clock
+// by more than the tolerance of timesync.
+func correctGuestDrift(conn net.Conn, timesync *config.TimeSync, getTime func(net.Conn) (time.Time, error), syncTime func(net.Conn) error) error {
+	before := time.Now()
+	guestTime, err := getTime(conn)
+	if err != nil {
+		return err
+	}
+	drift := timesyncpkg.Drift(before, guestTime, time.Now())
+	options := timesync.Options()
+	if !options.NeedsCorrection(drift) {
+		log.Debugf("guest clock drift is %s", drift)
+		return nil
+	}
+	log.Infof("correcting guest clock drift of %s", drift)
+
+	return syncTime(conn)
+}
+
+func watchWakeupNotifications(vm *vz.VirtualMachine, timesync *config.TimeSync) {
+	vsockPort := timesync.VsockPort()
+	syncTime := syncGuestTime
+	getTime := getGuestTime
+	if timesync.UseAgent() {
+		syncTime = syncGuestTimeWithAgent
+		getTime = getGuestTimeWithAgent
+	}
+
 	var vsockConn net.Conn
 	defer func() {
 		if vsockConn != nil {
 			_ = vsockConn.Close()
 		}
 	}()
+	connect := func() error {
+		if vsockConn != nil {
+			return nil
+		}
+		var err error
+		vsockConn, err = vf.ConnectVsockSync(vm, vsockPort)
+		if err != nil {
+			return fmt.Errorf("error connecting to vsock port %d: %w", vsockPort, err)
+		}
+		return nil
+	}
+	// the connection is reopened after errors, the guest agent may have
+	// been restarted
+	closeOnError := func(err error) {
+		if err != nil && vsockConn != nil {
+			_ = vsockConn.Close()
+			vsockConn = nil
+		}
+	}
+
+	// a nil channel blocks forever, disabling the drift correction
+	var driftCh <-chan time.Time
+	if timesync.Interval() != 0 {
+		ticker := time.NewTicker(timesync.Interval())
+		defer ticker.Stop()
+		driftCh = ticker.C
+	}
 
 	sleepNotifierCh := sleepnotifier.GetInstance().Start()
 	for {
@@ -49,18 +144,26 @@ func watchWakeupNotifications(vm *vz.VirtualMachine, vsockPort uint) {
 			log.Debugf("Sleep notification: %s", activity)
 			if activity.Type == sleepnotifier.Awake {
 				log.Infof("machine awake")
-				if vsockConn == nil {
-					var err error
-					vsockConn, err = vf.ConnectVsockSync(vm, vsockPort)
-					if err != nil {
-						log.Debugf("error connecting to vsock port %d: %v", vsockPort, err)
-						break
-					}
+				if err := connect(); err != nil {
+					log.Debugf("%v", err)
+					break
 				}
-				if err := syncGuestTime(vsockConn); err != nil {
+				err := syncTime(vsockConn)
+				if err != nil {
 					log.Debugf("error syncing guest time: %v", err)
 				}
+				closeOnError(err)
+			}
+		case <-driftCh:
+			if err := connect(); err != nil {
+				log.Debugf("%v", err)
+				break
 			}
+			err := correctGuestDrift(vsockConn, timesync, getTime, syncTime)
+			if err != nil {
+				log.Debugf("error correcting guest clock drift: %v", err)
+			}
+			closeOnError(err)
 		}
 	}
 
@@ -71,9 +174,13 @@ func setupGuestTimeSync(vm *vz.VirtualMachine, timesync *config.TimeSync) error
 		return nil
 	}
 
-	log.Infof("Setting up host/guest time synchronization")
+	if timesync.Interval() != 0 {
+		log.Infof("Setting up host/guest time synchronization, checking the guest clock every %s", timesync.Interval())
+	} else {
+		log.Infof("Setting up host/guest time synchronization")
+	}
 
-	go watchWakeupNotifications(vm, timesync.VsockPort())
+	go watchWakeupNotifications(vm, timesync)
 
 	return nil
 }
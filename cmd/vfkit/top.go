@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/crc-org/vfkit/pkg/client"
+	"github.com/crc-org/vfkit/pkg/inventory"
+	"github.com/crc-org/vfkit/pkg/output"
+	"github.com/crc-org/vfkit/pkg/top"
+	"github.com/crc-org/vfkit/pkg/util/term"
+	"github.com/spf13/cobra"
+)
+
+var topOpts struct {
+	stateDir string
+	interval time.Duration
+}
+
+var topCmd = &cobra.Command{
+	Use:   "top",
+	Short: "show a live view of the running virtual machines",
+	Long: `Show the CPU, memory and disk usage of the virtual machines started with --name, refreshed periodically.
+The selected virtual machine can be paused, resumed, stopped, and its serial console followed. The resource usage
+and the key bindings need the REST API of the virtual machines, enabled with --restful-uri.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if topOpts.interval <= 0 {
+			return fmt.Errorf("invalid refresh interval: %s", topOpts.interval)
+		}
+		if !output.IsTerminal(os.Stdin) {
+			return fmt.Errorf("vfkit top needs a terminal")
+		}
+		return runTop(cmd.OutOrStdout())
+	},
+}
+
+func init() {
+	topCmd.Flags().StringVar(&topOpts.stateDir, "state-dir", "", "directory where generated host artifacts are stored (default \"$HOME/.vfkit\")")
+	topCmd.Flags().DurationVar(&topOpts.interval, "interval", 2*time.Second, "refresh interval")
+	rootCmd.AddCommand(topCmd)
+}
+
+// dashboard is the state of 'vfkit top'.
+type dashboard struct {
+	out      io.Writer
+	color    bool
+	keys     <-chan []top.Key
+	signals  <-chan os.Signal
+	sampler  *top.Sampler
+	rows     []top.Row
+	selected int
+	status   string
+	// quit is set when vfkit top must exit
+	quit bool
+}
+
+func runTop(out io.Writer) error {
+	restore, err := term.MakeCbreak(int(os.Stdin.Fd()))
+	if err != nil {
+		return err
+	}
+	defer restore()
+	// alternate screen, hidden cursor
+	fmt.Fprint(out, "\x1b[?1049h\x1b[?25l")
+	defer fmt.Fprint(out, "\x1b[?25h\x1b[?1049l")
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	d := &dashboard{
+		out:     out,
+		color:   output.ColorEnabled(out),
+		keys:    readKeys(os.Stdin),
+		signals: signals,
+		sampler: top.NewSampler(),
+	}
+	return d.run()
+}
+
+// readKeys sends the key bindings typed on in to the returned channel, which
+// is closed when in cannot be read anymore.
+func readKeys(in io.Reader) <-chan []top.Key {
+	keys := make(chan []top.Key)
+	go func() {
+		defer close(keys)
+		buf := make([]byte, 64)
+		for {
+			n, err := in.Read(buf)
+			if n > 0 {
+				keys <- top.ParseKeys(buf[:n])
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return keys
+}
+
+func (d *dashboard) run() error {
+	ticker := time.NewTicker(topOpts.interval)
+	defer ticker.Stop()
+	if err := d.refresh(); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-d.signals:
+			return nil
+		case <-ticker.C:
+		case keys, ok := <-d.keys:
+			if !ok {
+				return nil
+			}
+			for _, key := range keys {
+				if key == top.KeyQuit {
+					return nil
+				}
+				d.handleKey(key)
+			}
+			if d.quit {
+				return nil
+			}
+		}
+		if err := d.refresh(); err != nil {
+			return err
+		}
+	}
+}
+
+func (d *dashboard) refresh() error {
+	ctx, cancel := context.WithTimeout(context.Background(), topOpts.interval)
+	defer cancel()
+	entries, err := inventory.Collect(ctx, topOpts.stateDir)
+	if err != nil {
+		return err
+	}
+	d.rows = d.sampler.Update(entries, time.Now())
+	if d.selected >= len(d.rows) {
+		d.selected = len(d.rows) - 1
+	}
+	if d.selected < 0 {
+		d.selected = 0
+	}
+	return top.Render(d.out, d.rows, d.selected, d.status, d.color)
+}
+
+func (d *dashboard) handleKey(key top.Key) {
+	switch key {
+	case top.KeyUp:
+		if d.selected > 0 {
+			d.selected--
+		}
+		return
+	case top.KeyDown:
+		if d.selected < len(d.rows)-1 {
+			d.selected++
+		}
+		return
+	}
+	if d.selected >= len(d.rows) {
+		return
+	}
+	row := d.rows[d.selected]
+	instance, err := client.AdoptInstance(topOpts.stateDir, row.Name)
+	if err != nil {
+		d.status = err.Error()
+		return
+	}
+	if instance.RestClient == nil {
+		d.status = fmt.Sprintf("virtual machine '%s' was started without --restful-uri", row.Name)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	switch key {
+	case top.KeyPause:
+		if row.State == "paused" {
+			err = instance.RestClient.Resume(ctx)
+			d.status = fmt.Sprintf("resumed %s", row.Name)
+		} else {
+			err = instance.RestClient.Pause(ctx)
+			d.status = fmt.Sprintf("paused %s", row.Name)
+		}
+	case top.KeyStop:
+		err = instance.RestClient.Stop(ctx, false)
+		d.status = fmt.Sprintf("stopping %s", row.Name)
+	case top.KeyConsole:
+		d.status = ""
+		err = d.followConsole(instance.RestClient, row.Name)
+	}
+	if err != nil {
+		d.status = err.Error()
+	}
+}
+
+// followConsole shows the serial console of the virtual machine until a key
+// is pressed.
+func (d *dashboard) followConsole(restClient *client.RestClient, name string) error {
+	fmt.Fprintf(d.out, "\x1b[H\x1b[2Jserial console of %s, press any key to return\n\n", name)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- restClient.FollowConsole(ctx, 20, d.out)
+	}()
+	select {
+	case <-d.keys:
+	case <-d.signals:
+		d.quit = true
+	case err := <-errCh:
+		if err != nil {
+			return err
+		}
+		<-d.keys
+		return nil
+	}
+	cancel()
+	<-errCh
+	return nil
+}
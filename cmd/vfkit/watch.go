@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/Code-Hex/vz/v3"
+	"github.com/crc-org/vfkit/pkg/agent"
+	"github.com/crc-org/vfkit/pkg/config"
+	"github.com/crc-org/vfkit/pkg/vf"
+	"github.com/crc-org/vfkit/pkg/watch"
+	log "github.com/sirupsen/logrus"
+)
+
+// notifyEchoDelay is how long changes to a path are ignored after the guest
+// was notified of it. The guest agent touches the file through virtio-fs,
+// which is reported again on the host.
+const notifyEchoDelay = time.Second
+
+// notifyShareChanges watches share on the host and notifies the guest agent
+// of the changes until ctx is cancelled.
+func notifyShareChanges(ctx context.Context, vm *vz.VirtualMachine, share config.WatchedShare) {
+	watcher, err := watch.New(share.SharedDir, watch.DefaultLatency)
+	if err != nil {
+		log.Warnf("cannot watch %s for changes: %v", share.SharedDir, err)
+		return
+	}
+	defer watcher.Close()
+
+	var client *agent.Client
+	defer func() {
+		if client != nil {
+			_ = client.Close()
+		}
+	}()
+
+	notified := map[string]time.Time{}
+	for {
+		var paths []string
+		select {
+		case <-ctx.Done():
+			return
+		case paths = <-watcher.Events():
+		}
+
+		now := time.Now()
+		changed := make([]string, 0, len(paths))
+		for _, path := range paths {
+			if now.Sub(notified[path]) < notifyEchoDelay {
+				continue
+			}
+			changed = append(changed, path)
+			notified[path] = now
+		}
+		for path, t := range notified {
+			if now.Sub(t) >= notifyEchoDelay {
+				delete(notified, path)
+			}
+		}
+		if len(changed) == 0 {
+			continue
+		}
+
+		if client == nil {
+			conn, err := vf.ConnectVsockSync(vm, share.AgentPort)
+			if err != nil {
+				log.Debugf("error connecting to the guest agent on vsock port %d: %v", share.AgentPort, err)
+				continue
+			}
+			client = agent.NewClient(conn)
+		}
+		callCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		err := client.NotifyChanges(callCtx, share.MountTag, changed)
+		cancel()
+		if err != nil {
+			log.Debugf("error notifying the guest of changes to %s: %v", share.SharedDir, err)
+			// reconnect on the next change, the agent may have restarted
+			_ = client.Close()
+			client = nil
+		}
+	}
+}
+
+// setupShareNotifications starts watching the virtio-fs shares configured
+// with the 'notify' option.
+func setupShareNotifications(ctx context.Context, vm *vz.VirtualMachine, vmConfig *config.VirtualMachine) {
+	for _, share := range vmConfig.WatchedShares() {
+		log.Infof("Notifying the guest of changes to %s", share.SharedDir)
+		go notifyShareChanges(ctx, vm, share)
+	}
+}
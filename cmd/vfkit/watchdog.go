@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/crc-org/vfkit/pkg/agent"
+	"github.com/crc-org/vfkit/pkg/config"
+	"github.com/crc-org/vfkit/pkg/events"
+	"github.com/crc-org/vfkit/pkg/vf"
+	vmstate "github.com/crc-org/vfkit/pkg/vm"
+	log "github.com/sirupsen/logrus"
+)
+
+// runWatchdog sends heartbeats to the guest agent until ctx is cancelled. When
+// the guest stops answering for longer than the watchdog timeout, the virtual
+// machine is forcefully stopped. With the restart action, a value is sent on
+// restartCh so that the main loop starts it again.
+func runWatchdog(ctx context.Context, vm *vf.VirtualMachine, watchdog *config.Watchdog, restartCh chan struct{}) {
+	detector := vmstate.NewWatchdog(watchdog.Timeout())
+	ticker := time.NewTicker(watchdog.Interval())
+	defer ticker.Stop()
+
+	var client *agent.Client
+	closeClient := func() {
+		if client != nil {
+			_ = client.Close()
+			client = nil
+		}
+	}
+	defer closeClient()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if vm.StateMachine().State() != vmstate.StateRunning {
+			// paused, stopped or restarting guests can't answer
+			detector.Disarm()
+			closeClient()
+			continue
+		}
+
+		if err := sendHeartbeat(ctx, vm, watchdog, &client); err != nil {
+			log.Debugf("watchdog heartbeat failed: %v", err)
+			// the connection is reopened on the next heartbeat, the
+			// guest agent may have been restarted
+			closeClient()
+		} else {
+			if !detector.Armed() {
+				log.Infof("watchdog armed, guest agent answered its first heartbeat")
+			}
+			detector.Heartbeat(time.Now())
+		}
+
+		if !detector.Expired(time.Now()) {
+			continue
+		}
+		log.Warnf("guest did not answer watchdog heartbeats for %s, running '%s' action", watchdog.Timeout(), watchdog.Action())
+		if vm.Events != nil {
+			recordEvent(vm.Events, events.TypeWatchdog, fmt.Sprintf("guest did not answer heartbeats for %s", watchdog.Timeout()), watchdog.Action())
+		}
+		detector.Disarm()
+		closeClient()
+		// the restart is requested before stopping the virtual machine so
+		// that the main loop sees it when the stop is reported
+		if watchdog.Action() == config.WatchdogRestart {
+			select {
+			case restartCh <- struct{}{}:
+			default:
+			}
+		}
+		if err := vm.Stop(); err != nil {
+			log.Warnf("watchdog failed to stop virtual machine: %v", err)
+			select {
+			case <-restartCh:
+			default:
+			}
+		}
+	}
+}
+
+// sendHeartbeat pings the guest agent, connecting to it first if client is
+// nil.
+func sendHeartbeat(ctx context.Context, vm *vf.VirtualMachine, watchdog *config.Watchdog, client **agent.Client) error {
+	if *client == nil {
+		conn, err := vf.ConnectVsockSync(vm.VirtualMachine, watchdog.AgentPort())
+		if err != nil {
+			return err
+		}
+		*client = agent.NewClient(conn)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, watchdog.Interval())
+	defer cancel()
+	_, err := (*client).Ping(ctx)
+
+	return err
+}
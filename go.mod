@@ -10,11 +10,11 @@ require (
 	github.com/sirupsen/logrus v1.8.1
 	github.com/spf13/cobra v1.6.1
 	github.com/spf13/pflag v1.0.5
+	golang.org/x/sys v0.1.0
 	inet.af/tcpproxy v0.0.0-20210824174053-2e577fef49e2
 )
 
 require (
 	github.com/inconshreveable/mousetrap v1.0.1 // indirect
 	golang.org/x/mod v0.7.0 // indirect
-	golang.org/x/sys v0.1.0 // indirect
 )
@@ -0,0 +1,190 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T) *Client {
+	hostConn, guestConn := net.Pipe()
+	server := NewServer("test")
+	go func() { _ = server.ServeConn(guestConn) }()
+	client := NewClient(hostConn)
+	t.Cleanup(func() { client.Close() })
+
+	return client
+}
+
+func TestAgentPing(t *testing.T) {
+	client := newTestClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	version, err := client.Ping(ctx)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if version != "test" {
+		t.Fatalf("unexpected version %s", version)
+	}
+	if err := client.Call(ctx, "unknown", nil, nil); err == nil {
+		t.Fatal("expected error for unknown method")
+	}
+}
+
+func TestAgentBrokenConnection(t *testing.T) {
+	hostConn, guestConn := net.Pipe()
+	client := NewClient(hostConn)
+	defer client.Close()
+	// the guest reads the requests but never answers
+	go func() { _, _ = io.Copy(io.Discard, guestConn) }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := client.Ping(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expected deadline error; got", err)
+	}
+
+	// a late response to the first call must not be read by the next one
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := client.Ping(ctx); !errors.Is(err, ErrBrokenConnection) {
+		t.Fatal("expected broken connection error; got", err)
+	}
+	if ctx.Err() != nil {
+		t.Fatal("expected the call to fail without waiting")
+	}
+}
+
+func TestAgentGetTime(t *testing.T) {
+	client := newTestClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	before := time.Now()
+	guestTime, err := client.GetTime(ctx)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if guestTime.Before(before) || guestTime.After(time.Now()) {
+		t.Fatalf("unexpected guest time %s", guestTime)
+	}
+}
+
+func TestAgentExec(t *testing.T) {
+	client := newTestClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := client.Exec(ctx, ExecParams{
+		Command: []string{"sh", "-c", "cat; exit 3"},
+		Stdin:   []byte("hello"),
+	})
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if result.ExitCode != 3 || string(result.Stdout) != "hello" {
+		t.Fatalf("unexpected exec result: %+v", result)
+	}
+}
+
+func TestAgentExecStream(t *testing.T) {
+	client := newTestClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	exitCode, err := client.ExecStream(ctx, ExecParams{
+		Command: []string{"sh", "-c", "echo one; sleep 1.5; echo two; echo error >&2; exit 2"},
+	}, &stdout, &stderr)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if exitCode != 2 || stdout.String() != "one\ntwo\n" || stderr.String() != "error\n" {
+		t.Fatalf("unexpected exec result: %d %q %q", exitCode, stdout.String(), stderr.String())
+	}
+
+	shortCtx, shortCancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer shortCancel()
+	if _, err := client.ExecStream(shortCtx, ExecParams{Command: []string{"sleep", "10"}}, nil, nil); err == nil {
+		t.Fatal("expected error when the context expires")
+	}
+}
+
+func TestAgentCopy(t *testing.T) {
+	client := newTestClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "file")
+	data := bytes.Repeat([]byte("vfkit"), maxChunkSize/2)
+	if err := client.CopyTo(ctx, bytes.NewReader(data), path, 0600); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if info.Mode().Perm() != 0600 || info.Size() != int64(len(data)) {
+		t.Fatalf("unexpected file mode %v or size %d", info.Mode(), info.Size())
+	}
+
+	var buf bytes.Buffer
+	if err := client.CopyFrom(ctx, path, &buf); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatal("copied data does not match")
+	}
+}
+
+func TestAgentNotify(t *testing.T) {
+	mountPoint := t.TempDir()
+	mountPointFunc = func(mountTag string) (string, error) {
+		return mountPoint, nil
+	}
+	defer func() { mountPointFunc = virtioFsMountPoint }()
+	client := newTestClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	path := filepath.Join(mountPoint, "file")
+	if err := os.WriteFile(path, []byte("content"), 0600); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	mtime := time.Date(2023, 3, 13, 8, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+
+	dir := filepath.Join(mountPoint, "dir")
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if err := os.Chtimes(dir, mtime, mtime); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+
+	if err := client.NotifyChanges(ctx, "share", []string{"file", "removed", "dir/removed"}); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	for _, path := range []string{path, dir} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal("expected no error; got", err)
+		}
+		if !info.ModTime().Equal(mtime) {
+			t.Fatalf("modification time of %s changed to %s", path, info.ModTime())
+		}
+	}
+	if err := client.NotifyChanges(ctx, "share", []string{"../file"}); err == nil {
+		t.Fatal("expected error for a path outside of the share")
+	}
+}
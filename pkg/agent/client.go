@@ -0,0 +1,244 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrBrokenConnection is returned by the calls made after a call failed while
+// sending its request or reading its response, for example when its context
+// was cancelled. The response of the failed call may still be sent by the
+// guest agent, so the client is not usable anymore and a new connection must
+// be made.
+var ErrBrokenConnection = errors.New("broken guest agent connection")
+
+// Client sends requests to a guest agent.
+type Client struct {
+	conn   net.Conn
+	reader *bufio.Reader
+
+	lock   sync.Mutex
+	nextID uint64
+	// broken is the error which left the connection in an unknown state
+	broken error
+}
+
+// NewClient creates a client for the guest agent at the other end of conn.
+func NewClient(conn net.Conn) *Client {
+	return &Client{
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+	}
+}
+
+// Dial connects to a guest agent exposed on the host unix socket at
+// socketPath, see the 'connect' mode of virtio-vsock devices.
+func Dial(ctx context.Context, socketPath string) (*Client, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+// Close closes the connection to the guest agent.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Call sends a request to the guest agent and waits for its response. The
+// response result is decoded in result if it's not nil. Once a call failed to
+// send its request or to read its response, the following calls fail with
+// ErrBrokenConnection.
+func (c *Client) Call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.broken != nil {
+		return fmt.Errorf("%w: %v", ErrBrokenConnection, c.broken)
+	}
+
+	req := Request{
+		ID:     c.nextID,
+		Method: method,
+	}
+	c.nextID++
+	if params != nil {
+		data, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		req.Params = data
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Time{}
+	}
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+	// unblock the read/write if ctx is cancelled before its deadline
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = c.conn.SetDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	if _, err := c.conn.Write(append(data, '\n')); err != nil {
+		// part of the request may have been sent
+		return c.fail(ctx, err)
+	}
+
+	line, err := c.reader.ReadBytes('\n')
+	if err != nil {
+		return c.fail(ctx, err)
+	}
+	var resp Response
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return c.fail(ctx, fmt.Errorf("invalid response from guest agent: %w", err))
+	}
+	if resp.ID != req.ID {
+		return c.fail(ctx, fmt.Errorf("unexpected response ID from guest agent: %d (expected %d)", resp.ID, req.ID))
+	}
+	if resp.Error != "" {
+		return fmt.Errorf("guest agent %s error: %s", method, resp.Error)
+	}
+	if result == nil || len(resp.Result) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(resp.Result, result)
+}
+
+// fail marks the connection as broken after err, and returns the error of the
+// call: the context error if it was cancelled, err otherwise.
+func (c *Client) fail(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	} else if deadline, ok := ctx.Deadline(); ok && errors.Is(err, os.ErrDeadlineExceeded) && !time.Now().Before(deadline) {
+		// the connection deadline can expire before the context one
+		err = context.DeadlineExceeded
+	}
+	c.broken = err
+	return err
+}
+
+// Ping checks that the guest agent is responding, and returns its version.
+func (c *Client) Ping(ctx context.Context) (string, error) {
+	var result PingResult
+	if err := c.Call(ctx, MethodPing, nil, &result); err != nil {
+		return "", err
+	}
+	return result.Version, nil
+}
+
+// Exec runs a command in the guest and waits for it to complete.
+func (c *Client) Exec(ctx context.Context, params ExecParams) (*ExecResult, error) {
+	var result ExecResult
+	if err := c.Call(ctx, MethodExec, params, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// CopyTo copies the content of r to the file at guestPath in the guest. The
+// file is created with mode if it does not exist, and truncated otherwise.
+func (c *Client) CopyTo(ctx context.Context, r io.Reader, guestPath string, mode os.FileMode) error {
+	buf := make([]byte, maxChunkSize)
+	params := FileWriteParams{
+		Path:     guestPath,
+		Mode:     uint32(mode.Perm()),
+		Truncate: true,
+	}
+	for {
+		n, err := io.ReadFull(r, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		// always send the first request so that empty files are created
+		if n > 0 || params.Truncate {
+			params.Data = buf[:n]
+			if err := c.Call(ctx, MethodFileWrite, params, nil); err != nil {
+				return err
+			}
+			params.Offset += int64(n)
+			params.Truncate = false
+		}
+		if err != nil {
+			return nil
+		}
+	}
+}
+
+// CopyFrom copies the content of the file at guestPath in the guest to w.
+func (c *Client) CopyFrom(ctx context.Context, guestPath string, w io.Writer) error {
+	params := FileReadParams{
+		Path:   guestPath,
+		Length: maxChunkSize,
+	}
+	for {
+		var result FileReadResult
+		if err := c.Call(ctx, MethodFileRead, params, &result); err != nil {
+			return err
+		}
+		if _, err := w.Write(result.Data); err != nil {
+			return err
+		}
+		params.Offset += int64(len(result.Data))
+		if result.EOF {
+			return nil
+		}
+	}
+}
+
+// Addresses returns the network interfaces of the guest and their addresses.
+func (c *Client) Addresses(ctx context.Context) ([]Interface, error) {
+	var result AddressesResult
+	if err := c.Call(ctx, MethodAddresses, nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Interfaces, nil
+}
+
+// Shutdown asks the guest to power off, or to reboot.
+func (c *Client) Shutdown(ctx context.Context, reboot bool) error {
+	return c.Call(ctx, MethodShutdown, ShutdownParams{Reboot: reboot}, nil)
+}
+
+// SetTime sets the guest clock to t.
+func (c *Client) SetTime(ctx context.Context, t time.Time) error {
+	return c.Call(ctx, MethodSetTime, SetTimeParams{Time: t.UnixNano()}, nil)
+}
+
+// GetTime returns the time of the guest clock.
+func (c *Client) GetTime(ctx context.Context) (time.Time, error) {
+	var result GetTimeResult
+	if err := c.Call(ctx, MethodGetTime, nil, &result); err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, result.Time), nil
+}
+
+// NotifyChanges tells the guest that paths, relative to the root of the
+// virtio-fs share mounted with mountTag, were changed on the host.
+func (c *Client) NotifyChanges(ctx context.Context, mountTag string, paths []string) error {
+	return c.Call(ctx, MethodNotify, NotifyParams{MountTag: mountTag, Paths: paths}, nil)
+}
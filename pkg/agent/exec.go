@@ -0,0 +1,241 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+const (
+	// maxExecOutputWait bounds the Wait parameter of exec.output, so that
+	// the connection is not blocked for long
+	maxExecOutputWait = 5 * time.Second
+	// execOutputWait is the Wait parameter used by Client.ExecStream
+	execOutputWait = time.Second
+)
+
+// process is a command started with exec.start. Its output is buffered until
+// it's returned by exec.output.
+type process struct {
+	cmd    *exec.Cmd
+	cancel context.CancelFunc
+
+	lock     sync.Mutex
+	stdout   bytes.Buffer
+	stderr   bytes.Buffer
+	exited   bool
+	exitCode int
+	// changed is closed and replaced when there is new output or when the
+	// command exits
+	changed chan struct{}
+}
+
+// processWriter appends the output of a process to one of its buffers.
+type processWriter struct {
+	p   *process
+	buf *bytes.Buffer
+}
+
+func (w processWriter) Write(data []byte) (int, error) {
+	w.p.lock.Lock()
+	defer w.p.lock.Unlock()
+	w.buf.Write(data)
+	w.p.notify()
+	return len(data), nil
+}
+
+// notify wakes up the exec.output request waiting for p, p.lock must be held.
+func (p *process) notify() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+type processTable struct {
+	lock      sync.Mutex
+	nextID    uint64
+	processes map[uint64]*process
+}
+
+func (t *processTable) add(p *process) uint64 {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.processes == nil {
+		t.processes = map[uint64]*process{}
+	}
+	t.nextID++
+	t.processes[t.nextID] = p
+	return t.nextID
+}
+
+func (t *processTable) get(id uint64) (*process, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	p, ok := t.processes[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown command ID %d", id)
+	}
+	return p, nil
+}
+
+func (t *processTable) remove(id uint64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.processes, id)
+}
+
+func (s *Server) execStart(_ context.Context, rawParams json.RawMessage) (interface{}, error) {
+	var params ExecParams
+	if err := decodeParams(rawParams, &params); err != nil {
+		return nil, err
+	}
+	if len(params.Command) == 0 {
+		return nil, fmt.Errorf("missing command")
+	}
+	// the command outlives the exec.start request
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if params.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), params.Timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+
+	p := &process{cancel: cancel, changed: make(chan struct{})}
+	p.cmd = exec.CommandContext(ctx, params.Command[0], params.Command[1:]...)
+	p.cmd.Env = append(os.Environ(), params.Env...)
+	p.cmd.Dir = params.Dir
+	p.cmd.Stdin = bytes.NewReader(params.Stdin)
+	p.cmd.Stdout = processWriter{p: p, buf: &p.stdout}
+	p.cmd.Stderr = processWriter{p: p, buf: &p.stderr}
+	if err := p.cmd.Start(); err != nil {
+		cancel()
+		return nil, err
+	}
+	go func() {
+		err := p.cmd.Wait()
+		cancel()
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		p.exited = true
+		p.exitCode = p.cmd.ProcessState.ExitCode()
+		var exitErr *exec.ExitError
+		if err != nil && !errors.As(err, &exitErr) {
+			p.exitCode = -1
+		}
+		p.notify()
+	}()
+
+	return &ExecStartResult{ID: s.processes.add(p)}, nil
+}
+
+func (s *Server) execOutput(_ context.Context, rawParams json.RawMessage) (interface{}, error) {
+	var params ExecOutputParams
+	if err := decodeParams(rawParams, &params); err != nil {
+		return nil, err
+	}
+	p, err := s.processes.get(params.ID)
+	if err != nil {
+		return nil, err
+	}
+	wait := params.Wait
+	if wait > maxExecOutputWait {
+		wait = maxExecOutputWait
+	}
+
+	p.lock.Lock()
+	if p.stdout.Len() == 0 && p.stderr.Len() == 0 && !p.exited && wait > 0 {
+		changed := p.changed
+		p.lock.Unlock()
+		select {
+		case <-changed:
+		case <-time.After(wait):
+		}
+		p.lock.Lock()
+	}
+	defer p.lock.Unlock()
+
+	result := ExecOutputResult{
+		Stdout: readChunk(&p.stdout),
+		Stderr: readChunk(&p.stderr),
+	}
+	if p.exited && p.stdout.Len() == 0 && p.stderr.Len() == 0 {
+		result.Exited = true
+		result.ExitCode = p.exitCode
+		s.processes.remove(params.ID)
+	}
+
+	return &result, nil
+}
+
+// readChunk returns at most maxChunkSize bytes of buf.
+func readChunk(buf *bytes.Buffer) []byte {
+	if buf.Len() == 0 {
+		return nil
+	}
+	return append([]byte{}, buf.Next(maxChunkSize)...)
+}
+
+func (s *Server) execKill(_ context.Context, rawParams json.RawMessage) (interface{}, error) {
+	var params ExecKillParams
+	if err := decodeParams(rawParams, &params); err != nil {
+		return nil, err
+	}
+	p, err := s.processes.get(params.ID)
+	if err != nil {
+		return nil, err
+	}
+	p.cancel()
+	s.processes.remove(params.ID)
+
+	return nil, nil
+}
+
+// ExecStream runs a command in the guest, and writes its output to stdout and
+// stderr while it runs. It returns the exit code of the command, -1 if it
+// could not be determined. The command is killed if ctx is cancelled.
+func (c *Client) ExecStream(ctx context.Context, params ExecParams, stdout io.Writer, stderr io.Writer) (int, error) {
+	var start ExecStartResult
+	if err := c.Call(ctx, MethodExecStart, params, &start); err != nil {
+		return -1, err
+	}
+	exitCode, err := c.streamOutput(ctx, start.ID, stdout, stderr)
+	if err != nil {
+		// kill the command, this fails if the connection is no longer
+		// usable after ctx was cancelled in the middle of a request
+		killCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		_ = c.Call(killCtx, MethodExecKill, ExecKillParams{ID: start.ID}, nil)
+		cancel()
+		return -1, err
+	}
+
+	return exitCode, nil
+}
+
+func (c *Client) streamOutput(ctx context.Context, id uint64, stdout io.Writer, stderr io.Writer) (int, error) {
+	for {
+		var output ExecOutputResult
+		if err := c.Call(ctx, MethodExecOutput, ExecOutputParams{ID: id, Wait: execOutputWait}, &output); err != nil {
+			return -1, err
+		}
+		if len(output.Stdout) != 0 && stdout != nil {
+			if _, err := stdout.Write(output.Stdout); err != nil {
+				return -1, err
+			}
+		}
+		if len(output.Stderr) != 0 && stderr != nil {
+			if _, err := stderr.Write(output.Stderr); err != nil {
+				return -1, err
+			}
+		}
+		if output.Exited {
+			return output.ExitCode, nil
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package agent
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// virtioFsMountPoint looks for the mount point of the virtio-fs share with the
+// given tag in /proc/self/mounts.
+func virtioFsMountPoint(mountTag string) (string, error) {
+	file, err := os.Open("/proc/self/mounts")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[2] != "virtiofs" || unescapeMountField(fields[0]) != mountTag {
+			continue
+		}
+		return unescapeMountField(fields[1]), nil
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	return "", fmt.Errorf("virtio-fs share '%s' is not mounted", mountTag)
+}
+
+// unescapeMountField decodes the octal escapes (\040 for space...) used in
+// /proc/self/mounts.
+func unescapeMountField(field string) string {
+	var b strings.Builder
+	for i := 0; i < len(field); i++ {
+		if field[i] == '\\' && i+3 < len(field) {
+			if c, err := strconv.ParseUint(field[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(field[i])
+	}
+	return b.String()
+}
+
+// touch sets the modification time of path to its current value, without
+// following symlinks and without changing the access time.
+func touch(path string) error {
+	var stat unix.Stat_t
+	if err := unix.Lstat(path, &stat); err != nil {
+		return err
+	}
+	times := []unix.Timespec{
+		{Nsec: unix.UTIME_OMIT},
+		stat.Mtim,
+	}
+	return unix.UtimesNanoAt(unix.AT_FDCWD, path, times, unix.AT_SYMLINK_NOFOLLOW)
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+// +build !linux
+
+package agent
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+func virtioFsMountPoint(_ string) (string, error) {
+	return "", fmt.Errorf("virtio-fs shares are not supported on %s", runtime.GOOS)
+}
+
+func touch(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(path, info.ModTime(), info.ModTime())
+}
@@ -0,0 +1,172 @@
+// Package agent implements a simple protocol to communicate with an agent
+// running in the guest over vsock.
+//
+// Messages are JSON objects, one per line. The host sends a Request and waits
+// for the matching Response before sending the next request on the same
+// connection. The guest agent can be implemented with Server, and the host
+// side with Client.
+package agent
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// DefaultVsockPort is the vsock port the guest agent listens on by default.
+const DefaultVsockPort = 1025
+
+// maxChunkSize is the maximum amount of file data sent in a single message.
+const maxChunkSize = 1024 * 1024
+
+// Method names
+const (
+	MethodPing = "ping"
+	MethodExec = "exec"
+	// the streaming variant of exec: exec.start starts the command,
+	// exec.output is called repeatedly to get its output until it exits
+	MethodExecStart  = "exec.start"
+	MethodExecOutput = "exec.output"
+	MethodExecKill   = "exec.kill"
+	MethodFileWrite  = "file.write"
+	MethodFileRead   = "file.read"
+	MethodAddresses  = "network.addresses"
+	MethodShutdown   = "shutdown"
+	MethodSetTime    = "time.set"
+	MethodGetTime    = "time.get"
+	MethodNotify     = "fs.notify"
+)
+
+// Request is sent by the host to the guest agent.
+type Request struct {
+	ID     uint64          `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// Response is sent by the guest agent for each Request. Error is set when the
+// request failed, Result otherwise.
+type Response struct {
+	ID     uint64          `json:"id"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// PingResult is the result of the ping method.
+type PingResult struct {
+	// Version is the version of the guest agent
+	Version string `json:"version"`
+}
+
+// ExecParams are the parameters of the exec method.
+type ExecParams struct {
+	// Command is the program to run, followed by its arguments
+	Command []string `json:"command"`
+	// Env are additional environment variables in the KEY=value format
+	Env []string `json:"env,omitempty"`
+	// Dir is the working directory of the command
+	Dir string `json:"dir,omitempty"`
+	// Stdin is sent to the standard input of the command
+	Stdin []byte `json:"stdin,omitempty"`
+	// Timeout kills the command if it runs for longer, 0 means no timeout
+	Timeout time.Duration `json:"timeout,omitempty"`
+}
+
+// ExecResult is the result of the exec method.
+type ExecResult struct {
+	ExitCode int    `json:"exitCode"`
+	Stdout   []byte `json:"stdout,omitempty"`
+	Stderr   []byte `json:"stderr,omitempty"`
+}
+
+// ExecStartResult is the result of the exec.start method.
+type ExecStartResult struct {
+	// ID identifies the command in the exec.output and exec.kill requests
+	ID uint64 `json:"id"`
+}
+
+// ExecOutputParams are the parameters of the exec.output method.
+type ExecOutputParams struct {
+	ID uint64 `json:"id"`
+	// Wait is how long the guest agent waits for new output when there is
+	// none, at most maxExecOutputWait
+	Wait time.Duration `json:"wait,omitempty"`
+}
+
+// ExecOutputResult is the result of the exec.output method, it contains the
+// output of the command since the previous exec.output request.
+type ExecOutputResult struct {
+	Stdout []byte `json:"stdout,omitempty"`
+	Stderr []byte `json:"stderr,omitempty"`
+	// Exited is true when the command exited and all its output was
+	// returned, the ID is no longer valid then
+	Exited   bool `json:"exited,omitempty"`
+	ExitCode int  `json:"exitCode"`
+}
+
+// ExecKillParams are the parameters of the exec.kill method.
+type ExecKillParams struct {
+	ID uint64 `json:"id"`
+}
+
+// FileWriteParams are the parameters of the file.write method. Large files are
+// written with several requests with increasing offsets.
+type FileWriteParams struct {
+	Path   string `json:"path"`
+	Offset int64  `json:"offset"`
+	Data   []byte `json:"data,omitempty"`
+	// Mode is used when the file is created
+	Mode uint32 `json:"mode,omitempty"`
+	// Truncate truncates the file before writing to it
+	Truncate bool `json:"truncate,omitempty"`
+}
+
+// FileReadParams are the parameters of the file.read method.
+type FileReadParams struct {
+	Path   string `json:"path"`
+	Offset int64  `json:"offset"`
+	Length int    `json:"length"`
+}
+
+// FileReadResult is the result of the file.read method.
+type FileReadResult struct {
+	Data []byte `json:"data,omitempty"`
+	// EOF is true when the end of the file was reached
+	EOF bool `json:"eof"`
+}
+
+// Interface describes a guest network interface.
+type Interface struct {
+	Name       string   `json:"name"`
+	HWAddress  string   `json:"hwAddress,omitempty"`
+	Addresses  []string `json:"addresses"`
+	IsLoopback bool     `json:"isLoopback,omitempty"`
+}
+
+// AddressesResult is the result of the network.addresses method.
+type AddressesResult struct {
+	Interfaces []Interface `json:"interfaces"`
+}
+
+// ShutdownParams are the parameters of the shutdown method.
+type ShutdownParams struct {
+	Reboot bool `json:"reboot,omitempty"`
+}
+
+// SetTimeParams are the parameters of the time.set method.
+type SetTimeParams struct {
+	// Time is the number of nanoseconds since the Unix epoch
+	Time int64 `json:"time"`
+}
+
+// GetTimeResult is the result of the time.get method.
+type GetTimeResult struct {
+	// Time is the number of nanoseconds since the Unix epoch
+	Time int64 `json:"time"`
+}
+
+// NotifyParams are the parameters of the fs.notify method. Paths are relative
+// to the root of the virtio-fs share mounted with MountTag.
+type NotifyParams struct {
+	MountTag string   `json:"mountTag"`
+	Paths    []string `json:"paths"`
+}
@@ -0,0 +1,55 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+// proxyScript configures apt, dnf/yum and the programs using the
+// http_proxy/https_proxy environment variables (npm, pip, curl...) to use the
+// proxy listening on the port given as first argument on the default gateway,
+// which is the host. Existing proxy settings in these files are replaced.
+const proxyScript = `set -e
+port="$1"
+gateway=$(ip route show default | awk '{print $3; exit}')
+if [ -z "$gateway" ]; then
+	echo "no default gateway" >&2
+	exit 1
+fi
+url="http://$gateway:$port"
+
+if [ -d /etc/apt/apt.conf.d ]; then
+	printf 'Acquire::http::Proxy "%s";\nAcquire::https::Proxy "%s";\n' "$url" "$url" > /etc/apt/apt.conf.d/99vfkit-proxy
+fi
+for conf in /etc/dnf/dnf.conf /etc/yum.conf; do
+	# /etc/yum.conf can be a symlink to dnf.conf, which sed -i would replace
+	if [ -f "$conf" ] && [ ! -L "$conf" ]; then
+		sed -i '/^proxy=/d' "$conf"
+		sed -i "/^\[main\]/a proxy=$url" "$conf"
+	fi
+done
+touch /etc/environment
+sed -i -E '/^(http_proxy|https_proxy|HTTP_PROXY|HTTPS_PROXY|no_proxy)=/d' /etc/environment
+for name in http_proxy https_proxy HTTP_PROXY HTTPS_PROXY; do
+	echo "$name=$url" >> /etc/environment
+done
+echo "no_proxy=localhost,127.0.0.1" >> /etc/environment
+`
+
+// ConfigureProxy configures the package managers of the guest and its login
+// environment to use the HTTP proxy listening on port on the host. The host
+// is reached through the default gateway of the guest.
+func (c *Client) ConfigureProxy(ctx context.Context, port uint) error {
+	result, err := c.Exec(ctx, ExecParams{
+		Command: []string{"sh", "-c", proxyScript, "sh", strconv.FormatUint(uint64(port), 10)},
+	})
+	if err != nil {
+		return err
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("failed to configure the guest proxy (exit code %d): %s", result.ExitCode, result.Stderr)
+	}
+
+	return nil
+}
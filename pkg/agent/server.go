@@ -0,0 +1,323 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Handler handles the requests for a method. params is the raw JSON value
+// of Request.Params. The returned value is sent as the response result.
+type Handler func(ctx context.Context, params json.RawMessage) (interface{}, error)
+
+// Server is a guest agent. It implements all the methods of the protocol
+// using the guest operating system, the handlers can be replaced with Handle.
+type Server struct {
+	// Version is returned by the ping method
+	Version  string
+	handlers map[string]Handler
+	// processes are the commands started with exec.start
+	processes processTable
+}
+
+// NewServer creates a guest agent with the default handlers.
+func NewServer(version string) *Server {
+	s := &Server{
+		Version:  version,
+		handlers: map[string]Handler{},
+	}
+	s.Handle(MethodPing, s.ping)
+	s.Handle(MethodExec, handleExec)
+	s.Handle(MethodExecStart, s.execStart)
+	s.Handle(MethodExecOutput, s.execOutput)
+	s.Handle(MethodExecKill, s.execKill)
+	s.Handle(MethodFileWrite, handleFileWrite)
+	s.Handle(MethodFileRead, handleFileRead)
+	s.Handle(MethodAddresses, handleAddresses)
+	s.Handle(MethodShutdown, handleShutdown)
+	s.Handle(MethodSetTime, handleSetTime)
+	s.Handle(MethodGetTime, handleGetTime)
+	s.Handle(MethodNotify, handleNotify)
+
+	return s
+}
+
+// Handle sets the handler for method.
+func (s *Server) Handle(method string, handler Handler) {
+	s.handlers[method] = handler
+}
+
+// Serve accepts connections on listener and serves requests on each of them
+// until listener is closed.
+func (s *Server) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			if err := s.ServeConn(conn); err != nil {
+				log.Debugf("guest agent connection error: %v", err)
+			}
+		}()
+	}
+}
+
+// ServeConn serves requests on conn until it's closed.
+func (s *Server) ServeConn(conn net.Conn) error {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	encoder := json.NewEncoder(conn)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		var req Request
+		if err := json.Unmarshal(line, &req); err != nil {
+			return fmt.Errorf("invalid request: %w", err)
+		}
+		if err := encoder.Encode(s.handleRequest(&req)); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) handleRequest(req *Request) *Response {
+	resp := Response{ID: req.ID}
+	handler, ok := s.handlers[req.Method]
+	if !ok {
+		resp.Error = fmt.Sprintf("unknown method %s", req.Method)
+		return &resp
+	}
+	result, err := handler(context.Background(), req.Params)
+	if err != nil {
+		resp.Error = err.Error()
+		return &resp
+	}
+	if result != nil {
+		data, err := json.Marshal(result)
+		if err != nil {
+			resp.Error = err.Error()
+			return &resp
+		}
+		resp.Result = data
+	}
+
+	return &resp
+}
+
+func decodeParams(params json.RawMessage, value interface{}) error {
+	if len(params) == 0 {
+		return fmt.Errorf("missing parameters")
+	}
+	return json.Unmarshal(params, value)
+}
+
+func (s *Server) ping(_ context.Context, _ json.RawMessage) (interface{}, error) {
+	return &PingResult{Version: s.Version}, nil
+}
+
+func handleExec(ctx context.Context, rawParams json.RawMessage) (interface{}, error) {
+	var params ExecParams
+	if err := decodeParams(rawParams, &params); err != nil {
+		return nil, err
+	}
+	if len(params.Command) == 0 {
+		return nil, fmt.Errorf("missing command")
+	}
+	if params.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, params.Timeout)
+		defer cancel()
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, params.Command[0], params.Command[1:]...)
+	cmd.Env = append(os.Environ(), params.Env...)
+	cmd.Dir = params.Dir
+	cmd.Stdin = bytes.NewReader(params.Stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	result := ExecResult{}
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitCode()
+	case err != nil:
+		return nil, err
+	}
+	result.Stdout = stdout.Bytes()
+	result.Stderr = stderr.Bytes()
+
+	return &result, nil
+}
+
+func handleFileWrite(_ context.Context, rawParams json.RawMessage) (interface{}, error) {
+	var params FileWriteParams
+	if err := decodeParams(rawParams, &params); err != nil {
+		return nil, err
+	}
+	mode := os.FileMode(params.Mode)
+	if mode == 0 {
+		mode = 0644
+	}
+	flags := os.O_WRONLY | os.O_CREATE
+	if params.Truncate {
+		flags |= os.O_TRUNC
+	}
+	file, err := os.OpenFile(params.Path, flags, mode)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.WriteAt(params.Data, params.Offset); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return nil, file.Close()
+}
+
+func handleFileRead(_ context.Context, rawParams json.RawMessage) (interface{}, error) {
+	var params FileReadParams
+	if err := decodeParams(rawParams, &params); err != nil {
+		return nil, err
+	}
+	if params.Length <= 0 || params.Length > maxChunkSize {
+		params.Length = maxChunkSize
+	}
+	file, err := os.Open(params.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	buf := make([]byte, params.Length)
+	n, err := file.ReadAt(buf, params.Offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	return &FileReadResult{
+		Data: buf[:n],
+		EOF:  errors.Is(err, io.EOF),
+	}, nil
+}
+
+func handleAddresses(_ context.Context, _ json.RawMessage) (interface{}, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	result := AddressesResult{Interfaces: []Interface{}}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		resultIface := Interface{
+			Name:       iface.Name,
+			HWAddress:  iface.HardwareAddr.String(),
+			Addresses:  []string{},
+			IsLoopback: iface.Flags&net.FlagLoopback != 0,
+		}
+		for _, addr := range addrs {
+			resultIface.Addresses = append(resultIface.Addresses, addr.String())
+		}
+		result.Interfaces = append(result.Interfaces, resultIface)
+	}
+
+	return &result, nil
+}
+
+func handleShutdown(_ context.Context, rawParams json.RawMessage) (interface{}, error) {
+	var params ShutdownParams
+	if len(rawParams) != 0 {
+		if err := json.Unmarshal(rawParams, &params); err != nil {
+			return nil, err
+		}
+	}
+	command := "poweroff"
+	if params.Reboot {
+		command = "reboot"
+	}
+	// delay the shutdown so that the response can be sent
+	cmd := exec.Command("sh", "-c", fmt.Sprintf("sleep 1; %s", command))
+	return nil, cmd.Start()
+}
+
+func handleSetTime(_ context.Context, rawParams json.RawMessage) (interface{}, error) {
+	var params SetTimeParams
+	if err := decodeParams(rawParams, &params); err != nil {
+		return nil, err
+	}
+
+	return nil, setSystemTime(time.Unix(0, params.Time))
+}
+
+func handleGetTime(_ context.Context, _ json.RawMessage) (interface{}, error) {
+	return GetTimeResult{Time: time.Now().UnixNano()}, nil
+}
+
+// mountPointFunc returns the guest directory where the virtio-fs share with
+// the given tag is mounted. It's a variable so that tests can override it.
+var mountPointFunc = virtioFsMountPoint
+
+// handleNotify updates the modification time of the changed files, and of
+// their parent directories, to its current value. virtio-fs does not report
+// the changes made on the host, touching the files generates IN_ATTRIB inotify
+// events in the guest instead: on the changed files, and on their directories
+// for the files which were created or removed. There are no IN_MODIFY,
+// IN_CREATE or IN_DELETE events.
+func handleNotify(_ context.Context, rawParams json.RawMessage) (interface{}, error) {
+	var params NotifyParams
+	if err := decodeParams(rawParams, &params); err != nil {
+		return nil, err
+	}
+	mountPoint, err := mountPointFunc(params.MountTag)
+	if err != nil {
+		return nil, err
+	}
+
+	dirs := map[string]bool{}
+	for _, path := range params.Paths {
+		path = filepath.Clean(path)
+		if filepath.IsAbs(path) || path == ".." || strings.HasPrefix(path, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("invalid path %s", path)
+		}
+		notifyChange(mountPoint, path)
+		dirs[filepath.Dir(path)] = true
+	}
+	for dir := range dirs {
+		notifyChange(mountPoint, dir)
+	}
+
+	return nil, nil
+}
+
+// notifyChange touches path, relative to mountPoint, to generate an inotify
+// event in the guest.
+func notifyChange(mountPoint string, path string) {
+	// removed files can't be touched, their directory is
+	if err := touch(filepath.Join(mountPoint, path)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Debugf("failed to notify change to %s: %v", path, err)
+	}
+}
@@ -0,0 +1,11 @@
+package agent
+
+import (
+	"syscall"
+	"time"
+)
+
+func setSystemTime(t time.Time) error {
+	tv := syscall.NsecToTimeval(t.UnixNano())
+	return syscall.Settimeofday(&tv)
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package agent
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+func setSystemTime(_ time.Time) error {
+	return fmt.Errorf("setting the time is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,46 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+// swapScript creates the swapfile given as first argument with the size in
+// bytes given as second argument if it does not exist, enables it, and adds
+// it to /etc/fstab. It does nothing if the swapfile is already in use.
+const swapScript = `set -e
+path="$1"
+size="$2"
+if grep -q "^$path " /proc/swaps; then
+	exit 0
+fi
+if [ ! -f "$path" ]; then
+	touch "$path"
+	# swapfiles on btrfs must not be copy-on-write
+	chattr +C "$path" 2>/dev/null || true
+	fallocate -l "$size" "$path" 2>/dev/null || dd if=/dev/zero of="$path" bs=1M count=$((size / 1048576)) status=none
+	chmod 600 "$path"
+	mkswap "$path" >/dev/null
+fi
+swapon "$path"
+grep -q "^$path " /etc/fstab || echo "$path none swap defaults 0 0" >> /etc/fstab
+`
+
+// ProvisionSwap creates a swapfile of sizeBytes at path in the guest and
+// enables it, it is also added to /etc/fstab so that it's used on the next
+// boots. Nothing is done if the swapfile is already enabled. sizeBytes must
+// be a multiple of 1MiB.
+func (c *Client) ProvisionSwap(ctx context.Context, path string, sizeBytes uint64) error {
+	result, err := c.Exec(ctx, ExecParams{
+		Command: []string{"sh", "-c", swapScript, "sh", path, strconv.FormatUint(sizeBytes, 10)},
+	})
+	if err != nil {
+		return err
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("failed to provision swapfile %s (exit code %d): %s", path, result.ExitCode, result.Stderr)
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+// Package arch detects the CPU architecture of guest kernels and disk images.
+//
+// The virtualization framework uses hardware virtualization: guests must have
+// the architecture of the host, nothing is emulated. Booting a foreign guest
+// either hangs or fails with an obscure framework error, detecting it
+// beforehand gives a clear error instead.
+package arch
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Arch is a CPU architecture, named like GOARCH. The empty Arch means the
+// architecture could not be detected.
+type Arch string
+
+const (
+	ARM64 Arch = "arm64"
+	AMD64 Arch = "amd64"
+)
+
+// Host returns the architecture of the host.
+func Host() Arch {
+	return Arch(runtime.GOARCH)
+}
+
+// MismatchError is returned when a guest does not have the architecture of
+// the host.
+type MismatchError struct {
+	// Path is the kernel or disk image of the guest
+	Path  string
+	Guest Arch
+	Host  Arch
+}
+
+func (e *MismatchError) Error() string {
+	msg := fmt.Sprintf("%s is an %s guest but this host is %s: vfkit uses hardware virtualization and cannot emulate another CPU architecture, use an %s image instead",
+		e.Path, e.Guest, e.Host, e.Host)
+	if e.Host == ARM64 && e.Guest == AMD64 {
+		msg += " (x86_64 binaries can run in an arm64 Linux guest with Rosetta, and QEMU can emulate x86_64 virtual machines)"
+	}
+	return msg
+}
+
+// Check returns a *MismatchError if guest, the detected architecture of the
+// kernel or disk image at path, is not the host architecture. Guests of
+// unknown architecture are not rejected.
+func Check(path string, guest Arch) error {
+	if guest == "" || guest == Host() {
+		return nil
+	}
+	return &MismatchError{Path: path, Guest: guest, Host: Host()}
+}
@@ -0,0 +1,142 @@
+package arch
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, path string, data []byte) {
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKernelArch(t *testing.T) {
+	dir := t.TempDir()
+
+	arm64Image := make([]byte, 4096)
+	copy(arm64Image[arm64MagicOffset:], arm64ImageMagic)
+	bzImage := make([]byte, 4096)
+	copy(bzImage, "MZ")
+	copy(bzImage[bzImageMagicOffset:], bzImageMagic)
+	elf := make([]byte, 64)
+	copy(elf, elfMagic)
+	binary.LittleEndian.PutUint16(elf[18:], elfMachineARM64)
+	zboot := make([]byte, 512)
+	copy(zboot, "MZ")
+	binary.LittleEndian.PutUint32(zboot[0x3c:], 0x80)
+	copy(zboot[0x80:], "PE\x00\x00")
+	binary.LittleEndian.PutUint16(zboot[0x84:], peMachineAMD64)
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	_, _ = writer.Write(arm64Image)
+	writer.Close()
+
+	for name, test := range map[string]struct {
+		data []byte
+		arch Arch
+	}{
+		"Image":       {arm64Image, ARM64},
+		"Image.gz":    {compressed.Bytes(), ARM64},
+		"bzImage":     {bzImage, AMD64},
+		"vmlinux":     {elf, ARM64},
+		"vmlinuz.efi": {zboot, AMD64},
+		"unknown":     {[]byte("not a kernel"), ""},
+	} {
+		path := filepath.Join(dir, name)
+		writeFile(t, path, test.data)
+		arch, err := KernelArch(path)
+		if err != nil {
+			t.Fatal("expected no error; got", err)
+		}
+		if arch != test.arch {
+			t.Errorf("unexpected architecture for %s: '%s'", name, arch)
+		}
+	}
+}
+
+// writeEFIDisk creates a disk image with a GPT partition table and a FAT12
+// EFI system partition containing EFI/BOOT/bootFile.
+func writeEFIDisk(t *testing.T, path string, bootFile string) {
+	const espLBA = 34
+	disk := make([]byte, (espLBA+100)*sectorSize)
+	gpt := disk[sectorSize:]
+	copy(gpt, "EFI PART")
+	binary.LittleEndian.PutUint64(gpt[72:], 2)
+	binary.LittleEndian.PutUint32(gpt[80:], 4)
+	binary.LittleEndian.PutUint32(gpt[84:], 128)
+	entry := disk[2*sectorSize:]
+	copy(entry, espTypeGUID)
+	binary.LittleEndian.PutUint64(entry[32:], espLBA)
+
+	// boot sector, FAT, root directory, then clusters 2 and 3
+	esp := disk[espLBA*sectorSize:]
+	binary.LittleEndian.PutUint16(esp[11:], sectorSize)
+	esp[13] = 1
+	binary.LittleEndian.PutUint16(esp[14:], 1)
+	esp[16] = 1
+	binary.LittleEndian.PutUint16(esp[17:], 16)
+	binary.LittleEndian.PutUint16(esp[19:], 100)
+	binary.LittleEndian.PutUint16(esp[22:], 1)
+	esp[510], esp[511] = 0x55, 0xaa
+	copy(esp[sectorSize:], []byte{0xf8, 0xff, 0xff, 0xff, 0xff, 0xff})
+	dirEntry := func(sector int, name string, attr byte, cluster uint16) {
+		raw := esp[sector*sectorSize:]
+		copy(raw, name)
+		raw[11] = attr
+		binary.LittleEndian.PutUint16(raw[26:], cluster)
+	}
+	dirEntry(2, "EFI        ", 0x10, 2)
+	dirEntry(3, "BOOT       ", 0x10, 3)
+	dirEntry(4, bootFile, 0x20, 0)
+
+	writeFile(t, path, disk)
+}
+
+func TestEFIDiskArch(t *testing.T) {
+	dir := t.TempDir()
+	for bootFile, expected := range map[string]Arch{
+		"BOOTAA64EFI": ARM64,
+		"BOOTX64 EFI": AMD64,
+		"GRUBX64 EFI": "",
+	} {
+		path := filepath.Join(dir, "disk.img")
+		writeEFIDisk(t, path, bootFile)
+		arch, err := EFIDiskArch(path)
+		if err != nil {
+			t.Fatal("expected no error; got", err)
+		}
+		if arch != expected {
+			t.Errorf("unexpected architecture with %s: '%s'", bootFile, arch)
+		}
+	}
+
+	empty := filepath.Join(dir, "empty.img")
+	writeFile(t, empty, make([]byte, 1024*1024))
+	if arch, err := EFIDiskArch(empty); err != nil || arch != "" {
+		t.Fatalf("unexpected result for an empty disk: '%s' %v", arch, err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	foreign := ARM64
+	if Host() == ARM64 {
+		foreign = AMD64
+	}
+	err := Check("/images/disk.img", foreign)
+	var mismatch *MismatchError
+	if !errors.As(err, &mismatch) || mismatch.Guest != foreign {
+		t.Fatalf("expected a MismatchError; got %v", err)
+	}
+	if err := Check("/images/disk.img", Host()); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if err := Check("/images/disk.img", ""); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+}
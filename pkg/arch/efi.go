@@ -0,0 +1,324 @@
+package arch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	sectorSize = 512
+	// maxDirEntries bounds the directories which are read, so that
+	// corrupted images cannot make the detection loop
+	maxDirEntries = 65536
+)
+
+// espTypeGUID is the GPT type of EFI system partitions,
+// C12A7328-F81F-11D2-BA4B-00A0C93EC93B in its on-disk encoding.
+var espTypeGUID = []byte{0x28, 0x73, 0x2a, 0xc1, 0x1f, 0xf8, 0xd2, 0x11, 0xba, 0x4b, 0x00, 0xa0, 0xc9, 0x3e, 0xc9, 0x3b}
+
+// espMBRType is the MBR partition type of EFI system partitions.
+const espMBRType = 0xef
+
+// efiBootFiles are the 8.3 names of the default EFI boot applications in the
+// EFI/BOOT directory of the EFI system partition.
+var efiBootFiles = map[string]Arch{
+	"BOOTAA64EFI": ARM64,
+	"BOOTX64 EFI": AMD64,
+}
+
+// EFIDiskArch returns the architecture of the raw disk image at path, which is
+// found from the name of the default boot application on its EFI system
+// partition. The empty Arch is returned when the image has no FAT EFI system
+// partition, or when it can boot several architectures.
+func EFIDiskArch(path string) (Arch, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	espOffset, err := findESP(file)
+	if err != nil || espOffset == 0 {
+		return "", err
+	}
+	fs, err := openFAT(file, espOffset)
+	if err != nil {
+		// not a FAT filesystem, or an unsupported variant
+		return "", nil
+	}
+	entries, err := fs.lookupDir("EFI", "BOOT")
+	if err != nil {
+		return "", nil
+	}
+	found := Arch("")
+	for _, entry := range entries {
+		if arch, ok := efiBootFiles[entry.name]; ok && !entry.isDir() {
+			if found != "" && found != arch {
+				return "", nil
+			}
+			found = arch
+		}
+	}
+
+	return found, nil
+}
+
+// findESP returns the offset of the EFI system partition, or 0 if there is
+// none.
+func findESP(r io.ReaderAt) (int64, error) {
+	gptHeader := make([]byte, sectorSize)
+	if _, err := r.ReadAt(gptHeader, sectorSize); err != nil {
+		return 0, ignoreEOF(err)
+	}
+	if bytes.HasPrefix(gptHeader, []byte("EFI PART")) {
+		entriesLBA := binary.LittleEndian.Uint64(gptHeader[72:])
+		numEntries := binary.LittleEndian.Uint32(gptHeader[80:])
+		entrySize := binary.LittleEndian.Uint32(gptHeader[84:])
+		if entrySize < 48 || numEntries > 1024 {
+			return 0, nil
+		}
+		entry := make([]byte, entrySize)
+		for i := uint32(0); i < numEntries; i++ {
+			if _, err := r.ReadAt(entry, int64(entriesLBA)*sectorSize+int64(i)*int64(entrySize)); err != nil {
+				return 0, ignoreEOF(err)
+			}
+			if bytes.Equal(entry[:16], espTypeGUID) {
+				return int64(binary.LittleEndian.Uint64(entry[32:])) * sectorSize, nil
+			}
+		}
+		return 0, nil
+	}
+
+	mbr := make([]byte, sectorSize)
+	if _, err := r.ReadAt(mbr, 0); err != nil {
+		return 0, ignoreEOF(err)
+	}
+	if mbr[510] != 0x55 || mbr[511] != 0xaa {
+		return 0, nil
+	}
+	for i := 0; i < 4; i++ {
+		partition := mbr[446+16*i:]
+		if partition[4] == espMBRType {
+			return int64(binary.LittleEndian.Uint32(partition[8:])) * sectorSize, nil
+		}
+	}
+
+	return 0, nil
+}
+
+func ignoreEOF(err error) error {
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+type fatType int
+
+const (
+	fat12 fatType = iota
+	fat16
+	fat32
+)
+
+// fatFS reads the directories of a FAT filesystem.
+type fatFS struct {
+	r                 io.ReaderAt
+	offset            int64
+	fatType           fatType
+	bytesPerSector    int64
+	sectorsPerCluster int64
+	fatOffset         int64
+	rootDirOffset     int64
+	rootDirSize       int64
+	rootCluster       uint32
+	dataOffset        int64
+}
+
+type dirEntry struct {
+	// name is the 8.3 name, without the dot and padded with spaces
+	name    string
+	attr    byte
+	cluster uint32
+}
+
+func (entry *dirEntry) isDir() bool {
+	return entry.attr&0x10 != 0
+}
+
+func openFAT(r io.ReaderAt, offset int64) (*fatFS, error) {
+	bootSector := make([]byte, sectorSize)
+	if _, err := r.ReadAt(bootSector, offset); err != nil {
+		return nil, err
+	}
+	if bootSector[510] != 0x55 || bootSector[511] != 0xaa {
+		return nil, fmt.Errorf("invalid FAT boot sector")
+	}
+	bytesPerSector := int64(binary.LittleEndian.Uint16(bootSector[11:]))
+	sectorsPerCluster := int64(bootSector[13])
+	reservedSectors := int64(binary.LittleEndian.Uint16(bootSector[14:]))
+	numFATs := int64(bootSector[16])
+	rootEntries := int64(binary.LittleEndian.Uint16(bootSector[17:]))
+	totalSectors := int64(binary.LittleEndian.Uint16(bootSector[19:]))
+	if totalSectors == 0 {
+		totalSectors = int64(binary.LittleEndian.Uint32(bootSector[32:]))
+	}
+	fatSize := int64(binary.LittleEndian.Uint16(bootSector[22:]))
+	if fatSize == 0 {
+		fatSize = int64(binary.LittleEndian.Uint32(bootSector[36:]))
+	}
+	if bytesPerSector == 0 || bytesPerSector%sectorSize != 0 || sectorsPerCluster == 0 || numFATs == 0 {
+		return nil, fmt.Errorf("invalid FAT boot sector")
+	}
+
+	rootDirSectors := (rootEntries*32 + bytesPerSector - 1) / bytesPerSector
+	firstDataSector := reservedSectors + numFATs*fatSize + rootDirSectors
+	if totalSectors <= firstDataSector {
+		return nil, fmt.Errorf("invalid FAT boot sector")
+	}
+	fs := &fatFS{
+		r:                 r,
+		offset:            offset,
+		bytesPerSector:    bytesPerSector,
+		sectorsPerCluster: sectorsPerCluster,
+		fatOffset:         offset + reservedSectors*bytesPerSector,
+		rootDirOffset:     offset + (reservedSectors+numFATs*fatSize)*bytesPerSector,
+		rootDirSize:       rootDirSectors * bytesPerSector,
+		dataOffset:        offset + firstDataSector*bytesPerSector,
+	}
+	switch clusters := (totalSectors - firstDataSector) / sectorsPerCluster; {
+	case clusters < 4085:
+		fs.fatType = fat12
+	case clusters < 65525:
+		fs.fatType = fat16
+	default:
+		fs.fatType = fat32
+		fs.rootCluster = binary.LittleEndian.Uint32(bootSector[44:])
+	}
+
+	return fs, nil
+}
+
+func (fs *fatFS) clusterSize() int64 {
+	return fs.bytesPerSector * fs.sectorsPerCluster
+}
+
+// nextCluster returns the cluster following cluster in its chain, and false
+// at the end of the chain.
+func (fs *fatFS) nextCluster(cluster uint32) (uint32, bool, error) {
+	buf := make([]byte, 4)
+	switch fs.fatType {
+	case fat12:
+		if _, err := fs.r.ReadAt(buf[:2], fs.fatOffset+int64(cluster)*3/2); err != nil {
+			return 0, false, err
+		}
+		next := uint32(binary.LittleEndian.Uint16(buf))
+		if cluster%2 == 1 {
+			next >>= 4
+		}
+		next &= 0xfff
+		return next, next >= 2 && next < 0xff8, nil
+	case fat16:
+		if _, err := fs.r.ReadAt(buf[:2], fs.fatOffset+int64(cluster)*2); err != nil {
+			return 0, false, err
+		}
+		next := uint32(binary.LittleEndian.Uint16(buf))
+		return next, next >= 2 && next < 0xfff8, nil
+	default:
+		if _, err := fs.r.ReadAt(buf, fs.fatOffset+int64(cluster)*4); err != nil {
+			return 0, false, err
+		}
+		next := binary.LittleEndian.Uint32(buf) & 0x0fffffff
+		return next, next >= 2 && next < 0x0ffffff8, nil
+	}
+}
+
+// readChain reads the content of the cluster chain starting at cluster.
+func (fs *fatFS) readChain(cluster uint32) ([]byte, error) {
+	data := []byte{}
+	for int64(len(data)) < maxDirEntries*32 {
+		buf := make([]byte, fs.clusterSize())
+		if _, err := fs.r.ReadAt(buf, fs.dataOffset+int64(cluster-2)*fs.clusterSize()); err != nil {
+			return nil, err
+		}
+		data = append(data, buf...)
+		next, ok, err := fs.nextCluster(cluster)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		cluster = next
+	}
+	return data, nil
+}
+
+func parseDirEntries(data []byte) []dirEntry {
+	entries := []dirEntry{}
+	for i := 0; i+32 <= len(data); i += 32 {
+		raw := data[i : i+32]
+		if raw[0] == 0 {
+			break
+		}
+		attr := raw[11]
+		// deleted entries, long file names and volume labels
+		if raw[0] == 0xe5 || attr&0x0f == 0x0f || attr&0x08 != 0 {
+			continue
+		}
+		entries = append(entries, dirEntry{
+			name:    strings.ToUpper(string(raw[:11])),
+			attr:    attr,
+			cluster: uint32(binary.LittleEndian.Uint16(raw[20:]))<<16 | uint32(binary.LittleEndian.Uint16(raw[26:])),
+		})
+	}
+	return entries
+}
+
+func (fs *fatFS) rootDir() ([]dirEntry, error) {
+	if fs.fatType == fat32 {
+		data, err := fs.readChain(fs.rootCluster)
+		if err != nil {
+			return nil, err
+		}
+		return parseDirEntries(data), nil
+	}
+	data := make([]byte, fs.rootDirSize)
+	if _, err := fs.r.ReadAt(data, fs.rootDirOffset); err != nil {
+		return nil, err
+	}
+	return parseDirEntries(data), nil
+}
+
+// lookupDir returns the entries of the directory at path, given as a list of
+// names without extension.
+func (fs *fatFS) lookupDir(path ...string) ([]dirEntry, error) {
+	entries, err := fs.rootDir()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range path {
+		shortName := fmt.Sprintf("%-11s", name)
+		found := false
+		for _, entry := range entries {
+			if entry.name == shortName && entry.isDir() && entry.cluster >= 2 {
+				data, err := fs.readChain(entry.cluster)
+				if err != nil {
+					return nil, err
+				}
+				entries = parseDirEntries(data)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("directory %s not found", name)
+		}
+	}
+
+	return entries, nil
+}
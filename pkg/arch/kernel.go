@@ -0,0 +1,115 @@
+package arch
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+const kernelHeaderSize = 4096
+
+const (
+	elfMachineAMD64 = 62
+	elfMachineARM64 = 183
+	peMachineAMD64  = 0x8664
+	peMachineARM64  = 0xaa64
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	elfMagic  = []byte("\x7fELF")
+	// arm64 Image header, see Documentation/arm64/booting.rst
+	arm64ImageMagic  = []byte("ARM\x64")
+	arm64MagicOffset = 56
+	// x86 boot protocol header, see Documentation/x86/boot.rst
+	bzImageMagic       = []byte("HdrS")
+	bzImageMagicOffset = 0x202
+)
+
+// KernelArch returns the architecture of the Linux kernel at path. Raw arm64
+// Images, x86 bzImages, ELF and PE (EFI stub) kernels are recognized, as
+// well as their gzip-compressed versions.
+func KernelArch(path string) (Arch, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	header, err := readHeader(file)
+	if err != nil {
+		return "", err
+	}
+	if bytes.HasPrefix(header, gzipMagic) {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+		reader, err := gzip.NewReader(file)
+		if err != nil {
+			return "", nil
+		}
+		if header, err = readHeader(reader); err != nil {
+			return "", nil
+		}
+	}
+
+	return kernelHeaderArch(header), nil
+}
+
+func readHeader(r io.Reader) ([]byte, error) {
+	header := make([]byte, kernelHeaderSize)
+	n, err := io.ReadFull(r, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	return header[:n], nil
+}
+
+func hasMagicAt(header []byte, offset int, magic []byte) bool {
+	return len(header) >= offset+len(magic) && bytes.Equal(header[offset:offset+len(magic)], magic)
+}
+
+func kernelHeaderArch(header []byte) Arch {
+	switch {
+	case hasMagicAt(header, 0, elfMagic):
+		if len(header) < 20 {
+			return ""
+		}
+		switch binary.LittleEndian.Uint16(header[18:]) {
+		case elfMachineARM64:
+			return ARM64
+		case elfMachineAMD64:
+			return AMD64
+		}
+	case hasMagicAt(header, arm64MagicOffset, arm64ImageMagic):
+		return ARM64
+	case hasMagicAt(header, bzImageMagicOffset, bzImageMagic):
+		return AMD64
+	case hasMagicAt(header, 0, []byte("MZ")):
+		return peArch(header)
+	}
+
+	return ""
+}
+
+// peArch returns the architecture of a PE executable, which is used by EFI
+// stub kernels, EFI applications, and compressed arm64 kernels (zboot).
+func peArch(header []byte) Arch {
+	if len(header) < 0x40 {
+		return ""
+	}
+	offset := int(binary.LittleEndian.Uint32(header[0x3c:]))
+	if !hasMagicAt(header, offset, []byte("PE\x00\x00")) || len(header) < offset+6 {
+		return ""
+	}
+	switch binary.LittleEndian.Uint16(header[offset+4:]) {
+	case peMachineARM64:
+		return ARM64
+	case peMachineAMD64:
+		return AMD64
+	}
+
+	return ""
+}
@@ -0,0 +1,114 @@
+package build
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/crc-org/vfkit/pkg/agent"
+)
+
+type fakeGuest struct {
+	commands []string
+	files    map[string]string
+}
+
+func (g *fakeGuest) Exec(_ context.Context, params agent.ExecParams) (*agent.ExecResult, error) {
+	command := strings.Join(params.Command, " ")
+	g.commands = append(g.commands, command)
+	if strings.Contains(command, "exit 3") {
+		return &agent.ExecResult{ExitCode: 3, Stderr: []byte("failed\n")}, nil
+	}
+	return &agent.ExecResult{Stdout: []byte("ok\n")}, nil
+}
+
+func (g *fakeGuest) CopyTo(_ context.Context, r io.Reader, guestPath string, _ os.FileMode) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	g.files[guestPath] = string(data)
+	return nil
+}
+
+const testSpec = `{
+  "base": "base.qcow2",
+  "output": "/images/golden.img",
+  "size": "20GiB",
+  "vm": {"cpus": 2, "memory": "2GiB", "bootloader": {"type": "efi", "variable-store": "efistore", "create": true}},
+  "cloudInit": {"userData": "user-data"},
+  "steps": [
+    {"shell": "dnf install -y podman", "env": ["LANG=C"]},
+    {"upload": {"source": "motd", "destination": "/etc/motd"}},
+    {"script": "cleanup.sh"}
+  ]
+}`
+
+func TestLoadSpec(t *testing.T) {
+	spec, err := LoadSpec(strings.NewReader(testSpec), "/builds")
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if spec.Base != "/builds/base.qcow2" || spec.Output != "/images/golden.img" || spec.Steps[2].Script != "/builds/cleanup.sh" {
+		t.Fatalf("unexpected paths: %+v", spec)
+	}
+	if spec.SizeBytes() != 20*1024*1024*1024 || spec.AgentPort != agent.DefaultVsockPort || !spec.ShouldCompact() {
+		t.Fatalf("unexpected defaults: %+v", spec)
+	}
+
+	for _, invalid := range []string{
+		`{"output": "out.img", "vm": {}}`,
+		`{"base": "base.img", "output": "out.img"}`,
+		`{"base": "base.img", "output": "out.img", "vm": {}, "steps": [{"shell": "true", "script": "x.sh"}]}`,
+		`{"base": "base.img", "output": "out.img", "vm": {}, "steps": [{"upload": {"source": "a", "destination": "b"}}]}`,
+		`{"base": "base.img", "output": "out.img", "vm": {}, "unknown": true}`,
+	} {
+		if _, err := LoadSpec(strings.NewReader(invalid), "/builds"); err == nil {
+			t.Errorf("expected error for %s", invalid)
+		}
+	}
+}
+
+func TestProvision(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"user-data":  "#cloud-config\n",
+		"motd":       "welcome\n",
+		"cleanup.sh": "rm -rf /var/cache/dnf\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	spec, err := LoadSpec(strings.NewReader(testSpec), dir)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+
+	guest := &fakeGuest{files: map[string]string{}}
+	var out strings.Builder
+	if err := spec.Provision(context.Background(), guest, &out); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if guest.files["/var/lib/cloud/seed/nocloud/user-data"] != "#cloud-config\n" ||
+		guest.files["/var/lib/cloud/seed/nocloud/meta-data"] == "" ||
+		guest.files["/etc/motd"] != "welcome\n" ||
+		guest.files[scriptPath] != "rm -rf /var/cache/dnf\n" {
+		t.Fatalf("unexpected guest files: %v", guest.files)
+	}
+	if !strings.Contains(out.String(), "==> step 3/3: script: "+filepath.Join(dir, "cleanup.sh")) {
+		t.Fatalf("unexpected output:\n%s", out.String())
+	}
+	last := guest.commands[len(guest.commands)-1]
+	if !strings.Contains(last, "/vfkit-build-zero") {
+		t.Fatalf("free space was not zero-filled: %v", guest.commands)
+	}
+
+	spec.Steps[0].Shell = "exit 3"
+	if err := spec.Provision(context.Background(), guest, &out); err == nil || !strings.Contains(err.Error(), "step 1 failed: exit code 3") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,184 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"github.com/crc-org/vfkit/pkg/agent"
+	"github.com/crc-org/vfkit/pkg/diskimage"
+)
+
+// Guest runs commands and copies files in the build virtual machine, it's
+// implemented by agent.Client.
+type Guest interface {
+	Exec(ctx context.Context, params agent.ExecParams) (*agent.ExecResult, error)
+	CopyTo(ctx context.Context, r io.Reader, guestPath string, mode os.FileMode) error
+}
+
+const (
+	cloudInitSeedDir = "/var/lib/cloud/seed/nocloud"
+	// cloudInitScript runs all the cloud-init stages with the NoCloud seed
+	cloudInitScript = `set -e
+cloud-init clean --logs
+cloud-init init --local
+cloud-init init
+cloud-init modules --mode=config
+cloud-init modules --mode=final
+`
+	// cloudInitCleanupScript resets cloud-init so that it runs again when the
+	// image is booted, with the seed of the virtual machines using it
+	cloudInitCleanupScript = `cloud-init clean --logs && rm -rf ` + cloudInitSeedDir
+	// compactScript discards the free blocks and zero-fills the free space
+	// of the root filesystem, dd fails when the filesystem is full
+	compactScript = `fstrim -a 2>/dev/null
+dd if=/dev/zero of=/vfkit-build-zero bs=1M 2>/dev/null
+rm -f /vfkit-build-zero
+sync
+`
+	scriptPath = "/tmp/vfkit-build-step"
+)
+
+// BuildPath is the path of the image while it's being built, it's renamed to
+// Output once the build succeeded.
+func (spec *Spec) BuildPath() string {
+	return spec.Output + ".build"
+}
+
+// PrepareImage copies the base image to BuildPath, as a raw image grown to
+// the requested size.
+func (spec *Spec) PrepareImage() error {
+	if err := diskimage.Convert(spec.Base, spec.BuildPath()); err != nil {
+		return err
+	}
+	if spec.sizeBytes == 0 {
+		return nil
+	}
+	info, err := os.Stat(spec.BuildPath())
+	if err != nil {
+		return err
+	}
+	if uint64(info.Size()) > spec.sizeBytes {
+		return fmt.Errorf("base image is larger than the requested size (%d bytes)", info.Size())
+	}
+
+	return os.Truncate(spec.BuildPath(), int64(spec.sizeBytes))
+}
+
+// FinishImage compacts the built image if needed and moves it to Output.
+func (spec *Spec) FinishImage() error {
+	if spec.ShouldCompact() {
+		if err := diskimage.Convert(spec.BuildPath(), spec.BuildPath()); err != nil {
+			return fmt.Errorf("failed to compact image: %w", err)
+		}
+	}
+
+	return os.Rename(spec.BuildPath(), spec.Output)
+}
+
+func run(ctx context.Context, guest Guest, out io.Writer, params agent.ExecParams) error {
+	result, err := guest.Exec(ctx, params)
+	if err != nil {
+		return err
+	}
+	_, _ = out.Write(result.Stdout)
+	_, _ = out.Write(result.Stderr)
+	if result.ExitCode != 0 {
+		return fmt.Errorf("exit code %d", result.ExitCode)
+	}
+
+	return nil
+}
+
+func copyFile(ctx context.Context, guest Guest, hostPath string, guestPath string) error {
+	file, err := os.Open(hostPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := guest.Exec(ctx, agent.ExecParams{Command: []string{"mkdir", "-p", path.Dir(guestPath)}}); err != nil {
+		return err
+	}
+
+	return guest.CopyTo(ctx, file, guestPath, info.Mode())
+}
+
+func (spec *Spec) runCloudInit(ctx context.Context, guest Guest, out io.Writer) error {
+	if err := copyFile(ctx, guest, spec.CloudInit.UserData, path.Join(cloudInitSeedDir, "user-data")); err != nil {
+		return err
+	}
+	metaDataPath := path.Join(cloudInitSeedDir, "meta-data")
+	if spec.CloudInit.MetaData != "" {
+		if err := copyFile(ctx, guest, spec.CloudInit.MetaData, metaDataPath); err != nil {
+			return err
+		}
+	} else {
+		metaData := bytes.NewBufferString("instance-id: vfkit-build\n")
+		if err := guest.CopyTo(ctx, metaData, metaDataPath, 0644); err != nil {
+			return err
+		}
+	}
+
+	return run(ctx, guest, out, agent.ExecParams{Command: []string{"sh", "-c", cloudInitScript}})
+}
+
+func (step *Step) run(ctx context.Context, guest Guest, out io.Writer) error {
+	switch {
+	case step.Shell != "":
+		return run(ctx, guest, out, agent.ExecParams{
+			Command: []string{"sh", "-c", step.Shell},
+			Env:     step.Env,
+		})
+	case step.Script != "":
+		if err := copyFile(ctx, guest, step.Script, scriptPath); err != nil {
+			return err
+		}
+		err := run(ctx, guest, out, agent.ExecParams{
+			Command: []string{"sh", scriptPath},
+			Env:     step.Env,
+		})
+		_, _ = guest.Exec(ctx, agent.ExecParams{Command: []string{"rm", "-f", scriptPath}})
+		return err
+	default:
+		return copyFile(ctx, guest, step.Upload.Source, step.Upload.Destination)
+	}
+}
+
+// Provision runs cloud-init and the steps of spec in guest, and prepares the
+// guest disk for compaction. The output of the commands is written to out.
+func (spec *Spec) Provision(ctx context.Context, guest Guest, out io.Writer) error {
+	if spec.CloudInit != nil {
+		fmt.Fprintf(out, "==> cloud-init: %s\n", spec.CloudInit.UserData)
+		if err := spec.runCloudInit(ctx, guest, out); err != nil {
+			return fmt.Errorf("cloud-init failed: %w", err)
+		}
+	}
+	for i := range spec.Steps {
+		step := &spec.Steps[i]
+		fmt.Fprintf(out, "==> step %d/%d: %s\n", i+1, len(spec.Steps), step)
+		if err := step.run(ctx, guest, out); err != nil {
+			return fmt.Errorf("step %d failed: %w", i+1, err)
+		}
+	}
+	if spec.CloudInit != nil {
+		if err := run(ctx, guest, out, agent.ExecParams{Command: []string{"sh", "-c", cloudInitCleanupScript}}); err != nil {
+			return fmt.Errorf("cloud-init cleanup failed: %w", err)
+		}
+	}
+	if spec.ShouldCompact() {
+		fmt.Fprintln(out, "==> zero-filling free space")
+		// errors are expected, the filesystem is filled until dd fails
+		if _, err := guest.Exec(ctx, agent.ExecParams{Command: []string{"sh", "-c", compactScript}}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
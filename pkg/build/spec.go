@@ -0,0 +1,205 @@
+// Package build provisions golden disk images: a base image is booted in a
+// temporary virtual machine, provisioning steps are run in the guest with the
+// vfkit guest agent, and the resulting disk is compacted.
+package build
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/crc-org/vfkit/pkg/agent"
+	"github.com/crc-org/vfkit/pkg/util"
+)
+
+// Spec describes how an image is built. It is read from a JSON file, see
+// LoadSpec.
+type Spec struct {
+	// Base is the disk image the build starts from, raw, qcow2 or vmdk
+	Base string `json:"base"`
+	// Output is the path of the raw image which is built
+	Output string `json:"output"`
+	// Size grows the disk image, such as "20GiB". The guest must grow its
+	// partitions and filesystems, cloud-init does it by default.
+	Size string `json:"size,omitempty"`
+	// VM is the configuration of the build virtual machine, in the format
+	// of the vfkit configuration files. The image being built is added to
+	// its devices.
+	VM json.RawMessage `json:"vm"`
+	// AgentPort is the vsock port of the guest agent, which must be
+	// installed in the base image
+	AgentPort uint `json:"agentPort,omitempty"`
+	// CloudInit runs cloud-init with the given user-data before the steps
+	CloudInit *CloudInit `json:"cloudInit,omitempty"`
+	Steps     []Step     `json:"steps"`
+	// Compact zero-fills the free space of the guest root filesystem and
+	// makes the zero-filled parts of the output sparse, true by default
+	Compact *bool `json:"compact,omitempty"`
+
+	sizeBytes uint64
+}
+
+// CloudInit is a NoCloud seed for cloud-init.
+type CloudInit struct {
+	// UserData is the path of the user-data file on the host
+	UserData string `json:"userData"`
+	// MetaData is the path of the meta-data file on the host, a minimal
+	// one is used when it's empty
+	MetaData string `json:"metaData,omitempty"`
+}
+
+// Step is a provisioning step, only one of its fields can be set.
+type Step struct {
+	// Shell is a script run with 'sh -c' in the guest
+	Shell string `json:"shell,omitempty"`
+	// Script is the path of a script on the host, it's copied to the
+	// guest and executed
+	Script string `json:"script,omitempty"`
+	// Upload copies a host file to the guest
+	Upload *Upload `json:"upload,omitempty"`
+	// Env are environment variables in the KEY=value format for Shell
+	// and Script steps
+	Env []string `json:"env,omitempty"`
+}
+
+// Upload copies the host file Source to Destination in the guest.
+type Upload struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+}
+
+func (step *Step) String() string {
+	switch {
+	case step.Shell != "":
+		return fmt.Sprintf("shell: %s", step.Shell)
+	case step.Script != "":
+		return fmt.Sprintf("script: %s", step.Script)
+	case step.Upload != nil:
+		return fmt.Sprintf("upload: %s to %s", step.Upload.Source, step.Upload.Destination)
+	}
+	return "empty step"
+}
+
+func (step *Step) validate() error {
+	set := 0
+	for _, isSet := range []bool{step.Shell != "", step.Script != "", step.Upload != nil} {
+		if isSet {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("a step must have exactly one of 'shell', 'script' or 'upload'")
+	}
+	if step.Upload != nil {
+		if step.Upload.Source == "" || !filepath.IsAbs(step.Upload.Destination) {
+			return fmt.Errorf("upload steps need a 'source' and an absolute 'destination'")
+		}
+		if len(step.Env) != 0 {
+			return fmt.Errorf("upload steps have no environment")
+		}
+	}
+
+	return nil
+}
+
+// LoadSpec reads a build specification in JSON format from r. Relative host
+// paths are relative to dir, which is usually the directory of the
+// specification file. The specification looks like:
+//
+//	{
+//	  "base": "fedora-cloud.qcow2",
+//	  "output": "golden.img",
+//	  "size": "20GiB",
+//	  "vm": {
+//	    "cpus": 2,
+//	    "memory": "2GiB",
+//	    "bootloader": {"type": "efi", "variable-store": "efistore", "create": true},
+//	    "devices": [{"type": "virtio-net", "nat": true}]
+//	  },
+//	  "cloudInit": {"userData": "user-data"},
+//	  "steps": [
+//	    {"shell": "dnf install -y podman"},
+//	    {"upload": {"source": "motd", "destination": "/etc/motd"}},
+//	    {"script": "cleanup.sh"}
+//	  ]
+//	}
+func LoadSpec(r io.Reader, dir string) (*Spec, error) {
+	var spec Spec
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&spec); err != nil {
+		return nil, err
+	}
+
+	if spec.Base == "" || spec.Output == "" {
+		return nil, fmt.Errorf("missing 'base' or 'output' key")
+	}
+	if len(spec.VM) == 0 {
+		return nil, fmt.Errorf("missing 'vm' key")
+	}
+	if spec.AgentPort == 0 {
+		spec.AgentPort = agent.DefaultVsockPort
+	}
+	if spec.Size != "" {
+		size, err := util.ParseMemorySize(spec.Size)
+		if err != nil {
+			return nil, fmt.Errorf("invalid image size: %w", err)
+		}
+		spec.sizeBytes = size
+	}
+
+	resolve := func(path *string) {
+		if *path != "" && !filepath.IsAbs(*path) {
+			*path = filepath.Join(dir, *path)
+		}
+	}
+	resolve(&spec.Base)
+	resolve(&spec.Output)
+	if spec.CloudInit != nil {
+		if spec.CloudInit.UserData == "" {
+			return nil, fmt.Errorf("missing 'userData' key for cloud-init")
+		}
+		resolve(&spec.CloudInit.UserData)
+		resolve(&spec.CloudInit.MetaData)
+	}
+	for i := range spec.Steps {
+		step := &spec.Steps[i]
+		if err := step.validate(); err != nil {
+			return nil, fmt.Errorf("step %d: %w", i+1, err)
+		}
+		resolve(&step.Script)
+		if step.Upload != nil {
+			resolve(&step.Upload.Source)
+		}
+	}
+
+	return &spec, nil
+}
+
+// LoadSpecFile reads the build specification at path, see LoadSpec.
+func LoadSpecFile(path string) (*Spec, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	spec, err := LoadSpec(file, filepath.Dir(path))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return spec, nil
+}
+
+// SizeBytes is the size of the output image, 0 to keep the size of the base
+// image.
+func (spec *Spec) SizeBytes() uint64 {
+	return spec.sizeBytes
+}
+
+// ShouldCompact returns true if the output image must be compacted.
+func (spec *Spec) ShouldCompact() bool {
+	return spec.Compact == nil || *spec.Compact
+}
@@ -0,0 +1,221 @@
+package client
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/crc-org/vfkit/pkg/naming"
+	"github.com/crc-org/vfkit/pkg/snapshot"
+)
+
+// CloneOption is an override applied to the VirtualMachine created by
+// VirtualMachine.Clone.
+type CloneOption func(*VirtualMachine) error
+
+// Clone returns a copy of vm with options applied to it. vm is used as a
+// template and is not modified, the devices of the copy can be changed
+// independently. The host paths of vm, such as the disk images, the REST API
+// socket or the pid file, are copied unchanged, options such as
+// WithDiskOverlays and WithVsockSocketDir give each copy its own files.
+func (vm *VirtualMachine) Clone(options ...CloneOption) (*VirtualMachine, error) {
+	clone := *vm
+	clone.bootloader = cloneBootloader(vm.bootloader)
+	clone.labels = nil
+	for key, value := range vm.labels {
+		clone.SetLabel(key, value)
+	}
+
+	clone.devices = nil
+	clone.deviceIDs = nil
+	clones := map[VirtioDevice]VirtioDevice{}
+	for _, dev := range vm.devices {
+		devClone := cloneDevice(dev)
+		clones[dev] = devClone
+		clone.devices = append(clone.devices, devClone)
+	}
+	for id, dev := range vm.deviceIDs {
+		if clone.deviceIDs == nil {
+			clone.deviceIDs = map[string]VirtioDevice{}
+		}
+		clone.deviceIDs[id] = clones[dev]
+	}
+
+	for _, option := range options {
+		if err := option(&clone); err != nil {
+			return nil, err
+		}
+	}
+
+	return &clone, nil
+}
+
+func cloneBootloader(bootloader Bootloader) Bootloader {
+	switch bootloader := bootloader.(type) {
+	case *linuxBootloader:
+		bootloaderClone := *bootloader
+		return &bootloaderClone
+	case *efiBootloader:
+		bootloaderClone := *bootloader
+		return &bootloaderClone
+	default:
+		return bootloader
+	}
+}
+
+// cloneDevice returns a copy of dev. Devices implemented outside of the
+// client package are shared with the template.
+func cloneDevice(dev VirtioDevice) VirtioDevice {
+	switch dev := dev.(type) {
+	case *VirtioVsock:
+		devClone := *dev
+		return &devClone
+	case *virtioBlk:
+		devClone := *dev
+		return &devClone
+	case *cdrom:
+		devClone := *dev
+		return &devClone
+	case *virtioBalloon:
+		devClone := *dev
+		return &devClone
+	case *virtioRNG:
+		devClone := *dev
+		return &devClone
+	case *virtioNet:
+		devClone := *dev
+		return &devClone
+	case *virtioSerial:
+		devClone := *dev
+		return &devClone
+	case *virtioFs:
+		devClone := *dev
+		return &devClone
+	case *PortForward:
+		devClone := *dev
+		return &devClone
+	case *timeSync:
+		devClone := *dev
+		return &devClone
+	default:
+		return dev
+	}
+}
+
+// WithName sets the name of the cloned virtual machine, so that the host
+// artifacts vfkit generates do not conflict with the ones of the template.
+func WithName(name string) CloneOption {
+	return func(vm *VirtualMachine) error {
+		vm.SetName(name)
+		return nil
+	}
+}
+
+// netDevices returns the network devices of vm.
+func (vm *VirtualMachine) netDevices() []*virtioNet {
+	netDevs := []*virtioNet{}
+	for _, dev := range vm.devices {
+		if netDev, isNet := dev.(*virtioNet); isNet {
+			netDevs = append(netDevs, netDev)
+		}
+	}
+	return netDevs
+}
+
+// WithMACAddresses sets the MAC addresses of the network devices of the
+// cloned virtual machine, in the order the devices were added. There must be
+// one address per network device.
+func WithMACAddresses(macAddresses ...string) CloneOption {
+	return func(vm *VirtualMachine) error {
+		netDevs := vm.netDevices()
+		if len(macAddresses) != len(netDevs) {
+			return fmt.Errorf("%w: %d MAC addresses for %d network devices", ErrInvalidConfig, len(macAddresses), len(netDevs))
+		}
+		for i, macAddress := range macAddresses {
+			hwAddr, err := net.ParseMAC(macAddress)
+			if err != nil {
+				return invalidDevice("virtio-net", "mac", "%v", err)
+			}
+			netDevs[i].macAddress = hwAddr
+		}
+		return nil
+	}
+}
+
+// WithRandomMACAddresses gives random locally administered MAC addresses to
+// the network devices of the cloned virtual machine. Static DHCP leases keep
+// the IP address of the template, it must be changed with a separate device.
+func WithRandomMACAddresses() CloneOption {
+	return func(vm *VirtualMachine) error {
+		for _, netDev := range vm.netDevices() {
+			hwAddr, err := randomMACAddress()
+			if err != nil {
+				return err
+			}
+			netDev.macAddress = hwAddr
+		}
+		return nil
+	}
+}
+
+// randomMACAddress returns a random unicast, locally administered MAC
+// address.
+func randomMACAddress() (net.HardwareAddr, error) {
+	hwAddr := make(net.HardwareAddr, 6)
+	if _, err := rand.Read(hwAddr); err != nil {
+		return nil, err
+	}
+	hwAddr[0] = (hwAddr[0] &^ 0x01) | 0x02
+	return hwAddr, nil
+}
+
+// WithDiskOverlays gives the cloned virtual machine its own copy of the disk
+// images of the template, created in dir and named after the device index
+// (disk-0.img, disk-1.img...). The copies are copy-on-write clones of the
+// template images on APFS, so they are created instantly and only use disk
+// space for the blocks the guest changes. The copies must not exist yet.
+func WithDiskOverlays(dir string) CloneOption {
+	return func(vm *VirtualMachine) error {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+		index := 0
+		for _, dev := range vm.devices {
+			blkDev, isBlk := dev.(*virtioBlk)
+			if !isBlk {
+				continue
+			}
+			// vfkit generates a RAM disk per virtual machine
+			if blkDev.ramSizeBytes != 0 {
+				index++
+				continue
+			}
+			overlayPath := filepath.Join(dir, naming.DiskDeviceID(index)+filepath.Ext(blkDev.imagePath))
+			if err := snapshot.CloneFile(blkDev.imagePath, overlayPath); err != nil {
+				return fmt.Errorf("failed to clone disk image %s: %w", blkDev.imagePath, err)
+			}
+			blkDev.imagePath = overlayPath
+			index++
+		}
+		return nil
+	}
+}
+
+// WithVsockSocketDir moves the unix sockets of the virtio-vsock devices of the
+// cloned virtual machine to dir, they are named after the vsock port
+// (vsock-1024.sock...). The devices without a socket URL already get a
+// socket path specific to the name of the virtual machine, see
+// VirtualMachine.VsockSocketPath.
+func WithVsockSocketDir(dir string) CloneOption {
+	return func(vm *VirtualMachine) error {
+		for _, vsockDev := range vm.VirtioVsockDevices() {
+			if vsockDev.SocketURL == "" {
+				continue
+			}
+			vsockDev.SocketURL = filepath.Join(dir, naming.VsockDeviceID(vsockDev.Port)+".sock")
+		}
+		return nil
+	}
+}
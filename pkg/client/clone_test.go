@@ -0,0 +1,90 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestClone(t *testing.T) {
+	dir := t.TempDir()
+	imagePath := filepath.Join(dir, "template.img")
+	if err := os.WriteFile(imagePath, []byte("template disk"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	template := NewVirtualMachine(2, 1024*1024*1024, NewLinuxBootloader("/vmlinuz", "console=hvc0", "/initrd"))
+	template.SetName("template")
+	disk, err := VirtioBlkNew(imagePath)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if err := template.AddDeviceWithID("root", disk); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	netDev, err := VirtioNetNew("5a:94:ef:e4:0c:ee")
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if err := template.AddDevice(netDev); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	vsock, err := VirtioVsockNew(1024, "/tmp/template-vsock.sock", false)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if err := template.AddDevice(vsock); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	templateArgs, err := template.ToCmdLineString()
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+
+	cloneDir := filepath.Join(dir, "clone")
+	clone, err := template.Clone(
+		WithName("clone"),
+		WithMACAddresses("5a:94:ef:e4:0c:ef"),
+		WithDiskOverlays(cloneDir),
+		WithVsockSocketDir(cloneDir),
+	)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if err := clone.SetKernelArg("console", "ttyS0"); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	args, err := clone.ToCmdLineString()
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	overlayPath := filepath.Join(cloneDir, "disk-0.img")
+	for _, expected := range []string{"--name clone", "path=" + overlayPath, "mac=5a:94:ef:e4:0c:ef", filepath.Join(cloneDir, "vsock-1024.sock"), "console=ttyS0"} {
+		if !strings.Contains(args, expected) {
+			t.Fatalf("expected '%s' in the clone command line: %s", expected, args)
+		}
+	}
+	if data, err := os.ReadFile(overlayPath); err != nil || string(data) != "template disk" {
+		t.Fatalf("unexpected disk overlay content: %q, %v", data, err)
+	}
+	if dev, ok := clone.DeviceByID("root"); !ok || dev == disk {
+		t.Fatal("expected the device IDs to refer to the cloned devices")
+	}
+
+	// the template is unchanged
+	if newArgs, _ := template.ToCmdLineString(); newArgs != templateArgs {
+		t.Fatalf("template was modified by Clone: %s", newArgs)
+	}
+
+	if _, err := template.Clone(WithMACAddresses()); err == nil {
+		t.Fatal("expected error for a wrong number of MAC addresses")
+	}
+	clone, err = template.Clone(WithRandomMACAddresses())
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	macAddress := clone.netDevices()[0].macAddress
+	if macAddress.String() == "5a:94:ef:e4:0c:ee" || macAddress[0]&0x03 != 0x02 {
+		t.Fatalf("unexpected random MAC address %s", macAddress)
+	}
+}
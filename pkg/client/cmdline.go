@@ -2,14 +2,28 @@
 //
 // After creating a `VirtualMachine` object, use its `ToCmdLine()` method to
 // get a list of arguments which can be used with the [os/exec] package.
+//
+// When the vfkit binary is set with `SetVfkitPath()` and its version detected
+// with `Version()`, the command line starts with the binary, and the options
+// this version does not support are reported as errors.
 // package client
 package client
 
 import (
+	"context"
 	"fmt"
+	"math"
 	"net"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/crc-org/vfkit/pkg/naming"
+	"github.com/crc-org/vfkit/pkg/rest/define"
+	"github.com/crc-org/vfkit/pkg/util"
+	"github.com/docker/go-units"
 )
 
 // Bootloader is the base interface for all bootloader classes. It specifies how to
@@ -29,15 +43,47 @@ type linuxBootloader struct {
 type efiBootloader struct {
 	efiVariableStorePath string
 	createVariableStore  bool
+	options              EFIOptions
+}
+
+// EFIOptions are the optional settings of an EFI bootloader.
+type EFIOptions struct {
+	// NetbootDir is a host directory with boot files which vfkit serves to
+	// the guest over TFTP and HTTP, on the address of the host on the NAT
+	// network. The EFI firmware cannot boot from the network, the files
+	// are meant for a network bootloader such as iPXE started from a disk
+	// image. The virtual machine needs a NAT network device.
+	NetbootDir string
+	// NetbootTFTPPort and NetbootHTTPPort are the ports of the netboot
+	// services, vfkit uses 69 and 8080 when they are 0.
+	NetbootTFTPPort uint16
+	NetbootHTTPPort uint16
 }
 
 // VirtualMachine is the top-level type. It describes the virtual machine
 // configuration (bootloader, devices, ...).
 type VirtualMachine struct {
-	vcpus       uint
-	memoryBytes uint64
-	bootloader  Bootloader
-	devices     []VirtioDevice
+	vcpus          uint
+	memoryBytes    uint64
+	bootloader     Bootloader
+	devices        []VirtioDevice
+	deviceIDs      map[string]VirtioDevice
+	name           string
+	labels         map[string]string
+	stateDir       string
+	namingTemplate string
+	restfulURI     string
+	pidFile        string
+	daemonize      bool
+	cpuLimit       uint
+	qosClass       string
+	ioPriority     string
+	vfkitPath      string
+	vfkitVersion   *VfkitVersion
+
+	guestOS               string
+	genericPlatform       bool
+	machineIdentifierPath string
 }
 
 // The VMComponent interface represents a VM element (device, bootloader, ...)
@@ -49,6 +95,38 @@ type VMComponent interface {
 // The VirtioDevice interface is an interface which is implemented by all devices.
 type VirtioDevice VMComponent
 
+// optionList builds the comma separated option list of a --device or
+// --bootloader argument. Values are quoted when needed, so that paths with
+// commas or spaces are passed unchanged to vfkit.
+type optionList struct {
+	builder strings.Builder
+}
+
+func newOptionList(kind string) *optionList {
+	list := optionList{}
+	list.builder.WriteString(kind)
+	return &list
+}
+
+// Set adds the key=value option.
+func (list *optionList) Set(key string, value string) {
+	list.builder.WriteString(fmt.Sprintf(",%s=%s", key, util.QuoteOptionValue(value)))
+}
+
+// Setf adds the key=value option, with value formatted like fmt.Sprintf.
+func (list *optionList) Setf(key string, format string, args ...interface{}) {
+	list.Set(key, fmt.Sprintf(format, args...))
+}
+
+// Flag adds an option without value, such as 'nat'.
+func (list *optionList) Flag(key string) {
+	list.builder.WriteString("," + key)
+}
+
+func (list *optionList) String() string {
+	return list.builder.String()
+}
+
 // VirtioVsock configures of a virtio-vsock device allowing 2-way communication
 // between the host and the virtual machine type
 type VirtioVsock struct {
@@ -56,6 +134,7 @@ type VirtioVsock struct {
 	// details.
 	Port uint
 	// SocketURL is the path to a unix socket on the host to use for the virtio-vsock communication with the guest.
+	// When it's empty, vfkit generates it, see VirtualMachine.VsockSocketPath().
 	SocketURL string
 	// If true, vsock connections will have to be done from guest to host. If false, vsock connections will only be possible
 	// from host to guest
@@ -65,6 +144,17 @@ type VirtioVsock struct {
 // virtioBlk configures a disk device.
 type virtioBlk struct {
 	imagePath string
+	// ramSizeBytes is set by VirtioBlkNewRAM, imagePath is empty then
+	ramSizeBytes uint64
+}
+
+// cdrom configures an ISO image attached as a read-only USB disk.
+type cdrom struct {
+	imagePath string
+}
+
+// virtioBalloon configures a memory balloon device.
+type virtioBalloon struct {
 }
 
 // virtioRNG configures a random number generator (RNG) device.
@@ -73,25 +163,93 @@ type virtioRNG struct {
 
 // virtioNet configures the virtual machine networking.
 type virtioNet struct {
-	nat        bool
-	macAddress net.HardwareAddr
+	nat            bool
+	unixSocketPath string
+	macAddress     net.HardwareAddr
+	subnet         *net.IPNet
+	ipAddress      net.IP
+	hostname       string
+	options        NetOptions
+}
+
+// NetOptions are the optional settings of a network device. The zero value
+// uses the defaults of the virtualization framework.
+type NetOptions struct {
+	// MTU is the MTU of the device, between 1500 and 65535. It can only be
+	// set on devices using a unix socket, the MTU of the NAT network is
+	// 1500.
+	MTU uint
 }
 
 // virtioSerial configures the virtual machine serial ports.
 type virtioSerial struct {
 	logFile string
+	options SerialOptions
+}
+
+// SerialOptions are the optional settings of a serial device.
+type SerialOptions struct {
+	// Console makes vfkit listen on a unix socket where clients can attach
+	// to the serial console, see VirtualMachine.AttachConsole. The output
+	// of the guest is still written to the log file.
+	Console bool
+	// ConsoleSocket is the path of the console socket, vfkit generates it
+	// when it's empty (see VirtualMachine.SerialConsolePath).
+	ConsoleSocket string
+	// ID names the device in the paths vfkit generates and in 'vfkit attach
+	// --serial', instead of its index. It must start with a letter and only
+	// contain letters, digits, '-' and '_'.
+	ID string
+	// PTY connects the serial port to a pseudo-terminal instead of a log
+	// file, its path is reported as a "pty" listener by Inspect. It cannot
+	// be used with Console or a log file.
+	PTY bool
 }
 
+// serialIDRegexp matches the valid SerialOptions.ID values.
+var serialIDRegexp = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_-]*$`)
+
 // virtioFs configures directory sharing between the guest and the host.
 type virtioFs struct {
 	sharedDir string
 	mountTag  string
 }
 
+// PortForward forwards a host TCP port to the guest.
+type PortForward struct {
+	// HostAddress is the host address to listen on, vfkit uses 127.0.0.1
+	// when it's empty.
+	HostAddress string
+	// HostPort is the host TCP port to listen on.
+	HostPort uint16
+	// GuestPort is the guest TCP port, or vsock port when OverVsock is
+	// true, the connections are forwarded to.
+	GuestPort uint32
+	// OverVsock forwards the connections to a vsock port instead of using
+	// the guest IP address on the NAT network.
+	OverVsock bool
+}
+
 // timeSync enables synchronization of the host time to the linux guest after the host was suspended.
 // This requires qemu-guest-agent to be running in the guest, and to be listening on a vsock socket
 type timeSync struct {
 	vsockPort uint
+	options   TimeSyncOptions
+}
+
+// TimeSyncOptions are the optional settings of the guest time
+// synchronization.
+type TimeSyncOptions struct {
+	// Agent uses the vfkit guest agent instead of qemu-guest-agent.
+	Agent bool
+	// Interval enables the periodic correction of the guest clock drift,
+	// vfkit checks the guest clock every Interval, which must be at least
+	// one second. The guest clock is otherwise only set when the host wakes
+	// up from sleep.
+	Interval time.Duration
+	// Tolerance is the drift below which the guest clock is not changed by
+	// the periodic drift correction, vfkit uses 100ms when it's 0.
+	Tolerance time.Duration
 }
 
 // NewVirtualMachine creates a new VirtualMachine instance. The virtual machine
@@ -108,20 +266,80 @@ func NewVirtualMachine(vcpus uint, memoryBytes uint64, bootloader Bootloader) *V
 // ToCmdLine generates a list of arguments for use with the [os/exec] package.
 // These arguments will start a virtual machine with the devices/bootloader/...
 // described by vm If the virtual machine configuration described by vm is
-// invalid, an error will be returned.
+// invalid, an error will be returned. ToCmdLine stops at the first error it
+// finds, use Validate to get all of them.
+//
+// The arguments are in a stable order: the virtual machine settings, with
+// labels sorted by key, then the bootloader, then the devices in the order
+// they were added. Option values with commas, spaces or equal signs are
+// quoted so that vfkit parses them back unchanged.
+//
+// The first argument is the vfkit binary when SetVfkitPath was called. When
+// the vfkit version was detected with Version, the options it does not
+// support are an error matching ErrNotSupported.
 func (vm *VirtualMachine) ToCmdLine() ([]string, error) {
-	// TODO: missing binary name/path
 	args := []string{}
+	if vm.vfkitPath != "" {
+		args = append(args, vm.vfkitPath)
+	}
 
 	if vm.vcpus != 0 {
 		args = append(args, "--cpus", strconv.FormatUint(uint64(vm.vcpus), 10))
 	}
 	if vm.memoryBytes != 0 {
-		args = append(args, "--memory", strconv.FormatUint(vm.memoryBytes, 10))
+		if vm.memoryBytes%units.MiB != 0 {
+			return nil, fmt.Errorf("%w: memory size must be a multiple of 1 MiB", ErrInvalidConfig)
+		}
+		// vfkit interprets sizes without units as MiB
+		args = append(args, "--memory", strconv.FormatUint(vm.memoryBytes/units.MiB, 10))
+	}
+	if vm.name != "" {
+		args = append(args, "--name", vm.name)
+	}
+	labelKeys := []string{}
+	for key := range vm.labels {
+		labelKeys = append(labelKeys, key)
+	}
+	sort.Strings(labelKeys)
+	for _, key := range labelKeys {
+		args = append(args, "--label", fmt.Sprintf("%s=%s", key, vm.labels[key]))
+	}
+	if vm.stateDir != "" {
+		args = append(args, "--state-dir", vm.stateDir)
+	}
+	if vm.namingTemplate != "" {
+		args = append(args, "--naming-template", vm.namingTemplate)
+	}
+	if vm.restfulURI != "" {
+		args = append(args, "--restful-uri", vm.restfulURI)
+	}
+	if vm.pidFile != "" {
+		args = append(args, "--pidfile", vm.pidFile)
+	}
+	if vm.daemonize {
+		args = append(args, "--daemonize")
+	}
+	if vm.cpuLimit != 0 {
+		args = append(args, "--cpu-limit", strconv.FormatUint(uint64(vm.cpuLimit), 10))
+	}
+	if vm.qosClass != "" {
+		args = append(args, "--qos-class", vm.qosClass)
+	}
+	if vm.ioPriority != "" {
+		args = append(args, "--io-priority", vm.ioPriority)
+	}
+	if vm.genericPlatform {
+		args = append(args, "--platform", vm.platformCmdLine())
+	}
+	if vm.guestOS != "" {
+		args = append(args, "--guest-os", vm.guestOS)
 	}
 
 	if vm.bootloader == nil {
-		return nil, fmt.Errorf("missing bootloader configuration")
+		return nil, ErrMissingBootloader
+	}
+	if err := vm.checkVfkitVersions(); err != nil {
+		return nil, err
 	}
 	bootloaderArgs, err := vm.bootloader.ToCmdLine()
 	if err != nil {
@@ -140,6 +358,31 @@ func (vm *VirtualMachine) ToCmdLine() ([]string, error) {
 	return args, nil
 }
 
+// ToCmdLineString returns the arguments generated by ToCmdLine as a single
+// string, with the arguments quoted for a POSIX shell when needed. It's meant
+// for logging, use ToCmdLine to start vfkit.
+func (vm *VirtualMachine) ToCmdLineString() (string, error) {
+	args, err := vm.ToCmdLine()
+	if err != nil {
+		return "", err
+	}
+
+	return util.ShellQuote(args), nil
+}
+
+// SetMemory sets the amount of RAM allocated to the virtual machine. memory
+// is a human-readable size such as "2GiB" or "512MiB". For consistency with
+// the vfkit --memory argument, a size without unit is in MiB.
+func (vm *VirtualMachine) SetMemory(memory string) error {
+	memoryBytes, err := util.ParseMemorySize(memory)
+	if err != nil {
+		return err
+	}
+	vm.memoryBytes = memoryBytes
+
+	return nil
+}
+
 // AddDevice adds a dev to vm. This device can be created with one of the
 // VirtioXXXNew methods.
 func (vm *VirtualMachine) AddDevice(dev VirtioDevice) error {
@@ -148,6 +391,270 @@ func (vm *VirtualMachine) AddDevice(dev VirtioDevice) error {
 	return nil
 }
 
+// AddDeviceWithID adds dev to vm like AddDevice, and associates it with id.
+// The device can then be retrieved with DeviceByID and removed with
+// RemoveDevice. IDs are only known to the client package, they are not part
+// of the vfkit command line.
+func (vm *VirtualMachine) AddDeviceWithID(id string, dev VirtioDevice) error {
+	if id == "" {
+		return fmt.Errorf("%w: device ID cannot be empty", ErrInvalidConfig)
+	}
+	if _, ok := vm.deviceIDs[id]; ok {
+		return fmt.Errorf("%w: a device with ID '%s' already exists", ErrDuplicateDeviceID, id)
+	}
+	if vm.deviceIDs == nil {
+		vm.deviceIDs = map[string]VirtioDevice{}
+	}
+	vm.deviceIDs[id] = dev
+
+	return vm.AddDevice(dev)
+}
+
+// Devices returns the devices of vm, in the order they were added.
+func (vm *VirtualMachine) Devices() []VirtioDevice {
+	return append([]VirtioDevice{}, vm.devices...)
+}
+
+// DeviceByID returns the device added to vm with AddDeviceWithID(id, ...).
+func (vm *VirtualMachine) DeviceByID(id string) (VirtioDevice, bool) {
+	dev, ok := vm.deviceIDs[id]
+	return dev, ok
+}
+
+// RemoveDevice removes the device added to vm with AddDeviceWithID(id, ...).
+func (vm *VirtualMachine) RemoveDevice(id string) error {
+	dev, ok := vm.deviceIDs[id]
+	if !ok {
+		return fmt.Errorf("%w: no device with ID '%s'", ErrDeviceNotFound, id)
+	}
+	delete(vm.deviceIDs, id)
+	for i, d := range vm.devices {
+		if d == dev {
+			vm.devices = append(vm.devices[:i], vm.devices[i+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
+
+// VirtioVsockDevices returns the virtio-vsock devices of vm.
+func (vm *VirtualMachine) VirtioVsockDevices() []*VirtioVsock {
+	vsockDevs := []*VirtioVsock{}
+	for _, dev := range vm.devices {
+		if vsockDev, isVirtioVsock := dev.(*VirtioVsock); isVirtioVsock {
+			vsockDevs = append(vsockDevs, vsockDev)
+		}
+	}
+
+	return vsockDevs
+}
+
+// PortForwards returns the port forwards of vm.
+func (vm *VirtualMachine) PortForwards() []*PortForward {
+	forwards := []*PortForward{}
+	for _, dev := range vm.devices {
+		if pf, isPortForward := dev.(*PortForward); isPortForward {
+			forwards = append(forwards, pf)
+		}
+	}
+
+	return forwards
+}
+
+// DiskImagePaths returns the paths to the disk images used by the virtio-blk
+// devices of vm. RAM disks are not included.
+func (vm *VirtualMachine) DiskImagePaths() []string {
+	paths := []string{}
+	for _, dev := range vm.devices {
+		if blkDev, isVirtioBlk := dev.(*virtioBlk); isVirtioBlk && blkDev.imagePath != "" {
+			paths = append(paths, blkDev.imagePath)
+		}
+	}
+
+	return paths
+}
+
+// SharedDirectories returns the host directories shared by the virtio-fs
+// devices of vm.
+func (vm *VirtualMachine) SharedDirectories() []string {
+	dirs := []string{}
+	for _, dev := range vm.devices {
+		if fsDev, isVirtioFs := dev.(*virtioFs); isVirtioFs {
+			dirs = append(dirs, fsDev.sharedDir)
+		}
+	}
+
+	return dirs
+}
+
+// SetName sets the name of the virtual machine. It is used to name the host
+// artifacts (unix sockets, log files, ...) which vfkit generates.
+func (vm *VirtualMachine) SetName(name string) {
+	vm.name = name
+}
+
+// SetLabel sets a key=value label on the virtual machine. Labels are listed
+// by 'vfkit inventory', along with the other information vfkit records about
+// named virtual machines.
+func (vm *VirtualMachine) SetLabel(key string, value string) {
+	if vm.labels == nil {
+		vm.labels = map[string]string{}
+	}
+	vm.labels[key] = value
+}
+
+// SetStateDir sets the directory where vfkit stores the host artifacts it
+// generates.
+func (vm *VirtualMachine) SetStateDir(stateDir string) {
+	vm.stateDir = stateDir
+}
+
+// SetNamingTemplate sets the template vfkit uses to generate host artifact
+// paths. See the naming package for the supported placeholders.
+func (vm *VirtualMachine) SetNamingTemplate(template string) {
+	vm.namingTemplate = template
+}
+
+func (vm *VirtualMachine) linuxBootloader() (*linuxBootloader, error) {
+	bootloader, ok := vm.bootloader.(*linuxBootloader)
+	if !ok {
+		return nil, fmt.Errorf("%w: the kernel command line can only be changed with a linux bootloader", ErrInvalidConfig)
+	}
+	return bootloader, nil
+}
+
+// AppendKernelArg appends key=value to the kernel command line of the linux
+// bootloader of vm, for example to add 'console=hvc0'. Only key is appended
+// when value is empty.
+func (vm *VirtualMachine) AppendKernelArg(key string, value string) error {
+	bootloader, err := vm.linuxBootloader()
+	if err != nil {
+		return err
+	}
+	bootloader.AppendKernelArg(key, value)
+	return nil
+}
+
+// SetKernelArg sets the value of key on the kernel command line of the linux
+// bootloader of vm, replacing its current value if any.
+func (vm *VirtualMachine) SetKernelArg(key string, value string) error {
+	bootloader, err := vm.linuxBootloader()
+	if err != nil {
+		return err
+	}
+	bootloader.SetKernelArg(key, value)
+	return nil
+}
+
+// SetRestfulURI enables the vfkit REST API on restfulURI, which can be
+// tcp://host:port or unix:///path/to/socket.
+func (vm *VirtualMachine) SetRestfulURI(restfulURI string) {
+	vm.restfulURI = restfulURI
+}
+
+// RestClient returns a client for the REST API of the vfkit instance started
+// for vm. SetRestfulURI must have been called first.
+func (vm *VirtualMachine) RestClient() (*RestClient, error) {
+	if vm.restfulURI == "" {
+		return nil, ErrRestAPIDisabled
+	}
+	return NewRestClient(vm.restfulURI)
+}
+
+// Inspect returns the effective configuration of the running virtual machine
+// from its REST API: the configuration of vm completed with the values vfkit
+// generated, such as random MAC addresses, host artifact paths, and the
+// sockets it listens on. SetRestfulURI must have been called first.
+func (vm *VirtualMachine) Inspect(ctx context.Context) (*define.VMConfig, error) {
+	restClient, err := vm.RestClient()
+	if err != nil {
+		return nil, err
+	}
+	inspect, err := restClient.Inspect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if inspect.Config == nil {
+		return nil, fmt.Errorf("%w: the vfkit instance does not report its configuration", ErrNotSupported)
+	}
+
+	return inspect.Config, nil
+}
+
+// SnapshotDisk snapshots the disk with the given ID of the running virtual
+// machine from its REST API. The virtual machine is paused while the disk
+// image is cloned. SetRestfulURI must have been called first.
+func (vm *VirtualMachine) SnapshotDisk(ctx context.Context, id string) (*define.Snapshot, error) {
+	restClient, err := vm.RestClient()
+	if err != nil {
+		return nil, err
+	}
+	return restClient.CreateDiskSnapshot(ctx, id, "")
+}
+
+// RestoreDiskSnapshot restores a snapshot taken with SnapshotDisk. The virtual
+// machine must be stopped. SetRestfulURI must have been called first.
+func (vm *VirtualMachine) RestoreDiskSnapshot(ctx context.Context, id string, snapshotID string) error {
+	restClient, err := vm.RestClient()
+	if err != nil {
+		return err
+	}
+	return restClient.RestoreDiskSnapshot(ctx, id, snapshotID)
+}
+
+// NamingTemplate returns the template vfkit will use to generate the paths of
+// host artifacts which were not explicitly configured.
+func (vm *VirtualMachine) NamingTemplate() (*naming.Template, error) {
+	return naming.NewTemplate(vm.namingTemplate, vm.stateDir, vm.name)
+}
+
+// VsockSocketPath returns the path of the unix socket vfkit will use for a
+// virtio-vsock device on port created without a socket URL.
+func (vm *VirtualMachine) VsockSocketPath(port uint) (string, error) {
+	tmpl, err := vm.NamingTemplate()
+	if err != nil {
+		return "", err
+	}
+	return tmpl.Path(naming.VsockDeviceID(port), "sock"), nil
+}
+
+// SerialLogPath returns the path of the log file vfkit will use for the
+// index-th virtio-serial device if it was created without a log file path.
+func (vm *VirtualMachine) SerialLogPath(index int) (string, error) {
+	return vm.serialArtifactPath(index, "log")
+}
+
+// SerialConsolePath returns the path of the console socket vfkit will use for
+// the index-th virtio-serial device if it was created with the Console option
+// and without a socket path.
+func (vm *VirtualMachine) SerialConsolePath(index int) (string, error) {
+	return vm.serialArtifactPath(index, "sock")
+}
+
+// serialArtifactPath returns the path of the artifact with extension ext of
+// the index-th virtio-serial device, which is named after its ID when it has
+// one.
+func (vm *VirtualMachine) serialArtifactPath(index int, ext string) (string, error) {
+	tmpl, err := vm.NamingTemplate()
+	if err != nil {
+		return "", err
+	}
+	deviceID := naming.SerialDeviceID(index)
+	serialIndex := 0
+	for _, dev := range vm.devices {
+		serialDev, isSerial := dev.(*virtioSerial)
+		if !isSerial {
+			continue
+		}
+		if serialIndex == index && serialDev.options.ID != "" {
+			deviceID = naming.NamedSerialDeviceID(serialDev.options.ID)
+		}
+		serialIndex++
+	}
+	return tmpl.Path(deviceID, ext), nil
+}
+
 // NewLinuxBootloader creates a new bootloader to start a VM with the file at
 // vmlinuzPath as the kernel, kernelCmdLine as the kernel command line, and the
 // file at initrdPath as the initrd. On ARM64, the kernel must be uncompressed
@@ -170,20 +677,46 @@ func NewEFIBootloader(efiVariableStorePath string, create bool) Bootloader {
 	}
 }
 
+// NewEFIBootloaderWithOptions creates a new EFI bootloader like
+// NewEFIBootloader, with the netboot settings of options.
+func NewEFIBootloaderWithOptions(efiVariableStorePath string, create bool, options EFIOptions) (Bootloader, error) {
+	if options.NetbootDir == "" && (options.NetbootTFTPPort != 0 || options.NetbootHTTPPort != 0) {
+		return nil, invalidDevice("efi bootloader", "netboot", "the netboot ports need a netboot directory")
+	}
+
+	return &efiBootloader{
+		efiVariableStorePath: efiVariableStorePath,
+		createVariableStore:  create,
+		options:              options,
+	}, nil
+}
+
+// AppendKernelArg appends key=value to the kernel command line. Only key is
+// appended when value is empty.
+func (bootloader *linuxBootloader) AppendKernelArg(key string, value string) {
+	bootloader.kernelCmdLine = util.AppendKernelArg(bootloader.kernelCmdLine, key, value)
+}
+
+// SetKernelArg sets the value of key on the kernel command line, replacing
+// its current value if any.
+func (bootloader *linuxBootloader) SetKernelArg(key string, value string) {
+	bootloader.kernelCmdLine = util.SetKernelArg(bootloader.kernelCmdLine, key, value)
+}
+
 func (bootloader *linuxBootloader) ToCmdLine() ([]string, error) {
 	args := []string{}
 	if bootloader.vmlinuzPath == "" {
-		return nil, fmt.Errorf("Missing kernel path")
+		return nil, invalidDevice("linux bootloader", "kernel", "missing kernel path")
 	}
 	args = append(args, "--kernel", bootloader.vmlinuzPath)
 
 	if bootloader.initrdPath == "" {
-		return nil, fmt.Errorf("Missing initrd path")
+		return nil, invalidDevice("linux bootloader", "initrd", "missing initrd path")
 	}
 	args = append(args, "--initrd", bootloader.initrdPath)
 
 	if bootloader.kernelCmdLine == "" {
-		return nil, fmt.Errorf("Missing kernel command line")
+		return nil, invalidDevice("linux bootloader", "cmdline", "missing kernel command line")
 	}
 	args = append(args, "--kernel-cmdline", bootloader.kernelCmdLine)
 
@@ -192,22 +725,34 @@ func (bootloader *linuxBootloader) ToCmdLine() ([]string, error) {
 
 func (bootloader *efiBootloader) ToCmdLine() ([]string, error) {
 	if bootloader.efiVariableStorePath == "" {
-		return nil, fmt.Errorf("Missing EFI store path")
+		return nil, invalidDevice("efi bootloader", "variable-store", "missing EFI store path")
 	}
 
-	builder := strings.Builder{}
-	builder.WriteString("efi")
-	builder.WriteString(fmt.Sprintf(",variable-store=%s", bootloader.efiVariableStorePath))
+	options := newOptionList("efi")
+	options.Set("variable-store", bootloader.efiVariableStorePath)
 	if bootloader.createVariableStore {
-		builder.WriteString(",create")
+		options.Flag("create")
+	}
+	if bootloader.options.NetbootDir != "" {
+		netboot := newOptionList("")
+		netboot.Set("dir", bootloader.options.NetbootDir)
+		if bootloader.options.NetbootTFTPPort != 0 {
+			netboot.Setf("tftpPort", "%d", bootloader.options.NetbootTFTPPort)
+		}
+		if bootloader.options.NetbootHTTPPort != 0 {
+			netboot.Setf("httpPort", "%d", bootloader.options.NetbootHTTPPort)
+		}
+		// the netboot options are a quoted option list
+		options.Set("netboot", strings.TrimPrefix(netboot.String(), ","))
 	}
 
-	return []string{"--bootloader", builder.String()}, nil
+	return []string{"--bootloader", options.String()}, nil
 }
 
 // VirtioVsockNew creates a new virtio-vsock device for 2-way communication
 // between the host and the virtual machine. The communication will happen on
-// vsock port, and on the host it will use the unix socket at socketURL.
+// vsock port, and on the host it will use the unix socket at socketURL. If
+// socketURL is empty, vfkit will generate it (see VirtualMachine.VsockSocketPath).
 // When listen is true, the host will be listening for connections over vsock.
 // When listen  is false, the guest will be listening for connections over vsock.
 func VirtioVsockNew(port uint, socketURL string, listen bool) (VirtioDevice, error) {
@@ -219,16 +764,21 @@ func VirtioVsockNew(port uint, socketURL string, listen bool) (VirtioDevice, err
 }
 
 func (dev *VirtioVsock) ToCmdLine() ([]string, error) {
-	if dev.Port == 0 || dev.SocketURL == "" {
-		return nil, fmt.Errorf("virtio-vsock needs both a port and a socket URL")
+	if dev.Port == 0 {
+		return nil, invalidDevice("virtio-vsock", "port", "a port is needed")
+	}
+	options := newOptionList("virtio-vsock")
+	options.Setf("port", "%d", dev.Port)
+	if dev.SocketURL != "" {
+		options.Set("socketURL", dev.SocketURL)
 	}
-	var listenStr string
 	if dev.Listen {
-		listenStr = "listen"
+		options.Flag("listen")
 	} else {
-		listenStr = "connect"
+		options.Flag("connect")
 	}
-	return []string{"--device", fmt.Sprintf("virtio-vsock,port=%d,socketURL=%s,%s", dev.Port, dev.SocketURL, listenStr)}, nil
+
+	return []string{"--device", options.String()}, nil
 }
 
 // VirtioBlkNew creates a new disk to use in the virtual machine. It will use
@@ -239,11 +789,62 @@ func VirtioBlkNew(imagePath string) (VirtioDevice, error) {
 	}, nil
 }
 
+// VirtioBlkNewRAM creates a RAM disk of sizeBytes bytes, whose content is
+// discarded when vfkit exits. It's meant for the temporary files of CI jobs.
+// vfkit backs it with a RAM device created by hdiutil, its content is never
+// written to the host disk. sizeBytes must be a multiple of 1 MiB.
+func VirtioBlkNewRAM(sizeBytes uint64) (VirtioDevice, error) {
+	if sizeBytes == 0 || sizeBytes%units.MiB != 0 {
+		return nil, invalidDevice("virtio-blk", "ram", "the size must be a non-zero multiple of 1 MiB")
+	}
+	return &virtioBlk{
+		ramSizeBytes: sizeBytes,
+	}, nil
+}
+
 func (dev *virtioBlk) ToCmdLine() ([]string, error) {
+	options := newOptionList("virtio-blk")
+	switch {
+	case dev.ramSizeBytes != 0:
+		options.Setf("ram", "%dMiB", dev.ramSizeBytes/units.MiB)
+	case dev.imagePath == "":
+		return nil, invalidDevice("virtio-blk", "path", "the path to a disk image is needed")
+	default:
+		options.Set("path", dev.imagePath)
+	}
+
+	return []string{"--device", options.String()}, nil
+}
+
+// CdromNew attaches the ISO image at imagePath as a read-only USB mass storage
+// device. vfkit attaches it before the virtio-blk disks, so that the EFI
+// firmware boots the installer it contains. USB mass storage devices need
+// macOS 13 or newer.
+func CdromNew(imagePath string) (VirtioDevice, error) {
+	return &cdrom{
+		imagePath: imagePath,
+	}, nil
+}
+
+func (dev *cdrom) ToCmdLine() ([]string, error) {
 	if dev.imagePath == "" {
-		return nil, fmt.Errorf("virtio-blk needs the path to a disk image")
+		return nil, invalidDevice("cdrom", "path", "the path to an ISO image is needed")
 	}
-	return []string{"--device", fmt.Sprintf("virtio-blk,path=%s", dev.imagePath)}, nil
+	options := newOptionList("usb-mass-storage")
+	options.Set("path", dev.imagePath)
+	options.Flag("readonly")
+
+	return []string{"--device", options.String()}, nil
+}
+
+// VirtioBalloonNew creates a new memory balloon device, the guest driver can
+// give memory back to the host.
+func VirtioBalloonNew() (VirtioDevice, error) {
+	return &virtioBalloon{}, nil
+}
+
+func (dev *virtioBalloon) ToCmdLine() ([]string, error) {
+	return []string{"--device", "virtio-balloon"}, nil
 }
 
 // VirtioRNGNew creates a new random number generator device to feed entropy
@@ -273,34 +874,147 @@ func VirtioNetNew(macAddress string) (VirtioDevice, error) {
 	}, nil
 }
 
+// VirtioNetUnixSocketNew creates a new network device for the virtual machine
+// sending its traffic to the unixgram socket at unixSocketPath, such as the
+// -listen-vfkit socket of gvproxy (see the gvproxy package). It will use
+// macAddress as its MAC address.
+func VirtioNetUnixSocketNew(unixSocketPath string, macAddress string) (VirtioDevice, error) {
+	dev, err := VirtioNetNew(macAddress)
+	if err != nil {
+		return nil, err
+	}
+	netDev := dev.(*virtioNet)
+	netDev.nat = false
+	netDev.unixSocketPath = unixSocketPath
+
+	return netDev, nil
+}
+
+// VirtioNetStaticLeaseNew creates a new NAT network device for the virtual
+// machine which gets ipAddress, and hostname when it's not empty, from the
+// macOS DHCP server. vfkit does not start the virtual machine unless the
+// host table of this server, /etc/bootptab, has the corresponding static
+// lease (see the dhcp package). subnet is optional, when set vfkit also
+// checks that the NAT network of the host uses it.
+func VirtioNetStaticLeaseNew(macAddress string, ipAddress string, hostname string, subnet string) (VirtioDevice, error) {
+	if macAddress == "" {
+		return nil, invalidDevice("virtio-net", "mac", "a static DHCP lease needs a MAC address")
+	}
+	dev, err := VirtioNetNew(macAddress)
+	if err != nil {
+		return nil, err
+	}
+	netDev := dev.(*virtioNet)
+	if netDev.ipAddress = net.ParseIP(ipAddress).To4(); netDev.ipAddress == nil {
+		return nil, invalidDevice("virtio-net", "ip", "invalid IPv4 address %s", ipAddress)
+	}
+	netDev.hostname = hostname
+	if subnet != "" {
+		if _, netDev.subnet, err = net.ParseCIDR(subnet); err != nil {
+			return nil, err
+		}
+		if !netDev.subnet.Contains(netDev.ipAddress) {
+			return nil, invalidDevice("virtio-net", "ip", "%s is not in subnet %s", ipAddress, subnet)
+		}
+	}
+
+	return netDev, nil
+}
+
+// VirtioNetUnixSocketNewWithOptions creates a new network device like
+// VirtioNetUnixSocketNew, with the MTU of options.
+func VirtioNetUnixSocketNewWithOptions(unixSocketPath string, macAddress string, options NetOptions) (VirtioDevice, error) {
+	if options.MTU != 0 && (options.MTU < 1500 || options.MTU > 65535) {
+		return nil, invalidDevice("virtio-net", "mtu", "%d is not between 1500 and 65535", options.MTU)
+	}
+	dev, err := VirtioNetUnixSocketNew(unixSocketPath, macAddress)
+	if err != nil {
+		return nil, err
+	}
+	dev.(*virtioNet).options = options
+
+	return dev, nil
+}
+
 func (dev *virtioNet) ToCmdLine() ([]string, error) {
-	if !dev.nat {
-		return nil, fmt.Errorf("virtio-net only support 'nat' networking")
+	options := newOptionList("virtio-net")
+	switch {
+	case dev.unixSocketPath != "":
+		options.Set("unixSocketPath", dev.unixSocketPath)
+	case dev.nat:
+		options.Flag("nat")
+	default:
+		return nil, invalidDevice("virtio-net", "nat", "'nat' or a unix socket path is needed")
 	}
-	builder := strings.Builder{}
-	builder.WriteString("virtio-net")
-	builder.WriteString(",nat")
 	if len(dev.macAddress) != 0 {
-		builder.WriteString(fmt.Sprintf(",mac=%s", dev.macAddress))
+		options.Set("mac", dev.macAddress.String())
+	}
+	if dev.subnet != nil {
+		options.Set("subnet", dev.subnet.String())
+	}
+	if dev.ipAddress != nil {
+		options.Set("ip", dev.ipAddress.String())
+	}
+	if dev.hostname != "" {
+		options.Set("hostname", dev.hostname)
+	}
+	if dev.options.MTU != 0 {
+		if dev.unixSocketPath == "" {
+			return nil, invalidDevice("virtio-net", "mtu", "the MTU can only be set on devices using a unix socket")
+		}
+		options.Setf("mtu", "%d", dev.options.MTU)
 	}
 
-	return []string{"--device", builder.String()}, nil
+	return []string{"--device", options.String()}, nil
 }
 
 // VirtioSerialNew creates a new serial device for the virtual machine. The
 // output the virtual machine sent to the serial port will be written to the
-// file at logFilePath.
+// file at logFilePath. If logFilePath is empty, vfkit will generate it (see
+// VirtualMachine.SerialLogPath).
 func VirtioSerialNew(logFilePath string) (VirtioDevice, error) {
 	return &virtioSerial{
 		logFile: logFilePath,
 	}, nil
 }
 
+// VirtioSerialNewWithOptions creates a new serial device like
+// VirtioSerialNew, with the console settings of options.
+func VirtioSerialNewWithOptions(logFilePath string, options SerialOptions) (VirtioDevice, error) {
+	if options.ConsoleSocket != "" && !options.Console {
+		return nil, invalidDevice("virtio-serial", "consoleSocket", "a console socket needs the Console option")
+	}
+	if options.ID != "" && !serialIDRegexp.MatchString(options.ID) {
+		return nil, invalidDevice("virtio-serial", "id", "invalid ID '%s', it must start with a letter and only contain letters, digits, '-' and '_'", options.ID)
+	}
+	if options.PTY && (options.Console || logFilePath != "") {
+		return nil, invalidDevice("virtio-serial", "pty", "a pseudo-terminal cannot be used with a console or a log file")
+	}
+
+	return &virtioSerial{
+		logFile: logFilePath,
+		options: options,
+	}, nil
+}
+
 func (dev *virtioSerial) ToCmdLine() ([]string, error) {
-	if dev.logFile == "" {
-		return nil, fmt.Errorf("virtio-serial needs the path to the log file")
+	options := newOptionList("virtio-serial")
+	if dev.options.ID != "" {
+		options.Set("id", dev.options.ID)
+	}
+	if dev.logFile != "" {
+		options.Set("logFilePath", dev.logFile)
+	}
+	if dev.options.ConsoleSocket != "" {
+		options.Set("consoleSocket", dev.options.ConsoleSocket)
+	} else if dev.options.Console {
+		options.Flag("console")
+	}
+	if dev.options.PTY {
+		options.Flag("pty")
 	}
-	return []string{"--device", fmt.Sprintf("virtio-serial,logFilePath=%s", dev.logFile)}, nil
+
+	return []string{"--device", options.String()}, nil
 }
 
 // VirtioFsNew creates a new virtio-fs device for file sharing. It will share
@@ -315,13 +1029,45 @@ func VirtioFsNew(sharedDir string, mountTag string) (VirtioDevice, error) {
 
 func (dev *virtioFs) ToCmdLine() ([]string, error) {
 	if dev.sharedDir == "" {
-		return nil, fmt.Errorf("virtio-fs needs the path to the directory to share")
+		return nil, invalidDevice("virtio-fs", "sharedDir", "the path to the directory to share is needed")
 	}
+	options := newOptionList("virtio-fs")
+	options.Set("sharedDir", dev.sharedDir)
 	if dev.mountTag != "" {
-		return []string{"--device", fmt.Sprintf("virtio-fs,sharedDir=%s,mountTag=%s", dev.sharedDir, dev.mountTag)}, nil
-	} else {
-		return []string{"--device", fmt.Sprintf("virtio-fs,sharedDir=%s", dev.sharedDir)}, nil
+		options.Set("mountTag", dev.mountTag)
 	}
+
+	return []string{"--device", options.String()}, nil
+}
+
+// PortForwardNew forwards connections to the host TCP port hostPort to the
+// guest TCP port guestPort. The guest IP address on the NAT network is looked
+// up using the MAC address of the first virtio-net device, which must be set
+// explicitly.
+func PortForwardNew(hostPort uint16, guestPort uint32) (VMComponent, error) {
+	return &PortForward{
+		HostPort:  hostPort,
+		GuestPort: guestPort,
+	}, nil
+}
+
+func (pf *PortForward) ToCmdLine() ([]string, error) {
+	if pf.HostPort == 0 || pf.GuestPort == 0 {
+		return nil, invalidDevice("port forward", "publish", "a host port and a guest port are needed")
+	}
+	if !pf.OverVsock && pf.GuestPort > math.MaxUint16 {
+		return nil, invalidDevice("port forward", "publish", "invalid guest TCP port %d", pf.GuestPort)
+	}
+	publish := fmt.Sprintf("%d:%d", pf.HostPort, pf.GuestPort)
+	if pf.HostAddress != "" {
+		// IPv6 addresses are enclosed in brackets
+		publish = net.JoinHostPort(pf.HostAddress, publish)
+	}
+	if pf.OverVsock {
+		publish += "/vsock"
+	}
+
+	return []string{"--publish", publish}, nil
 }
 
 func TimeSyncNew(vsockPort uint) (VMComponent, error) {
@@ -330,10 +1076,35 @@ func TimeSyncNew(vsockPort uint) (VMComponent, error) {
 	}, nil
 }
 
+// TimeSyncNewWithOptions enables the guest time synchronization like
+// TimeSyncNew, with the guest agent and drift correction settings of options.
+func TimeSyncNewWithOptions(vsockPort uint, options TimeSyncOptions) (VMComponent, error) {
+	if options.Interval != 0 && options.Interval < time.Second {
+		return nil, invalidDevice("timesync", "interval", "the interval must be at least 1s")
+	}
+	if options.Tolerance != 0 && options.Interval == 0 {
+		return nil, invalidDevice("timesync", "tolerance", "the tolerance needs an interval")
+	}
+
+	return &timeSync{
+		vsockPort: vsockPort,
+		options:   options,
+	}, nil
+}
+
 func (ts *timeSync) ToCmdLine() ([]string, error) {
 	args := []string{}
 	if ts.vsockPort != 0 {
 		args = append(args, fmt.Sprintf("vsockPort=%d", ts.vsockPort))
 	}
+	if ts.options.Agent {
+		args = append(args, "agent")
+	}
+	if ts.options.Interval != 0 {
+		args = append(args, fmt.Sprintf("interval=%s", ts.options.Interval))
+	}
+	if ts.options.Tolerance != 0 {
+		args = append(args, fmt.Sprintf("tolerance=%s", ts.options.Tolerance))
+	}
 	return []string{"--timesync", strings.Join(args, ",")}, nil
 }
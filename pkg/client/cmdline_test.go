@@ -0,0 +1,303 @@
+package client
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPortForwardCmdLine(t *testing.T) {
+	pf := &PortForward{HostAddress: "::1", HostPort: 8080, GuestPort: 80}
+	args, err := pf.ToCmdLine()
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if len(args) != 2 || args[1] != "[::1]:8080:80" {
+		t.Fatalf("unexpected arguments: %v", args)
+	}
+
+	pf = &PortForward{HostPort: 8080, GuestPort: 65536}
+	if _, err := pf.ToCmdLine(); err == nil {
+		t.Fatal("expected error for an out of range guest TCP port")
+	}
+	pf.OverVsock = true
+	if _, err := pf.ToCmdLine(); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+}
+
+func TestVirtioBlkRAMCmdLine(t *testing.T) {
+	disk, err := VirtioBlkNewRAM(2 * 1024 * 1024 * 1024)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	args, err := disk.ToCmdLine()
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if len(args) != 2 || args[1] != "virtio-blk,ram=2048MiB" {
+		t.Fatalf("unexpected arguments: %v", args)
+	}
+
+	if _, err := VirtioBlkNewRAM(1000); err == nil {
+		t.Fatal("expected error for a size which is not a multiple of 1 MiB")
+	}
+}
+
+func TestVirtioNetUnixSocketCmdLine(t *testing.T) {
+	dev, err := VirtioNetUnixSocketNew("/tmp/gvproxy.sock", "5a:94:ef:e4:0c:ee")
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	args, err := dev.ToCmdLine()
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if len(args) != 2 || args[1] != "virtio-net,unixSocketPath=/tmp/gvproxy.sock,mac=5a:94:ef:e4:0c:ee" {
+		t.Fatalf("unexpected arguments: %v", args)
+	}
+}
+
+func TestVirtioNetOptionsCmdLine(t *testing.T) {
+	dev, err := VirtioNetUnixSocketNewWithOptions("/tmp/gvproxy.sock", "", NetOptions{MTU: 9000})
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	args, err := dev.ToCmdLine()
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if len(args) != 2 || args[1] != "virtio-net,unixSocketPath=/tmp/gvproxy.sock,mtu=9000" {
+		t.Fatalf("unexpected arguments: %v", args)
+	}
+
+	if _, err := VirtioNetUnixSocketNewWithOptions("/tmp/gvproxy.sock", "", NetOptions{MTU: 576}); err == nil {
+		t.Fatal("expected error for an MTU below 1500")
+	}
+}
+
+func TestVirtioNetStaticLeaseCmdLine(t *testing.T) {
+	dev, err := VirtioNetStaticLeaseNew("52:54:00:70:2b:71", "192.168.200.10", "myvm", "192.168.200.0/24")
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	args, err := dev.ToCmdLine()
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if len(args) != 2 || args[1] != "virtio-net,nat,mac=52:54:00:70:2b:71,subnet=192.168.200.0/24,ip=192.168.200.10,hostname=myvm" {
+		t.Fatalf("unexpected arguments: %v", args)
+	}
+
+	if _, err := VirtioNetStaticLeaseNew("52:54:00:70:2b:71", "192.168.64.10", "", "192.168.200.0/24"); err == nil {
+		t.Fatal("expected error for an IP address outside of the subnet")
+	}
+	if _, err := VirtioNetStaticLeaseNew("", "192.168.64.10", "", ""); err == nil {
+		t.Fatal("expected error without MAC address")
+	}
+}
+
+func TestKernelArgs(t *testing.T) {
+	vm := NewVirtualMachine(1, 512*1024*1024, NewLinuxBootloader("vmlinuz", "root=/dev/vda console=tty0", "initrd"))
+	if err := vm.AppendKernelArg("ignition.config.url", "http://192.168.64.1/config.ign"); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if err := vm.SetKernelArg("console", "hvc0"); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if err := vm.AppendKernelArg("dyndbg", "file drivers/* +p"); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if err := vm.SetKernelArg("quiet", ""); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	args, err := vm.bootloader.ToCmdLine()
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	expected := `root=/dev/vda console=hvc0 ignition.config.url=http://192.168.64.1/config.ign dyndbg="file drivers/* +p" quiet`
+	if args[len(args)-1] != expected {
+		t.Fatalf("unexpected kernel command line: %s", args[len(args)-1])
+	}
+
+	vm = NewVirtualMachine(1, 512*1024*1024, NewEFIBootloader("efi-store", true))
+	if err := vm.AppendKernelArg("console", "hvc0"); err == nil {
+		t.Fatal("expected error with an EFI bootloader")
+	}
+}
+
+func TestDeviceIDs(t *testing.T) {
+	vm := NewVirtualMachine(1, 512*1024*1024, NewLinuxBootloader("vmlinuz", "console=hvc0", "initrd"))
+	rng, _ := VirtioRNGNew()
+	_ = vm.AddDevice(rng)
+	vsock, _ := VirtioVsockNew(1024, "/tmp/vsock.sock", false)
+	if err := vm.AddDeviceWithID("vsock", vsock); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if err := vm.AddDeviceWithID("vsock", vsock); err == nil {
+		t.Fatal("expected error for a duplicate device ID")
+	}
+	disk, _ := VirtioBlkNew("/tmp/disk.img")
+	if err := vm.AddDeviceWithID("disk", disk); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+
+	if dev, ok := vm.DeviceByID("vsock"); !ok || dev != vsock {
+		t.Fatalf("unexpected device for ID 'vsock': %v", dev)
+	}
+	if len(vm.VirtioVsockDevices()) != 1 || len(vm.DiskImagePaths()) != 1 {
+		t.Fatalf("unexpected devices: %v", vm.Devices())
+	}
+	if err := vm.RemoveDevice("vsock"); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if err := vm.RemoveDevice("vsock"); err == nil {
+		t.Fatal("expected error when removing a missing device")
+	}
+	devices := vm.Devices()
+	if len(devices) != 2 || devices[0] != rng || devices[1] != disk {
+		t.Fatalf("unexpected devices: %v", devices)
+	}
+}
+
+func TestLabelsCmdLine(t *testing.T) {
+	vm := NewVirtualMachine(1, 512*1024*1024, NewLinuxBootloader("vmlinuz", "console=hvc0", "initrd"))
+	vm.SetName("web")
+	vm.SetLabel("team", "infra")
+	vm.SetLabel("asset-tag", "A-42")
+	args, err := vm.ToCmdLine()
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if !strings.Contains(strings.Join(args, " "), "--name web --label asset-tag=A-42 --label team=infra") {
+		t.Fatalf("unexpected command line: %v", args)
+	}
+}
+
+func TestThrottleCmdLine(t *testing.T) {
+	vm := NewVirtualMachine(1, 512*1024*1024, NewLinuxBootloader("vmlinuz", "console=hvc0", "initrd"))
+	vm.SetCPULimit(50)
+	if err := vm.SetQoSClass("background"); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if err := vm.SetIOPriority("realtime"); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("expected ErrInvalidConfig for invalid I/O priority; got %v", err)
+	}
+	if err := vm.SetIOPriority("throttle"); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	args, err := vm.ToCmdLine()
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if !strings.Contains(strings.Join(args, " "), "--cpu-limit 50 --qos-class background --io-priority throttle") {
+		t.Fatalf("unexpected command line: %v", args)
+	}
+}
+
+func TestQuotedCmdLine(t *testing.T) {
+	vm := NewVirtualMachine(1, 512*1024*1024, NewEFIBootloader("/Users/virtuser/VM Store/efi,vars", true))
+	dev, _ := VirtioBlkNew(`/Users/virtuser/VM Store/disk "1".img`)
+	_ = vm.AddDevice(dev)
+	dev, _ = VirtioFsNew("/Users/virtuser/a=b", "src")
+	_ = vm.AddDevice(dev)
+	args, err := vm.ToCmdLine()
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	expected := []string{
+		"--cpus", "1",
+		"--memory", "512",
+		"--bootloader", `efi,variable-store="/Users/virtuser/VM Store/efi,vars",create`,
+		"--device", `virtio-blk,path="/Users/virtuser/VM Store/disk \"1\".img"`,
+		"--device", `virtio-fs,sharedDir="/Users/virtuser/a=b",mountTag=src`,
+	}
+	if strings.Join(args, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("unexpected arguments: %q", args)
+	}
+
+	cmdline, err := vm.ToCmdLineString()
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if !strings.HasPrefix(cmdline, `--cpus 1 --memory 512 --bootloader 'efi,variable-store="/Users/virtuser/VM Store/efi,vars",create' --device`) {
+		t.Fatalf("unexpected command line: %s", cmdline)
+	}
+}
+
+func TestCdromCmdLine(t *testing.T) {
+	dev, err := CdromNew("/Users/virtuser/Downloads/fedora.iso")
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	args, err := dev.ToCmdLine()
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if len(args) != 2 || args[1] != "usb-mass-storage,path=/Users/virtuser/Downloads/fedora.iso,readonly" {
+		t.Fatalf("unexpected arguments: %v", args)
+	}
+}
+
+func TestEFINetbootCmdLine(t *testing.T) {
+	bootloader, err := NewEFIBootloaderWithOptions("/Users/virtuser/efi-store", false, EFIOptions{NetbootDir: "/Users/virtuser/boot files", NetbootHTTPPort: 8081})
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	args, err := bootloader.ToCmdLine()
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if len(args) != 2 || args[1] != `efi,variable-store=/Users/virtuser/efi-store,netboot="dir=\"/Users/virtuser/boot files\",httpPort=8081"` {
+		t.Fatalf("unexpected arguments: %v", args)
+	}
+
+	if _, err := NewEFIBootloaderWithOptions("/Users/virtuser/efi-store", false, EFIOptions{NetbootTFTPPort: 69}); err == nil {
+		t.Fatal("expected error for netboot ports without directory")
+	}
+}
+
+func TestTimeSyncCmdLine(t *testing.T) {
+	ts, err := TimeSyncNewWithOptions(1025, TimeSyncOptions{Agent: true, Interval: 30 * time.Second})
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	args, err := ts.ToCmdLine()
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if len(args) != 2 || args[1] != "vsockPort=1025,agent,interval=30s" {
+		t.Fatalf("unexpected arguments: %v", args)
+	}
+
+	if _, err := TimeSyncNewWithOptions(1025, TimeSyncOptions{Tolerance: time.Second}); err == nil {
+		t.Fatal("expected error for a tolerance without interval")
+	}
+}
+
+func TestErrors(t *testing.T) {
+	vm := NewVirtualMachine(1, 512*1024*1024, nil)
+	if _, err := vm.ToCmdLine(); !errors.Is(err, ErrMissingBootloader) || !errors.Is(err, ErrInvalidConfig) {
+		t.Fatal("expected missing bootloader error; got", err)
+	}
+
+	_, err := VirtioNetUnixSocketNewWithOptions("/tmp/gvproxy.sock", "", NetOptions{MTU: 100})
+	var devErr *InvalidDeviceError
+	if !errors.As(err, &devErr) || devErr.Device != "virtio-net" || devErr.Field != "mtu" {
+		t.Fatal("expected invalid virtio-net mtu error; got", err)
+	}
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Fatal("expected configuration error; got", err)
+	}
+
+	if err := vm.RemoveDevice("disk0"); !errors.Is(err, ErrDeviceNotFound) {
+		t.Fatal("expected device not found error; got", err)
+	}
+
+	err = &RestError{StatusCode: http.StatusNotImplemented, Message: "not supported"}
+	if !errors.Is(err, ErrNotSupported) || errors.Is(err, ErrInvalidConfig) {
+		t.Fatal("expected unsupported runtime error; got", err)
+	}
+}
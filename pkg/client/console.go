@@ -0,0 +1,43 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/crc-org/vfkit/pkg/console"
+)
+
+// AttachConsole attaches rw to the serial console of the running virtual
+// machine: the guest output is written to rw, and what is read from rw is
+// sent to the guest. The first virtio-serial device created with the Console
+// option is used. AttachConsole returns when ctx is cancelled, when reading
+// rw returns io.EOF, or when the virtual machine stops.
+func (vm *VirtualMachine) AttachConsole(ctx context.Context, rw io.ReadWriter) error {
+	serialIndex := 0
+	for _, dev := range vm.devices {
+		serialDev, isSerial := dev.(*virtioSerial)
+		if !isSerial {
+			continue
+		}
+		if serialDev.options.Console {
+			socketPath := serialDev.options.ConsoleSocket
+			if socketPath == "" {
+				var err error
+				if socketPath, err = vm.SerialConsolePath(serialIndex); err != nil {
+					return err
+				}
+			}
+			return AttachConsole(ctx, socketPath, rw)
+		}
+		serialIndex++
+	}
+
+	return fmt.Errorf("%w: the virtual machine has no serial console", ErrDeviceNotFound)
+}
+
+// AttachConsole attaches rw to the serial console listening on socketPath,
+// see VirtualMachine.AttachConsole.
+func AttachConsole(ctx context.Context, socketPath string, rw io.ReadWriter) error {
+	return console.Connect(ctx, socketPath, rw)
+}
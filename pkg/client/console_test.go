@@ -0,0 +1,132 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/crc-org/vfkit/pkg/console"
+)
+
+func TestSerialConsoleCmdLine(t *testing.T) {
+	dev, err := VirtioSerialNewWithOptions("/tmp/serial.log", SerialOptions{Console: true})
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	args, err := dev.ToCmdLine()
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if len(args) != 2 || args[1] != "virtio-serial,logFilePath=/tmp/serial.log,console" {
+		t.Fatalf("unexpected arguments: %v", args)
+	}
+
+	if _, err := VirtioSerialNewWithOptions("", SerialOptions{ConsoleSocket: "/tmp/console.sock"}); err == nil {
+		t.Fatal("expected error for a console socket without console")
+	}
+}
+
+func TestMultipleSerialCmdLine(t *testing.T) {
+	vm := NewVirtualMachine(1, 512*1024*1024, NewEFIBootloader("/tmp/efi-store", true))
+	vm.SetStateDir("/tmp/vfkit")
+	vm.SetName("web")
+	kernelConsole, err := VirtioSerialNewWithOptions("", SerialOptions{ID: "kernel"})
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	appConsole, err := VirtioSerialNewWithOptions("", SerialOptions{ID: "app", PTY: true})
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	for _, dev := range []VirtioDevice{kernelConsole, appConsole} {
+		if err := vm.AddDevice(dev); err != nil {
+			t.Fatal("expected no error; got", err)
+		}
+	}
+	args, err := appConsole.ToCmdLine()
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if len(args) != 2 || args[1] != "virtio-serial,id=app,pty" {
+		t.Fatalf("unexpected arguments: %v", args)
+	}
+	logPath, err := vm.SerialLogPath(0)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if !strings.HasSuffix(logPath, "serial-kernel.log") {
+		t.Fatalf("unexpected log path: %s", logPath)
+	}
+
+	duplicate, err := VirtioSerialNewWithOptions("/tmp/serial.log", SerialOptions{ID: "app"})
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if err := vm.AddDevice(duplicate); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	var validationErr *ValidationError
+	if err := vm.Validate(); !errors.As(err, &validationErr) || !strings.Contains(err.Error(), "ID 'app'") {
+		t.Fatalf("expected a duplicate ID error; got %v", err)
+	}
+
+	if _, err := VirtioSerialNewWithOptions("", SerialOptions{ID: "0"}); err == nil {
+		t.Fatal("expected error for an ID starting with a digit")
+	}
+	if _, err := VirtioSerialNewWithOptions("/tmp/serial.log", SerialOptions{PTY: true}); err == nil {
+		t.Fatal("expected error for a pseudo-terminal with a log file")
+	}
+}
+
+func TestAttachConsole(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "console.sock")
+	serialConsole, err := console.New()
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	defer serialConsole.Close()
+	if err := serialConsole.Start(""); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if err := serialConsole.Listen(socketPath); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	_, guestOut := serialConsole.GuestFiles()
+	if _, err := guestOut.Write([]byte("login: ")); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+
+	vm := NewVirtualMachine(1, 512*1024*1024, NewEFIBootloader("/tmp/efi-store", true))
+	if err := vm.AttachConsole(context.Background(), nil); !errors.Is(err, ErrDeviceNotFound) {
+		t.Fatalf("unexpected error for a virtual machine without console: %v", err)
+	}
+	dev, err := VirtioSerialNewWithOptions("", SerialOptions{Console: true, ConsoleSocket: socketPath})
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if err := vm.AddDevice(dev); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	output := &bytes.Buffer{}
+	// the input never ends, AttachConsole returns when ctx expires
+	inputR, inputW := io.Pipe()
+	defer inputW.Close()
+	err = vm.AttachConsole(ctx, struct {
+		io.Reader
+		io.Writer
+	}{inputR, output})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(output.String(), "login: ") {
+		t.Fatalf("unexpected console output %q", output.String())
+	}
+}
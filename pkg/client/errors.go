@@ -0,0 +1,84 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/crc-org/vfkit/pkg/rest/define"
+)
+
+// Errors returned by the client package. Configuration errors, which are
+// caused by the VirtualMachine built by the caller, match ErrInvalidConfig
+// with errors.Is. Runtime errors reported by a running vfkit instance are
+// *RestError.
+var (
+	// ErrInvalidConfig is matched by all the configuration errors,
+	// including *InvalidDeviceError and *ValidationError.
+	ErrInvalidConfig = errors.New("invalid virtual machine configuration")
+	// ErrMissingBootloader is returned when the virtual machine has no
+	// bootloader.
+	ErrMissingBootloader = fmt.Errorf("%w: missing bootloader configuration", ErrInvalidConfig)
+	// ErrRestAPIDisabled is returned by the methods needing the REST API
+	// when SetRestfulURI was not called.
+	ErrRestAPIDisabled = fmt.Errorf("%w: REST API is not enabled for this virtual machine", ErrInvalidConfig)
+	// ErrDeviceNotFound is returned when no device has the requested ID.
+	ErrDeviceNotFound = errors.New("device not found")
+	// ErrDuplicateDeviceID is returned when adding a device with an ID
+	// which is already used.
+	ErrDuplicateDeviceID = fmt.Errorf("%w: duplicate device ID", ErrInvalidConfig)
+	// ErrHostUnsupported is returned when the host cannot run the virtual
+	// machine.
+	ErrHostUnsupported = errors.New("the host cannot run the virtual machine")
+	// ErrNotSupported is returned when vfkit or the virtualization
+	// framework do not support an operation, it's the same error as
+	// define.ErrNotSupported.
+	ErrNotSupported = define.ErrNotSupported
+)
+
+// InvalidDeviceError is a configuration error of a device or a bootloader.
+type InvalidDeviceError struct {
+	// Device is the kind of device, as named on the vfkit command line,
+	// such as "virtio-blk" or "linux bootloader"
+	Device string
+	// Field is the invalid setting of the device, as named on the vfkit
+	// command line, such as "path"
+	Field string
+	// Reason describes the problem
+	Reason string
+}
+
+func invalidDevice(device string, field string, format string, args ...interface{}) *InvalidDeviceError {
+	return &InvalidDeviceError{Device: device, Field: field, Reason: fmt.Sprintf(format, args...)}
+}
+
+func (e *InvalidDeviceError) Error() string {
+	return fmt.Sprintf("invalid %s '%s': %s", e.Device, e.Field, e.Reason)
+}
+
+// Is makes InvalidDeviceError match ErrInvalidConfig.
+func (e *InvalidDeviceError) Is(target error) bool {
+	return target == ErrInvalidConfig
+}
+
+// RestError is an error reported by the REST API of a running vfkit instance.
+// It matches ErrNotSupported when the operation is not supported.
+type RestError struct {
+	// StatusCode is the HTTP status code of the response
+	StatusCode int
+	// Message is the error sent by vfkit, or the HTTP status when there is
+	// none
+	Message string
+}
+
+func (e *RestError) Error() string {
+	return fmt.Sprintf("vfkit REST API error: %s", e.Message)
+}
+
+// Unwrap returns ErrNotSupported for the operations vfkit does not support.
+func (e *RestError) Unwrap() error {
+	if e.StatusCode == http.StatusNotImplemented {
+		return ErrNotSupported
+	}
+	return nil
+}
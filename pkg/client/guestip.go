@@ -0,0 +1,39 @@
+package client
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/crc-org/vfkit/pkg/dhcp"
+)
+
+// dhcpLeasesPath is the DHCP lease database used by GuestIP. It's a variable
+// so that tests can override it.
+var dhcpLeasesPath = dhcp.DefaultLeasesPath
+
+// GuestIP returns the IP address the macOS DHCP server gave to the virtual
+// machine on the NAT network. It is looked up in the DHCP lease database with
+// the MAC address of the first virtio-net device, which must be set
+// explicitly with VirtioNetNew. The address of devices created with
+// VirtioNetStaticLeaseNew is returned without looking it up.
+//
+// The lease database is only updated once the guest network is up, GuestIP
+// fails until then.
+func (vm *VirtualMachine) GuestIP() (net.IP, error) {
+	for _, dev := range vm.devices {
+		netDev, ok := dev.(*virtioNet)
+		if !ok || !netDev.nat || len(netDev.macAddress) == 0 {
+			continue
+		}
+		if netDev.ipAddress != nil {
+			return netDev.ipAddress, nil
+		}
+		lease, err := dhcp.FindLease(dhcpLeasesPath, netDev.macAddress)
+		if err != nil {
+			return nil, err
+		}
+		return lease.IPAddress, nil
+	}
+
+	return nil, fmt.Errorf("%w: the guest IP address can only be found for NAT virtio-net devices with an explicit MAC address", ErrInvalidConfig)
+}
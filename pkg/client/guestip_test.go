@@ -0,0 +1,32 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/crc-org/vfkit/pkg/dhcp"
+)
+
+func TestGuestIP(t *testing.T) {
+	dhcpLeasesPath = filepath.Join(t.TempDir(), "dhcpd_leases")
+	t.Cleanup(func() { dhcpLeasesPath = dhcp.DefaultLeasesPath })
+	leases := "{\n\tname=fedora\n\tip_address=192.168.64.3\n\thw_address=1,52:54:0:70:2b:71\n}\n"
+	if err := os.WriteFile(dhcpLeasesPath, []byte(leases), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	vm := NewVirtualMachine(1, 512*1024*1024, nil)
+	if _, err := vm.GuestIP(); err == nil {
+		t.Fatal("expected error for a virtual machine without network")
+	}
+	dev, _ := VirtioNetNew("52:54:00:70:2b:71")
+	_ = vm.AddDevice(dev)
+	ip, err := vm.GuestIP()
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if ip.String() != "192.168.64.3" {
+		t.Fatalf("unexpected guest IP %s", ip)
+	}
+}
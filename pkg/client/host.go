@@ -0,0 +1,53 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/docker/go-units"
+)
+
+// Capabilities describes the resources and virtualization features
+// available on the host running vfkit.
+type Capabilities struct {
+	// MaxVcpus is the maximum number of virtual CPUs a virtual machine can
+	// use, this is the number of CPUs of the host.
+	MaxVcpus uint
+	// MaxMemoryBytes is the maximum amount of RAM a virtual machine can use,
+	// this is the amount of RAM of the host.
+	MaxMemoryBytes uint64
+	// HypervisorSupported is true when the host CPU and macOS version support
+	// hardware virtualization.
+	HypervisorSupported bool
+	// RunningInVirtualMachine is true when the host itself is a virtual
+	// machine.
+	RunningInVirtualMachine bool
+	// NestedVirtualization is true when the host is a virtual machine and
+	// hardware virtualization is available to it.
+	NestedVirtualization bool
+}
+
+// hostCapabilitiesFunc can be overridden in tests
+var hostCapabilitiesFunc = hostCapabilities
+
+// HostCapabilities returns the capabilities of the host on which this
+// function is called. It returns an error on hosts which cannot run vfkit.
+func HostCapabilities() (*Capabilities, error) {
+	return hostCapabilitiesFunc()
+}
+
+// Check verifies that vm can run on a host with the capabilities described by
+// caps. The returned errors list all the problems which were found.
+func (caps *Capabilities) Check(vm *VirtualMachine) []error {
+	errs := []error{}
+	if !caps.HypervisorSupported {
+		errs = append(errs, fmt.Errorf("%w: hardware virtualization is not available on this host", ErrHostUnsupported))
+	}
+	if vm.vcpus > caps.MaxVcpus {
+		errs = append(errs, fmt.Errorf("%w: the virtual machine uses %d virtual CPUs, the host only has %d", ErrHostUnsupported, vm.vcpus, caps.MaxVcpus))
+	}
+	if vm.memoryBytes > caps.MaxMemoryBytes {
+		errs = append(errs, fmt.Errorf("%w: the virtual machine uses %s of memory, the host only has %s", ErrHostUnsupported, units.BytesSize(float64(vm.memoryBytes)), units.BytesSize(float64(caps.MaxMemoryBytes))))
+	}
+
+	return errs
+}
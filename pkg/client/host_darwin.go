@@ -0,0 +1,40 @@
+package client
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+func hostCapabilities() (*Capabilities, error) {
+	ncpu, err := unix.SysctlUint32("hw.ncpu")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get host CPU count: %w", err)
+	}
+	memsize, err := unix.SysctlUint64("hw.memsize")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get host memory size: %w", err)
+	}
+	caps := Capabilities{
+		MaxVcpus:       uint(ncpu),
+		MaxMemoryBytes: memsize,
+	}
+	// these sysctls are missing on older macOS versions, treat this as 'false'
+	if hvSupport, err := unix.SysctlUint32("kern.hv_support"); err == nil {
+		caps.HypervisorSupported = hvSupport != 0
+	}
+	if vmmPresent, err := unix.SysctlUint32("kern.hv_vmm_present"); err == nil {
+		caps.RunningInVirtualMachine = vmmPresent != 0
+	}
+	caps.NestedVirtualization = caps.RunningInVirtualMachine && caps.HypervisorSupported
+
+	return &caps, nil
+}
+
+func hostOSVersion() (string, error) {
+	version, err := unix.Sysctl("kern.osproductversion")
+	if err != nil {
+		return "", fmt.Errorf("failed to get macOS version: %w", err)
+	}
+	return version, nil
+}
@@ -0,0 +1,17 @@
+//go:build !darwin
+// +build !darwin
+
+package client
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func hostCapabilities() (*Capabilities, error) {
+	return nil, fmt.Errorf("%w: vfkit cannot run on %s", ErrHostUnsupported, runtime.GOOS)
+}
+
+func hostOSVersion() (string, error) {
+	return "", fmt.Errorf("%w: vfkit cannot run on %s", ErrHostUnsupported, runtime.GOOS)
+}
@@ -0,0 +1,162 @@
+package client
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/crc-org/vfkit/pkg/naming"
+	"github.com/crc-org/vfkit/pkg/util"
+)
+
+// Instance is a running vfkit process, such as one started with
+// VirtualMachine.SetDaemonize().
+type Instance struct {
+	// PID is the process ID of vfkit
+	PID int
+	// RestClient can be used to control the virtual machine, it's nil
+	// when the REST API is not enabled.
+	RestClient *RestClient
+	// Version and StartTime are only set for adopted and listed instances
+	Version   string
+	StartTime time.Time
+	// Name and ConfigDigest are only set for listed instances, see
+	// ListRunningVMs. Name is empty for unnamed virtual machines.
+	Name         string
+	ConfigDigest string
+}
+
+// FindInstance returns the vfkit instance whose PID is stored in pidFile. An
+// error is returned if it's not running. restfulURI is optional, it's used to
+// create the REST API client of the instance.
+func FindInstance(pidFile string, restfulURI string) (*Instance, error) {
+	pid, err := util.ReadPIDFile(pidFile)
+	if err != nil {
+		return nil, err
+	}
+	instance := &Instance{PID: pid}
+	if restfulURI != "" {
+		if instance.RestClient, err = NewRestClient(restfulURI); err != nil {
+			return nil, err
+		}
+	}
+
+	return instance, nil
+}
+
+// AdoptInstance returns the vfkit instance running the virtual machine named
+// vmName, which was started with '--name vmName --state-dir stateDir'. It can
+// be used to manage this virtual machine again after the process which
+// started it exited or crashed. Empty arguments are replaced with their
+// default value.
+func AdoptInstance(stateDir string, vmName string) (*Instance, error) {
+	namingTemplate, err := naming.NewTemplate("", stateDir, vmName)
+	if err != nil {
+		return nil, err
+	}
+	return adoptInstance(namingTemplate)
+}
+
+func adoptInstance(namingTemplate *naming.Template) (*Instance, error) {
+	record, err := util.ReadInstanceRecord(namingTemplate.Path(naming.InstanceID, "json"))
+	if err != nil {
+		return nil, err
+	}
+	return instanceFromRecord(record)
+}
+
+func instanceFromRecord(record *util.InstanceRecord) (*Instance, error) {
+	instance := &Instance{
+		PID:       record.PID,
+		Version:   record.Version,
+		StartTime: record.StartTime,
+	}
+	if record.RestfulURI != "" {
+		var err error
+		if instance.RestClient, err = NewRestClient(record.RestfulURI); err != nil {
+			return nil, err
+		}
+	}
+
+	return instance, nil
+}
+
+// ListRunningVMs returns the vfkit instances of the current user which are
+// running on the host, oldest first. Every vfkit process registers itself in
+// a per-user directory while it runs, see naming.RegistryDir, whatever its
+// name and state directory. The instances without REST API have a nil
+// RestClient.
+func ListRunningVMs() ([]*Instance, error) {
+	registryDir, err := naming.RegistryDir()
+	if err != nil {
+		return nil, err
+	}
+	return listRunningVMs(registryDir)
+}
+
+func listRunningVMs(registryDir string) ([]*Instance, error) {
+	records, err := util.ListInstanceRecords(registryDir)
+	if err != nil {
+		return nil, err
+	}
+	instances := []*Instance{}
+	for _, record := range records {
+		instance, err := instanceFromRecord(record)
+		if err != nil {
+			return nil, err
+		}
+		instance.Name = record.Name
+		instance.ConfigDigest = record.ConfigDigest
+		instances = append(instances, instance)
+	}
+
+	return instances, nil
+}
+
+// Running returns true if the vfkit process is still running.
+func (i *Instance) Running() bool {
+	return util.ProcessRunning(i.PID)
+}
+
+// Terminate sends SIGTERM to vfkit, which then shuts down the virtual machine.
+func (i *Instance) Terminate() error {
+	process, err := os.FindProcess(i.PID)
+	if err != nil {
+		return err
+	}
+	return process.Signal(syscall.SIGTERM)
+}
+
+// SetPIDFile makes vfkit write its process ID to pidFile.
+func (vm *VirtualMachine) SetPIDFile(pidFile string) {
+	vm.pidFile = pidFile
+}
+
+// SetDaemonize makes vfkit detach from its controlling terminal and run in
+// the background. SetPIDFile should be used to find it later with Attach.
+func (vm *VirtualMachine) SetDaemonize(daemonize bool) {
+	vm.daemonize = daemonize
+}
+
+// Attach returns the running vfkit instance of vm. SetPIDFile must have been
+// called first.
+func (vm *VirtualMachine) Attach() (*Instance, error) {
+	if vm.pidFile == "" {
+		return nil, fmt.Errorf("%w: pid file is not set for this virtual machine", ErrInvalidConfig)
+	}
+	return FindInstance(vm.pidFile, vm.restfulURI)
+}
+
+// Adopt returns the running vfkit instance of vm, it is found in the state
+// directory with the name of vm, which must have been set with SetName.
+func (vm *VirtualMachine) Adopt() (*Instance, error) {
+	if vm.name == "" {
+		return nil, fmt.Errorf("%w: only named virtual machines can be adopted", ErrInvalidConfig)
+	}
+	namingTemplate, err := vm.NamingTemplate()
+	if err != nil {
+		return nil, err
+	}
+	return adoptInstance(namingTemplate)
+}
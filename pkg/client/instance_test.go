@@ -0,0 +1,110 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/crc-org/vfkit/pkg/util"
+)
+
+func TestFindInstance(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "vfkit.pid")
+	if _, err := FindInstance(pidFile, ""); err == nil {
+		t.Fatal("expected error for missing pid file")
+	}
+
+	if err := util.WritePIDFile(pidFile); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	vm := NewVirtualMachine(1, 512, nil)
+	vm.SetPIDFile(pidFile)
+	vm.SetRestfulURI("unix:///tmp/vfkit.sock")
+	instance, err := vm.Attach()
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if instance.PID != os.Getpid() || !instance.Running() || instance.RestClient == nil {
+		t.Fatalf("unexpected instance: %+v", instance)
+	}
+
+	// larger than the maximum pid on macOS and linux
+	if err := os.WriteFile(pidFile, []byte(strconv.Itoa(1<<22+1)), 0644); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if _, err := FindInstance(pidFile, ""); err == nil {
+		t.Fatal("expected error for stale pid file")
+	}
+	// a stale pid file can be overwritten
+	if err := util.WritePIDFile(pidFile); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+}
+
+func TestAdoptInstance(t *testing.T) {
+	stateDir := t.TempDir()
+	vm := NewVirtualMachine(1, 512, nil)
+	vm.SetStateDir(stateDir)
+	if _, err := vm.Adopt(); err == nil {
+		t.Fatal("expected error for unnamed virtual machine")
+	}
+	vm.SetName("myvm")
+	if _, err := vm.Adopt(); err == nil {
+		t.Fatal("expected error for virtual machine which is not running")
+	}
+
+	record := &util.InstanceRecord{
+		PID:        os.Getpid(),
+		RestfulURI: "unix:///tmp/vfkit.sock",
+		Version:    "0.0.4",
+	}
+	if err := util.WriteInstanceRecord(filepath.Join(stateDir, "myvm", "instance.json"), record); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	instance, err := vm.Adopt()
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if instance.PID != os.Getpid() || instance.Version != "0.0.4" || instance.RestClient == nil {
+		t.Fatalf("unexpected instance: %+v", instance)
+	}
+	if _, err := AdoptInstance(stateDir, "othervm"); err == nil {
+		t.Fatal("expected error for unknown virtual machine")
+	}
+}
+
+func TestListRunningVMs(t *testing.T) {
+	registryDir := t.TempDir()
+	instances, err := listRunningVMs(filepath.Join(registryDir, "missing"))
+	if err != nil || len(instances) != 0 {
+		t.Fatalf("expected no instances; got %v, %v", instances, err)
+	}
+
+	record := &util.InstanceRecord{
+		PID:          os.Getpid(),
+		RestfulURI:   "unix:///tmp/vfkit.sock",
+		Version:      "0.0.4",
+		Name:         "myvm",
+		ConfigDigest: "sha256:1234",
+	}
+	if err := util.WriteInstanceRecord(util.RegistryRecordPath(registryDir, record.PID), record); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	// left behind by a vfkit process which was killed
+	stalePath := util.RegistryRecordPath(registryDir, 1<<22+1)
+	if err := os.WriteFile(stalePath, []byte(`{"pid": 4194305}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	instances, err = listRunningVMs(registryDir)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if len(instances) != 1 || instances[0].Name != "myvm" || instances[0].ConfigDigest != "sha256:1234" || instances[0].RestClient == nil {
+		t.Fatalf("unexpected instances: %+v", instances)
+	}
+	if _, err := os.Stat(stalePath); !os.IsNotExist(err) {
+		t.Fatal("expected the stale record to be removed")
+	}
+}
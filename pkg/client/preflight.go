@@ -0,0 +1,195 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// minMacOSVersion is the oldest macOS version vfkit runs on.
+const minMacOSVersion = 11
+
+// virtualizationEntitlement is the entitlement macOS requires to use
+// Virtualization.framework.
+const virtualizationEntitlement = "com.apple.security.virtualization"
+
+// PreflightCheck is the result of one of the checks done by Preflight.
+type PreflightCheck struct {
+	// Name identifies the check: macos-version, hypervisor, signature or
+	// entitlement.
+	Name string `json:"name"`
+	// Passed is true when the check succeeded.
+	Passed bool `json:"passed"`
+	// Message describes what was found.
+	Message string `json:"message"`
+	// Hint tells how to fix a failed check, it may be empty.
+	Hint string `json:"hint,omitempty"`
+}
+
+// PreflightReport lists the results of the checks done by Preflight.
+type PreflightReport struct {
+	Checks []PreflightCheck `json:"checks"`
+}
+
+// Passed returns true when all the checks of the report succeeded.
+func (report *PreflightReport) Passed() bool {
+	for _, check := range report.Checks {
+		if !check.Passed {
+			return false
+		}
+	}
+	return true
+}
+
+// Err returns nil when all the checks succeeded, and an error matching
+// ErrHostUnsupported describing the failed checks otherwise.
+func (report *PreflightReport) Err() error {
+	failures := []string{}
+	for _, check := range report.Checks {
+		if !check.Passed {
+			failures = append(failures, check.Message)
+		}
+	}
+	if len(failures) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrHostUnsupported, strings.Join(failures, "; "))
+}
+
+// PreflightOptions are the optional settings of PreflightWithOptions.
+type PreflightOptions struct {
+	// VfkitPath is the vfkit binary whose signature and entitlements are
+	// checked. It's looked up in $PATH when empty.
+	VfkitPath string
+}
+
+// these can be overridden in tests
+var (
+	hostOSVersionFunc = hostOSVersion
+	codesignFunc      = codesign
+)
+
+// Preflight checks that vfkit can start virtual machines on this host: macOS
+// is recent enough, hardware virtualization is available, and the vfkit
+// binary found in $PATH is signed with the virtualization entitlement. A
+// missing entitlement otherwise only shows up as a failure to start the
+// virtual machine.
+func Preflight() *PreflightReport {
+	return PreflightWithOptions(PreflightOptions{})
+}
+
+// PreflightWithOptions runs the checks of Preflight with the settings of
+// options.
+func PreflightWithOptions(options PreflightOptions) *PreflightReport {
+	report := &PreflightReport{}
+	report.Checks = append(report.Checks, checkMacOSVersion(), checkHypervisor())
+	report.Checks = append(report.Checks, checkVfkitBinary(options.VfkitPath)...)
+
+	return report
+}
+
+func checkMacOSVersion() PreflightCheck {
+	check := PreflightCheck{Name: "macos-version"}
+	version, err := hostOSVersionFunc()
+	if err != nil {
+		check.Message = err.Error()
+		return check
+	}
+	major, err := strconv.Atoi(strings.SplitN(version, ".", 2)[0])
+	if err != nil {
+		check.Message = fmt.Sprintf("cannot parse macOS version '%s'", version)
+		return check
+	}
+	if major < minMacOSVersion {
+		check.Message = fmt.Sprintf("macOS %s is too old, vfkit needs macOS %d or newer", version, minMacOSVersion)
+		check.Hint = "upgrade macOS"
+		return check
+	}
+	check.Passed = true
+	check.Message = fmt.Sprintf("macOS %s", version)
+
+	return check
+}
+
+func checkHypervisor() PreflightCheck {
+	check := PreflightCheck{Name: "hypervisor"}
+	caps, err := hostCapabilitiesFunc()
+	switch {
+	case err != nil:
+		check.Message = err.Error()
+	case !caps.HypervisorSupported && caps.RunningInVirtualMachine:
+		check.Message = "hardware virtualization is not available in this virtual machine"
+		check.Hint = "nested virtualization needs an M3 or newer host running macOS 15 or newer"
+	case !caps.HypervisorSupported:
+		check.Message = "hardware virtualization is not available on this host"
+	default:
+		check.Passed = true
+		check.Message = "hardware virtualization is available"
+	}
+
+	return check
+}
+
+func checkVfkitBinary(path string) []PreflightCheck {
+	signature := PreflightCheck{Name: "signature"}
+	entitlement := PreflightCheck{Name: "entitlement"}
+	if path == "" {
+		var err error
+		if path, err = exec.LookPath("vfkit"); err != nil {
+			signature.Message = "vfkit binary not found in $PATH"
+			entitlement.Message = signature.Message
+			return []PreflightCheck{signature, entitlement}
+		}
+	}
+
+	if _, err := codesignFunc("--verify", path); err != nil {
+		signature.Message = fmt.Sprintf("%s is not correctly signed: %v", path, err)
+		signature.Hint = "sign it with 'codesign --entitlements vf.entitlements -s - " + path + "'"
+	} else {
+		signature.Passed = true
+		signature.Message = fmt.Sprintf("%s is signed", path)
+	}
+
+	// ':-' prints the entitlements as a plist without the blob header
+	entitlements, err := codesignFunc("-d", "--entitlements", ":-", path)
+	switch {
+	case err != nil:
+		entitlement.Message = fmt.Sprintf("cannot read the entitlements of %s: %v", path, err)
+	case !hasEntitlement(entitlements, virtualizationEntitlement):
+		entitlement.Message = fmt.Sprintf("%s does not have the %s entitlement", path, virtualizationEntitlement)
+		entitlement.Hint = "sign it with 'codesign --entitlements vf.entitlements -s - " + path + "'"
+	default:
+		entitlement.Passed = true
+		entitlement.Message = fmt.Sprintf("%s has the %s entitlement", path, virtualizationEntitlement)
+	}
+
+	return []PreflightCheck{signature, entitlement}
+}
+
+// hasEntitlement returns true when the entitlements plist has the boolean
+// entitlement name set to true.
+func hasEntitlement(plist string, name string) bool {
+	key := "<key>" + name + "</key>"
+	idx := strings.Index(plist, key)
+	if idx < 0 {
+		return false
+	}
+	return strings.HasPrefix(strings.TrimSpace(plist[idx+len(key):]), "<true/>")
+}
+
+func codesign(args ...string) (string, error) {
+	var stdout, stderr strings.Builder
+	cmd := exec.Command("codesign", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && stderr.Len() != 0 {
+			return "", errors.New(strings.TrimSpace(stderr.String()))
+		}
+		return "", err
+	}
+	return stdout.String(), nil
+}
@@ -0,0 +1,72 @@
+package client
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const testEntitlements = `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>com.apple.security.virtualization</key>
+	<true/>
+</dict>
+</plist>
+`
+
+func fakePreflight(t *testing.T, version string, entitlements string) {
+	hostOSVersionFunc = func() (string, error) { return version, nil }
+	codesignFunc = func(args ...string) (string, error) {
+		if args[0] == "--verify" {
+			return "", nil
+		}
+		return entitlements, nil
+	}
+	t.Cleanup(func() {
+		hostOSVersionFunc = hostOSVersion
+		codesignFunc = codesign
+	})
+}
+
+func TestPreflight(t *testing.T) {
+	fakeHostCapabilities(t, Capabilities{MaxVcpus: 4, MaxMemoryBytes: 1024 * 1024 * 1024, HypervisorSupported: true})
+	fakePreflight(t, "14.5", testEntitlements)
+
+	report := PreflightWithOptions(PreflightOptions{VfkitPath: "/usr/local/bin/vfkit"})
+	if !report.Passed() || report.Err() != nil {
+		t.Fatalf("unexpected failed checks: %+v", report.Checks)
+	}
+	if len(report.Checks) != 4 {
+		t.Fatalf("expected 4 checks; got %+v", report.Checks)
+	}
+}
+
+func TestPreflightFailures(t *testing.T) {
+	fakeHostCapabilities(t, Capabilities{MaxVcpus: 4, MaxMemoryBytes: 1024 * 1024 * 1024, RunningInVirtualMachine: true})
+	fakePreflight(t, "10.15.7", "<plist><dict></dict></plist>")
+
+	report := PreflightWithOptions(PreflightOptions{VfkitPath: "/usr/local/bin/vfkit"})
+	failed := []string{}
+	for _, check := range report.Checks {
+		if !check.Passed {
+			failed = append(failed, check.Name)
+		}
+	}
+	if strings.Join(failed, ",") != "macos-version,hypervisor,entitlement" {
+		t.Fatalf("unexpected failed checks: %+v", report.Checks)
+	}
+	if err := report.Err(); !errors.Is(err, ErrHostUnsupported) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestHasEntitlement(t *testing.T) {
+	if !hasEntitlement(testEntitlements, virtualizationEntitlement) {
+		t.Fatal("expected the virtualization entitlement")
+	}
+	disabled := strings.Replace(testEntitlements, "<true/>", "<false/>", 1)
+	if hasEntitlement(disabled, virtualizationEntitlement) {
+		t.Fatal("unexpected virtualization entitlement set to false")
+	}
+}
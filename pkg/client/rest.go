@@ -0,0 +1,390 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/crc-org/vfkit/pkg/rest/define"
+	vmstate "github.com/crc-org/vfkit/pkg/vm"
+)
+
+// RestClient can be used to query and control a running vfkit instance
+// through its REST API. The REST API must be enabled with
+// VirtualMachine.SetRestfulURI().
+type RestClient struct {
+	httpClient *http.Client
+	baseURL    string
+}
+
+// NewRestClient creates a new client for the vfkit REST API listening on
+// restfulURI.
+func NewRestClient(restfulURI string) (*RestClient, error) {
+	network, address, err := define.ParseRestfulURI(restfulURI)
+	if err != nil {
+		return nil, err
+	}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, address)
+		},
+	}
+
+	return &RestClient{
+		httpClient: &http.Client{Transport: transport},
+		// the host part is ignored as DialContext always connects to address
+		baseURL: "http://vfkit",
+	}, nil
+}
+
+// send sends a request to the REST API and returns the response, the error
+// responses are converted to errors. body is sent as is when it's an
+// io.Reader, and encoded as JSON otherwise. The caller must close the body of
+// the response.
+func (c *RestClient) send(ctx context.Context, method string, path string, body interface{}) (*http.Response, error) {
+	var reqBody io.Reader
+	contentType := "application/json"
+	switch body := body.(type) {
+	case nil:
+	case io.Reader:
+		reqBody = body
+		contentType = "application/octet-stream"
+	default:
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close()
+		var errResp define.ErrorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil || errResp.Error == "" {
+			return nil, &RestError{StatusCode: resp.StatusCode, Message: resp.Status}
+		}
+		return nil, &RestError{StatusCode: resp.StatusCode, Message: errResp.Error}
+	}
+
+	return resp, nil
+}
+
+func (c *RestClient) do(ctx context.Context, method string, path string, body interface{}, result interface{}) error {
+	resp, err := c.send(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if result == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// Inspect returns the state, the network information and the effective
+// configuration of the virtual machine.
+func (c *RestClient) Inspect(ctx context.Context) (*define.InspectResponse, error) {
+	var resp define.InspectResponse
+	if err := c.do(ctx, http.MethodGet, "/vm/inspect", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// State returns the current state of the virtual machine.
+func (c *RestClient) State(ctx context.Context) (vmstate.State, error) {
+	var resp define.StateResponse
+	if err := c.do(ctx, http.MethodGet, "/vm/state", nil, &resp); err != nil {
+		return vmstate.StateError, err
+	}
+	return resp.State, nil
+}
+
+func (c *RestClient) setState(ctx context.Context, req define.StateRequest) error {
+	return c.do(ctx, http.MethodPost, "/vm/state", req, nil)
+}
+
+// Pause suspends the execution of the virtual machine.
+func (c *RestClient) Pause(ctx context.Context) error {
+	return c.setState(ctx, define.StateRequest{State: vmstate.StatePaused})
+}
+
+// Resume resumes the execution of a paused virtual machine.
+func (c *RestClient) Resume(ctx context.Context) error {
+	return c.setState(ctx, define.StateRequest{State: vmstate.StateRunning})
+}
+
+// Stop stops the virtual machine. When force is false, the guest is asked to
+// shut down and is forcefully stopped if it does not do so before vfkit's
+// shutdown timeout. When force is true, the virtual machine is stopped
+// immediately.
+func (c *RestClient) Stop(ctx context.Context, force bool) error {
+	return c.setState(ctx, define.StateRequest{State: vmstate.StateStopped, Force: force})
+}
+
+// Stats returns the resource usage of the virtual machine, including an
+// estimate of its energy usage.
+func (c *RestClient) Stats(ctx context.Context) (*define.Stats, error) {
+	var stats define.Stats
+	if err := c.do(ctx, http.MethodGet, "/vm/stats", nil, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// Schedule returns the start/stop schedule of the virtual machine. vfkit must
+// have been started with --schedule.
+func (c *RestClient) Schedule(ctx context.Context) (*define.ScheduleResponse, error) {
+	var resp define.ScheduleResponse
+	if err := c.do(ctx, http.MethodGet, "/vm/schedule", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// OverrideSchedule suspends or skips scheduled start/stop actions, see
+// define.ScheduleRequest.
+func (c *RestClient) OverrideSchedule(ctx context.Context, req define.ScheduleRequest) (*define.ScheduleResponse, error) {
+	var resp define.ScheduleResponse
+	if err := c.do(ctx, http.MethodPost, "/vm/schedule", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Events returns the lifecycle and device events of the virtual machine with
+// an ID greater than since, oldest first. Only the events of eventType are
+// returned when it's not empty. vfkit keeps the last 1000 events.
+func (c *RestClient) Events(ctx context.Context, since uint64, eventType string) ([]define.Event, error) {
+	query := url.Values{}
+	if since != 0 {
+		query.Set("since", strconv.FormatUint(since, 10))
+	}
+	if eventType != "" {
+		query.Set("type", eventType)
+	}
+	path := "/vm/events"
+	if len(query) != 0 {
+		path += "?" + query.Encode()
+	}
+	events := []define.Event{}
+	if err := c.do(ctx, http.MethodGet, path, nil, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// VsockForwards returns the vsock port forwards of the virtual machine.
+func (c *RestClient) VsockForwards(ctx context.Context) ([]define.VsockForward, error) {
+	forwards := []define.VsockForward{}
+	if err := c.do(ctx, http.MethodGet, "/vm/vsock/forwards", nil, &forwards); err != nil {
+		return nil, err
+	}
+	return forwards, nil
+}
+
+// AddVsockForward starts forwarding connections between a vsock port and a
+// host unix socket while the virtual machine is running.
+func (c *RestClient) AddVsockForward(ctx context.Context, forward define.VsockForward) error {
+	return c.do(ctx, http.MethodPost, "/vm/vsock/forwards", forward, nil)
+}
+
+// RemoveVsockForward stops forwarding connections for a vsock port.
+func (c *RestClient) RemoveVsockForward(ctx context.Context, port uint) error {
+	return c.do(ctx, http.MethodDelete, "/vm/vsock/forwards/"+strconv.FormatUint(uint64(port), 10), nil, nil)
+}
+
+// Shares returns the virtio-fs shares of the virtual machine.
+func (c *RestClient) Shares(ctx context.Context) ([]define.Share, error) {
+	shares := []define.Share{}
+	if err := c.do(ctx, http.MethodGet, "/vm/shares", nil, &shares); err != nil {
+		return nil, err
+	}
+	return shares, nil
+}
+
+// EgressPolicy returns the rules restricting the network traffic sent by the
+// guest. The REST API returns an error if vfkit was started without --egress.
+func (c *RestClient) EgressPolicy(ctx context.Context) (*define.EgressPolicy, error) {
+	var policy define.EgressPolicy
+	if err := c.do(ctx, http.MethodGet, "/vm/egress", nil, &policy); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// SetEgressPolicy replaces the rules restricting the network traffic sent by
+// the guest.
+func (c *RestClient) SetEgressPolicy(ctx context.Context, policy define.EgressPolicy) (*define.EgressPolicy, error) {
+	var newPolicy define.EgressPolicy
+	if err := c.do(ctx, http.MethodPut, "/vm/egress", policy, &newPolicy); err != nil {
+		return nil, err
+	}
+	return &newPolicy, nil
+}
+
+// Disks returns the virtio-blk disks of the virtual machine.
+func (c *RestClient) Disks(ctx context.Context) ([]define.Disk, error) {
+	disks := []define.Disk{}
+	if err := c.do(ctx, http.MethodGet, "/vm/disks", nil, &disks); err != nil {
+		return nil, err
+	}
+	return disks, nil
+}
+
+// DiskSnapshots returns the snapshots of the disk with the given ID, oldest
+// first.
+func (c *RestClient) DiskSnapshots(ctx context.Context, diskID string) ([]define.Snapshot, error) {
+	snapshots := []define.Snapshot{}
+	if err := c.do(ctx, http.MethodGet, "/vm/disks/"+url.PathEscape(diskID)+"/snapshots", nil, &snapshots); err != nil {
+		return nil, err
+	}
+	return snapshots, nil
+}
+
+// CreateDiskSnapshot snapshots the disk with the given ID, the virtual
+// machine is paused while the snapshot is taken. name is an optional
+// description of the snapshot.
+func (c *RestClient) CreateDiskSnapshot(ctx context.Context, diskID string, name string) (*define.Snapshot, error) {
+	var snapshot define.Snapshot
+	req := define.SnapshotRequest{Name: name}
+	if err := c.do(ctx, http.MethodPost, "/vm/disks/"+url.PathEscape(diskID)+"/snapshots", req, &snapshot); err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// RestoreDiskSnapshot replaces the content of the disk with the given ID by
+// the content of a snapshot. The virtual machine must be stopped, the
+// returned *RestError has a 409 status code otherwise.
+func (c *RestClient) RestoreDiskSnapshot(ctx context.Context, diskID string, snapshotID string) error {
+	path := "/vm/disks/" + url.PathEscape(diskID) + "/snapshots/" + url.PathEscape(snapshotID) + "/restore"
+	return c.do(ctx, http.MethodPost, path, nil, nil)
+}
+
+// DeleteDiskSnapshot removes a snapshot of the disk with the given ID.
+func (c *RestClient) DeleteDiskSnapshot(ctx context.Context, diskID string, snapshotID string) error {
+	return c.do(ctx, http.MethodDelete, "/vm/disks/"+url.PathEscape(diskID)+"/snapshots/"+url.PathEscape(snapshotID), nil, nil)
+}
+
+// Resources returns the virtual CPUs and memory of the virtual machine.
+func (c *RestClient) Resources(ctx context.Context) (*define.Resources, error) {
+	var resources define.Resources
+	if err := c.do(ctx, http.MethodGet, "/vm/resources", nil, &resources); err != nil {
+		return nil, err
+	}
+	return &resources, nil
+}
+
+// Console returns the last lines of the serial console output of the virtual
+// machine. vfkit must log the output of a virtio-serial device to a file.
+func (c *RestClient) Console(ctx context.Context, lines int) (string, error) {
+	resp, err := c.send(ctx, http.MethodGet, "/vm/console?lines="+strconv.Itoa(lines), nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	return string(data), err
+}
+
+// FollowConsole writes the last lines of the serial console output of the
+// virtual machine to w, followed by the new output until ctx is cancelled.
+func (c *RestClient) FollowConsole(ctx context.Context, lines int, w io.Writer) error {
+	resp, err := c.send(ctx, http.MethodGet, "/vm/console?follow=true&lines="+strconv.Itoa(lines), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// CopyTo copies the content of r to the file at guestPath in the guest, with
+// the vfkit guest agent. The file is created with mode if it does not exist,
+// 0644 is used when mode is 0, and it's truncated otherwise.
+func (c *RestClient) CopyTo(ctx context.Context, guestPath string, r io.Reader, mode os.FileMode) error {
+	query := url.Values{"path": {guestPath}}
+	if mode != 0 {
+		query.Set("mode", strconv.FormatUint(uint64(mode.Perm()), 8))
+	}
+	if r == nil {
+		r = bytes.NewReader(nil)
+	}
+	return c.do(ctx, http.MethodPut, "/vm/files?"+query.Encode(), r, nil)
+}
+
+// CopyFrom copies the content of the file at guestPath in the guest to w,
+// with the vfkit guest agent. An error is returned if the transfer is
+// interrupted.
+func (c *RestClient) CopyFrom(ctx context.Context, guestPath string, w io.Writer) error {
+	resp, err := c.send(ctx, http.MethodGet, "/vm/files?"+url.Values{"path": {guestPath}}.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// Exec runs a command in the guest with the vfkit guest agent, and writes its
+// output to stdout and stderr while it runs. It returns the exit code of the
+// command. The command is killed if ctx is cancelled.
+func (c *RestClient) Exec(ctx context.Context, req define.ExecRequest, stdout io.Writer, stderr io.Writer) (int, error) {
+	resp, err := c.send(ctx, http.MethodPost, "/vm/exec", req)
+	if err != nil {
+		return -1, err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event define.ExecEvent
+		if err := decoder.Decode(&event); err != nil {
+			if errors.Is(err, io.EOF) {
+				return -1, fmt.Errorf("exec output ended before the command exited")
+			}
+			return -1, err
+		}
+		if len(event.Stdout) != 0 && stdout != nil {
+			if _, err := stdout.Write(event.Stdout); err != nil {
+				return -1, err
+			}
+		}
+		if len(event.Stderr) != 0 && stderr != nil {
+			if _, err := stderr.Write(event.Stderr); err != nil {
+				return -1, err
+			}
+		}
+		if event.Error != "" {
+			return -1, fmt.Errorf("exec failed: %s", event.Error)
+		}
+		if event.ExitCode != nil {
+			return *event.ExitCode, nil
+		}
+	}
+}
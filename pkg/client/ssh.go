@@ -0,0 +1,220 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/crc-org/vfkit/pkg/rest/define"
+)
+
+// SSHOptions are the optional settings of WaitForSSHWithOptions.
+type SSHOptions struct {
+	// Port is the guest SSH port, 22 when it's 0.
+	Port uint16
+	// KnownHostsPath is a known_hosts file where the host keys of the
+	// guest are added once its SSH server is up. They are read with the
+	// vfkit guest agent, which needs the REST API.
+	KnownHostsPath string
+	// Interval is the delay between two connection attempts, 1 second when
+	// it's 0.
+	Interval time.Duration
+}
+
+// authorizeKeyScript appends the key read on its standard input to the
+// authorized_keys file of the user given as its first argument, unless it's
+// already there.
+const authorizeKeyScript = `set -e
+home=$(getent passwd "$1" | cut -d: -f6)
+[ -n "$home" ] || { echo "unknown user $1" >&2; exit 1; }
+key=$(cat)
+mkdir -p "$home/.ssh"
+touch "$home/.ssh/authorized_keys"
+grep -qxF "$key" "$home/.ssh/authorized_keys" || printf '%s\n' "$key" >> "$home/.ssh/authorized_keys"
+chmod 700 "$home/.ssh"
+chmod 600 "$home/.ssh/authorized_keys"
+chown -R "$1:" "$home/.ssh"
+`
+
+// WaitForSSH waits until the SSH server of the guest accepts connections, and
+// returns its address. It's reached through a port forward of the guest SSH
+// port when there is one, with the NAT IP address of the guest otherwise, see
+// GuestIP. When keyPath is not empty, the public key it contains is first
+// added to the authorized keys of user in the guest with the vfkit guest
+// agent, which needs the REST API. WaitForSSH returns when ctx expires if
+// the guest never becomes reachable.
+func (vm *VirtualMachine) WaitForSSH(ctx context.Context, user string, keyPath string) (string, error) {
+	return vm.WaitForSSHWithOptions(ctx, user, keyPath, SSHOptions{})
+}
+
+// WaitForSSHWithOptions waits for the SSH server of the guest like
+// WaitForSSH, with the settings of options.
+func (vm *VirtualMachine) WaitForSSHWithOptions(ctx context.Context, user string, keyPath string, options SSHOptions) (string, error) {
+	if options.Port == 0 {
+		options.Port = 22
+	}
+	if options.Interval == 0 {
+		options.Interval = time.Second
+	}
+	var restClient *RestClient
+	if keyPath != "" || options.KnownHostsPath != "" {
+		var err error
+		if restClient, err = vm.RestClient(); err != nil {
+			return "", err
+		}
+	}
+	var key []byte
+	if keyPath != "" {
+		var err error
+		if key, err = os.ReadFile(keyPath); err != nil {
+			return "", err
+		}
+		key = bytes.TrimSpace(key)
+		if user == "" {
+			return "", fmt.Errorf("%w: a user is needed to add an authorized key", ErrInvalidConfig)
+		}
+	}
+
+	keyAdded := len(key) == 0
+	var lastErr error
+	for {
+		if !keyAdded {
+			if lastErr = authorizeKey(ctx, restClient, user, key); lastErr == nil {
+				keyAdded = true
+			}
+		}
+		if keyAdded {
+			var address string
+			if address, lastErr = vm.sshAddress(options.Port); lastErr == nil {
+				if lastErr = checkSSHServer(ctx, address); lastErr == nil {
+					if options.KnownHostsPath != "" {
+						if err := addKnownHosts(ctx, restClient, address, options.KnownHostsPath); err != nil {
+							return "", err
+						}
+					}
+					return address, nil
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("timeout waiting for SSH: %w", lastErr)
+		case <-time.After(options.Interval):
+		}
+	}
+}
+
+// sshAddress returns the address where the guest SSH server on port can be
+// reached from the host.
+func (vm *VirtualMachine) sshAddress(port uint16) (string, error) {
+	for _, pf := range vm.PortForwards() {
+		if pf.GuestPort == uint32(port) {
+			host := pf.HostAddress
+			if host == "" {
+				host = "127.0.0.1"
+			}
+			return net.JoinHostPort(host, strconv.Itoa(int(pf.HostPort))), nil
+		}
+	}
+	ip, err := vm.GuestIP()
+	if err != nil {
+		return "", err
+	}
+
+	return net.JoinHostPort(ip.String(), strconv.Itoa(int(port))), nil
+}
+
+// checkSSHServer connects to address and checks that an SSH server answers.
+func checkSSHServer(ctx context.Context, address string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetReadDeadline(deadline)
+	}
+	// the connection can be accepted by a port forwarder before sshd runs,
+	// only the SSH banner proves it's ready
+	banner, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("no SSH banner from %s: %w", address, err)
+	}
+	if !strings.HasPrefix(banner, "SSH-") {
+		return fmt.Errorf("unexpected SSH banner from %s: %q", address, strings.TrimSpace(banner))
+	}
+
+	return nil
+}
+
+func authorizeKey(ctx context.Context, restClient *RestClient, user string, key []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	var stderr bytes.Buffer
+	exitCode, err := restClient.Exec(ctx, define.ExecRequest{
+		Command: []string{"sh", "-c", authorizeKeyScript, "sh", user},
+		Stdin:   key,
+	}, nil, &stderr)
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("cannot add the authorized key of %s: %s", user, strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
+
+// addKnownHosts appends the host keys of the guest to the known_hosts file at
+// path, for the host and port of address.
+func addKnownHosts(ctx context.Context, restClient *RestClient, address string, path string) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	var stdout bytes.Buffer
+	exitCode, err := restClient.Exec(ctx, define.ExecRequest{
+		Command: []string{"sh", "-c", "cat /etc/ssh/ssh_host_*_key.pub"},
+	}, &stdout, nil)
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("cannot read the SSH host keys of the guest")
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if port != "22" {
+		host = fmt.Sprintf("[%s]:%s", host, port)
+	}
+	var entries strings.Builder
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		// the comment of the key is dropped
+		fmt.Fprintf(&entries, "%s %s %s\n", host, fields[0], fields[1])
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteString(entries.String()); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,83 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWaitForSSH(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = conn.Write([]byte("SSH-2.0-test\r\n"))
+			conn.Close()
+		}
+	}()
+
+	vm := NewVirtualMachine(1, 512*1024*1024, NewEFIBootloader("/tmp/efi-store", true))
+	port := listener.Addr().(*net.TCPAddr).Port
+	if err := vm.AddDevice(&PortForward{HostAddress: "127.0.0.1", HostPort: uint16(port), GuestPort: 22}); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	address, err := vm.WaitForSSH(ctx, "core", "")
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if address != listener.Addr().String() {
+		t.Fatalf("unexpected SSH address: %s", address)
+	}
+
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519.pub")
+	if err := os.WriteFile(keyPath, []byte("ssh-ed25519 AAAA test\n"), 0600); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if _, err := vm.WaitForSSH(ctx, "core", keyPath); !errors.Is(err, ErrRestAPIDisabled) {
+		t.Fatalf("unexpected error without REST API: %v", err)
+	}
+}
+
+func TestWaitForSSHTimeout(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			// a port forwarder without sshd behind it
+			_, _ = conn.Write([]byte("not ssh\n"))
+			conn.Close()
+		}
+	}()
+
+	vm := NewVirtualMachine(1, 512*1024*1024, NewEFIBootloader("/tmp/efi-store", true))
+	port := listener.Addr().(*net.TCPAddr).Port
+	if err := vm.AddDevice(&PortForward{HostPort: uint16(port), GuestPort: 2222}); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	_, err = vm.WaitForSSHWithOptions(ctx, "", "", SSHOptions{Port: 2222, Interval: 50 * time.Millisecond})
+	if err == nil {
+		t.Fatal("expected error for a server without SSH banner")
+	}
+}
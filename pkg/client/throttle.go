@@ -0,0 +1,35 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/crc-org/vfkit/pkg/throttle"
+)
+
+// SetCPULimit caps the CPU usage of the virtual machine to percent of one
+// host CPU, 150 allows one and a half CPUs. vfkit enforces the limit by
+// briefly pausing the virtual machine. 0 removes the limit.
+func (vm *VirtualMachine) SetCPULimit(percent uint) {
+	vm.cpuLimit = percent
+}
+
+// SetQoSClass sets the macOS QoS class of the vfkit process: "default",
+// "utility" or "background". The background class is the most energy
+// efficient, it also throttles disk and network I/O.
+func (vm *VirtualMachine) SetQoSClass(qos string) error {
+	if _, err := throttle.ParseQoSClass(qos); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+	vm.qosClass = qos
+	return nil
+}
+
+// SetIOPriority sets the disk I/O policy of the vfkit process, one of the
+// IOPOL_* policies of setiopolicy_np(3) in lower case, such as "throttle".
+func (vm *VirtualMachine) SetIOPriority(priority string) error {
+	if _, err := throttle.ParseIOPriority(priority); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+	vm.ioPriority = priority
+	return nil
+}
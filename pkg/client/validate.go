@@ -0,0 +1,285 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/crc-org/vfkit/pkg/arch"
+	"github.com/crc-org/vfkit/pkg/rest/define"
+	"github.com/docker/go-units"
+)
+
+// ValidationError is returned by VirtualMachine.Validate. It lists all the
+// problems found in the virtual machine configuration.
+type ValidationError struct {
+	Errors []error
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Errors) == 1 {
+		return e.Errors[0].Error()
+	}
+	msgs := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("%d errors in virtual machine configuration:\n\t%s", len(e.Errors), strings.Join(msgs, "\n\t"))
+}
+
+// Is makes ValidationError match ErrInvalidConfig when it lists configuration
+// errors, and the errors matched by the errors it lists, such as
+// ErrHostUnsupported.
+func (e *ValidationError) Is(target error) bool {
+	for _, err := range e.Errors {
+		if target == ErrInvalidConfig && !errors.Is(err, ErrHostUnsupported) {
+			return true
+		}
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// validator accumulates the errors found during validation.
+type validator struct {
+	errors []error
+}
+
+func (v *validator) addf(format string, args ...interface{}) {
+	v.errors = append(v.errors, fmt.Errorf(format, args...))
+}
+
+func (v *validator) checkFile(description string, path string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		v.addf("%s: %w", description, err)
+		return
+	}
+	if info.IsDir() {
+		v.addf("%s: %s is a directory", description, path)
+	}
+}
+
+func (v *validator) checkDir(description string, path string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		v.addf("%s: %w", description, err)
+		return
+	}
+	if !info.IsDir() {
+		v.addf("%s: %s is not a directory", description, path)
+	}
+}
+
+// Validate checks the virtual machine configuration without stopping at the
+// first error. It checks that the files and directories used by the
+// bootloader and the devices exist, and that there are no conflicting vsock
+// ports, socket paths, MAC addresses or mount tags, and that the kernel has
+// the architecture of the host. It also checks that the host has enough
+// resources to run the virtual machine, see HostCapabilities.
+// When problems are found, the returned error is a *ValidationError listing
+// all of them.
+//
+// Validate inspects the host, it must be called on the host where vfkit will
+// run.
+func (vm *VirtualMachine) Validate() error {
+	v := validator{}
+
+	if vm.vcpus == 0 {
+		v.addf("the virtual machine needs at least 1 virtual CPU")
+	}
+	if vm.memoryBytes == 0 {
+		v.addf("the virtual machine needs some memory")
+	} else if vm.memoryBytes%units.MiB != 0 {
+		v.addf("memory size must be a multiple of 1 MiB")
+	}
+	if vm.restfulURI != "" {
+		if _, _, err := define.ParseRestfulURI(vm.restfulURI); err != nil {
+			v.addf("invalid REST API URI: %w", err)
+		}
+	}
+
+	for key := range vm.labels {
+		if key == "" || strings.Contains(key, "=") {
+			v.addf("invalid label key '%s'", key)
+		}
+	}
+
+	switch bootloader := vm.bootloader.(type) {
+	case nil:
+		v.addf("missing bootloader configuration")
+	case *linuxBootloader:
+		bootloader.validate(&v)
+	case *efiBootloader:
+		bootloader.validate(&v)
+	}
+
+	vm.validateDevices(&v)
+	vm.validateGuestOS(&v)
+
+	if caps, err := HostCapabilities(); err == nil {
+		v.errors = append(v.errors, caps.Check(vm)...)
+	} else {
+		v.errors = append(v.errors, err)
+	}
+
+	if len(v.errors) != 0 {
+		return &ValidationError{Errors: v.errors}
+	}
+	return nil
+}
+
+func (bootloader *linuxBootloader) validate(v *validator) {
+	if bootloader.vmlinuzPath == "" {
+		v.addf("missing kernel path")
+	} else {
+		v.checkFile("kernel", bootloader.vmlinuzPath)
+		if guestArch, err := arch.KernelArch(bootloader.vmlinuzPath); err == nil {
+			if err := arch.Check(bootloader.vmlinuzPath, guestArch); err != nil {
+				v.errors = append(v.errors, err)
+			}
+		}
+	}
+	if bootloader.initrdPath == "" {
+		v.addf("missing initrd path")
+	} else {
+		v.checkFile("initrd", bootloader.initrdPath)
+	}
+	if bootloader.kernelCmdLine == "" {
+		v.addf("missing kernel command line")
+	}
+}
+
+func (bootloader *efiBootloader) validate(v *validator) {
+	if bootloader.efiVariableStorePath == "" {
+		v.addf("missing EFI store path")
+		return
+	}
+	if bootloader.createVariableStore {
+		v.checkDir("EFI variable store directory", filepath.Dir(bootloader.efiVariableStorePath))
+	} else {
+		v.checkFile("EFI variable store", bootloader.efiVariableStorePath)
+	}
+}
+
+func (vm *VirtualMachine) validateDevices(v *validator) {
+	vsockPorts := map[uint]string{}
+	socketURLs := map[string]bool{}
+	macAddresses := map[string]bool{}
+	mountTags := map[string]bool{}
+	timesyncPort := uint(0)
+	hostPorts := map[uint16]bool{}
+	needsMAC := false
+	balloons := 0
+	serialIDs := map[string]bool{}
+
+	for _, dev := range vm.devices {
+		switch dev := dev.(type) {
+		case *VirtioVsock:
+			if dev.Port == 0 {
+				v.addf("virtio-vsock needs a port")
+				break
+			}
+			if owner, ok := vsockPorts[dev.Port]; ok {
+				v.addf("vsock port %d is used by several devices (%s and virtio-vsock)", dev.Port, owner)
+			}
+			vsockPorts[dev.Port] = "virtio-vsock"
+			if dev.SocketURL != "" {
+				if socketURLs[dev.SocketURL] {
+					v.addf("socket %s is used by several virtio-vsock devices", dev.SocketURL)
+				}
+				socketURLs[dev.SocketURL] = true
+				if !dev.Listen {
+					v.checkDir("virtio-vsock socket directory", filepath.Dir(dev.SocketURL))
+				}
+			}
+		case *virtioBlk:
+			switch {
+			case dev.ramSizeBytes != 0:
+				// vfkit creates the RAM device of RAM disks
+			case dev.imagePath == "":
+				v.addf("virtio-blk needs the path to a disk image")
+			default:
+				v.checkFile("virtio-blk disk image", dev.imagePath)
+			}
+		case *cdrom:
+			if dev.imagePath == "" {
+				v.addf("cdrom needs the path to an ISO image")
+			} else {
+				v.checkFile("cdrom ISO image", dev.imagePath)
+			}
+		case *virtioNet:
+			if !dev.nat && dev.unixSocketPath == "" {
+				v.addf("virtio-net needs 'nat' or a unix socket path")
+			}
+			if len(dev.macAddress) == 0 {
+				break
+			}
+			if len(dev.macAddress) != 6 {
+				v.addf("invalid MAC address %s: only 48 bits MAC addresses are supported", dev.macAddress)
+			} else if dev.macAddress[0]&0x01 != 0 {
+				v.addf("invalid MAC address %s: multicast addresses cannot be used", dev.macAddress)
+			}
+			mac := dev.macAddress.String()
+			if macAddresses[mac] {
+				v.addf("MAC address %s is used by several virtio-net devices", mac)
+			}
+			macAddresses[mac] = true
+		case *virtioFs:
+			if dev.sharedDir == "" {
+				v.addf("virtio-fs needs the path to the directory to share")
+				break
+			}
+			v.checkDir("virtio-fs shared directory", dev.sharedDir)
+			// vfkit uses the name of the shared directory when no mount tag is set
+			mountTag := dev.mountTag
+			if mountTag == "" {
+				mountTag = filepath.Base(dev.sharedDir)
+			}
+			if mountTags[mountTag] {
+				v.addf("mount tag '%s' is used by several virtio-fs devices", mountTag)
+			}
+			mountTags[mountTag] = true
+		case *PortForward:
+			if hostPorts[dev.HostPort] {
+				v.addf("host port %d is forwarded several times", dev.HostPort)
+			}
+			hostPorts[dev.HostPort] = true
+			if !dev.OverVsock {
+				needsMAC = true
+			}
+		case *virtioSerial:
+			if dev.options.ID == "" {
+				break
+			}
+			if serialIDs[dev.options.ID] {
+				v.addf("ID '%s' is used by several virtio-serial devices", dev.options.ID)
+			}
+			serialIDs[dev.options.ID] = true
+		case *virtioBalloon:
+			balloons++
+			if balloons == 2 {
+				v.addf("only one virtio-balloon device is supported")
+			}
+		case *timeSync:
+			if timesyncPort != 0 {
+				v.addf("time synchronization is configured several times")
+			}
+			timesyncPort = dev.vsockPort
+		}
+	}
+
+	if needsMAC && len(macAddresses) == 0 {
+		v.addf("port forwarding over NAT needs a virtio-net device with an explicit MAC address")
+	}
+
+	if timesyncPort != 0 {
+		if owner, ok := vsockPorts[timesyncPort]; ok {
+			v.addf("vsock port %d is used by several devices (%s and timesync)", timesyncPort, owner)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package client
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func fakeHostCapabilities(t *testing.T, caps Capabilities) {
+	hostCapabilitiesFunc = func() (*Capabilities, error) {
+		return &caps, nil
+	}
+	t.Cleanup(func() { hostCapabilitiesFunc = hostCapabilities })
+}
+
+func TestValidate(t *testing.T) {
+	fakeHostCapabilities(t, Capabilities{MaxVcpus: 4, MaxMemoryBytes: 8 * 1024 * 1024 * 1024, HypervisorSupported: true})
+	dir := t.TempDir()
+	kernel := filepath.Join(dir, "vmlinuz")
+	if err := os.WriteFile(kernel, []byte{}, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	vm := NewVirtualMachine(1, 512*1024*1024, NewLinuxBootloader(kernel, "console=hvc0", kernel))
+	dev, _ := VirtioFsNew(dir, "share")
+	_ = vm.AddDevice(dev)
+	if err := vm.Validate(); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+
+	vm = NewVirtualMachine(1, 512*1024*1024, NewLinuxBootloader(kernel, "console=hvc0", filepath.Join(dir, "missing")))
+	for _, port := range []uint{1024, 1024} {
+		dev, _ := VirtioVsockNew(port, "", false)
+		_ = vm.AddDevice(dev)
+	}
+	for i := 0; i < 2; i++ {
+		dev, _ := VirtioFsNew(dir, "share")
+		_ = vm.AddDevice(dev)
+	}
+	dev, _ = VirtioNetNew("01:00:00:00:00:01")
+	_ = vm.AddDevice(dev)
+	for i := 0; i < 2; i++ {
+		dev, _ := VirtioBalloonNew()
+		_ = vm.AddDevice(dev)
+	}
+
+	err := vm.Validate()
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expected a ValidationError; got %v", err)
+	}
+	// missing initrd, vsock port collision, duplicate mount tag, multicast
+	// MAC, duplicate balloon
+	if len(validationErr.Errors) != 5 {
+		t.Fatalf("expected 5 errors; got %v", err)
+	}
+}
+
+func TestValidateHostCapabilities(t *testing.T) {
+	fakeHostCapabilities(t, Capabilities{MaxVcpus: 4, MaxMemoryBytes: 1024 * 1024 * 1024})
+
+	vm := NewVirtualMachine(8, 2*1024*1024*1024, NewEFIBootloader(filepath.Join(t.TempDir(), "efistore"), true))
+	err := vm.Validate()
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expected a ValidationError; got %v", err)
+	}
+	// no hypervisor, too many vCPUs, too much memory
+	if len(validationErr.Errors) != 3 {
+		t.Fatalf("expected 3 errors; got %v", err)
+	}
+}
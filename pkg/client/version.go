@@ -0,0 +1,212 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// VfkitVersion is the version of a vfkit binary.
+type VfkitVersion struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+// vfkitVersion010 is the first version with the options and devices added
+// after vfkit 0.0.4: port forwards, cdroms and balloon devices, serial
+// consoles, IDs and pseudo-terminals, netboot, and the instance management
+// options.
+var vfkitVersion010 = VfkitVersion{Major: 0, Minor: 1, Patch: 0}
+
+// ParseVfkitVersion parses a version such as "0.1.0" or "v0.1.0-rc1", the
+// pre-release and build suffixes are ignored.
+func ParseVfkitVersion(str string) (VfkitVersion, error) {
+	trimmed := strings.TrimPrefix(strings.TrimSpace(str), "v")
+	if idx := strings.IndexAny(trimmed, "-+"); idx >= 0 {
+		trimmed = trimmed[:idx]
+	}
+	parts := strings.Split(trimmed, ".")
+	if len(parts) != 3 {
+		return VfkitVersion{}, fmt.Errorf("invalid vfkit version '%s'", str)
+	}
+	numbers := make([]int, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return VfkitVersion{}, fmt.Errorf("invalid vfkit version '%s'", str)
+		}
+		numbers[i] = n
+	}
+
+	return VfkitVersion{Major: numbers[0], Minor: numbers[1], Patch: numbers[2]}, nil
+}
+
+func (v VfkitVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// AtLeast returns true when v is the same version as other or a newer one.
+func (v VfkitVersion) AtLeast(other VfkitVersion) bool {
+	if v.Major != other.Major {
+		return v.Major > other.Major
+	}
+	if v.Minor != other.Minor {
+		return v.Minor > other.Minor
+	}
+	return v.Patch >= other.Patch
+}
+
+// versionedComponent is implemented by the components which need a vfkit
+// version newer than 0.0.4. The zero version is returned when the options
+// of the component are supported by all versions.
+type versionedComponent interface {
+	minVfkitVersion() VfkitVersion
+}
+
+// SetVfkitPath sets the path of the vfkit binary used to run vm. It's the
+// first element of the command line returned by ToCmdLine, and the binary
+// executed by Version. "vfkit" is looked up in $PATH when it's not set.
+func (vm *VirtualMachine) SetVfkitPath(path string) {
+	vm.vfkitPath = path
+	vm.vfkitVersion = nil
+}
+
+// VfkitPath returns the path of the vfkit binary set with SetVfkitPath, or an
+// empty string when it was not set.
+func (vm *VirtualMachine) VfkitPath() string {
+	return vm.vfkitPath
+}
+
+// Version executes 'vfkit --version' and returns the version of the vfkit
+// binary, see SetVfkitPath. Once it's known, ToCmdLine fails with
+// ErrNotSupported for the options and devices this version does not
+// support, instead of a command line vfkit would reject.
+func (vm *VirtualMachine) Version(ctx context.Context) (VfkitVersion, error) {
+	if vm.vfkitVersion != nil {
+		return *vm.vfkitVersion, nil
+	}
+	path := vm.vfkitPath
+	if path == "" {
+		path = "vfkit"
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path, "--version")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return VfkitVersion{}, fmt.Errorf("failed to run '%s --version': %w: %s", path, err, strings.TrimSpace(stderr.String()))
+	}
+	// the output is 'vfkit version: 0.1.0'
+	output := strings.TrimSpace(stdout.String())
+	idx := strings.LastIndex(output, " ")
+	version, err := ParseVfkitVersion(output[idx+1:])
+	if err != nil {
+		return VfkitVersion{}, err
+	}
+	vm.vfkitVersion = &version
+
+	return version, nil
+}
+
+// checkVfkitVersion returns an error when the vfkit version detected by
+// Version is older than min.
+func (vm *VirtualMachine) checkVfkitVersion(what string, min VfkitVersion) error {
+	if vm.vfkitVersion == nil || vm.vfkitVersion.AtLeast(min) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s needs vfkit %s or newer, the vfkit binary is version %s", ErrNotSupported, what, min, vm.vfkitVersion)
+}
+
+// checkVfkitVersions checks that the vfkit version detected by Version
+// supports the options of vm and its components.
+func (vm *VirtualMachine) checkVfkitVersions() error {
+	if vm.vfkitVersion == nil {
+		return nil
+	}
+	options := []struct {
+		name string
+		set  bool
+	}{
+		{"--name", vm.name != ""},
+		{"--label", len(vm.labels) != 0},
+		{"--state-dir", vm.stateDir != ""},
+		{"--naming-template", vm.namingTemplate != ""},
+		{"--restful-uri", vm.restfulURI != ""},
+		{"--pidfile", vm.pidFile != ""},
+		{"--daemonize", vm.daemonize},
+		{"--cpu-limit", vm.cpuLimit != 0},
+		{"--qos-class", vm.qosClass != ""},
+		{"--io-priority", vm.ioPriority != ""},
+		{"--platform", vm.genericPlatform},
+		{"--guest-os", vm.guestOS != ""},
+	}
+	for _, option := range options {
+		if !option.set {
+			continue
+		}
+		if err := vm.checkVfkitVersion(option.name, vfkitVersion010); err != nil {
+			return err
+		}
+	}
+	components := []VMComponent{vm.bootloader}
+	for _, dev := range vm.devices {
+		components = append(components, dev)
+	}
+	for _, component := range components {
+		versioned, ok := component.(versionedComponent)
+		if !ok {
+			continue
+		}
+		args, err := component.ToCmdLine()
+		if err != nil {
+			return err
+		}
+		// '--device virtio-serial' for '--device virtio-serial,logFilePath=...'
+		what := args[0]
+		if len(args) > 1 {
+			what += " " + strings.SplitN(args[1], ",", 2)[0]
+		}
+		if err := vm.checkVfkitVersion(what, versioned.minVfkitVersion()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (pf *PortForward) minVfkitVersion() VfkitVersion {
+	return vfkitVersion010
+}
+
+func (dev *virtioBlk) minVfkitVersion() VfkitVersion {
+	if dev.ramSizeBytes != 0 {
+		return vfkitVersion010
+	}
+	return VfkitVersion{}
+}
+
+func (dev *cdrom) minVfkitVersion() VfkitVersion {
+	return vfkitVersion010
+}
+
+func (dev *virtioBalloon) minVfkitVersion() VfkitVersion {
+	return vfkitVersion010
+}
+
+func (dev *virtioSerial) minVfkitVersion() VfkitVersion {
+	if dev.options.Console || dev.options.ID != "" || dev.options.PTY {
+		return vfkitVersion010
+	}
+	return VfkitVersion{}
+}
+
+func (bootloader *efiBootloader) minVfkitVersion() VfkitVersion {
+	if bootloader.options.NetbootDir != "" {
+		return vfkitVersion010
+	}
+	return VfkitVersion{}
+}
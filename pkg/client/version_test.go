@@ -0,0 +1,84 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseVfkitVersion(t *testing.T) {
+	for str, expected := range map[string]VfkitVersion{
+		"0.1.0":         {Major: 0, Minor: 1, Patch: 0},
+		"v0.5.1":        {Major: 0, Minor: 5, Patch: 1},
+		"1.2.3-rc1":     {Major: 1, Minor: 2, Patch: 3},
+		"0.0.4+dirty\n": {Major: 0, Minor: 0, Patch: 4},
+	} {
+		version, err := ParseVfkitVersion(str)
+		if err != nil {
+			t.Fatal("expected no error; got", err)
+		}
+		if version != expected {
+			t.Fatalf("unexpected version for '%s': %s", str, version)
+		}
+	}
+	for _, str := range []string{"", "1.2", "a.b.c", "1.-2.3"} {
+		if _, err := ParseVfkitVersion(str); err == nil {
+			t.Fatalf("expected error for '%s'", str)
+		}
+	}
+	if !(VfkitVersion{Major: 0, Minor: 10, Patch: 0}).AtLeast(VfkitVersion{Major: 0, Minor: 9, Patch: 5}) {
+		t.Fatal("expected 0.10.0 to be newer than 0.9.5")
+	}
+}
+
+func fakeVfkit(t *testing.T, version string) string {
+	path := filepath.Join(t.TempDir(), "vfkit")
+	script := "#!/bin/sh\necho 'vfkit version: " + version + "'\n"
+	if err := os.WriteFile(path, []byte(script), 0700); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	return path
+}
+
+func TestVfkitVersion(t *testing.T) {
+	vm := NewVirtualMachine(1, 512*1024*1024, NewEFIBootloader("/tmp/efi-store", true))
+	path := fakeVfkit(t, "0.0.4")
+	vm.SetVfkitPath(path)
+	dev, err := CdromNew("/tmp/installer.iso")
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if err := vm.AddDevice(dev); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+
+	// the version is unknown, all the options are generated
+	args, err := vm.ToCmdLine()
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if args[0] != path {
+		t.Fatalf("expected the vfkit path first; got %v", args)
+	}
+
+	version, err := vm.Version(context.Background())
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if version.String() != "0.0.4" {
+		t.Fatalf("unexpected version: %s", version)
+	}
+	if _, err := vm.ToCmdLine(); !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("unexpected error for a cdrom with vfkit 0.0.4: %v", err)
+	}
+
+	vm.SetVfkitPath(fakeVfkit(t, "0.1.0"))
+	if _, err := vm.Version(context.Background()); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if _, err := vm.ToCmdLine(); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+}
@@ -0,0 +1,150 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// vsockRetryInterval is the delay between two attempts of ConnectVsock.
+	vsockRetryInterval = 200 * time.Millisecond
+	// vsockProbeTimeout is how long ConnectVsock waits for vfkit to close a
+	// new connection because the guest is not listening on the vsock port.
+	vsockProbeTimeout = 100 * time.Millisecond
+)
+
+// vsockSocketPath returns the host unix socket of the virtio-vsock device of
+// vm for port. listen is the mode the device must use.
+func (vm *VirtualMachine) vsockSocketPath(port uint, listen bool) (string, error) {
+	for _, dev := range vm.VirtioVsockDevices() {
+		if dev.Port != port {
+			continue
+		}
+		if dev.Listen != listen {
+			if dev.Listen {
+				return "", fmt.Errorf("%w: the virtio-vsock device on port %d is in listen mode, the guest connects to the host", ErrInvalidConfig, port)
+			}
+			return "", fmt.Errorf("%w: the virtio-vsock device on port %d is in connect mode, the host connects to the guest", ErrInvalidConfig, port)
+		}
+		if dev.SocketURL != "" {
+			return dev.SocketURL, nil
+		}
+		return vm.VsockSocketPath(port)
+	}
+
+	return "", fmt.Errorf("%w: no virtio-vsock device on port %d", ErrInvalidConfig, port)
+}
+
+// ConnectVsock connects to the guest service listening on vsock port, through
+// the host unix socket of the virtio-vsock device of vm on port, which must
+// be in connect mode (see VirtioVsockNew).
+//
+// vfkit accepts the connections on the unix socket before connecting to the
+// guest, and closes them when the guest is not listening on port. ConnectVsock
+// detects this and tries again, as it does while vfkit has not created the
+// unix socket yet, until the guest accepts the connection or ctx expires. The
+// guest services which send data as soon as a client connects are detected
+// right away, the others after a short delay.
+func ConnectVsock(ctx context.Context, vm *VirtualMachine, port uint) (net.Conn, error) {
+	socketPath, err := vm.vsockSocketPath(port, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for {
+		var conn net.Conn
+		if conn, lastErr = dialVsock(ctx, socketPath); lastErr == nil {
+			return conn, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("cannot connect to vsock port %d: %w", port, lastErr)
+		case <-time.After(vsockRetryInterval):
+		}
+	}
+}
+
+// dialVsock connects to the unix socket at socketPath and checks that vfkit
+// does not close the connection right away.
+func dialVsock(ctx context.Context, socketPath string) (net.Conn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(vsockProbeTimeout)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	buf := make([]byte, 1)
+	n, err := conn.Read(buf)
+	var netErr net.Error
+	switch {
+	case n == 1:
+		// the guest sent data, keep it for the caller
+	case errors.As(err, &netErr) && netErr.Timeout():
+		// the connection is still open, the guest accepted it
+	case err == io.EOF:
+		conn.Close()
+		return nil, fmt.Errorf("the guest is not listening")
+	default:
+		conn.Close()
+		return nil, err
+	}
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &prefixedConn{Conn: conn, prefix: buf[:n]}, nil
+}
+
+// prefixedConn is a connection whose first bytes were already read, they are
+// returned before the data read from Conn.
+type prefixedConn struct {
+	net.Conn
+	prefix []byte
+}
+
+func (conn *prefixedConn) Read(b []byte) (int, error) {
+	if len(conn.prefix) == 0 {
+		return conn.Conn.Read(b)
+	}
+	n := copy(b, conn.prefix)
+	conn.prefix = conn.prefix[n:]
+	return n, nil
+}
+
+// ListenVsock listens for the connections of the guest to the host vsock
+// port, on the host unix socket of the virtio-vsock device of vm on port,
+// which must be in listen mode (see VirtioVsockNew). vfkit connects to the
+// socket when the guest connects, so ListenVsock can be called before or
+// after vfkit is started. A stale socket left at the same path is replaced,
+// and the socket is removed when the listener is closed.
+func ListenVsock(vm *VirtualMachine, port uint) (net.Listener, error) {
+	socketPath, err := vm.vsockSocketPath(port, true)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(socketPath), 0700); err != nil {
+		return nil, err
+	}
+	if info, err := os.Lstat(socketPath); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", socketPath); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("the unix socket %s of vsock port %d is already in use", socketPath, port)
+		}
+		_ = os.Remove(socketPath)
+	}
+
+	return net.Listen("unix", socketPath)
+}
@@ -0,0 +1,91 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestConnectVsock(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "vsock-1024.sock")
+	vm := NewVirtualMachine(1, 512*1024*1024, NewEFIBootloader("/efi-store", true))
+	dev, _ := VirtioVsockNew(1024, socketPath, false)
+	_ = vm.AddDevice(dev)
+
+	// like vfkit, close the first connections as if the guest was not
+	// listening yet
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for i := 0; ; i++ {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			if i < 2 {
+				conn.Close()
+				continue
+			}
+			_, _ = conn.Write([]byte("hello"))
+			defer conn.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := ConnectVsock(ctx, vm, 1024)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	defer conn.Close()
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if string(buf) != "hello" {
+		t.Fatalf("unexpected data: %q", buf)
+	}
+
+	if _, err := ConnectVsock(ctx, vm, 1025); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("expected ErrInvalidConfig for a missing device; got %v", err)
+	}
+}
+
+func TestListenVsock(t *testing.T) {
+	vm := NewVirtualMachine(1, 512*1024*1024, NewEFIBootloader("/efi-store", true))
+	vm.SetStateDir(t.TempDir())
+	dev, _ := VirtioVsockNew(1024, "", true)
+	_ = vm.AddDevice(dev)
+
+	listener, err := ListenVsock(vm, 1024)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	defer listener.Close()
+	if _, err := ListenVsock(vm, 1024); err == nil {
+		t.Fatal("expected error for a socket which is already in use")
+	}
+
+	socketPath, _ := vm.VsockSocketPath(1024)
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	conn.Close()
+	if _, err := listener.Accept(); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+
+	dev, _ = VirtioVsockNew(1025, "", false)
+	_ = vm.AddDevice(dev)
+	if _, err := ListenVsock(vm, 1025); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("expected ErrInvalidConfig for a device in connect mode; got %v", err)
+	}
+}
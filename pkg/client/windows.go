@@ -0,0 +1,138 @@
+package client
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/crc-org/vfkit/pkg/arch"
+	"github.com/docker/go-units"
+)
+
+// Guest operating systems accepted by SetGuestOS.
+const (
+	GuestOSLinux   = "linux"
+	GuestOSWindows = "windows"
+)
+
+// minimum hardware requirements of Windows 11 on ARM
+const (
+	windowsMinVcpus       = 2
+	windowsMinMemoryBytes = 4 * units.GiB
+)
+
+// WindowsOptions are the optional settings of NewWindowsVM.
+type WindowsOptions struct {
+	// InstallerPath is the path to a Windows on ARM installation ISO image,
+	// it's attached as a USB mass storage device and booted before the disk.
+	InstallerPath string
+	// MachineIdentifierPath is the file storing the machine identifier, see
+	// SetGenericPlatform. vfkit generates a path specific to the name of the
+	// virtual machine when it's empty.
+	MachineIdentifierPath string
+	// MACAddress is the MAC address of the NAT network device, a random one
+	// is generated when it's empty.
+	MACAddress string
+}
+
+// NewWindowsVM creates a virtual machine running Windows on ARM from the disk
+// image at diskImagePath. It uses the EFI bootloader with the variable store
+// at efiVariableStorePath, which is created when it does not exist yet, a
+// generic platform with a persistent machine identifier and a NAT network
+// device. The guest OS is set to windows, so that vfkit checks the
+// configuration can run Windows.
+//
+// vfkit has no display, the installation must be unattended and the guest is
+// used over the network. There is no TPM device in the virtualization
+// framework either, the TPM 2.0 check of the Windows 11 installer must be
+// bypassed.
+func NewWindowsVM(vcpus uint, memoryBytes uint64, efiVariableStorePath string, diskImagePath string, options WindowsOptions) (*VirtualMachine, error) {
+	_, err := os.Stat(efiVariableStorePath)
+	bootloader := NewEFIBootloader(efiVariableStorePath, os.IsNotExist(err))
+	vm := NewVirtualMachine(vcpus, memoryBytes, bootloader)
+	if err := vm.SetGuestOS(GuestOSWindows); err != nil {
+		return nil, err
+	}
+	vm.SetGenericPlatform(options.MachineIdentifierPath)
+
+	devices := []func() (VirtioDevice, error){
+		func() (VirtioDevice, error) { return VirtioBlkNew(diskImagePath) },
+		func() (VirtioDevice, error) { return VirtioNetNew(options.MACAddress) },
+		VirtioRNGNew,
+	}
+	if options.InstallerPath != "" {
+		devices = append(devices, func() (VirtioDevice, error) { return CdromNew(options.InstallerPath) })
+	}
+	for _, newDevice := range devices {
+		dev, err := newDevice()
+		if err != nil {
+			return nil, err
+		}
+		if err := vm.AddDevice(dev); err != nil {
+			return nil, err
+		}
+	}
+
+	return vm, nil
+}
+
+// SetGuestOS sets the operating system of the guest, GuestOSLinux or
+// GuestOSWindows. With GuestOSWindows, vfkit uses a generic platform with a
+// persistent machine identifier and checks that the configuration can run
+// Windows on ARM, Validate does the same checks.
+func (vm *VirtualMachine) SetGuestOS(guestOS string) error {
+	switch guestOS {
+	case GuestOSLinux, GuestOSWindows:
+		vm.guestOS = guestOS
+		return nil
+	default:
+		return fmt.Errorf("%w: unknown guest OS '%s'", ErrInvalidConfig, guestOS)
+	}
+}
+
+// SetGenericPlatform makes the virtual machine use the generic platform with
+// the machine identifier stored at machineIdentifierPath. The file is created
+// the first time vfkit starts, the guest then sees the same machine on every
+// boot. vfkit generates a path specific to the name of the virtual machine
+// when machineIdentifierPath is empty.
+func (vm *VirtualMachine) SetGenericPlatform(machineIdentifierPath string) {
+	vm.genericPlatform = true
+	vm.machineIdentifierPath = machineIdentifierPath
+}
+
+// platformCmdLine returns the value of the --platform argument.
+func (vm *VirtualMachine) platformCmdLine() string {
+	options := newOptionList("generic")
+	if vm.machineIdentifierPath != "" {
+		options.Set("machineIdentifier", vm.machineIdentifierPath)
+	}
+	return options.String()
+}
+
+// validateGuestOS checks that the virtual machine can run its guest
+// operating system.
+func (vm *VirtualMachine) validateGuestOS(v *validator) {
+	if vm.guestOS != GuestOSWindows {
+		return
+	}
+	if host := arch.Host(); host != arch.ARM64 {
+		v.addf("%w: windows guests need an arm64 host, this host is %s", ErrHostUnsupported, host)
+	}
+	if _, isEFI := vm.bootloader.(*efiBootloader); !isEFI {
+		v.addf("windows guests need an EFI bootloader")
+	}
+	if vm.vcpus < windowsMinVcpus {
+		v.addf("windows guests need at least %d virtual CPUs", windowsMinVcpus)
+	}
+	if vm.memoryBytes < windowsMinMemoryBytes {
+		v.addf("windows guests need at least %s of memory", units.BytesSize(windowsMinMemoryBytes))
+	}
+	hasDisk := false
+	for _, dev := range vm.devices {
+		if _, isBlk := dev.(*virtioBlk); isBlk {
+			hasDisk = true
+		}
+	}
+	if !hasDisk {
+		v.addf("windows guests need a virtio-blk disk")
+	}
+}
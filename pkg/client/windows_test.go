@@ -0,0 +1,67 @@
+package client
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewWindowsVM(t *testing.T) {
+	dir := t.TempDir()
+	vm, err := NewWindowsVM(4, 8*1024*1024*1024, filepath.Join(dir, "efi-store"), "/tmp/windows.img", WindowsOptions{
+		InstallerPath:         "/tmp/windows.iso",
+		MachineIdentifierPath: "/tmp/machine-identifier.bin",
+		MACAddress:            "5a:94:ef:e4:0c:ee",
+	})
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	args, err := vm.ToCmdLine()
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	expected := strings.Join([]string{
+		"--cpus 4 --memory 8192",
+		"--platform generic,machineIdentifier=/tmp/machine-identifier.bin",
+		"--guest-os windows",
+		"--bootloader efi,variable-store=" + filepath.Join(dir, "efi-store") + ",create",
+		"--device virtio-blk,path=/tmp/windows.img",
+		"--device virtio-net,nat,mac=5a:94:ef:e4:0c:ee",
+		"--device virtio-rng",
+		"--device usb-mass-storage,path=/tmp/windows.iso,readonly",
+	}, " ")
+	if strings.Join(args, " ") != expected {
+		t.Fatalf("unexpected arguments: %v", args)
+	}
+
+	if err := vm.SetGuestOS("macos"); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("expected ErrInvalidConfig; got %v", err)
+	}
+}
+
+func TestValidateWindows(t *testing.T) {
+	fakeHostCapabilities(t, Capabilities{MaxVcpus: 4, MaxMemoryBytes: 8 * 1024 * 1024 * 1024, HypervisorSupported: true})
+	dir := t.TempDir()
+
+	vm := NewVirtualMachine(1, 2*1024*1024*1024, NewEFIBootloader(filepath.Join(dir, "efi-store"), true))
+	if err := vm.SetGuestOS(GuestOSWindows); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	err := vm.Validate()
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expected a ValidationError; got %v", err)
+	}
+	// vCPUs, memory and disk, the host architecture error is only reported
+	// on amd64 hosts
+	windowsErrors := 0
+	for _, err := range validationErr.Errors {
+		if strings.HasPrefix(err.Error(), "windows guests need") {
+			windowsErrors++
+		}
+	}
+	if windowsErrors != 3 {
+		t.Fatalf("expected 3 windows errors; got %v", err)
+	}
+}
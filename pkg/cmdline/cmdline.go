@@ -1,28 +1,110 @@
 package cmdline
 
-import "github.com/spf13/cobra"
+import (
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
 
 type Options struct {
-	Vcpus     uint
-	MemoryMiB uint
+	Vcpus  uint
+	Memory memoryValue
 
 	VmlinuzPath   string
 	KernelCmdline string
 	InitrdPath    string
 
+	KernelCmdlineAppend []string
+
 	Bootloader stringSliceValue
 
-	TimeSync string
+	TimeSync timesyncValue
 
 	Devices []string
+	Cdroms  []string
+
+	Publish []string
+
+	RestfulURI string
+
+	ShutdownTimeout time.Duration
+
+	Signals []string
+
+	Soak string
+
+	Swap string
+
+	Egress []string
+
+	CacheProxy string
+	VNC        string
+	GVProxy    string
+
+	Platform string
+	GuestOS  string
+
+	Schedule []string
+
+	Priority string
+
+	CPULimit   uint
+	QoSClass   string
+	IOPriority string
+
+	Restart string
+
+	Watchdog string
+
+	Journal bool
+
+	EventsFile string
+
+	PIDFile   string
+	Daemonize bool
+
+	Name           string
+	Labels         []string
+	StateDir       string
+	NamingTemplate string
+
+	ConfigPath string
+
+	DryRun    bool
+	Preflight bool
+
+	LogLevel  string
+	LogFormat string
+	LogFile   string
+
+	flags *pflag.FlagSet
+}
+
+// Changed returns true if the flag name was explicitly set on the command
+// line.
+func (opts *Options) Changed(name string) bool {
+	if opts.flags == nil {
+		return false
+	}
+	return opts.flags.Changed(name)
 }
 
 func AddFlags(cmd *cobra.Command, opts *Options) {
+	opts.flags = cmd.Flags()
+	cmd.Flags().SetNormalizeFunc(normalizeFlagName)
+
+	cmd.Flags().StringVar(&opts.ConfigPath, "config", "", "path to a JSON file with the virtual machine configuration, explicit flags take precedence")
+
 	cmd.Flags().StringVarP(&opts.VmlinuzPath, "kernel", "k", "", "path to the virtual machine linux kernel")
 	cmd.Flags().StringVarP(&opts.KernelCmdline, "kernel-cmdline", "C", "", "linux kernel command line")
 	cmd.Flags().StringVarP(&opts.InitrdPath, "initrd", "i", "", "path to the virtual machine initrd")
 
+	cmd.Flags().StringArrayVar(&opts.KernelCmdlineAppend, "kernel-cmdline-append", []string{}, "arguments to append to the linux kernel command line (can be repeated)")
+
 	cmd.Flags().VarP(&opts.Bootloader, "bootloader", "b", "bootloader configuration")
+	_ = cmd.RegisterFlagCompletionFunc("bootloader", completeBootloader)
 
 	cmd.MarkFlagsMutuallyExclusive("kernel", "bootloader")
 	cmd.MarkFlagsMutuallyExclusive("initrd", "bootloader")
@@ -30,10 +112,74 @@ func AddFlags(cmd *cobra.Command, opts *Options) {
 	cmd.MarkFlagsRequiredTogether("kernel", "initrd", "kernel-cmdline")
 
 	cmd.Flags().UintVarP(&opts.Vcpus, "cpus", "c", 1, "number of virtual CPUs")
-	// FIXME: use go-units for parsing
-	cmd.Flags().UintVarP(&opts.MemoryMiB, "memory", "m", 512, "virtual machine RAM size in mibibytes")
+	opts.Memory = memoryValue{bytes: 512 * units.MiB}
+	cmd.Flags().VarP(&opts.Memory, "memory", "m", "virtual machine RAM size, such as 2GiB or 512MiB (in MiB when no unit is given)")
+
+	cmd.Flags().VarP(&opts.TimeSync, "timesync", "t", "sync guest time when host wakes up from sleep, and periodically with 'interval', vsockPort=1234[,agent][,interval=30s][,tolerance=100ms]")
+
+	opts.Devices = []string{}
+	cmd.Flags().VarP(&deviceValue{value: &opts.Devices}, "device", "d", "devices")
+	_ = cmd.RegisterFlagCompletionFunc("device", completeDevice)
+	cmd.Flags().StringArrayVar(&opts.Cdroms, "cdrom", []string{}, "attach an ISO image as a read-only USB disk which is booted before the virtio-blk disks (can be repeated)")
+
+	cmd.Flags().StringArrayVarP(&opts.Publish, "publish", "p", []string{}, "forward a host TCP port to the guest, [hostAddress:]hostPort:guestPort[/nat|/vsock] (can be repeated)")
+
+	cmd.Flags().StringVar(&opts.RestfulURI, "restful-uri", "", "URI of the REST API (tcp://host:port or unix:///path/to/socket)")
+
+	cmd.Flags().DurationVar(&opts.ShutdownTimeout, "shutdown-timeout", 30*time.Second, "time to wait for the guest to shut down before forcefully stopping it")
+
+	cmd.Flags().StringArrayVar(&opts.Signals, "signal", []string{}, "action to run when vfkit receives a signal, such as 'HUP=toggle-pause' (shutdown, stop, pause, resume, toggle-pause, ignore or default), the defaults are TERM=shutdown, USR1=pause and USR2=resume (can be repeated)")
+
+	cmd.Flags().StringVar(&opts.Soak, "soak", "", "keep the virtual machine running for a given duration while periodically checking its devices")
+
+	cmd.Flags().StringVar(&opts.Swap, "swap", "", "create and enable a swapfile in the guest with the vfkit guest agent, size=2GiB[,path=/swapfile][,agentPort=1025]")
+
+	cmd.Flags().StringArrayVar(&opts.Egress, "egress", []string{}, "restrict the network traffic sent by the guest, allow|deny,cidr=10.0.0.0/8[,port=443][,proto=tcp] or default=allow|deny (can be repeated)")
+
+	cmd.Flags().StringVar(&opts.CacheProxy, "cache-proxy", "", "run a caching HTTP proxy on the host and configure the guest to use it with the vfkit guest agent, [port=3128][,cacheDir=/path][,maxSize=10GiB][,allow=192.168.64.0/24][,agentPort=1025]")
+	// --cache-proxy without a value uses the default options
+	cmd.Flags().Lookup("cache-proxy").NoOptDefVal = "port=3128"
+	cmd.Flags().StringVar(&opts.VNC, "vnc", "", "expose the display of the virtual machine with a VNC server, not supported by vz v3.0.0")
+	// --vnc without a value is rejected the same way
+	cmd.Flags().Lookup("vnc").NoOptDefVal = "127.0.0.1:5900"
+	cmd.Flags().StringVar(&opts.GVProxy, "gvproxy", "", "start gvproxy from gvisor-tap-vsock for the network of the virtual machine, [binary=/path][,mac=...][,sshPort=2222][,vsockPort=1024][,debug]")
+	// --gvproxy without a value looks up gvproxy in $PATH
+	cmd.Flags().Lookup("gvproxy").NoOptDefVal = "binary=gvproxy"
+
+	cmd.Flags().StringVar(&opts.Platform, "platform", "", "use the generic platform with a machine identifier stored in a file, so that it does not change between boots, generic[,machineIdentifier=/path]")
+	cmd.Flags().StringVar(&opts.GuestOS, "guest-os", "", "operating system of the guest (linux or windows), 'windows' checks the configuration can run Windows on ARM and implies --platform generic")
+
+	cmd.Flags().StringArrayVar(&opts.Schedule, "schedule", []string{}, "start or stop the virtual machine on a cron-like calendar, such as 'stop=0 22 * * *' (can be repeated)")
+
+	cmd.Flags().StringVar(&opts.Priority, "priority", "", "pause the virtual machine when the host is low on memory according to its priority (low, normal or high)")
+
+	cmd.Flags().UintVar(&opts.CPULimit, "cpu-limit", 0, "maximum CPU usage of the virtual machine in percent of one host CPU, such as 50 or 150, enforced by briefly pausing it")
+	cmd.Flags().StringVar(&opts.QoSClass, "qos-class", "", "macOS QoS class of the vfkit process (default, utility or background)")
+	cmd.Flags().StringVar(&opts.IOPriority, "io-priority", "", "disk I/O policy of the vfkit process (important, standard, utility, throttle or passive)")
+
+	cmd.Flags().StringVar(&opts.Restart, "restart", "", "restart policy of the virtual machine (no, on-failure or always), with crash loop detection options")
+
+	cmd.Flags().StringVar(&opts.Watchdog, "watchdog", "", "stop or restart the virtual machine when the vfkit guest agent stops answering heartbeats, [action=restart|poweroff][,timeout=30s][,interval=10s][,agentPort=1025]")
+	// --watchdog without a value uses the default options
+	cmd.Flags().Lookup("watchdog").NoOptDefVal = "action=restart"
+
+	cmd.Flags().BoolVar(&opts.Journal, "journal", false, "record REST API calls and lifecycle events in the journal of the virtual machine, which can be printed with 'vfkit replay'")
+
+	cmd.Flags().StringVar(&opts.EventsFile, "events-file", "", "path to a file where the lifecycle and device events of the virtual machine are appended in JSON lines format")
+
+	cmd.Flags().StringVar(&opts.PIDFile, "pidfile", "", "path to a file where the process ID of vfkit is written")
+	cmd.Flags().BoolVar(&opts.Daemonize, "daemonize", false, "run in the background, detached from the controlling terminal")
+
+	cmd.Flags().BoolVar(&opts.DryRun, "dry-run", false, "validate the virtual machine configuration and print it as JSON without starting the virtual machine")
+	cmd.Flags().BoolVar(&opts.DryRun, "print-config", false, "same as --dry-run")
+	cmd.Flags().BoolVar(&opts.Preflight, "preflight", false, "check that this host and the vfkit binary can run virtual machines, and exit")
 
-	cmd.Flags().StringVarP(&opts.TimeSync, "timesync", "t", "", "sync guest time when host wakes up from sleep")
+	cmd.Flags().StringVar(&opts.LogLevel, "log-level", "info", "log level (trace, debug, info, warn or error)")
+	cmd.Flags().StringVar(&opts.LogFormat, "log-format", "text", "log format (text or json)")
+	cmd.Flags().StringVar(&opts.LogFile, "log-file", "", "path to a file where logs are written instead of stderr")
 
-	cmd.Flags().StringArrayVarP(&opts.Devices, "device", "d", []string{}, "devices")
+	cmd.Flags().StringVar(&opts.Name, "name", "", "virtual machine name, used to name the generated host artifacts (default \"default\")")
+	cmd.Flags().StringArrayVar(&opts.Labels, "label", []string{}, "key=value label of the virtual machine, listed by 'vfkit inventory' (can be repeated)")
+	cmd.Flags().StringVar(&opts.StateDir, "state-dir", "", "directory where generated host artifacts are stored (default \"$HOME/.vfkit\")")
+	cmd.Flags().StringVar(&opts.NamingTemplate, "naming-template", "", "template for the paths of generated host artifacts (default \"{statedir}/{vm}/{device-id}.{ext}\")")
 }
@@ -0,0 +1,150 @@
+package cmdline
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/crc-org/vfkit/pkg/util"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+)
+
+// flagAliases maps the alternative flag spellings used by other vfkit forks or
+// by older versions to the flags vfkit uses.
+var flagAliases = map[string]string{
+	"cpu":         "cpus",
+	"vcpus":       "cpus",
+	"mem":         "memory",
+	"ram":         "memory",
+	"cmdline":     "kernel-cmdline",
+	"kernel-args": "kernel-cmdline",
+	"ramdisk":     "initrd",
+	"devices":     "device",
+	"restfulURI":  "restful-uri",
+	"restful-url": "restful-uri",
+}
+
+// deviceTypeAliases maps alternative --device type names to the ones vfkit
+// uses.
+var deviceTypeAliases = map[string]string{
+	"virtio-block":   "virtio-blk",
+	"virtiofs":       "virtio-fs",
+	"virtio-network": "virtio-net",
+	"virtio-console": "virtio-serial",
+	"virtio-entropy": "virtio-rng",
+	"virtio-socket":  "virtio-vsock",
+	"vsock":          "virtio-vsock",
+}
+
+// deviceOptionAliases maps, for each device type, alternative option names to
+// the ones vfkit uses.
+var deviceOptionAliases = map[string]map[string]string{
+	"virtio-blk": {
+		"image":     "path",
+		"imagePath": "path",
+	},
+	"virtio-fs": {
+		"shared-dir": "sharedDir",
+		"source":     "sharedDir",
+		"mount-tag":  "mountTag",
+		"tag":        "mountTag",
+	},
+	"virtio-net": {
+		"macAddress":  "mac",
+		"mac-address": "mac",
+	},
+	"virtio-serial": {
+		"logFile":       "logFilePath",
+		"log-file":      "logFilePath",
+		"log-file-path": "logFilePath",
+	},
+	"virtio-vsock": {
+		"socket":     "socketURL",
+		"socketUrl":  "socketURL",
+		"socket-url": "socketURL",
+	},
+}
+
+var (
+	warnedAliasesLock sync.Mutex
+	warnedAliases     = map[string]bool{}
+)
+
+// warnDeprecated logs a deprecation warning the first time alias is used.
+func warnDeprecated(kind string, alias string, replacement string) {
+	warnedAliasesLock.Lock()
+	defer warnedAliasesLock.Unlock()
+
+	key := kind + ":" + alias
+	if warnedAliases[key] {
+		return
+	}
+	warnedAliases[key] = true
+	log.Warnf("%s '%s' is deprecated, use '%s' instead", kind, alias, replacement)
+}
+
+// normalizeFlagName is a pflag normalization function translating flag aliases
+// to the flags vfkit uses. Underscores are accepted instead of dashes.
+func normalizeFlagName(_ *pflag.FlagSet, name string) pflag.NormalizedName {
+	normalized := name
+	if replacement, ok := flagAliases[normalized]; ok {
+		normalized = replacement
+	}
+	normalized = strings.ReplaceAll(normalized, "_", "-")
+	if normalized != name {
+		warnDeprecated("flag", "--"+name, "--"+normalized)
+	}
+
+	return pflag.NormalizedName(normalized)
+}
+
+// normalizeDeviceOptions translates device type and option aliases in the
+// value of a --device argument.
+func normalizeDeviceOptions(deviceOpts string) string {
+	opts, err := util.SplitOptions(deviceOpts)
+	if err != nil {
+		// the error is reported when parsing the device options
+		return deviceOpts
+	}
+	devType := opts[0]
+	if replacement, ok := deviceTypeAliases[devType]; ok {
+		warnDeprecated("device type", devType, replacement)
+		devType = replacement
+		opts[0] = replacement
+	}
+
+	optionAliases := deviceOptionAliases[devType]
+	for i, opt := range opts[1:] {
+		splitOpt := strings.SplitN(opt, "=", 2)
+		replacement, ok := optionAliases[splitOpt[0]]
+		if !ok {
+			continue
+		}
+		warnDeprecated(devType+" option", splitOpt[0], replacement)
+		splitOpt[0] = replacement
+		opts[i+1] = strings.Join(splitOpt, "=")
+	}
+
+	return strings.Join(opts, ",")
+}
+
+// -- device Value
+type deviceValue struct {
+	value *[]string
+}
+
+func (d *deviceValue) Set(val string) error {
+	*d.value = append(*d.value, normalizeDeviceOptions(val))
+	return nil
+}
+
+func (d *deviceValue) Type() string {
+	return "stringArray"
+}
+
+func (d *deviceValue) String() string {
+	if d.value == nil {
+		return "[]"
+	}
+	return "[" + strings.Join(*d.value, ",") + "]"
+}
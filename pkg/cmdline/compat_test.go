@@ -0,0 +1,50 @@
+package cmdline
+
+import (
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestFlagAliases(t *testing.T) {
+	opts := Options{}
+	cmd := &cobra.Command{}
+	AddFlags(cmd, &opts)
+
+	err := cmd.Flags().Parse([]string{"--vcpus", "4", "--mem", "2GiB", "--restful_uri", "tcp://localhost:8081"})
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if opts.Vcpus != 4 {
+		t.Fatalf("expected 4 vCPUs, got %d", opts.Vcpus)
+	}
+	if opts.Memory.Bytes() != 2*1024*1024*1024 {
+		t.Fatalf("expected 2GiB of memory, got %d bytes", opts.Memory.Bytes())
+	}
+	if opts.RestfulURI != "tcp://localhost:8081" {
+		t.Fatalf("unexpected REST API URI: %s", opts.RestfulURI)
+	}
+}
+
+func TestDeviceAliases(t *testing.T) {
+	tests := map[string]string{
+		"virtio-blk,path=/disk.img":                     "virtio-blk,path=/disk.img",
+		"virtio-block,image=/disk.img":                  "virtio-blk,path=/disk.img",
+		"virtiofs,shared-dir=/Users,tag=home":           "virtio-fs,sharedDir=/Users,mountTag=home",
+		"vsock,port=1024,socket=/tmp/vsock.sock,listen": "virtio-vsock,port=1024,socketURL=/tmp/vsock.sock,listen",
+		"virtio-rng":                                "virtio-rng",
+		`virtiofs,source="/Users/a b,c",tag=src`:    `virtio-fs,sharedDir="/Users/a b,c",mountTag=src`,
+		`virtiofs,source="/Users/tag=home",tag=src`: `virtio-fs,sharedDir="/Users/tag=home",mountTag=src`,
+	}
+	for in, expected := range tests {
+		opts := Options{}
+		cmd := &cobra.Command{}
+		AddFlags(cmd, &opts)
+		if err := cmd.Flags().Parse([]string{"--device", in}); err != nil {
+			t.Fatal("expected no error; got", err)
+		}
+		if len(opts.Devices) != 1 || opts.Devices[0] != expected {
+			t.Fatalf("expected %s to be normalized to %s, got %v", in, expected, opts.Devices)
+		}
+	}
+}
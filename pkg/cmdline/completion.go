@@ -0,0 +1,172 @@
+package cmdline
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/crc-org/vfkit/pkg/util"
+	"github.com/spf13/cobra"
+)
+
+// completionOption describes an option of a --device or --bootloader type for
+// shell completion.
+type completionOption struct {
+	name string
+	// flag is true for options without a value, such as 'nat'
+	flag bool
+	// path is true when the value is a host path, which is completed
+	// with the files of the host
+	path bool
+	// repeated is true when the option can be used several times
+	repeated bool
+}
+
+var deviceCompletions = map[string][]completionOption{
+	"virtio-balloon": {},
+	"virtio-blk": {
+		{name: "path", path: true},
+	},
+	"virtio-fs": {
+		{name: "sharedDir", path: true},
+		{name: "mountTag"},
+		{name: "notify"},
+		{name: "caseSensitive", flag: true},
+		{name: "xattr", flag: true},
+	},
+	"virtio-net": {
+		{name: "nat", flag: true},
+		{name: "unixSocketPath", path: true},
+		{name: "mac"},
+		{name: "subnet"},
+		{name: "ip"},
+		{name: "hostname"},
+		{name: "mtu"},
+	},
+	"virtio-rng": {},
+	"virtio-serial": {
+		{name: "id"},
+		{name: "logFilePath", path: true},
+		{name: "console", flag: true},
+		{name: "consoleSocket", path: true},
+		{name: "pty", flag: true},
+	},
+	"usb-mass-storage": {
+		{name: "path", path: true},
+		{name: "readonly", flag: true},
+	},
+	"virtio-vsock": {
+		{name: "port"},
+		{name: "socketURL", path: true},
+		{name: "listen", flag: true},
+		{name: "connect", flag: true},
+		{name: "forward", repeated: true},
+	},
+}
+
+var bootloaderCompletions = map[string][]completionOption{
+	"efi": {
+		{name: "variable-store", path: true},
+		{name: "create", flag: true},
+		{name: "order"},
+		{name: "netboot"},
+	},
+	"linux": {
+		{name: "kernel", path: true},
+		{name: "initrd", path: true},
+		{name: "cmdline"},
+	},
+}
+
+// completeDevice completes the value of the --device flag: the device types,
+// then the options of the device type, and the host paths of the path options.
+func completeDevice(_ *cobra.Command, _ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return completeOptionList(deviceCompletions, toComplete)
+}
+
+// completeBootloader completes the value of the --bootloader flag like
+// completeDevice.
+func completeBootloader(_ *cobra.Command, _ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return completeOptionList(bootloaderCompletions, toComplete)
+}
+
+func completeOptionList(types map[string][]completionOption, toComplete string) ([]string, cobra.ShellCompDirective) {
+	directive := cobra.ShellCompDirectiveNoSpace | cobra.ShellCompDirectiveNoFileComp
+	opts, err := util.SplitOptions(toComplete)
+	if err != nil {
+		// within a quoted value
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	if len(opts) == 1 {
+		completions := []string{}
+		for typ, options := range types {
+			if !strings.HasPrefix(typ, toComplete) {
+				continue
+			}
+			if len(options) == 0 {
+				completions = append(completions, typ)
+			} else {
+				completions = append(completions, typ+",")
+			}
+		}
+		sort.Strings(completions)
+		return completions, directive
+	}
+
+	options, ok := types[opts[0]]
+	if !ok {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	prefix := toComplete[:len(toComplete)-len(opts[len(opts)-1])]
+	current := opts[len(opts)-1]
+	used := map[string]bool{}
+	for _, opt := range opts[1 : len(opts)-1] {
+		used[strings.SplitN(opt, "=", 2)[0]] = true
+	}
+
+	if split := strings.SplitN(current, "=", 2); len(split) == 2 {
+		for _, option := range options {
+			if option.name == split[0] {
+				return completeOptionValue(prefix+split[0]+"=", option, split[1]), directive
+			}
+		}
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	completions := []string{}
+	for _, option := range options {
+		if !strings.HasPrefix(option.name, current) || (used[option.name] && !option.repeated) {
+			continue
+		}
+		if option.flag {
+			completions = append(completions, prefix+option.name)
+		} else {
+			completions = append(completions, prefix+option.name+"=")
+		}
+	}
+
+	return completions, directive
+}
+
+// completeOptionValue returns the completions of the value of option starting
+// with value, prefixed with prefix. Only host paths are completed.
+func completeOptionValue(prefix string, option completionOption, value string) []string {
+	if !option.path {
+		return nil
+	}
+	matches, err := filepath.Glob(value + "*")
+	if err != nil {
+		return nil
+	}
+	completions := []string{}
+	for _, match := range matches {
+		if info, err := os.Stat(match); err == nil && info.IsDir() {
+			match += "/"
+		}
+		completions = append(completions, prefix+match)
+	}
+
+	return completions
+}
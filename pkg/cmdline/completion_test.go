@@ -0,0 +1,51 @@
+package cmdline
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestCompleteDevice(t *testing.T) {
+	tmpDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmpDir, "disk.img"), nil, 0600); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if err := os.Mkdir(filepath.Join(tmpDir, "disks"), 0700); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+
+	tests := map[string][]string{
+		"virtio-b":                           {"virtio-balloon", "virtio-blk,"},
+		"virtio-blk,":                        {"virtio-blk,path="},
+		"virtio-fs,sharedDir=/src,x":         {"virtio-fs,sharedDir=/src,xattr"},
+		"virtio-net,nat,m":                   {"virtio-net,nat,mac=", "virtio-net,nat,mtu="},
+		"virtio-vsock,forward=1:a,forward=2": {},
+		"virtio-vsock,forward=1:a,f":         {"virtio-vsock,forward=1:a,forward="},
+		"virtio-fs,sharedDir=" + tmpDir + "/dis": {
+			"virtio-fs,sharedDir=" + tmpDir + "/disk.img",
+			"virtio-fs,sharedDir=" + tmpDir + "/disks/",
+		},
+		"virtio-gpu,": nil,
+	}
+	for toComplete, expected := range tests {
+		completions, directive := completeDevice(nil, nil, toComplete)
+		if len(completions) == 0 && len(expected) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(completions, expected) {
+			t.Fatalf("unexpected completions for %s: %v, expected %v", toComplete, completions, expected)
+		}
+		if directive&cobra.ShellCompDirectiveNoSpace == 0 {
+			t.Fatalf("unexpected directive for %s: %v", toComplete, directive)
+		}
+	}
+
+	completions, _ := completeBootloader(nil, nil, "efi,variable-store=/tmp,c")
+	if !reflect.DeepEqual(completions, []string{"efi,variable-store=/tmp,create"}) {
+		t.Fatalf("unexpected bootloader completions: %v", completions)
+	}
+}
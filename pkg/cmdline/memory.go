@@ -0,0 +1,38 @@
+package cmdline
+
+import (
+	"fmt"
+
+	"github.com/crc-org/vfkit/pkg/util"
+	"github.com/docker/go-units"
+)
+
+// -- memory Value
+type memoryValue struct {
+	bytes uint64
+}
+
+func (m *memoryValue) Set(val string) error {
+	bytes, err := util.ParseMemorySize(val)
+	if err != nil {
+		return err
+	}
+	m.bytes = bytes
+	return nil
+}
+
+func (m *memoryValue) Type() string {
+	return "size"
+}
+
+func (m *memoryValue) String() string {
+	if m.bytes%units.GiB == 0 {
+		return fmt.Sprintf("%dGiB", m.bytes/units.GiB)
+	}
+	return fmt.Sprintf("%dMiB", m.bytes/units.MiB)
+}
+
+// Bytes returns the memory size in bytes.
+func (m *memoryValue) Bytes() uint64 {
+	return m.bytes
+}
@@ -0,0 +1,40 @@
+package cmdline
+
+import (
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+func TestMemory(t *testing.T) {
+	tests := map[string]uint64{
+		"4096":   4096 * 1024 * 1024,
+		"512M":   512 * 1024 * 1024,
+		"512MiB": 512 * 1024 * 1024,
+		"2GiB":   2 * 1024 * 1024 * 1024,
+		"2g":     2 * 1024 * 1024 * 1024,
+	}
+	for arg, expected := range tests {
+		var memory memoryValue
+		f := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		f.Var(&memory, "memory", "memory size")
+		if err := f.Parse([]string{"--memory", arg}); err != nil {
+			t.Fatalf("expected no error for %s; got %v", arg, err)
+		}
+		if memory.Bytes() != expected {
+			t.Fatalf("expected %s to be parsed as %d bytes, got %d", arg, expected, memory.Bytes())
+		}
+	}
+}
+
+func TestMemoryInvalid(t *testing.T) {
+	// 17592186044417 MiB is 2^64 + 1 MiB, which wraps around to 1 MiB
+	for _, arg := range []string{"", "0", "foo", "-1", "1000KiB", "17592186044417"} {
+		var memory memoryValue
+		f := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		f.Var(&memory, "memory", "memory size")
+		if err := f.Parse([]string{"--memory", arg}); err == nil {
+			t.Fatalf("expected error when parsing %q", arg)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package cmdline
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/crc-org/vfkit/pkg/client"
+	"github.com/crc-org/vfkit/pkg/util"
+	"github.com/docker/go-units"
+	"github.com/spf13/cobra"
+)
+
+// roundTripVMs generates virtual machines with the client package, using
+// paths with the characters which need quoting in option lists.
+func roundTripVMs(t *testing.T) map[string]*client.VirtualMachine {
+	vms := map[string]*client.VirtualMachine{}
+	for _, dir := range []string{"/Users/virtuser/vm", "/Users/virtuser/VM Store", "/Users/virtuser/a,b", "/Users/virtuser/a=b", `/Users/virtuser/"vm"`} {
+		vm := client.NewVirtualMachine(2, 2*units.GiB, client.NewEFIBootloader(dir+"/efi-store", true))
+		devs := []func() (client.VirtioDevice, error){
+			func() (client.VirtioDevice, error) { return client.VirtioBlkNew(dir + "/disk.img") },
+			func() (client.VirtioDevice, error) { return client.VirtioFsNew(dir, "share") },
+			func() (client.VirtioDevice, error) { return client.VirtioSerialNew(dir + "/serial.log") },
+			func() (client.VirtioDevice, error) { return client.VirtioVsockNew(1024, dir+"/vsock.sock", true) },
+			func() (client.VirtioDevice, error) {
+				return client.VirtioNetUnixSocketNew(dir+"/gvproxy.sock", "5a:94:ef:e4:0c:ee")
+			},
+		}
+		for _, newDev := range devs {
+			dev, err := newDev()
+			if err != nil {
+				t.Fatal("expected no error; got", err)
+			}
+			if err := vm.AddDevice(dev); err != nil {
+				t.Fatal("expected no error; got", err)
+			}
+		}
+		vms[dir] = vm
+	}
+
+	return vms
+}
+
+func TestCmdLineRoundTrip(t *testing.T) {
+	for dir, vm := range roundTripVMs(t) {
+		args, err := vm.ToCmdLine()
+		if err != nil {
+			t.Fatal("expected no error; got", err)
+		}
+		opts := Options{}
+		cmd := &cobra.Command{}
+		AddFlags(cmd, &opts)
+		if err := cmd.Flags().Parse(args); err != nil {
+			t.Fatalf("failed to parse %q: %v", args, err)
+		}
+		if opts.Vcpus != 2 || opts.Memory.Bytes() != 2*units.GiB {
+			t.Fatalf("unexpected resources for %q: %d vCPUs, %d bytes", args, opts.Vcpus, opts.Memory.Bytes())
+		}
+
+		bootloader := opts.Bootloader.GetSlice()
+		if len(bootloader) != 3 || bootloader[0] != "efi" || bootloader[2] != "create" ||
+			util.UnquoteOptionValue(strings.TrimPrefix(bootloader[1], "variable-store=")) != dir+"/efi-store" {
+			t.Fatalf("unexpected bootloader for %q: %q", args, bootloader)
+		}
+
+		expected := map[string]string{
+			"virtio-blk":    "path=" + dir + "/disk.img",
+			"virtio-fs":     "sharedDir=" + dir,
+			"virtio-serial": "logFilePath=" + dir + "/serial.log",
+			"virtio-vsock":  "socketURL=" + dir + "/vsock.sock",
+			"virtio-net":    "unixSocketPath=" + dir + "/gvproxy.sock",
+		}
+		if len(opts.Devices) != len(expected) {
+			t.Fatalf("unexpected devices for %q: %q", args, opts.Devices)
+		}
+		for _, device := range opts.Devices {
+			deviceOpts, err := util.SplitOptions(device)
+			if err != nil {
+				t.Fatal("expected no error; got", err)
+			}
+			found := false
+			for _, opt := range deviceOpts[1:] {
+				split := strings.SplitN(opt, "=", 2)
+				if len(split) == 2 && split[0]+"="+util.UnquoteOptionValue(split[1]) == expected[deviceOpts[0]] {
+					found = true
+				}
+			}
+			if !found {
+				t.Fatalf("expected %s in %q", expected[deviceOpts[0]], device)
+			}
+		}
+	}
+}
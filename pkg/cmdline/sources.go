@@ -0,0 +1,125 @@
+package cmdline
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// EnvPrefix is the prefix of the environment variables setting the vfkit
+// flags, VFKIT_RESTFUL_URI sets --restful-uri for example.
+const EnvPrefix = "VFKIT_"
+
+// maxArgsFileDepth limits the nesting of @args-file arguments, so that a file
+// including itself is an error instead of an endless loop.
+const maxArgsFileDepth = 8
+
+// EnvVarName returns the name of the environment variable setting the flag
+// named flagName.
+func EnvVarName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// SetFromEnv sets the flags which were not set on the command line from the
+// VFKIT_* environment variables, looked up with lookupEnv (os.LookupEnv).
+// The values of repeatable flags, such as --device, are separated with
+// newlines. Flags set from the environment are reported as set by Changed,
+// so they take precedence over the configuration file like command line
+// flags. AddFlags and the parsing of the command line must be done first.
+func (opts *Options) SetFromEnv(lookupEnv func(string) (string, bool)) error {
+	if opts.flags == nil {
+		return nil
+	}
+	var err error
+	opts.flags.VisitAll(func(flag *pflag.Flag) {
+		if err != nil || flag.Changed {
+			return
+		}
+		name := EnvVarName(flag.Name)
+		value, ok := lookupEnv(name)
+		if !ok || value == "" {
+			return
+		}
+		for _, line := range strings.Split(value, "\n") {
+			if line == "" {
+				continue
+			}
+			if setErr := opts.flags.Set(flag.Name, line); setErr != nil {
+				err = fmt.Errorf("invalid value for %s: %w", name, setErr)
+				return
+			}
+		}
+	})
+
+	return err
+}
+
+// ExpandArgsFiles replaces the '@path' arguments of args with the arguments
+// listed in the file at path, one per line. Empty lines and lines starting
+// with '#' are ignored, the other lines are used unchanged, without shell
+// quoting: a flag and its value are either on one line, as in
+// '--device=virtio-rng', or on two consecutive lines. Relative paths are
+// relative to the current directory, the files can include other files with
+// '@path' lines, relative to the including file. '@@' escapes an argument
+// starting with '@'.
+func ExpandArgsFiles(args []string) ([]string, error) {
+	return expandArgsFiles(args, "", 0)
+}
+
+func expandArgsFiles(args []string, dir string, depth int) ([]string, error) {
+	expanded := make([]string, 0, len(args))
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "@@"):
+			expanded = append(expanded, arg[1:])
+		case strings.HasPrefix(arg, "@") && len(arg) > 1:
+			if depth >= maxArgsFileDepth {
+				return nil, fmt.Errorf("too many nested argument files at %s", arg)
+			}
+			path := arg[1:]
+			if dir != "" && !filepath.IsAbs(path) {
+				path = filepath.Join(dir, path)
+			}
+			fileArgs, err := readArgsFile(path)
+			if err != nil {
+				return nil, err
+			}
+			fileArgs, err = expandArgsFiles(fileArgs, filepath.Dir(path), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			expanded = append(expanded, fileArgs...)
+		default:
+			expanded = append(expanded, arg)
+		}
+	}
+
+	return expanded, nil
+}
+
+func readArgsFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read argument file: %w", err)
+	}
+	defer file.Close()
+
+	args := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		args = append(args, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read argument file %s: %w", path, err)
+	}
+
+	return args, nil
+}
@@ -0,0 +1,82 @@
+package cmdline
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestSetFromEnv(t *testing.T) {
+	opts := Options{}
+	cmd := &cobra.Command{}
+	AddFlags(cmd, &opts)
+	if err := cmd.Flags().Parse([]string{"--cpus", "4"}); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+
+	env := map[string]string{
+		"VFKIT_CPUS":        "2",
+		"VFKIT_MEMORY":      "2GiB",
+		"VFKIT_RESTFUL_URI": "tcp://localhost:8081",
+		"VFKIT_DEVICE":      "virtio-rng\nvirtio-block,image=/disk.img\n",
+	}
+	err := opts.SetFromEnv(func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	})
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	// the command line takes precedence over the environment
+	if opts.Vcpus != 4 {
+		t.Fatalf("expected 4 vCPUs, got %d", opts.Vcpus)
+	}
+	if opts.Memory.Bytes() != 2*1024*1024*1024 || !opts.Changed("memory") {
+		t.Fatalf("expected 2GiB of memory, got %d bytes", opts.Memory.Bytes())
+	}
+	if opts.RestfulURI != "tcp://localhost:8081" {
+		t.Fatalf("unexpected REST API URI: %s", opts.RestfulURI)
+	}
+	if !reflect.DeepEqual(opts.Devices, []string{"virtio-rng", "virtio-blk,path=/disk.img"}) {
+		t.Fatalf("unexpected devices: %v", opts.Devices)
+	}
+
+	env = map[string]string{"VFKIT_SHUTDOWN_TIMEOUT": "soon"}
+	if err := opts.SetFromEnv(func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}); err == nil {
+		t.Fatal("expected error for an invalid duration")
+	}
+}
+
+func TestExpandArgsFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name string, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("vm.args", "# test virtual machine\n--cpus=2\n\n--kernel-cmdline\nconsole=hvc0 root=/dev/vda\n@devices.args\n")
+	writeFile("devices.args", "  --device=virtio-rng  \n")
+	writeFile("loop.args", "@loop.args\n")
+
+	args, err := ExpandArgsFiles([]string{"--memory", "2GiB", "@" + filepath.Join(dir, "vm.args"), "--label", "@@home"})
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	expected := []string{"--memory", "2GiB", "--cpus=2", "--kernel-cmdline", "console=hvc0 root=/dev/vda", "--device=virtio-rng", "--label", "@home"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Fatalf("unexpected arguments: %q", args)
+	}
+
+	if _, err := ExpandArgsFiles([]string{"@" + filepath.Join(dir, "loop.args")}); err == nil {
+		t.Fatal("expected error for an argument file including itself")
+	}
+	if _, err := ExpandArgsFiles([]string{"@" + filepath.Join(dir, "missing.args")}); err == nil {
+		t.Fatal("expected error for a missing argument file")
+	}
+}
@@ -56,12 +56,20 @@ func (builder *strvBuilder) End() ([]string, error) {
 
 func parseString(str string) ([]string, error) {
 	withinQuotes := false
+	escaped := false
 
 	//  trim spaces from str
 	builder := strvBuilder{}
 	for _, c := range str {
 		if withinQuotes {
-			if c == '"' {
+			// a backslash escapes the next character, as done by
+			// util.QuoteOptionValue
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
 				withinQuotes = false
 			}
 			builder.WriteRune(c)
@@ -0,0 +1,36 @@
+package cmdline
+
+import (
+	"github.com/crc-org/vfkit/pkg/timesync"
+)
+
+// -- timesync Value
+type timesyncValue struct {
+	options *timesync.Options
+}
+
+func (v *timesyncValue) Set(val string) error {
+	options, err := timesync.Parse(val)
+	if err != nil {
+		return err
+	}
+	v.options = options
+	return nil
+}
+
+func (v *timesyncValue) Type() string {
+	return "timesync"
+}
+
+func (v *timesyncValue) String() string {
+	if v.options == nil {
+		return ""
+	}
+	return v.options.String()
+}
+
+// Options returns the parsed --timesync options, nil when --timesync was not
+// used.
+func (v *timesyncValue) Options() *timesync.Options {
+	return v.options
+}
@@ -3,6 +3,8 @@ package config
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/crc-org/vfkit/pkg/util"
 
@@ -25,6 +27,9 @@ type LinuxBootloader struct {
 type EFIBootloader struct {
 	efiVariableStorePath string
 	createVariableStore  bool
+	// indexes of the virtio-blk devices, in boot order
+	bootOrder []int
+	netboot   *Netboot
 }
 
 func NewLinuxBootloader(vmlinuzPath, kernelCmdLine, initrdPath string) *LinuxBootloader {
@@ -60,6 +65,15 @@ func isKernelUncompressed(filename string) (bool, error) {
 	return false, nil
 }
 
+// AppendKernelCmdLine appends args to the kernel command line.
+func (bootloader *LinuxBootloader) AppendKernelCmdLine(args string) {
+	if bootloader.kernelCmdLine == "" {
+		bootloader.kernelCmdLine = args
+		return
+	}
+	bootloader.kernelCmdLine += " " + args
+}
+
 func (bootloader *LinuxBootloader) toVzBootloader() (vz.BootLoader, error) {
 	uncompressed, err := isKernelUncompressed(bootloader.vmlinuzPath)
 	if err != nil {
@@ -127,6 +141,20 @@ func (bootloader *EFIBootloader) FromOptions(options []option) error {
 				return fmt.Errorf("Unexpected value for EFI bootloader 'create' option: %s", option.value)
 			}
 			bootloader.createVariableStore = true
+		case "secure-boot":
+			return fmt.Errorf("secure boot is not supported by the EFI firmware of Virtualization.framework")
+		case "order":
+			order, err := parseBootOrder(option.value)
+			if err != nil {
+				return err
+			}
+			bootloader.bootOrder = order
+		case "netboot":
+			netboot, err := netbootFromOptionValue(option.value)
+			if err != nil {
+				return err
+			}
+			bootloader.netboot = netboot
 		default:
 			return fmt.Errorf("Unknown option for EFI bootloaders: %s", option.key)
 		}
@@ -134,13 +162,40 @@ func (bootloader *EFIBootloader) FromOptions(options []option) error {
 	return nil
 }
 
-func BootloaderFromCmdLine(optsStrv []string) (Bootloader, error) {
+// parseBootOrder parses the value of the 'order' option of EFI bootloaders, a
+// list of boot devices separated by ':' such as "disk1:disk0". diskN is the
+// Nth virtio-blk device of the command line, starting from 0.
+func parseBootOrder(order string) ([]int, error) {
+	if order == "" {
+		return nil, fmt.Errorf("missing value for EFI bootloader 'order' option")
+	}
+	indexes := []int{}
+	seen := map[int]bool{}
+	for _, dev := range strings.Split(order, ":") {
+		switch {
+		case dev == "net":
+			return nil, fmt.Errorf("network boot is not supported by the EFI firmware of Virtualization.framework, boot a network bootloader such as iPXE from a disk image and serve its files with the 'netboot' option")
+		case strings.HasPrefix(dev, "disk"):
+			index, err := strconv.Atoi(strings.TrimPrefix(dev, "disk"))
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid boot device in EFI bootloader 'order' option: %s", dev)
+			}
+			if seen[index] {
+				return nil, fmt.Errorf("boot device %s is listed several times in EFI bootloader 'order' option", dev)
+			}
+			seen[index] = true
+			indexes = append(indexes, index)
+		default:
+			return nil, fmt.Errorf("unknown boot device in EFI bootloader 'order' option: %s", dev)
+		}
+	}
+
+	return indexes, nil
+}
+
+func bootloaderFromOptions(bootloaderType string, options []option) (Bootloader, error) {
 	var bootloader Bootloader
 
-	if len(optsStrv) < 1 {
-		return nil, fmt.Errorf("empty option list in --bootloader command line argument")
-	}
-	bootloaderType := optsStrv[0]
 	switch bootloaderType {
 	case "efi":
 		bootloader = &EFIBootloader{}
@@ -149,9 +204,16 @@ func BootloaderFromCmdLine(optsStrv []string) (Bootloader, error) {
 	default:
 		return nil, fmt.Errorf("unknown bootloader type: %s", bootloaderType)
 	}
-	options := strvToOptions(optsStrv[1:])
 	if err := bootloader.FromOptions(options); err != nil {
 		return nil, err
 	}
 	return bootloader, nil
 }
+
+func BootloaderFromCmdLine(optsStrv []string) (Bootloader, error) {
+	if len(optsStrv) < 1 {
+		return nil, fmt.Errorf("empty option list in --bootloader command line argument")
+	}
+
+	return bootloaderFromOptions(optsStrv[0], strvToOptions(optsStrv[1:]))
+}
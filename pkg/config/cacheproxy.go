@@ -0,0 +1,112 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/crc-org/vfkit/pkg/agent"
+	"github.com/crc-org/vfkit/pkg/util"
+)
+
+const (
+	defaultCacheProxyPort = 3128
+	// defaultCacheProxyNetwork is the network used by the NAT virtio-net
+	// devices
+	defaultCacheProxyNetwork = "192.168.64.0/24"
+	defaultCacheProxyMaxSize = 10 * 1024 * 1024 * 1024
+	// cacheProxyDirName is the name of the default cache directory, in the
+	// state directory, it is shared by all the virtual machines
+	cacheProxyDirName = "proxy-cache"
+)
+
+// CacheProxy configures an HTTP proxy with a disk cache which runs on the
+// host. The guest is configured to use it by the vfkit guest agent after
+// boot.
+type CacheProxy struct {
+	port         uint
+	cacheDir     string
+	maxSizeBytes uint64
+	allow        *net.IPNet
+	agentPort    uint
+}
+
+// Port is the TCP port the proxy listens on.
+func (proxy *CacheProxy) Port() uint {
+	return proxy.port
+}
+
+// CacheDir is the directory storing the cached responses. It is empty until
+// GenerateArtifactPaths is called when it was not explicitly set.
+func (proxy *CacheProxy) CacheDir() string {
+	return proxy.cacheDir
+}
+
+// MaxSizeBytes is the maximum size of the cache.
+func (proxy *CacheProxy) MaxSizeBytes() uint64 {
+	return proxy.maxSizeBytes
+}
+
+// Allow is the network of the clients allowed to use the proxy.
+func (proxy *CacheProxy) Allow() *net.IPNet {
+	return proxy.allow
+}
+
+// AgentPort is the vsock port of the guest agent.
+func (proxy *CacheProxy) AgentPort() uint {
+	return proxy.agentPort
+}
+
+// CacheProxyFromCmdLine parses the options of the --cache-proxy command line
+// argument, "[port=3128][,cacheDir=/path][,maxSize=10GiB][,allow=192.168.64.0/24][,agentPort=1025]".
+func CacheProxyFromCmdLine(optsStr string) (*CacheProxy, error) {
+	_, allow, _ := net.ParseCIDR(defaultCacheProxyNetwork)
+	proxy := CacheProxy{
+		port:         defaultCacheProxyPort,
+		maxSizeBytes: defaultCacheProxyMaxSize,
+		allow:        allow,
+		agentPort:    agent.DefaultVsockPort,
+	}
+
+	options, err := optionsFromCmdLine(optsStr)
+	if err != nil {
+		return nil, err
+	}
+	for _, option := range options {
+		switch option.key {
+		case "port":
+			port, err := strconv.ParseUint(option.value, 10, 16)
+			if err != nil || port == 0 {
+				return nil, fmt.Errorf("invalid port for cache-proxy parameter: %s", option.value)
+			}
+			proxy.port = uint(port)
+		case "cacheDir":
+			if option.value == "" {
+				return nil, fmt.Errorf("empty cacheDir for cache-proxy parameter")
+			}
+			proxy.cacheDir = option.value
+		case "maxSize":
+			size, err := util.ParseMemorySize(option.value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid cache size: %w", err)
+			}
+			proxy.maxSizeBytes = size
+		case "allow":
+			_, network, err := net.ParseCIDR(option.value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid network for cache-proxy parameter: %w", err)
+			}
+			proxy.allow = network
+		case "agentPort":
+			port, err := strconv.ParseUint(option.value, 10, 32)
+			if err != nil || port == 0 {
+				return nil, fmt.Errorf("invalid agent vsock port for cache-proxy parameter: %s", option.value)
+			}
+			proxy.agentPort = uint(port)
+		default:
+			return nil, fmt.Errorf("Unknown option for cache-proxy parameter: %s", option.key)
+		}
+	}
+
+	return &proxy, nil
+}
@@ -2,10 +2,19 @@ package config
 
 import (
 	"fmt"
-	"strconv"
-	"strings"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/Code-Hex/vz/v3"
+	"github.com/crc-org/vfkit/pkg/arch"
+	"github.com/crc-org/vfkit/pkg/console"
+	"github.com/crc-org/vfkit/pkg/diskimage"
+	"github.com/crc-org/vfkit/pkg/egress"
+	"github.com/crc-org/vfkit/pkg/naming"
+	"github.com/crc-org/vfkit/pkg/timesync"
+	log "github.com/sirupsen/logrus"
 )
 
 type VirtualMachine struct {
@@ -14,14 +23,39 @@ type VirtualMachine struct {
 	bootloader  Bootloader
 	devices     []VirtioDevice
 	timesync    *TimeSync
+	publish     []*PortForward
+	swap        *Swap
+	egress      []string
+	cacheProxy  *CacheProxy
+	gvproxy     *GVProxy
+	guestAgent  bool
+	platform    *Platform
+	guestOS     GuestOS
 }
 
 type TimeSync struct {
-	vsockPort uint
+	options timesync.Options
 }
 
 func (ts *TimeSync) VsockPort() uint {
-	return ts.vsockPort
+	return ts.options.VsockPort
+}
+
+// UseAgent returns true if the vfkit guest agent must be used instead of
+// qemu-guest-agent to set the guest time.
+func (ts *TimeSync) UseAgent() bool {
+	return ts.options.Agent
+}
+
+// Interval returns the period of the guest clock drift correction, 0 when the
+// guest clock is only set after the host wakes up from sleep.
+func (ts *TimeSync) Interval() time.Duration {
+	return ts.options.Interval
+}
+
+// Options returns the settings of the time synchronization.
+func (ts *TimeSync) Options() timesync.Options {
+	return ts.options
 }
 
 func NewVirtualMachine(vcpus uint, memoryBytes uint64, bootloader Bootloader) *VirtualMachine {
@@ -32,19 +66,171 @@ func NewVirtualMachine(vcpus uint, memoryBytes uint64, bootloader Bootloader) *V
 	}
 }
 
+// Vcpus returns the number of virtual CPUs of the virtual machine.
+func (vm *VirtualMachine) Vcpus() uint {
+	return vm.vcpus
+}
+
+// MemoryBytes returns the amount of RAM of the virtual machine, in bytes.
+func (vm *VirtualMachine) MemoryBytes() uint64 {
+	return vm.memoryBytes
+}
+
+// Bootloader returns the bootloader used to start the virtual machine.
+func (vm *VirtualMachine) Bootloader() Bootloader {
+	return vm.bootloader
+}
+
+// SetVcpus sets the number of virtual CPUs of the virtual machine.
+func (vm *VirtualMachine) SetVcpus(vcpus uint) {
+	vm.vcpus = vcpus
+}
+
+// SetMemoryBytes sets the amount of RAM of the virtual machine, in bytes.
+func (vm *VirtualMachine) SetMemoryBytes(memoryBytes uint64) {
+	vm.memoryBytes = memoryBytes
+}
+
+// SetBootloader sets the bootloader used to start the virtual machine.
+func (vm *VirtualMachine) SetBootloader(bootloader Bootloader) {
+	vm.bootloader = bootloader
+}
+
+// AppendKernelCmdLine appends args to the kernel command line of the linux
+// bootloader of vm.
+func (vm *VirtualMachine) AppendKernelCmdLine(args string) error {
+	bootloader, ok := vm.bootloader.(*LinuxBootloader)
+	if !ok {
+		return fmt.Errorf("kernel command line arguments can only be appended with a linux bootloader")
+	}
+	bootloader.AppendKernelCmdLine(args)
+	return nil
+}
+
 func (vm *VirtualMachine) AddTimeSyncFromCmdLine(cmdlineOpts string) error {
 	if cmdlineOpts == "" {
 		return nil
 	}
-	timesync, err := timesyncFromCmdLine(cmdlineOpts)
+	options, err := timesync.Parse(cmdlineOpts)
+	if err != nil {
+		return err
+	}
+	vm.SetTimeSync(options)
+
+	return nil
+}
+
+// SetTimeSync enables the guest time synchronization with options, it's
+// disabled when options is nil.
+func (vm *VirtualMachine) SetTimeSync(options *timesync.Options) {
+	if options == nil {
+		vm.timesync = nil
+		return
+	}
+	vm.timesync = &TimeSync{options: *options}
+}
+
+// AddSwapFromCmdLine parses the value of the --swap command line argument.
+func (vm *VirtualMachine) AddSwapFromCmdLine(cmdlineOpts string) error {
+	if cmdlineOpts == "" {
+		return nil
+	}
+	swap, err := SwapFromCmdLine(cmdlineOpts)
 	if err != nil {
 		return err
 	}
-	vm.timesync = timesync
+	vm.swap = swap
+
+	return nil
+}
+
+// AddCacheProxyFromCmdLine parses the value of the --cache-proxy command line
+// argument.
+func (vm *VirtualMachine) AddCacheProxyFromCmdLine(cmdlineOpts string) error {
+	if cmdlineOpts == "" {
+		return nil
+	}
+	proxy, err := CacheProxyFromCmdLine(cmdlineOpts)
+	if err != nil {
+		return err
+	}
+	vm.cacheProxy = proxy
+
+	return nil
+}
+
+// AddGVProxyFromCmdLine parses the value of the --gvproxy command line
+// argument. It adds the virtio-net device connected to gvproxy, and the
+// virtio-vsock device used by the guest to reach it when a vsock port is set.
+func (vm *VirtualMachine) AddGVProxyFromCmdLine(cmdlineOpts string) error {
+	if cmdlineOpts == "" {
+		return nil
+	}
+	for _, dev := range vm.devices {
+		if _, isVirtioNet := dev.(*virtioNet); isVirtioNet {
+			return fmt.Errorf("gvproxy cannot be used with other virtio-net devices")
+		}
+	}
+	proxy, err := GVProxyFromCmdLine(cmdlineOpts)
+	if err != nil {
+		return err
+	}
+	vm.gvproxy = proxy
+	vm.devices = append(vm.devices, proxy.net)
+	if proxy.vsock != nil {
+		vm.devices = append(vm.devices, proxy.vsock)
+	}
+
+	return nil
+}
+
+// GVProxy returns the gvproxy configuration, nil when vfkit must not start
+// gvproxy.
+func (vm *VirtualMachine) GVProxy() *GVProxy {
+	return vm.gvproxy
+}
+
+// AddEgressRulesFromCmdLine parses the values of the --egress command line
+// arguments, they are appended to the existing egress rules.
+func (vm *VirtualMachine) AddEgressRulesFromCmdLine(cmdlineOpts []string) error {
+	if _, err := egress.ParsePolicy(cmdlineOpts); err != nil {
+		return err
+	}
+	vm.egress = append(vm.egress, cmdlineOpts...)
+
+	return nil
+}
+
+// EgressPolicy returns the rules restricting the network traffic of the
+// guest, or nil if there are none.
+func (vm *VirtualMachine) EgressPolicy() *egress.Policy {
+	if len(vm.egress) == 0 {
+		return nil
+	}
+	// the entries were validated by AddEgressRulesFromCmdLine
+	policy, _ := egress.ParsePolicy(vm.egress)
+	return policy
+}
+
+// AddPortForwardsFromCmdLine parses the values of the --publish command line
+// arguments.
+func (vm *VirtualMachine) AddPortForwardsFromCmdLine(cmdlineOpts []string) error {
+	for _, publishOpts := range cmdlineOpts {
+		pf, err := PortForwardFromCmdLine(publishOpts)
+		if err != nil {
+			return err
+		}
+		vm.publish = append(vm.publish, pf)
+	}
 
 	return nil
 }
 
+// PortForwards returns the host ports which are forwarded to the guest.
+func (vm *VirtualMachine) PortForwards() []*PortForward {
+	return vm.publish
+}
+
 func (vm *VirtualMachine) AddDevicesFromCmdLine(cmdlineOpts []string) error {
 	for _, deviceOpts := range cmdlineOpts {
 		dev, err := deviceFromCmdLine(deviceOpts)
@@ -56,19 +242,299 @@ func (vm *VirtualMachine) AddDevicesFromCmdLine(cmdlineOpts []string) error {
 	return nil
 }
 
+// GenerateArtifactPaths sets the host paths which were not explicitly
+// configured (virtio-vsock unix sockets, virtio-serial log files, proxy
+// cache, gvproxy sockets, machine identifier)
+// using tmpl.
+// The directories containing the generated paths are created.
+func (vm *VirtualMachine) GenerateArtifactPaths(tmpl *naming.Template) error {
+	if vm.gvproxy != nil {
+		if err := vm.gvproxy.generatePaths(tmpl); err != nil {
+			return err
+		}
+	}
+	serialIndex := 0
+	for _, dev := range vm.devices {
+		var path *string
+		switch dev := dev.(type) {
+		case *VirtioVsock:
+			for i := range dev.Forwards {
+				forward := &dev.Forwards[i]
+				if forward.SocketURL != "" {
+					continue
+				}
+				forward.SocketURL = tmpl.Path(naming.VsockDeviceID(forward.Port), "sock")
+				log.Debugf("using generated path %s", forward.SocketURL)
+				if err := os.MkdirAll(filepath.Dir(forward.SocketURL), 0700); err != nil {
+					return err
+				}
+			}
+			if dev.Port != 0 && dev.SocketURL == "" {
+				dev.SocketURL = tmpl.Path(naming.VsockDeviceID(dev.Port), "sock")
+				path = &dev.SocketURL
+			}
+		case *virtioSerial:
+			if dev.console && dev.consoleSocket == "" {
+				dev.consoleSocket = tmpl.Path(dev.ArtifactID(serialIndex), "sock")
+				log.Debugf("using generated path %s", dev.consoleSocket)
+			}
+			// pseudo-terminals have no log file
+			if dev.logFile == "" && !dev.pty {
+				dev.logFile = tmpl.Path(dev.ArtifactID(serialIndex), "log")
+				path = &dev.logFile
+			}
+			serialIndex++
+		}
+		if path == nil {
+			continue
+		}
+		log.Debugf("using generated path %s", *path)
+		if err := os.MkdirAll(filepath.Dir(*path), 0700); err != nil {
+			return err
+		}
+	}
+
+	if vm.platform != nil && vm.platform.machineIdentifierPath == "" {
+		vm.platform.machineIdentifierPath = tmpl.Path(naming.MachineIdentifierID, "bin")
+		log.Debugf("using generated path %s", vm.platform.machineIdentifierPath)
+	}
+
+	if vm.cacheProxy != nil && vm.cacheProxy.cacheDir == "" {
+		// the cache is shared by all the virtual machines
+		vm.cacheProxy.cacheDir = filepath.Join(tmpl.StateDir(), cacheProxyDirName)
+		log.Debugf("using generated path %s", vm.cacheProxy.cacheDir)
+	}
+
+	return nil
+}
+
+// ConvertDiskImages converts the qcow2 and vmdk disk images of the virtio-blk
+// devices of vm to raw images, which are stored at paths generated with tmpl.
+// Conversions are cached, see diskimage.ConvertCached.
+func (vm *VirtualMachine) ConvertDiskImages(tmpl *naming.Template) error {
+	diskIndex := 0
+	for _, dev := range vm.devices {
+		blkDev, isVirtioBlk := dev.(*virtioBlk)
+		if !isVirtioBlk {
+			continue
+		}
+		if blkDev.imagePath != "" && blkDev.ramSizeBytes == 0 {
+			rawPath, err := diskimage.ConvertCached(blkDev.imagePath, tmpl.Path(naming.DiskDeviceID(diskIndex), "raw"))
+			if err != nil {
+				return err
+			}
+			if rawPath != blkDev.imagePath {
+				blkDev.rawImagePath = rawPath
+			}
+		}
+		diskIndex++
+	}
+
+	return nil
+}
+
+// CheckDiskImages verifies that the disk images of the virtio-blk devices of
+// vm are in a supported format, without converting them. It's used by
+// --dry-run instead of ConvertDiskImages.
+func (vm *VirtualMachine) CheckDiskImages() error {
+	for _, dev := range vm.devices {
+		if blkDev, isVirtioBlk := dev.(*virtioBlk); isVirtioBlk && blkDev.imagePath != "" && blkDev.ramSizeBytes == 0 {
+			if _, err := diskimage.Detect(blkDev.imagePath); err != nil {
+				return fmt.Errorf("virtio-blk %s: %w", blkDev.imagePath, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Validate runs the checks of ToVzVirtualMachineConfig, including the
+// validation by the virtualization framework, without modifying the host
+// files the configuration refers to: the EFI variable store to create is
+// replaced by a file in a temporary directory while the configuration is
+// validated, as are the RAM devices of RAM disks which are only created when
+// starting the virtual machine. The virtio-serial devices are connected to
+// /dev/null, their log files, consoles and pseudo-terminals are not opened,
+// so that log files are not truncated. The virtio-net
+// devices connected to a unixgram socket are connected to a placeholder
+// socket in this directory instead, so that gvproxy is not needed, and a new
+// machine identifier of the generic platform is not stored. It's used by
+// --dry-run.
+func (vm *VirtualMachine) Validate() error {
+	tmpDir, err := os.MkdirTemp("", "vfkit-dry-run")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if efi, isEFI := vm.bootloader.(*EFIBootloader); isEFI && efi.createVariableStore {
+		path := efi.efiVariableStorePath
+		efi.efiVariableStorePath = filepath.Join(tmpDir, "efi-variable-store")
+		defer func() { efi.efiVariableStorePath = path }()
+	}
+	if vm.platform != nil {
+		vm.platform.ephemeral = true
+		defer func() { vm.platform.ephemeral = false }()
+	}
+	for i, dev := range vm.devices {
+		switch dev := dev.(type) {
+		case *virtioSerial:
+			if dev.logFile == "" && !dev.pty {
+				// missing log file, reported by ToVzVirtualMachineConfig
+				continue
+			}
+			devNull, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+			if err != nil {
+				return err
+			}
+			defer devNull.Close()
+			dev.devNull = devNull
+			defer func() { dev.devNull = nil }()
+		case *virtioBlk:
+			if dev.ramSizeBytes == 0 {
+				continue
+			}
+			path := dev.imagePath
+			dev.imagePath = filepath.Join(tmpDir, fmt.Sprintf("disk-%d.img", i))
+			defer func() { dev.imagePath = path }()
+			if err := createSparseFile(dev.imagePath, dev.ramSizeBytes); err != nil {
+				return err
+			}
+		case *virtioNet:
+			if dev.unixSocketPath == "" {
+				continue
+			}
+			// the attachment connects to the socket and sends the
+			// handshake, gvproxy must not see it
+			path := dev.unixSocketPath
+			dev.unixSocketPath = filepath.Join(tmpDir, fmt.Sprintf("net-%d.sock", i))
+			defer func() { dev.unixSocketPath = path }()
+			conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: dev.unixSocketPath, Net: "unixgram"})
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+		}
+	}
+
+	_, err = vm.ToVzVirtualMachineConfig()
+	return err
+}
+
 func (vm *VirtualMachine) ToVzVirtualMachineConfig() (*vz.VirtualMachineConfiguration, error) {
 	vzBootloader, err := vm.bootloader.toVzBootloader()
 	if err != nil {
 		return nil, err
 	}
+	if err := vm.checkNetboot(); err != nil {
+		return nil, err
+	}
+	if err := vm.checkSerialIDs(); err != nil {
+		return nil, err
+	}
+	if err := vm.checkBootOrder(); err != nil {
+		return nil, err
+	}
 
 	vzVMConfig, err := vz.NewVirtualMachineConfiguration(vzBootloader, vm.vcpus, vm.memoryBytes)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, dev := range vm.devices {
+	if vm.platform != nil {
+		platformConfig, err := vm.platform.toVzPlatformConfig()
+		if err != nil {
+			return nil, err
+		}
+		vzVMConfig.SetPlatformVirtualMachineConfiguration(platformConfig)
+	}
+
+	storageDevices := []vz.StorageDeviceConfiguration{}
+	serialPorts := []*vz.VirtioConsoleDeviceSerialPortConfiguration{}
+	networkDevices := []*vz.VirtioNetworkDeviceConfiguration{}
+	sharingDevices := []vz.DirectorySharingDeviceConfiguration{}
+	for i, dev := range vm.devices {
+		log.WithField("device", i).Debugf("device configuration: %+v", dev)
+		// the devices of a kind are set all at once, setting them one
+		// by one would only keep the last one
+		switch dev := dev.(type) {
+		case *virtioBlk, *usbMassStorage:
+			// storage devices are added all at once, in boot order
+			continue
+		case *virtioSerial:
+			serialPort, err := dev.toVzSerialPortConfig()
+			if err != nil {
+				return nil, fmt.Errorf("device %d: %w", i, err)
+			}
+			serialPorts = append(serialPorts, serialPort)
+			continue
+		case *virtioNet:
+			networkConfig, err := dev.toVzNetworkDeviceConfig()
+			if err != nil {
+				return nil, fmt.Errorf("device %d: %w", i, err)
+			}
+			networkDevices = append(networkDevices, networkConfig)
+			continue
+		case *virtioFs:
+			fileSystemDeviceConfig, err := dev.toVzDirectorySharingDeviceConfig()
+			if err != nil {
+				return nil, fmt.Errorf("device %d: %w", i, err)
+			}
+			sharingDevices = append(sharingDevices, fileSystemDeviceConfig)
+			continue
+		}
 		if err := dev.AddToVirtualMachineConfig(vzVMConfig); err != nil {
+			return nil, fmt.Errorf("device %d: %w", i, err)
+		}
+	}
+	if len(serialPorts) != 0 {
+		vzVMConfig.SetSerialPortsVirtualMachineConfiguration(serialPorts)
+	}
+	if len(networkDevices) != 0 {
+		vzVMConfig.SetNetworkDevicesVirtualMachineConfiguration(networkDevices)
+	}
+	if len(sharingDevices) != 0 {
+		vzVMConfig.SetDirectorySharingDevicesVirtualMachineConfiguration(sharingDevices)
+	}
+	// USB mass storage devices come first, so that the EFI firmware boots
+	// the installer of --cdrom
+	for _, dev := range vm.devices {
+		usbDev, isUSB := dev.(*usbMassStorage)
+		if !isUSB {
+			continue
+		}
+		storageDeviceConfig, err := usbDev.toVzStorageDeviceConfig()
+		if err != nil {
+			return nil, fmt.Errorf("usb-mass-storage %s: %w", usbDev.imagePath, err)
+		}
+		storageDevices = append(storageDevices, storageDeviceConfig)
+	}
+	for _, dev := range vm.bootOrderedDisks() {
+		storageDeviceConfig, err := dev.toVzStorageDeviceConfig()
+		if err != nil {
+			return nil, fmt.Errorf("virtio-blk %s: %w", dev.imagePath, err)
+		}
+		storageDevices = append(storageDevices, storageDeviceConfig)
+	}
+	if len(storageDevices) != 0 {
+		vzVMConfig.SetStorageDevicesVirtualMachineConfiguration(storageDevices)
+	}
+
+	for _, pf := range vm.publish {
+		if pf.Transport() != PortForwardVsock {
+			continue
+		}
+		// ports published over vsock need a vsock device
+		vsockDev := VirtioVsock{}
+		if err := vsockDev.AddToVirtualMachineConfig(vzVMConfig); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(vm.WatchedShares()) != 0 || vm.swap != nil || vm.cacheProxy != nil || vm.guestAgent {
+		// the guest agent is reached over vsock
+		vsockDev := VirtioVsock{}
+		if err := vsockDev.AddToVirtualMachineConfig(vzVMConfig); err != nil {
 			return nil, err
 		}
 	}
@@ -99,6 +565,63 @@ func (vm *VirtualMachine) TimeSync() *TimeSync {
 	return vm.timesync
 }
 
+// Swap returns the swapfile configuration, nil when no swapfile must be
+// provisioned.
+func (vm *VirtualMachine) Swap() *Swap {
+	return vm.swap
+}
+
+// EnableGuestAgent adds the virtio-vsock device used to reach the vfkit guest
+// agent, it is otherwise only added when an option needs the guest agent.
+func (vm *VirtualMachine) EnableGuestAgent() {
+	vm.guestAgent = true
+}
+
+// CacheProxy returns the caching proxy configuration, nil when the guest must
+// not use a proxy.
+func (vm *VirtualMachine) CacheProxy() *CacheProxy {
+	return vm.cacheProxy
+}
+
+// VsockForwards returns the port mappings of all the virtio-vsock devices.
+func (vm *VirtualMachine) VsockForwards() []VsockForward {
+	forwards := []VsockForward{}
+	for _, dev := range vm.VirtioVsockDevices() {
+		forwards = append(forwards, dev.VsockForwards()...)
+	}
+
+	return forwards
+}
+
+// Shares returns the directories shared with the guest by the virtio-fs
+// devices of vm.
+func (vm *VirtualMachine) Shares() []Share {
+	shares := []Share{}
+	for _, dev := range vm.devices {
+		if fsDev, isVirtioFs := dev.(*virtioFs); isVirtioFs {
+			shares = append(shares, Share{SharedDir: fsDev.sharedDir, MountTag: fsDev.MountTag()})
+		}
+	}
+
+	return shares
+}
+
+// WatchedShares returns the virtio-fs shares configured with the 'notify'
+// option.
+func (vm *VirtualMachine) WatchedShares() []WatchedShare {
+	shares := []WatchedShare{}
+	for _, dev := range vm.devices {
+		if fsDev, isVirtioFs := dev.(*virtioFs); isVirtioFs && fsDev.notifyPort != 0 {
+			shares = append(shares, WatchedShare{
+				Share:     Share{SharedDir: fsDev.sharedDir, MountTag: fsDev.MountTag()},
+				AgentPort: fsDev.notifyPort,
+			})
+		}
+	}
+
+	return shares
+}
+
 func (vm *VirtualMachine) VirtioVsockDevices() []*VirtioVsock {
 	vsockDevs := []*VirtioVsock{}
 	for _, dev := range vm.devices {
@@ -110,28 +633,201 @@ func (vm *VirtualMachine) VirtioVsockDevices() []*VirtioVsock {
 	return vsockDevs
 }
 
-func timesyncFromCmdLine(optsStr string) (*TimeSync, error) {
-	var timesync TimeSync
+// bootOrderedDisks returns the virtio-blk devices of vm, sorted according to
+// the boot order of the EFI bootloader. The disks missing from the boot order
+// come last, in command line order.
+func (vm *VirtualMachine) bootOrderedDisks() []*virtioBlk {
+	disks := []*virtioBlk{}
+	for _, dev := range vm.devices {
+		if blkDev, isVirtioBlk := dev.(*virtioBlk); isVirtioBlk {
+			disks = append(disks, blkDev)
+		}
+	}
+	efi, isEFI := vm.bootloader.(*EFIBootloader)
+	if !isEFI || len(efi.bootOrder) == 0 {
+		return disks
+	}
 
-	optsStrv := strings.Split(optsStr, ",")
-	options := strvToOptions(optsStrv)
+	ordered := make([]*virtioBlk, 0, len(disks))
+	used := make([]bool, len(disks))
+	for _, index := range efi.bootOrder {
+		if index < len(disks) && !used[index] {
+			ordered = append(ordered, disks[index])
+			used[index] = true
+		}
+	}
+	for i, disk := range disks {
+		if !used[i] {
+			ordered = append(ordered, disk)
+		}
+	}
 
-	for _, option := range options {
-		switch option.key {
-		case "vsockPort":
-			vsockPort, err := strconv.ParseUint(option.value, 10, 64)
-			if err != nil {
-				return nil, err
-			}
-			timesync.vsockPort = uint(vsockPort)
-		default:
-			return nil, fmt.Errorf("Unknown option for timesync parameter: %s", option.key)
+	return ordered
+}
+
+// checkBootOrder verifies that the boot order of the EFI bootloader can be
+// honoured: all its disks must exist. USB mass storage devices, such as the
+// ISO images of --cdrom, are always booted before the virtio-blk disks.
+func (vm *VirtualMachine) checkBootOrder() error {
+	efi, isEFI := vm.bootloader.(*EFIBootloader)
+	if !isEFI || len(efi.bootOrder) == 0 {
+		return nil
+	}
+	disks := 0
+	for _, dev := range vm.devices {
+		switch dev.(type) {
+		case *usbMassStorage:
+			return fmt.Errorf("EFI bootloader 'order' option cannot be used with --cdrom or usb-mass-storage devices, they are always booted first")
+		case *virtioBlk:
+			disks++
 		}
 	}
+	for _, index := range efi.bootOrder {
+		if index >= disks {
+			return fmt.Errorf("boot device disk%d of EFI bootloader 'order' option does not exist, there are %d virtio-blk devices", index, disks)
+		}
+	}
+
+	return nil
+}
 
-	if timesync.vsockPort == 0 {
-		return nil, fmt.Errorf("Missing 'vsockPort' option for timesync parameter")
+// CheckArchitecture returns an *arch.MismatchError if the kernel of the Linux
+// bootloader, or the first disk booted by the EFI bootloader, does not have
+// the architecture of the host. Guests whose architecture cannot be detected
+// are accepted.
+func (vm *VirtualMachine) CheckArchitecture() error {
+	var path string
+	var detect func(string) (arch.Arch, error)
+	switch bootloader := vm.bootloader.(type) {
+	case *LinuxBootloader:
+		path, detect = bootloader.vmlinuzPath, arch.KernelArch
+	case *EFIBootloader:
+		disks := vm.bootOrderedDisks()
+		if len(disks) == 0 {
+			return nil
+		}
+		path, detect = disks[0].imagePath, arch.EFIDiskArch
+		if disks[0].rawImagePath != "" {
+			path = disks[0].rawImagePath
+		}
+	default:
+		return nil
+	}
+	guestArch, err := detect(path)
+	if err != nil {
+		log.Debugf("cannot detect the architecture of %s: %v", path, err)
+		return nil
+	}
+
+	return arch.Check(path, guestArch)
+}
+
+// DiskImagePaths returns the paths to the disk images used by the virtio-blk
+// devices of vm.
+func (vm *VirtualMachine) DiskImagePaths() []string {
+	paths := []string{}
+	for _, dev := range vm.devices {
+		if blkDev, isVirtioBlk := dev.(*virtioBlk); isVirtioBlk {
+			paths = append(paths, blkDev.imagePath)
+		}
+	}
+
+	return paths
+}
+
+// SerialLogPaths returns the paths of the log files of the virtio-serial
+// devices of vm.
+func (vm *VirtualMachine) SerialLogPaths() []string {
+	paths := []string{}
+	for _, dev := range vm.devices {
+		if serialDev, isVirtioSerial := dev.(*virtioSerial); isVirtioSerial && serialDev.logFile != "" {
+			paths = append(paths, serialDev.logFile)
+		}
+	}
+
+	return paths
+}
+
+// checkSerialIDs verifies that the 'id' options of the virtio-serial devices
+// are unique, they name their artifacts.
+func (vm *VirtualMachine) checkSerialIDs() error {
+	ids := map[string]bool{}
+	for _, dev := range vm.devices {
+		serialDev, isVirtioSerial := dev.(*virtioSerial)
+		if !isVirtioSerial || serialDev.id == "" {
+			continue
+		}
+		if ids[serialDev.id] {
+			return fmt.Errorf("duplicate virtio-serial id '%s'", serialDev.id)
+		}
+		ids[serialDev.id] = true
+	}
+	return nil
+}
+
+// SerialPTYs returns the pseudo-terminals of the virtio-serial devices of vm
+// with the 'pty' option. They are opened by ToVzVirtualMachineConfig.
+func (vm *VirtualMachine) SerialPTYs() []*console.PTY {
+	ptys := []*console.PTY{}
+	for _, dev := range vm.devices {
+		if serialDev, isVirtioSerial := dev.(*virtioSerial); isVirtioSerial && serialDev.ptyDev != nil {
+			ptys = append(ptys, serialDev.ptyDev)
+		}
+	}
+
+	return ptys
+}
+
+// SerialConsole is the console of a virtio-serial device with the 'console'
+// option.
+type SerialConsole struct {
+	Console    *console.Console
+	SocketPath string
+	LogFile    string
+}
+
+// SerialConsoles returns the consoles of the virtio-serial devices of vm. They
+// are created by ToVzVirtualMachineConfig.
+func (vm *VirtualMachine) SerialConsoles() []SerialConsole {
+	consoles := []SerialConsole{}
+	for _, dev := range vm.devices {
+		if serialDev, isVirtioSerial := dev.(*virtioSerial); isVirtioSerial && serialDev.serialConsole != nil {
+			consoles = append(consoles, SerialConsole{
+				Console:    serialDev.serialConsole,
+				SocketPath: serialDev.consoleSocket,
+				LogFile:    serialDev.logFile,
+			})
+		}
+	}
+
+	return consoles
+}
+
+// MACAddresses returns the MAC addresses of the virtio-net devices of vm which
+// have an explicit MAC address. Devices using a random MAC address are
+// omitted.
+func (vm *VirtualMachine) MACAddresses() []net.HardwareAddr {
+	macs := []net.HardwareAddr{}
+	for _, dev := range vm.devices {
+		if netDev, isVirtioNet := dev.(*virtioNet); isVirtioNet && len(netDev.macAddress) != 0 {
+			macs = append(macs, netDev.macAddress)
+		}
+	}
+
+	return macs
+}
+
+func timesyncFromOptions(options []option) (*TimeSync, error) {
+	var ts TimeSync
+
+	for _, option := range options {
+		if err := ts.options.Set(option.key, option.value); err != nil {
+			return nil, err
+		}
+	}
+	if err := ts.options.Validate(); err != nil {
+		return nil, err
 	}
 
-	return &timesync, nil
+	return &ts, nil
 }
@@ -0,0 +1,434 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/crc-org/vfkit/pkg/agent"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestDeviceFromCmdLine(t *testing.T) {
+	valid := []string{
+		"virtio-blk,path=/tmp/disk.img",
+		"virtio-blk,ram=1GiB",
+		"virtio-net,nat,mac=72:20:43:d4:38:62,subnet=192.168.64.0/24,ip=192.168.64.10,hostname=vm",
+		"virtio-net,unixSocketPath=/tmp/net.sock,mtu=9000",
+		"virtio-fs,sharedDir=/tmp,mountTag=tmp,notify,caseSensitive,xattr",
+		"virtio-vsock,port=1024,socketURL=/tmp/vsock.sock,forward=1025:/tmp/agent.sock:connect",
+		"virtio-serial,id=console,logFilePath=/tmp/serial.log",
+		"virtio-rng",
+		"virtio-balloon",
+	}
+	for _, devOpts := range valid {
+		if _, err := deviceFromCmdLine(devOpts); err != nil {
+			t.Fatalf("expected no error for %s; got %v", devOpts, err)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"virtio-gpu",
+		"virtio-blk,path=/tmp/disk.img,ram=1GiB",
+		"virtio-blk,ram=lots",
+		"virtio-blk,cache=none",
+		"virtio-blk,path=/tmp/disk.img,sync=none",
+		"virtio-blk,path=/tmp/disk.img,discard",
+		"virtio-blk,path=/tmp/disk.img,queues=4",
+		"virtio-net,nat=yes",
+		"virtio-net,nat,unixSocketPath=/tmp/net.sock",
+		"virtio-net,subnet=192.168.64.0/24",
+		"virtio-net,nat,subnet=fd00::/64",
+		"virtio-net,nat,ip=192.168.64.10",
+		"virtio-net,nat,mac=72:20:43:d4:38:62,subnet=192.168.64.0/24,ip=192.168.65.10",
+		"virtio-net,nat,mtu=9000",
+		"virtio-net,unixSocketPath=/tmp/net.sock,mtu=1000",
+		"virtio-net,unixSocketPath=/tmp/net.sock,tso=off",
+		"virtio-net,nat,queues=4",
+		"virtio-net,mac=invalid",
+		"virtio-fs,sharedDir=/tmp,notify=0",
+		"virtio-fs,sharedDir=/tmp,cache=always",
+		"virtio-fs,sharedDir=/tmp,dax=2GiB",
+		"virtio-fs,sharedDir=/tmp,queueSize=1024",
+		"virtio-vsock,port=abc",
+		"virtio-vsock,forward=0",
+		"virtio-serial,id=0console",
+		"virtio-serial,pty,console",
+		"virtio-serial,pty,logFilePath=/tmp/serial.log",
+		"virtio-rng,src=/dev/random",
+		"virtio-rng,maxBytes=1024,period=1s",
+		"virtio-balloon,size=1GiB",
+		"virtio-pmem,path=/tmp/cache.img,size=512MiB",
+	}
+	for _, devOpts := range invalid {
+		if _, err := deviceFromCmdLine(devOpts); err == nil {
+			t.Fatalf("expected error for %s", devOpts)
+		}
+	}
+}
+
+func TestParseVsockForward(t *testing.T) {
+	tests := map[string]VsockForward{
+		"1025":                      {Port: 1025, Listen: true},
+		"1025:connect":              {Port: 1025},
+		"1025:/tmp/vsock.sock":      {Port: 1025, SocketURL: "/tmp/vsock.sock", Listen: true},
+		"1025:/tmp/a:b.sock:listen": {Port: 1025, SocketURL: "/tmp/a:b.sock", Listen: true},
+		"1025:/tmp/vsock.sock:connect": {
+			Port:      1025,
+			SocketURL: "/tmp/vsock.sock",
+		},
+	}
+	for str, expected := range tests {
+		forward, err := parseVsockForward(str)
+		if err != nil {
+			t.Fatalf("expected no error for %s; got %v", str, err)
+		}
+		if *forward != expected {
+			t.Fatalf("unexpected forward for %s: %+v", str, forward)
+		}
+	}
+
+	for _, invalid := range []string{"", "0", "-1", "abc:/tmp/vsock.sock", "4294967296"} {
+		if _, err := parseVsockForward(invalid); err == nil {
+			t.Fatalf("expected error for %s", invalid)
+		}
+	}
+}
+
+func TestRestartFromCmdLine(t *testing.T) {
+	restart, err := RestartFromCmdLine("on-failure,maxRetries=3,window=1m,delay=5s")
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	expected := Restart{policy: RestartOnFailure, maxRetries: 3, window: time.Minute, delay: 5 * time.Second}
+	if *restart != expected {
+		t.Fatalf("unexpected restart configuration: %+v", restart)
+	}
+	if !restart.ShouldRestart(true, false) || restart.ShouldRestart(false, false) {
+		t.Fatal("unexpected restart decision for on-failure policy")
+	}
+
+	for _, invalid := range []string{"", "sometimes", "always,maxRetries=-1", "always,maxRetries=many", "always,window=0s", "always,delay=soon", "always,backoff=2"} {
+		if _, err := RestartFromCmdLine(invalid); err == nil {
+			t.Fatalf("expected error for %s", invalid)
+		}
+	}
+}
+
+func TestPortForwardFromCmdLine(t *testing.T) {
+	tests := map[string]string{
+		"8080:80":               "127.0.0.1:8080 -> guest nat port 80",
+		"0.0.0.0:8080:80":       "0.0.0.0:8080 -> guest nat port 80",
+		"[::1]:8080:80/nat":     "[::1]:8080 -> guest nat port 80",
+		"2222:1024/vsock":       "127.0.0.1:2222 -> guest vsock port 1024",
+		"localhost:2222:22/nat": "localhost:2222 -> guest nat port 22",
+		"2222:65536/vsock":      "127.0.0.1:2222 -> guest vsock port 65536",
+	}
+	for str, expected := range tests {
+		pf, err := PortForwardFromCmdLine(str)
+		if err != nil {
+			t.Fatalf("expected no error for %s; got %v", str, err)
+		}
+		if pf.String() != expected {
+			t.Fatalf("unexpected port forward for %s: %s", str, pf)
+		}
+	}
+
+	for _, invalid := range []string{"", "8080", "8080:80/udp", "0:80", "65536:80", "8080:0", "8080:65536", "8080:65536/nat", "8080:4294967296/vsock", "8080:http", "a:b:c"} {
+		if _, err := PortForwardFromCmdLine(invalid); err == nil {
+			t.Fatalf("expected error for %s", invalid)
+		}
+	}
+}
+
+func TestBootloaderFromCmdLine(t *testing.T) {
+	bootloader, err := BootloaderFromCmdLine([]string{"efi", "variable-store=/tmp/efistore", "create", "order=disk1:disk0"})
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	efi, isEFI := bootloader.(*EFIBootloader)
+	if !isEFI {
+		t.Fatalf("unexpected bootloader type: %T", bootloader)
+	}
+	if efi.efiVariableStorePath != "/tmp/efistore" || !efi.createVariableStore || !reflect.DeepEqual(efi.bootOrder, []int{1, 0}) {
+		t.Fatalf("unexpected EFI bootloader: %+v", efi)
+	}
+
+	invalid := [][]string{
+		{},
+		{"bios"},
+		{"efi", "create=yes"},
+		{"efi", "secure-boot"},
+		{"efi", "order="},
+		{"efi", "order=net"},
+		{"efi", "order=cdrom"},
+		{"efi", "order=diskA"},
+		{"efi", "order=disk-1"},
+		{"efi", "order=disk0:disk0"},
+		{"efi", "netboot=tftpPort=69"},
+		{"linux", "kernel=/tmp/vmlinuz", "append=console=hvc0"},
+	}
+	for _, optsStrv := range invalid {
+		if _, err := BootloaderFromCmdLine(optsStrv); err == nil {
+			t.Fatalf("expected error for %v", optsStrv)
+		}
+	}
+}
+
+func TestNetbootFromOptionValue(t *testing.T) {
+	netboot, err := netbootFromOptionValue("dir=/srv/tftp,httpPort=0")
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if netboot.Dir() != "/srv/tftp" || netboot.TFTPPort() != defaultNetbootTFTPPort || netboot.HTTPPort() != 0 {
+		t.Fatalf("unexpected netboot configuration: %+v", netboot)
+	}
+
+	for _, invalid := range []string{"", "tftpPort=69", "dir=/srv/tftp,tftpPort=0,httpPort=0", "dir=/srv/tftp,httpPort=65536", "dir=/srv/tftp,nfs"} {
+		if _, err := netbootFromOptionValue(invalid); err == nil {
+			t.Fatalf("expected error for %s", invalid)
+		}
+	}
+}
+
+func TestSwapFromCmdLine(t *testing.T) {
+	for _, optsStr := range []string{"2GiB", "size=2GiB,path=/var/swapfile,agentPort=1030"} {
+		swap, err := SwapFromCmdLine(optsStr)
+		if err != nil {
+			t.Fatalf("expected no error for %s; got %v", optsStr, err)
+		}
+		if swap.SizeBytes() != 2*1024*1024*1024 {
+			t.Fatalf("unexpected swap size for %s: %d", optsStr, swap.SizeBytes())
+		}
+	}
+
+	for _, invalid := range []string{"", "path=/swapfile", "size=lots", "size=2GiB,path=swapfile", "size=2GiB,agentPort=0", "size=2GiB,priority=1"} {
+		if _, err := SwapFromCmdLine(invalid); err == nil {
+			t.Fatalf("expected error for %s", invalid)
+		}
+	}
+}
+
+func TestWatchdogFromCmdLine(t *testing.T) {
+	watchdog, err := WatchdogFromCmdLine("action=poweroff,timeout=1m")
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if watchdog.Action() != WatchdogPoweroff || watchdog.Timeout() != time.Minute || watchdog.Interval() != 20*time.Second || watchdog.AgentPort() != agent.DefaultVsockPort {
+		t.Fatalf("unexpected watchdog configuration: %+v", watchdog)
+	}
+
+	for _, invalid := range []string{"action=reboot", "timeout=0s", "timeout=10s,interval=10s", "interval=-1s", "agentPort=0", "timeout=soon", "grace=1m"} {
+		if _, err := WatchdogFromCmdLine(invalid); err == nil {
+			t.Fatalf("expected error for %s", invalid)
+		}
+	}
+}
+
+func TestGVProxyFromCmdLine(t *testing.T) {
+	proxy, err := GVProxyFromCmdLine("binary=/usr/local/bin/gvproxy,mtu=9000,sshPort=2222,vsockPort=1024,debug")
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if proxy.binary != "/usr/local/bin/gvproxy" || proxy.net.mtu != 9000 || proxy.sshPort != 2222 || !proxy.debug {
+		t.Fatalf("unexpected gvproxy configuration: %+v", proxy)
+	}
+	if proxy.vsock == nil || proxy.vsock.Port != 1024 || !proxy.vsock.Listen {
+		t.Fatalf("unexpected gvproxy vsock device: %+v", proxy.vsock)
+	}
+
+	for _, invalid := range []string{"mac=invalid", "mtu=100", "sshPort=0", "sshPort=65536", "vsockPort=0", "debug=yes", "dns=1.1.1.1"} {
+		if _, err := GVProxyFromCmdLine(invalid); err == nil {
+			t.Fatalf("expected error for %s", invalid)
+		}
+	}
+
+	vm := NewVirtualMachine(1, 1024*1024*1024, NewEFIBootloader("/tmp/efistore", true))
+	if err := vm.AddDevicesFromCmdLine([]string{"virtio-net,nat"}); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if err := vm.AddGVProxyFromCmdLine("sshPort=2222"); err == nil {
+		t.Fatal("expected error for gvproxy with another virtio-net device")
+	}
+}
+
+func TestPlatformFromCmdLine(t *testing.T) {
+	platform, err := PlatformFromCmdLine("generic,machineIdentifier=/tmp/machine-id")
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if platform.MachineIdentifierPath() != "/tmp/machine-id" {
+		t.Fatalf("unexpected machine identifier path: %s", platform.MachineIdentifierPath())
+	}
+
+	for _, invalid := range []string{"", "mac", "generic=1", "generic,machineIdentifier=", "generic,ecid=1"} {
+		if _, err := PlatformFromCmdLine(invalid); err == nil {
+			t.Fatalf("expected error for %s", invalid)
+		}
+	}
+	if _, err := ParseGuestOS("macos"); err == nil {
+		t.Fatal("expected error for unknown guest OS")
+	}
+}
+
+// stubHostVolume makes the virtio-fs host volume checks return the given
+// properties until the end of the test.
+func stubHostVolume(t *testing.T, caseSensitive bool, xattr bool, err error) {
+	origIsCaseSensitive, origSupportsXattrs := isCaseSensitive, supportsXattrs
+	t.Cleanup(func() {
+		isCaseSensitive, supportsXattrs = origIsCaseSensitive, origSupportsXattrs
+	})
+	isCaseSensitive = func(string) (bool, error) {
+		return caseSensitive, err
+	}
+	supportsXattrs = func(string) (bool, error) {
+		return xattr, err
+	}
+}
+
+func TestCheckHostVolume(t *testing.T) {
+	hook := logtest.NewGlobal()
+	defer hook.Reset()
+	checkErr := errors.New("pathconf failed")
+
+	tests := []struct {
+		name          string
+		devOpts       string
+		caseSensitive bool
+		xattr         bool
+		err           error
+		expectError   bool
+		expectWarning bool
+	}{
+		{name: "case-sensitive volume", devOpts: "sharedDir=/tmp,caseSensitive,xattr", caseSensitive: true, xattr: true},
+		{name: "case-insensitive volume", devOpts: "sharedDir=/tmp", expectWarning: true},
+		{name: "case-insensitive volume with caseSensitive", devOpts: "sharedDir=/tmp,caseSensitive", expectError: true},
+		{name: "failed check", devOpts: "sharedDir=/tmp", err: checkErr},
+		{name: "failed check with caseSensitive", devOpts: "sharedDir=/tmp,caseSensitive", err: checkErr, expectError: true},
+		{name: "no xattr support", devOpts: "sharedDir=/tmp,xattr", caseSensitive: true, expectError: true},
+		{name: "failed check with xattr", devOpts: "sharedDir=/tmp,xattr", err: checkErr, expectError: true},
+	}
+	for _, test := range tests {
+		stubHostVolume(t, test.caseSensitive, test.xattr, test.err)
+		hook.Reset()
+
+		dev, err := deviceFromCmdLine("virtio-fs," + test.devOpts)
+		if err != nil {
+			t.Fatalf("%s: expected no error; got %v", test.name, err)
+		}
+		err = dev.(*virtioFs).checkHostVolume()
+		if test.expectError != (err != nil) {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+		warned := false
+		for _, entry := range hook.AllEntries() {
+			warned = warned || entry.Level == logrus.WarnLevel
+		}
+		if test.expectWarning != warned {
+			t.Fatalf("%s: unexpected log entries: %v", test.name, hook.AllEntries())
+		}
+	}
+}
+
+func TestLoadReader(t *testing.T) {
+	vm, err := LoadReader(strings.NewReader(`{
+		"cpus": 2,
+		"memory": "2GiB",
+		"bootloader": {"type": "efi", "variable-store": "/tmp/efistore", "create": true},
+		"devices": [
+			{"type": "virtio-blk", "path": "/tmp/disk.img"},
+			{"type": "virtio-net", "nat": true},
+			{"type": "virtio-vsock", "port": 1024, "forward": ["1025:/tmp/agent.sock"]}
+		],
+		"publish": ["2222:22"],
+		"swap": "1GiB"
+	}`))
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if vm.Vcpus() != 2 || vm.MemoryBytes() != 2*1024*1024*1024 || len(vm.devices) != 3 {
+		t.Fatalf("unexpected virtual machine: %+v", vm)
+	}
+	if forwards := vm.VsockForwards(); len(forwards) != 2 || forwards[1].SocketURL != "/tmp/agent.sock" {
+		t.Fatalf("unexpected vsock forwards: %+v", forwards)
+	}
+	if len(vm.PortForwards()) != 1 || vm.Swap() == nil {
+		t.Fatalf("unexpected port forwards or swap: %v %v", vm.PortForwards(), vm.Swap())
+	}
+
+	invalid := []string{
+		`{}`,
+		`{"cpus": 2, "bootloader": {"type": "efi"}}`,
+		`{"cpus": 2, "memory": true, "bootloader": {"type": "efi"}}`,
+		`{"cpus": 2, "memory": 2048}`,
+		`{"cpus": 2, "memory": 2048, "bootloader": {"variable-store": "/tmp/efistore"}}`,
+		`{"cpus": 2, "memory": 2048, "bootloader": {"type": "efi"}, "devices": [{"path": "/tmp/disk.img"}]}`,
+		`{"cpus": 2, "memory": 2048, "bootloader": {"type": "efi"}, "devices": [{"type": "virtio-blk", "path": {}}]}`,
+		`{"cpus": 2, "memory": 2048, "bootloader": {"type": "efi"}, "devices": [{"type": "virtio-vsock", "forward": [1025]}]}`,
+		`{"cpus": 2, "memory": 2048, "bootloader": {"type": "efi"}, "publish": ["22"]}`,
+		`{"cpus": 2, "memory": 2048, "bootloader": {"type": "efi"}, "disks": []}`,
+	}
+	for _, cfg := range invalid {
+		if _, err := LoadReader(strings.NewReader(cfg)); err == nil {
+			t.Fatalf("expected error for %s", cfg)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	cfg := `{"cpus": 2, "memory": "2GiB", "bootloader": {"type": "efi", "variable-store": "/tmp/efistore"}}`
+	for _, name := range []string{"vm.json", "vm.yaml", "vm.yml"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(cfg), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := Load(filepath.Join(dir, "vm.json")); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	for _, name := range []string{"vm.yaml", "vm.yml"} {
+		if _, err := Load(filepath.Join(dir, name)); err == nil || !strings.Contains(err.Error(), "YAML") {
+			t.Fatalf("expected YAML error for %s; got %v", name, err)
+		}
+	}
+}
+
+func TestCheckBootOrder(t *testing.T) {
+	bootloader, err := BootloaderFromCmdLine([]string{"efi", "variable-store=/tmp/efistore", "order=disk1:disk0"})
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	vm := NewVirtualMachine(1, 1024*1024*1024, bootloader)
+	if err := vm.AddDevicesFromCmdLine([]string{"virtio-blk,path=/tmp/disk0.img", "virtio-blk,path=/tmp/disk1.img"}); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if err := vm.checkBootOrder(); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if disks := vm.bootOrderedDisks(); disks[0].imagePath != "/tmp/disk1.img" {
+		t.Fatalf("unexpected first boot disk: %s", disks[0].imagePath)
+	}
+
+	bootloader.(*EFIBootloader).bootOrder = []int{2, 0}
+	if err := vm.checkBootOrder(); err == nil {
+		t.Fatal("expected error for a boot order with a missing disk")
+	}
+	bootloader.(*EFIBootloader).bootOrder = []int{1, 0}
+
+	if err := vm.AddCdromsFromCmdLine([]string{"/tmp/installer.iso"}); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if err := vm.checkBootOrder(); err == nil {
+		t.Fatal("expected error for a boot order with a cdrom")
+	}
+	if err := vm.devices[2].AddToVirtualMachineConfig(nil); err == nil {
+		t.Fatal("expected error when adding a usb-mass-storage device on its own")
+	}
+}
@@ -0,0 +1,234 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/crc-org/vfkit/pkg/util"
+)
+
+// componentConfig is the representation of a bootloader, device or timesync
+// configuration in a configuration file. The "type" key selects the
+// bootloader or device type, and the other keys are the same options as the
+// ones used on the command line. Options without a value (such as 'nat' or
+// 'create') are set to true, options which can be repeated (such as 'forward')
+// are set to a list of strings.
+type componentConfig map[string]interface{}
+
+// fileConfig is the on-disk representation of a virtual machine
+// configuration.
+type fileConfig struct {
+	Cpus uint `json:"cpus"`
+	// Memory is either a number of MiB, or a string with a unit ("2GiB")
+	Memory     interface{}       `json:"memory"`
+	Bootloader componentConfig   `json:"bootloader"`
+	Devices    []componentConfig `json:"devices"`
+	TimeSync   componentConfig   `json:"timesync"`
+	// Publish uses the same format as the --publish command line argument
+	Publish []string `json:"publish"`
+	// Swap uses the same format as the --swap command line argument
+	Swap string `json:"swap"`
+	// Egress uses the same format as the --egress command line arguments
+	Egress []string `json:"egress"`
+	// CacheProxy uses the same format as the --cache-proxy command line
+	// argument
+	CacheProxy string `json:"cacheProxy"`
+	// GVProxy uses the same format as the --gvproxy command line argument
+	GVProxy string `json:"gvproxy"`
+	// Platform uses the same format as the --platform command line argument
+	Platform string `json:"platform"`
+	// GuestOS uses the same format as the --guest-os command line argument
+	GuestOS string `json:"guestOS"`
+}
+
+// toOptions converts the configuration to the option list used by the
+// FromOptions methods. The "type" key is not part of the returned options.
+func (c componentConfig) toOptions() ([]option, error) {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		if key != "type" {
+			keys = append(keys, key)
+		}
+	}
+	// map iteration order is random, sort to get deterministic behaviour
+	sort.Strings(keys)
+
+	options := []option{}
+	for _, key := range keys {
+		opt := option{key: key}
+		switch value := c[key].(type) {
+		case string:
+			opt.value = value
+		case float64:
+			opt.value = strconv.FormatFloat(value, 'f', -1, 64)
+		case bool:
+			if !value {
+				continue
+			}
+		case []interface{}:
+			// repeated option, such as virtio-vsock 'forward'
+			for _, item := range value {
+				itemStr, ok := item.(string)
+				if !ok {
+					return nil, fmt.Errorf("invalid value for option '%s': %v", key, item)
+				}
+				options = append(options, option{key: key, value: itemStr})
+			}
+			continue
+		default:
+			return nil, fmt.Errorf("invalid value for option '%s': %v", key, value)
+		}
+		options = append(options, opt)
+	}
+
+	return options, nil
+}
+
+func (c componentConfig) componentType() (string, error) {
+	componentType, ok := c["type"].(string)
+	if !ok || componentType == "" {
+		return "", fmt.Errorf("missing 'type' key")
+	}
+	return componentType, nil
+}
+
+func parseMemory(memory interface{}) (uint64, error) {
+	switch memory := memory.(type) {
+	case nil:
+		return 0, fmt.Errorf("missing 'memory' key")
+	case float64:
+		return util.ParseMemorySize(strconv.FormatFloat(memory, 'f', -1, 64))
+	case string:
+		return util.ParseMemorySize(memory)
+	default:
+		return 0, fmt.Errorf("invalid memory size: %v", memory)
+	}
+}
+
+// Load reads the virtual machine configuration file at path. See LoadReader
+// for details. Only JSON files are supported, YAML files are rejected.
+func Load(path string) (*VirtualMachine, error) {
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		return nil, fmt.Errorf("%s: YAML configuration files are not supported, the configuration file must use JSON", path)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	vm, err := LoadReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return vm, nil
+}
+
+// LoadReader reads a virtual machine configuration in JSON format from r and
+// validates its syntax. The configuration looks like:
+//
+//	{
+//	  "cpus": 2,
+//	  "memory": "2GiB",
+//	  "bootloader": {"type": "efi", "variable-store": "/path/to/efistore", "create": true},
+//	  "devices": [
+//	    {"type": "virtio-blk", "path": "/path/to/disk.img"},
+//	    {"type": "virtio-net", "nat": true}
+//	  ],
+//	  "timesync": {"vsockPort": 1234},
+//	  "publish": ["2222:22"]
+//	}
+func LoadReader(r io.Reader) (*VirtualMachine, error) {
+	var cfg fileConfig
+
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&cfg); err != nil {
+		return nil, err
+	}
+
+	return cfg.toVirtualMachine()
+}
+
+func (cfg *fileConfig) toVirtualMachine() (*VirtualMachine, error) {
+	if cfg.Cpus == 0 {
+		return nil, fmt.Errorf("missing 'cpus' key")
+	}
+	memoryBytes, err := parseMemory(cfg.Memory)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Bootloader == nil {
+		return nil, fmt.Errorf("missing 'bootloader' key")
+	}
+	bootloaderType, err := cfg.Bootloader.componentType()
+	if err != nil {
+		return nil, fmt.Errorf("bootloader: %w", err)
+	}
+	options, err := cfg.Bootloader.toOptions()
+	if err != nil {
+		return nil, fmt.Errorf("bootloader: %w", err)
+	}
+	bootloader, err := bootloaderFromOptions(bootloaderType, options)
+	if err != nil {
+		return nil, err
+	}
+
+	vm := NewVirtualMachine(cfg.Cpus, memoryBytes, bootloader)
+
+	for i, devConfig := range cfg.Devices {
+		devType, err := devConfig.componentType()
+		if err != nil {
+			return nil, fmt.Errorf("device %d: %w", i, err)
+		}
+		options, err := devConfig.toOptions()
+		if err != nil {
+			return nil, fmt.Errorf("device %d: %w", i, err)
+		}
+		dev, err := deviceFromOptions(devType, options)
+		if err != nil {
+			return nil, err
+		}
+		vm.devices = append(vm.devices, dev)
+	}
+
+	if cfg.TimeSync != nil {
+		options, err := cfg.TimeSync.toOptions()
+		if err != nil {
+			return nil, fmt.Errorf("timesync: %w", err)
+		}
+		if vm.timesync, err = timesyncFromOptions(options); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := vm.AddPortForwardsFromCmdLine(cfg.Publish); err != nil {
+		return nil, err
+	}
+	if err := vm.AddSwapFromCmdLine(cfg.Swap); err != nil {
+		return nil, err
+	}
+	if err := vm.AddEgressRulesFromCmdLine(cfg.Egress); err != nil {
+		return nil, err
+	}
+	if err := vm.AddCacheProxyFromCmdLine(cfg.CacheProxy); err != nil {
+		return nil, err
+	}
+	if err := vm.AddGVProxyFromCmdLine(cfg.GVProxy); err != nil {
+		return nil, err
+	}
+	if err := vm.AddPlatformFromCmdLine(cfg.Platform); err != nil {
+		return nil, err
+	}
+	if err := vm.SetGuestOSFromCmdLine(cfg.GuestOS); err != nil {
+		return nil, err
+	}
+
+	return vm, nil
+}
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/crc-org/vfkit/pkg/gvproxy"
+	"github.com/crc-org/vfkit/pkg/naming"
+)
+
+// GVProxy configures the gvproxy process of gvisor-tap-vsock which provides
+// the network of the virtual machine. vfkit starts it before the virtual
+// machine and stops it when exiting.
+type GVProxy struct {
+	binary    string
+	sshPort   uint16
+	vsockPort uint
+	debug     bool
+
+	// net and vsock are the devices connected to gvproxy, their socket
+	// paths are set by generatePaths
+	net            *virtioNet
+	vsock          *VirtioVsock
+	servicesSocket string
+	pidFile        string
+	logFile        string
+}
+
+// GVProxyFromCmdLine parses the options of the --gvproxy command line
+// argument, "[binary=/path][,mac=...][,mtu=9000][,sshPort=2222][,vsockPort=1024][,debug]".
+func GVProxyFromCmdLine(optsStr string) (*GVProxy, error) {
+	proxy := GVProxy{
+		net: &virtioNet{},
+	}
+
+	options, err := optionsFromCmdLine(optsStr)
+	if err != nil {
+		return nil, err
+	}
+	for _, option := range options {
+		switch option.key {
+		case "binary":
+			proxy.binary = option.value
+		case "mac":
+			macAddress, err := net.ParseMAC(option.value)
+			if err != nil {
+				return nil, err
+			}
+			proxy.net.macAddress = macAddress
+		case "mtu":
+			mtu, err := strconv.ParseUint(option.value, 10, 32)
+			if err != nil || mtu < minMTU || mtu > maxMTU {
+				return nil, fmt.Errorf("invalid MTU for gvproxy parameter: %s, it must be between %d and %d", option.value, minMTU, maxMTU)
+			}
+			proxy.net.mtu = uint(mtu)
+		case "sshPort":
+			port, err := strconv.ParseUint(option.value, 10, 16)
+			if err != nil || port == 0 {
+				return nil, fmt.Errorf("invalid SSH port for gvproxy parameter: %s", option.value)
+			}
+			proxy.sshPort = uint16(port)
+		case "vsockPort":
+			port, err := strconv.ParseUint(option.value, 10, 32)
+			if err != nil || port == 0 {
+				return nil, fmt.Errorf("invalid vsock port for gvproxy parameter: %s", option.value)
+			}
+			proxy.vsockPort = uint(port)
+		case "debug":
+			if option.value != "" {
+				return nil, fmt.Errorf("Unexpected value for gvproxy 'debug' option: %s", option.value)
+			}
+			proxy.debug = true
+		default:
+			return nil, fmt.Errorf("Unknown option for gvproxy parameter: %s", option.key)
+		}
+	}
+	if proxy.vsockPort != 0 {
+		proxy.vsock = &VirtioVsock{Port: proxy.vsockPort, Listen: true}
+	}
+
+	return &proxy, nil
+}
+
+// generatePaths sets the paths of the gvproxy sockets, log and pid files
+// using tmpl.
+func (proxy *GVProxy) generatePaths(tmpl *naming.Template) error {
+	proxy.net.unixSocketPath = tmpl.Path("gvproxy", "sock")
+	proxy.servicesSocket = tmpl.Path("gvproxy-services", "sock")
+	proxy.pidFile = tmpl.Path("gvproxy", "pid")
+	proxy.logFile = tmpl.Path("gvproxy", "log")
+	if proxy.vsock != nil {
+		proxy.vsock.SocketURL = tmpl.Path("gvproxy-vsock", "sock")
+	}
+
+	return os.MkdirAll(filepath.Dir(proxy.net.unixSocketPath), 0700)
+}
+
+// Config returns the configuration of the gvproxy process.
+func (proxy *GVProxy) Config() gvproxy.Config {
+	config := gvproxy.Config{
+		Binary:         proxy.binary,
+		NetworkSocket:  proxy.net.unixSocketPath,
+		ServicesSocket: proxy.servicesSocket,
+		SSHPort:        proxy.sshPort,
+		MTU:            proxy.net.mtu,
+		PIDFile:        proxy.pidFile,
+		LogFile:        proxy.logFile,
+		Debug:          proxy.debug,
+	}
+	if proxy.vsock != nil {
+		config.VsockSocket = proxy.vsock.SocketURL
+	}
+
+	return config
+}
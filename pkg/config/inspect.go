@@ -0,0 +1,167 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/crc-org/vfkit/pkg/rest/define"
+	"github.com/crc-org/vfkit/pkg/util"
+	"github.com/docker/go-units"
+)
+
+// Inspect returns the effective configuration of vm for the /vm/inspect REST
+// endpoint. It must be called after GenerateArtifactPaths and
+// ToVzVirtualMachineConfig so that it includes the generated paths and MAC
+// addresses. The options of the devices use the names of the command line
+// options, with the addition of 'rawPath' for the raw images converted from
+// qcow2 and vmdk disk images.
+func (vm *VirtualMachine) Inspect() *define.VMConfig {
+	config := define.VMConfig{
+		Vcpus:       vm.vcpus,
+		MemoryBytes: vm.memoryBytes,
+		Devices:     []define.DeviceConfig{},
+	}
+	switch bootloader := vm.bootloader.(type) {
+	case *LinuxBootloader:
+		config.Bootloader = define.DeviceConfig{Type: "linux", Options: map[string]string{
+			"kernel":  bootloader.vmlinuzPath,
+			"cmdline": bootloader.kernelCmdLine,
+			"initrd":  bootloader.initrdPath,
+		}}
+	case *EFIBootloader:
+		options := map[string]string{"variable-store": bootloader.efiVariableStorePath}
+		if bootloader.createVariableStore {
+			options["create"] = ""
+		}
+		if len(bootloader.bootOrder) != 0 {
+			order := []string{}
+			for _, index := range bootloader.bootOrder {
+				order = append(order, fmt.Sprintf("disk%d", index))
+			}
+			options["order"] = strings.Join(order, ":")
+		}
+		if netboot := bootloader.netboot; netboot != nil {
+			options["netboot"] = fmt.Sprintf("dir=%s,tftpPort=%d,httpPort=%d", util.QuoteOptionValue(netboot.dir), netboot.tftpPort, netboot.httpPort)
+		}
+		config.Bootloader = define.DeviceConfig{Type: "efi", Options: options}
+	}
+
+	for _, dev := range vm.devices {
+		config.Devices = append(config.Devices, inspectDevice(dev))
+		if vsockDev, isVirtioVsock := dev.(*VirtioVsock); isVirtioVsock {
+			// vfkit listens on the unix sockets of the connect mode
+			// mappings, the guest listens on the vsock port
+			for _, forward := range append([]VsockForward{{Port: vsockDev.Port, SocketURL: vsockDev.SocketURL, Listen: vsockDev.Listen}}, vsockDev.Forwards...) {
+				if forward.Port != 0 && !forward.Listen {
+					config.Listeners = append(config.Listeners, define.Listener{Kind: "vsock", Address: forward.SocketURL})
+				}
+			}
+		}
+		if serialDev, isVirtioSerial := dev.(*virtioSerial); isVirtioSerial && serialDev.consoleSocket != "" {
+			config.Listeners = append(config.Listeners, define.Listener{Kind: "console", Address: serialDev.consoleSocket})
+		}
+		if serialDev, isVirtioSerial := dev.(*virtioSerial); isVirtioSerial && serialDev.ptyDev != nil {
+			config.Listeners = append(config.Listeners, define.Listener{Kind: "pty", Address: serialDev.ptyDev.Path})
+		}
+	}
+	for _, pf := range vm.publish {
+		config.Listeners = append(config.Listeners, define.Listener{Kind: "publish", Address: pf.HostAddress()})
+	}
+	if vm.gvproxy != nil && vm.gvproxy.servicesSocket != "" {
+		config.Listeners = append(config.Listeners, define.Listener{Kind: "gvproxy", Address: vm.gvproxy.servicesSocket})
+	}
+
+	return &config
+}
+
+// inspectDevice returns the effective options of dev.
+func inspectDevice(dev VirtioDevice) define.DeviceConfig {
+	options := map[string]string{}
+	set := func(key string, value string) {
+		if value != "" {
+			options[key] = value
+		}
+	}
+	setFlag := func(key string, enabled bool) {
+		if enabled {
+			options[key] = ""
+		}
+	}
+
+	var devType string
+	switch dev := dev.(type) {
+	case *virtioBalloon:
+		devType = "virtio-balloon"
+	case *virtioBlk:
+		devType = "virtio-blk"
+		// the RAM device of RAM disks is created by vfkit, it cannot be
+		// set with 'ram'
+		if dev.ramSizeBytes == 0 {
+			set("path", dev.imagePath)
+		}
+		set("rawPath", dev.rawImagePath)
+		if dev.ramSizeBytes != 0 {
+			set("ram", fmt.Sprintf("%dMiB", dev.ramSizeBytes/units.MiB))
+		}
+	case *usbMassStorage:
+		devType = "usb-mass-storage"
+		set("path", dev.imagePath)
+		setFlag("readonly", dev.readOnly)
+	case *virtioFs:
+		devType = "virtio-fs"
+		set("sharedDir", dev.sharedDir)
+		set("mountTag", dev.MountTag())
+		if dev.notifyPort != 0 {
+			set("notify", strconv.FormatUint(uint64(dev.notifyPort), 10))
+		}
+		setFlag("caseSensitive", dev.caseSensitive)
+		setFlag("xattr", dev.xattr)
+	case *virtioNet:
+		devType = "virtio-net"
+		setFlag("nat", dev.nat)
+		set("unixSocketPath", dev.unixSocketPath)
+		set("mac", dev.macAddress.String())
+		if dev.subnet != nil {
+			set("subnet", dev.subnet.String())
+		}
+		if dev.ipAddress != nil {
+			set("ip", dev.ipAddress.String())
+		}
+		set("hostname", dev.hostname)
+		if dev.mtu != 0 {
+			set("mtu", strconv.FormatUint(uint64(dev.mtu), 10))
+		}
+	case *virtioRng:
+		devType = "virtio-rng"
+	case *virtioSerial:
+		devType = "virtio-serial"
+		set("id", dev.id)
+		set("logFilePath", dev.logFile)
+		setFlag("pty", dev.pty)
+		set("consoleSocket", dev.consoleSocket)
+		setFlag("console", dev.console && dev.consoleSocket == "")
+	case *VirtioVsock:
+		devType = "virtio-vsock"
+		if dev.Port != 0 {
+			set("port", strconv.FormatUint(uint64(dev.Port), 10))
+			set("socketURL", dev.SocketURL)
+			setFlag("listen", dev.Listen)
+			setFlag("connect", !dev.Listen)
+		}
+		forwards := []string{}
+		for _, forward := range dev.Forwards {
+			mode := "listen"
+			if !forward.Listen {
+				mode = "connect"
+			}
+			forwards = append(forwards, fmt.Sprintf("%d:%s:%s", forward.Port, forward.SocketURL, mode))
+		}
+		set("forward", strings.Join(forwards, ","))
+	}
+	if len(options) == 0 {
+		options = nil
+	}
+
+	return define.DeviceConfig{Type: devType, Options: options}
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+)
+
+const (
+	defaultNetbootTFTPPort = 69
+	defaultNetbootHTTPPort = 8080
+)
+
+// Netboot configures the TFTP and HTTP services which serve boot files to the
+// guest on the NAT network.
+type Netboot struct {
+	dir      string
+	tftpPort uint16
+	httpPort uint16
+}
+
+// Dir is the host directory with the boot files.
+func (netboot *Netboot) Dir() string {
+	return netboot.dir
+}
+
+// TFTPPort is the UDP port of the TFTP service, 0 when it's disabled.
+func (netboot *Netboot) TFTPPort() uint16 {
+	return netboot.tftpPort
+}
+
+// HTTPPort is the TCP port of the HTTP service, 0 when it's disabled.
+func (netboot *Netboot) HTTPPort() uint16 {
+	return netboot.httpPort
+}
+
+// netbootFromOptionValue parses the value of the 'netboot' option of EFI
+// bootloaders, "dir=/path[,tftpPort=69][,httpPort=8080]". The value must be
+// quoted when it has several options.
+func netbootFromOptionValue(value string) (*Netboot, error) {
+	netboot := Netboot{
+		tftpPort: defaultNetbootTFTPPort,
+		httpPort: defaultNetbootHTTPPort,
+	}
+
+	options, err := optionsFromCmdLine(value)
+	if err != nil {
+		return nil, err
+	}
+	for _, option := range options {
+		switch option.key {
+		case "dir":
+			netboot.dir = option.value
+		case "tftpPort", "httpPort":
+			port, err := strconv.ParseUint(option.value, 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("invalid netboot %s: %s", option.key, option.value)
+			}
+			if option.key == "tftpPort" {
+				netboot.tftpPort = uint16(port)
+			} else {
+				netboot.httpPort = uint16(port)
+			}
+		default:
+			return nil, fmt.Errorf("Unknown option for EFI bootloader netboot: %s", option.key)
+		}
+	}
+	if netboot.dir == "" {
+		return nil, fmt.Errorf("missing 'dir' for EFI bootloader 'netboot' option")
+	}
+	if netboot.tftpPort == 0 && netboot.httpPort == 0 {
+		return nil, fmt.Errorf("the TFTP and HTTP services of EFI bootloader 'netboot' option cannot both be disabled")
+	}
+
+	return &netboot, nil
+}
+
+// checkNetboot verifies that the guest can reach the netboot services, which
+// listen on the NAT network.
+func (vm *VirtualMachine) checkNetboot() error {
+	if vm.Netboot() == nil {
+		return nil
+	}
+	for _, dev := range vm.devices {
+		if netDev, isVirtioNet := dev.(*virtioNet); isVirtioNet && netDev.nat {
+			return nil
+		}
+	}
+	return fmt.Errorf("the EFI bootloader 'netboot' option needs a virtio-net device using NAT")
+}
+
+// Netboot returns the netboot configuration of the EFI bootloader, nil when
+// the boot files are not served.
+func (vm *VirtualMachine) Netboot() *Netboot {
+	if efi, isEFI := vm.bootloader.(*EFIBootloader); isEFI {
+		return efi.netboot
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/Code-Hex/vz/v3"
+)
+
+const (
+	// vfkitMagic is sent to the user-mode network stack when connecting to
+	// its unixgram socket so that it learns the address of vfkit, this is
+	// the protocol of the gvproxy -listen-vfkit option.
+	vfkitMagic = "VFKT"
+	// the buffer sizes recommended by Apple for VZFileHandleNetworkDeviceAttachment
+	netSocketSendBufferSize    = 1024 * 1024
+	netSocketReceiveBufferSize = 4 * 1024 * 1024
+)
+
+// unixSocketAttachment connects to the unixgram socket at remotePath and
+// returns a network attachment sending the guest frames over it. vfkit binds
+// its end of the connection to a path next to remotePath. mtu is the MTU of
+// the attachment, 0 keeps the default of 1500.
+func unixSocketAttachment(remotePath string, mtu uint) (*vz.FileHandleNetworkDeviceAttachment, error) {
+	localPath := strings.TrimSuffix(remotePath, ".sock") + "-vfkit.sock"
+	if err := os.Remove(localPath); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	conn, err := net.DialUnix("unixgram",
+		&net.UnixAddr{Name: localPath, Net: "unixgram"},
+		&net.UnixAddr{Name: remotePath, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to %s: %w", remotePath, err)
+	}
+	defer conn.Close()
+	if err := conn.SetWriteBuffer(netSocketSendBufferSize); err != nil {
+		return nil, err
+	}
+	if err := conn.SetReadBuffer(netSocketReceiveBufferSize); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write([]byte(vfkitMagic)); err != nil {
+		return nil, fmt.Errorf("cannot send handshake to %s: %w", remotePath, err)
+	}
+	// the attachment uses a duplicate of the socket file descriptor
+	file, err := conn.File()
+	if err != nil {
+		return nil, err
+	}
+
+	attachment, err := vz.NewFileHandleNetworkDeviceAttachment(file)
+	if err != nil {
+		return nil, err
+	}
+	if mtu != 0 {
+		if err := attachment.SetMaximumTransmissionUnit(int(mtu)); err != nil {
+			return nil, fmt.Errorf("cannot set the MTU of %s to %d: %w", remotePath, mtu, err)
+		}
+	}
+
+	return attachment, nil
+}
@@ -0,0 +1,221 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/Code-Hex/vz/v3"
+	"github.com/crc-org/vfkit/pkg/arch"
+	"github.com/docker/go-units"
+	log "github.com/sirupsen/logrus"
+)
+
+// GuestOS is the operating system of the guest, it selects a configuration
+// profile with the settings and checks this operating system needs.
+type GuestOS string
+
+const (
+	// GuestOSLinux is the default, it has no specific settings.
+	GuestOSLinux GuestOS = "linux"
+	// GuestOSWindows is Windows on ARM, booted with the EFI bootloader. It
+	// uses a generic platform with a persistent machine identifier.
+	GuestOSWindows GuestOS = "windows"
+)
+
+// minimum hardware requirements of Windows 11 on ARM
+const (
+	windowsMinVcpus       = 2
+	windowsMinMemoryBytes = 4 * units.GiB
+	windowsMinDiskBytes   = 64 * units.GB
+)
+
+// ParseGuestOS parses the value of the --guest-os command line argument.
+func ParseGuestOS(str string) (GuestOS, error) {
+	switch GuestOS(str) {
+	case GuestOSLinux, GuestOSWindows:
+		return GuestOS(str), nil
+	default:
+		return "", fmt.Errorf("unknown guest OS '%s', must be '%s' or '%s'", str, GuestOSLinux, GuestOSWindows)
+	}
+}
+
+// Platform configures the generic platform of the virtual machine. Its
+// machine identifier is stored in a file, so that the guest sees the same
+// hardware every time it boots. Otherwise the virtualization framework
+// generates a new identifier for each boot, which Windows treats as a
+// hardware change.
+type Platform struct {
+	machineIdentifierPath string
+	// ephemeral is set by Validate, a new machine identifier is not
+	// stored then
+	ephemeral bool
+}
+
+// MachineIdentifierPath is the path of the file storing the machine
+// identifier, it's created the first time the virtual machine starts.
+func (platform *Platform) MachineIdentifierPath() string {
+	return platform.machineIdentifierPath
+}
+
+// PlatformFromCmdLine parses the options of the --platform command line
+// argument, "generic[,machineIdentifier=path]".
+func PlatformFromCmdLine(optsStr string) (*Platform, error) {
+	options, err := optionsFromCmdLine(optsStr)
+	if err != nil {
+		return nil, err
+	}
+	if len(options) == 0 || options[0].key != "generic" || options[0].value != "" {
+		return nil, fmt.Errorf("only the 'generic' platform is supported")
+	}
+
+	platform := Platform{}
+	for _, option := range options[1:] {
+		switch option.key {
+		case "machineIdentifier":
+			if option.value == "" {
+				return nil, fmt.Errorf("missing path for machineIdentifier option")
+			}
+			platform.machineIdentifierPath = option.value
+		default:
+			return nil, fmt.Errorf("Unknown option for platform parameter: %s", option.key)
+		}
+	}
+
+	return &platform, nil
+}
+
+// machineIdentifier reads the machine identifier from its file, or creates a
+// new one and stores it, unless the platform is ephemeral.
+func (platform *Platform) machineIdentifier() (*vz.GenericMachineIdentifier, error) {
+	machineIdentifier, err := vz.NewGenericMachineIdentifierWithDataPath(platform.machineIdentifierPath)
+	if err == nil {
+		return machineIdentifier, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("invalid machine identifier %s: %w", platform.machineIdentifierPath, err)
+	}
+
+	machineIdentifier, err = vz.NewGenericMachineIdentifier()
+	if err != nil || platform.ephemeral {
+		return machineIdentifier, err
+	}
+	if err := os.MkdirAll(filepath.Dir(platform.machineIdentifierPath), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(platform.machineIdentifierPath, machineIdentifier.DataRepresentation(), 0600); err != nil {
+		return nil, err
+	}
+	log.Infof("created machine identifier %s", platform.machineIdentifierPath)
+
+	return machineIdentifier, nil
+}
+
+func (platform *Platform) toVzPlatformConfig() (vz.PlatformConfiguration, error) {
+	machineIdentifier, err := platform.machineIdentifier()
+	if err != nil {
+		return nil, err
+	}
+	platformConfig, err := vz.NewGenericPlatformConfiguration(vz.WithGenericMachineIdentifier(machineIdentifier))
+	if err != nil {
+		return nil, err
+	}
+	return platformConfig, nil
+}
+
+// AddPlatformFromCmdLine parses the value of the --platform command line
+// argument.
+func (vm *VirtualMachine) AddPlatformFromCmdLine(cmdlineOpts string) error {
+	if cmdlineOpts == "" {
+		return nil
+	}
+	platform, err := PlatformFromCmdLine(cmdlineOpts)
+	if err != nil {
+		return err
+	}
+	vm.platform = platform
+
+	return nil
+}
+
+// Platform returns the generic platform configuration, nil when the
+// virtualization framework defaults are used.
+func (vm *VirtualMachine) Platform() *Platform {
+	return vm.platform
+}
+
+// SetGuestOSFromCmdLine parses the value of the --guest-os command line
+// argument and applies the profile of this operating system.
+func (vm *VirtualMachine) SetGuestOSFromCmdLine(guestOSStr string) error {
+	if guestOSStr == "" {
+		return nil
+	}
+	guestOS, err := ParseGuestOS(guestOSStr)
+	if err != nil {
+		return err
+	}
+	vm.guestOS = guestOS
+	// the machine identifier of Windows guests must not change, its path
+	// is generated when it's not set
+	if guestOS == GuestOSWindows && vm.platform == nil {
+		vm.platform = &Platform{}
+	}
+
+	return nil
+}
+
+// GuestOS returns the operating system of the guest.
+func (vm *VirtualMachine) GuestOS() GuestOS {
+	if vm.guestOS == "" {
+		return GuestOSLinux
+	}
+	return vm.guestOS
+}
+
+// CheckGuestOS checks that the configuration can run the guest operating
+// system, see SetGuestOSFromCmdLine. The problems which do not prevent the
+// guest from booting are logged as warnings.
+func (vm *VirtualMachine) CheckGuestOS() error {
+	if vm.GuestOS() != GuestOSWindows {
+		return nil
+	}
+
+	if host := arch.Host(); host != arch.ARM64 {
+		return fmt.Errorf("windows guests need an arm64 host, this host is %s", host)
+	}
+	if _, isEFI := vm.bootloader.(*EFIBootloader); !isEFI {
+		return fmt.Errorf("windows guests need an EFI bootloader")
+	}
+	if vm.vcpus < windowsMinVcpus {
+		return fmt.Errorf("windows guests need at least %d virtual CPUs", windowsMinVcpus)
+	}
+	if vm.memoryBytes < windowsMinMemoryBytes {
+		return fmt.Errorf("windows guests need at least %s of memory", units.BytesSize(windowsMinMemoryBytes))
+	}
+	disks := vm.bootOrderedDisks()
+	if len(disks) == 0 {
+		return fmt.Errorf("windows guests need a virtio-blk disk")
+	}
+
+	diskPath := disks[0].imagePath
+	if disks[0].rawImagePath != "" {
+		diskPath = disks[0].rawImagePath
+	}
+	if info, err := os.Stat(diskPath); err == nil && info.Size() < windowsMinDiskBytes {
+		log.Warnf("windows needs a disk of at least %s, %s is %s", units.HumanSize(windowsMinDiskBytes), diskPath, units.HumanSize(float64(info.Size())))
+	}
+	hasNetwork := vm.gvproxy != nil
+	for _, dev := range vm.devices {
+		if _, isNet := dev.(*virtioNet); isNet {
+			hasNetwork = true
+		}
+	}
+	if !hasNetwork {
+		log.Warn("windows guest has no virtio-net device, it will not have network access")
+	}
+	// there is no TPM device in the virtualization framework
+	log.Warn("windows 11 setup needs its TPM 2.0 check to be bypassed, the virtualization framework has no TPM device")
+
+	return nil
+}
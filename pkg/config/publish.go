@@ -0,0 +1,94 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// PortForwardTransport is how forwarded connections reach the guest.
+type PortForwardTransport string
+
+const (
+	// PortForwardNAT connects to the guest IP address on the NAT network
+	PortForwardNAT PortForwardTransport = "nat"
+	// PortForwardVsock connects to a vsock port the guest listens on
+	PortForwardVsock PortForwardTransport = "vsock"
+)
+
+// PortForward forwards connections to a host TCP port to a port in the guest.
+type PortForward struct {
+	hostAddress string
+	hostPort    uint16
+	guestPort   uint32
+	transport   PortForwardTransport
+}
+
+// HostAddress is the host address to listen on, for example 127.0.0.1:8080.
+func (pf *PortForward) HostAddress() string {
+	return net.JoinHostPort(pf.hostAddress, strconv.FormatUint(uint64(pf.hostPort), 10))
+}
+
+// GuestPort is the TCP port, or the vsock port, the connections are
+// forwarded to.
+func (pf *PortForward) GuestPort() uint32 {
+	return pf.guestPort
+}
+
+// Transport is how the connections reach the guest.
+func (pf *PortForward) Transport() PortForwardTransport {
+	return pf.transport
+}
+
+func (pf *PortForward) String() string {
+	return fmt.Sprintf("%s -> guest %s port %d", pf.HostAddress(), pf.transport, pf.guestPort)
+}
+
+// PortForwardFromCmdLine parses the value of a --publish command line
+// argument, in the [hostAddress:]hostPort:guestPort[/nat|/vsock] format.
+// Ports are only published on 127.0.0.1 unless hostAddress is set.
+func PortForwardFromCmdLine(str string) (*PortForward, error) {
+	pf := PortForward{
+		hostAddress: "127.0.0.1",
+		transport:   PortForwardNAT,
+	}
+
+	ports := str
+	if split := strings.SplitN(str, "/", 2); len(split) == 2 {
+		ports = split[0]
+		pf.transport = PortForwardTransport(split[1])
+		if pf.transport != PortForwardNAT && pf.transport != PortForwardVsock {
+			return nil, fmt.Errorf("Unknown transport for published port %s: %s", str, split[1])
+		}
+	}
+
+	// the host address can be an IPv6 address containing ':'
+	lastColon := strings.LastIndex(ports, ":")
+	if lastColon == -1 {
+		return nil, fmt.Errorf("Invalid published port %s, expected hostPort:guestPort", str)
+	}
+	hostStr, guestStr := ports[:lastColon], ports[lastColon+1:]
+	if hostColon := strings.LastIndex(hostStr, ":"); hostColon != -1 {
+		pf.hostAddress = strings.Trim(hostStr[:hostColon], "[]")
+		hostStr = hostStr[hostColon+1:]
+	}
+
+	hostPort, err := strconv.ParseUint(hostStr, 10, 16)
+	if err != nil || hostPort == 0 {
+		return nil, fmt.Errorf("Invalid host port for published port %s", str)
+	}
+	pf.hostPort = uint16(hostPort)
+	// guest TCP ports are 16 bits, vsock ports are 32 bits
+	bitSize := 16
+	if pf.transport == PortForwardVsock {
+		bitSize = 32
+	}
+	guestPort, err := strconv.ParseUint(guestStr, 10, bitSize)
+	if err != nil || guestPort == 0 {
+		return nil, fmt.Errorf("Invalid guest port for published port %s", str)
+	}
+	pf.guestPort = uint32(guestPort)
+
+	return &pf, nil
+}
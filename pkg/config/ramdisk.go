@@ -0,0 +1,95 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/docker/go-units"
+	log "github.com/sirupsen/logrus"
+)
+
+// ramDiskSectorSize is the sector size of the ram:// devices of hdiutil.
+const ramDiskSectorSize = 512
+
+// CreateRAMDisks creates the RAM devices backing the virtio-blk devices with
+// the 'ram' option. They are attached with 'hdiutil attach -nomount ram://',
+// the memory of the device is allocated by the host kernel and is never
+// written to a disk. The virtualization framework opens the /dev/diskN device
+// as a disk image.
+//
+// The returned function detaches the devices and frees their memory, it must
+// be called once the virtual machine is stopped.
+func (vm *VirtualMachine) CreateRAMDisks() (func(), error) {
+	disks := []*virtioBlk{}
+	detach := func() {
+		for _, disk := range disks {
+			if _, err := hdiutil("detach", disk.imagePath); err != nil {
+				log.Warnf("failed to detach RAM disk %s: %v", disk.imagePath, err)
+			}
+			disk.imagePath = ""
+		}
+	}
+	for _, dev := range vm.devices {
+		blkDev, isVirtioBlk := dev.(*virtioBlk)
+		if !isVirtioBlk || blkDev.ramSizeBytes == 0 {
+			continue
+		}
+		devicePath, err := attachRAMDevice(blkDev.ramSizeBytes)
+		if err != nil {
+			detach()
+			return nil, fmt.Errorf("virtio-blk ram=%s: %w", units.BytesSize(float64(blkDev.ramSizeBytes)), err)
+		}
+		log.Infof("created %s RAM disk %s", units.BytesSize(float64(blkDev.ramSizeBytes)), devicePath)
+		blkDev.imagePath = devicePath
+		disks = append(disks, blkDev)
+	}
+
+	return detach, nil
+}
+
+// attachRAMDevice creates a RAM device of sizeBytes bytes, rounded up to a
+// whole number of sectors, and returns its path.
+func attachRAMDevice(sizeBytes uint64) (string, error) {
+	sectors := (sizeBytes + ramDiskSectorSize - 1) / ramDiskSectorSize
+	output, err := hdiutil("attach", "-nomount", fmt.Sprintf("ram://%d", sectors))
+	if err != nil {
+		return "", err
+	}
+	// the only output is the device path, followed by padding
+	fields := strings.Fields(output)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "/dev/disk") {
+		return "", fmt.Errorf("unexpected hdiutil output: %s", output)
+	}
+
+	return fields[0], nil
+}
+
+func hdiutil(args ...string) (string, error) {
+	cmd := exec.Command("hdiutil", args...)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("hdiutil %s failed: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(output.String()))
+	}
+
+	return output.String(), nil
+}
+
+// createSparseFile creates an empty file of sizeBytes bytes at path, it
+// replaces the RAM device of a RAM disk in Validate.
+func createSparseFile(path string, sizeBytes uint64) error {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err := file.Truncate(int64(sizeBytes)); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,130 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/crc-org/vfkit/pkg/util"
+)
+
+// RestartPolicy determines when a stopped virtual machine is restarted.
+type RestartPolicy string
+
+const (
+	// RestartNever never restarts the virtual machine
+	RestartNever RestartPolicy = "no"
+	// RestartOnFailure restarts the virtual machine when it stops because
+	// of an error, see ShouldRestart
+	RestartOnFailure RestartPolicy = "on-failure"
+	// RestartAlways restarts the virtual machine whenever it stops, unless
+	// the stop was requested through vfkit
+	RestartAlways RestartPolicy = "always"
+)
+
+// Restart configures the restart of the virtual machine when it stops, and
+// how crash loops are detected.
+type Restart struct {
+	policy     RestartPolicy
+	maxRetries int
+	window     time.Duration
+	delay      time.Duration
+}
+
+// Policy is the restart policy.
+func (restart *Restart) Policy() RestartPolicy {
+	return restart.policy
+}
+
+// MaxRetries is the number of restarts allowed within Window. When the
+// virtual machine fails more often than this, it's considered to be in a
+// crash loop and it's no longer restarted.
+func (restart *Restart) MaxRetries() int {
+	return restart.maxRetries
+}
+
+// Window is the time window used for crash loop detection.
+func (restart *Restart) Window() time.Duration {
+	return restart.window
+}
+
+// Delay is the time to wait before restarting the virtual machine.
+func (restart *Restart) Delay() time.Duration {
+	return restart.delay
+}
+
+// ShouldRestart returns true if a virtual machine which stopped must be
+// restarted. failed is true if the virtual machine stopped because of an
+// error, stopRequested is true if the stop was requested through vfkit.
+//
+// Only the stops reported with an error by Virtualization.framework are
+// failures, when the virtual machine moves to the error state, for example
+// after a hypervisor error or a failed restart. The guest powering off or
+// rebooting itself is a clean stop, and so is a kernel panic which reboots
+// the guest, they are only restarted by the "always" policy.
+func (restart *Restart) ShouldRestart(failed bool, stopRequested bool) bool {
+	switch restart.policy {
+	case RestartOnFailure:
+		return failed
+	case RestartAlways:
+		return failed || !stopRequested
+	default:
+		return false
+	}
+}
+
+// RestartFromCmdLine parses the options of the --restart command line
+// argument. The first option is the restart policy.
+func RestartFromCmdLine(optsStr string) (*Restart, error) {
+	restart := Restart{
+		maxRetries: 5,
+		window:     10 * time.Minute,
+		delay:      time.Second,
+	}
+
+	optsStrv, err := util.SplitOptions(optsStr)
+	if err != nil {
+		return nil, err
+	}
+	restart.policy = RestartPolicy(optsStrv[0])
+	switch restart.policy {
+	case RestartNever, RestartOnFailure, RestartAlways:
+	default:
+		return nil, fmt.Errorf("Unknown restart policy: %s", restart.policy)
+	}
+
+	options := strvToOptions(optsStrv[1:])
+	for _, option := range options {
+		switch option.key {
+		case "maxRetries":
+			maxRetries, err := strconv.Atoi(option.value)
+			if err != nil {
+				return nil, err
+			}
+			restart.maxRetries = maxRetries
+		case "window":
+			window, err := time.ParseDuration(option.value)
+			if err != nil {
+				return nil, err
+			}
+			restart.window = window
+		case "delay":
+			delay, err := time.ParseDuration(option.value)
+			if err != nil {
+				return nil, err
+			}
+			restart.delay = delay
+		default:
+			return nil, fmt.Errorf("Unknown option for restart parameter: %s", option.key)
+		}
+	}
+
+	if restart.maxRetries < 0 {
+		return nil, fmt.Errorf("Invalid 'maxRetries' option for restart parameter: %d", restart.maxRetries)
+	}
+	if restart.window <= 0 {
+		return nil, fmt.Errorf("Invalid 'window' option for restart parameter: %s", restart.window)
+	}
+
+	return &restart, nil
+}
@@ -0,0 +1,34 @@
+package config
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// pathconf names from <sys/unistd.h>, they are missing from x/sys/unix
+const (
+	pcCaseSensitive = 11 // _PC_CASE_SENSITIVE
+	pcXattrSizeBits = 26 // _PC_XATTR_SIZE_BITS
+)
+
+// volumeIsCaseSensitive returns true when the volume containing path distinguishes
+// file names which only differ by case.
+func volumeIsCaseSensitive(path string) (bool, error) {
+	val, err := unix.Pathconf(path, pcCaseSensitive)
+	if err != nil {
+		return false, err
+	}
+	return val == 1, nil
+}
+
+// volumeSupportsXattrs returns true when the volume containing path can store
+// extended attributes.
+func volumeSupportsXattrs(path string) (bool, error) {
+	val, err := unix.Pathconf(path, pcXattrSizeBits)
+	if err == unix.EINVAL {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return val > 0, nil
+}
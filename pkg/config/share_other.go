@@ -0,0 +1,17 @@
+//go:build !darwin
+// +build !darwin
+
+package config
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func volumeIsCaseSensitive(_ string) (bool, error) {
+	return false, fmt.Errorf("checking case sensitivity is not supported on %s", runtime.GOOS)
+}
+
+func volumeSupportsXattrs(_ string) (bool, error) {
+	return false, fmt.Errorf("checking extended attributes support is not supported on %s", runtime.GOOS)
+}
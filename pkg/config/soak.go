@@ -0,0 +1,73 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// Soak configures a long-running soak test: the virtual machine is kept
+// running for a given duration while its vsock, network and disk devices are
+// periodically checked.
+type Soak struct {
+	duration    time.Duration
+	interval    time.Duration
+	logFilePath string
+}
+
+// Duration is how long the virtual machine is kept running.
+func (soak *Soak) Duration() time.Duration {
+	return soak.duration
+}
+
+// Interval is the delay between two rounds of checks.
+func (soak *Soak) Interval() time.Duration {
+	return soak.interval
+}
+
+// LogFilePath is the path to the file where check results are recorded, in
+// JSON lines format. It can be empty.
+func (soak *Soak) LogFilePath() string {
+	return soak.logFilePath
+}
+
+// SoakFromCmdLine parses the options of the --soak command line argument.
+func SoakFromCmdLine(optsStr string) (*Soak, error) {
+	soak := Soak{
+		interval: time.Minute,
+	}
+
+	options, err := optionsFromCmdLine(optsStr)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, option := range options {
+		switch option.key {
+		case "duration":
+			duration, err := time.ParseDuration(option.value)
+			if err != nil {
+				return nil, err
+			}
+			soak.duration = duration
+		case "interval":
+			interval, err := time.ParseDuration(option.value)
+			if err != nil {
+				return nil, err
+			}
+			soak.interval = interval
+		case "logFilePath":
+			soak.logFilePath = option.value
+		default:
+			return nil, fmt.Errorf("Unknown option for soak parameter: %s", option.key)
+		}
+	}
+
+	if soak.duration <= 0 {
+		return nil, fmt.Errorf("Missing 'duration' option for soak parameter")
+	}
+	if soak.interval <= 0 {
+		return nil, fmt.Errorf("Invalid 'interval' option for soak parameter: %s", soak.interval)
+	}
+
+	return &soak, nil
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/crc-org/vfkit/pkg/agent"
+	"github.com/crc-org/vfkit/pkg/util"
+)
+
+// Swap configures a swapfile which is created and enabled in the guest by the
+// vfkit guest agent after boot.
+type Swap struct {
+	sizeBytes uint64
+	path      string
+	agentPort uint
+}
+
+// SizeBytes is the size of the swapfile.
+func (swap *Swap) SizeBytes() uint64 {
+	return swap.sizeBytes
+}
+
+// Path is the path of the swapfile in the guest.
+func (swap *Swap) Path() string {
+	return swap.path
+}
+
+// AgentPort is the vsock port of the guest agent.
+func (swap *Swap) AgentPort() uint {
+	return swap.agentPort
+}
+
+// SwapFromCmdLine parses the options of the --swap command line argument,
+// "size=2GiB[,path=/swapfile][,agentPort=1025]". A size alone, such as
+// "2GiB", is also accepted.
+func SwapFromCmdLine(optsStr string) (*Swap, error) {
+	swap := Swap{
+		path:      "/swapfile",
+		agentPort: agent.DefaultVsockPort,
+	}
+
+	options, err := optionsFromCmdLine(optsStr)
+	if err != nil {
+		return nil, err
+	}
+	for i, option := range options {
+		switch {
+		case option.key == "size":
+			size, err := util.ParseMemorySize(option.value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid swap size: %w", err)
+			}
+			swap.sizeBytes = size
+		case option.key == "path":
+			if !strings.HasPrefix(option.value, "/") {
+				return nil, fmt.Errorf("swapfile path must be absolute: %s", option.value)
+			}
+			swap.path = option.value
+		case option.key == "agentPort":
+			port, err := strconv.ParseUint(option.value, 10, 32)
+			if err != nil || port == 0 {
+				return nil, fmt.Errorf("invalid agent vsock port for swap parameter: %s", option.value)
+			}
+			swap.agentPort = uint(port)
+		case i == 0 && option.value == "":
+			size, err := util.ParseMemorySize(option.key)
+			if err != nil {
+				return nil, fmt.Errorf("invalid swap size: %w", err)
+			}
+			swap.sizeBytes = size
+		default:
+			return nil, fmt.Errorf("Unknown option for swap parameter: %s", option.key)
+		}
+	}
+
+	if swap.sizeBytes == 0 {
+		return nil, fmt.Errorf("Missing 'size' option for swap parameter")
+	}
+
+	return &swap, nil
+}
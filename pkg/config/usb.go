@@ -0,0 +1,66 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/Code-Hex/vz/v3"
+	log "github.com/sirupsen/logrus"
+)
+
+// usbMassStorage is a disk image attached as a USB mass storage device, such
+// as the installer ISO image of --cdrom. USB mass storage devices are
+// attached before the virtio-blk devices, so the EFI firmware tries to boot
+// them first.
+type usbMassStorage struct {
+	imagePath string
+	readOnly  bool
+}
+
+func (dev *usbMassStorage) FromOptions(options []option) error {
+	for _, option := range options {
+		switch option.key {
+		case "path":
+			dev.imagePath = option.value
+		case "readonly":
+			if option.value != "" {
+				return fmt.Errorf("Unexpected value for usb-mass-storage 'readonly' option: %s", option.value)
+			}
+			dev.readOnly = true
+		default:
+			return fmt.Errorf("Unknown option for usb-mass-storage devices: %s", option.key)
+		}
+	}
+	return nil
+}
+
+func (dev *usbMassStorage) toVzStorageDeviceConfig() (vz.StorageDeviceConfiguration, error) {
+	if dev.imagePath == "" {
+		return nil, fmt.Errorf("missing mandatory 'path' option for usb-mass-storage device")
+	}
+	log.Infof("Adding usb-mass-storage device (imagePath: %s, readonly: %v)", dev.imagePath, dev.readOnly)
+	attachment, err := vz.NewDiskImageStorageDeviceAttachment(dev.imagePath, dev.readOnly)
+	if err != nil {
+		return nil, err
+	}
+	return vz.NewUSBMassStorageDeviceConfiguration(attachment)
+}
+
+// AddToVirtualMachineConfig always fails, setting the storage devices of
+// vmConfig to this device alone would drop the virtio-blk disks.
+// ToVzVirtualMachineConfig adds all the storage devices at once instead.
+func (dev *usbMassStorage) AddToVirtualMachineConfig(_ *vz.VirtualMachineConfiguration) error {
+	return fmt.Errorf("usb-mass-storage devices can only be added with the other storage devices of the virtual machine")
+}
+
+// AddCdromsFromCmdLine attaches the ISO images of the --cdrom arguments as
+// read-only USB mass storage devices.
+func (vm *VirtualMachine) AddCdromsFromCmdLine(paths []string) error {
+	for _, path := range paths {
+		if path == "" {
+			return fmt.Errorf("empty --cdrom path")
+		}
+		vm.devices = append(vm.devices, &usbMassStorage{imagePath: path, readOnly: true})
+	}
+
+	return nil
+}
@@ -3,11 +3,18 @@ package config
 import (
 	"fmt"
 	"net"
+	"os"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 
 	"github.com/Code-Hex/vz/v3"
+	"github.com/crc-org/vfkit/pkg/agent"
+	"github.com/crc-org/vfkit/pkg/console"
+	"github.com/crc-org/vfkit/pkg/dhcp"
+	"github.com/crc-org/vfkit/pkg/naming"
+	"github.com/crc-org/vfkit/pkg/util"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -20,10 +27,42 @@ type VirtioVsock struct {
 	Port      uint
 	SocketURL string
 	Listen    bool
+	// Forwards are additional port mappings using the same virtio-vsock
+	// device
+	Forwards []VsockForward
+}
+
+// VsockForward maps a vsock port to a host unix socket. When Listen is true,
+// the guest connects to the vsock port and the connections are forwarded to
+// the unix socket. When Listen is false, connections to the unix socket are
+// forwarded to the vsock port the guest listens on.
+type VsockForward struct {
+	Port      uint
+	SocketURL string
+	Listen    bool
+}
+
+// Share is a host directory shared with the guest through virtio-fs.
+type Share struct {
+	SharedDir string
+	MountTag  string
+}
+
+// WatchedShare is a share whose host changes must be reported to the vfkit
+// guest agent listening on AgentPort.
+type WatchedShare struct {
+	Share
+	AgentPort uint
 }
 
 type virtioBlk struct {
 	imagePath string
+	// rawImagePath is set when imagePath is not a raw image, it's the path
+	// of the converted image used by the virtual machine
+	rawImagePath string
+	// ramSizeBytes is set for RAM disks, see CreateRAMDisks. imagePath is
+	// the path of their RAM device while the virtual machine runs
+	ramSizeBytes uint64
 }
 
 type virtioRng struct {
@@ -35,16 +74,56 @@ type virtioRng struct {
 // TODO: Add FileHandleNetwork support
 // https://github.com/Code-Hex/vz/blob/d70a0533bf8ed0fa9ab22fa4d4ca554b7c3f3ce5/network.go#L109-L112
 type virtioNet struct {
-	nat        bool
-	macAddress net.HardwareAddr
+	nat bool
+	// unixSocketPath is the unixgram socket of a user-mode network stack
+	// such as gvproxy, it's used instead of NAT
+	unixSocketPath string
+	macAddress     net.HardwareAddr
+	// subnet, ipAddress and hostname configure the macOS DHCP server of
+	// the NAT network, they are checked before starting the virtual machine
+	subnet    *net.IPNet
+	ipAddress net.IP
+	hostname  string
+	// mtu is the MTU of the unixSocketPath attachment, 0 for the default
+	mtu uint
 }
 
+const (
+	// minMTU and maxMTU are the MTUs supported by
+	// VZFileHandleNetworkDeviceAttachment
+	minMTU = 1500
+	maxMTU = 65535
+)
+
+// serialIDRegexp matches the values of the virtio-serial 'id' option, they
+// are used in file names
+var serialIDRegexp = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_-]*$`)
+
 type virtioSerial struct {
+	// id names the device in the generated artifact paths and in 'vfkit
+	// attach', the devices are otherwise identified by their index
+	id      string
 	logFile string
+	// consoleSocket is the unix socket clients attach to with 'vfkit
+	// attach', it's generated when the 'console' option is set without a
+	// path
+	console       bool
+	consoleSocket string
+	// serialConsole is created with the virtual machine configuration, and
+	// kept when the configuration is created again
+	serialConsole *console.Console
+	// pty connects the serial port to a pseudo-terminal instead of a log
+	// file, it's opened with the virtual machine configuration
+	pty    bool
+	ptyDev *console.PTY
+	// devNull replaces the log file, console or pseudo-terminal while
+	// Validate runs, so that they are not opened
+	devNull *os.File
 }
 
-// TODO: Add VirtioBalloon
-// https://github.com/Code-Hex/vz/blob/master/memory_balloon.go
+// virtioBalloon is a virtio traditional memory balloon device, it lets the
+// host reclaim memory from the guest.
+type virtioBalloon struct{}
 
 type option struct {
 	key   string
@@ -58,7 +137,7 @@ func strToOption(str string) option {
 		key: splitStr[0],
 	}
 	if len(splitStr) > 1 {
-		opt.value = splitStr[1]
+		opt.value = util.UnquoteOptionValue(splitStr[1])
 	}
 
 	return opt
@@ -76,61 +155,168 @@ func strvToOptions(opts []string) []option {
 	return parsedOpts
 }
 
-func deviceFromCmdLine(deviceOpts string) (VirtioDevice, error) {
-	opts := strings.Split(deviceOpts, ",")
-	if len(opts) == 0 {
-		return nil, fmt.Errorf("empty option list in command line argument")
+// optionsFromCmdLine parses a comma separated list of options, values can be
+// quoted as done by util.QuoteOptionValue.
+func optionsFromCmdLine(optsStr string) ([]option, error) {
+	optsStrv, err := util.SplitOptions(optsStr)
+	if err != nil {
+		return nil, err
 	}
-	var dev VirtioDevice
-	switch opts[0] {
+
+	return strvToOptions(optsStrv), nil
+}
+
+func newDevice(devType string) (VirtioDevice, error) {
+	switch devType {
+	case "virtio-balloon":
+		return &virtioBalloon{}, nil
 	case "virtio-blk":
-		dev = &virtioBlk{}
+		return &virtioBlk{}, nil
 	case "virtio-fs":
-		dev = &virtioFs{}
+		return &virtioFs{}, nil
 	case "virtio-net":
-		dev = &virtioNet{}
+		return &virtioNet{}, nil
+	case "virtio-pmem":
+		return nil, fmt.Errorf("virtio-pmem devices are not supported by vz v3.0.0, use a virtio-fs share instead")
 	case "virtio-rng":
-		dev = &virtioRng{}
+		return &virtioRng{}, nil
 	case "virtio-serial":
-		dev = &virtioSerial{}
+		return &virtioSerial{}, nil
 	case "virtio-vsock":
-		dev = &VirtioVsock{}
+		return &VirtioVsock{}, nil
+	case "usb-mass-storage":
+		return &usbMassStorage{}, nil
 	default:
-		return nil, fmt.Errorf("unknown device type: %s", opts[0])
+		return nil, fmt.Errorf("unknown device type: %s", devType)
 	}
+}
 
-	parsedOpts := strvToOptions(opts[1:])
-	if err := dev.FromOptions(parsedOpts); err != nil {
+func deviceFromOptions(devType string, options []option) (VirtioDevice, error) {
+	dev, err := newDevice(devType)
+	if err != nil {
+		return nil, err
+	}
+	if err := dev.FromOptions(options); err != nil {
 		return nil, err
 	}
 
 	return dev, nil
 }
 
+func deviceFromCmdLine(deviceOpts string) (VirtioDevice, error) {
+	opts, err := util.SplitOptions(deviceOpts)
+	if err != nil {
+		return nil, err
+	}
+	if len(opts) == 0 {
+		return nil, fmt.Errorf("empty option list in command line argument")
+	}
+
+	return deviceFromOptions(opts[0], strvToOptions(opts[1:]))
+}
+
 func (dev *virtioSerial) FromOptions(options []option) error {
 	for _, option := range options {
 		switch option.key {
+		case "id":
+			if !serialIDRegexp.MatchString(option.value) {
+				return fmt.Errorf("Unexpected value for virtio-serial 'id' option: %s, it must start with a letter and only contain letters, digits, '-' and '_'", option.value)
+			}
+			dev.id = option.value
 		case "logFilePath":
 			dev.logFile = option.value
+		case "console":
+			if option.value != "" {
+				return fmt.Errorf("Unexpected value for virtio-serial 'console' option: %s", option.value)
+			}
+			dev.console = true
+		case "consoleSocket":
+			dev.console = true
+			dev.consoleSocket = option.value
+		case "pty":
+			if option.value != "" {
+				return fmt.Errorf("Unexpected value for virtio-serial 'pty' option: %s", option.value)
+			}
+			dev.pty = true
 		default:
 			return fmt.Errorf("Unknown option for virtio-serial devices: %s", option.key)
 		}
 	}
+	if dev.pty && dev.console {
+		return fmt.Errorf("virtio-serial 'pty' and 'console' options cannot be used together")
+	}
+	if dev.pty && dev.logFile != "" {
+		return fmt.Errorf("virtio-serial 'pty' and 'logFilePath' options cannot be used together")
+	}
 	return nil
 }
 
-func (dev *virtioSerial) AddToVirtualMachineConfig(vmConfig *vz.VirtualMachineConfiguration) error {
-	if dev.logFile == "" {
-		return fmt.Errorf("missing mandatory 'logFile' option for virtio-serial device")
+// ArtifactID returns the identifier used to name the artifacts of the
+// index-th virtio-serial device.
+func (dev *virtioSerial) ArtifactID(index int) string {
+	if dev.id != "" {
+		return naming.NamedSerialDeviceID(dev.id)
 	}
-	log.Infof("Adding virtio-serial device (logFile: %s)", dev.logFile)
+	return naming.SerialDeviceID(index)
+}
 
-	//serialPortAttachment := vz.NewFileHandleSerialPortAttachment(os.Stdin, tty)
-	serialPortAttachment, err := vz.NewFileSerialPortAttachment(dev.logFile, false)
-	if err != nil {
-		return err
+func (dev *virtioSerial) toVzSerialPortConfig() (*vz.VirtioConsoleDeviceSerialPortConfiguration, error) {
+	var serialPortAttachment vz.SerialPortAttachment
+	switch {
+	case dev.devNull != nil:
+		attachment, err := vz.NewFileHandleSerialPortAttachment(dev.devNull, dev.devNull)
+		if err != nil {
+			return nil, err
+		}
+		serialPortAttachment = attachment
+	case dev.pty:
+		if dev.ptyDev == nil {
+			ptyDev, err := console.OpenPTY()
+			if err != nil {
+				return nil, err
+			}
+			dev.ptyDev = ptyDev
+		}
+		log.Infof("Adding virtio-serial device (pty: %s)", dev.ptyDev.Path)
+		attachment, err := vz.NewFileHandleSerialPortAttachment(dev.ptyDev.GuestFile(), dev.ptyDev.GuestFile())
+		if err != nil {
+			return nil, err
+		}
+		serialPortAttachment = attachment
+	case dev.logFile == "":
+		return nil, fmt.Errorf("missing mandatory 'logFile' option for virtio-serial device")
+	case dev.console:
+		log.Infof("Adding virtio-serial device (logFile: %s)", dev.logFile)
+		// the console writes the log file
+		if dev.serialConsole == nil {
+			serialConsole, err := console.New()
+			if err != nil {
+				return nil, err
+			}
+			dev.serialConsole = serialConsole
+		}
+		read, write := dev.serialConsole.GuestFiles()
+		attachment, err := vz.NewFileHandleSerialPortAttachment(read, write)
+		if err != nil {
+			return nil, err
+		}
+		serialPortAttachment = attachment
+	default:
+		log.Infof("Adding virtio-serial device (logFile: %s)", dev.logFile)
+		attachment, err := vz.NewFileSerialPortAttachment(dev.logFile, false)
+		if err != nil {
+			return nil, err
+		}
+		serialPortAttachment = attachment
 	}
-	consoleConfig, err := vz.NewVirtioConsoleDeviceSerialPortConfiguration(serialPortAttachment)
+
+	return vz.NewVirtioConsoleDeviceSerialPortConfiguration(serialPortAttachment)
+}
+
+// AddToVirtualMachineConfig sets the serial port of vmConfig,
+// ToVzVirtualMachineConfig adds all the serial ports at once instead.
+func (dev *virtioSerial) AddToVirtualMachineConfig(vmConfig *vz.VirtualMachineConfiguration) error {
+	consoleConfig, err := dev.toVzSerialPortConfig()
 	if err != nil {
 		return err
 	}
@@ -149,30 +335,100 @@ func (dev *virtioNet) FromOptions(options []option) error {
 				return fmt.Errorf("Unexpected value for virtio-net 'nat' option: %s", option.value)
 			}
 			dev.nat = true
+		case "unixSocketPath":
+			dev.unixSocketPath = option.value
 		case "mac":
 			macAddress, err := net.ParseMAC(option.value)
 			if err != nil {
 				return err
 			}
 			dev.macAddress = macAddress
+		case "subnet":
+			_, subnet, err := net.ParseCIDR(option.value)
+			if err != nil || subnet.IP.To4() == nil {
+				return fmt.Errorf("invalid IPv4 subnet for virtio-net 'subnet' option: %s", option.value)
+			}
+			dev.subnet = subnet
+		case "ip":
+			ipAddress := net.ParseIP(option.value).To4()
+			if ipAddress == nil {
+				return fmt.Errorf("invalid IPv4 address for virtio-net 'ip' option: %s", option.value)
+			}
+			dev.ipAddress = ipAddress
+		case "hostname":
+			if option.value == "" || strings.ContainsAny(option.value, " \t#") {
+				return fmt.Errorf("invalid host name for virtio-net 'hostname' option: %s", option.value)
+			}
+			dev.hostname = option.value
+		case "mtu":
+			mtu, err := strconv.ParseUint(option.value, 10, 32)
+			if err != nil || mtu < minMTU || mtu > maxMTU {
+				return fmt.Errorf("invalid MTU for virtio-net 'mtu' option: %s, it must be between %d and %d", option.value, minMTU, maxMTU)
+			}
+			dev.mtu = uint(mtu)
+		case "queues":
+			return fmt.Errorf("virtio-net 'queues' option is not supported by vz v3.0.0")
 		default:
 			return fmt.Errorf("Unknown option for virtio-net devices: %s", option.key)
 		}
 	}
+	if dev.nat && dev.unixSocketPath != "" {
+		return fmt.Errorf("virtio-net 'nat' and 'unixSocketPath' options are mutually exclusive")
+	}
+	if !dev.nat && (dev.subnet != nil || dev.ipAddress != nil || dev.hostname != "") {
+		return fmt.Errorf("virtio-net 'subnet', 'ip' and 'hostname' options need the 'nat' option")
+	}
+	if dev.mtu != 0 && dev.unixSocketPath == "" {
+		return fmt.Errorf("virtio-net 'mtu' option needs the 'unixSocketPath' option, the MTU of the NAT network cannot be changed")
+	}
+	if (dev.ipAddress != nil || dev.hostname != "") && len(dev.macAddress) == 0 {
+		return fmt.Errorf("virtio-net 'ip' and 'hostname' options need the 'mac' option")
+	}
+	if dev.subnet != nil && dev.ipAddress != nil && !dev.subnet.Contains(dev.ipAddress) {
+		return fmt.Errorf("virtio-net IP address %s is not in subnet %s", dev.ipAddress, dev.subnet)
+	}
 	return nil
 }
 
-func (dev *virtioNet) AddToVirtualMachineConfig(vmConfig *vz.VirtualMachineConfiguration) error {
+// checkNATConfig verifies that the macOS DHCP server of the NAT network is
+// configured as requested by the subnet, ip and hostname options. vfkit
+// cannot change this host wide configuration itself.
+func (dev *virtioNet) checkNATConfig() error {
+	subnet := dev.subnet
+	if subnet != nil {
+		if err := dhcp.CheckNATSubnet(subnet); err != nil {
+			return err
+		}
+	} else if dev.ipAddress != nil {
+		var err error
+		if subnet, err = dhcp.NATSubnet(); err != nil {
+			return err
+		}
+		if !subnet.Contains(dev.ipAddress) {
+			return fmt.Errorf("virtio-net IP address %s is not in the macOS NAT network %s", dev.ipAddress, subnet)
+		}
+	}
+	if dev.ipAddress == nil && dev.hostname == "" {
+		return nil
+	}
+	return dhcp.CheckHostEntry(dhcp.DefaultBootptabPath, dhcp.HostEntry{
+		Name:      dev.hostname,
+		HWAddress: dev.macAddress,
+		IPAddress: dev.ipAddress,
+	})
+}
+
+func (dev *virtioNet) toVzNetworkDeviceConfig() (*vz.VirtioNetworkDeviceConfiguration, error) {
 	var (
 		mac *vz.MACAddress
 		err error
 	)
 
-	if !dev.nat {
-		return fmt.Errorf("NAT is the only supported networking mode")
+	if !dev.nat && dev.unixSocketPath == "" {
+		return nil, fmt.Errorf("virtio-net needs the 'nat' or 'unixSocketPath' option")
 	}
 
-	log.Infof("Adding virtio-net device (nat: %t macAddress: [%s])", dev.nat, dev.macAddress)
+	log.Infof("Adding virtio-net device (nat: %t unixSocketPath: %s macAddress: [%s] mtu: %d)", dev.nat, dev.unixSocketPath, dev.macAddress, dev.mtu)
 
 	if len(dev.macAddress) == 0 {
 		mac, err = vz.NewRandomLocallyAdministeredMACAddress()
@@ -180,17 +436,40 @@ func (dev *virtioNet) AddToVirtualMachineConfig(vmConfig *vz.VirtualMachineConfi
 		mac, err = vz.NewMACAddress(dev.macAddress)
 	}
 	if err != nil {
-		return err
+		return nil, err
+	}
+	// keep the generated address for Inspect
+	dev.macAddress = mac.HardwareAddr()
+	if dev.nat {
+		if err := dev.checkNATConfig(); err != nil {
+			return nil, err
+		}
+	}
+	var attachment vz.NetworkDeviceAttachment
+	if dev.unixSocketPath != "" {
+		attachment, err = unixSocketAttachment(dev.unixSocketPath, dev.mtu)
+	} else {
+		attachment, err = vz.NewNATNetworkDeviceAttachment()
 	}
-	natAttachment, err := vz.NewNATNetworkDeviceAttachment()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	networkConfig, err := vz.NewVirtioNetworkDeviceConfiguration(natAttachment)
+	networkConfig, err := vz.NewVirtioNetworkDeviceConfiguration(attachment)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	networkConfig.SetMACAddress(mac)
+
+	return networkConfig, nil
+}
+
+// AddToVirtualMachineConfig sets the network device of vmConfig,
+// ToVzVirtualMachineConfig adds all the network devices at once instead.
+func (dev *virtioNet) AddToVirtualMachineConfig(vmConfig *vz.VirtualMachineConfiguration) error {
+	networkConfig, err := dev.toVzNetworkDeviceConfig()
+	if err != nil {
+		return err
+	}
 	vmConfig.SetNetworkDevicesVirtualMachineConfiguration([]*vz.VirtioNetworkDeviceConfiguration{
 		networkConfig,
 	})
@@ -198,9 +477,34 @@ func (dev *virtioNet) AddToVirtualMachineConfig(vmConfig *vz.VirtualMachineConfi
 	return nil
 }
 
-func (dev *virtioRng) FromOptions(options []option) error {
+func (dev *virtioBalloon) FromOptions(options []option) error {
 	if len(options) != 0 {
-		return fmt.Errorf("Unknown options for virtio-rng devices: %s", options)
+		return fmt.Errorf("Unknown option for virtio-balloon devices: %s", options[0].key)
+	}
+	return nil
+}
+
+func (dev *virtioBalloon) AddToVirtualMachineConfig(vmConfig *vz.VirtualMachineConfiguration) error {
+	log.Infof("Adding virtio-balloon device")
+	balloonConfig, err := vz.NewVirtioTraditionalMemoryBalloonDeviceConfiguration()
+	if err != nil {
+		return err
+	}
+	vmConfig.SetMemoryBalloonDevicesVirtualMachineConfiguration([]vz.MemoryBalloonDeviceConfiguration{
+		balloonConfig,
+	})
+
+	return nil
+}
+
+func (dev *virtioRng) FromOptions(options []option) error {
+	for _, option := range options {
+		switch option.key {
+		case "src", "maxBytes", "period":
+			return fmt.Errorf("virtio-rng '%s' option is not supported by vz v3.0.0", option.key)
+		default:
+			return fmt.Errorf("Unknown option for virtio-rng devices: %s", option.key)
+		}
 	}
 	return nil
 }
@@ -223,26 +527,45 @@ func (dev *virtioBlk) FromOptions(options []option) error {
 		switch option.key {
 		case "path":
 			dev.imagePath = option.value
+		case "sync", "caching", "discard", "queues":
+			return fmt.Errorf("virtio-blk '%s' option is not supported by vz v3.0.0", option.key)
+		case "ram":
+			size, err := util.ParseMemorySize(option.value)
+			if err != nil {
+				return fmt.Errorf("invalid size for virtio-blk 'ram' option: %w", err)
+			}
+			dev.ramSizeBytes = size
 		default:
 			return fmt.Errorf("Unknown option for virtio-blk devices: %s", option.key)
 		}
 	}
+	if dev.ramSizeBytes != 0 && dev.imagePath != "" {
+		return fmt.Errorf("virtio-blk 'ram' and 'path' options cannot be used together, vfkit creates the RAM device of RAM disks")
+	}
 	return nil
 }
 
-func (dev *virtioBlk) AddToVirtualMachineConfig(vmConfig *vz.VirtualMachineConfiguration) error {
+func (dev *virtioBlk) toVzStorageDeviceConfig() (vz.StorageDeviceConfiguration, error) {
 	if dev.imagePath == "" {
-		return fmt.Errorf("missing mandatory 'path' option for virtio-blk device")
+		return nil, fmt.Errorf("missing mandatory 'path' or 'ram' option for virtio-blk device")
 	}
-	log.Infof("Adding virtio-blk device (imagePath: %s)", dev.imagePath)
+	imagePath := dev.imagePath
+	if dev.rawImagePath != "" {
+		imagePath = dev.rawImagePath
+	}
+	log.Infof("Adding virtio-blk device (imagePath: %s)", imagePath)
 	diskImageAttachment, err := vz.NewDiskImageStorageDeviceAttachment(
-		dev.imagePath,
+		imagePath,
 		false,
 	)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	storageDeviceConfig, err := vz.NewVirtioBlockDeviceConfiguration(diskImageAttachment)
+	return vz.NewVirtioBlockDeviceConfiguration(diskImageAttachment)
+}
+
+func (dev *virtioBlk) AddToVirtualMachineConfig(vmConfig *vz.VirtualMachineConfiguration) error {
+	storageDeviceConfig, err := dev.toVzStorageDeviceConfig()
 	if err != nil {
 		return err
 	}
@@ -269,6 +592,12 @@ func (dev *VirtioVsock) FromOptions(options []option) error {
 			dev.Listen = true
 		case "connect":
 			dev.Listen = false
+		case "forward":
+			forward, err := parseVsockForward(option.value)
+			if err != nil {
+				return err
+			}
+			dev.Forwards = append(dev.Forwards, *forward)
 		default:
 			return fmt.Errorf("Unknown option for virtio-vsock devices: %s", option.key)
 		}
@@ -276,6 +605,44 @@ func (dev *VirtioVsock) FromOptions(options []option) error {
 	return nil
 }
 
+// parseVsockForward parses a port mapping in the
+// <port>[:<socketURL>][:listen|:connect] format. The mapping is in listen mode
+// by default, similar to the virtio-vsock device options.
+func parseVsockForward(str string) (*VsockForward, error) {
+	forward := VsockForward{Listen: true}
+	split := strings.SplitN(str, ":", 2)
+	port, err := strconv.ParseUint(split[0], 10, 32)
+	if err != nil || port == 0 {
+		return nil, fmt.Errorf("invalid port in vsock forward '%s'", str)
+	}
+	forward.Port = uint(port)
+	if len(split) == 1 {
+		return &forward, nil
+	}
+
+	socketURL := split[1]
+	switch {
+	case socketURL == "listen" || strings.HasSuffix(socketURL, ":listen"):
+		socketURL = strings.TrimSuffix(socketURL, "listen")
+	case socketURL == "connect" || strings.HasSuffix(socketURL, ":connect"):
+		socketURL = strings.TrimSuffix(socketURL, "connect")
+		forward.Listen = false
+	}
+	forward.SocketURL = strings.TrimSuffix(socketURL, ":")
+
+	return &forward, nil
+}
+
+// VsockForwards returns all the port mappings of the device, the one set with
+// the 'port' option followed by the 'forward' ones.
+func (dev *VirtioVsock) VsockForwards() []VsockForward {
+	forwards := []VsockForward{}
+	if dev.Port != 0 {
+		forwards = append(forwards, VsockForward{Port: dev.Port, SocketURL: dev.SocketURL, Listen: dev.Listen})
+	}
+	return append(forwards, dev.Forwards...)
+}
+
 func (dev *VirtioVsock) AddToVirtualMachineConfig(vmConfig *vz.VirtualMachineConfiguration) error {
 	if len(vmConfig.SocketDevices()) != 0 {
 		log.Debugf("virtio-vsock device already present, not adding a second one")
@@ -294,6 +661,22 @@ func (dev *VirtioVsock) AddToVirtualMachineConfig(vmConfig *vz.VirtualMachineCon
 type virtioFs struct {
 	sharedDir string
 	mountTag  string
+	// vsock port of the guest agent to notify of host changes, 0 when
+	// changes are not watched
+	notifyPort uint
+	// the share must be on a case-sensitive host volume
+	caseSensitive bool
+	// the share must be on a host volume supporting extended attributes
+	xattr bool
+}
+
+// MountTag returns the tag used to mount the share in the guest. It defaults
+// to the name of the shared directory.
+func (dev *virtioFs) MountTag() string {
+	if dev.mountTag != "" {
+		return dev.mountTag
+	}
+	return filepath.Base(dev.sharedDir)
 }
 
 func (dev *virtioFs) FromOptions(options []option) error {
@@ -303,6 +686,22 @@ func (dev *virtioFs) FromOptions(options []option) error {
 			dev.sharedDir = option.value
 		case "mountTag":
 			dev.mountTag = option.value
+		case "notify":
+			if option.value == "" {
+				dev.notifyPort = agent.DefaultVsockPort
+				break
+			}
+			port, err := strconv.ParseUint(option.value, 10, 32)
+			if err != nil || port == 0 {
+				return fmt.Errorf("invalid agent vsock port for virtio-fs 'notify' option: %s", option.value)
+			}
+			dev.notifyPort = uint(port)
+		case "caseSensitive":
+			dev.caseSensitive = true
+		case "xattr":
+			dev.xattr = true
+		case "cache", "dax", "queueSize":
+			return fmt.Errorf("virtio-fs '%s' option is not supported by vz v3.0.0", option.key)
 		default:
 			return fmt.Errorf("Unknown option for virtio-fs devices: %s", option.key)
 		}
@@ -310,31 +709,76 @@ func (dev *virtioFs) FromOptions(options []option) error {
 	return nil
 }
 
-func (dev *virtioFs) AddToVirtualMachineConfig(vmConfig *vz.VirtualMachineConfiguration) error {
+// isCaseSensitive and supportsXattrs query the host volume of a shared
+// directory, tests replace them to check volumes with other properties.
+var (
+	isCaseSensitive = volumeIsCaseSensitive
+	supportsXattrs  = volumeSupportsXattrs
+)
+
+// checkHostVolume checks that the host volume of the shared directory has the
+// properties requested with the 'caseSensitive' and 'xattr' options.
+// virtio-fs exposes the host file system semantics as is, they can't be
+// changed for the guest.
+func (dev *virtioFs) checkHostVolume() error {
+	caseSensitive, err := isCaseSensitive(dev.sharedDir)
+	switch {
+	case err != nil && dev.caseSensitive:
+		return fmt.Errorf("cannot check case sensitivity of %s: %w", dev.sharedDir, err)
+	case err != nil:
+		log.Debugf("cannot check case sensitivity of %s: %v", dev.sharedDir, err)
+	case !caseSensitive && dev.caseSensitive:
+		return fmt.Errorf("%s is on a case-insensitive volume, the 'caseSensitive' virtio-fs option needs a case-sensitive volume", dev.sharedDir)
+	case !caseSensitive:
+		log.Warnf("%s is on a case-insensitive volume, files whose names only differ by case will overwrite each other in the guest", dev.sharedDir)
+	}
+
+	if dev.xattr {
+		xattr, err := supportsXattrs(dev.sharedDir)
+		if err != nil {
+			return fmt.Errorf("cannot check extended attributes support of %s: %w", dev.sharedDir, err)
+		}
+		if !xattr {
+			return fmt.Errorf("%s is on a volume without extended attributes support, needed by the 'xattr' virtio-fs option", dev.sharedDir)
+		}
+	}
+
+	return nil
+}
+
+func (dev *virtioFs) toVzDirectorySharingDeviceConfig() (*vz.VirtioFileSystemDeviceConfiguration, error) {
 	log.Infof("Adding virtio-fs device")
 	if dev.sharedDir == "" {
-		return fmt.Errorf("missing mandatory 'sharedDir' option for virtio-fs device")
+		return nil, fmt.Errorf("missing mandatory 'sharedDir' option for virtio-fs device")
 	}
-	var mountTag string
-	if dev.mountTag != "" {
-		mountTag = dev.mountTag
-	} else {
-		mountTag = filepath.Base(dev.sharedDir)
+	mountTag := dev.MountTag()
+	if err := dev.checkHostVolume(); err != nil {
+		return nil, err
 	}
 
 	sharedDir, err := vz.NewSharedDirectory(dev.sharedDir, false)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	sharedDirConfig, err := vz.NewSingleDirectoryShare(sharedDir)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	fileSystemDeviceConfig, err := vz.NewVirtioFileSystemDeviceConfiguration(mountTag)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	fileSystemDeviceConfig.SetDirectoryShare(sharedDirConfig)
+	return fileSystemDeviceConfig, nil
+}
+
+// AddToVirtualMachineConfig sets the directory sharing device of vmConfig,
+// ToVzVirtualMachineConfig adds all the virtio-fs devices at once instead.
+func (dev *virtioFs) AddToVirtualMachineConfig(vmConfig *vz.VirtualMachineConfiguration) error {
+	fileSystemDeviceConfig, err := dev.toVzDirectorySharingDeviceConfig()
+	if err != nil {
+		return err
+	}
 	vmConfig.SetDirectorySharingDevicesVirtualMachineConfiguration([]vz.DirectorySharingDeviceConfiguration{
 		fileSystemDeviceConfig,
 	})
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/crc-org/vfkit/pkg/agent"
+)
+
+// WatchdogAction is what vfkit does when the guest stops responding to the
+// watchdog heartbeats.
+type WatchdogAction string
+
+const (
+	// WatchdogRestart forcefully stops the virtual machine and starts it
+	// again
+	WatchdogRestart WatchdogAction = "restart"
+	// WatchdogPoweroff forcefully stops the virtual machine
+	WatchdogPoweroff WatchdogAction = "poweroff"
+)
+
+// Watchdog configures a heartbeat sent periodically to the vfkit guest agent.
+// When the guest agent does not answer for longer than the timeout, the
+// virtual machine is considered hung and the watchdog action is run.
+type Watchdog struct {
+	action    WatchdogAction
+	timeout   time.Duration
+	interval  time.Duration
+	agentPort uint
+}
+
+// Action is run when the guest stops responding.
+func (watchdog *Watchdog) Action() WatchdogAction {
+	return watchdog.action
+}
+
+// Timeout is how long the guest can go without answering a heartbeat before
+// the watchdog action is run.
+func (watchdog *Watchdog) Timeout() time.Duration {
+	return watchdog.timeout
+}
+
+// Interval is the delay between two heartbeats.
+func (watchdog *Watchdog) Interval() time.Duration {
+	return watchdog.interval
+}
+
+// AgentPort is the vsock port of the guest agent.
+func (watchdog *Watchdog) AgentPort() uint {
+	return watchdog.agentPort
+}
+
+// WatchdogFromCmdLine parses the options of the --watchdog command line
+// argument, "[action=restart|poweroff][,timeout=30s][,interval=10s][,agentPort=1025]".
+// The interval defaults to a third of the timeout.
+func WatchdogFromCmdLine(optsStr string) (*Watchdog, error) {
+	watchdog := Watchdog{
+		action:    WatchdogRestart,
+		timeout:   30 * time.Second,
+		agentPort: agent.DefaultVsockPort,
+	}
+
+	options, err := optionsFromCmdLine(optsStr)
+	if err != nil {
+		return nil, err
+	}
+	for _, option := range options {
+		switch option.key {
+		case "action":
+			watchdog.action = WatchdogAction(option.value)
+			switch watchdog.action {
+			case WatchdogRestart, WatchdogPoweroff:
+			default:
+				return nil, fmt.Errorf("Unknown watchdog action: %s", option.value)
+			}
+		case "timeout":
+			timeout, err := time.ParseDuration(option.value)
+			if err != nil {
+				return nil, err
+			}
+			watchdog.timeout = timeout
+		case "interval":
+			interval, err := time.ParseDuration(option.value)
+			if err != nil {
+				return nil, err
+			}
+			watchdog.interval = interval
+		case "agentPort":
+			port, err := strconv.ParseUint(option.value, 10, 32)
+			if err != nil || port == 0 {
+				return nil, fmt.Errorf("invalid agent vsock port for watchdog parameter: %s", option.value)
+			}
+			watchdog.agentPort = uint(port)
+		default:
+			return nil, fmt.Errorf("Unknown option for watchdog parameter: %s", option.key)
+		}
+	}
+
+	if watchdog.timeout <= 0 {
+		return nil, fmt.Errorf("Invalid 'timeout' option for watchdog parameter: %s", watchdog.timeout)
+	}
+	if watchdog.interval == 0 {
+		watchdog.interval = watchdog.timeout / 3
+	}
+	if watchdog.interval <= 0 || watchdog.interval >= watchdog.timeout {
+		return nil, fmt.Errorf("Invalid 'interval' option for watchdog parameter: %s, it must be shorter than the timeout", watchdog.interval)
+	}
+
+	return &watchdog, nil
+}
@@ -0,0 +1,69 @@
+package console
+
+import (
+	"context"
+	"io"
+	"net"
+)
+
+// DetachKey is the key which detaches 'vfkit attach' from the console, ^].
+const DetachKey = 0x1d
+
+// Connect attaches rw to the console listening on the unix socket at path:
+// the guest output is written to rw and what is read from rw is sent to the
+// guest. It returns when ctx is cancelled, when reading rw returns an error
+// or io.EOF, or when the console is closed.
+func Connect(ctx context.Context, path string, rw io.ReadWriter) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", path)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	errCh := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(rw, conn)
+		errCh <- err
+	}()
+	go func() {
+		_, err := io.Copy(conn, rw)
+		errCh <- err
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-errCh:
+		return err
+	}
+}
+
+// detachReader returns io.EOF when DetachKey is read.
+type detachReader struct {
+	r        io.Reader
+	detached bool
+}
+
+// NewDetachReader returns a reader which reads from r until DetachKey is
+// read, it can be given to Connect to detach from the console with ^].
+func NewDetachReader(r io.Reader) io.Reader {
+	return &detachReader{r: r}
+}
+
+func (d *detachReader) Read(p []byte) (int, error) {
+	if d.detached {
+		return 0, io.EOF
+	}
+	n, err := d.r.Read(p)
+	for i := 0; i < n; i++ {
+		if p[i] == DetachKey {
+			d.detached = true
+			if i == 0 {
+				return 0, io.EOF
+			}
+			return i, nil
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,197 @@
+// Package console connects the serial port of a virtual machine to a unix
+// socket. Several clients can attach to the socket at the same time: they all
+// receive the output of the guest, starting with its most recent output, and
+// what they type is sent to the guest. The serial port can also be connected
+// to a pseudo-terminal, see OpenPTY.
+package console
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ScrollbackSize is the amount of guest output sent to the clients when they
+// attach.
+const ScrollbackSize = 64 * 1024
+
+// clientWriteTimeout is how long the guest output can wait for a client, a
+// client which does not read its output is detached.
+const clientWriteTimeout = time.Second
+
+// Console multiplexes the serial port of a virtual machine between the
+// clients attached to it.
+type Console struct {
+	// the guest reads from guestIn and writes to guestOut, vfkit uses the
+	// other ends of the pipes
+	guestIn   *os.File
+	guestOut  *os.File
+	input     *os.File
+	output    *os.File
+	logWriter io.WriteCloser
+
+	lock       sync.Mutex
+	scrollback []byte
+	clients    map[net.Conn]struct{}
+	listener   net.Listener
+}
+
+// New creates the pipes of a console. GuestFiles returns the files to attach
+// to the serial port of the virtual machine, the output of the guest is only
+// read once Start is called.
+func New() (*Console, error) {
+	guestIn, input, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	output, guestOut, err := os.Pipe()
+	if err != nil {
+		guestIn.Close()
+		input.Close()
+		return nil, err
+	}
+
+	return &Console{
+		guestIn:  guestIn,
+		guestOut: guestOut,
+		input:    input,
+		output:   output,
+		clients:  map[net.Conn]struct{}{},
+	}, nil
+}
+
+// GuestFiles returns the files the virtual machine reads its serial input
+// from and writes its serial output to.
+func (c *Console) GuestFiles() (read *os.File, write *os.File) {
+	return c.guestIn, c.guestOut
+}
+
+// Start starts reading the output of the guest. It's appended to the file at
+// logFilePath when it's not empty.
+func (c *Console) Start(logFilePath string) error {
+	if logFilePath != "" {
+		logFile, err := os.OpenFile(logFilePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		c.logWriter = logFile
+	}
+	go c.readOutput()
+
+	return nil
+}
+
+func (c *Console) readOutput() {
+	buf := make([]byte, 4096)
+	for {
+		n, err := c.output.Read(buf)
+		if n > 0 {
+			c.broadcast(buf[:n])
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, os.ErrClosed) {
+				log.Warnf("error reading the serial console output: %v", err)
+			}
+			return
+		}
+	}
+}
+
+func (c *Console) broadcast(data []byte) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.logWriter != nil {
+		if _, err := c.logWriter.Write(data); err != nil {
+			log.Warnf("error writing the serial console log: %v", err)
+		}
+	}
+	c.scrollback = append(c.scrollback, data...)
+	if len(c.scrollback) > ScrollbackSize {
+		c.scrollback = append([]byte{}, c.scrollback[len(c.scrollback)-ScrollbackSize:]...)
+	}
+	for conn := range c.clients {
+		_ = conn.SetWriteDeadline(time.Now().Add(clientWriteTimeout))
+		if _, err := conn.Write(data); err != nil {
+			// a slow or gone client must not block the guest
+			log.Debugf("detaching console client: %v", err)
+			conn.Close()
+			delete(c.clients, conn)
+		}
+	}
+}
+
+// Listen accepts console clients on the unix socket at path.
+func (c *Console) Listen(path string) error {
+	_ = os.Remove(path)
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	c.lock.Lock()
+	c.listener = listener
+	c.lock.Unlock()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					log.Warnf("console socket %s stopped: %v", path, err)
+				}
+				return
+			}
+			go c.Attach(conn)
+		}
+	}()
+	log.Infof("serial console listening on %s", path)
+
+	return nil
+}
+
+// Attach sends the recent guest output and the new guest output to conn, and
+// what is read from conn to the guest. It returns when conn is closed.
+func (c *Console) Attach(conn net.Conn) {
+	c.lock.Lock()
+	if _, err := conn.Write(c.scrollback); err != nil {
+		c.lock.Unlock()
+		conn.Close()
+		return
+	}
+	c.clients[conn] = struct{}{}
+	c.lock.Unlock()
+
+	_, _ = io.Copy(c.input, conn)
+
+	c.lock.Lock()
+	delete(c.clients, conn)
+	c.lock.Unlock()
+	conn.Close()
+}
+
+// Close detaches the clients and stops listening on the console socket. The
+// pipes of the guest are closed.
+func (c *Console) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.listener != nil {
+		_ = c.listener.Close()
+	}
+	for conn := range c.clients {
+		conn.Close()
+		delete(c.clients, conn)
+	}
+	for _, f := range []*os.File{c.guestIn, c.guestOut, c.input, c.output} {
+		_ = f.Close()
+	}
+	if c.logWriter != nil {
+		return c.logWriter.Close()
+	}
+
+	return nil
+}
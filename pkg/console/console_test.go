@@ -0,0 +1,153 @@
+package console
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConsole(t *testing.T) {
+	tmpDir := t.TempDir()
+	logPath := filepath.Join(tmpDir, "serial.log")
+	socketPath := filepath.Join(tmpDir, "console.sock")
+
+	c, err := New()
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	defer c.Close()
+	if err := c.Start(logPath); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if err := c.Listen(socketPath); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	guestIn, guestOut := c.GuestFiles()
+
+	if _, err := guestOut.Write([]byte("login: ")); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	waitFor(t, func() bool {
+		data, _ := os.ReadFile(logPath)
+		return string(data) == "login: "
+	})
+
+	inputR, inputW := io.Pipe()
+	output := &syncBuffer{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- Connect(ctx, socketPath, struct {
+			io.Reader
+			io.Writer
+		}{NewDetachReader(inputR), output})
+	}()
+
+	// the client receives the scrollback, then the new output
+	waitFor(t, func() bool { return output.String() == "login: " })
+	if _, err := guestOut.Write([]byte("root\n")); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	waitFor(t, func() bool { return output.String() == "login: root\n" })
+
+	if _, err := inputW.Write([]byte("ls\n")); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	buf := make([]byte, 3)
+	if _, err := io.ReadFull(guestIn, buf); err != nil || string(buf) != "ls\n" {
+		t.Fatalf("unexpected guest input %q: %v", buf, err)
+	}
+
+	if _, err := inputW.Write([]byte{DetachKey}); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatal("expected no error; got", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the client to detach")
+	}
+}
+
+func TestDetachReader(t *testing.T) {
+	r := NewDetachReader(strings.NewReader("abc\x1ddef"))
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if string(data) != "abc" {
+		t.Fatalf("unexpected data %q", data)
+	}
+}
+
+type syncBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.String()
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for condition")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPTY(t *testing.T) {
+	pty, err := OpenPTY()
+	if err != nil {
+		t.Skip("cannot open a pseudo-terminal:", err)
+	}
+	defer pty.Close()
+
+	user, err := os.OpenFile(pty.Path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	defer user.Close()
+	if _, err := pty.GuestFile().Write([]byte("login: ")); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	buf := make([]byte, 7)
+	if _, err := io.ReadFull(user, buf); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if string(buf) != "login: " {
+		t.Fatalf("unexpected output: %q", buf)
+	}
+	// raw mode, the newline is not translated
+	if _, err := user.Write([]byte("root\n")); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	buf = make([]byte, 5)
+	if _, err := io.ReadFull(pty.GuestFile(), buf); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if string(buf) != "root\n" {
+		t.Fatalf("unexpected input: %q", buf)
+	}
+}
@@ -0,0 +1,55 @@
+package console
+
+import (
+	"os"
+
+	"github.com/crc-org/vfkit/pkg/util/term"
+)
+
+// PTY is a pseudo-terminal connected to the serial port of a virtual
+// machine, users open it with a terminal program such as screen.
+type PTY struct {
+	// Path is the path of the terminal side of the pseudo-terminal.
+	Path string
+
+	// the guest reads and writes master
+	master *os.File
+	// the terminal side is kept open, reading master fails when nothing
+	// has it open
+	terminal *os.File
+}
+
+// OpenPTY opens a new pseudo-terminal in raw mode.
+func OpenPTY() (*PTY, error) {
+	master, path, err := openPTY()
+	if err != nil {
+		return nil, err
+	}
+	terminal, err := os.OpenFile(path, os.O_RDWR|openNoCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, err
+	}
+	if _, err := term.MakeRaw(int(terminal.Fd())); err != nil {
+		terminal.Close()
+		master.Close()
+		return nil, err
+	}
+
+	return &PTY{Path: path, master: master, terminal: terminal}, nil
+}
+
+// GuestFile returns the file the guest reads its input from and writes its
+// output to.
+func (p *PTY) GuestFile() *os.File {
+	return p.master
+}
+
+// Close closes the pseudo-terminal.
+func (p *PTY) Close() error {
+	err := p.terminal.Close()
+	if masterErr := p.master.Close(); err == nil {
+		err = masterErr
+	}
+	return err
+}
@@ -0,0 +1,36 @@
+package console
+
+import (
+	"bytes"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const openNoCTTY = unix.O_NOCTTY
+
+func openPTY() (*os.File, string, error) {
+	fd, err := unix.Open("/dev/ptmx", unix.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, "", err
+	}
+	master := os.NewFile(uintptr(fd), "/dev/ptmx")
+	// grantpt(3) and unlockpt(3)
+	if err := unix.IoctlSetInt(fd, unix.TIOCPTYGRANT, 0); err != nil {
+		master.Close()
+		return nil, "", err
+	}
+	if err := unix.IoctlSetInt(fd, unix.TIOCPTYUNLK, 0); err != nil {
+		master.Close()
+		return nil, "", err
+	}
+	// ptsname(3)
+	name := make([]byte, 128)
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), uintptr(unix.TIOCPTYGNAME), uintptr(unsafe.Pointer(&name[0]))); errno != 0 {
+		master.Close()
+		return nil, "", errno
+	}
+
+	return master, string(name[:bytes.IndexByte(name, 0)]), nil
+}
@@ -0,0 +1,30 @@
+package console
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+const openNoCTTY = unix.O_NOCTTY
+
+func openPTY() (*os.File, string, error) {
+	fd, err := unix.Open("/dev/ptmx", unix.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, "", err
+	}
+	master := os.NewFile(uintptr(fd), "/dev/ptmx")
+	// unlockpt(3) and ptsname(3)
+	if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		master.Close()
+		return nil, "", err
+	}
+	n, err := unix.IoctlGetInt(fd, unix.TIOCGPTN)
+	if err != nil {
+		master.Close()
+		return nil, "", err
+	}
+
+	return master, fmt.Sprintf("/dev/pts/%d", n), nil
+}
@@ -0,0 +1,131 @@
+package dhcp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+// DefaultBootptabPath is the path to the static host table of the macOS DHCP
+// server, bootpd. It's only writable by root.
+const DefaultBootptabPath = "/etc/bootptab"
+
+// HostEntry is a static DHCP lease in the bootpd host table: the guest with
+// HWAddress always gets IPAddress, and Name as its host name.
+type HostEntry struct {
+	Name      string
+	HWAddress net.HardwareAddr
+	IPAddress net.IP
+}
+
+// String returns entry formatted as a line of the bootpd host table.
+func (entry HostEntry) String() string {
+	return fmt.Sprintf("%s\t1\t%s\t%s", entry.Name, entry.HWAddress, entry.IPAddress)
+}
+
+// ParseBootptab parses the content of a bootpd host table. Entries are
+// whitespace separated "name hwtype hwaddr ipaddr [bootfile]" lines following
+// a "%%" line, '#' starts a comment.
+func ParseBootptab(r io.Reader) ([]HostEntry, error) {
+	entries := []HostEntry{}
+	inHosts := false
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+			continue
+		case line == "%%":
+			inHosts = true
+			continue
+		case !inHosts:
+			// legacy network configuration, ignored by bootpd
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("invalid line in bootpd host table: %s", line)
+		}
+		if fields[1] != "1" {
+			// not an ethernet entry
+			continue
+		}
+		hwAddr, err := parseHWAddress(fields[2])
+		if err != nil {
+			return nil, err
+		}
+		ipAddr := net.ParseIP(fields[3])
+		if ipAddr == nil {
+			return nil, fmt.Errorf("invalid IP address in bootpd host table: %s", fields[3])
+		}
+		entries = append(entries, HostEntry{Name: fields[0], HWAddress: hwAddr, IPAddress: ipAddr})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// FindHostEntry returns the entry for the hardware address mac in the bootpd
+// host table at bootptabPath. It returns nil when there is none.
+func FindHostEntry(bootptabPath string, mac net.HardwareAddr) (*HostEntry, error) {
+	file, err := os.Open(bootptabPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries, err := ParseBootptab(file)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.HWAddress.String() == mac.String() {
+			entry := entry
+			return &entry, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// CheckHostEntry verifies that the bootpd host table at bootptabPath has a
+// static lease for expected.HWAddress matching the IP address and name of
+// expected, when they are set. vfkit cannot edit the table, so the error
+// gives the line to add, named after the hardware address when expected has
+// no name.
+func CheckHostEntry(bootptabPath string, expected HostEntry) error {
+	entry, err := FindHostEntry(bootptabPath, expected.HWAddress)
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		if expected.IPAddress == nil {
+			return fmt.Errorf("no static DHCP lease for %s in %s, an IP address is needed to add one", expected.HWAddress, bootptabPath)
+		}
+		if expected.Name == "" {
+			expected.Name = strings.ReplaceAll(expected.HWAddress.String(), ":", "")
+		}
+		return fmt.Errorf("no static DHCP lease for %s in %s, add this line after the '%%%%' line of the file (as root): %s", expected.HWAddress, bootptabPath, expected)
+	}
+	if expected.IPAddress != nil && !entry.IPAddress.Equal(expected.IPAddress) {
+		return fmt.Errorf("the static DHCP lease for %s in %s is %s, not %s", expected.HWAddress, bootptabPath, entry.IPAddress, expected.IPAddress)
+	}
+	if expected.Name != "" && entry.Name != expected.Name {
+		return fmt.Errorf("the static DHCP lease for %s in %s has the host name %s, not %s", expected.HWAddress, bootptabPath, entry.Name, expected.Name)
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package dhcp
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const bootptab = `# bootptab
+%%
+# hostname      hwtype  hwaddr              ipaddr          bootfile
+myvm            1       52:54:0:70:2b:71    192.168.64.10
+other           1       0a:00:27:00:00:01   192.168.64.11   boot.img
+`
+
+func TestParseBootptab(t *testing.T) {
+	entries, err := ParseBootptab(strings.NewReader(bootptab))
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+	if entries[0].Name != "myvm" || entries[0].HWAddress.String() != "52:54:00:70:2b:71" || entries[0].IPAddress.String() != "192.168.64.10" {
+		t.Fatalf("unexpected entry: %+v", entries[0])
+	}
+
+	for _, table := range []string{"%%\nmyvm 1 52:54:00:70:2b:71\n", "%%\nmyvm 1 52:54:00:70:2b:71 foo\n"} {
+		if _, err := ParseBootptab(strings.NewReader(table)); err == nil {
+			t.Fatalf("expected error when parsing %q", table)
+		}
+	}
+}
+
+func TestCheckHostEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bootptab")
+	if err := os.WriteFile(path, []byte(bootptab), 0600); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	mac, _ := net.ParseMAC("52:54:00:70:2b:71")
+	if err := CheckHostEntry(path, HostEntry{Name: "myvm", HWAddress: mac, IPAddress: net.ParseIP("192.168.64.10")}); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if err := CheckHostEntry(path, HostEntry{HWAddress: mac, IPAddress: net.ParseIP("192.168.64.12")}); err == nil {
+		t.Fatal("expected error for a different IP address")
+	}
+	if err := CheckHostEntry(path, HostEntry{Name: "web", HWAddress: mac}); err == nil {
+		t.Fatal("expected error for a different host name")
+	}
+
+	mac, _ = net.ParseMAC("5a:94:ef:e4:0c:ee")
+	err := CheckHostEntry(path, HostEntry{HWAddress: mac, IPAddress: net.ParseIP("192.168.64.12")})
+	if err == nil || !strings.Contains(err.Error(), "5a94efe40cee\t1\t5a:94:ef:e4:0c:ee\t192.168.64.12") {
+		t.Fatal("expected error with the line to add; got", err)
+	}
+}
+
+func TestNATSubnet(t *testing.T) {
+	subnet, err := natSubnet("", "")
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if subnet.String() != "192.168.64.0/24" {
+		t.Fatalf("expected the default NAT subnet; got %s", subnet)
+	}
+	subnet, err = natSubnet("192.168.200.1", "255.255.0.0")
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if subnet.String() != "192.168.0.0/16" {
+		t.Fatalf("unexpected NAT subnet: %s", subnet)
+	}
+	if _, err := natSubnet("192.168.200.1", "255.0.255.0"); err == nil {
+		t.Fatal("expected error for an invalid mask")
+	}
+}
@@ -0,0 +1,135 @@
+// Package dhcp looks up the addresses handed out to virtual machines by the
+// macOS DHCP server used for NAT networking, and checks the configuration of
+// this server: the NAT subnet and the static leases.
+package dhcp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+// DefaultLeasesPath is the path to the macOS vmnet DHCP lease database.
+const DefaultLeasesPath = "/var/db/dhcpd_leases"
+
+// Lease is an entry in the DHCP lease database.
+type Lease struct {
+	Name       string
+	IPAddress  net.IP
+	HWAddress  net.HardwareAddr
+	Identifier string
+	Lease      string
+}
+
+// ParseLeases parses the content of a DHCP lease database, which is made of
+// blocks of key=value lines between curly braces.
+func ParseLeases(r io.Reader) ([]Lease, error) {
+	leases := []Lease{}
+	var current *Lease
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+			continue
+		case line == "{":
+			current = &Lease{}
+		case line == "}":
+			if current == nil {
+				return nil, fmt.Errorf("unexpected '}' in DHCP lease database")
+			}
+			leases = append(leases, *current)
+			current = nil
+		default:
+			if current == nil {
+				return nil, fmt.Errorf("unexpected line outside of a lease block: %s", line)
+			}
+			if err := current.parseLine(line); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if current != nil {
+		return nil, fmt.Errorf("unterminated lease block in DHCP lease database")
+	}
+
+	return leases, nil
+}
+
+func (lease *Lease) parseLine(line string) error {
+	splitLine := strings.SplitN(line, "=", 2)
+	if len(splitLine) != 2 {
+		return fmt.Errorf("invalid line in DHCP lease database: %s", line)
+	}
+	key, value := splitLine[0], splitLine[1]
+	switch key {
+	case "name":
+		lease.Name = value
+	case "ip_address":
+		lease.IPAddress = net.ParseIP(value)
+		if lease.IPAddress == nil {
+			return fmt.Errorf("invalid IP address in DHCP lease database: %s", value)
+		}
+	case "hw_address":
+		hwAddr, err := parseHWAddress(value)
+		if err != nil {
+			return err
+		}
+		lease.HWAddress = hwAddr
+	case "identifier":
+		lease.Identifier = value
+	case "lease":
+		lease.Lease = value
+	}
+	// unknown keys are ignored for forward compatibility
+
+	return nil
+}
+
+// parseHWAddress parses hardware addresses as stored in the lease database.
+// They are prefixed by the hardware type ("1," for ethernet), and leading
+// zeroes are omitted ("52:54:0:70:2b:71").
+func parseHWAddress(str string) (net.HardwareAddr, error) {
+	if splitStr := strings.SplitN(str, ",", 2); len(splitStr) == 2 {
+		str = splitStr[1]
+	}
+	octets := strings.Split(str, ":")
+	for i, octet := range octets {
+		if len(octet) == 1 {
+			octets[i] = "0" + octet
+		}
+	}
+
+	return net.ParseMAC(strings.Join(octets, ":"))
+}
+
+// FindLease returns the most recent lease for the hardware address mac in the
+// DHCP lease database at leasesPath.
+func FindLease(leasesPath string, mac net.HardwareAddr) (*Lease, error) {
+	file, err := os.Open(leasesPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	leases, err := ParseLeases(file)
+	if err != nil {
+		return nil, err
+	}
+	// the most recent leases are at the top of the file
+	for _, lease := range leases {
+		if lease.HWAddress.String() == mac.String() {
+			lease := lease
+			return &lease, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no DHCP lease found for %s", mac)
+}
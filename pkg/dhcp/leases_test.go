@@ -0,0 +1,70 @@
+package dhcp
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const leasesDB = `{
+	name=fedora
+	ip_address=192.168.64.3
+	hw_address=1,52:54:0:70:2b:71
+	identifier=1,52:54:0:70:2b:71
+	lease=0x6377a8e1
+}
+{
+	name=debian
+	ip_address=192.168.64.2
+	hw_address=1,a:0:27:0:0:1
+	identifier=1,a:0:27:0:0:1
+	lease=0x6377a001
+}
+`
+
+func TestParseLeases(t *testing.T) {
+	leases, err := ParseLeases(strings.NewReader(leasesDB))
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if len(leases) != 2 {
+		t.Fatalf("expected 2 leases, got %d", len(leases))
+	}
+	if leases[0].Name != "fedora" || leases[0].IPAddress.String() != "192.168.64.3" {
+		t.Fatalf("unexpected lease: %+v", leases[0])
+	}
+	if leases[1].HWAddress.String() != "0a:00:27:00:00:01" {
+		t.Fatalf("unexpected hardware address: %s", leases[1].HWAddress)
+	}
+}
+
+func TestParseLeasesInvalid(t *testing.T) {
+	for _, db := range []string{"{\n\tname=foo\n", "name=foo\n", "}\n", "{\n\tip_address=foo\n}\n"} {
+		if _, err := ParseLeases(strings.NewReader(db)); err == nil {
+			t.Fatalf("expected error when parsing %q", db)
+		}
+	}
+}
+
+func TestFindLease(t *testing.T) {
+	leasesPath := filepath.Join(t.TempDir(), "dhcpd_leases")
+	if err := os.WriteFile(leasesPath, []byte(leasesDB), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	mac, _ := net.ParseMAC("52:54:00:70:2b:71")
+	lease, err := FindLease(leasesPath, mac)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if lease.IPAddress.String() != "192.168.64.3" {
+		t.Fatalf("expected IP address 192.168.64.3, got %s", lease.IPAddress)
+	}
+
+	mac, _ = net.ParseMAC("52:54:00:00:00:01")
+	if _, err := FindLease(leasesPath, mac); err == nil {
+		t.Fatal("expected error for unknown MAC address")
+	}
+}
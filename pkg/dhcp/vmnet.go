@@ -0,0 +1,112 @@
+package dhcp
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+)
+
+// vmnetPreferences is the macOS preferences domain holding the address of the
+// NAT network shared by all the virtual machines of the host.
+const vmnetPreferences = "/Library/Preferences/SystemConfiguration/com.apple.vmnet"
+
+const (
+	defaultNATAddress = "192.168.64.1"
+	defaultNATMask    = "255.255.255.0"
+)
+
+// NATSubnet returns the subnet of the macOS NAT network, as configured by the
+// Shared_Net_Address and Shared_Net_Mask vmnet preferences.
+func NATSubnet() (*net.IPNet, error) {
+	address, err := readVmnetPreference("Shared_Net_Address")
+	if err != nil {
+		return nil, err
+	}
+	mask, err := readVmnetPreference("Shared_Net_Mask")
+	if err != nil {
+		return nil, err
+	}
+
+	return natSubnet(address, mask)
+}
+
+// NATHostAddress returns the address of the host on the macOS NAT network,
+// which guests can use to reach the services running on the host.
+func NATHostAddress() (net.IP, error) {
+	address, err := readVmnetPreference("Shared_Net_Address")
+	if err != nil {
+		return nil, err
+	}
+	if address == "" {
+		address = defaultNATAddress
+	}
+	ip := net.ParseIP(address).To4()
+	if ip == nil {
+		return nil, fmt.Errorf("invalid NAT network address: %s", address)
+	}
+
+	return ip, nil
+}
+
+// readVmnetPreference returns the value of key in the vmnet preferences, or
+// an empty string when it's not set.
+func readVmnetPreference(key string) (string, error) {
+	out, err := exec.Command("defaults", "read", vmnetPreferences, key).Output()
+	if err != nil {
+		if _, isExitError := err.(*exec.ExitError); isExitError {
+			// 'defaults read' fails when the key does not exist
+			return "", nil
+		}
+		return "", err
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// natSubnet returns the subnet of the NAT network from the address of the
+// host on this network and its netmask, empty strings stand for the macOS
+// defaults.
+func natSubnet(address string, mask string) (*net.IPNet, error) {
+	if address == "" {
+		address = defaultNATAddress
+	}
+	if mask == "" {
+		mask = defaultNATMask
+	}
+	ip := net.ParseIP(address).To4()
+	if ip == nil {
+		return nil, fmt.Errorf("invalid NAT network address: %s", address)
+	}
+	maskIP := net.ParseIP(mask).To4()
+	if maskIP == nil {
+		return nil, fmt.Errorf("invalid NAT network mask: %s", mask)
+	}
+	ipMask := net.IPMask(maskIP)
+	if ones, bits := ipMask.Size(); ones == 0 && bits == 0 {
+		return nil, fmt.Errorf("invalid NAT network mask: %s", mask)
+	}
+
+	return &net.IPNet{IP: ip.Mask(ipMask), Mask: ipMask}, nil
+}
+
+// CheckNATSubnet verifies that the macOS NAT network uses subnet. It's a host
+// wide setting which vfkit does not change, so the error gives the commands
+// to change it.
+func CheckNATSubnet(subnet *net.IPNet) error {
+	current, err := NATSubnet()
+	if err != nil {
+		return err
+	}
+	if current.String() == subnet.String() {
+		return nil
+	}
+	hostIP := make(net.IP, len(subnet.IP))
+	copy(hostIP, subnet.IP)
+	hostIP[len(hostIP)-1]++
+
+	return fmt.Errorf("the macOS NAT network uses %s, not %s. It's shared by all the virtual machines of the host, change it with "+
+		"'sudo defaults write %s Shared_Net_Address -string %s' and 'sudo defaults write %s Shared_Net_Mask -string %s' "+
+		"while no virtual machine is running",
+		current, subnet, vmnetPreferences, hostIP, vmnetPreferences, net.IP(subnet.Mask))
+}
@@ -0,0 +1,208 @@
+// Package diskimage converts the disk image formats which are common for cloud
+// images, qcow2 and vmdk, to the raw format which is the only one supported by
+// the virtualization framework.
+//
+// Converted images are sparse: unallocated and zero-filled parts of the
+// source image do not use disk space in the raw image.
+package diskimage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Format is the format of a disk image.
+type Format string
+
+const (
+	FormatRaw   Format = "raw"
+	FormatQcow2 Format = "qcow2"
+	FormatVMDK  Format = "vmdk"
+)
+
+// sparseImage is a disk image made of fixed-size clusters which can be read
+// independently.
+type sparseImage interface {
+	// size is the size of the disk seen by the guest, in bytes
+	size() uint64
+	clusterSize() uint64
+	// readCluster reads the index-th cluster into buf, which is
+	// clusterSize() bytes long. It returns false when the cluster is not
+	// allocated, buf is then left untouched.
+	readCluster(index uint64, buf []byte) (bool, error)
+}
+
+// Detect returns the format of the disk image at path. Images which are
+// neither qcow2 nor vmdk are assumed to be raw.
+func Detect(path string) (Format, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(file, magic); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return FormatRaw, nil
+		}
+		return "", err
+	}
+	switch {
+	case bytes.Equal(magic, qcow2Magic):
+		return FormatQcow2, nil
+	case bytes.Equal(magic, vmdkMagic):
+		return FormatVMDK, nil
+	case bytes.Equal(magic, []byte(vmdkDescriptorMagic[:4])):
+		// a text descriptor, the data is in separate extent files
+		header := make([]byte, len(vmdkDescriptorMagic))
+		if _, err := file.ReadAt(header, 0); err == nil && string(header) == vmdkDescriptorMagic {
+			return FormatVMDK, nil
+		}
+	}
+
+	return FormatRaw, nil
+}
+
+func openSparseImage(file *os.File, format Format) (sparseImage, error) {
+	switch format {
+	case FormatQcow2:
+		return openQcow2(file)
+	case FormatVMDK:
+		return openVMDK(file)
+	case FormatRaw:
+		return openRaw(file)
+	}
+	return nil, fmt.Errorf("unsupported disk image format: %s", format)
+}
+
+// Convert converts the qcow2 or vmdk image at src to a raw image at dst.
+// dst is only created once the conversion succeeded. Raw images are copied,
+// which makes the zero-filled parts of src sparse in dst. src and dst can be
+// the same file.
+func Convert(src string, dst string) error {
+	format, err := Detect(src)
+	if err != nil {
+		return err
+	}
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+	image, err := openSparseImage(srcFile, format)
+	if err != nil {
+		return fmt.Errorf("%s: %w", src, err)
+	}
+
+	tmpPath := dst + ".tmp"
+	dstFile, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+	defer dstFile.Close()
+
+	if err := dstFile.Truncate(int64(image.size())); err != nil {
+		return err
+	}
+	clusterSize := image.clusterSize()
+	buf := make([]byte, clusterSize)
+	zeros := make([]byte, clusterSize)
+	for index := uint64(0); index*clusterSize < image.size(); index++ {
+		allocated, err := image.readCluster(index, buf)
+		if err != nil {
+			return fmt.Errorf("%s: %w", src, err)
+		}
+		// zeros are not written to keep the raw image sparse
+		if !allocated || bytes.Equal(buf, zeros) {
+			continue
+		}
+		data := buf
+		if remaining := image.size() - index*clusterSize; remaining < clusterSize {
+			data = buf[:remaining]
+		}
+		if _, err := dstFile.WriteAt(data, int64(index*clusterSize)); err != nil {
+			return err
+		}
+	}
+	if err := dstFile.Sync(); err != nil {
+		return err
+	}
+	if err := dstFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, dst)
+}
+
+// conversionSource identifies the source of a converted image, it's stored
+// next to the raw image to know when it must be converted again.
+type conversionSource struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+func (s conversionSource) equal(other conversionSource) bool {
+	return s.Path == other.Path && s.Size == other.Size && s.ModTime.Equal(other.ModTime)
+}
+
+// ConvertCached returns the path of a raw version of the disk image at src.
+// Raw images are returned unchanged. Other images are converted to dst, unless
+// dst was already converted from the same version of src: the guest writes to
+// dst, which must be kept across runs. If src was modified since dst was
+// converted, an error is returned rather than overwriting the changes made by
+// the guest, dst must be deleted to convert src again.
+func ConvertCached(src string, dst string) (string, error) {
+	format, err := Detect(src)
+	if err != nil {
+		return "", err
+	}
+	if format == FormatRaw {
+		return src, nil
+	}
+
+	absSrc, err := filepath.Abs(src)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(absSrc)
+	if err != nil {
+		return "", err
+	}
+	source := conversionSource{Path: absSrc, Size: info.Size(), ModTime: info.ModTime().UTC()}
+	sourcePath := dst + ".source"
+	if _, err := os.Stat(dst); err == nil {
+		var cached conversionSource
+		if data, err := os.ReadFile(sourcePath); err == nil && json.Unmarshal(data, &cached) == nil && cached.equal(source) {
+			log.Debugf("using raw image %s converted from %s", dst, src)
+			return dst, nil
+		}
+		return "", fmt.Errorf("%s was modified after it was converted to %s, which holds the changes made by the guest: delete %s to convert it again", src, dst, dst)
+	}
+
+	log.Infof("converting %s image %s to raw image %s", format, src, dst)
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		return "", err
+	}
+	if err := Convert(src, dst); err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(source)
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(sourcePath, data, 0600); err != nil {
+		return "", err
+	}
+
+	return dst, nil
+}
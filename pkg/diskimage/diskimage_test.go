@@ -0,0 +1,268 @@
+package diskimage
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testClusterSize = 64 * 1024
+
+// testDiskContent is the content of the disk of the test images, the second
+// and fourth clusters are not allocated.
+func testDiskContent() []byte {
+	content := make([]byte, 4*testClusterSize)
+	copy(content, "first cluster")
+	copy(content[2*testClusterSize:], bytes.Repeat([]byte("compressed cluster"), 1000))
+	return content
+}
+
+func writeAt(t *testing.T, file *os.File, offset int64, data interface{}, order binary.ByteOrder) {
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, order, data); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteAt(buf.Bytes(), offset); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeQcow2(t *testing.T, path string, content []byte) {
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	// header, L1 table, L2 table, then the clusters
+	header := qcow2Header{
+		Version:       3,
+		ClusterBits:   16,
+		Size:          uint64(len(content)),
+		L1Size:        1,
+		L1TableOffset: testClusterSize,
+		HeaderLength:  104,
+	}
+	copy(header.Magic[:], qcow2Magic)
+	writeAt(t, file, 0, header, binary.BigEndian)
+	writeAt(t, file, testClusterSize, uint64(2*testClusterSize), binary.BigEndian)
+
+	if _, err := file.WriteAt(content[:testClusterSize], 3*testClusterSize); err != nil {
+		t.Fatal(err)
+	}
+	var compressed bytes.Buffer
+	writer, _ := flate.NewWriter(&compressed, flate.BestCompression)
+	if _, err := writer.Write(content[2*testClusterSize : 3*testClusterSize]); err != nil {
+		t.Fatal(err)
+	}
+	writer.Close()
+	compressedOffset := uint64(4*testClusterSize + 100)
+	if _, err := file.WriteAt(compressed.Bytes(), int64(compressedOffset)); err != nil {
+		t.Fatal(err)
+	}
+	additionalSectors := uint64((100 + compressed.Len() - 1) / 512)
+	l2Table := []uint64{
+		3 * testClusterSize,
+		0,
+		qcow2CompressedFlag | additionalSectors<<54 | compressedOffset,
+		qcow2ZeroFlag,
+	}
+	writeAt(t, file, 2*testClusterSize, l2Table, binary.BigEndian)
+}
+
+func writeStreamOptimizedVMDK(t *testing.T, path string, content []byte) {
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	// header, grains, grain table, grain directory, footer, end-of-stream
+	// marker
+	header := vmdkHeader{
+		Version:           3,
+		Flags:             vmdkFlagZeroGrains | vmdkFlagCompressed | 1<<17,
+		Capacity:          uint64(len(content)) / vmdkSectorSize,
+		GrainSize:         testClusterSize / vmdkSectorSize,
+		NumGTEsPerGT:      512,
+		GDOffset:          vmdkGDAtEnd,
+		CompressAlgorithm: vmdkCompressionDeflate,
+	}
+	copy(header.Magic[:], vmdkMagic)
+	writeAt(t, file, 0, header, binary.LittleEndian)
+
+	grainTable := make([]uint32, header.NumGTEsPerGT)
+	sector := uint32(2)
+	for _, index := range []int{0, 2} {
+		var compressed bytes.Buffer
+		writer := zlib.NewWriter(&compressed)
+		if _, err := writer.Write(content[index*testClusterSize : (index+1)*testClusterSize]); err != nil {
+			t.Fatal(err)
+		}
+		writer.Close()
+		grainTable[index] = sector
+		writeAt(t, file, int64(sector)*vmdkSectorSize, []uint64{uint64(index) * uint64(header.GrainSize)}, binary.LittleEndian)
+		writeAt(t, file, int64(sector)*vmdkSectorSize+8, uint32(compressed.Len()), binary.LittleEndian)
+		if _, err := file.WriteAt(compressed.Bytes(), int64(sector)*vmdkSectorSize+12); err != nil {
+			t.Fatal(err)
+		}
+		sector += uint32((12+compressed.Len())/vmdkSectorSize + 1)
+	}
+	grainTable[3] = 1
+	gtSector := sector
+	writeAt(t, file, int64(gtSector)*vmdkSectorSize, grainTable, binary.LittleEndian)
+	gdSector := gtSector + 4
+	writeAt(t, file, int64(gdSector)*vmdkSectorSize, []uint32{gtSector}, binary.LittleEndian)
+
+	footer := header
+	footer.GDOffset = uint64(gdSector)
+	writeAt(t, file, int64(gdSector+2)*vmdkSectorSize, footer, binary.LittleEndian)
+	if err := file.Truncate(int64(gdSector+4) * vmdkSectorSize); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	content := testDiskContent()
+	dir := t.TempDir()
+	qcow2Path := filepath.Join(dir, "disk.qcow2")
+	writeQcow2(t, qcow2Path, content)
+	vmdkPath := filepath.Join(dir, "disk.vmdk")
+	writeStreamOptimizedVMDK(t, vmdkPath, content)
+	rawPath := filepath.Join(dir, "disk.img")
+	if err := os.WriteFile(rawPath, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		path   string
+		format Format
+	}{
+		{qcow2Path, FormatQcow2},
+		{vmdkPath, FormatVMDK},
+		{rawPath, FormatRaw},
+	} {
+		format, err := Detect(test.path)
+		if err != nil {
+			t.Fatal("expected no error; got", err)
+		}
+		if format != test.format {
+			t.Fatalf("expected format %s for %s, got %s", test.format, test.path, format)
+		}
+		converted := test.path + ".raw"
+		if err := Convert(test.path, converted); err != nil {
+			t.Fatal("expected no error; got", err)
+		}
+		data, err := os.ReadFile(converted)
+		if err != nil {
+			t.Fatal("expected no error; got", err)
+		}
+		if !bytes.Equal(data, content) {
+			t.Fatalf("unexpected content after converting %s image", format)
+		}
+	}
+
+	// raw images can be compacted in place
+	if err := Convert(rawPath, rawPath); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if data, err := os.ReadFile(rawPath); err != nil || !bytes.Equal(data, content) {
+		t.Fatalf("unexpected content after compacting raw image: %v", err)
+	}
+}
+
+func TestConvertCached(t *testing.T) {
+	content := testDiskContent()
+	dir := t.TempDir()
+	qcow2Path := filepath.Join(dir, "disk.qcow2")
+	writeQcow2(t, qcow2Path, content)
+	rawPath := filepath.Join(dir, "cache", "disk.raw")
+
+	path, err := ConvertCached(qcow2Path, rawPath)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if path != rawPath {
+		t.Fatalf("unexpected converted image path: %s", path)
+	}
+	// guest writes must be kept as long as the source image is unchanged
+	if err := os.WriteFile(rawPath, []byte("guest data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ConvertCached(qcow2Path, rawPath); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if data, _ := os.ReadFile(rawPath); string(data) != "guest data" {
+		t.Fatal("converted image was unexpectedly overwritten")
+	}
+	// a modified source image must not silently replace the guest writes
+	modTime := time.Now().Add(time.Hour)
+	if err := os.Chtimes(qcow2Path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ConvertCached(qcow2Path, rawPath); err == nil {
+		t.Fatal("expected error for a source image modified after its conversion")
+	}
+	if data, _ := os.ReadFile(rawPath); string(data) != "guest data" {
+		t.Fatal("converted image was unexpectedly overwritten")
+	}
+	if err := os.Remove(rawPath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ConvertCached(qcow2Path, rawPath); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+
+	path, err = ConvertCached(rawPath, filepath.Join(dir, "unused.raw"))
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if path != rawPath {
+		t.Fatalf("raw image path should be unchanged, got %s", path)
+	}
+}
+
+func TestConvertBackingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "disk.qcow2")
+	writeQcow2(t, path, testDiskContent())
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeAt(t, file, 8, uint64(512), binary.BigEndian)
+	file.Close()
+
+	if err := Convert(path, path+".raw"); err == nil {
+		t.Fatal("expected error for qcow2 image with a backing file")
+	}
+}
+
+func TestCopyInto(t *testing.T) {
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "src.raw")
+	// the last cluster is incomplete
+	content := append(testDiskContent(), "tail"...)
+	if err := os.WriteFile(srcPath, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	dstPath := filepath.Join(dir, "dst.raw")
+	if err := os.WriteFile(dstPath, bytes.Repeat([]byte("x"), 8*testClusterSize), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := CopyInto(srcPath, dstPath); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	data, err := os.ReadFile(dstPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Fatal("copied image content does not match the source image")
+	}
+}
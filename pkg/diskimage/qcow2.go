@@ -0,0 +1,171 @@
+package diskimage
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// The qcow2 format is described in
+// https://gitlab.com/qemu-project/qemu/-/blob/master/docs/interop/qcow2.txt
+
+var qcow2Magic = []byte{'Q', 'F', 'I', 0xfb}
+
+const (
+	qcow2OffsetMask     = 0x00fffffffffffe00
+	qcow2CompressedFlag = 1 << 62
+	qcow2ZeroFlag       = 1
+
+	qcow2IncompatDirty        = 1 << 0
+	qcow2IncompatCompressType = 1 << 3
+)
+
+// qcow2Header contains the fields of the qcow2 header which are needed to
+// read the image. The version 3 fields are zero for version 2 images.
+type qcow2Header struct {
+	Magic                 [4]byte
+	Version               uint32
+	BackingFileOffset     uint64
+	BackingFileSize       uint32
+	ClusterBits           uint32
+	Size                  uint64
+	CryptMethod           uint32
+	L1Size                uint32
+	L1TableOffset         uint64
+	RefcountTableOffset   uint64
+	RefcountTableClusters uint32
+	NbSnapshots           uint32
+	SnapshotsOffset       uint64
+	IncompatibleFeatures  uint64
+	CompatibleFeatures    uint64
+	AutoclearFeatures     uint64
+	RefcountOrder         uint32
+	HeaderLength          uint32
+	CompressionType       uint8
+}
+
+type qcow2Image struct {
+	file    *os.File
+	header  qcow2Header
+	l1Table []uint64
+	// l2Cache is the last L2 table which was read, clusters are read in
+	// order so they often use the same L2 table
+	l2Cache       []uint64
+	l2CacheOffset uint64
+}
+
+func openQcow2(file *os.File) (*qcow2Image, error) {
+	image := &qcow2Image{file: file}
+	header := &image.header
+	if err := binary.Read(io.NewSectionReader(file, 0, 105), binary.BigEndian, header); err != nil {
+		return nil, fmt.Errorf("failed to read qcow2 header: %w", err)
+	}
+	switch header.Version {
+	case 2:
+		header.IncompatibleFeatures = 0
+		header.CompressionType = 0
+	case 3:
+		if header.HeaderLength <= 104 {
+			header.CompressionType = 0
+		}
+	default:
+		return nil, fmt.Errorf("unsupported qcow2 version %d", header.Version)
+	}
+	if header.BackingFileOffset != 0 {
+		return nil, fmt.Errorf("qcow2 images with a backing file are not supported")
+	}
+	if header.CryptMethod != 0 {
+		return nil, fmt.Errorf("encrypted qcow2 images are not supported")
+	}
+	if header.IncompatibleFeatures&^(qcow2IncompatDirty|qcow2IncompatCompressType) != 0 {
+		return nil, fmt.Errorf("unsupported qcow2 incompatible features: %#x", header.IncompatibleFeatures)
+	}
+	if header.CompressionType != 0 {
+		return nil, fmt.Errorf("only zlib compression is supported for qcow2 images")
+	}
+	if header.ClusterBits < 9 || header.ClusterBits > 21 {
+		return nil, fmt.Errorf("invalid qcow2 cluster size: 2^%d", header.ClusterBits)
+	}
+
+	image.l1Table = make([]uint64, header.L1Size)
+	l1Reader := io.NewSectionReader(file, int64(header.L1TableOffset), int64(header.L1Size)*8)
+	if err := binary.Read(l1Reader, binary.BigEndian, image.l1Table); err != nil {
+		return nil, fmt.Errorf("failed to read qcow2 L1 table: %w", err)
+	}
+
+	return image, nil
+}
+
+func (image *qcow2Image) size() uint64 {
+	return image.header.Size
+}
+
+func (image *qcow2Image) clusterSize() uint64 {
+	return 1 << image.header.ClusterBits
+}
+
+func (image *qcow2Image) l2Table(offset uint64) ([]uint64, error) {
+	if image.l2Cache != nil && image.l2CacheOffset == offset {
+		return image.l2Cache, nil
+	}
+	table := make([]uint64, image.clusterSize()/8)
+	if err := binary.Read(io.NewSectionReader(image.file, int64(offset), int64(image.clusterSize())), binary.BigEndian, table); err != nil {
+		return nil, fmt.Errorf("failed to read qcow2 L2 table: %w", err)
+	}
+	image.l2Cache = table
+	image.l2CacheOffset = offset
+
+	return table, nil
+}
+
+func (image *qcow2Image) readCluster(index uint64, buf []byte) (bool, error) {
+	l2Entries := image.clusterSize() / 8
+	l1Index := index / l2Entries
+	if l1Index >= uint64(len(image.l1Table)) {
+		return false, nil
+	}
+	l2Offset := image.l1Table[l1Index] & qcow2OffsetMask
+	if l2Offset == 0 {
+		return false, nil
+	}
+	l2Table, err := image.l2Table(l2Offset)
+	if err != nil {
+		return false, err
+	}
+	entry := l2Table[index%l2Entries]
+
+	if entry&qcow2CompressedFlag != 0 {
+		return true, image.readCompressedCluster(entry, buf)
+	}
+	offset := entry & qcow2OffsetMask
+	if offset == 0 || entry&qcow2ZeroFlag != 0 {
+		return false, nil
+	}
+	if _, err := image.file.ReadAt(buf, int64(offset)); err != nil {
+		return false, fmt.Errorf("failed to read qcow2 cluster %d: %w", index, err)
+	}
+
+	return true, nil
+}
+
+func (image *qcow2Image) readCompressedCluster(entry uint64, buf []byte) error {
+	offsetBits := 62 - (image.header.ClusterBits - 8)
+	offset := entry & (1<<offsetBits - 1)
+	sectors := (entry>>offsetBits)&(1<<(image.header.ClusterBits-8)-1) + 1
+	compressed := make([]byte, sectors*512-offset%512)
+	n, err := image.file.ReadAt(compressed, int64(offset))
+	// the last compressed cluster can end before the end of its last sector
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("failed to read qcow2 compressed cluster: %w", err)
+	}
+	reader := flate.NewReader(bytes.NewReader(compressed[:n]))
+	defer reader.Close()
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		return fmt.Errorf("failed to decompress qcow2 cluster: %w", err)
+	}
+
+	return nil
+}
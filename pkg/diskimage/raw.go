@@ -0,0 +1,98 @@
+package diskimage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// rawClusterSize is the granularity used to find the zero-filled parts of raw
+// images.
+const rawClusterSize = 64 * 1024
+
+// rawImage reads raw images, so that they can be copied to a sparse image.
+type rawImage struct {
+	file     *os.File
+	diskSize uint64
+}
+
+func openRaw(file *os.File) (*rawImage, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return &rawImage{file: file, diskSize: uint64(info.Size())}, nil
+}
+
+func (img *rawImage) size() uint64 {
+	return img.diskSize
+}
+
+func (img *rawImage) clusterSize() uint64 {
+	return rawClusterSize
+}
+
+func (img *rawImage) readCluster(index uint64, buf []byte) (bool, error) {
+	n, err := img.file.ReadAt(buf, int64(index*rawClusterSize))
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	// the last cluster can be partial
+	for i := n; i < len(buf); i++ {
+		buf[i] = 0
+	}
+	return true, nil
+}
+
+// CopyInto copies the raw image at src into the existing file at dst. Unlike
+// Convert, dst keeps its inode, so a virtual machine which has it open sees
+// the new content. dst is resized to the size of src, and the zero-filled
+// parts of src are sparse in dst.
+func CopyInto(src string, dst string) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+	image, err := openRaw(srcFile)
+	if err != nil {
+		return err
+	}
+
+	dstFile, err := os.OpenFile(dst, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer dstFile.Close()
+	// truncating to 0 first deallocates all the blocks of dst
+	if err := dstFile.Truncate(0); err != nil {
+		return err
+	}
+	if err := dstFile.Truncate(int64(image.size())); err != nil {
+		return err
+	}
+
+	buf := make([]byte, rawClusterSize)
+	zeros := make([]byte, rawClusterSize)
+	for index := uint64(0); index*rawClusterSize < image.size(); index++ {
+		if _, err := image.readCluster(index, buf); err != nil {
+			return fmt.Errorf("%s: %w", src, err)
+		}
+		if bytes.Equal(buf, zeros) {
+			continue
+		}
+		data := buf
+		if remaining := image.size() - index*rawClusterSize; remaining < rawClusterSize {
+			data = buf[:remaining]
+		}
+		if _, err := dstFile.WriteAt(data, int64(index*rawClusterSize)); err != nil {
+			return err
+		}
+	}
+	if err := dstFile.Sync(); err != nil {
+		return err
+	}
+
+	return dstFile.Close()
+}
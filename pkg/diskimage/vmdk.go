@@ -0,0 +1,196 @@
+package diskimage
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// The vmdk hosted sparse extent format is described in the "Virtual Disk
+// Format 5.0" specification from VMware. Only images with a single sparse
+// extent embedded in the same file, such as monolithicSparse and
+// streamOptimized images, are supported.
+
+var vmdkMagic = []byte{'K', 'D', 'M', 'V'}
+
+const vmdkDescriptorMagic = "# Disk DescriptorFile"
+
+const (
+	vmdkSectorSize = 512
+	vmdkGDAtEnd    = 0xffffffffffffffff
+
+	vmdkFlagZeroGrains = 1 << 2
+	vmdkFlagCompressed = 1 << 16
+
+	vmdkCompressionDeflate = 1
+)
+
+// vmdkHeader is the header of a hosted sparse extent. Offsets and sizes are
+// in sectors.
+type vmdkHeader struct {
+	Magic              [4]byte
+	Version            uint32
+	Flags              uint32
+	Capacity           uint64
+	GrainSize          uint64
+	DescriptorOffset   uint64
+	DescriptorSize     uint64
+	NumGTEsPerGT       uint32
+	RGDOffset          uint64
+	GDOffset           uint64
+	OverHead           uint64
+	UncleanShutdown    uint8
+	SingleEndLineChar  byte
+	NonEndLineChar     byte
+	DoubleEndLineChar1 byte
+	DoubleEndLineChar2 byte
+	CompressAlgorithm  uint16
+}
+
+type vmdkImage struct {
+	file           *os.File
+	header         vmdkHeader
+	grainDirectory []uint32
+	// gtCache is the last grain table which was read
+	gtCache       []uint32
+	gtCacheOffset uint32
+}
+
+func readVMDKHeader(file *os.File, offset int64) (*vmdkHeader, error) {
+	var header vmdkHeader
+	if err := binary.Read(io.NewSectionReader(file, offset, vmdkSectorSize), binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("failed to read vmdk header: %w", err)
+	}
+	if !bytes.Equal(header.Magic[:], vmdkMagic) {
+		return nil, fmt.Errorf("invalid vmdk header magic")
+	}
+
+	return &header, nil
+}
+
+func openVMDK(file *os.File) (*vmdkImage, error) {
+	magic := make([]byte, len(vmdkDescriptorMagic))
+	if _, err := file.ReadAt(magic, 0); err == nil && string(magic) == vmdkDescriptorMagic {
+		return nil, fmt.Errorf("vmdk images with separate extent files are not supported")
+	}
+	header, err := readVMDKHeader(file, 0)
+	if err != nil {
+		return nil, err
+	}
+	if header.GDOffset == vmdkGDAtEnd {
+		// streamOptimized images written in a single pass have the
+		// actual header in a footer, before the end-of-stream marker
+		info, err := file.Stat()
+		if err != nil {
+			return nil, err
+		}
+		if header, err = readVMDKHeader(file, info.Size()-2*vmdkSectorSize); err != nil {
+			return nil, fmt.Errorf("failed to read vmdk footer: %w", err)
+		}
+	}
+	if header.Version < 1 || header.Version > 3 {
+		return nil, fmt.Errorf("unsupported vmdk version %d", header.Version)
+	}
+	if header.Flags&vmdkFlagCompressed != 0 && header.CompressAlgorithm != vmdkCompressionDeflate {
+		return nil, fmt.Errorf("unsupported vmdk compression algorithm %d", header.CompressAlgorithm)
+	}
+	if header.GrainSize == 0 || header.GrainSize > 2048 || header.NumGTEsPerGT == 0 {
+		return nil, fmt.Errorf("invalid vmdk grain size")
+	}
+
+	grainTableCoverage := header.GrainSize * uint64(header.NumGTEsPerGT)
+	gdEntries := (header.Capacity + grainTableCoverage - 1) / grainTableCoverage
+	image := &vmdkImage{
+		file:           file,
+		header:         *header,
+		grainDirectory: make([]uint32, gdEntries),
+	}
+	gdReader := io.NewSectionReader(file, int64(header.GDOffset*vmdkSectorSize), int64(gdEntries*4))
+	if err := binary.Read(gdReader, binary.LittleEndian, image.grainDirectory); err != nil {
+		return nil, fmt.Errorf("failed to read vmdk grain directory: %w", err)
+	}
+
+	return image, nil
+}
+
+func (image *vmdkImage) size() uint64 {
+	return image.header.Capacity * vmdkSectorSize
+}
+
+func (image *vmdkImage) clusterSize() uint64 {
+	return image.header.GrainSize * vmdkSectorSize
+}
+
+func (image *vmdkImage) grainTable(offset uint32) ([]uint32, error) {
+	if image.gtCache != nil && image.gtCacheOffset == offset {
+		return image.gtCache, nil
+	}
+	table := make([]uint32, image.header.NumGTEsPerGT)
+	gtReader := io.NewSectionReader(image.file, int64(offset)*vmdkSectorSize, int64(len(table))*4)
+	if err := binary.Read(gtReader, binary.LittleEndian, table); err != nil {
+		return nil, fmt.Errorf("failed to read vmdk grain table: %w", err)
+	}
+	image.gtCache = table
+	image.gtCacheOffset = offset
+
+	return table, nil
+}
+
+func (image *vmdkImage) readCluster(index uint64, buf []byte) (bool, error) {
+	gtIndex := index / uint64(image.header.NumGTEsPerGT)
+	if gtIndex >= uint64(len(image.grainDirectory)) || image.grainDirectory[gtIndex] == 0 {
+		return false, nil
+	}
+	grainTable, err := image.grainTable(image.grainDirectory[gtIndex])
+	if err != nil {
+		return false, err
+	}
+	grainOffset := grainTable[index%uint64(image.header.NumGTEsPerGT)]
+	if grainOffset == 0 {
+		return false, nil
+	}
+	if grainOffset == 1 && image.header.Flags&vmdkFlagZeroGrains != 0 {
+		// a grain filled with zeros
+		return false, nil
+	}
+
+	if image.header.Flags&vmdkFlagCompressed == 0 {
+		if _, err := image.file.ReadAt(buf, int64(grainOffset)*vmdkSectorSize); err != nil {
+			return false, fmt.Errorf("failed to read vmdk grain %d: %w", index, err)
+		}
+		return true, nil
+	}
+
+	return true, image.readCompressedGrain(int64(grainOffset)*vmdkSectorSize, buf)
+}
+
+func (image *vmdkImage) readCompressedGrain(offset int64, buf []byte) error {
+	// compressed grains start with their LBA and their compressed size
+	marker := make([]byte, 12)
+	if _, err := image.file.ReadAt(marker, offset); err != nil {
+		return fmt.Errorf("failed to read vmdk grain marker: %w", err)
+	}
+	compressedSize := binary.LittleEndian.Uint32(marker[8:])
+	reader, err := zlib.NewReader(io.NewSectionReader(image.file, offset+12, int64(compressedSize)))
+	if err != nil {
+		return fmt.Errorf("failed to decompress vmdk grain: %w", err)
+	}
+	defer reader.Close()
+	n, err := io.ReadFull(reader, buf)
+	// the last grain is shorter when the capacity is not a multiple of the
+	// grain size
+	if err == io.ErrUnexpectedEOF {
+		for i := n; i < len(buf); i++ {
+			buf[i] = 0
+		}
+		err = nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to decompress vmdk grain: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,241 @@
+// Package egress implements allow/deny rules for the network traffic sent by
+// a virtual machine to the outside world.
+//
+// A Policy is an ordered list of rules, the first rule matching a packet
+// decides if it's allowed, and packets which don't match any rule use the
+// default action.
+package egress
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Action is what happens to packets matching a rule.
+type Action string
+
+const (
+	ActionAllow Action = "allow"
+	ActionDeny  Action = "deny"
+)
+
+func parseAction(str string) (Action, error) {
+	switch Action(str) {
+	case ActionAllow, ActionDeny:
+		return Action(str), nil
+	}
+	return "", fmt.Errorf("invalid egress action '%s', must be 'allow' or 'deny'", str)
+}
+
+// Rule matches packets sent to a network, and optionally to a port range and
+// protocol.
+type Rule struct {
+	Action  Action
+	Network *net.IPNet
+	// PortMin and PortMax are 0 when the rule applies to all ports
+	PortMin uint16
+	PortMax uint16
+	// Protocol is "tcp", "udp", or empty to match both
+	Protocol string
+}
+
+// ParseRule parses a rule in the format used on the command line:
+// allow|deny,cidr=10.0.0.0/8[,port=443|port=8000-8999][,proto=tcp|udp]
+func ParseRule(str string) (*Rule, error) {
+	fields := strings.Split(str, ",")
+	action, err := parseAction(fields[0])
+	if err != nil {
+		return nil, err
+	}
+	rule := &Rule{Action: action}
+	for _, field := range fields[1:] {
+		split := strings.SplitN(field, "=", 2)
+		key, value := split[0], ""
+		if len(split) == 2 {
+			value = split[1]
+		}
+		switch key {
+		case "cidr":
+			ip, network, err := net.ParseCIDR(value)
+			if err != nil {
+				// a single address
+				if ip = net.ParseIP(value); ip == nil {
+					return nil, fmt.Errorf("invalid egress rule network: %s", value)
+				}
+				bits := 8 * net.IPv6len
+				if ip.To4() != nil {
+					ip = ip.To4()
+					bits = 8 * net.IPv4len
+				}
+				network = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+			}
+			// the rules apply to the IPv4 address of the guest on the
+			// NAT network
+			if network.IP.To4() == nil {
+				return nil, fmt.Errorf("invalid egress rule network: %s, only IPv4 networks are supported", value)
+			}
+			rule.Network = network
+		case "port":
+			if rule.PortMin, rule.PortMax, err = parsePortRange(value); err != nil {
+				return nil, err
+			}
+		case "proto":
+			if value != "tcp" && value != "udp" {
+				return nil, fmt.Errorf("invalid egress rule protocol '%s', must be 'tcp' or 'udp'", value)
+			}
+			rule.Protocol = value
+		default:
+			return nil, fmt.Errorf("Unknown option for egress rules: %s", key)
+		}
+	}
+	if rule.Network == nil {
+		return nil, fmt.Errorf("missing mandatory 'cidr' option for egress rule")
+	}
+	if rule.PortMin != 0 && rule.Protocol == "" {
+		return nil, fmt.Errorf("egress rules with a port must set 'proto'")
+	}
+
+	return rule, nil
+}
+
+func parsePortRange(str string) (uint16, uint16, error) {
+	bounds := strings.SplitN(str, "-", 2)
+	minStr, maxStr := bounds[0], bounds[0]
+	if len(bounds) == 2 {
+		maxStr = bounds[1]
+	}
+	portMin, err := strconv.ParseUint(minStr, 10, 16)
+	if err != nil || portMin == 0 {
+		return 0, 0, fmt.Errorf("invalid egress rule port: %s", str)
+	}
+	portMax, err := strconv.ParseUint(maxStr, 10, 16)
+	if err != nil || portMax < portMin {
+		return 0, 0, fmt.Errorf("invalid egress rule port: %s", str)
+	}
+
+	return uint16(portMin), uint16(portMax), nil
+}
+
+// String returns the rule in the format accepted by ParseRule.
+func (rule *Rule) String() string {
+	str := fmt.Sprintf("%s,cidr=%s", rule.Action, rule.Network)
+	if rule.PortMin != 0 {
+		str += ",port=" + strconv.Itoa(int(rule.PortMin))
+		if rule.PortMax != rule.PortMin {
+			str += "-" + strconv.Itoa(int(rule.PortMax))
+		}
+	}
+	if rule.Protocol != "" {
+		str += ",proto=" + rule.Protocol
+	}
+
+	return str
+}
+
+// Matches returns true if a packet sent to ip and port with protocol proto
+// matches the rule.
+func (rule *Rule) Matches(ip net.IP, port uint16, proto string) bool {
+	if !rule.Network.Contains(ip) {
+		return false
+	}
+	if rule.Protocol != "" && rule.Protocol != proto {
+		return false
+	}
+	if rule.PortMin != 0 && (port < rule.PortMin || port > rule.PortMax) {
+		return false
+	}
+
+	return true
+}
+
+// Policy is the egress configuration of a virtual machine.
+type Policy struct {
+	Default Action
+	Rules   []Rule
+}
+
+// ParsePolicy parses the values of the --egress command line argument. Each
+// entry is either a rule, or 'default=allow|deny' to set the default action,
+// which is 'allow' when not specified.
+func ParsePolicy(entries []string) (*Policy, error) {
+	policy := &Policy{Default: ActionAllow}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry, "default=") {
+			action, err := parseAction(strings.TrimPrefix(entry, "default="))
+			if err != nil {
+				return nil, err
+			}
+			policy.Default = action
+			continue
+		}
+		rule, err := ParseRule(entry)
+		if err != nil {
+			return nil, err
+		}
+		policy.Rules = append(policy.Rules, *rule)
+	}
+
+	return policy, nil
+}
+
+// Strings returns the policy in the format accepted by ParsePolicy.
+func (policy *Policy) Strings() []string {
+	entries := []string{"default=" + string(policy.Default)}
+	for _, rule := range policy.Rules {
+		entries = append(entries, rule.String())
+	}
+
+	return entries
+}
+
+// Allowed returns true if the policy allows a packet sent to ip and port with
+// protocol proto.
+func (policy *Policy) Allowed(ip net.IP, port uint16, proto string) bool {
+	for _, rule := range policy.Rules {
+		if rule.Matches(ip, port, proto) {
+			return rule.Action == ActionAllow
+		}
+	}
+
+	return policy.Default == ActionAllow
+}
+
+// PFRules returns the pf rules enforcing the policy for the packets sent by
+// guestIP. pf evaluates 'quick' rules in order, and stops at the first
+// matching one, which gives the same semantics as Allowed.
+func (policy *Policy) PFRules(guestIP net.IP) string {
+	var rules strings.Builder
+	for _, rule := range policy.Rules {
+		action := "pass"
+		if rule.Action == ActionDeny {
+			action = "block drop"
+		}
+		fmt.Fprintf(&rules, "%s in quick", action)
+		if rule.Protocol != "" {
+			fmt.Fprintf(&rules, " proto %s", rule.Protocol)
+		}
+		fmt.Fprintf(&rules, " from %s to %s", guestIP, rule.Network)
+		if rule.PortMin != 0 {
+			if rule.PortMin == rule.PortMax {
+				fmt.Fprintf(&rules, " port %d", rule.PortMin)
+			} else {
+				fmt.Fprintf(&rules, " port %d:%d", rule.PortMin, rule.PortMax)
+			}
+		}
+		rules.WriteString("\n")
+	}
+	if policy.Default == ActionDeny {
+		fmt.Fprintf(&rules, "block drop in quick from %s to any\n", guestIP)
+	}
+
+	return rules.String()
+}
+
+// PendingPFRules returns the pf rules used until the guest IP address is
+// known. They drop the packets sent by the whole network to the outside, the
+// guest gets its address in this network.
+func PendingPFRules(network *net.IPNet) string {
+	return fmt.Sprintf("block drop in quick inet from %s to ! %s\n", network, network)
+}
@@ -0,0 +1,129 @@
+package egress
+
+import (
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestParsePolicy(t *testing.T) {
+	policy, err := ParsePolicy([]string{
+		"default=deny",
+		"allow,cidr=192.168.64.1,port=53,proto=udp",
+		"deny,cidr=10.0.0.0/8",
+		"allow,cidr=0.0.0.0/0,port=80-443,proto=tcp",
+	})
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+
+	for _, test := range []struct {
+		ip      string
+		port    uint16
+		proto   string
+		allowed bool
+	}{
+		{"192.168.64.1", 53, "udp", true},
+		{"192.168.64.1", 53, "tcp", false},
+		{"10.1.2.3", 443, "tcp", false},
+		{"1.1.1.1", 443, "tcp", true},
+		{"1.1.1.1", 22, "tcp", false},
+	} {
+		if allowed := policy.Allowed(net.ParseIP(test.ip), test.port, test.proto); allowed != test.allowed {
+			t.Errorf("unexpected result for %s:%d/%s: %v", test.ip, test.port, test.proto, allowed)
+		}
+	}
+
+	expected := []string{
+		"default=deny",
+		"allow,cidr=192.168.64.1/32,port=53,proto=udp",
+		"deny,cidr=10.0.0.0/8",
+		"allow,cidr=0.0.0.0/0,port=80-443,proto=tcp",
+	}
+	if fmt.Sprint(policy.Strings()) != fmt.Sprint(expected) {
+		t.Fatalf("unexpected policy strings: %v", policy.Strings())
+	}
+
+	expectedRules := `pass in quick proto udp from 192.168.64.3 to 192.168.64.1/32 port 53
+block drop in quick from 192.168.64.3 to 10.0.0.0/8
+pass in quick proto tcp from 192.168.64.3 to 0.0.0.0/0 port 80:443
+block drop in quick from 192.168.64.3 to any
+`
+	if rules := policy.PFRules(net.ParseIP("192.168.64.3")); rules != expectedRules {
+		t.Fatalf("unexpected pf rules:\n%s", rules)
+	}
+}
+
+func TestParseRuleInvalid(t *testing.T) {
+	for _, str := range []string{
+		"reject,cidr=10.0.0.0/8",
+		"deny",
+		"deny,cidr=10.0.0.0/33",
+		"deny,cidr=10.0.0.0/8,port=443",
+		"deny,cidr=10.0.0.0/8,port=443-80,proto=tcp",
+		"deny,cidr=10.0.0.0/8,proto=icmp",
+		"deny,cidr=10.0.0.0/8,foo=bar",
+		"deny,cidr=fd00::/8",
+		"allow,cidr=::1,port=53,proto=udp",
+	} {
+		if _, err := ParseRule(str); err == nil {
+			t.Errorf("expected error for '%s'", str)
+		}
+	}
+}
+
+type fakeLoader struct {
+	rules []string
+}
+
+func (l *fakeLoader) Load(rules string) error {
+	l.rules = append(l.rules, rules)
+	return nil
+}
+
+func TestEnforcer(t *testing.T) {
+	var guestIP net.IP
+	lookup := func() (net.IP, error) {
+		if guestIP == nil {
+			return nil, fmt.Errorf("no DHCP lease")
+		}
+		return guestIP, nil
+	}
+	policy, _ := ParsePolicy([]string{"deny,cidr=10.0.0.0/8"})
+	loader := &fakeLoader{}
+	enforcer := NewEnforcer(policy, lookup, loader)
+
+	// the whole network is blocked until the guest IP is known
+	_, network, _ := net.ParseCIDR("192.168.64.0/24")
+	if err := enforcer.LoadPendingRules(network); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	newPolicy, _ := ParsePolicy([]string{"default=deny"})
+	if err := enforcer.SetPolicy(newPolicy); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if err := enforcer.update(); err != nil || len(loader.rules) != 1 {
+		t.Fatalf("unexpected rules loaded: %v %v", loader.rules, err)
+	}
+	if loader.rules[0] != "block drop in quick inet from 192.168.64.0/24 to ! 192.168.64.0/24\n" {
+		t.Fatalf("unexpected pending rules: %v", loader.rules)
+	}
+
+	guestIP = net.ParseIP("192.168.64.3")
+	if err := enforcer.update(); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if err := enforcer.update(); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if len(loader.rules) != 2 || loader.rules[1] != "block drop in quick from 192.168.64.3 to any\n" {
+		t.Fatalf("unexpected rules loaded: %v", loader.rules)
+	}
+
+	if err := enforcer.SetPolicy(policy); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if len(loader.rules) != 3 || enforcer.Policy().Default != ActionAllow {
+		t.Fatalf("policy was not applied: %v", loader.rules)
+	}
+}
@@ -0,0 +1,117 @@
+package egress
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// guestIPPollInterval is how often the enforcer checks if the guest IP
+// address changed.
+const guestIPPollInterval = time.Second
+
+// RuleLoader loads firewall rules, it's implemented by PFAnchor.
+type RuleLoader interface {
+	Load(rules string) error
+}
+
+// Enforcer keeps the firewall rules up to date with the policy of a virtual
+// machine and with its IP address, which is only known once the guest network
+// is up. The policy can be changed at runtime. It is safe for concurrent use.
+type Enforcer struct {
+	mu      sync.Mutex
+	policy  *Policy
+	guestIP func() (net.IP, error)
+	loader  RuleLoader
+	// appliedIP is the guest IP address used by the loaded rules
+	appliedIP net.IP
+}
+
+// NewEnforcer creates an enforcer for policy. guestIP returns the current IP
+// address of the virtual machine, it fails until the guest network is up.
+func NewEnforcer(policy *Policy, guestIP func() (net.IP, error), loader RuleLoader) *Enforcer {
+	return &Enforcer{
+		policy:  policy,
+		guestIP: guestIP,
+		loader:  loader,
+	}
+}
+
+// Policy returns the current policy.
+func (e *Enforcer) Policy() *Policy {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	policy := *e.policy
+	policy.Rules = append([]Rule{}, e.policy.Rules...)
+	return &policy
+}
+
+// SetPolicy replaces the policy, the new rules are loaded immediately if the
+// guest IP address is known.
+func (e *Enforcer) SetPolicy(policy *Policy) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.appliedIP != nil {
+		if err := e.loader.Load(policy.PFRules(e.appliedIP)); err != nil {
+			return err
+		}
+	}
+	e.policy = policy
+
+	return nil
+}
+
+// LoadPendingRules drops the packets sent by network to the outside until the
+// rules of the policy are loaded, network is the NAT network the guest gets
+// its IP address in. It must be called before the virtual machine starts, so
+// that the guest cannot send any packet before the guest IP address is known.
+func (e *Enforcer) LoadPendingRules(network *net.IPNet) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.loader.Load(PendingPFRules(network))
+}
+
+// update loads the rules if the guest IP address changed.
+func (e *Enforcer) update() error {
+	ip, err := e.guestIP()
+	if err != nil {
+		log.Debugf("egress rules not applied yet: %v", err)
+		return nil
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if ip.Equal(e.appliedIP) {
+		return nil
+	}
+	if err := e.loader.Load(e.policy.PFRules(ip)); err != nil {
+		return err
+	}
+	log.Infof("egress rules applied to guest IP %s", ip)
+	e.appliedIP = ip
+
+	return nil
+}
+
+// Run loads the rules as soon as the guest IP address is known, and when it
+// changes, until ctx is done.
+func (e *Enforcer) Run(ctx context.Context) {
+	ticker := time.NewTicker(guestIPPollInterval)
+	defer ticker.Stop()
+	for {
+		if err := e.update(); err != nil {
+			log.Warnf("failed to apply egress rules: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package egress
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+var pfTokenRegexp = regexp.MustCompile(`Token : (\d+)`)
+
+// PFAnchor loads rules in a pf anchor with pfctl, which requires root
+// privileges. The default macOS pf configuration evaluates the anchors named
+// com.apple/*.
+type PFAnchor struct {
+	name  string
+	token string
+}
+
+func pfctl(stdin string, args ...string) (string, error) {
+	cmd := exec.Command("pfctl", args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("pfctl %s failed: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(output.String()))
+	}
+
+	return output.String(), nil
+}
+
+// NewPFAnchor enables pf and returns the anchor named com.apple/name.
+func NewPFAnchor(name string) (*PFAnchor, error) {
+	// -E keeps pf enabled until the token it returns is released, even if
+	// other programs enable and disable it
+	output, err := pfctl("", "-E")
+	if err != nil {
+		return nil, err
+	}
+	anchor := &PFAnchor{name: "com.apple/" + name}
+	if match := pfTokenRegexp.FindStringSubmatch(output); match != nil {
+		anchor.token = match[1]
+	}
+
+	return anchor, nil
+}
+
+// Load replaces the rules of the anchor.
+func (a *PFAnchor) Load(rules string) error {
+	_, err := pfctl(rules, "-a", a.name, "-f", "-")
+	return err
+}
+
+// Close removes the rules of the anchor, and releases the reference to pf
+// taken by NewPFAnchor.
+func (a *PFAnchor) Close() error {
+	if _, err := pfctl("", "-a", a.name, "-F", "rules"); err != nil {
+		return err
+	}
+	if a.token == "" {
+		return nil
+	}
+	_, err := pfctl("", "-X", a.token)
+	return err
+}
@@ -0,0 +1,155 @@
+// Package events keeps an in-memory log of the lifecycle and device events of
+// a virtual machine, such as its start, the devices it started with, or a crash.
+//
+// The most recent events are kept in a ring buffer which can be queried with
+// the /vm/events endpoint of the REST API. The events can also be mirrored to
+// a file in JSON lines format, one define.Event per line, to keep an audit
+// trail which outlives the vfkit process.
+package events
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/crc-org/vfkit/pkg/rest/define"
+)
+
+// DefaultCapacity is the number of events kept in memory by default.
+const DefaultCapacity = 1000
+
+// Event types
+const (
+	TypeStarted           = "started"
+	TypeStopped           = "stopped"
+	TypePaused            = "paused"
+	TypeResumed           = "resumed"
+	TypeCrashed           = "crashed"
+	TypeFailed            = "failed"
+	TypeShutdownRequested = "shutdown-requested"
+	TypeStopRequested     = "stop-requested"
+	TypeDeviceAttached    = "device-attached"
+	TypeWatchdog          = "watchdog"
+	TypeSnapshotCreated   = "snapshot-created"
+	TypeSnapshotRestored  = "snapshot-restored"
+)
+
+// Log records events in a ring buffer, and optionally in a mirror file. It is
+// safe for concurrent use.
+type Log struct {
+	mu       sync.Mutex
+	capacity int
+	events   []define.Event
+	// next is the index in events where the next event is stored once the
+	// buffer is full
+	next   int
+	nextID uint64
+	mirror *os.File
+}
+
+// NewLog creates an event log which keeps the last capacity events in
+// memory.
+func NewLog(capacity int) *Log {
+	if capacity <= 0 {
+		capacity = DefaultCapacity
+	}
+	return &Log{
+		capacity: capacity,
+		events:   make([]define.Event, 0, capacity),
+		nextID:   1,
+	}
+}
+
+// MirrorTo appends all the events recorded from now on to the file at path,
+// which is created if needed.
+func (l *Log) MirrorTo(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.mirror != nil {
+		_ = l.mirror.Close()
+	}
+	l.mirror = file
+
+	return nil
+}
+
+// Record adds an event with the current time to the log and returns it.
+// details is any JSON-serializable value, it can be nil. Errors writing to the
+// mirror file are returned, the event is recorded in memory regardless.
+func (l *Log) Record(eventType string, message string, details interface{}) (define.Event, error) {
+	event := define.Event{
+		Time:    time.Now(),
+		Type:    eventType,
+		Message: message,
+		Details: details,
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	event.ID = l.nextID
+	l.nextID++
+	if len(l.events) < l.capacity {
+		l.events = append(l.events, event)
+	} else {
+		l.events[l.next] = event
+		l.next = (l.next + 1) % l.capacity
+	}
+
+	if l.mirror == nil {
+		return event, nil
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return event, err
+	}
+	_, err = l.mirror.Write(append(data, '\n'))
+
+	return event, err
+}
+
+// Events returns the events in memory with an ID greater than since, oldest
+// first. When eventType is not empty, only the events of this type are
+// returned.
+func (l *Log) Events(since uint64, eventType string) []define.Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	events := []define.Event{}
+	for i := range l.events {
+		event := l.events[(l.next+i)%len(l.events)]
+		if event.ID <= since {
+			continue
+		}
+		if eventType != "" && event.Type != eventType {
+			continue
+		}
+		events = append(events, event)
+	}
+
+	return events
+}
+
+// Close closes the mirror file, if any.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.mirror == nil {
+		return nil
+	}
+	err := l.mirror.Close()
+	l.mirror = nil
+
+	return err
+}
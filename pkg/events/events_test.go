@@ -0,0 +1,71 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/crc-org/vfkit/pkg/rest/define"
+)
+
+func TestLogRingBuffer(t *testing.T) {
+	l := NewLog(3)
+	for _, eventType := range []string{TypeStarted, TypeDeviceAttached, TypePaused, TypeResumed, TypeDeviceAttached} {
+		if _, err := l.Record(eventType, "", nil); err != nil {
+			t.Fatal("expected no error; got", err)
+		}
+	}
+
+	events := l.Events(0, "")
+	if len(events) != 3 {
+		t.Fatalf("expected the 3 most recent events; got %+v", events)
+	}
+	for i, event := range events {
+		if event.ID != uint64(i+3) {
+			t.Fatalf("expected events 3 to 5 in order; got %+v", events)
+		}
+	}
+	if events := l.Events(4, ""); len(events) != 1 || events[0].ID != 5 {
+		t.Fatalf("expected only the events after ID 4; got %+v", events)
+	}
+	if events := l.Events(0, TypeDeviceAttached); len(events) != 1 || events[0].Type != TypeDeviceAttached {
+		t.Fatalf("expected only the device-attached events; got %+v", events)
+	}
+}
+
+func TestLogMirror(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "myvm", "events.jsonl")
+	l := NewLog(0)
+	if _, err := l.Record(TypeStarted, "not mirrored", nil); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if err := l.MirrorTo(path); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if _, err := l.Record(TypeCrashed, "virtual machine error", map[string]string{"from": "running"}); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	events := []define.Event{}
+	for scanner.Scan() {
+		var event define.Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatal("expected no error; got", err)
+		}
+		events = append(events, event)
+	}
+	if len(events) != 1 || events[0].ID != 2 || events[0].Type != TypeCrashed || events[0].Details == nil {
+		t.Fatalf("unexpected mirrored events: %+v", events)
+	}
+}
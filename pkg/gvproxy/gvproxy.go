@@ -0,0 +1,227 @@
+// Package gvproxy launches and supervises the gvproxy process of
+// gvisor-tap-vsock, which provides a user-mode network stack to the virtual
+// machine: DHCP, DNS, and port forwarding from the host.
+package gvproxy
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/crc-org/vfkit/pkg/util"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// startTimeout is how long Start waits for gvproxy to create its
+	// network socket.
+	startTimeout = 10 * time.Second
+	// stopTimeout is how long Stop waits for gvproxy to exit after SIGTERM
+	// before killing it.
+	stopTimeout = 5 * time.Second
+)
+
+// Config is the configuration of a gvproxy process.
+type Config struct {
+	// Binary is the path of gvproxy, it's looked up in $PATH when empty.
+	Binary string
+	// NetworkSocket is the unixgram socket gvproxy creates for the
+	// virtio-net device of vfkit (-listen-vfkit).
+	NetworkSocket string
+	// ServicesSocket is the unix socket of the gvproxy HTTP API used to
+	// manage port forwards (-services), it's optional.
+	ServicesSocket string
+	// VsockSocket is the unix socket gvproxy listens on for the connections
+	// of the guest forwarded from a virtio-vsock port (-listen), it's
+	// optional.
+	VsockSocket string
+	// SSHPort is the host port forwarded to the SSH port of the guest
+	// (-ssh-port), 0 disables the forward.
+	SSHPort uint16
+	// MTU is the MTU of the guest network (-mtu), 0 keeps the gvproxy
+	// default of 1500. It must match the MTU of the virtio-net device.
+	MTU uint
+	// PIDFile stores the pid of gvproxy, it's used to stop a gvproxy left
+	// over by a vfkit process which did not exit cleanly.
+	PIDFile string
+	// LogFile receives the output of gvproxy, it's discarded when empty.
+	LogFile string
+	// Debug enables the debug logs of gvproxy.
+	Debug bool
+}
+
+// Args returns the command line arguments of gvproxy for c.
+func (c *Config) Args() []string {
+	args := []string{"-listen-vfkit", "unixgram://" + c.NetworkSocket}
+	if c.ServicesSocket != "" {
+		args = append(args, "-services", "unix://"+c.ServicesSocket)
+	}
+	if c.VsockSocket != "" {
+		args = append(args, "-listen", "unix://"+c.VsockSocket)
+	}
+	if c.SSHPort != 0 {
+		args = append(args, "-ssh-port", fmt.Sprintf("%d", c.SSHPort))
+	} else {
+		args = append(args, "-ssh-port", "-1")
+	}
+	if c.MTU != 0 {
+		args = append(args, "-mtu", fmt.Sprintf("%d", c.MTU))
+	}
+	if c.PIDFile != "" {
+		args = append(args, "-pid-file", c.PIDFile)
+	}
+	if c.Debug {
+		args = append(args, "-debug")
+	}
+
+	return args
+}
+
+// Process is a running gvproxy process.
+type Process struct {
+	config Config
+	cmd    *exec.Cmd
+	done   chan struct{}
+	err    error
+}
+
+// Start launches gvproxy and waits until its network socket is created.
+func Start(config Config) (*Process, error) {
+	if config.NetworkSocket == "" {
+		return nil, fmt.Errorf("gvproxy needs a network socket path")
+	}
+	binary := config.Binary
+	if binary == "" {
+		binary = "gvproxy"
+	}
+	path, err := exec.LookPath(binary)
+	if err != nil {
+		return nil, fmt.Errorf("cannot find gvproxy: %w", err)
+	}
+	if err := stopStale(config.PIDFile); err != nil {
+		return nil, err
+	}
+	for _, socket := range []string{config.NetworkSocket, config.ServicesSocket, config.VsockSocket} {
+		if socket == "" {
+			continue
+		}
+		if err := os.Remove(socket); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	cmd := exec.Command(path, config.Args()...)
+	if config.LogFile != "" {
+		logFile, err := os.OpenFile(config.LogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, err
+		}
+		defer logFile.Close()
+		cmd.Stdout = logFile
+		cmd.Stderr = logFile
+	}
+	log.Infof("Starting %s %v", path, cmd.Args[1:])
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	p := &Process{
+		config: config,
+		cmd:    cmd,
+		done:   make(chan struct{}),
+	}
+	go func() {
+		p.err = cmd.Wait()
+		close(p.done)
+	}()
+
+	if err := p.waitForSocket(); err != nil {
+		_ = p.Stop()
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// stopStale stops the gvproxy process whose pid is in pidFile.
+func stopStale(pidFile string) error {
+	if pidFile == "" {
+		return nil
+	}
+	pid, err := util.ReadPIDFile(pidFile)
+	if err != nil {
+		return nil
+	}
+	log.Warnf("stopping stale gvproxy process %d", pid)
+	if err := syscall.Kill(pid, syscall.SIGTERM); err != nil {
+		return fmt.Errorf("cannot stop stale gvproxy process %d: %w", pid, err)
+	}
+	for deadline := time.Now().Add(stopTimeout); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		if !util.ProcessRunning(pid) {
+			return nil
+		}
+	}
+	return fmt.Errorf("stale gvproxy process %d did not exit", pid)
+}
+
+func (p *Process) waitForSocket() error {
+	deadline := time.After(startTimeout)
+	for {
+		if _, err := os.Stat(p.config.NetworkSocket); err == nil {
+			return nil
+		}
+		select {
+		case <-p.done:
+			return fmt.Errorf("gvproxy exited before creating %s: %v", p.config.NetworkSocket, p.err)
+		case <-deadline:
+			return fmt.Errorf("timeout waiting for gvproxy to create %s", p.config.NetworkSocket)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+// PID returns the process ID of gvproxy.
+func (p *Process) PID() int {
+	return p.cmd.Process.Pid
+}
+
+// Done returns a channel which is closed when gvproxy exits.
+func (p *Process) Done() <-chan struct{} {
+	return p.done
+}
+
+// Err returns the exit status of gvproxy once Done is closed.
+func (p *Process) Err() error {
+	select {
+	case <-p.done:
+		return p.err
+	default:
+		return nil
+	}
+}
+
+// Stop terminates gvproxy, it's killed if it does not exit in time. Its
+// sockets are removed.
+func (p *Process) Stop() error {
+	select {
+	case <-p.done:
+	default:
+		_ = p.cmd.Process.Signal(syscall.SIGTERM)
+		select {
+		case <-p.done:
+		case <-time.After(stopTimeout):
+			log.Warnf("gvproxy did not exit after SIGTERM, killing it")
+			_ = p.cmd.Process.Kill()
+			<-p.done
+		}
+	}
+	for _, path := range []string{p.config.NetworkSocket, p.config.ServicesSocket, p.config.VsockSocket, p.config.PIDFile} {
+		if path != "" {
+			_ = os.Remove(path)
+		}
+	}
+
+	return nil
+}
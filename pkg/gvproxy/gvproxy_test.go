@@ -0,0 +1,90 @@
+package gvproxy
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestArgs(t *testing.T) {
+	config := Config{
+		NetworkSocket:  "/tmp/net.sock",
+		ServicesSocket: "/tmp/services.sock",
+		SSHPort:        2222,
+		MTU:            9000,
+		PIDFile:        "/tmp/gvproxy.pid",
+	}
+	expected := []string{
+		"-listen-vfkit", "unixgram:///tmp/net.sock",
+		"-services", "unix:///tmp/services.sock",
+		"-ssh-port", "2222",
+		"-mtu", "9000",
+		"-pid-file", "/tmp/gvproxy.pid",
+	}
+	if args := config.Args(); !reflect.DeepEqual(args, expected) {
+		t.Fatalf("expected %v; got %v", expected, args)
+	}
+
+	config = Config{NetworkSocket: "/tmp/net.sock", VsockSocket: "/tmp/vsock.sock", Debug: true}
+	expected = []string{
+		"-listen-vfkit", "unixgram:///tmp/net.sock",
+		"-listen", "unix:///tmp/vsock.sock",
+		"-ssh-port", "-1",
+		"-debug",
+	}
+	if args := config.Args(); !reflect.DeepEqual(args, expected) {
+		t.Fatalf("expected %v; got %v", expected, args)
+	}
+}
+
+// fakeGVProxy creates the file given after -listen-vfkit and waits to be
+// terminated.
+const fakeGVProxy = `#!/bin/sh
+while [ $# -gt 0 ]; do
+	if [ "$1" = "-listen-vfkit" ]; then
+		touch "${2#unixgram://}"
+	fi
+	shift
+done
+trap 'exit 0' TERM
+while true; do sleep 0.1; done
+`
+
+func TestStartStop(t *testing.T) {
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "gvproxy")
+	if err := os.WriteFile(binary, []byte(fakeGVProxy), 0700); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	socket := filepath.Join(dir, "net.sock")
+	process, err := Start(Config{Binary: binary, NetworkSocket: socket})
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if _, err := os.Stat(socket); err != nil {
+		t.Fatal("expected network socket to exist; got", err)
+	}
+	if err := process.Stop(); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	select {
+	case <-process.Done():
+	default:
+		t.Fatal("expected gvproxy to be stopped")
+	}
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Fatal("expected network socket to be removed; got", err)
+	}
+}
+
+func TestStartEarlyExit(t *testing.T) {
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "gvproxy")
+	if err := os.WriteFile(binary, []byte("#!/bin/sh\nexit 1\n"), 0700); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if _, err := Start(Config{Binary: binary, NetworkSocket: filepath.Join(dir, "net.sock")}); err == nil {
+		t.Fatal("expected error when gvproxy exits early")
+	}
+}
@@ -0,0 +1,239 @@
+// Package inventory lists the virtual machines started by vfkit with --name,
+// with their configuration and resource usage, for ingestion in asset
+// management databases.
+package inventory
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/crc-org/vfkit/pkg/client"
+	"github.com/crc-org/vfkit/pkg/naming"
+	"github.com/crc-org/vfkit/pkg/output"
+	"github.com/crc-org/vfkit/pkg/rest/define"
+	"github.com/crc-org/vfkit/pkg/util"
+	vmstate "github.com/crc-org/vfkit/pkg/vm"
+)
+
+// queryTimeout bounds the REST API requests made for each virtual machine.
+const queryTimeout = 5 * time.Second
+
+// Entry describes a virtual machine of the inventory. Only Name,
+// SerialNumber and State are set for stopped virtual machines, which have no
+// running vfkit process. State,
+// GuestIP and the resource usage are only known when the REST API of the
+// virtual machine is enabled.
+type Entry struct {
+	Name         string            `json:"name"`
+	SerialNumber string            `json:"serialNumber"`
+	State        string            `json:"state,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	PID          int               `json:"pid,omitempty"`
+	Version      string            `json:"version,omitempty"`
+	StartTime    *time.Time        `json:"startTime,omitempty"`
+	CPUs         uint              `json:"cpus,omitempty"`
+	MemoryBytes  uint64            `json:"memoryBytes,omitempty"`
+	Disks        []string          `json:"disks,omitempty"`
+	MACAddresses []string          `json:"macAddresses,omitempty"`
+	GuestIP      string            `json:"guestIP,omitempty"`
+	// CPUTimeSeconds, MemoryFootprintBytes and the disk I/O are the
+	// resource usage of the vfkit process
+	CPUTimeSeconds       float64 `json:"cpuTimeSeconds,omitempty"`
+	MemoryFootprintBytes uint64  `json:"memoryFootprintBytes,omitempty"`
+	DiskReadBytes        uint64  `json:"diskReadBytes,omitempty"`
+	DiskWrittenBytes     uint64  `json:"diskWrittenBytes,omitempty"`
+	// Config is the effective configuration reported by the REST API
+	Config *define.VMConfig `json:"config,omitempty"`
+}
+
+// Collect returns the virtual machines found in stateDir, sorted by name.
+// Only the virtual machines using the default naming template are found.
+// An empty stateDir is replaced with its default value.
+func Collect(ctx context.Context, stateDir string) ([]Entry, error) {
+	if stateDir == "" {
+		var err error
+		if stateDir, err = naming.DefaultStateDir(); err != nil {
+			return nil, err
+		}
+	}
+	dirEntries, err := os.ReadDir(stateDir)
+	if os.IsNotExist(err) {
+		return []Entry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []Entry{}
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.IsDir() {
+			continue
+		}
+		// the other directories are not virtual machines
+		if entry, err := Lookup(ctx, stateDir, dirEntry.Name()); err == nil {
+			entries = append(entries, *entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+
+	return entries, nil
+}
+
+// Lookup returns the virtual machine named name in stateDir. An empty
+// stateDir is replaced with its default value.
+func Lookup(ctx context.Context, stateDir string, name string) (*Entry, error) {
+	namingTemplate, err := naming.NewTemplate("", stateDir, name)
+	if err != nil {
+		return nil, err
+	}
+	// the serial number is created the first time a named virtual machine
+	// runs
+	data, err := os.ReadFile(namingTemplate.Path(naming.SerialNumberID, "txt"))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("virtual machine '%s' not found", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	entry := &Entry{
+		Name:         name,
+		SerialNumber: strings.TrimSpace(string(data)),
+		State:        vmstate.StateStopped.String(),
+	}
+	record, err := util.ReadInstanceRecord(namingTemplate.Path(naming.InstanceID, "json"))
+	if err == nil {
+		entry.addRecord(record)
+		entry.query(ctx, record.RestfulURI)
+	}
+
+	return entry, nil
+}
+
+func (entry *Entry) addRecord(record *util.InstanceRecord) {
+	startTime := record.StartTime
+	entry.State = ""
+	entry.Labels = record.Labels
+	entry.PID = record.PID
+	entry.Version = record.Version
+	entry.StartTime = &startTime
+	entry.CPUs = record.CPUs
+	entry.MemoryBytes = record.MemoryBytes
+	entry.Disks = record.Disks
+	entry.MACAddresses = record.MACAddresses
+}
+
+// query adds the live data of the virtual machine, errors are ignored so that
+// an unresponsive virtual machine does not prevent the inventory.
+func (entry *Entry) query(ctx context.Context, restfulURI string) {
+	if restfulURI == "" {
+		return
+	}
+	restClient, err := client.NewRestClient(restfulURI)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+	if inspect, err := restClient.Inspect(ctx); err == nil {
+		entry.State = inspect.State.String()
+		entry.GuestIP = inspect.GuestIP
+		entry.Config = inspect.Config
+	}
+	if stats, err := restClient.Stats(ctx); err == nil {
+		entry.CPUTimeSeconds = stats.CPUTimeSeconds
+		entry.MemoryFootprintBytes = stats.MemoryFootprintBytes
+		entry.DiskReadBytes = stats.DiskReadBytes
+		entry.DiskWrittenBytes = stats.DiskWrittenBytes
+	}
+}
+
+// Table returns entries as a table with all their fields. Labels are
+// written as sorted key=value pairs separated by ';', as are the disks and
+// the MAC addresses.
+func Table(entries []Entry) *output.Table {
+	table := output.NewTable("NAME", "SERIAL NUMBER", "STATE", "LABELS", "PID", "VERSION", "START TIME",
+		"CPUS", "MEMORY BYTES", "DISKS", "MAC ADDRESSES", "GUEST IP", "CPU TIME SECONDS", "MEMORY FOOTPRINT BYTES")
+	table.ColumnColor = func(column int, value string) output.Color {
+		if column == 2 {
+			return output.StateColor(value)
+		}
+		return output.NoColor
+	}
+	for _, entry := range entries {
+		table.AddRow(
+			entry.Name,
+			entry.SerialNumber,
+			entry.State,
+			entry.labels(),
+			formatUint(uint64(entry.PID)),
+			entry.Version,
+			entry.startTime(),
+			formatUint(uint64(entry.CPUs)),
+			formatUint(entry.MemoryBytes),
+			strings.Join(entry.Disks, ";"),
+			strings.Join(entry.MACAddresses, ";"),
+			entry.GuestIP,
+			entry.cpuTime(),
+			formatUint(entry.MemoryFootprintBytes),
+		)
+	}
+
+	return table
+}
+
+// SummaryTable returns entries as a table with their main fields, for
+// 'vfkit list'.
+func SummaryTable(entries []Entry) *output.Table {
+	table := output.NewTable("NAME", "STATE", "CPUS", "MEMORY", "GUEST IP", "LABELS")
+	table.ColumnColor = func(column int, value string) output.Color {
+		if column == 1 {
+			return output.StateColor(value)
+		}
+		return output.NoColor
+	}
+	for _, entry := range entries {
+		memory := ""
+		if entry.MemoryBytes != 0 {
+			memory = fmt.Sprintf("%dMiB", entry.MemoryBytes/(1024*1024))
+		}
+		table.AddRow(entry.Name, entry.State, formatUint(uint64(entry.CPUs)), memory, entry.GuestIP, entry.labels())
+	}
+
+	return table
+}
+
+func (entry *Entry) labels() string {
+	labels := []string{}
+	for key, value := range entry.Labels {
+		labels = append(labels, fmt.Sprintf("%s=%s", key, value))
+	}
+	sort.Strings(labels)
+	return strings.Join(labels, ";")
+}
+
+func (entry *Entry) startTime() string {
+	if entry.StartTime == nil {
+		return ""
+	}
+	return entry.StartTime.Format(time.RFC3339)
+}
+
+func (entry *Entry) cpuTime() string {
+	if entry.CPUTimeSeconds == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(entry.CPUTimeSeconds, 'f', -1, 64)
+}
+
+// formatUint formats value, 0 is an unknown value which is left empty.
+func formatUint(value uint64) string {
+	if value == 0 {
+		return ""
+	}
+	return strconv.FormatUint(value, 10)
+}
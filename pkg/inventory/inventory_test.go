@@ -0,0 +1,90 @@
+package inventory
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/crc-org/vfkit/pkg/util"
+)
+
+func writeFile(t *testing.T, path string, data []byte) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+}
+
+func TestCollect(t *testing.T) {
+	stateDir := t.TempDir()
+	writeFile(t, filepath.Join(stateDir, "web", "serial-number.txt"), []byte("serial-web\n"))
+	record, err := json.Marshal(&util.InstanceRecord{
+		PID:          os.Getpid(),
+		Version:      "0.0.4",
+		StartTime:    time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Name:         "web",
+		SerialNumber: "serial-web",
+		Labels:       map[string]string{"team": "infra", "asset-tag": "A-42"},
+		CPUs:         2,
+		MemoryBytes:  2 << 30,
+		Disks:        []string{"/vms/web.img"},
+		MACAddresses: []string{"5a:94:ef:e4:0c:ee"},
+	})
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	writeFile(t, filepath.Join(stateDir, "web", "instance.json"), record)
+	writeFile(t, filepath.Join(stateDir, "db", "serial-number.txt"), []byte("serial-db\n"))
+	// not a virtual machine
+	writeFile(t, filepath.Join(stateDir, "proxy-cache", "entry.json"), []byte("{}"))
+
+	entries, err := Collect(context.Background(), stateDir)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+	if entries[0].Name != "db" || entries[0].SerialNumber != "serial-db" || entries[0].State != "stopped" {
+		t.Errorf("unexpected stopped entry: %+v", entries[0])
+	}
+	web := entries[1]
+	if web.Name != "web" || web.SerialNumber != "serial-web" || web.State != "" || web.CPUs != 2 || web.Labels["asset-tag"] != "A-42" {
+		t.Errorf("unexpected running entry: %+v", web)
+	}
+
+	var buf bytes.Buffer
+	if err := Table(entries).WriteCSV(&buf); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	expected := `NAME,SERIAL NUMBER,STATE,LABELS,PID,VERSION,START TIME,CPUS,MEMORY BYTES,DISKS,MAC ADDRESSES,GUEST IP,CPU TIME SECONDS,MEMORY FOOTPRINT BYTES
+db,serial-db,stopped,,,,,,,,,,,
+web,serial-web,,asset-tag=A-42;team=infra,` + strconv.Itoa(os.Getpid()) + `,0.0.4,2024-01-02T03:04:05Z,2,2147483648,/vms/web.img,5a:94:ef:e4:0c:ee,,,
+`
+	if buf.String() != expected {
+		t.Errorf("unexpected CSV output:\n%s", buf.String())
+	}
+}
+
+func TestLookupMissing(t *testing.T) {
+	if _, err := Lookup(context.Background(), t.TempDir(), "missing"); err == nil {
+		t.Fatal("expected error for a missing virtual machine")
+	}
+}
+
+func TestCollectMissingStateDir(t *testing.T) {
+	entries, err := Collect(context.Background(), filepath.Join(t.TempDir(), "missing"))
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+}
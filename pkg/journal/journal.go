@@ -0,0 +1,147 @@
+// Package journal records the control API calls and lifecycle events of a
+// virtual machine in an append-only file, so that what happened to it can be
+// reconstructed after the fact with 'vfkit replay'.
+//
+// The journal is a JSON lines file, each line is an Entry. Entries are only
+// ever appended, a journal can be shared by successive runs of the same
+// virtual machine.
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/crc-org/vfkit/pkg/output"
+)
+
+// Kind is the category of a journal entry.
+type Kind string
+
+const (
+	// KindLifecycle entries are recorded when vfkit starts or exits, and when
+	// the virtual machine changes state.
+	KindLifecycle Kind = "lifecycle"
+	// KindAPI entries are recorded for each REST API call modifying the
+	// virtual machine.
+	KindAPI Kind = "api"
+)
+
+// Entry is a single event of the journal.
+type Entry struct {
+	Time   time.Time `json:"time"`
+	Kind   Kind      `json:"kind"`
+	Action string    `json:"action"`
+	// Details is any JSON-serializable value giving more information about
+	// the event. When an entry is read back from a journal, it is the
+	// result of decoding that value with encoding/json.
+	Details interface{} `json:"details,omitempty"`
+}
+
+// Journal appends entries to a journal file. It is safe for concurrent use.
+type Journal struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// Open opens the journal at path for appending, the file and its directory
+// are created if needed.
+func Open(path string) (*Journal, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Journal{file: file}, nil
+}
+
+// Append writes entry at the end of the journal. The current time is used
+// when entry.Time is not set.
+func (j *Journal) Append(entry Entry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	// a single write per entry so that a crash leaves at most one
+	// truncated line at the end of the file
+	data = append(data, '\n')
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	_, err = j.file.Write(data)
+
+	return err
+}
+
+// Record appends an entry with the current time to the journal.
+func (j *Journal) Record(kind Kind, action string, details interface{}) error {
+	return j.Append(Entry{Kind: kind, Action: action, Details: details})
+}
+
+// Close closes the journal file.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.file.Close()
+}
+
+// ReadEntries reads all the entries of a journal. A truncated last line,
+// which vfkit leaves behind if it is killed while writing an entry, is
+// ignored.
+func ReadEntries(r io.Reader) ([]Entry, error) {
+	entries := []Entry{}
+	reader := bufio.NewReader(r)
+	for lineNum := 1; ; lineNum++ {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 1 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return nil, fmt.Errorf("journal line %d: %w", lineNum, err)
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// Timeline returns entries as a table, one per row, with the time elapsed
+// since the first entry.
+func Timeline(entries []Entry) (*output.Table, error) {
+	table := output.NewTable("TIME", "ELAPSED", "KIND", "ACTION", "DETAILS")
+	var start time.Time
+	for i, entry := range entries {
+		if i == 0 {
+			start = entry.Time
+		}
+		details := ""
+		if entry.Details != nil {
+			data, err := json.Marshal(entry.Details)
+			if err != nil {
+				return nil, err
+			}
+			details = string(data)
+		}
+		elapsed := "+" + entry.Time.Sub(start).Round(time.Millisecond).String()
+		table.AddRow(entry.Time.Format("2006-01-02 15:04:05.000"), elapsed, string(entry.Kind), entry.Action, details)
+	}
+
+	return table, nil
+}
@@ -0,0 +1,86 @@
+package journal
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "myvm", "journal.jsonl")
+	start := time.Date(2023, 5, 4, 10, 0, 0, 0, time.Local)
+
+	for i := 0; i < 2; i++ {
+		// reopening the journal appends to it
+		j, err := Open(path)
+		if err != nil {
+			t.Fatal("expected no error; got", err)
+		}
+		err = j.Append(Entry{
+			Time:    start.Add(time.Duration(i) * 1500 * time.Millisecond),
+			Kind:    KindAPI,
+			Action:  "POST /vm/state",
+			Details: map[string]string{"state": "stopped"},
+		})
+		if err != nil {
+			t.Fatal("expected no error; got", err)
+		}
+		if err := j.Record(KindLifecycle, "vfkit exited", nil); err != nil {
+			t.Fatal("expected no error; got", err)
+		}
+		if err := j.Close(); err != nil {
+			t.Fatal("expected no error; got", err)
+		}
+	}
+
+	// simulate a crash while writing an entry
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if _, err := file.WriteString(`{"time":"2023-05-04`); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	file.Close()
+
+	file, err = os.Open(path)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	defer file.Close()
+	entries, err := ReadEntries(file)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if len(entries) != 4 {
+		t.Fatalf("expected 4 entries, got %d", len(entries))
+	}
+	if entries[0].Kind != KindAPI || entries[1].Kind != KindLifecycle || entries[1].Time.IsZero() {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+
+	timeline, err := Timeline(entries[2:3])
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	var buf bytes.Buffer
+	if err := timeline.Write(&buf, false); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	expected := `TIME                      ELAPSED   KIND   ACTION           DETAILS
+2023-05-04 10:00:01.500   +0s       api    POST /vm/state   {"state":"stopped"}
+`
+	if buf.String() != expected {
+		t.Fatalf("unexpected timeline: %q", buf.String())
+	}
+}
+
+func TestReadEntriesInvalid(t *testing.T) {
+	_, err := ReadEntries(strings.NewReader("{}\nnot json\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("expected error for line 2, got %v", err)
+	}
+}
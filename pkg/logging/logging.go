@@ -0,0 +1,58 @@
+// Package logging configures the logger of the vfkit process.
+package logging
+
+import (
+	"fmt"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Options configures the vfkit logger, they are set with the --log-level,
+// --log-format and --log-file command line arguments.
+type Options struct {
+	// Level is one of the logrus levels: trace, debug, info, warn, error...
+	Level string
+	// Format is either "text" or "json"
+	Format string
+	// File is the path to a file where logs are written instead of stderr
+	File string
+}
+
+// Setup configures the standard logrus logger according to opts. The returned
+// function closes the log file, if any.
+func Setup(opts Options) (func() error, error) {
+	return setup(log.StandardLogger(), opts)
+}
+
+func setup(logger *log.Logger, opts Options) (func() error, error) {
+	noop := func() error { return nil }
+
+	if opts.Level != "" {
+		level, err := log.ParseLevel(opts.Level)
+		if err != nil {
+			return noop, fmt.Errorf("invalid log level: %s", opts.Level)
+		}
+		logger.SetLevel(level)
+	}
+
+	switch opts.Format {
+	case "", "text":
+		logger.SetFormatter(&log.TextFormatter{})
+	case "json":
+		logger.SetFormatter(&log.JSONFormatter{})
+	default:
+		return noop, fmt.Errorf("invalid log format '%s', expected 'text' or 'json'", opts.Format)
+	}
+
+	if opts.File == "" {
+		return noop, nil
+	}
+	file, err := os.OpenFile(opts.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return noop, err
+	}
+	logger.SetOutput(file)
+
+	return file.Close, nil
+}
@@ -0,0 +1,44 @@
+package logging
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestSetup(t *testing.T) {
+	logger := log.New()
+	logFile := filepath.Join(t.TempDir(), "vfkit.log")
+	closeLog, err := setup(logger, Options{Level: "debug", Format: "json", File: logFile})
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	logger.WithField("device", "virtio-blk").Debug("adding device")
+	if err := closeLog(); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	var entry map[string]string
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if entry["level"] != "debug" || entry["msg"] != "adding device" || entry["device"] != "virtio-blk" {
+		t.Fatalf("unexpected log entry: %v", entry)
+	}
+}
+
+func TestSetupInvalid(t *testing.T) {
+	if _, err := setup(log.New(), Options{Level: "loud"}); err == nil {
+		t.Fatal("expected error for an invalid log level")
+	}
+	if _, err := setup(log.New(), Options{Format: "xml"}); err == nil {
+		t.Fatal("expected error for an invalid log format")
+	}
+}
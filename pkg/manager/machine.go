@@ -0,0 +1,256 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/crc-org/vfkit/pkg/client"
+	vmstate "github.com/crc-org/vfkit/pkg/vm"
+	log "github.com/sirupsen/logrus"
+)
+
+// restStatePollInterval is how often the REST API of a starting virtual
+// machine is queried until it's running.
+const restStatePollInterval = 500 * time.Millisecond
+
+// Machine is a virtual machine defined in a Manager.
+type Machine struct {
+	name         string
+	config       *client.VirtualMachine
+	logger       *log.Entry
+	stateMachine *vmstate.StateMachine
+
+	lock          sync.Mutex
+	cmd           *exec.Cmd
+	exited        chan struct{}
+	exitErr       error
+	stopRequested bool
+}
+
+func newMachine(name string, config *client.VirtualMachine, logger *log.Entry) *Machine {
+	return &Machine{
+		name:         name,
+		config:       config,
+		logger:       logger,
+		stateMachine: vmstate.NewStateMachine(),
+		exited:       closedChannel(),
+	}
+}
+
+func closedChannel() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}
+
+// Name returns the name of the virtual machine.
+func (mc *Machine) Name() string {
+	return mc.name
+}
+
+// Config returns the configuration of the virtual machine. It can be used to
+// get a REST API client or the paths of the host artifacts of the virtual
+// machine, changes are used by the next Start.
+func (mc *Machine) Config() *client.VirtualMachine {
+	return mc.config
+}
+
+// State returns the current state of the virtual machine.
+func (mc *Machine) State() vmstate.State {
+	return mc.stateMachine.State()
+}
+
+// StateMachine returns the state machine tracking the state of the virtual
+// machine, it can be used to wait for or subscribe to state changes.
+func (mc *Machine) StateMachine() *vmstate.StateMachine {
+	return mc.stateMachine
+}
+
+// Running returns true while the vfkit process of the virtual machine is
+// running.
+func (mc *Machine) Running() bool {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+	return mc.cmd != nil
+}
+
+// PID returns the process ID of vfkit, or 0 when it's not running.
+func (mc *Machine) PID() int {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+	if mc.cmd == nil {
+		return 0
+	}
+	return mc.cmd.Process.Pid
+}
+
+// setState changes the state of the virtual machine, mc.lock must be held.
+func (mc *Machine) setState(state vmstate.State, message string) {
+	if err := mc.stateMachine.SetStateWithMessage(state, message); err != nil {
+		mc.logger.Debugf("ignoring state change: %v", err)
+	}
+}
+
+// Start starts the vfkit process of the virtual machine. It returns once the
+// process is started, the virtual machine is in the running state once its
+// REST API reports it's running, or right away when the REST API is not
+// enabled.
+func (mc *Machine) Start() error {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	if mc.cmd != nil {
+		return fmt.Errorf("virtual machine '%s' is already running", mc.name)
+	}
+	args, err := mc.config.ToCmdLine()
+	if err != nil {
+		return err
+	}
+	if err := mc.stateMachine.SetState(vmstate.StateStarting); err != nil {
+		return err
+	}
+
+	output := mc.logger.WriterLevel(log.InfoLevel)
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = output
+	cmd.Stderr = output
+	// vfkit must not receive the signals sent to the process group of the
+	// manager, such as SIGINT on ctrl-c
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		_ = output.Close()
+		mc.setState(vmstate.StateError, err.Error())
+		return fmt.Errorf("failed to start vfkit: %w", err)
+	}
+	mc.logger.Infof("vfkit started with PID %d", cmd.Process.Pid)
+
+	mc.cmd = cmd
+	mc.exited = make(chan struct{})
+	mc.exitErr = nil
+	mc.stopRequested = false
+	go mc.wait(cmd, mc.exited, output)
+	go mc.waitRunning(mc.exited)
+
+	return nil
+}
+
+// wait waits for the vfkit process to exit and updates the state of the
+// virtual machine.
+func (mc *Machine) wait(cmd *exec.Cmd, exited chan struct{}, output io.Closer) {
+	err := cmd.Wait()
+	_ = output.Close()
+
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+	mc.cmd = nil
+	mc.exitErr = err
+	switch {
+	case err == nil:
+		mc.logger.Info("vfkit exited")
+		mc.setState(vmstate.StateStopped, "")
+	case mc.stopRequested:
+		mc.logger.Infof("vfkit stopped: %v", err)
+		mc.setState(vmstate.StateStopped, err.Error())
+	default:
+		mc.logger.Errorf("vfkit failed: %v", err)
+		mc.setState(vmstate.StateError, err.Error())
+	}
+	close(exited)
+}
+
+// waitRunning moves the virtual machine to the running state once its REST
+// API reports it's running.
+func (mc *Machine) waitRunning(exited chan struct{}) {
+	restClient, err := mc.config.RestClient()
+	if err != nil {
+		mc.setRunning()
+		return
+	}
+
+	ticker := time.NewTicker(restStatePollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-exited:
+			return
+		case <-ticker.C:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), restStatePollInterval)
+		state, err := restClient.State(ctx)
+		cancel()
+		if err == nil && state == vmstate.StateRunning {
+			mc.setRunning()
+			return
+		}
+	}
+}
+
+func (mc *Machine) setRunning() {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+	// the virtual machine may have been stopped in the meantime
+	if mc.cmd != nil && mc.stateMachine.State() == vmstate.StateStarting {
+		mc.setState(vmstate.StateRunning, "")
+	}
+}
+
+// Stop sends SIGTERM to vfkit, which shuts down the virtual machine, and waits
+// for it to exit. vfkit is killed when ctx expires first. Stopping a virtual
+// machine which is not running is a no-op.
+func (mc *Machine) Stop(ctx context.Context) error {
+	mc.lock.Lock()
+	cmd := mc.cmd
+	exited := mc.exited
+	if cmd == nil {
+		mc.lock.Unlock()
+		return nil
+	}
+	mc.stopRequested = true
+	switch mc.stateMachine.State() {
+	case vmstate.StateRunning, vmstate.StatePaused:
+		mc.setState(vmstate.StateStopping, "")
+	}
+	err := cmd.Process.Signal(syscall.SIGTERM)
+	mc.lock.Unlock()
+	if err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return err
+	}
+
+	select {
+	case <-exited:
+		return nil
+	case <-ctx.Done():
+	}
+	mc.logger.Warn("vfkit did not stop in time, killing it")
+	if err := cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return err
+	}
+	<-exited
+
+	return fmt.Errorf("virtual machine '%s' was killed: %w", mc.name, ctx.Err())
+}
+
+// Wait waits for the vfkit process to exit, and returns its exit error. It
+// returns right away when the virtual machine is not running.
+func (mc *Machine) Wait(ctx context.Context) error {
+	mc.lock.Lock()
+	exited := mc.exited
+	mc.lock.Unlock()
+
+	select {
+	case <-exited:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+	return mc.exitErr
+}
@@ -0,0 +1,163 @@
+// Package manager supervises several vfkit virtual machines from a single go
+// process.
+//
+// Each virtual machine is defined from a client.VirtualMachine and a unique
+// name, and runs in its own vfkit process. The Manager starts and stops these
+// processes, tracks the state of each virtual machine with a vm.StateMachine,
+// and forwards the output of vfkit to a shared logger, with a "vm" field
+// holding the name of the virtual machine.
+package manager
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/crc-org/vfkit/pkg/client"
+	log "github.com/sirupsen/logrus"
+)
+
+// Manager keeps track of a set of named virtual machines. It is safe for
+// concurrent use.
+type Manager struct {
+	lock     sync.Mutex
+	logger   *log.Logger
+	machines map[string]*Machine
+}
+
+// New creates a Manager which logs the output of the vfkit processes with
+// logger, the standard logrus logger is used when it's nil.
+func New(logger *log.Logger) *Manager {
+	if logger == nil {
+		logger = log.StandardLogger()
+	}
+	return &Manager{
+		logger:   logger,
+		machines: map[string]*Machine{},
+	}
+}
+
+// Define adds a virtual machine named name to the manager, with the
+// configuration of vm. vm is copied and can be reused as a template for other
+// virtual machines, the copy is given name with client.WithName. It must not
+// be daemonized, the manager needs to be the parent of the vfkit process.
+func (m *Manager) Define(name string, vm *client.VirtualMachine) (*Machine, error) {
+	if name == "" {
+		return nil, fmt.Errorf("%w: virtual machine name cannot be empty", client.ErrInvalidConfig)
+	}
+	config, err := vm.Clone(client.WithName(name))
+	if err != nil {
+		return nil, err
+	}
+	if config.VfkitPath() == "" {
+		config.SetVfkitPath("vfkit")
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.machines[name]; ok {
+		return nil, fmt.Errorf("%w: a virtual machine named '%s' is already defined", client.ErrInvalidConfig, name)
+	}
+	machine := newMachine(name, config, m.logger.WithField("vm", name))
+	m.machines[name] = machine
+
+	return machine, nil
+}
+
+// Get returns the virtual machine named name.
+func (m *Manager) Get(name string) (*Machine, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	machine, ok := m.machines[name]
+	return machine, ok
+}
+
+func (m *Manager) get(name string) (*Machine, error) {
+	machine, ok := m.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("no virtual machine named '%s'", name)
+	}
+	return machine, nil
+}
+
+// List returns the virtual machines of the manager, sorted by name.
+func (m *Manager) List() []*Machine {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	machines := make([]*Machine, 0, len(m.machines))
+	for _, machine := range m.machines {
+		machines = append(machines, machine)
+	}
+	sort.Slice(machines, func(i, j int) bool {
+		return machines[i].Name() < machines[j].Name()
+	})
+	return machines
+}
+
+// Remove removes the virtual machine named name from the manager. It must
+// not be running.
+func (m *Manager) Remove(name string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	machine, ok := m.machines[name]
+	if !ok {
+		return fmt.Errorf("no virtual machine named '%s'", name)
+	}
+	if machine.Running() {
+		return fmt.Errorf("virtual machine '%s' is still running", name)
+	}
+	delete(m.machines, name)
+	return nil
+}
+
+// Start starts the virtual machine named name.
+func (m *Manager) Start(name string) error {
+	machine, err := m.get(name)
+	if err != nil {
+		return err
+	}
+	return machine.Start()
+}
+
+// Stop stops the virtual machine named name, see Machine.Stop.
+func (m *Manager) Stop(ctx context.Context, name string) error {
+	machine, err := m.get(name)
+	if err != nil {
+		return err
+	}
+	return machine.Stop(ctx)
+}
+
+// StopAll stops all the running virtual machines concurrently, see
+// Machine.Stop. The returned error lists the virtual machines which failed to
+// stop.
+func (m *Manager) StopAll(ctx context.Context) error {
+	var (
+		wg       sync.WaitGroup
+		errsLock sync.Mutex
+		errs     []string
+	)
+	for _, machine := range m.List() {
+		if !machine.Running() {
+			continue
+		}
+		wg.Add(1)
+		go func(machine *Machine) {
+			defer wg.Done()
+			if err := machine.Stop(ctx); err != nil {
+				errsLock.Lock()
+				errs = append(errs, fmt.Sprintf("%s: %v", machine.Name(), err))
+				errsLock.Unlock()
+			}
+		}(machine)
+	}
+	wg.Wait()
+
+	if len(errs) != 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("failed to stop virtual machines: %s", strings.Join(errs, ", "))
+	}
+	return nil
+}
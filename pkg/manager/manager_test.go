@@ -0,0 +1,142 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/crc-org/vfkit/pkg/client"
+	vmstate "github.com/crc-org/vfkit/pkg/vm"
+	log "github.com/sirupsen/logrus"
+)
+
+// fakeVfkit writes a script which logs its arguments and runs until it gets
+// SIGTERM, or exits with an error when the command line contains 'fail'.
+func fakeVfkit(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "vfkit")
+	script := `#!/bin/sh
+trap 'echo stopping; exit 0' TERM
+echo "started with $*"
+case "$*" in *fail*) exit 3;; esac
+while true; do sleep 0.1; done
+`
+	if err := os.WriteFile(path, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// syncBuffer is a bytes.Buffer which can be written by several goroutines.
+type syncBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.String()
+}
+
+func TestManager(t *testing.T) {
+	var logs syncBuffer
+	logger := log.New()
+	logger.SetOutput(&logs)
+	manager := New(logger)
+
+	template := client.NewVirtualMachine(1, 512*1024*1024, client.NewLinuxBootloader("/vmlinuz", "console=hvc0", "/initrd"))
+	template.SetVfkitPath(fakeVfkit(t))
+	for _, name := range []string{"web", "db"} {
+		if _, err := manager.Define(name, template); err != nil {
+			t.Fatal("expected no error; got", err)
+		}
+	}
+	if _, err := manager.Define("web", template); err == nil {
+		t.Fatal("expected error for a duplicate name")
+	}
+	machines := manager.List()
+	if len(machines) != 2 || machines[0].Name() != "db" || machines[1].Name() != "web" {
+		t.Fatalf("unexpected virtual machines: %v", machines)
+	}
+
+	for _, machine := range machines {
+		if err := manager.Start(machine.Name()); err != nil {
+			t.Fatal("expected no error; got", err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	web, ok := manager.Get("web")
+	if !ok {
+		t.Fatal("expected to find the 'web' virtual machine")
+	}
+	if _, err := web.StateMachine().WaitForState(ctx, vmstate.StateRunning); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if err := web.Start(); err == nil {
+		t.Fatal("expected error starting a running virtual machine")
+	}
+	if err := manager.Remove("web"); err == nil {
+		t.Fatal("expected error removing a running virtual machine")
+	}
+
+	// SIGTERM must not be sent before the scripts install their trap
+	for !strings.Contains(logs.String(), "--name web") || !strings.Contains(logs.String(), "--name db") {
+		select {
+		case <-ctx.Done():
+			t.Fatalf("expected the vfkit output in the logs: %s", logs.String())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if err := manager.StopAll(ctx); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	for _, machine := range machines {
+		if machine.Running() || machine.State() != vmstate.StateStopped {
+			t.Fatalf("expected %s to be stopped, got %s", machine.Name(), machine.State())
+		}
+	}
+	if !strings.Contains(logs.String(), "vm=web") {
+		t.Fatalf("expected the vfkit output in the logs: %s", logs.String())
+	}
+	if err := manager.Remove("web"); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if _, ok := manager.Get("web"); ok {
+		t.Fatal("expected 'web' to be removed")
+	}
+}
+
+func TestMachineFailure(t *testing.T) {
+	logger := log.New()
+	logger.SetOutput(&syncBuffer{})
+	manager := New(logger)
+	vm := client.NewVirtualMachine(1, 512*1024*1024, client.NewLinuxBootloader("/vmlinuz", "fail", "/initrd"))
+	vm.SetVfkitPath(fakeVfkit(t))
+	machine, err := manager.Define("broken", vm)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if err := machine.Start(); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := machine.Wait(ctx); err == nil {
+		t.Fatal("expected the exit error of vfkit")
+	}
+	if machine.State() != vmstate.StateError {
+		t.Fatalf("expected the error state, got %s", machine.State())
+	}
+}
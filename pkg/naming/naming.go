@@ -0,0 +1,132 @@
+// Package naming computes the paths of the host artifacts (unix sockets, log
+// files, ...) which vfkit generates when they are not explicitly configured.
+//
+// Paths are derived from a template so that they are predictable: the same
+// template, state directory, virtual machine name and device identifier always
+// produce the same path.
+package naming
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// DefaultTemplate is the template used when none is specified.
+	DefaultTemplate = "{statedir}/{vm}/{device-id}.{ext}"
+	// DefaultVMName is the virtual machine name used when none is specified.
+	DefaultVMName = "default"
+	// InstanceID is the identifier used to name the record of the running
+	// vfkit process, see util.InstanceRecord.
+	InstanceID = "instance"
+	// SerialNumberID is the identifier used to name the file storing the
+	// serial number of the virtual machine.
+	SerialNumberID = "serial-number"
+	// MachineIdentifierID is the identifier used to name the file storing the
+	// machine identifier of the generic platform, see config.Platform.
+	MachineIdentifierID = "machine-identifier"
+)
+
+// Template generates artifact paths for a given virtual machine.
+type Template struct {
+	template string
+	stateDir string
+	vmName   string
+}
+
+// DefaultStateDir returns the directory used when none is specified,
+// $HOME/.vfkit.
+func DefaultStateDir() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".vfkit"), nil
+}
+
+// RegistryDir returns the directory where each running vfkit process
+// registers itself, $HOME/.vfkit/.instances. It does not depend on the state
+// directory of the virtual machines, so that all the vfkit processes of the
+// user can be found there.
+func RegistryDir() (string, error) {
+	stateDir, err := DefaultStateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(stateDir, ".instances"), nil
+}
+
+// NewTemplate creates a new Template. template can use the {statedir}, {vm},
+// {device-id} and {ext} placeholders. Empty arguments are replaced with their
+// default value.
+func NewTemplate(template, stateDir, vmName string) (*Template, error) {
+	if template == "" {
+		template = DefaultTemplate
+	}
+	if vmName == "" {
+		vmName = DefaultVMName
+	}
+	if stateDir == "" {
+		var err error
+		if stateDir, err = DefaultStateDir(); err != nil {
+			return nil, err
+		}
+	}
+	// without the device ID, several devices would use the same path
+	if !strings.Contains(template, "{device-id}") {
+		return nil, fmt.Errorf("naming template must contain {device-id}: %s", template)
+	}
+	if strings.ContainsAny(vmName, `/\`) {
+		return nil, fmt.Errorf("invalid virtual machine name: %s", vmName)
+	}
+
+	return &Template{
+		template: template,
+		stateDir: stateDir,
+		vmName:   vmName,
+	}, nil
+}
+
+// StateDir returns the state directory, for the artifacts which are shared by
+// all the virtual machines.
+func (t *Template) StateDir() string {
+	return t.stateDir
+}
+
+// Path returns the path of the artifact with extension ext for the device
+// identified by deviceID.
+func (t *Template) Path(deviceID, ext string) string {
+	replacer := strings.NewReplacer(
+		"{statedir}", t.stateDir,
+		"{vm}", t.vmName,
+		"{device-id}", deviceID,
+		"{ext}", ext,
+	)
+	return filepath.Clean(replacer.Replace(t.template))
+}
+
+// VsockDeviceID returns the identifier used to name the artifacts of the
+// virtio-vsock device using port.
+func VsockDeviceID(port uint) string {
+	return fmt.Sprintf("vsock-%d", port)
+}
+
+// SerialDeviceID returns the identifier used to name the artifacts of the
+// index-th virtio-serial device.
+func SerialDeviceID(index int) string {
+	return fmt.Sprintf("serial-%d", index)
+}
+
+// NamedSerialDeviceID returns the identifier used to name the artifacts of the
+// virtio-serial device with the 'id' option set to id.
+func NamedSerialDeviceID(id string) string {
+	return fmt.Sprintf("serial-%s", id)
+}
+
+// DiskDeviceID returns the identifier used to name the artifacts of the
+// index-th virtio-blk device.
+func DiskDeviceID(index int) string {
+	return fmt.Sprintf("disk-%d", index)
+}
@@ -0,0 +1,34 @@
+package naming
+
+import (
+	"testing"
+)
+
+func TestTemplatePath(t *testing.T) {
+	tmpl, err := NewTemplate("", "/var/vfkit", "myvm")
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	path := tmpl.Path(VsockDeviceID(1024), "sock")
+	if path != "/var/vfkit/myvm/vsock-1024.sock" {
+		t.Fatalf("unexpected path: %s", path)
+	}
+
+	tmpl, err = NewTemplate("{statedir}/{vm}-{device-id}.{ext}", "/var/vfkit", "")
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	path = tmpl.Path(SerialDeviceID(0), "log")
+	if path != "/var/vfkit/default-serial-0.log" {
+		t.Fatalf("unexpected path: %s", path)
+	}
+}
+
+func TestTemplateInvalid(t *testing.T) {
+	if _, err := NewTemplate("{statedir}/{vm}.sock", "/var/vfkit", "myvm"); err == nil {
+		t.Fatal("expected error for template without {device-id}")
+	}
+	if _, err := NewTemplate("", "/var/vfkit", "my/vm"); err == nil {
+		t.Fatal("expected error for invalid virtual machine name")
+	}
+}
@@ -0,0 +1,124 @@
+// Package netboot serves boot files to guests booting from the network, over
+// TFTP and HTTP. The EFI firmware of Virtualization.framework cannot boot
+// from the network, so the files are meant to be loaded by a network
+// bootloader, such as iPXE, started from a disk image.
+package netboot
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Server serves the files of a host directory over TFTP and HTTP.
+type Server struct {
+	dir string
+
+	lock         sync.Mutex
+	tftpConn     net.PacketConn
+	httpListener net.Listener
+}
+
+// NewServer creates a server for the files of dir. Its TFTP and HTTP services
+// are started with ListenTFTP and ListenHTTP.
+func NewServer(dir string) (*Server, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+
+	return &Server{dir: dir}, nil
+}
+
+// ListenTFTP starts serving the files over TFTP on the UDP address addr.
+func (s *Server) ListenTFTP(addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	s.tftpConn = conn
+	s.lock.Unlock()
+	go s.serveTFTP(conn)
+	log.Infof("netboot TFTP server listening on %s", conn.LocalAddr())
+
+	return nil
+}
+
+// ListenHTTP starts serving the files over HTTP on the TCP address addr.
+func (s *Server) ListenHTTP(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	s.httpListener = listener
+	s.lock.Unlock()
+	go func() {
+		if err := http.Serve(listener, http.FileServer(http.Dir(s.dir))); err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Warnf("netboot HTTP server stopped: %v", err)
+		}
+	}()
+	log.Infof("netboot HTTP server listening on %s", listener.Addr())
+
+	return nil
+}
+
+// TFTPAddr returns the address of the TFTP service, nil when it's not
+// started.
+func (s *Server) TFTPAddr() net.Addr {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.tftpConn == nil {
+		return nil
+	}
+	return s.tftpConn.LocalAddr()
+}
+
+// HTTPAddr returns the address of the HTTP service, nil when it's not
+// started.
+func (s *Server) HTTPAddr() net.Addr {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.httpListener == nil {
+		return nil
+	}
+	return s.httpListener.Addr()
+}
+
+// Close stops the TFTP and HTTP services. Transfers in progress are not
+// interrupted.
+func (s *Server) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var errs []error
+	if s.tftpConn != nil {
+		errs = append(errs, s.tftpConn.Close())
+	}
+	if s.httpListener != nil {
+		errs = append(errs, s.httpListener.Close())
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// path returns the host path of the requested file, which cannot be outside
+// of the served directory.
+func (s *Server) path(name string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(filepath.Clean("/"+name)))
+}
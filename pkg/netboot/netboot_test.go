@@ -0,0 +1,124 @@
+package netboot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// tftpGet downloads name from the TFTP server at addr.
+func tftpGet(t *testing.T, addr net.Addr, name string) ([]byte, error) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	defer conn.Close()
+
+	request := []byte{0, tftpOpRRQ}
+	request = append(request, name...)
+	request = append(request, 0)
+	request = append(request, "octet"...)
+	request = append(request, 0)
+	if _, err := conn.WriteTo(request, addr); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+
+	content := []byte{}
+	buf := make([]byte, tftpMaxPacketSize)
+	for {
+		if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+			t.Fatal("expected no error; got", err)
+		}
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal("expected no error; got", err)
+		}
+		switch binary.BigEndian.Uint16(buf) {
+		case tftpOpError:
+			return nil, fmt.Errorf("TFTP error %d: %s", binary.BigEndian.Uint16(buf[2:]), buf[4:n-1])
+		case tftpOpData:
+		default:
+			t.Fatalf("unexpected TFTP packet: %v", buf[:n])
+		}
+		content = append(content, buf[4:n]...)
+		ack := []byte{0, tftpOpAck, buf[2], buf[3]}
+		if _, err := conn.WriteTo(ack, from); err != nil {
+			t.Fatal("expected no error; got", err)
+		}
+		if n-4 < tftpBlockSize {
+			return content, nil
+		}
+	}
+}
+
+func newTestServer(t *testing.T) (*Server, map[string][]byte) {
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"ipxe.efi":       bytes.Repeat([]byte("a"), 1000),
+		"boot/vmlinuz":   bytes.Repeat([]byte("b"), 2*tftpBlockSize),
+		"boot/empty.cfg": {},
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal("expected no error; got", err)
+		}
+		if err := os.WriteFile(path, content, 0600); err != nil {
+			t.Fatal("expected no error; got", err)
+		}
+	}
+	server, err := NewServer(dir)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	t.Cleanup(func() { server.Close() })
+
+	return server, files
+}
+
+func TestTFTP(t *testing.T) {
+	server, files := newTestServer(t)
+	if err := server.ListenTFTP("127.0.0.1:0"); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+
+	for name, expected := range files {
+		content, err := tftpGet(t, server.TFTPAddr(), name)
+		if err != nil {
+			t.Fatal("expected no error; got", err)
+		}
+		if !bytes.Equal(content, expected) {
+			t.Fatalf("unexpected content for %s: %d bytes", name, len(content))
+		}
+	}
+	if _, err := tftpGet(t, server.TFTPAddr(), "../../etc/passwd"); err == nil {
+		t.Fatal("expected error for a file outside of the served directory")
+	}
+}
+
+func TestHTTP(t *testing.T) {
+	server, files := newTestServer(t)
+	if err := server.ListenHTTP("127.0.0.1:0"); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+
+	resp, err := http.Get(fmt.Sprintf("http://%s/boot/vmlinuz", server.HTTPAddr()))
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	defer resp.Body.Close()
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(content, files["boot/vmlinuz"]) {
+		t.Fatalf("unexpected response: %s, %d bytes", resp.Status, len(content))
+	}
+}
@@ -0,0 +1,169 @@
+package netboot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// TFTP opcodes and error codes, see RFC 1350
+const (
+	tftpOpRRQ   = 1
+	tftpOpWRQ   = 2
+	tftpOpData  = 3
+	tftpOpAck   = 4
+	tftpOpError = 5
+
+	tftpErrNotFound  = 1
+	tftpErrAccess    = 2
+	tftpErrIllegalOp = 4
+)
+
+const (
+	tftpBlockSize     = 512
+	tftpMaxPacketSize = 4 + tftpBlockSize
+	// a data packet is sent again when it's not acknowledged within
+	// tftpTimeout, the transfer fails after tftpRetransmissions attempts
+	tftpTimeout         = time.Second
+	tftpRetransmissions = 5
+)
+
+func (s *Server) serveTFTP(conn net.PacketConn) {
+	buf := make([]byte, tftpMaxPacketSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Warnf("netboot TFTP server stopped: %v", err)
+			}
+			return
+		}
+		packet := append([]byte{}, buf[:n]...)
+		go s.handleTFTPRequest(conn, addr, packet)
+	}
+}
+
+// handleTFTPRequest serves a read request. The transfer uses a new UDP port,
+// as specified by the TFTP protocol.
+func (s *Server) handleTFTPRequest(conn net.PacketConn, addr net.Addr, packet []byte) {
+	if len(packet) < 2 {
+		return
+	}
+	switch binary.BigEndian.Uint16(packet) {
+	case tftpOpRRQ:
+	case tftpOpWRQ:
+		sendTFTPError(conn, addr, tftpErrAccess, "the netboot server is read-only")
+		return
+	default:
+		sendTFTPError(conn, addr, tftpErrIllegalOp, "illegal TFTP operation")
+		return
+	}
+	fields := bytes.Split(packet[2:], []byte{0})
+	if len(fields) < 2 || len(fields[0]) == 0 {
+		sendTFTPError(conn, addr, tftpErrIllegalOp, "malformed read request")
+		return
+	}
+	// the transfer mode is ignored, files are always sent as is
+	name := string(fields[0])
+
+	host, _, err := net.SplitHostPort(conn.LocalAddr().String())
+	if err != nil {
+		return
+	}
+	transferConn, err := net.ListenPacket("udp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		log.Warnf("netboot TFTP transfer of %s failed: %v", name, err)
+		return
+	}
+	defer transferConn.Close()
+
+	file, err := os.Open(s.path(name))
+	if err != nil {
+		sendTFTPError(transferConn, addr, tftpErrNotFound, "file not found")
+		return
+	}
+	defer file.Close()
+
+	log.Debugf("netboot TFTP transfer of %s to %s", name, addr)
+	if err := sendTFTPFile(transferConn, addr, file); err != nil {
+		log.Warnf("netboot TFTP transfer of %s failed: %v", name, err)
+	}
+}
+
+// sendTFTPFile sends the content of r in data packets, waiting for the
+// acknowledgement of each of them. The transfer ends with a packet shorter
+// than the block size, which may be empty.
+func sendTFTPFile(conn net.PacketConn, addr net.Addr, r io.Reader) error {
+	data := make([]byte, tftpMaxPacketSize)
+	ack := make([]byte, tftpMaxPacketSize)
+	binary.BigEndian.PutUint16(data, tftpOpData)
+	for block := uint16(1); ; block++ {
+		n, err := io.ReadFull(r, data[4:])
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		binary.BigEndian.PutUint16(data[2:], block)
+
+		acked := false
+		for retry := 0; retry < tftpRetransmissions && !acked; retry++ {
+			if _, err := conn.WriteTo(data[:4+n], addr); err != nil {
+				return err
+			}
+			acked, err = waitTFTPAck(conn, addr, block, ack)
+			if err != nil {
+				return err
+			}
+		}
+		if !acked {
+			return errors.New("timeout waiting for acknowledgement")
+		}
+		if n < tftpBlockSize {
+			return nil
+		}
+	}
+}
+
+// waitTFTPAck waits for the acknowledgement of block from addr. It returns
+// false on timeout.
+func waitTFTPAck(conn net.PacketConn, addr net.Addr, block uint16, buf []byte) (bool, error) {
+	deadline := time.Now().Add(tftpTimeout)
+	for {
+		if err := conn.SetReadDeadline(deadline); err != nil {
+			return false, err
+		}
+		n, from, err := conn.ReadFrom(buf)
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if from.String() != addr.String() || n < 4 {
+			continue
+		}
+		switch binary.BigEndian.Uint16(buf) {
+		case tftpOpAck:
+			if binary.BigEndian.Uint16(buf[2:]) == block {
+				return true, nil
+			}
+		case tftpOpError:
+			return false, errors.New("transfer aborted by the client")
+		}
+	}
+}
+
+func sendTFTPError(conn net.PacketConn, addr net.Addr, code uint16, msg string) {
+	packet := make([]byte, 4, 4+len(msg)+1)
+	binary.BigEndian.PutUint16(packet, tftpOpError)
+	binary.BigEndian.PutUint16(packet[2:], code)
+	packet = append(packet, msg...)
+	packet = append(packet, 0)
+	_, _ = conn.WriteTo(packet, addr)
+}
@@ -0,0 +1,210 @@
+// Package output renders the results of the read-only vfkit subcommands,
+// as aligned tables for humans, with colors when writing to a terminal, or
+// as JSON, YAML or CSV for scripts.
+package output
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// Format is an output format of the subcommands.
+type Format string
+
+const (
+	FormatTable Format = "table"
+	FormatJSON  Format = "json"
+	FormatYAML  Format = "yaml"
+	FormatCSV   Format = "csv"
+)
+
+var formats = []Format{FormatTable, FormatJSON, FormatYAML, FormatCSV}
+
+// ParseFormat parses the value of the --output flag.
+func ParseFormat(str string) (Format, error) {
+	for _, format := range formats {
+		if string(format) == str {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("unknown output format '%s', expected one of table, json, yaml or csv", str)
+}
+
+// AddFlag adds the --output/-o flag to cmd, its value is stored in format.
+func AddFlag(cmd *cobra.Command, format *string) {
+	cmd.Flags().StringVarP(format, "output", "o", string(FormatTable), "output format, table, json, yaml or csv")
+}
+
+// IsTerminal returns true if w is a terminal.
+func IsTerminal(w io.Writer) bool {
+	file, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Printer writes values in the format selected with --output.
+type Printer struct {
+	out    io.Writer
+	format Format
+	color  bool
+}
+
+// NewPrinter creates a Printer writing to out in the format named format.
+// Tables are colored when out is a terminal, unless the NO_COLOR environment
+// variable is set.
+func NewPrinter(out io.Writer, format string) (*Printer, error) {
+	parsed, err := ParseFormat(format)
+	if err != nil {
+		return nil, err
+	}
+	return &Printer{
+		out:    out,
+		format: parsed,
+		color:  parsed == FormatTable && ColorEnabled(out),
+	}, nil
+}
+
+// ColorEnabled returns true if colors can be written to out: it's a terminal
+// and the NO_COLOR environment variable is not set.
+func ColorEnabled(out io.Writer) bool {
+	_, noColor := os.LookupEnv("NO_COLOR")
+	return !noColor && IsTerminal(out)
+}
+
+// Print writes v. It's encoded as is in JSON and YAML, the table and CSV
+// formats use the table returned by table. FieldTable is used when table is
+// nil.
+func (p *Printer) Print(v interface{}, table func() (*Table, error)) error {
+	switch p.format {
+	case FormatJSON:
+		encoder := json.NewEncoder(p.out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(v)
+	case FormatYAML:
+		return WriteYAML(p.out, v)
+	}
+
+	if table == nil {
+		table = func() (*Table, error) { return FieldTable(v) }
+	}
+	t, err := table()
+	if err != nil {
+		return err
+	}
+	if p.format == FormatCSV {
+		return t.WriteCSV(p.out)
+	}
+	return t.Write(p.out, p.color)
+}
+
+// Color is an ANSI terminal color.
+type Color int
+
+const (
+	NoColor Color = 0
+	Red     Color = 31
+	Green   Color = 32
+	Yellow  Color = 33
+	Gray    Color = 90
+)
+
+// StateColor returns the color of a virtual machine state in tables.
+func StateColor(state string) Color {
+	switch state {
+	case "running":
+		return Green
+	case "starting", "stopping", "paused":
+		return Yellow
+	case "error", "failed":
+		return Red
+	case "stopped":
+		return Gray
+	}
+	return NoColor
+}
+
+// Table is a list of rows with a header.
+type Table struct {
+	header []string
+	rows   [][]string
+	// ColumnColor returns the color of a cell when the table is written to
+	// a terminal, it's optional
+	ColumnColor func(column int, value string) Color
+}
+
+// NewTable creates a table with the given column names.
+func NewTable(header ...string) *Table {
+	return &Table{header: header}
+}
+
+// AddRow appends a row to the table, it must have one cell per column.
+func (t *Table) AddRow(cells ...string) {
+	t.rows = append(t.rows, cells)
+}
+
+// Write writes the table with aligned columns. The header is written in bold
+// when color is true.
+func (t *Table) Write(w io.Writer, color bool) error {
+	widths := make([]int, len(t.header))
+	for _, row := range append([][]string{t.header}, t.rows...) {
+		for i, cell := range row {
+			if i < len(widths) && len(cell) > widths[i] {
+				widths[i] = len(cell)
+			}
+		}
+	}
+	writeRow := func(row []string, isHeader bool) error {
+		line := strings.Builder{}
+		for i, cell := range row {
+			if i >= len(widths) {
+				break
+			}
+			text := cell
+			if i != len(row)-1 {
+				text += strings.Repeat(" ", widths[i]-len(cell)+3)
+			}
+			switch {
+			case !color:
+			case isHeader:
+				text = "\x1b[1m" + text + "\x1b[0m"
+			case t.ColumnColor != nil:
+				if c := t.ColumnColor(i, cell); c != NoColor {
+					text = fmt.Sprintf("\x1b[%dm%s\x1b[0m", c, text)
+				}
+			}
+			line.WriteString(text)
+		}
+		_, err := fmt.Fprintln(w, strings.TrimRight(line.String(), " "))
+		return err
+	}
+	if err := writeRow(t.header, true); err != nil {
+		return err
+	}
+	for _, row := range t.rows {
+		if err := writeRow(row, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteCSV writes the table in CSV format, with a header line.
+func (t *Table) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(t.header); err != nil {
+		return err
+	}
+	if err := writer.WriteAll(t.rows); err != nil {
+		return err
+	}
+	return writer.Error()
+}
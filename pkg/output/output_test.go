@@ -0,0 +1,122 @@
+package output
+
+import (
+	"bytes"
+	"testing"
+)
+
+type testStruct struct {
+	Name   string            `json:"name"`
+	Count  int               `json:"count"`
+	Labels map[string]string `json:"labels"`
+	Disks  []string          `json:"disks"`
+	Nested []testNested      `json:"nested"`
+	Empty  []string          `json:"empty"`
+}
+
+type testNested struct {
+	Path string `json:"path"`
+	Up   bool   `json:"up"`
+}
+
+func TestWriteYAML(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteYAML(&buf, testStruct{
+		Name:   "web",
+		Count:  2,
+		Labels: map[string]string{"team": "infra", "version": "1.0", "note": "a: b"},
+		Disks:  []string{"/vms/web.img", "true"},
+		Nested: []testNested{{Path: "/a", Up: true}, {Path: "", Up: false}},
+		Empty:  []string{},
+	})
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	expected := `name: web
+count: 2
+labels:
+  note: "a: b"
+  team: infra
+  version: "1.0"
+disks:
+  - /vms/web.img
+  - "true"
+nested:
+  - path: /a
+    up: true
+  - path: ""
+    up: false
+empty: []
+`
+	if buf.String() != expected {
+		t.Errorf("unexpected YAML output:\n%s", buf.String())
+	}
+}
+
+func TestTable(t *testing.T) {
+	table := NewTable("NAME", "STATE")
+	table.AddRow("web", "running")
+	table.AddRow("database", "stopped")
+
+	var buf bytes.Buffer
+	if err := table.Write(&buf, false); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	expected := `NAME       STATE
+web        running
+database   stopped
+`
+	if buf.String() != expected {
+		t.Errorf("unexpected table output:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	table.ColumnColor = func(column int, value string) Color {
+		if column == 1 {
+			return StateColor(value)
+		}
+		return NoColor
+	}
+	if err := table.Write(&buf, true); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte("\x1b[32mrunning\x1b[0m")) {
+		t.Errorf("running state is not green:\n%q", buf.String())
+	}
+
+	buf.Reset()
+	if err := table.WriteCSV(&buf); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if buf.String() != "NAME,STATE\nweb,running\ndatabase,stopped\n" {
+		t.Errorf("unexpected CSV output:\n%s", buf.String())
+	}
+}
+
+func TestFieldTable(t *testing.T) {
+	table, err := FieldTable(testStruct{
+		Name:   "web",
+		Count:  2,
+		Disks:  []string{"/vms/web.img"},
+		Nested: []testNested{{Path: "/a", Up: true}},
+	})
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	var buf bytes.Buffer
+	if err := table.WriteCSV(&buf); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	expected := `FIELD,VALUE
+name,web
+count,2
+labels,
+disks[0],/vms/web.img
+nested[0].path,/a
+nested[0].up,true
+empty,
+`
+	if buf.String() != expected {
+		t.Errorf("unexpected field table:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,203 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// mapItem is a key of a JSON object, the order of the keys is kept.
+type mapItem struct {
+	key   string
+	value interface{}
+}
+
+type orderedMap []mapItem
+
+// WriteYAML writes v in YAML format. v is first encoded in JSON, so the json
+// struct tags and the MarshalJSON/MarshalText methods are used, and the
+// fields are written in the same order as in JSON.
+func WriteYAML(w io.Writer, v interface{}) error {
+	value, err := toOrdered(v)
+	if err != nil {
+		return err
+	}
+	buf := bytes.Buffer{}
+	if isScalar(value) {
+		buf.WriteString(yamlScalar(value) + "\n")
+	} else {
+		writeYAMLBlock(&buf, value, 0)
+	}
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// toOrdered converts v to its JSON representation, made of orderedMap,
+// []interface{}, string, json.Number, bool and nil values.
+func toOrdered(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decodeOrdered(decoder)
+}
+
+func decodeOrdered(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token {
+	case json.Delim('{'):
+		m := orderedMap{}
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeOrdered(decoder)
+			if err != nil {
+				return nil, err
+			}
+			m = append(m, mapItem{key: key.(string), value: value})
+		}
+		// closing delimiter
+		_, err := decoder.Token()
+		return m, err
+	case json.Delim('['):
+		list := []interface{}{}
+		for decoder.More() {
+			value, err := decodeOrdered(decoder)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		_, err := decoder.Token()
+		return list, err
+	}
+	return token, nil
+}
+
+// isScalar returns true for the values written on the line of their key,
+// which include empty maps and lists.
+func isScalar(value interface{}) bool {
+	switch v := value.(type) {
+	case orderedMap:
+		return len(v) == 0
+	case []interface{}:
+		return len(v) == 0
+	}
+	return true
+}
+
+func writeYAMLBlock(buf *bytes.Buffer, value interface{}, indent int) {
+	pad := strings.Repeat(" ", indent)
+	switch v := value.(type) {
+	case orderedMap:
+		for _, item := range v {
+			buf.WriteString(pad + yamlString(item.key) + ":")
+			if isScalar(item.value) {
+				buf.WriteString(" " + yamlScalar(item.value) + "\n")
+			} else {
+				buf.WriteString("\n")
+				writeYAMLBlock(buf, item.value, indent+2)
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if isScalar(item) {
+				buf.WriteString(pad + "- " + yamlScalar(item) + "\n")
+				continue
+			}
+			// the first line of the nested block follows the dash
+			nested := bytes.Buffer{}
+			writeYAMLBlock(&nested, item, indent+2)
+			buf.WriteString(pad + "- " + strings.TrimPrefix(nested.String(), pad+"  "))
+		}
+	}
+}
+
+func yamlScalar(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(v)
+	case json.Number:
+		return v.String()
+	case string:
+		return yamlString(v)
+	case orderedMap:
+		return "{}"
+	case []interface{}:
+		return "[]"
+	}
+	return ""
+}
+
+// yamlString returns str as a plain scalar when it cannot be mistaken for
+// another type or for YAML syntax, and as a double-quoted scalar otherwise.
+func yamlString(str string) string {
+	if str == "" || strings.ContainsAny(str[:1], "-?:,[]{}#&*!|>'\"%@` ") || strings.HasSuffix(str, " ") {
+		return strconv.Quote(str)
+	}
+	for _, r := range str {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune(" _./-+=()", r) || r == ':') {
+			return strconv.Quote(str)
+		}
+	}
+	if strings.Contains(str, ": ") || strings.Contains(str, " #") || strings.HasSuffix(str, ":") {
+		return strconv.Quote(str)
+	}
+	switch strings.ToLower(str) {
+	case "true", "false", "yes", "no", "on", "off", "y", "n", "null", "~":
+		return strconv.Quote(str)
+	}
+	if _, err := strconv.ParseFloat(str, 64); err == nil {
+		return strconv.Quote(str)
+	}
+	return str
+}
+
+// FieldTable returns a FIELD/VALUE table listing the fields of the JSON
+// representation of v. The names of nested fields are joined with dots, and
+// list elements are numbered, such as "shares[0].path".
+func FieldTable(v interface{}) (*Table, error) {
+	value, err := toOrdered(v)
+	if err != nil {
+		return nil, err
+	}
+	table := NewTable("FIELD", "VALUE")
+	var addFields func(prefix string, value interface{})
+	addFields = func(prefix string, value interface{}) {
+		switch v := value.(type) {
+		case orderedMap:
+			for _, item := range v {
+				name := item.key
+				if prefix != "" {
+					name = prefix + "." + item.key
+				}
+				addFields(name, item.value)
+			}
+		case []interface{}:
+			for i, item := range v {
+				addFields(fmt.Sprintf("%s[%d]", prefix, i), item)
+			}
+		case string:
+			table.AddRow(prefix, v)
+		case nil:
+			table.AddRow(prefix, "")
+		default:
+			table.AddRow(prefix, yamlScalar(v))
+		}
+	}
+	addFields("", value)
+
+	return table, nil
+}
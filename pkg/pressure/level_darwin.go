@@ -0,0 +1,14 @@
+package pressure
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// HostLevel returns the current memory pressure level of the host.
+func HostLevel() (Level, error) {
+	level, err := unix.SysctlUint32("kern.memorystatus_vm_pressure_level")
+	if err != nil {
+		return 0, err
+	}
+	return Level(level), nil
+}
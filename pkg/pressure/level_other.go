@@ -0,0 +1,14 @@
+//go:build !darwin
+// +build !darwin
+
+package pressure
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// HostLevel returns the current memory pressure level of the host.
+func HostLevel() (Level, error) {
+	return 0, fmt.Errorf("memory pressure monitoring is not supported on %s", runtime.GOOS)
+}
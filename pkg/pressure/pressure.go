@@ -0,0 +1,162 @@
+// Package pressure pauses virtual machines when the host is low on memory.
+//
+// Each vfkit instance monitors the host memory pressure level and pauses its
+// virtual machine according to its priority: low priority virtual machines
+// are paused as soon as macOS reports memory pressure, normal priority ones
+// when the pressure becomes critical, and high priority ones are never paused.
+// Virtual machines paused this way are resumed when the pressure goes back to
+// normal.
+//
+// Pausing a virtual machine does not free its memory: it stops its vCPUs, so
+// that the guest no longer touches or allocates memory and macOS can compress
+// or swap out its pages without the guest faulting them back in.
+package pressure
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Level is a host memory pressure level, the values are the ones used by the
+// kern.memorystatus_vm_pressure_level sysctl.
+type Level uint32
+
+const (
+	LevelNormal   Level = 1
+	LevelWarning  Level = 2
+	LevelCritical Level = 4
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelNormal:
+		return "normal"
+	case LevelWarning:
+		return "warning"
+	case LevelCritical:
+		return "critical"
+	default:
+		return fmt.Sprintf("unknown (%d)", uint32(l))
+	}
+}
+
+// Priority determines at which memory pressure level a virtual machine is
+// paused.
+type Priority string
+
+const (
+	PriorityLow    Priority = "low"
+	PriorityNormal Priority = "normal"
+	PriorityHigh   Priority = "high"
+)
+
+// ParsePriority converts a priority name to a Priority.
+func ParsePriority(str string) (Priority, error) {
+	switch priority := Priority(str); priority {
+	case PriorityLow, PriorityNormal, PriorityHigh:
+		return priority, nil
+	default:
+		return "", fmt.Errorf("invalid priority '%s', must be 'low', 'normal' or 'high'", str)
+	}
+}
+
+// pauseLevel returns the memory pressure level at which a virtual machine
+// with this priority must be paused. ok is false if it must never be paused.
+func (p Priority) pauseLevel() (level Level, ok bool) {
+	switch p {
+	case PriorityLow:
+		return LevelWarning, true
+	case PriorityNormal:
+		return LevelCritical, true
+	default:
+		return 0, false
+	}
+}
+
+// ShouldPause returns true if a virtual machine with priority p must be
+// paused when the memory pressure is at level.
+func (p Priority) ShouldPause(level Level) bool {
+	pauseLevel, ok := p.pauseLevel()
+	return ok && level >= pauseLevel
+}
+
+// Actions are the callbacks the Monitor uses to pause and resume the virtual
+// machine. Running returns true when the virtual machine is running, it is
+// only paused then.
+type Actions struct {
+	Pause   func() error
+	Resume  func() error
+	Running func() bool
+}
+
+// Monitor periodically checks the host memory pressure and pauses or resumes
+// the virtual machine according to its priority.
+type Monitor struct {
+	priority  Priority
+	interval  time.Duration
+	actions   Actions
+	readLevel func() (Level, error)
+
+	paused bool
+}
+
+// NewMonitor creates a new memory pressure monitor for a virtual machine with
+// the given priority.
+func NewMonitor(priority Priority, actions Actions) *Monitor {
+	return &Monitor{
+		priority:  priority,
+		interval:  5 * time.Second,
+		actions:   actions,
+		readLevel: HostLevel,
+	}
+}
+
+func (m *Monitor) check() {
+	level, err := m.readLevel()
+	if err != nil {
+		log.Debugf("failed to get memory pressure level: %v", err)
+		return
+	}
+
+	switch {
+	case !m.paused && m.priority.ShouldPause(level):
+		if !m.actions.Running() {
+			// starting, stopped or already paused by other means
+			return
+		}
+		log.Warnf("host memory pressure is %s, pausing %s priority virtual machine", level, m.priority)
+		if err := m.actions.Pause(); err != nil {
+			log.Warnf("failed to pause virtual machine: %v", err)
+			return
+		}
+		m.paused = true
+	case m.paused && level == LevelNormal:
+		log.Infof("host memory pressure is back to normal, resuming virtual machine")
+		// the virtual machine may have been resumed by other means, don't
+		// try again on failure
+		m.paused = false
+		if err := m.actions.Resume(); err != nil {
+			log.Warnf("failed to resume virtual machine: %v", err)
+		}
+	}
+}
+
+// Run monitors the memory pressure until ctx is cancelled.
+func (m *Monitor) Run(ctx context.Context) {
+	if _, ok := m.priority.pauseLevel(); !ok {
+		return
+	}
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.check()
+		}
+	}
+}
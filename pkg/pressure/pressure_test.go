@@ -0,0 +1,54 @@
+package pressure
+
+import (
+	"testing"
+)
+
+func TestMonitor(t *testing.T) {
+	level := LevelNormal
+	paused := false
+	running := true
+	monitor := NewMonitor(PriorityNormal, Actions{
+		Pause:   func() error { paused = true; return nil },
+		Resume:  func() error { paused = false; return nil },
+		Running: func() bool { return running && !paused },
+	})
+	monitor.readLevel = func() (Level, error) { return level, nil }
+
+	for _, step := range []struct {
+		level  Level
+		paused bool
+	}{
+		{LevelWarning, false},
+		{LevelCritical, true},
+		// only resumed once the pressure is back to normal
+		{LevelWarning, true},
+		{LevelNormal, false},
+	} {
+		level = step.level
+		monitor.check()
+		if paused != step.paused {
+			t.Fatalf("memory pressure %s: expected paused=%v", level, step.paused)
+		}
+	}
+
+	// only running virtual machines are paused
+	running = false
+	level = LevelCritical
+	monitor.check()
+	if paused || monitor.paused {
+		t.Fatal("expected a stopped virtual machine not to be paused")
+	}
+}
+
+func TestPriority(t *testing.T) {
+	if _, err := ParsePriority("urgent"); err == nil {
+		t.Fatal("expected error for invalid priority")
+	}
+	if !PriorityLow.ShouldPause(LevelWarning) {
+		t.Fatal("low priority virtual machines must be paused on memory pressure warning")
+	}
+	if PriorityHigh.ShouldPause(LevelCritical) {
+		t.Fatal("high priority virtual machines must never be paused")
+	}
+}
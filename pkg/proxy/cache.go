@@ -0,0 +1,250 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// immutableExtensions are the file extensions of the packages downloaded by
+// apt, dnf, apk, npm, pip... Their URLs include the package version, so
+// their content never changes and they can be cached without expiration.
+var immutableExtensions = []string{
+	".deb", ".udeb", ".rpm", ".drpm", ".apk", ".tgz", ".whl", ".gem", ".crate", ".jar",
+}
+
+// cachedHeaders are the response headers which are stored in the cache.
+var cachedHeaders = []string{
+	"Content-Type", "Last-Modified", "ETag", "Cache-Control", "Expires",
+}
+
+// cacheMeta is stored next to each cached response body.
+type cacheMeta struct {
+	URL    string              `json:"url"`
+	Header map[string][]string `json:"header"`
+	Size   int64               `json:"size"`
+	// ExpiresAt is zero for responses which never expire
+	ExpiresAt time.Time `json:"expiresAt,omitempty"`
+}
+
+// Cache stores HTTP responses on disk. Its size is kept under a limit by
+// removing the least recently used responses.
+type Cache struct {
+	dir     string
+	maxSize int64
+
+	// evictMu serializes evictions
+	evictMu sync.Mutex
+}
+
+// NewCache creates a cache storing responses in dir, which is created if
+// needed. maxSize is the maximum size of the cached responses, 0 means no
+// limit.
+func NewCache(dir string, maxSize int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Cache{dir: dir, maxSize: maxSize}, nil
+}
+
+func cacheKey(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *Cache) paths(url string) (string, string) {
+	base := filepath.Join(c.dir, cacheKey(url))
+	return base + ".json", base + ".body"
+}
+
+// expiration returns whether the response to req can be cached, and when it
+// expires.
+func expiration(req *http.Request, resp *http.Response, now time.Time) (bool, time.Time) {
+	if req.Method != http.MethodGet || resp.StatusCode != http.StatusOK {
+		return false, time.Time{}
+	}
+	// responses to authenticated or partial requests are not shared
+	if req.Header.Get("Authorization") != "" || req.Header.Get("Range") != "" {
+		return false, time.Time{}
+	}
+	for _, directive := range strings.Split(resp.Header.Get("Cache-Control"), ",") {
+		directive = strings.TrimSpace(strings.ToLower(directive))
+		switch {
+		case directive == "no-store" || directive == "private" || directive == "no-cache":
+			return false, time.Time{}
+		case strings.HasPrefix(directive, "max-age="):
+			if maxAge, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age=")); err == nil && maxAge > 0 {
+				return true, now.Add(time.Duration(maxAge) * time.Second)
+			}
+		}
+	}
+	for _, ext := range immutableExtensions {
+		if strings.HasSuffix(req.URL.Path, ext) {
+			return true, time.Time{}
+		}
+	}
+
+	// repository metadata without caching headers must be fetched again
+	// every time to get new package versions
+	return false, time.Time{}
+}
+
+// get returns the cached response for url, or nil if there is none. The
+// caller must close the returned body.
+func (c *Cache) get(url string, now time.Time) (*cacheMeta, *os.File) {
+	metaPath, bodyPath := c.paths(url)
+	data, err := os.ReadFile(metaPath)
+	if err != nil {
+		return nil, nil
+	}
+	var meta cacheMeta
+	if err := json.Unmarshal(data, &meta); err != nil || meta.URL != url {
+		return nil, nil
+	}
+	if !meta.ExpiresAt.IsZero() && now.After(meta.ExpiresAt) {
+		c.remove(metaPath, bodyPath)
+		return nil, nil
+	}
+	body, err := os.Open(bodyPath)
+	if err != nil {
+		return nil, nil
+	}
+	// the modification time is used to find the least recently used
+	// responses
+	_ = os.Chtimes(bodyPath, now, now)
+
+	return &meta, body
+}
+
+func (c *Cache) remove(metaPath string, bodyPath string) {
+	_ = os.Remove(metaPath)
+	_ = os.Remove(bodyPath)
+}
+
+// cacheWriter stores a response body while it's sent to the guest.
+type cacheWriter struct {
+	cache *Cache
+	meta  cacheMeta
+	file  *os.File
+	err   error
+}
+
+func (c *Cache) newWriter(url string, resp *http.Response, expiresAt time.Time) (*cacheWriter, error) {
+	file, err := os.CreateTemp(c.dir, "tmp-")
+	if err != nil {
+		return nil, err
+	}
+	meta := cacheMeta{
+		URL:       url,
+		Header:    map[string][]string{},
+		ExpiresAt: expiresAt,
+	}
+	for _, key := range cachedHeaders {
+		if values := resp.Header.Values(key); len(values) != 0 {
+			meta.Header[key] = values
+		}
+	}
+
+	return &cacheWriter{cache: c, meta: meta, file: file}, nil
+}
+
+func (w *cacheWriter) Write(data []byte) (int, error) {
+	// a cache error must not interrupt the download
+	if w.err == nil {
+		var n int
+		n, w.err = w.file.Write(data)
+		w.meta.Size += int64(n)
+	}
+	return len(data), nil
+}
+
+// commit adds the response to the cache if it was fully received, which is
+// the case when expectedSize, the Content-Length of the response, is -1 or
+// the size of the response. The temporary file is removed otherwise.
+func (w *cacheWriter) commit(complete bool, expectedSize int64) {
+	defer os.Remove(w.file.Name())
+	if err := w.file.Close(); err != nil && w.err == nil {
+		w.err = err
+	}
+	if !complete || w.err != nil || (expectedSize >= 0 && expectedSize != w.meta.Size) {
+		return
+	}
+
+	data, err := json.Marshal(w.meta)
+	if err != nil {
+		return
+	}
+	metaPath, bodyPath := w.cache.paths(w.meta.URL)
+	if err := os.Rename(w.file.Name(), bodyPath); err != nil {
+		log.Debugf("failed to add %s to the proxy cache: %v", w.meta.URL, err)
+		return
+	}
+	if err := os.WriteFile(metaPath, data, 0600); err != nil {
+		log.Debugf("failed to add %s to the proxy cache: %v", w.meta.URL, err)
+		w.cache.remove(metaPath, bodyPath)
+		return
+	}
+	w.cache.evict()
+}
+
+// evict removes the least recently used responses until the cache is smaller
+// than its maximum size.
+func (c *Cache) evict() {
+	if c.maxSize <= 0 {
+		return
+	}
+	c.evictMu.Lock()
+	defer c.evictMu.Unlock()
+
+	bodies, err := filepath.Glob(filepath.Join(c.dir, "*.body"))
+	if err != nil {
+		return
+	}
+	infos := []os.FileInfo{}
+	var total int64
+	for _, body := range bodies {
+		info, err := os.Stat(body)
+		if err != nil {
+			continue
+		}
+		infos = append(infos, info)
+		total += info.Size()
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().Before(infos[j].ModTime())
+	})
+	for _, info := range infos {
+		if total <= c.maxSize {
+			break
+		}
+		base := strings.TrimSuffix(filepath.Join(c.dir, info.Name()), ".body")
+		c.remove(base+".json", base+".body")
+		total -= info.Size()
+	}
+}
+
+// copyCached sends the cached body to w.
+func copyCached(w http.ResponseWriter, meta *cacheMeta, body io.Reader) error {
+	for key, values := range meta.Header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(meta.Size, 10))
+	w.Header().Set("X-Cache", "HIT")
+	w.WriteHeader(http.StatusOK)
+	_, err := io.Copy(w, body)
+
+	return err
+}
@@ -0,0 +1,188 @@
+// Package proxy implements an HTTP proxy with a disk cache, which virtual
+// machines use to share the packages they download.
+//
+// Plain HTTP GET responses are cached according to their Cache-Control
+// header, and packages (.deb, .rpm, .tgz...) are cached without expiration as
+// their URLs contain their version. HTTPS requests are tunneled with CONNECT
+// and cannot be cached.
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// hopHeaders are the headers which only apply to a single connection and must
+// not be forwarded, see RFC 7230 section 6.1.
+var hopHeaders = []string{
+	"Connection", "Proxy-Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+// Stats are the cache statistics of the proxy.
+type Stats struct {
+	Hits   uint64
+	Misses uint64
+}
+
+// Proxy is an http.Handler implementing a caching forward proxy.
+type Proxy struct {
+	cache     *Cache
+	allowed   []*net.IPNet
+	transport http.RoundTripper
+	now       func() time.Time
+
+	hits   uint64
+	misses uint64
+}
+
+// New creates a proxy storing its cache in cache. Only clients with an
+// address in one of the allowed networks can use it.
+func New(cache *Cache, allowed []*net.IPNet) *Proxy {
+	return &Proxy{
+		cache:   cache,
+		allowed: allowed,
+		// the host itself can be behind a proxy
+		transport: &http.Transport{Proxy: http.ProxyFromEnvironment},
+		now:       time.Now,
+	}
+}
+
+// Stats returns the number of requests served from the cache, and of
+// cacheable requests which were not.
+func (p *Proxy) Stats() Stats {
+	return Stats{
+		Hits:   atomic.LoadUint64(&p.hits),
+		Misses: atomic.LoadUint64(&p.misses),
+	}
+}
+
+func (p *Proxy) clientAllowed(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	for _, network := range p.allowed {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !p.clientAllowed(r.RemoteAddr) {
+		http.Error(w, "proxy access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method == http.MethodConnect {
+		p.tunnel(w, r)
+		return
+	}
+	if !r.URL.IsAbs() {
+		http.Error(w, "this is a proxy, requests must use absolute URLs", http.StatusBadRequest)
+		return
+	}
+
+	url := r.URL.String()
+	if r.Method == http.MethodGet && r.Header.Get("Range") == "" {
+		if meta, body := p.cache.get(url, p.now()); meta != nil {
+			defer body.Close()
+			atomic.AddUint64(&p.hits, 1)
+			if err := copyCached(w, meta, body); err != nil {
+				log.Debugf("proxy: failed to send cached %s: %v", url, err)
+			}
+			return
+		}
+	}
+
+	outReq := r.Clone(r.Context())
+	outReq.RequestURI = ""
+	for _, header := range hopHeaders {
+		outReq.Header.Del(header)
+	}
+	resp, err := p.transport.RoundTrip(outReq)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	for _, header := range hopHeaders {
+		resp.Header.Del(header)
+	}
+	for key, values := range resp.Header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+
+	var body io.Reader = resp.Body
+	var writer *cacheWriter
+	if cacheable, expiresAt := expiration(r, resp, p.now()); cacheable {
+		atomic.AddUint64(&p.misses, 1)
+		if writer, err = p.cache.newWriter(url, resp, expiresAt); err != nil {
+			log.Debugf("proxy: cannot cache %s: %v", url, err)
+		} else {
+			body = io.TeeReader(resp.Body, writer)
+		}
+		w.Header().Set("X-Cache", "MISS")
+	}
+	w.WriteHeader(resp.StatusCode)
+	_, err = io.Copy(w, body)
+	if writer != nil {
+		writer.commit(err == nil, resp.ContentLength)
+	}
+	if err != nil {
+		log.Debugf("proxy: failed to send %s: %v", url, err)
+	}
+}
+
+// tunnel handles CONNECT requests, which are used for HTTPS.
+func (p *Proxy) tunnel(w http.ResponseWriter, r *http.Request) {
+	upstream, err := net.DialTimeout("tcp", r.Host, 30*time.Second)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer upstream.Close()
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "tunneling not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, buffered, err := hijacker.Hijack()
+	if err != nil {
+		log.Debugf("proxy: %v", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := fmt.Fprint(conn, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+		return
+	}
+
+	done := make(chan struct{}, 2)
+	go func() {
+		// data sent by the client before the tunnel was established
+		_, _ = io.Copy(upstream, buffered)
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(conn, upstream)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// Serve accepts connections on listener and serves the proxy on them. It
+// returns when listener is closed.
+func (p *Proxy) Serve(listener net.Listener) error {
+	return http.Serve(listener, p)
+}
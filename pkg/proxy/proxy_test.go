@@ -0,0 +1,136 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func newTestProxy(t *testing.T, maxSize int64) (*Proxy, *http.Client) {
+	cache, err := NewCache(t.TempDir(), maxSize)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	p := New(cache, []*net.IPNet{loopback})
+	p.transport = http.DefaultTransport
+	server := httptest.NewServer(p)
+	t.Cleanup(server.Close)
+
+	proxyURL, _ := url.Parse(server.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	return p, client
+}
+
+func get(t *testing.T, client *http.Client, url string) (string, string) {
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	return string(body), resp.Header.Get("X-Cache")
+}
+
+func TestProxyCache(t *testing.T) {
+	requests := map[string]int{}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests[r.URL.Path]++
+		if r.URL.Path == "/static" {
+			w.Header().Set("Cache-Control", "public, max-age=60")
+		}
+		fmt.Fprintf(w, "%s %d", r.URL.Path, requests[r.URL.Path])
+	}))
+	defer upstream.Close()
+
+	p, client := newTestProxy(t, 0)
+	now := time.Now()
+	p.now = func() time.Time { return now }
+
+	for _, test := range []struct {
+		path  string
+		body  string
+		cache string
+	}{
+		{"/pool/main/h/hello_2.10-2_amd64.deb", "/pool/main/h/hello_2.10-2_amd64.deb 1", "MISS"},
+		{"/pool/main/h/hello_2.10-2_amd64.deb", "/pool/main/h/hello_2.10-2_amd64.deb 1", "HIT"},
+		// repository metadata is not cached
+		{"/dists/stable/InRelease", "/dists/stable/InRelease 1", ""},
+		{"/dists/stable/InRelease", "/dists/stable/InRelease 2", ""},
+		{"/static", "/static 1", "MISS"},
+		{"/static", "/static 1", "HIT"},
+	} {
+		body, cache := get(t, client, upstream.URL+test.path)
+		if body != test.body || cache != test.cache {
+			t.Fatalf("unexpected response for %s: '%s' (X-Cache: '%s')", test.path, body, cache)
+		}
+	}
+
+	// max-age expired
+	now = now.Add(2 * time.Minute)
+	if body, _ := get(t, client, upstream.URL+"/static"); body != "/static 2" {
+		t.Fatalf("expired response was used: %s", body)
+	}
+	if stats := p.Stats(); stats.Hits != 2 || stats.Misses != 3 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestProxyEviction(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "0123456789")
+	}))
+	defer upstream.Close()
+
+	p, client := newTestProxy(t, 25)
+	now := time.Now()
+	p.now = func() time.Time { return now }
+
+	for _, name := range []string{"a.rpm", "b.rpm", "a.rpm", "c.rpm"} {
+		now = now.Add(time.Second)
+		get(t, client, upstream.URL+"/"+name)
+	}
+	// b.rpm is the least recently used package, it's checked last as
+	// fetching it again evicts another package
+	for _, test := range []struct {
+		name     string
+		expected string
+	}{
+		{"a.rpm", "HIT"},
+		{"c.rpm", "HIT"},
+		{"b.rpm", "MISS"},
+	} {
+		if _, cache := get(t, client, upstream.URL+"/"+test.name); cache != test.expected {
+			t.Errorf("unexpected X-Cache for %s: %s", test.name, cache)
+		}
+	}
+}
+
+func TestProxyDenied(t *testing.T) {
+	cache, err := NewCache(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	_, network, _ := net.ParseCIDR("192.168.64.0/24")
+	server := httptest.NewServer(New(cache, []*net.IPNet{network}))
+	defer server.Close()
+
+	proxyURL, _ := url.Parse(server.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	resp, err := client.Get("http://example.com/")
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	}
+}
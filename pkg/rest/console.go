@@ -0,0 +1,127 @@
+package rest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	// defaultConsoleLines is the number of lines returned by /vm/console
+	// when the lines parameter is not set
+	defaultConsoleLines = 100
+	// maxConsoleTail bounds the part of the console log which is read to
+	// find the last lines
+	maxConsoleTail = 1024 * 1024
+	// consolePollInterval is how often the console log is checked for new
+	// output when following it
+	consolePollInterval = 200 * time.Millisecond
+)
+
+// SetConsoleLog enables the /vm/console endpoint, which returns the end of
+// the serial console output logged to path and can follow it.
+func (s *Server) SetConsoleLog(path string) {
+	s.consoleLog = path
+	s.mux.HandleFunc("/vm/console", s.handleConsole)
+}
+
+// tailLines returns the last n lines of data, which is the end of a file when
+// truncated is true. The first, partial, line of a truncated file is skipped.
+func tailLines(data []byte, n int, truncated bool) []byte {
+	if truncated {
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			data = data[i+1:]
+		}
+	}
+	end := len(data)
+	// a trailing newline ends the last line, it does not start a new one
+	if end > 0 && data[end-1] == '\n' {
+		end--
+	}
+	for i := end - 1; i >= 0; i-- {
+		if data[i] == '\n' {
+			n--
+			if n == 0 {
+				return data[i+1:]
+			}
+		}
+	}
+	return data
+}
+
+func (s *Server) handleConsole(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("unsupported method %s", r.Method))
+		return
+	}
+	lines := defaultConsoleLines
+	if str := r.URL.Query().Get("lines"); str != "" {
+		var err error
+		if lines, err = strconv.Atoi(str); err != nil || lines < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid number of lines: %s", str))
+			return
+		}
+	}
+	follow := r.URL.Query().Get("follow") == "true"
+
+	file, err := os.Open(s.consoleLog)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer file.Close()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	offset := size - maxConsoleTail
+	if offset < 0 {
+		offset = 0
+	}
+	data := make([]byte, size-offset)
+	if _, err := file.ReadAt(data, offset); err != nil && err != io.EOF {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if lines != 0 {
+		if _, err := w.Write(tailLines(data, lines, offset != 0)); err != nil {
+			return
+		}
+	}
+	if !follow {
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, 32*1024)
+	ticker := time.NewTicker(consolePollInterval)
+	defer ticker.Stop()
+	for {
+		if flusher != nil {
+			flusher.Flush()
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+		for {
+			n, err := file.Read(buf)
+			if n > 0 {
+				if _, err := w.Write(buf[:n]); err != nil {
+					return
+				}
+			}
+			if err != nil {
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,283 @@
+// Package define contains the definitions shared by the vfkit REST API server
+// and its clients.
+package define
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/crc-org/vfkit/pkg/vm"
+)
+
+// StateResponse is returned by the /vm/state endpoint.
+type StateResponse struct {
+	State vm.State `json:"state"`
+}
+
+// StateRequest is sent to the /vm/state endpoint to change the virtual machine
+// state.
+type StateRequest struct {
+	State vm.State `json:"state"`
+	// Force skips the graceful guest shutdown when State is 'stopped'
+	Force bool `json:"force,omitempty"`
+}
+
+// InspectResponse is returned by the /vm/inspect endpoint.
+type InspectResponse struct {
+	State vm.State `json:"state"`
+	// MACAddress is the MAC address of the virtio-net device
+	MACAddress string `json:"macAddress,omitempty"`
+	// GuestIP is the address found for MACAddress in the DHCP leases of
+	// the host, it's empty until the guest network is up
+	GuestIP string `json:"guestIP,omitempty"`
+	// Config is the effective configuration of the virtual machine
+	Config *VMConfig `json:"config,omitempty"`
+}
+
+// VMConfig is the effective configuration of a virtual machine: the command
+// line configuration completed with the values generated by vfkit, such as
+// MAC addresses and host artifact paths.
+type VMConfig struct {
+	Vcpus       uint           `json:"vcpus"`
+	MemoryBytes uint64         `json:"memoryBytes"`
+	Bootloader  DeviceConfig   `json:"bootloader"`
+	Devices     []DeviceConfig `json:"devices"`
+	// Listeners are the host sockets vfkit listens on for the virtual
+	// machine
+	Listeners []Listener `json:"listeners,omitempty"`
+}
+
+// DeviceConfig is the configuration of a bootloader or device. Type and the
+// keys of Options are the names used on the vfkit command line, options
+// without value have an empty value.
+type DeviceConfig struct {
+	Type    string            `json:"type"`
+	Options map[string]string `json:"options,omitempty"`
+}
+
+// Listener is a host socket vfkit listens on.
+type Listener struct {
+	// Kind is what the socket is used for: "rest", "vsock", "publish",
+	// "gvproxy", "console" or "pty"
+	Kind string `json:"kind"`
+	// Address is a host:port TCP address or the path of a unix socket, or
+	// of the terminal for "pty"
+	Address string `json:"address"`
+}
+
+// ErrorResponse is returned by all endpoints when an error occurs.
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// ParseRestfulURI validates uri and returns the network and address to listen
+// on. Supported URIs are tcp://host:port and unix:///path/to/socket.
+func ParseRestfulURI(uri string) (string, string, error) {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return "", "", err
+	}
+	switch parsed.Scheme {
+	case "tcp":
+		if parsed.Host == "" {
+			return "", "", fmt.Errorf("missing host in REST API URI: %s", uri)
+		}
+		return "tcp", parsed.Host, nil
+	case "unix":
+		path := parsed.Path
+		if path == "" {
+			path = parsed.Opaque
+		}
+		if path == "" {
+			return "", "", fmt.Errorf("missing socket path in REST API URI: %s", uri)
+		}
+		return "unix", path, nil
+	default:
+		return "", "", fmt.Errorf("unsupported scheme for REST API URI: %s", uri)
+	}
+}
+
+// Stats is returned by the /vm/stats endpoint. Resource usage is measured on
+// the vfkit process, energy includes the work done on its behalf by
+// Virtualization.framework helper processes.
+type Stats struct {
+	// CPUTimeSeconds is the CPU time used by the vfkit process
+	CPUTimeSeconds float64 `json:"cpuTimeSeconds"`
+	// EnergyJoules is the energy used since vfkit started
+	EnergyJoules float64 `json:"energyJoules"`
+	// AveragePowerWatts is the average power used since vfkit started
+	AveragePowerWatts float64 `json:"averagePowerWatts"`
+	// PowerWatts is the average power used during the last 10 seconds
+	PowerWatts float64 `json:"powerWatts"`
+	// MemoryFootprintBytes is the physical memory footprint of the vfkit process
+	MemoryFootprintBytes uint64 `json:"memoryFootprintBytes"`
+	// ResidentMemoryBytes is the resident memory of the vfkit process,
+	// including the pages shared with other processes
+	ResidentMemoryBytes uint64 `json:"residentMemoryBytes"`
+	// DiskReadBytes and DiskWrittenBytes are the storage I/O of the vfkit
+	// process, mostly the guest disk images
+	DiskReadBytes    uint64 `json:"diskReadBytes"`
+	DiskWrittenBytes uint64 `json:"diskWrittenBytes"`
+	// HostCompressor describes the memory compressor of the host, it is
+	// omitted when its statistics are not available
+	HostCompressor *CompressorStats `json:"hostCompressor,omitempty"`
+	// Shares is the disk usage of the host volumes backing the virtio-fs
+	// shares
+	Shares []ShareUsage `json:"shares,omitempty"`
+}
+
+// CompressorStats describes the memory compressor of the host. macOS has no
+// page deduplication across processes, the compressor is the mechanism which
+// reduces the memory used by idle and redundant guest pages.
+type CompressorStats struct {
+	// UncompressedBytes is the size of the pages stored in the compressor
+	UncompressedBytes uint64 `json:"uncompressedBytes"`
+	// CompressedBytes is the physical memory used to store them
+	CompressedBytes uint64 `json:"compressedBytes"`
+	// SavedBytes is the memory saved by the compressor
+	SavedBytes uint64 `json:"savedBytes"`
+}
+
+// ShareUsage is the disk usage of the host volume of a virtio-fs share.
+type ShareUsage struct {
+	MountTag  string `json:"mountTag"`
+	SharedDir string `json:"sharedDir"`
+	// TotalBytes is the size of the host volume
+	TotalBytes uint64 `json:"totalBytes"`
+	// AvailableBytes is the free space usable by the vfkit process
+	AvailableBytes uint64 `json:"availableBytes"`
+}
+
+// ScheduleResponse is returned by the /vm/schedule endpoint.
+type ScheduleResponse struct {
+	// Entries lists the schedule entries, such as "stop=0 22 * * *"
+	Entries []string `json:"entries"`
+	// NextAction is the next action the scheduler will run, if any
+	NextAction string `json:"nextAction,omitempty"`
+	// NextActionTime is when NextAction will run
+	NextActionTime *time.Time `json:"nextActionTime,omitempty"`
+	// SuspendedUntil is set when the schedule is suspended
+	SuspendedUntil *time.Time `json:"suspendedUntil,omitempty"`
+}
+
+// ScheduleRequest is the body of POST requests to the /vm/schedule endpoint.
+type ScheduleRequest struct {
+	// SuspendUntil disables the scheduled actions until the given time. The
+	// zero time resumes the schedule.
+	SuspendUntil *time.Time `json:"suspendUntil,omitempty"`
+	// SkipNext cancels the next scheduled action
+	SkipNext bool `json:"skipNext,omitempty"`
+}
+
+// Event is a lifecycle or device event of the virtual machine, as returned by
+// the /vm/events endpoint.
+type Event struct {
+	// ID increases with each event, it can be used to only get the events
+	// which happened after a previous request
+	ID      uint64    `json:"id"`
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"`
+	Message string    `json:"message,omitempty"`
+	// Details is any JSON value giving more information about the event,
+	// such as the ID and path of an attached disk
+	Details interface{} `json:"details,omitempty"`
+}
+
+// VsockForward is a mapping between a vsock port and a host unix socket, as
+// returned by the /vm/vsock/forwards endpoint.
+type VsockForward struct {
+	Port      uint   `json:"port"`
+	SocketURL string `json:"socketURL"`
+	// Listen is true when the guest connects to the host, and false when
+	// the host connects to the guest
+	Listen bool `json:"listen"`
+}
+
+// Share is a host directory shared with the guest through virtio-fs, as
+// returned by the /vm/shares endpoint.
+type Share struct {
+	SharedDir string `json:"sharedDir"`
+	MountTag  string `json:"mountTag"`
+}
+
+// Disk is a disk image attached to the guest as a virtio-blk device, as
+// returned by the /vm/disks endpoint.
+type Disk struct {
+	// ID identifies the disk in /vm/disks/<id>, the disks configured on
+	// the command line are named disk0, disk1, ... in their order
+	ID        string `json:"id"`
+	ImagePath string `json:"imagePath"`
+}
+
+// Snapshot is a point-in-time copy of a disk image, as returned by the
+// /vm/disks/<id>/snapshots endpoint.
+type Snapshot struct {
+	ID string `json:"id"`
+	// Name is an optional description given when the snapshot was created
+	Name      string    `json:"name,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	// SizeBytes is the size of the disk image when the snapshot was taken
+	SizeBytes uint64 `json:"sizeBytes"`
+}
+
+// SnapshotRequest is the body of POST requests to the
+// /vm/disks/<id>/snapshots endpoint.
+type SnapshotRequest struct {
+	Name string `json:"name,omitempty"`
+}
+
+// Resources are the virtual CPUs and memory of the virtual machine, returned
+// by the /vm/resources endpoint.
+type Resources struct {
+	CPUs        uint   `json:"cpus,omitempty"`
+	MemoryBytes uint64 `json:"memoryBytes,omitempty"`
+}
+
+// ErrNotSupported is returned when an operation is not supported by vfkit or
+// by the host. The REST API reports it with a 501 status code.
+var ErrNotSupported = errors.New("operation not supported")
+
+// ErrInvalidState is returned when an operation is not possible in the
+// current state of the virtual machine. The REST API reports it with a 409
+// status code.
+var ErrInvalidState = errors.New("operation not possible in the current virtual machine state")
+
+// EgressPolicy is the egress policy of the virtual machine, as returned and
+// accepted by the /vm/egress endpoint. The rules use the same format as the
+// --egress command line argument.
+type EgressPolicy struct {
+	// Default is 'allow' or 'deny'
+	Default string   `json:"default"`
+	Rules   []string `json:"rules"`
+}
+
+// ExecRequest is the body of POST requests to the /vm/exec endpoint. The
+// command is run in the guest by the vfkit guest agent.
+type ExecRequest struct {
+	// Command is the program to run, followed by its arguments
+	Command []string `json:"command"`
+	// Env are additional environment variables in the KEY=value format
+	Env []string `json:"env,omitempty"`
+	// Dir is the working directory of the command
+	Dir string `json:"dir,omitempty"`
+	// Stdin is sent to the standard input of the command
+	Stdin []byte `json:"stdin,omitempty"`
+	// TimeoutSeconds kills the command if it runs for longer, 0 means no
+	// timeout
+	TimeoutSeconds uint `json:"timeoutSeconds,omitempty"`
+}
+
+// ExecEvent is a line of the newline-delimited JSON stream returned by the
+// /vm/exec endpoint. The output of the command is sent while it runs, the
+// last event has the exit code, or an error.
+type ExecEvent struct {
+	Stdout []byte `json:"stdout,omitempty"`
+	Stderr []byte `json:"stderr,omitempty"`
+	// ExitCode is set when the command exited
+	ExitCode *int `json:"exitCode,omitempty"`
+	// Error is set when the output of the command could not be read
+	// until its end
+	Error string `json:"error,omitempty"`
+}
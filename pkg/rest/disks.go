@@ -0,0 +1,50 @@
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/crc-org/vfkit/pkg/rest/define"
+)
+
+// DiskManager is the interface the REST API uses to list the virtio-blk
+// disks of the virtual machine.
+type DiskManager interface {
+	Disks() []define.Disk
+}
+
+const disksPath = "/vm/disks"
+
+// SetDiskManager enables the /vm/disks endpoint listing the virtio-blk disks,
+// and the /vm/disks/<id>/snapshots endpoints when a snapshot manager is set.
+func (s *Server) SetDiskManager(disks DiskManager) {
+	s.disks = disks
+	s.mux.HandleFunc(disksPath, s.handleDisks)
+	s.mux.HandleFunc(disksPath+"/", s.handleDisk)
+}
+
+func (s *Server) handleDisks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("unsupported method %s", r.Method))
+		return
+	}
+	writeJSON(w, http.StatusOK, s.disks.Disks())
+}
+
+// handleDisk handles /vm/disks/<id>/snapshots/...
+func (s *Server) handleDisk(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, disksPath+"/")
+	i := strings.Index(id, "/")
+	if i == -1 {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown path %s", r.URL.Path))
+		return
+	}
+	subPath := id[i+1:]
+	id = id[:i]
+	if subPath != "snapshots" && !strings.HasPrefix(subPath, "snapshots/") {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown path %s", r.URL.Path))
+		return
+	}
+	s.handleSnapshots(w, r, id, strings.TrimPrefix(subPath, "snapshots"))
+}
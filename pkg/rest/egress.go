@@ -0,0 +1,66 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/crc-org/vfkit/pkg/egress"
+	"github.com/crc-org/vfkit/pkg/rest/define"
+)
+
+// EgressController is the interface the REST API uses to query and change the
+// egress policy of the virtual machine, it's implemented by egress.Enforcer.
+type EgressController interface {
+	Policy() *egress.Policy
+	SetPolicy(policy *egress.Policy) error
+}
+
+// SetEgressController enables the /vm/egress endpoint to query and change the
+// egress rules of the virtual machine at runtime.
+func (s *Server) SetEgressController(controller EgressController) {
+	s.egress = controller
+	s.mux.HandleFunc("/vm/egress", s.handleEgress)
+}
+
+func (s *Server) egressResponse() define.EgressPolicy {
+	policy := s.egress.Policy()
+	resp := define.EgressPolicy{
+		Default: string(policy.Default),
+		Rules:   []string{},
+	}
+	for _, rule := range policy.Rules {
+		resp.Rules = append(resp.Rules, rule.String())
+	}
+
+	return resp
+}
+
+func (s *Server) handleEgress(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var req define.EgressPolicy
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		entries := req.Rules
+		if req.Default != "" {
+			entries = append([]string{"default=" + req.Default}, entries...)
+		}
+		policy, err := egress.ParsePolicy(entries)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := s.egress.SetPolicy(policy); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("unsupported method %s", r.Method))
+		return
+	}
+	writeJSON(w, http.StatusOK, s.egressResponse())
+}
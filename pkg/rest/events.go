@@ -0,0 +1,34 @@
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/crc-org/vfkit/pkg/events"
+)
+
+// SetEventLog enables the /vm/events endpoint to query the lifecycle and
+// device events recorded in eventLog.
+func (s *Server) SetEventLog(eventLog *events.Log) {
+	s.events = eventLog
+	s.mux.HandleFunc("/vm/events", s.handleEvents)
+}
+
+// handleEvents handles /vm/events?since=<id>&type=<type>
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("unsupported method %s", r.Method))
+		return
+	}
+	var since uint64
+	if sinceStr := r.URL.Query().Get("since"); sinceStr != "" {
+		var err error
+		if since, err = strconv.ParseUint(sinceStr, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid 'since' event ID: %s", sinceStr))
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, s.events.Events(since, r.URL.Query().Get("type")))
+}
@@ -0,0 +1,98 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/crc-org/vfkit/pkg/rest/define"
+	log "github.com/sirupsen/logrus"
+)
+
+// GuestExecutor is the interface the REST API uses to run commands in the
+// guest.
+type GuestExecutor interface {
+	// Exec runs a command in the guest, its output is written to stdout
+	// and stderr while it runs. It returns the exit code of the command.
+	Exec(ctx context.Context, req define.ExecRequest, stdout io.Writer, stderr io.Writer) (int, error)
+}
+
+// SetGuestExecutor enables the /vm/exec endpoint, which runs commands in the
+// guest, when the REST API is served on a unix socket.
+func (s *Server) SetGuestExecutor(executor GuestExecutor) {
+	s.executor = executor
+	s.handleUnixOnly("/vm/exec", s.handleExec)
+}
+
+func (s *Server) handleExec(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("unsupported method %s", r.Method))
+		return
+	}
+	var req define.ExecRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(req.Command) == 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("missing command"))
+		return
+	}
+
+	events := &execEventWriter{w: w}
+	exitCode, err := s.executor.Exec(r.Context(), req, events.stream(false), events.stream(true))
+	if err != nil {
+		if !events.started {
+			writeError(w, errorStatus(err, http.StatusBadGateway), err)
+			return
+		}
+		events.send(define.ExecEvent{Error: err.Error()})
+		return
+	}
+	events.send(define.ExecEvent{ExitCode: &exitCode})
+}
+
+// execEventWriter sends the /vm/exec events, the response status is sent with
+// the first event.
+type execEventWriter struct {
+	w       http.ResponseWriter
+	started bool
+}
+
+func (ew *execEventWriter) send(event define.ExecEvent) {
+	if !ew.started {
+		ew.w.Header().Set("Content-Type", "application/x-ndjson")
+		ew.w.WriteHeader(http.StatusOK)
+		ew.started = true
+	}
+	if err := json.NewEncoder(ew.w).Encode(event); err != nil {
+		log.Debugf("error writing exec event: %v", err)
+		return
+	}
+	if flusher, ok := ew.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// stream returns a writer sending the data written to it as stdout or stderr
+// events.
+func (ew *execEventWriter) stream(stderr bool) io.Writer {
+	return execStreamWriter{ew: ew, stderr: stderr}
+}
+
+type execStreamWriter struct {
+	ew     *execEventWriter
+	stderr bool
+}
+
+func (sw execStreamWriter) Write(p []byte) (int, error) {
+	data := append([]byte{}, p...)
+	if sw.stderr {
+		sw.ew.send(define.ExecEvent{Stderr: data})
+	} else {
+		sw.ew.send(define.ExecEvent{Stdout: data})
+	}
+	return len(p), nil
+}
@@ -0,0 +1,101 @@
+package rest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// GuestFileManager is the interface the REST API uses to copy files to and
+// from the guest.
+type GuestFileManager interface {
+	// CopyTo copies the content of r to the file at guestPath, which is
+	// created with mode if it does not exist.
+	CopyTo(ctx context.Context, r io.Reader, guestPath string, mode os.FileMode) error
+	// CopyFrom copies the content of the file at guestPath to w.
+	CopyFrom(ctx context.Context, guestPath string, w io.Writer) error
+}
+
+// fileTransferTimeout bounds the duration of a file transfer.
+const fileTransferTimeout = 10 * time.Minute
+
+// SetGuestFileManager enables the /vm/files endpoint, which copies files to
+// and from the guest, when the REST API is served on a unix socket.
+func (s *Server) SetGuestFileManager(files GuestFileManager) {
+	s.files = files
+	s.handleUnixOnly("/vm/files", s.handleFiles)
+}
+
+// handleFiles handles /vm/files?path=<guest path>. GET returns the content of
+// the guest file, PUT replaces it with the request body.
+func (s *Server) handleFiles(w http.ResponseWriter, r *http.Request) {
+	guestPath := r.URL.Query().Get("path")
+	if guestPath == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("missing path parameter"))
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), fileTransferTimeout)
+	defer cancel()
+
+	switch r.Method {
+	case http.MethodGet:
+		out := &responseStarter{w: w}
+		if err := s.files.CopyFrom(ctx, guestPath, out); err != nil {
+			if out.started {
+				// the status was already sent, abort the response
+				// so that the client sees a truncated transfer
+				log.Warnf("error copying %s from the guest: %v", guestPath, err)
+				panic(http.ErrAbortHandler)
+			}
+			writeError(w, errorStatus(err, http.StatusBadGateway), err)
+			return
+		}
+		if !out.started {
+			// empty file
+			out.start()
+		}
+	case http.MethodPut:
+		mode := os.FileMode(0644)
+		if str := r.URL.Query().Get("mode"); str != "" {
+			perm, err := strconv.ParseUint(str, 8, 32)
+			if err != nil || perm > 0777 {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid mode: %s", str))
+				return
+			}
+			mode = os.FileMode(perm)
+		}
+		if err := s.files.CopyTo(ctx, r.Body, guestPath, mode); err != nil {
+			writeError(w, errorStatus(err, http.StatusBadGateway), err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("unsupported method %s", r.Method))
+	}
+}
+
+// responseStarter sends the status of a successful file download before the
+// first chunk of the file is written.
+type responseStarter struct {
+	w       http.ResponseWriter
+	started bool
+}
+
+func (rs *responseStarter) start() {
+	rs.w.Header().Set("Content-Type", "application/octet-stream")
+	rs.w.WriteHeader(http.StatusOK)
+	rs.started = true
+}
+
+func (rs *responseStarter) Write(p []byte) (int, error) {
+	if !rs.started {
+		rs.start()
+	}
+	return rs.w.Write(p)
+}
@@ -0,0 +1,50 @@
+package rest
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/crc-org/vfkit/pkg/rest/define"
+)
+
+// SetGuestIPLookup adds the guest network information to the /vm/inspect
+// endpoint. mac is the MAC address of the virtio-net device, and lookup
+// returns the IP address of the guest.
+func (s *Server) SetGuestIPLookup(mac net.HardwareAddr, lookup func() (net.IP, error)) {
+	s.guestMAC = mac
+	s.guestIP = lookup
+}
+
+// SetConfig adds the effective configuration of the virtual machine to the
+// /vm/inspect endpoint, the REST API listener is added to its listeners.
+func (s *Server) SetConfig(config *define.VMConfig) {
+	s.config = config
+}
+
+func (s *Server) handleInspect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("unsupported method %s", r.Method))
+		return
+	}
+
+	resp := define.InspectResponse{
+		State: s.machine.State(),
+	}
+	if len(s.guestMAC) != 0 {
+		resp.MACAddress = s.guestMAC.String()
+	}
+	if s.guestIP != nil {
+		// the lease is missing until the guest network is up, this is
+		// not an error
+		if ip, err := s.guestIP(); err == nil {
+			resp.GuestIP = ip.String()
+		}
+	}
+	if s.config != nil {
+		config := *s.config
+		config.Listeners = append([]define.Listener{{Kind: "rest", Address: s.listener.Addr().String()}}, config.Listeners...)
+		resp.Config = &config
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
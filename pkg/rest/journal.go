@@ -0,0 +1,112 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/crc-org/vfkit/pkg/journal"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxJournalBodySize is the maximum size of the request and error bodies
+// recorded in the journal.
+const maxJournalBodySize = 4096
+
+// apiCall is the journal entry details of a REST API call.
+type apiCall struct {
+	Request interface{} `json:"request,omitempty"`
+	Status  int         `json:"status"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// SetJournal records all the REST API calls which can modify the virtual
+// machine in j. It must be called before Start.
+func (s *Server) SetJournal(j *journal.Journal) {
+	s.journal = j
+}
+
+func (s *Server) handler() http.Handler {
+	if s.journal == nil {
+		return s.mux
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			s.mux.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxJournalBodySize))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		s.mux.ServeHTTP(recorder, r)
+
+		call := apiCall{
+			Request: journalBody(body),
+			Status:  recorder.status,
+		}
+		if recorder.status >= http.StatusBadRequest {
+			var resp struct {
+				Error string `json:"error"`
+			}
+			if err := json.Unmarshal(recorder.body.Bytes(), &resp); err == nil {
+				call.Error = resp.Error
+			}
+		}
+		if err := s.journal.Record(journal.KindAPI, r.Method+" "+r.URL.Path, call); err != nil {
+			log.Warnf("failed to record REST API call in journal: %v", err)
+		}
+	})
+}
+
+// journalBody returns body as a JSON value when possible so that it is not
+// escaped in the journal.
+func journalBody(body []byte) interface{} {
+	if len(body) == 0 {
+		return nil
+	}
+	if json.Valid(body) {
+		return json.RawMessage(body)
+	}
+	return string(body)
+}
+
+// statusRecorder keeps the status code and the beginning of the body of an
+// HTTP response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(data []byte) (int, error) {
+	if remaining := maxJournalBodySize - r.body.Len(); remaining > 0 {
+		if len(data) < remaining {
+			remaining = len(data)
+		}
+		r.body.Write(data[:remaining])
+	}
+	return r.ResponseWriter.Write(data)
+}
+
+// Flush sends the buffered data to the client, so that streamed responses
+// such as the /vm/exec output are not delayed when the journal is enabled.
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
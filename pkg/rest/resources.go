@@ -0,0 +1,24 @@
+package rest
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/crc-org/vfkit/pkg/rest/define"
+)
+
+// SetResources enables the /vm/resources endpoint, which reports the virtual
+// CPUs and memory the virtual machine was started with. They cannot change
+// while it runs.
+func (s *Server) SetResources(resources define.Resources) {
+	s.resources = resources
+	s.mux.HandleFunc("/vm/resources", s.handleResources)
+}
+
+func (s *Server) handleResources(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("unsupported method %s", r.Method))
+		return
+	}
+	writeJSON(w, http.StatusOK, s.resources)
+}
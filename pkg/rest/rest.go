@@ -0,0 +1,146 @@
+// Package rest implements the HTTP API which can be used to query and control
+// a running vfkit instance.
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/crc-org/vfkit/pkg/events"
+	"github.com/crc-org/vfkit/pkg/journal"
+	"github.com/crc-org/vfkit/pkg/rest/define"
+	"github.com/crc-org/vfkit/pkg/schedule"
+	"github.com/crc-org/vfkit/pkg/vm"
+	log "github.com/sirupsen/logrus"
+)
+
+// VirtualMachine is the interface the REST API uses to query and control the
+// virtual machine.
+type VirtualMachine interface {
+	StateMachine() *vm.StateMachine
+	// Shutdown asks the guest to shut down and forcefully stops it after a
+	// timeout.
+	Shutdown() error
+	// Stop forcefully stops the virtual machine.
+	Stop() error
+	// Pause suspends the virtual machine execution.
+	Pause() error
+	// Resume resumes the execution of a paused virtual machine.
+	Resume() error
+	// Stats returns the resource usage of the virtual machine.
+	Stats() (*define.Stats, error)
+}
+
+// Server serves the vfkit REST API on a TCP or unix socket.
+type Server struct {
+	listener net.Listener
+	network  string
+	mux      *http.ServeMux
+	vm       VirtualMachine
+	machine  *vm.StateMachine
+
+	scheduler *schedule.Scheduler
+	forwarder VsockForwarder
+	shares    ShareManager
+	disks     DiskManager
+	snapshots SnapshotManager
+	resources define.Resources
+	guestMAC  net.HardwareAddr
+	guestIP   func() (net.IP, error)
+	config    *define.VMConfig
+	journal   *journal.Journal
+	events    *events.Log
+	egress    EgressController
+	files     GuestFileManager
+	executor  GuestExecutor
+
+	consoleLog string
+}
+
+// NewServer creates a new REST API server listening on uri to query and
+// control virtualMachine.
+func NewServer(uri string, virtualMachine VirtualMachine) (*Server, error) {
+	network, address, err := define.ParseRestfulURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	if network == "unix" {
+		// remove stale socket from a previous run
+		if err := os.Remove(address); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &Server{
+		listener: listener,
+		network:  network,
+		mux:      http.NewServeMux(),
+		vm:       virtualMachine,
+		machine:  virtualMachine.StateMachine(),
+	}
+	server.mux.HandleFunc("/vm/state", server.handleState)
+	server.mux.HandleFunc("/vm/state/events", server.handleStateEvents)
+	server.mux.HandleFunc("/vm/inspect", server.handleInspect)
+	server.mux.HandleFunc("/vm/stats", server.handleStats)
+	server.mux.HandleFunc("/metrics", server.handleMetrics)
+
+	return server, nil
+}
+
+// Start serves the REST API in a new goroutine.
+func (s *Server) Start() {
+	log.Infof("REST API listening on %s", s.listener.Addr())
+	go func() {
+		if err := http.Serve(s.listener, s.handler()); err != nil {
+			log.Debugf("REST API server stopped: %v", err)
+		}
+	}()
+}
+
+// Close stops listening for new connections.
+func (s *Server) Close() error {
+	return s.listener.Close()
+}
+
+// handleUnixOnly registers handler for pattern when the REST API is served on
+// a unix socket. The endpoints giving access to the guest are not served on a
+// TCP socket, which any local user, or any host for a non-loopback address,
+// can connect to.
+func (s *Server) handleUnixOnly(pattern string, handler http.HandlerFunc) {
+	if s.network != "unix" {
+		log.Warnf("%s is only served on a unix socket, it is disabled on %s", pattern, s.listener.Addr())
+		return
+	}
+	s.mux.HandleFunc(pattern, handler)
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Debugf("error writing REST API response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, define.ErrorResponse{Error: err.Error()})
+}
+
+// errorStatus returns the status code to use for err, fallback is used for
+// errors other than define.ErrNotSupported and define.ErrInvalidState.
+func errorStatus(err error, fallback int) int {
+	if errors.Is(err, define.ErrNotSupported) {
+		return http.StatusNotImplemented
+	}
+	if errors.Is(err, define.ErrInvalidState) {
+		return http.StatusConflict
+	}
+	return fallback
+}
@@ -0,0 +1,715 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/crc-org/vfkit/pkg/client"
+	"github.com/crc-org/vfkit/pkg/egress"
+	"github.com/crc-org/vfkit/pkg/events"
+	"github.com/crc-org/vfkit/pkg/journal"
+	"github.com/crc-org/vfkit/pkg/rest/define"
+	"github.com/crc-org/vfkit/pkg/vm"
+)
+
+type fakeVM struct {
+	machine *vm.StateMachine
+}
+
+func (v *fakeVM) StateMachine() *vm.StateMachine {
+	return v.machine
+}
+
+func (v *fakeVM) Shutdown() error {
+	return v.Stop()
+}
+
+func (v *fakeVM) Stop() error {
+	return v.machine.SetState(vm.StateStopped)
+}
+
+func (v *fakeVM) Pause() error {
+	return v.machine.SetState(vm.StatePaused)
+}
+
+func (v *fakeVM) Resume() error {
+	return v.machine.SetState(vm.StateRunning)
+}
+
+func (v *fakeVM) Stats() (*define.Stats, error) {
+	return &define.Stats{EnergyJoules: 42}, nil
+}
+
+// newTestServer starts a REST API server for a running fake virtual machine,
+// configure is called before the server is started to enable the optional
+// endpoints.
+func newTestServer(t *testing.T, configure func(*Server)) (*fakeVM, *client.RestClient) {
+	virtualMachine := &fakeVM{machine: vm.NewStateMachine()}
+	for _, state := range []vm.State{vm.StateStarting, vm.StateRunning} {
+		if err := virtualMachine.machine.SetState(state); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	uri := "unix://" + filepath.Join(t.TempDir(), "rest.sock")
+	server, err := NewServer(uri, virtualMachine)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if configure != nil {
+		configure(server)
+	}
+	server.Start()
+	t.Cleanup(func() { server.Close() })
+
+	restClient, err := client.NewRestClient(uri)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+
+	return virtualMachine, restClient
+}
+
+func TestRestState(t *testing.T) {
+	_, restClient := newTestServer(t, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	state, err := restClient.State(ctx)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if state != vm.StateRunning {
+		t.Fatalf("expected state %s, got %s", vm.StateRunning, state)
+	}
+
+	if err := restClient.Pause(ctx); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if state, _ := restClient.State(ctx); state != vm.StatePaused {
+		t.Fatalf("expected state %s, got %s", vm.StatePaused, state)
+	}
+
+	if err := restClient.Resume(ctx); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if err := restClient.Stop(ctx, true); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if state, _ := restClient.State(ctx); state != vm.StateStopped {
+		t.Fatalf("expected state %s, got %s", vm.StateStopped, state)
+	}
+
+	// the state machine rejects this transition
+	if err := restClient.Pause(ctx); err == nil {
+		t.Fatal("expected error when pausing a stopped virtual machine")
+	}
+}
+
+type fakeForwarder struct {
+	forwards map[uint]define.VsockForward
+}
+
+func (f *fakeForwarder) Forwards() []define.VsockForward {
+	forwards := []define.VsockForward{}
+	for _, forward := range f.forwards {
+		forwards = append(forwards, forward)
+	}
+	return forwards
+}
+
+func (f *fakeForwarder) AddForward(forward define.VsockForward) error {
+	if _, ok := f.forwards[forward.Port]; ok {
+		return fmt.Errorf("vsock port %d is already forwarded", forward.Port)
+	}
+	f.forwards[forward.Port] = forward
+	return nil
+}
+
+func (f *fakeForwarder) RemoveForward(port uint) error {
+	if _, ok := f.forwards[port]; !ok {
+		return fmt.Errorf("vsock port %d is not forwarded", port)
+	}
+	delete(f.forwards, port)
+	return nil
+}
+
+func TestRestVsockForwards(t *testing.T) {
+	_, restClient := newTestServer(t, func(server *Server) {
+		server.SetVsockForwarder(&fakeForwarder{forwards: map[uint]define.VsockForward{}})
+	})
+	ctx := context.Background()
+
+	forward := define.VsockForward{Port: 1024, SocketURL: "/tmp/vsock.sock"}
+	if err := restClient.AddVsockForward(ctx, forward); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if err := restClient.AddVsockForward(ctx, forward); err == nil {
+		t.Fatal("expected error when forwarding the same port twice")
+	}
+	forwards, err := restClient.VsockForwards(ctx)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if len(forwards) != 1 || forwards[0] != forward {
+		t.Fatalf("unexpected forwards: %v", forwards)
+	}
+	if err := restClient.RemoveVsockForward(ctx, 1024); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if err := restClient.RemoveVsockForward(ctx, 1024); err == nil {
+		t.Fatal("expected error when removing a missing forward")
+	}
+}
+
+type fakeShareManager struct {
+	shares []define.Share
+}
+
+func (m *fakeShareManager) Shares() []define.Share {
+	return m.shares
+}
+
+func TestRestShares(t *testing.T) {
+	share := define.Share{SharedDir: "/Users/virtuser/vfkit", MountTag: "vfkit-share"}
+	_, restClient := newTestServer(t, func(server *Server) {
+		server.SetShareManager(&fakeShareManager{shares: []define.Share{share}})
+	})
+	ctx := context.Background()
+
+	shares, err := restClient.Shares(ctx)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if len(shares) != 1 || shares[0] != share {
+		t.Fatalf("unexpected shares: %v", shares)
+	}
+
+	// shares cannot be hot-plugged
+	server := &Server{shares: &fakeShareManager{}}
+	for _, method := range []string{http.MethodPost, http.MethodDelete} {
+		recorder := httptest.NewRecorder()
+		server.handleShares(recorder, httptest.NewRequest(method, sharesPath, strings.NewReader(`{"sharedDir": "/tmp", "mountTag": "tmp"}`)))
+		if recorder.Code != http.StatusNotImplemented {
+			t.Fatalf("unexpected status for %s: %d", method, recorder.Code)
+		}
+	}
+}
+
+type fakeDiskManager struct {
+	disks []define.Disk
+}
+
+func (m *fakeDiskManager) Disks() []define.Disk {
+	return m.disks
+}
+
+func TestRestDisks(t *testing.T) {
+	disk := define.Disk{ID: "disk0", ImagePath: "/Users/virtuser/vfkit.img"}
+	_, restClient := newTestServer(t, func(server *Server) {
+		server.SetDiskManager(&fakeDiskManager{disks: []define.Disk{disk}})
+	})
+	ctx := context.Background()
+
+	disks, err := restClient.Disks(ctx)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if len(disks) != 1 || disks[0] != disk {
+		t.Fatalf("unexpected disks: %v", disks)
+	}
+}
+
+func TestRestResources(t *testing.T) {
+	_, restClient := newTestServer(t, func(server *Server) {
+		server.SetResources(define.Resources{CPUs: 2, MemoryBytes: 2 << 30})
+	})
+
+	resources, err := restClient.Resources(context.Background())
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if *resources != (define.Resources{CPUs: 2, MemoryBytes: 2 << 30}) {
+		t.Fatalf("unexpected resources: %v", resources)
+	}
+}
+
+func TestRestShareUsage(t *testing.T) {
+	_, restClient := newTestServer(t, func(server *Server) {
+		server.SetShareManager(&fakeShareManager{shares: []define.Share{
+			{SharedDir: t.TempDir(), MountTag: "share"},
+			{SharedDir: filepath.Join(t.TempDir(), "missing"), MountTag: "missing"},
+		}})
+	})
+
+	stats, err := restClient.Stats(context.Background())
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if len(stats.Shares) != 1 || stats.Shares[0].MountTag != "share" {
+		t.Fatalf("unexpected share usage: %+v", stats.Shares)
+	}
+	if stats.Shares[0].TotalBytes == 0 || stats.Shares[0].AvailableBytes > stats.Shares[0].TotalBytes {
+		t.Fatalf("unexpected share usage: %+v", stats.Shares[0])
+	}
+}
+
+func TestRestInspect(t *testing.T) {
+	mac, _ := net.ParseMAC("52:54:00:70:2b:71")
+	guestIP := net.ParseIP("192.168.64.3")
+	var address string
+	_, restClient := newTestServer(t, func(server *Server) {
+		address = server.listener.Addr().String()
+		server.SetGuestIPLookup(mac, func() (net.IP, error) {
+			return guestIP, nil
+		})
+		server.SetConfig(&define.VMConfig{
+			Vcpus:   2,
+			Devices: []define.DeviceConfig{{Type: "virtio-net", Options: map[string]string{"nat": "", "mac": mac.String()}}},
+		})
+	})
+
+	inspect, err := restClient.Inspect(context.Background())
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if inspect.State != vm.StateRunning || inspect.MACAddress != mac.String() || inspect.GuestIP != guestIP.String() {
+		t.Fatalf("unexpected inspect response: %+v", inspect)
+	}
+	if inspect.Config == nil || inspect.Config.Vcpus != 2 || inspect.Config.Devices[0].Options["mac"] != mac.String() {
+		t.Fatalf("unexpected configuration: %+v", inspect.Config)
+	}
+	expected := define.Listener{Kind: "rest", Address: address}
+	if len(inspect.Config.Listeners) != 1 || inspect.Config.Listeners[0] != expected {
+		t.Fatalf("expected the REST API listener; got %+v", inspect.Config.Listeners)
+	}
+}
+
+func TestRestJournal(t *testing.T) {
+	journalPath := filepath.Join(t.TempDir(), "journal.jsonl")
+	j, err := journal.Open(journalPath)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	defer j.Close()
+	virtualMachine, restClient := newTestServer(t, func(server *Server) {
+		server.SetJournal(j)
+	})
+	if err := virtualMachine.Stop(); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	ctx := context.Background()
+
+	// queries are not recorded
+	if _, err := restClient.State(ctx); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	// the state machine rejects this transition
+	if err := restClient.Pause(ctx); err == nil {
+		t.Fatal("expected error when pausing a stopped virtual machine")
+	}
+
+	file, err := os.Open(journalPath)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	defer file.Close()
+	entries, err := journal.ReadEntries(file)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if len(entries) != 1 || entries[0].Kind != journal.KindAPI || entries[0].Action != "POST /vm/state" {
+		t.Fatalf("unexpected journal entries: %+v", entries)
+	}
+	details, ok := entries[0].Details.(map[string]interface{})
+	if !ok || details["status"] == float64(http.StatusOK) || details["error"] == "" || details["request"] == nil {
+		t.Fatalf("unexpected journal entry details: %+v", entries[0].Details)
+	}
+}
+
+func TestRestEvents(t *testing.T) {
+	eventLog := events.NewLog(events.DefaultCapacity)
+	_, restClient := newTestServer(t, func(server *Server) {
+		server.SetEventLog(eventLog)
+	})
+	ctx := context.Background()
+
+	if _, err := eventLog.Record(events.TypeStarted, "virtual machine running", nil); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if _, err := eventLog.Record(events.TypeDeviceAttached, "virtio-blk device attached", nil); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+
+	all, err := restClient.Events(ctx, 0, "")
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if len(all) != 2 || all[0].Type != events.TypeStarted || all[1].Type != events.TypeDeviceAttached {
+		t.Fatalf("unexpected events: %+v", all)
+	}
+	newer, err := restClient.Events(ctx, all[0].ID, "")
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if len(newer) != 1 || newer[0].ID != all[1].ID {
+		t.Fatalf("unexpected events since %d: %+v", all[0].ID, newer)
+	}
+	filtered, err := restClient.Events(ctx, 0, events.TypeStarted)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if len(filtered) != 1 || filtered[0].Type != events.TypeStarted {
+		t.Fatalf("unexpected %s events: %+v", events.TypeStarted, filtered)
+	}
+}
+
+// fakeSnapshotManager has one disk, disk0, and refuses restores while running
+type fakeSnapshotManager struct {
+	running   bool
+	snapshots []define.Snapshot
+}
+
+func (m *fakeSnapshotManager) Snapshots(diskID string) ([]define.Snapshot, error) {
+	if diskID != "disk0" {
+		return nil, fmt.Errorf("%w: no disk with ID '%s'", os.ErrNotExist, diskID)
+	}
+	return m.snapshots, nil
+}
+
+func (m *fakeSnapshotManager) CreateSnapshot(diskID string, name string) (*define.Snapshot, error) {
+	if diskID != "disk0" {
+		return nil, fmt.Errorf("%w: no disk with ID '%s'", os.ErrNotExist, diskID)
+	}
+	snapshot := define.Snapshot{ID: fmt.Sprintf("snap%d", len(m.snapshots)+1), Name: name}
+	m.snapshots = append(m.snapshots, snapshot)
+	return &snapshot, nil
+}
+
+func (m *fakeSnapshotManager) RestoreSnapshot(diskID string, snapshotID string) error {
+	if m.running {
+		return fmt.Errorf("%w (running)", define.ErrInvalidState)
+	}
+	return nil
+}
+
+func (m *fakeSnapshotManager) DeleteSnapshot(diskID string, snapshotID string) error {
+	for i, snapshot := range m.snapshots {
+		if snapshot.ID == snapshotID {
+			m.snapshots = append(m.snapshots[:i], m.snapshots[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: no snapshot with ID '%s'", os.ErrNotExist, snapshotID)
+}
+
+func TestRestSnapshots(t *testing.T) {
+	snapshots := &fakeSnapshotManager{running: true}
+	_, restClient := newTestServer(t, func(server *Server) {
+		server.SetDiskManager(&fakeDiskManager{})
+		server.SetSnapshotManager(snapshots)
+	})
+	ctx := context.Background()
+
+	snapshot, err := restClient.CreateDiskSnapshot(ctx, "disk0", "clean install")
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if snapshot.ID != "snap1" || snapshot.Name != "clean install" {
+		t.Fatalf("unexpected snapshot: %+v", snapshot)
+	}
+	list, err := restClient.DiskSnapshots(ctx, "disk0")
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if len(list) != 1 || list[0].ID != snapshot.ID {
+		t.Fatalf("unexpected snapshots: %+v", list)
+	}
+	_, err = restClient.DiskSnapshots(ctx, "missing")
+	var restErr *client.RestError
+	if !errors.As(err, &restErr) || restErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+
+	err = restClient.RestoreDiskSnapshot(ctx, "disk0", snapshot.ID)
+	if !errors.As(err, &restErr) || restErr.StatusCode != http.StatusConflict {
+		t.Fatalf("expected conflict error restoring a running virtual machine, got %v", err)
+	}
+	snapshots.running = false
+	if err := restClient.RestoreDiskSnapshot(ctx, "disk0", snapshot.ID); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+
+	if err := restClient.DeleteDiskSnapshot(ctx, "disk0", snapshot.ID); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	err = restClient.DeleteDiskSnapshot(ctx, "disk0", snapshot.ID)
+	if !errors.As(err, &restErr) || restErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+}
+
+type fakeRuleLoader struct{}
+
+func (l *fakeRuleLoader) Load(rules string) error {
+	return nil
+}
+
+func TestRestEgress(t *testing.T) {
+	policy, err := egress.ParsePolicy([]string{"deny,cidr=10.0.0.0/8"})
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	lookup := func() (net.IP, error) {
+		return net.ParseIP("192.168.64.3"), nil
+	}
+	_, restClient := newTestServer(t, func(server *Server) {
+		server.SetEgressController(egress.NewEnforcer(policy, lookup, &fakeRuleLoader{}))
+	})
+	ctx := context.Background()
+
+	resp, err := restClient.EgressPolicy(ctx)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if resp.Default != "allow" || len(resp.Rules) != 1 || resp.Rules[0] != "deny,cidr=10.0.0.0/8" {
+		t.Fatalf("unexpected egress policy: %+v", resp)
+	}
+
+	resp, err = restClient.SetEgressPolicy(ctx, define.EgressPolicy{Default: "deny", Rules: []string{"allow,cidr=192.168.64.1"}})
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if resp.Default != "deny" || len(resp.Rules) != 1 || resp.Rules[0] != "allow,cidr=192.168.64.1/32" {
+		t.Fatalf("unexpected egress policy: %+v", resp)
+	}
+	if _, err := restClient.SetEgressPolicy(ctx, define.EgressPolicy{Rules: []string{"allow"}}); err == nil {
+		t.Fatal("expected error for invalid egress rule")
+	}
+}
+
+func TestRestConsole(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "console.log")
+	if err := os.WriteFile(logPath, []byte("line 1\nline 2\nline 3\n"), 0600); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	_, restClient := newTestServer(t, func(server *Server) {
+		server.SetConsoleLog(logPath)
+	})
+	ctx := context.Background()
+
+	console, err := restClient.Console(ctx, 2)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if console != "line 2\nline 3\n" {
+		t.Fatalf("unexpected console output: %q", console)
+	}
+
+	followCtx, cancel := context.WithCancel(ctx)
+	reader, writer := io.Pipe()
+	go func() {
+		_ = restClient.FollowConsole(followCtx, 1, writer)
+		writer.Close()
+	}()
+	buf := make([]byte, len("line 3\n"))
+	if _, err := io.ReadFull(reader, buf); err != nil || string(buf) != "line 3\n" {
+		t.Fatalf("unexpected console output: %q (%v)", buf, err)
+	}
+	file, err := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	_, _ = file.WriteString("line 4\n")
+	file.Close()
+	if _, err := io.ReadFull(reader, buf); err != nil || string(buf) != "line 4\n" {
+		t.Fatalf("unexpected followed output: %q (%v)", buf, err)
+	}
+	cancel()
+}
+
+type fakeGuestFiles struct {
+	files map[string][]byte
+	modes map[string]os.FileMode
+}
+
+func (f *fakeGuestFiles) CopyTo(_ context.Context, r io.Reader, guestPath string, mode os.FileMode) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	f.files[guestPath] = data
+	f.modes[guestPath] = mode
+	return nil
+}
+
+func (f *fakeGuestFiles) CopyFrom(_ context.Context, guestPath string, w io.Writer) error {
+	data, ok := f.files[guestPath]
+	if !ok {
+		return fmt.Errorf("open %s: no such file or directory", guestPath)
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+func TestRestFiles(t *testing.T) {
+	files := &fakeGuestFiles{files: map[string][]byte{}, modes: map[string]os.FileMode{}}
+	_, restClient := newTestServer(t, func(server *Server) {
+		server.SetGuestFileManager(files)
+	})
+	ctx := context.Background()
+
+	if err := restClient.CopyTo(ctx, "/etc/motd", strings.NewReader("hello"), 0600); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if string(files.files["/etc/motd"]) != "hello" || files.modes["/etc/motd"] != 0600 {
+		t.Fatalf("unexpected guest file: %q %o", files.files["/etc/motd"], files.modes["/etc/motd"])
+	}
+	if err := restClient.CopyTo(ctx, "/etc/empty", nil, 0); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if data, ok := files.files["/etc/empty"]; !ok || len(data) != 0 || files.modes["/etc/empty"] != 0644 {
+		t.Fatalf("unexpected empty guest file: %q %o", data, files.modes["/etc/empty"])
+	}
+
+	var buf bytes.Buffer
+	if err := restClient.CopyFrom(ctx, "/etc/motd", &buf); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if buf.String() != "hello" {
+		t.Fatalf("unexpected content: %q", buf.String())
+	}
+	buf.Reset()
+	if err := restClient.CopyFrom(ctx, "/etc/empty", &buf); err != nil || buf.Len() != 0 {
+		t.Fatalf("unexpected result for an empty file: %q %v", buf.String(), err)
+	}
+	var restErr *client.RestError
+	if err := restClient.CopyFrom(ctx, "/missing", &buf); !errors.As(err, &restErr) || restErr.StatusCode != http.StatusBadGateway {
+		t.Fatalf("unexpected error for a missing file: %v", err)
+	}
+}
+
+func TestRestGuestAccessTCP(t *testing.T) {
+	server, err := NewServer("tcp://127.0.0.1:0", &fakeVM{machine: vm.NewStateMachine()})
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	defer server.Close()
+	server.SetGuestFileManager(&fakeGuestFiles{files: map[string][]byte{}, modes: map[string]os.FileMode{}})
+	server.SetGuestExecutor(&fakeExecutor{})
+
+	// guest files and commands are not exposed on a TCP socket
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/vm/files?path=/etc/motd", nil),
+		httptest.NewRequest(http.MethodPost, "/vm/exec", strings.NewReader(`{"command": ["true"]}`)),
+	} {
+		recorder := httptest.NewRecorder()
+		server.handler().ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusNotFound {
+			t.Fatalf("unexpected status for %s: %d", req.URL.Path, recorder.Code)
+		}
+	}
+}
+
+// fakeExecutor runs the "stream" command until streamed is closed, when the
+// client received its first output line
+type fakeExecutor struct {
+	streamed chan struct{}
+}
+
+func (e *fakeExecutor) Exec(_ context.Context, req define.ExecRequest, stdout io.Writer, stderr io.Writer) (int, error) {
+	switch req.Command[0] {
+	case "stream":
+		_, _ = stdout.Write([]byte("started\n"))
+		select {
+		case <-e.streamed:
+			return 0, nil
+		case <-time.After(5 * time.Second):
+			return -1, fmt.Errorf("output was not streamed")
+		}
+	case "unreachable":
+		return -1, fmt.Errorf("guest agent unreachable")
+	case "broken":
+		_, _ = stdout.Write([]byte("partial"))
+		return -1, fmt.Errorf("connection reset")
+	}
+	_, _ = stdout.Write([]byte("out1\n"))
+	_, _ = stderr.Write([]byte("err\n"))
+	_, _ = stdout.Write([]byte("out2\n"))
+	return 4, nil
+}
+
+func TestRestExec(t *testing.T) {
+	_, restClient := newTestServer(t, func(server *Server) {
+		server.SetGuestExecutor(&fakeExecutor{})
+	})
+	ctx := context.Background()
+
+	var stdout, stderr bytes.Buffer
+	exitCode, err := restClient.Exec(ctx, define.ExecRequest{Command: []string{"true"}}, &stdout, &stderr)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if exitCode != 4 || stdout.String() != "out1\nout2\n" || stderr.String() != "err\n" {
+		t.Fatalf("unexpected exec result: %d %q %q", exitCode, stdout.String(), stderr.String())
+	}
+
+	var restErr *client.RestError
+	if _, err := restClient.Exec(ctx, define.ExecRequest{Command: []string{"unreachable"}}, nil, nil); !errors.As(err, &restErr) || restErr.StatusCode != http.StatusBadGateway {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stdout.Reset()
+	if _, err := restClient.Exec(ctx, define.ExecRequest{Command: []string{"broken"}}, &stdout, nil); err == nil || !strings.Contains(err.Error(), "connection reset") || stdout.String() != "partial" {
+		t.Fatalf("unexpected result: %q %v", stdout.String(), err)
+	}
+	if _, err := restClient.Exec(ctx, define.ExecRequest{}, nil, nil); !errors.As(err, &restErr) || restErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unexpected error for a missing command: %v", err)
+	}
+}
+
+// notifyWriter closes notify on the first write.
+type notifyWriter struct {
+	bytes.Buffer
+	notify chan struct{}
+}
+
+func (w *notifyWriter) Write(p []byte) (int, error) {
+	if w.Len() == 0 {
+		close(w.notify)
+	}
+	return w.Buffer.Write(p)
+}
+
+func TestRestExecJournal(t *testing.T) {
+	j, err := journal.Open(filepath.Join(t.TempDir(), "journal.jsonl"))
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	defer j.Close()
+	executor := &fakeExecutor{streamed: make(chan struct{})}
+	_, restClient := newTestServer(t, func(server *Server) {
+		server.SetJournal(j)
+		server.SetGuestExecutor(executor)
+	})
+
+	// the journal must not buffer the output until the command exits
+	stdout := &notifyWriter{notify: executor.streamed}
+	exitCode, err := restClient.Exec(context.Background(), define.ExecRequest{Command: []string{"stream"}}, stdout, nil)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if exitCode != 0 || stdout.String() != "started\n" {
+		t.Fatalf("unexpected exec result: %d %q", exitCode, stdout.String())
+	}
+}
@@ -0,0 +1,56 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/crc-org/vfkit/pkg/rest/define"
+	"github.com/crc-org/vfkit/pkg/schedule"
+)
+
+// SetScheduler enables the /vm/schedule endpoint to query and override the
+// start/stop schedule of the virtual machine.
+func (s *Server) SetScheduler(scheduler *schedule.Scheduler) {
+	s.scheduler = scheduler
+	s.mux.HandleFunc("/vm/schedule", s.handleSchedule)
+}
+
+func (s *Server) scheduleResponse() define.ScheduleResponse {
+	resp := define.ScheduleResponse{Entries: []string{}}
+	for _, entry := range s.scheduler.Entries() {
+		resp.Entries = append(resp.Entries, entry.String())
+	}
+	if action, at, ok := s.scheduler.Next(); ok {
+		resp.NextAction = string(action)
+		resp.NextActionTime = &at
+	}
+	if suspendedUntil := s.scheduler.SuspendedUntil(); !suspendedUntil.IsZero() {
+		resp.SuspendedUntil = &suspendedUntil
+	}
+
+	return resp
+}
+
+func (s *Server) handleSchedule(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req define.ScheduleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if req.SuspendUntil != nil {
+			s.scheduler.SuspendUntil(*req.SuspendUntil)
+		}
+		if req.SkipNext {
+			s.scheduler.SkipNext()
+		}
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("unsupported method %s", r.Method))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.scheduleResponse())
+}
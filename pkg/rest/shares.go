@@ -0,0 +1,36 @@
+package rest
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/crc-org/vfkit/pkg/rest/define"
+)
+
+// ShareManager is the interface the REST API uses to list the virtio-fs
+// shares of the virtual machine.
+type ShareManager interface {
+	Shares() []define.Share
+}
+
+const sharesPath = "/vm/shares"
+
+// SetShareManager enables the /vm/shares endpoint to list the virtio-fs
+// shares.
+func (s *Server) SetShareManager(shares ShareManager) {
+	s.shares = shares
+	s.mux.HandleFunc(sharesPath, s.handleShares)
+}
+
+func (s *Server) handleShares(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.shares.Shares())
+	case http.MethodPost, http.MethodDelete:
+		// the share of a running virtio-fs device cannot be changed with
+		// Code-Hex/vz 3.0.0, see doc/missing-vz-api.md
+		writeError(w, http.StatusNotImplemented, fmt.Errorf("%w: adding and removing virtio-fs shares of a running virtual machine is not supported by vz v3.0.0", define.ErrNotSupported))
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("unsupported method %s", r.Method))
+	}
+}
@@ -0,0 +1,31 @@
+package rest
+
+import (
+	"github.com/crc-org/vfkit/pkg/rest/define"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// shareUsage returns the disk usage of the host volumes of the virtio-fs
+// shares, shares whose usage can't be read are skipped.
+func (s *Server) shareUsage() []define.ShareUsage {
+	if s.shares == nil {
+		return nil
+	}
+	usage := []define.ShareUsage{}
+	for _, share := range s.shares.Shares() {
+		var stat unix.Statfs_t
+		if err := unix.Statfs(share.SharedDir, &stat); err != nil {
+			log.Debugf("cannot read disk usage of %s: %v", share.SharedDir, err)
+			continue
+		}
+		usage = append(usage, define.ShareUsage{
+			MountTag:       share.MountTag,
+			SharedDir:      share.SharedDir,
+			TotalBytes:     stat.Blocks * uint64(stat.Bsize),
+			AvailableBytes: stat.Bavail * uint64(stat.Bsize),
+		})
+	}
+
+	return usage
+}
@@ -0,0 +1,102 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/crc-org/vfkit/pkg/rest/define"
+)
+
+// SnapshotManager is the interface the REST API uses to manage the snapshots
+// of the virtio-blk disks of the virtual machine.
+type SnapshotManager interface {
+	Snapshots(diskID string) ([]define.Snapshot, error)
+	CreateSnapshot(diskID string, name string) (*define.Snapshot, error)
+	RestoreSnapshot(diskID string, snapshotID string) error
+	DeleteSnapshot(diskID string, snapshotID string) error
+}
+
+// SetSnapshotManager enables the /vm/disks/<id>/snapshots endpoints to list,
+// create, restore and delete disk snapshots. It must be used along with
+// SetDiskManager.
+func (s *Server) SetSnapshotManager(snapshots SnapshotManager) {
+	s.snapshots = snapshots
+}
+
+// snapshotStatus returns the status code to use for err.
+func snapshotStatus(err error, fallback int) int {
+	if errors.Is(err, os.ErrNotExist) {
+		return http.StatusNotFound
+	}
+	return errorStatus(err, fallback)
+}
+
+// handleSnapshots handles the /vm/disks/<id>/snapshots endpoints, path is the
+// part of the URL path after /vm/disks/<id>/snapshots.
+func (s *Server) handleSnapshots(w http.ResponseWriter, r *http.Request, diskID string, path string) {
+	if s.snapshots == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("disk snapshots are not enabled"))
+		return
+	}
+	path = strings.Trim(path, "/")
+	switch {
+	case path == "":
+		s.handleDiskSnapshots(w, r, diskID)
+	case strings.HasSuffix(path, "/restore"):
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("unsupported method %s", r.Method))
+			return
+		}
+		snapshotID := strings.TrimSuffix(path, "/restore")
+		if err := s.snapshots.RestoreSnapshot(diskID, snapshotID); err != nil {
+			writeError(w, snapshotStatus(err, http.StatusInternalServerError), err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case !strings.Contains(path, "/"):
+		if r.Method != http.MethodDelete {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("unsupported method %s", r.Method))
+			return
+		}
+		if err := s.snapshots.DeleteSnapshot(diskID, path); err != nil {
+			writeError(w, snapshotStatus(err, http.StatusInternalServerError), err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown path %s", r.URL.Path))
+	}
+}
+
+// handleDiskSnapshots handles /vm/disks/<id>/snapshots
+func (s *Server) handleDiskSnapshots(w http.ResponseWriter, r *http.Request, diskID string) {
+	switch r.Method {
+	case http.MethodGet:
+		snapshots, err := s.snapshots.Snapshots(diskID)
+		if err != nil {
+			writeError(w, snapshotStatus(err, http.StatusInternalServerError), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, snapshots)
+	case http.MethodPost:
+		var req define.SnapshotRequest
+		// the body is optional
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		snapshot, err := s.snapshots.CreateSnapshot(diskID, req.Name)
+		if err != nil {
+			writeError(w, snapshotStatus(err, http.StatusInternalServerError), err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, snapshot)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("unsupported method %s", r.Method))
+	}
+}
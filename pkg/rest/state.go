@@ -0,0 +1,154 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/crc-org/vfkit/pkg/rest/define"
+	"github.com/crc-org/vfkit/pkg/vm"
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultLongPollTimeout = 30 * time.Second
+
+func (s *Server) handleState(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.getState(w, r)
+	case http.MethodPost:
+		s.setState(w, r)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("unsupported method %s", r.Method))
+	}
+}
+
+// getState returns the current virtual machine state.
+// When the 'wait' query parameter is set to a state name, the request blocks
+// until the virtual machine leaves this state (long-polling), or until
+// 'timeout' (default 30s) expires.
+func (s *Server) getState(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	waitStr := query.Get("wait")
+	if waitStr == "" {
+		writeJSON(w, http.StatusOK, define.StateResponse{State: s.machine.State()})
+		return
+	}
+
+	waitState, err := vm.ParseState(waitStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	timeout := defaultLongPollTimeout
+	if timeoutStr := query.Get("timeout"); timeoutStr != "" {
+		timeout, err = time.ParseDuration(timeoutStr)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	// on timeout, the unchanged state is returned
+	state, _ := s.machine.WaitForChange(ctx, waitState)
+	writeJSON(w, http.StatusOK, define.StateResponse{State: state})
+}
+
+// setState changes the virtual machine state. The request returns as soon as
+// the state change was initiated, GET /vm/state or /vm/state/events can be
+// used to know when it completes.
+func (s *Server) setState(w http.ResponseWriter, r *http.Request) {
+	var req define.StateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	switch req.State {
+	case vm.StateStopped:
+		if req.Force {
+			if err := s.vm.Stop(); err != nil {
+				writeError(w, http.StatusConflict, err)
+				return
+			}
+			break
+		}
+		// the graceful shutdown can take up to the shutdown timeout, don't
+		// block the HTTP request
+		go func() {
+			if err := s.vm.Shutdown(); err != nil {
+				log.Warnf("failed to shut down virtual machine: %v", err)
+			}
+		}()
+	case vm.StatePaused:
+		if err := s.vm.Pause(); err != nil {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+	case vm.StateRunning:
+		if err := s.vm.Resume(); err != nil {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("cannot change virtual machine state to %s", req.State))
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, define.StateResponse{State: s.machine.State()})
+}
+
+// handleStateEvents streams virtual machine state changes as server-sent
+// events until the client disconnects.
+func (s *Server) handleStateEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("unsupported method %s", r.Method))
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming is not supported"))
+		return
+	}
+
+	changes, unsubscribe := s.machine.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	// send the current state first so that clients don't need a separate request
+	if err := writeEvent(w, "state", define.StateResponse{State: s.machine.State()}); err != nil {
+		return
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case change, ok := <-changes:
+			if !ok {
+				return
+			}
+			if err := writeEvent(w, "change", change); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
@@ -0,0 +1,76 @@
+package rest
+
+import (
+	"fmt"
+	"net/http"
+)
+
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("unsupported method %s", r.Method))
+		return
+	}
+	stats, err := s.vm.Stats()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	stats.Shares = s.shareUsage()
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// metric is a value exposed by the /metrics endpoint.
+type metric struct {
+	name       string
+	metricType string
+	help       string
+	value      float64
+}
+
+// handleMetrics exposes the virtual machine stats using the Prometheus text
+// format.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("unsupported method %s", r.Method))
+		return
+	}
+	stats, err := s.vm.Stats()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metrics := []metric{
+		{"vfkit_cpu_seconds_total", "counter", "CPU time used by the vfkit process.", stats.CPUTimeSeconds},
+		{"vfkit_energy_joules_total", "counter", "Estimated energy used by the virtual machine.", stats.EnergyJoules},
+		{"vfkit_power_watts", "gauge", "Estimated power used by the virtual machine during the last 10 seconds.", stats.PowerWatts},
+		{"vfkit_memory_footprint_bytes", "gauge", "Physical memory footprint of the vfkit process.", float64(stats.MemoryFootprintBytes)},
+		{"vfkit_resident_memory_bytes", "gauge", "Resident memory of the vfkit process, including shared pages.", float64(stats.ResidentMemoryBytes)},
+		{"vfkit_disk_read_bytes_total", "counter", "Bytes read from storage by the vfkit process.", float64(stats.DiskReadBytes)},
+		{"vfkit_disk_written_bytes_total", "counter", "Bytes written to storage by the vfkit process.", float64(stats.DiskWrittenBytes)},
+	}
+	if compressor := stats.HostCompressor; compressor != nil {
+		metrics = append(metrics, []metric{
+			{"vfkit_host_compressor_uncompressed_bytes", "gauge", "Size of the pages stored in the host memory compressor.", float64(compressor.UncompressedBytes)},
+			{"vfkit_host_compressor_compressed_bytes", "gauge", "Physical memory used by the host memory compressor.", float64(compressor.CompressedBytes)},
+			{"vfkit_host_compressor_saved_bytes", "gauge", "Memory saved by the host memory compressor.", float64(compressor.SavedBytes)},
+		}...)
+	}
+	for _, metric := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", metric.name, metric.help, metric.name, metric.metricType, metric.name, metric.value)
+	}
+
+	shares := s.shareUsage()
+	if len(shares) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "# HELP vfkit_share_size_bytes Size of the host volume of a virtio-fs share.\n# TYPE vfkit_share_size_bytes gauge\n")
+	for _, share := range shares {
+		fmt.Fprintf(w, "vfkit_share_size_bytes{mount_tag=%q} %d\n", share.MountTag, share.TotalBytes)
+	}
+	fmt.Fprintf(w, "# HELP vfkit_share_available_bytes Free space on the host volume of a virtio-fs share.\n# TYPE vfkit_share_available_bytes gauge\n")
+	for _, share := range shares {
+		fmt.Fprintf(w, "vfkit_share_available_bytes{mount_tag=%q} %d\n", share.MountTag, share.AvailableBytes)
+	}
+}
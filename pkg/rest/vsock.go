@@ -0,0 +1,68 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/crc-org/vfkit/pkg/rest/define"
+)
+
+// VsockForwarder is the interface the REST API uses to manage the vsock port
+// forwards.
+type VsockForwarder interface {
+	Forwards() []define.VsockForward
+	AddForward(forward define.VsockForward) error
+	RemoveForward(port uint) error
+}
+
+const vsockForwardsPath = "/vm/vsock/forwards"
+
+// SetVsockForwarder enables the /vm/vsock/forwards endpoints to list, add and
+// remove vsock port forwards at runtime.
+func (s *Server) SetVsockForwarder(forwarder VsockForwarder) {
+	s.forwarder = forwarder
+	s.mux.HandleFunc(vsockForwardsPath, s.handleVsockForwards)
+	s.mux.HandleFunc(vsockForwardsPath+"/", s.handleVsockForward)
+}
+
+func (s *Server) handleVsockForwards(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.forwarder.Forwards())
+	case http.MethodPost:
+		var forward define.VsockForward
+		if err := json.NewDecoder(r.Body).Decode(&forward); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := s.forwarder.AddForward(forward); err != nil {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, forward)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("unsupported method %s", r.Method))
+	}
+}
+
+// handleVsockForward handles /vm/vsock/forwards/<port>
+func (s *Server) handleVsockForward(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("unsupported method %s", r.Method))
+		return
+	}
+	portStr := strings.TrimPrefix(r.URL.Path, vsockForwardsPath+"/")
+	port, err := strconv.ParseUint(portStr, 10, 32)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid vsock port '%s'", portStr))
+		return
+	}
+	if err := s.forwarder.RemoveForward(uint(port)); err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
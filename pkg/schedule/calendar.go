@@ -0,0 +1,163 @@
+// Package schedule starts and stops virtual machines according to a cron-like
+// calendar.
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Calendar is a set of points in time described with the 5 fields of a crontab
+// line: minute, hour, day of month, month and day of week.
+//
+// Each field is either '*', a value, a range ('1-5') or a comma-separated list
+// of these. Steps ('*/15', '8-18/2') are also supported. Days of the week go
+// from 0 (Sunday) to 6 (Saturday), 7 is also accepted for Sunday. As with
+// cron, when both day of month and day of week are restricted, a day matches
+// when either of them matches.
+type Calendar struct {
+	expr     string
+	minutes  uint64
+	hours    uint64
+	days     uint64
+	months   uint64
+	weekdays uint64
+	anyDay   bool
+	anyDow   bool
+}
+
+type calendarField struct {
+	name string
+	min  int
+	max  int
+}
+
+var calendarFields = []calendarField{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// ParseCalendar parses a crontab-like calendar expression such as
+// "0 8 * * 1-5" (8am on weekdays).
+func ParseCalendar(expr string) (*Calendar, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != len(calendarFields) {
+		return nil, fmt.Errorf("invalid calendar '%s': expected %d fields, got %d", expr, len(calendarFields), len(fields))
+	}
+
+	masks := make([]uint64, len(fields))
+	for i, field := range fields {
+		mask, err := parseCalendarField(field, calendarFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid calendar '%s': %w", expr, err)
+		}
+		masks[i] = mask
+	}
+	// 7 is an alias for Sunday
+	if masks[4]&(1<<7) != 0 {
+		masks[4] |= 1
+	}
+
+	return &Calendar{
+		expr:     strings.Join(fields, " "),
+		minutes:  masks[0],
+		hours:    masks[1],
+		days:     masks[2],
+		months:   masks[3],
+		weekdays: masks[4],
+		anyDay:   fields[2] == "*",
+		anyDow:   fields[4] == "*",
+	}, nil
+}
+
+func parseCalendarField(field string, desc calendarField) (uint64, error) {
+	var mask uint64
+	for _, item := range strings.Split(field, ",") {
+		rangeStr := item
+		step := 1
+		if split := strings.SplitN(item, "/", 2); len(split) == 2 {
+			var err error
+			rangeStr = split[0]
+			if step, err = strconv.Atoi(split[1]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %s '%s'", desc.name, item)
+			}
+		}
+
+		start, end := desc.min, desc.max
+		if rangeStr != "*" {
+			bounds := strings.SplitN(rangeStr, "-", 2)
+			var err error
+			if start, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid %s '%s'", desc.name, item)
+			}
+			end = start
+			if len(bounds) == 2 {
+				if end, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid %s '%s'", desc.name, item)
+				}
+			}
+		}
+		if start < desc.min || end > desc.max || start > end {
+			return 0, fmt.Errorf("%s '%s' is out of range (%d-%d)", desc.name, item, desc.min, desc.max)
+		}
+		for i := start; i <= end; i += step {
+			mask |= 1 << uint(i)
+		}
+	}
+
+	return mask, nil
+}
+
+func (c *Calendar) String() string {
+	return c.expr
+}
+
+func (c *Calendar) matchDay(t time.Time) bool {
+	dayMatch := c.days&(1<<uint(t.Day())) != 0
+	dowMatch := c.weekdays&(1<<uint(t.Weekday())) != 0
+	switch {
+	case c.anyDay && c.anyDow:
+		return true
+	case c.anyDay:
+		return dowMatch
+	case c.anyDow:
+		return dayMatch
+	default:
+		return dayMatch || dowMatch
+	}
+}
+
+// Next returns the first point in time of the calendar strictly after t. The
+// zero time is returned if there is none in the next 5 years (for example for
+// "0 0 31 2 *").
+func (c *Calendar) Next(t time.Time) time.Time {
+	next := t.Truncate(time.Minute).Add(time.Minute)
+	limit := next.AddDate(5, 0, 0)
+
+	for next.Before(limit) {
+		if c.months&(1<<uint(next.Month())) == 0 {
+			next = time.Date(next.Year(), next.Month()+1, 1, 0, 0, 0, 0, next.Location())
+			continue
+		}
+		if !c.matchDay(next) {
+			next = time.Date(next.Year(), next.Month(), next.Day()+1, 0, 0, 0, 0, next.Location())
+			continue
+		}
+		if c.hours&(1<<uint(next.Hour())) == 0 {
+			next = time.Date(next.Year(), next.Month(), next.Day(), next.Hour()+1, 0, 0, 0, next.Location())
+			continue
+		}
+		if c.minutes&(1<<uint(next.Minute())) == 0 {
+			next = next.Add(time.Minute)
+			continue
+		}
+		return next
+	}
+
+	return time.Time{}
+}
@@ -0,0 +1,40 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCalendarNext(t *testing.T) {
+	// Friday
+	start := time.Date(2023, time.March, 10, 21, 30, 0, 0, time.UTC)
+	tests := []struct {
+		expr     string
+		expected time.Time
+	}{
+		{"0 22 * * *", time.Date(2023, time.March, 10, 22, 0, 0, 0, time.UTC)},
+		{"0 8 * * 1-5", time.Date(2023, time.March, 13, 8, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2023, time.March, 10, 21, 45, 0, 0, time.UTC)},
+		{"30 21 * * *", time.Date(2023, time.March, 11, 21, 30, 0, 0, time.UTC)},
+		{"0 0 1 * 0", time.Date(2023, time.March, 12, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2023, time.March, 12, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		calendar, err := ParseCalendar(test.expr)
+		if err != nil {
+			t.Fatal("expected no error; got", err)
+		}
+		if next := calendar.Next(start); !next.Equal(test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.expr, test.expected, next)
+		}
+	}
+}
+
+func TestCalendarInvalid(t *testing.T) {
+	for _, expr := range []string{"", "0 8 * *", "60 * * * *", "0 8 * * 1-8", "*/0 * * * *", "a * * * *", "5-1 * * * *"} {
+		if _, err := ParseCalendar(expr); err == nil {
+			t.Errorf("expected error for calendar '%s'", expr)
+		}
+	}
+}
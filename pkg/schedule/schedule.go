@@ -0,0 +1,211 @@
+package schedule
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Action is what the scheduler does to the virtual machine.
+type Action string
+
+const (
+	ActionStart Action = "start"
+	ActionStop  Action = "stop"
+)
+
+// Entry associates an action with the calendar describing when it must run.
+type Entry struct {
+	Action   Action
+	Calendar *Calendar
+}
+
+// ParseEntry parses a schedule entry in the "action=calendar" format, for
+// example "stop=0 22 * * *".
+func ParseEntry(str string) (*Entry, error) {
+	split := strings.SplitN(str, "=", 2)
+	if len(split) != 2 {
+		return nil, fmt.Errorf("invalid schedule '%s', expected 'start=<calendar>' or 'stop=<calendar>'", str)
+	}
+	action := Action(strings.TrimSpace(split[0]))
+	if action != ActionStart && action != ActionStop {
+		return nil, fmt.Errorf("unknown schedule action '%s'", action)
+	}
+	calendar, err := ParseCalendar(split[1])
+	if err != nil {
+		return nil, err
+	}
+
+	return &Entry{Action: action, Calendar: calendar}, nil
+}
+
+func (e *Entry) String() string {
+	return fmt.Sprintf("%s=%s", e.Action, e.Calendar)
+}
+
+// Runner runs the scheduled actions on a virtual machine.
+type Runner func(action Action) error
+
+// Scheduler runs the actions of a list of entries when their calendar says
+// so. The schedule can be overridden at runtime with SuspendUntil and
+// SkipNext.
+type Scheduler struct {
+	entries []*Entry
+	run     Runner
+	now     func() time.Time
+
+	lock           sync.Mutex
+	suspendedUntil time.Time
+	skipNext       bool
+	lastAction     Action
+	updated        chan struct{}
+}
+
+// NewScheduler creates a scheduler which will use run to execute the actions
+// of entries.
+func NewScheduler(entries []*Entry, run Runner) *Scheduler {
+	return &Scheduler{
+		entries: entries,
+		run:     run,
+		now:     time.Now,
+		updated: make(chan struct{}, 1),
+	}
+}
+
+// Entries returns the entries of the schedule.
+func (s *Scheduler) Entries() []*Entry {
+	return s.entries
+}
+
+// next returns the next entry to run after t, and when it must run.
+func (s *Scheduler) next(t time.Time) (*Entry, time.Time) {
+	var nextEntry *Entry
+	var nextTime time.Time
+	for _, entry := range s.entries {
+		entryTime := entry.Calendar.Next(t)
+		if entryTime.IsZero() {
+			continue
+		}
+		if nextEntry == nil || entryTime.Before(nextTime) {
+			nextEntry = entry
+			nextTime = entryTime
+		}
+	}
+
+	return nextEntry, nextTime
+}
+
+// Next returns the next action the scheduler will run, and when it will run.
+// Overrides are taken into account. ok is false when there is no upcoming
+// action.
+func (s *Scheduler) Next() (action Action, at time.Time, ok bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	t := s.now()
+	skip := s.skipNext
+	for {
+		entry, entryTime := s.next(t)
+		if entry == nil {
+			return "", time.Time{}, false
+		}
+		if skip || entryTime.Before(s.suspendedUntil) {
+			skip = false
+			t = entryTime
+			continue
+		}
+		return entry.Action, entryTime, true
+	}
+}
+
+// SuspendUntil disables the scheduled actions until t. A zero t resumes the
+// schedule immediately.
+func (s *Scheduler) SuspendUntil(t time.Time) {
+	s.lock.Lock()
+	s.suspendedUntil = t
+	s.lock.Unlock()
+	s.notify()
+}
+
+// SuspendedUntil returns the time until which scheduled actions are disabled.
+func (s *Scheduler) SuspendedUntil() time.Time {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.suspendedUntil
+}
+
+// SkipNext cancels the next scheduled action.
+func (s *Scheduler) SkipNext() {
+	s.lock.Lock()
+	s.skipNext = true
+	s.lock.Unlock()
+	s.notify()
+}
+
+// LastAction returns the last action started by the scheduler, or an empty
+// string if none was started yet.
+func (s *Scheduler) LastAction() Action {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.lastAction
+}
+
+func (s *Scheduler) notify() {
+	select {
+	case s.updated <- struct{}{}:
+	default:
+	}
+}
+
+// Run runs the scheduled actions until ctx is cancelled.
+func (s *Scheduler) Run(ctx context.Context) {
+	// time of the last handled entry, to avoid running it twice if the
+	// timer fires slightly early
+	var lastEntryTime time.Time
+	for {
+		s.lock.Lock()
+		t := s.now()
+		if t.Before(lastEntryTime) {
+			t = lastEntryTime
+		}
+		entry, entryTime := s.next(t)
+		s.lock.Unlock()
+
+		var timer <-chan time.Time
+		if entry != nil {
+			timer = time.After(entryTime.Sub(t))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.updated:
+			continue
+		case <-timer:
+		}
+		lastEntryTime = entryTime
+
+		s.lock.Lock()
+		skip := s.skipNext || entryTime.Before(s.suspendedUntil)
+		s.skipNext = false
+		s.lock.Unlock()
+		if skip {
+			log.Infof("skipping scheduled virtual machine %s", entry.Action)
+			continue
+		}
+
+		log.Infof("running scheduled virtual machine %s (%s)", entry.Action, entry.Calendar)
+		// lastAction is set before running the action so that it's already
+		// up to date when the virtual machine state changes
+		s.lock.Lock()
+		s.lastAction = entry.Action
+		s.lock.Unlock()
+		if err := s.run(entry.Action); err != nil {
+			log.Warnf("scheduled virtual machine %s failed: %v", entry.Action, err)
+		}
+	}
+}
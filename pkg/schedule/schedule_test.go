@@ -0,0 +1,32 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedulerNext(t *testing.T) {
+	var entries []*Entry
+	for _, str := range []string{"stop=0 22 * * *", "start=0 8 * * *"} {
+		entry, err := ParseEntry(str)
+		if err != nil {
+			t.Fatal("expected no error; got", err)
+		}
+		entries = append(entries, entry)
+	}
+	scheduler := NewScheduler(entries, func(Action) error { return nil })
+	scheduler.now = func() time.Time { return time.Date(2023, time.March, 10, 12, 0, 0, 0, time.UTC) }
+
+	action, at, ok := scheduler.Next()
+	if !ok || action != ActionStop || at.Hour() != 22 {
+		t.Fatalf("unexpected next action %s at %v", action, at)
+	}
+	scheduler.SkipNext()
+	if action, at, _ = scheduler.Next(); action != ActionStart || at.Day() != 11 {
+		t.Fatalf("unexpected next action %s at %v", action, at)
+	}
+	scheduler.SuspendUntil(time.Date(2023, time.March, 12, 0, 0, 0, 0, time.UTC))
+	if action, at, _ = scheduler.Next(); action != ActionStart || at.Day() != 12 {
+		t.Fatalf("unexpected next action %s at %v", action, at)
+	}
+}
@@ -0,0 +1,187 @@
+// Package signals maps the POSIX signals received by vfkit to lifecycle
+// actions on the virtual machine, so that scripts can control it with kill(1)
+// without the REST API.
+package signals
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Action is what vfkit does to the virtual machine when it receives a signal.
+type Action string
+
+const (
+	// ActionShutdown asks the guest to shut down and exits, the virtual
+	// machine is forcefully stopped after the shutdown timeout.
+	ActionShutdown Action = "shutdown"
+	// ActionStop forcefully stops the virtual machine and exits.
+	ActionStop Action = "stop"
+	// ActionPause suspends the virtual machine execution.
+	ActionPause Action = "pause"
+	// ActionResume resumes a paused virtual machine.
+	ActionResume Action = "resume"
+	// ActionTogglePause pauses a running virtual machine and resumes a
+	// paused one.
+	ActionTogglePause Action = "toggle-pause"
+	// ActionIgnore ignores the signal.
+	ActionIgnore Action = "ignore"
+	// ActionDefault restores the default behavior of the signal, which
+	// terminates vfkit for most signals.
+	ActionDefault Action = "default"
+)
+
+var actions = []Action{ActionShutdown, ActionStop, ActionPause, ActionResume, ActionTogglePause, ActionIgnore, ActionDefault}
+
+// signalNames are the signals which can be mapped. SIGKILL and SIGSTOP cannot
+// be caught, SIGPIPE and SIGCHLD are used by vfkit itself.
+var signalNames = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"TERM": syscall.SIGTERM,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+}
+
+// Mapping associates signals with the action vfkit runs when receiving them.
+type Mapping map[syscall.Signal]Action
+
+// DefaultMapping returns the mapping used when no --signal argument is given:
+// SIGTERM shuts the virtual machine down, SIGUSR1 pauses it and SIGUSR2
+// resumes it.
+func DefaultMapping() Mapping {
+	return Mapping{
+		syscall.SIGTERM: ActionShutdown,
+		syscall.SIGUSR1: ActionPause,
+		syscall.SIGUSR2: ActionResume,
+	}
+}
+
+// ParseSignal parses a signal name such as "USR1" or "SIGUSR1".
+func ParseSignal(name string) (syscall.Signal, error) {
+	sig, ok := signalNames[strings.TrimPrefix(strings.ToUpper(name), "SIG")]
+	if !ok {
+		return 0, fmt.Errorf("unsupported signal '%s', expected one of %s", name, strings.Join(signalList(), ", "))
+	}
+	return sig, nil
+}
+
+func signalList() []string {
+	names := []string{}
+	for name := range signalNames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseMapping returns the default mapping updated with strs, which are in the
+// "signal=action" format, for example "HUP=toggle-pause".
+func ParseMapping(strs []string) (Mapping, error) {
+	mapping := DefaultMapping()
+	for _, str := range strs {
+		split := strings.SplitN(str, "=", 2)
+		if len(split) != 2 {
+			return nil, fmt.Errorf("invalid signal mapping '%s', expected '<signal>=<action>'", str)
+		}
+		sig, err := ParseSignal(strings.TrimSpace(split[0]))
+		if err != nil {
+			return nil, err
+		}
+		action := Action(strings.TrimSpace(split[1]))
+		if !isAction(action) {
+			return nil, fmt.Errorf("unknown signal action '%s'", action)
+		}
+		mapping[sig] = action
+	}
+
+	return mapping, nil
+}
+
+func isAction(action Action) bool {
+	for _, a := range actions {
+		if action == a {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the mapping in the format of ParseMapping, sorted by signal
+// number.
+func (m Mapping) String() string {
+	sigs := []int{}
+	for sig := range m {
+		sigs = append(sigs, int(sig))
+	}
+	sort.Ints(sigs)
+	strs := []string{}
+	for _, sig := range sigs {
+		strs = append(strs, fmt.Sprintf("%s=%s", signalName(syscall.Signal(sig)), m[syscall.Signal(sig)]))
+	}
+	return strings.Join(strs, ",")
+}
+
+func signalName(sig syscall.Signal) string {
+	for name, s := range signalNames {
+		if s == sig {
+			return name
+		}
+	}
+	return strconv.Itoa(int(sig))
+}
+
+// Runner runs an action on the virtual machine.
+type Runner func(action Action) error
+
+// Handle installs the signal handlers of m and runs the actions with run until
+// stop is called. ActionIgnore and ActionDefault are handled by the Go runtime
+// and never passed to run.
+func (m Mapping) Handle(run Runner) (stop func()) {
+	notified := []os.Signal{}
+	for sig, action := range m {
+		switch action {
+		case ActionIgnore:
+			signal.Ignore(sig)
+		case ActionDefault:
+			signal.Reset(sig)
+		default:
+			notified = append(notified, sig)
+		}
+	}
+	signalCh := make(chan os.Signal, 1)
+	if len(notified) == 0 {
+		return func() {}
+	}
+	signal.Notify(signalCh, notified...)
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case s := <-signalCh:
+				sig := s.(syscall.Signal)
+				action := m[sig]
+				log.Infof("received %s, running '%s' action", signalName(sig), action)
+				if err := run(action); err != nil {
+					log.Errorf("'%s' action for %s failed: %v", action, signalName(sig), err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(signalCh)
+		close(done)
+	}
+}
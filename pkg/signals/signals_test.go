@@ -0,0 +1,45 @@
+package signals
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseMapping(t *testing.T) {
+	mapping, err := ParseMapping([]string{"SIGHUP=toggle-pause", "usr1=ignore", "TERM=stop"})
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	expected := "HUP=toggle-pause,USR1=ignore,USR2=resume,TERM=stop"
+	if mapping.String() != expected {
+		t.Fatalf("expected %s; got %s", expected, mapping)
+	}
+
+	for _, str := range []string{"HUP", "KILL=stop", "HUP=reboot"} {
+		if _, err := ParseMapping([]string{str}); err == nil {
+			t.Fatalf("expected error when parsing %q", str)
+		}
+	}
+}
+
+func TestHandle(t *testing.T) {
+	actions := make(chan Action, 1)
+	stop := Mapping{syscall.SIGUSR1: ActionPause}.Handle(func(action Action) error {
+		actions <- action
+		return nil
+	})
+	defer stop()
+
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	select {
+	case action := <-actions:
+		if action != ActionPause {
+			t.Fatalf("expected %s; got %s", ActionPause, action)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the signal action")
+	}
+}
@@ -0,0 +1,16 @@
+package snapshot
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// CloneFile creates dst as a copy-on-write clone of src. It falls back to a
+// sparse copy when the file system does not support clones.
+func CloneFile(src string, dst string) error {
+	err := unix.Clonefile(src, dst, unix.CLONE_NOFOLLOW)
+	if err == unix.ENOTSUP || err == unix.EXDEV {
+		return copyFile(src, dst)
+	}
+
+	return err
+}
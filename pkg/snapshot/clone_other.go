@@ -0,0 +1,9 @@
+//go:build !darwin
+// +build !darwin
+
+package snapshot
+
+// CloneFile copies src to dst, clonefile(2) is only available on macOS.
+func CloneFile(src string, dst string) error {
+	return copyFile(src, dst)
+}
@@ -0,0 +1,24 @@
+package snapshot
+
+import (
+	"os"
+
+	"github.com/crc-org/vfkit/pkg/diskimage"
+)
+
+// copyFile creates dst as a sparse copy of src.
+func copyFile(src string, dst string) error {
+	file, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := diskimage.CopyInto(src, dst); err != nil {
+		_ = os.Remove(dst)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,189 @@
+// Package snapshot manages point-in-time snapshots of raw disk images.
+//
+// Snapshots are copy-on-write clones of the disk image made with clonefile(2),
+// they are created instantly and only use disk space for the blocks which
+// change afterwards. This requires the disk image to be on an APFS volume,
+// other file systems and operating systems fall back to a sparse copy.
+//
+// The snapshots of an image are stored in the <image>.snapshots directory
+// next to it, along with an index.json file with their metadata.
+package snapshot
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/crc-org/vfkit/pkg/diskimage"
+	"github.com/crc-org/vfkit/pkg/rest/define"
+)
+
+// index is the content of the index.json file of a snapshot directory.
+type index struct {
+	NextID    int               `json:"nextID"`
+	Snapshots []define.Snapshot `json:"snapshots"`
+}
+
+// Store manages the snapshots of a disk image. It is safe for concurrent use,
+// but a single Store must be used for a given image.
+type Store struct {
+	mu        sync.Mutex
+	imagePath string
+	dir       string
+}
+
+// NewStore creates a Store for the snapshots of the raw disk image at
+// imagePath.
+func NewStore(imagePath string) *Store {
+	return &Store{
+		imagePath: imagePath,
+		dir:       imagePath + ".snapshots",
+	}
+}
+
+func (s *Store) indexPath() string {
+	return filepath.Join(s.dir, "index.json")
+}
+
+func (s *Store) snapshotPath(id string) string {
+	return filepath.Join(s.dir, id+".img")
+}
+
+func (s *Store) readIndex() (*index, error) {
+	idx := index{NextID: 1, Snapshots: []define.Snapshot{}}
+	data, err := os.ReadFile(s.indexPath())
+	if os.IsNotExist(err) {
+		return &idx, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return nil, fmt.Errorf("invalid snapshot index %s: %w", s.indexPath(), err)
+	}
+
+	return &idx, nil
+}
+
+// writeIndex replaces the index file atomically, so that a crash leaves the
+// previous version.
+func (s *Store) writeIndex(idx *index) error {
+	data, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := s.indexPath() + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, s.indexPath())
+}
+
+func findSnapshot(idx *index, id string) (int, error) {
+	for i, snapshot := range idx.Snapshots {
+		if snapshot.ID == id {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("%w: no snapshot with ID '%s'", os.ErrNotExist, id)
+}
+
+// List returns the snapshots of the image, oldest first.
+func (s *Store) List() ([]define.Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	idx, err := s.readIndex()
+	if err != nil {
+		return nil, err
+	}
+	return idx.Snapshots, nil
+}
+
+// Create snapshots the current content of the image. The guest must not be
+// writing to the image while the snapshot is taken, see vf.SnapshotManager
+// which pauses the virtual machine. name is an optional description.
+func (s *Store) Create(name string) (*define.Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	idx, err := s.readIndex()
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(s.imagePath)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return nil, err
+	}
+
+	snapshot := define.Snapshot{
+		ID:        "snap" + strconv.Itoa(idx.NextID),
+		Name:      name,
+		CreatedAt: time.Now().UTC(),
+		SizeBytes: uint64(info.Size()),
+	}
+	path := s.snapshotPath(snapshot.ID)
+	// leftover of a crash before the index was updated
+	_ = os.Remove(path)
+	if err := CloneFile(s.imagePath, path); err != nil {
+		return nil, fmt.Errorf("failed to snapshot %s: %w", s.imagePath, err)
+	}
+	idx.NextID++
+	idx.Snapshots = append(idx.Snapshots, snapshot)
+	if err := s.writeIndex(idx); err != nil {
+		_ = os.Remove(path)
+		return nil, err
+	}
+
+	return &snapshot, nil
+}
+
+// Restore replaces the content of the image with the content of the snapshot
+// with the given ID. The image keeps its inode, so a stopped virtual machine
+// which has it open boots from the restored content. The snapshot is kept.
+func (s *Store) Restore(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	idx, err := s.readIndex()
+	if err != nil {
+		return err
+	}
+	if _, err := findSnapshot(idx, id); err != nil {
+		return err
+	}
+
+	return diskimage.CopyInto(s.snapshotPath(id), s.imagePath)
+}
+
+// Delete removes the snapshot with the given ID.
+func (s *Store) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	idx, err := s.readIndex()
+	if err != nil {
+		return err
+	}
+	i, err := findSnapshot(idx, id)
+	if err != nil {
+		return err
+	}
+	idx.Snapshots = append(idx.Snapshots[:i], idx.Snapshots[i+1:]...)
+	if err := s.writeIndex(idx); err != nil {
+		return err
+	}
+	if err := os.Remove(s.snapshotPath(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
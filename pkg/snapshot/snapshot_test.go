@@ -0,0 +1,65 @@
+package snapshot
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	imagePath := filepath.Join(t.TempDir(), "disk.img")
+	original := bytes.Repeat([]byte("original"), 20000)
+	if err := os.WriteFile(imagePath, original, 0600); err != nil {
+		t.Fatal(err)
+	}
+	store := NewStore(imagePath)
+
+	snapshots, err := store.List()
+	if err != nil || len(snapshots) != 0 {
+		t.Fatalf("expected no snapshots; got %v, %v", snapshots, err)
+	}
+	snapshot, err := store.Create("before upgrade")
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if snapshot.ID != "snap1" || snapshot.Name != "before upgrade" || snapshot.SizeBytes != uint64(len(original)) {
+		t.Fatalf("unexpected snapshot: %+v", snapshot)
+	}
+
+	// the guest writes to the disk, keeping the image open like the
+	// virtualization framework does
+	file, err := os.OpenFile(imagePath, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteAt([]byte("modified"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Restore(snapshot.ID); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	restored := make([]byte, len(original))
+	if _, err := file.ReadAt(restored, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(restored, original) {
+		t.Fatal("expected the open image to have the snapshot content after a restore")
+	}
+
+	// a new store sees the same snapshots, IDs are not reused
+	store = NewStore(imagePath)
+	if err := store.Delete(snapshot.ID); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if err := store.Restore(snapshot.ID); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected ErrNotExist for a deleted snapshot; got %v", err)
+	}
+	snapshot, err = store.Create("")
+	if err != nil || snapshot.ID != "snap2" {
+		t.Fatalf("expected a new snapshot ID; got %+v, %v", snapshot, err)
+	}
+}
@@ -0,0 +1,190 @@
+// Package throttle limits the host resources used by a vfkit process, so that
+// background virtual machines don't drain the battery of laptops.
+//
+// The CPU usage of vfkit is capped by pausing the virtual machine for short
+// periods when it used more CPU time than allowed, macOS has no equivalent of
+// the Linux CPU cgroup controller. The QoS class and I/O priority are process
+// attributes applied by vfkit at startup.
+package throttle
+
+import (
+	"context"
+	"fmt"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// QoSClass is the macOS quality of service class of the vfkit process.
+type QoSClass string
+
+const (
+	// QoSDefault leaves the scheduling of vfkit unchanged
+	QoSDefault QoSClass = "default"
+	// QoSUtility lowers the CPU scheduling priority of vfkit
+	QoSUtility QoSClass = "utility"
+	// QoSBackground puts vfkit in the background band: lowest CPU
+	// priority, and throttled disk and network I/O
+	QoSBackground QoSClass = "background"
+)
+
+// ParseQoSClass converts a QoS class name to a QoSClass.
+func ParseQoSClass(str string) (QoSClass, error) {
+	switch qos := QoSClass(str); qos {
+	case QoSDefault, QoSUtility, QoSBackground:
+		return qos, nil
+	default:
+		return "", fmt.Errorf("invalid QoS class '%s', must be 'default', 'utility' or 'background'", str)
+	}
+}
+
+// IOPriority is the disk I/O policy of the vfkit process, the names are the
+// ones of the IOPOL_* policies of setiopolicy_np(3).
+type IOPriority string
+
+const (
+	IOPriorityImportant IOPriority = "important"
+	IOPriorityStandard  IOPriority = "standard"
+	IOPriorityUtility   IOPriority = "utility"
+	IOPriorityThrottle  IOPriority = "throttle"
+	IOPriorityPassive   IOPriority = "passive"
+)
+
+// ParseIOPriority converts an I/O priority name to an IOPriority.
+func ParseIOPriority(str string) (IOPriority, error) {
+	switch priority := IOPriority(str); priority {
+	case IOPriorityImportant, IOPriorityStandard, IOPriorityUtility, IOPriorityThrottle, IOPriorityPassive:
+		return priority, nil
+	default:
+		return "", fmt.Errorf("invalid I/O priority '%s', must be 'important', 'standard', 'utility', 'throttle' or 'passive'", str)
+	}
+}
+
+// maxPause is the longest the virtual machine is paused at once, so that it
+// keeps answering network requests even with a low CPU limit.
+const maxPause = 2 * time.Second
+
+// PauseDuration returns how long a process which used cpuTime during elapsed
+// must be paused so that its average CPU usage is at most limit percent of
+// one host CPU. It's 0 when the process is within its limit.
+func PauseDuration(cpuTime time.Duration, elapsed time.Duration, limit uint) time.Duration {
+	if limit == 0 {
+		return 0
+	}
+	pause := cpuTime*100/time.Duration(limit) - elapsed
+	if pause <= 0 {
+		return 0
+	}
+	if pause > maxPause {
+		return maxPause
+	}
+
+	return pause
+}
+
+// Actions are the callbacks the Limiter uses to pause and resume the virtual
+// machine.
+type Actions struct {
+	Pause  func() error
+	Resume func() error
+}
+
+// Limiter caps the CPU usage of the vfkit process by pausing its virtual
+// machine when it used more than its share of CPU time.
+type Limiter struct {
+	limit       uint
+	interval    time.Duration
+	actions     Actions
+	readCPUTime func() (time.Duration, error)
+
+	lastCPUTime time.Duration
+	lastTime    time.Time
+}
+
+// NewLimiter creates a limiter which keeps the CPU usage of vfkit below limit
+// percent of one host CPU, 150 allows one and a half CPUs.
+func NewLimiter(limit uint, actions Actions) *Limiter {
+	return &Limiter{
+		limit:       limit,
+		interval:    250 * time.Millisecond,
+		actions:     actions,
+		readCPUTime: processCPUTime,
+	}
+}
+
+// processCPUTime returns the user and system CPU time used by this process.
+func processCPUTime() (time.Duration, error) {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return 0, err
+	}
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano()), nil
+}
+
+func (l *Limiter) sample() error {
+	cpuTime, err := l.readCPUTime()
+	if err != nil {
+		return err
+	}
+	l.lastCPUTime = cpuTime
+	l.lastTime = time.Now()
+	return nil
+}
+
+// check returns how long the virtual machine must be paused since the last
+// sample.
+func (l *Limiter) check() time.Duration {
+	lastCPUTime, lastTime := l.lastCPUTime, l.lastTime
+	if err := l.sample(); err != nil {
+		log.Debugf("failed to get vfkit CPU usage: %v", err)
+		return 0
+	}
+
+	return PauseDuration(l.lastCPUTime-lastCPUTime, l.lastTime.Sub(lastTime), l.limit)
+}
+
+// throttle pauses the virtual machine for pause, or until ctx is cancelled.
+func (l *Limiter) throttle(ctx context.Context, pause time.Duration) {
+	log.Debugf("CPU usage above %d%%, pausing virtual machine for %s", l.limit, pause)
+	if err := l.actions.Pause(); err != nil {
+		// the virtual machine may already be paused or stopped
+		log.Debugf("failed to pause virtual machine: %v", err)
+		return
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(pause):
+	}
+	if err := l.actions.Resume(); err != nil {
+		log.Warnf("failed to resume virtual machine: %v", err)
+	}
+	// the CPU time used while the virtual machine was paused is not
+	// counted in the next window
+	if err := l.sample(); err != nil {
+		log.Debugf("failed to get vfkit CPU usage: %v", err)
+	}
+}
+
+// Run limits the CPU usage until ctx is cancelled.
+func (l *Limiter) Run(ctx context.Context) {
+	if l.limit == 0 {
+		return
+	}
+	if err := l.sample(); err != nil {
+		log.Warnf("cannot limit CPU usage: %v", err)
+		return
+	}
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if pause := l.check(); pause > 0 {
+				l.throttle(ctx, pause)
+			}
+		}
+	}
+}
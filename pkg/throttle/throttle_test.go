@@ -0,0 +1,71 @@
+package throttle
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPauseDuration(t *testing.T) {
+	for _, test := range []struct {
+		cpuTime time.Duration
+		elapsed time.Duration
+		limit   uint
+		pause   time.Duration
+	}{
+		{100 * time.Millisecond, time.Second, 50, 0},
+		{500 * time.Millisecond, time.Second, 50, 0},
+		{time.Second, time.Second, 50, time.Second},
+		// two busy vCPUs with a limit of one CPU
+		{500 * time.Millisecond, 250 * time.Millisecond, 100, 250 * time.Millisecond},
+		{time.Second, 250 * time.Millisecond, 10, maxPause},
+		{time.Second, time.Second, 0, 0},
+	} {
+		pause := PauseDuration(test.cpuTime, test.elapsed, test.limit)
+		if pause != test.pause {
+			t.Errorf("%s of CPU time in %s with a %d%% limit: expected a pause of %s; got %s", test.cpuTime, test.elapsed, test.limit, test.pause, pause)
+		}
+	}
+}
+
+func TestLimiterThrottle(t *testing.T) {
+	paused, resumed := 0, 0
+	limiter := NewLimiter(50, Actions{
+		Pause:  func() error { paused++; return nil },
+		Resume: func() error { resumed++; return nil },
+	})
+	cpuTime := time.Duration(0)
+	limiter.readCPUTime = func() (time.Duration, error) { return cpuTime, nil }
+
+	if err := limiter.sample(); err != nil {
+		t.Fatalf("expected no error; got %v", err)
+	}
+	if pause := limiter.check(); pause != 0 {
+		t.Fatalf("expected no pause without CPU usage; got %s", pause)
+	}
+
+	cpuTime += time.Second
+	pause := limiter.check()
+	if pause <= 0 {
+		t.Fatal("expected a pause when the CPU limit is exceeded")
+	}
+	limiter.throttle(context.Background(), time.Millisecond)
+	if paused != 1 || resumed != 1 {
+		t.Fatalf("expected the virtual machine to be paused and resumed once; got %d pauses and %d resumes", paused, resumed)
+	}
+}
+
+func TestParse(t *testing.T) {
+	if _, err := ParseQoSClass("realtime"); err == nil {
+		t.Fatal("expected error for invalid QoS class")
+	}
+	if qos, err := ParseQoSClass("background"); err != nil || qos != QoSBackground {
+		t.Fatalf("expected background QoS class; got %s, %v", qos, err)
+	}
+	if _, err := ParseIOPriority("low"); err == nil {
+		t.Fatal("expected error for invalid I/O priority")
+	}
+	if priority, err := ParseIOPriority("throttle"); err != nil || priority != IOPriorityThrottle {
+		t.Fatalf("expected throttle I/O priority; got %s, %v", priority, err)
+	}
+}
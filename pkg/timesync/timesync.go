@@ -0,0 +1,150 @@
+// Package timesync parses the --timesync options and implements the drift
+// computations of the guest time synchronization. vfkit sets the guest clock
+// when the host wakes up from sleep, and optionally checks it periodically to
+// correct its drift.
+package timesync
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/crc-org/vfkit/pkg/util"
+)
+
+// DefaultTolerance is the drift below which the guest clock is not changed by
+// the periodic drift correction.
+const DefaultTolerance = 100 * time.Millisecond
+
+// Options are the settings of the guest time synchronization.
+type Options struct {
+	// VsockPort is the vsock port of the guest agent
+	VsockPort uint
+	// Agent is true when the guest agent is the vfkit guest agent instead
+	// of qemu-guest-agent
+	Agent bool
+	// Interval is the period of the drift correction, the guest clock is
+	// only set when the host wakes up from sleep when it's 0
+	Interval time.Duration
+	// Tolerance is the drift below which the periodic drift correction
+	// does not change the guest clock
+	Tolerance time.Duration
+}
+
+// Parse parses the value of the --timesync argument, such as
+// "vsockPort=1234,interval=30s".
+func Parse(str string) (*Options, error) {
+	optsStrv, err := util.SplitOptions(str)
+	if err != nil {
+		return nil, err
+	}
+	opts := Options{}
+	for _, opt := range optsStrv {
+		if opt == "" {
+			continue
+		}
+		split := strings.SplitN(opt, "=", 2)
+		value := ""
+		if len(split) == 2 {
+			value = util.UnquoteOptionValue(split[1])
+		}
+		if err := opts.Set(split[0], value); err != nil {
+			return nil, err
+		}
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &opts, nil
+}
+
+// Set sets the option named key to value, as if "key=value" was given to
+// --timesync.
+func (opts *Options) Set(key string, value string) error {
+	switch key {
+	case "vsockPort":
+		vsockPort, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid timesync vsockPort: %s", value)
+		}
+		opts.VsockPort = uint(vsockPort)
+	case "agent":
+		if value != "" {
+			return fmt.Errorf("Unexpected value for timesync 'agent' option: %s", value)
+		}
+		opts.Agent = true
+	case "interval":
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval < time.Second {
+			return fmt.Errorf("invalid timesync interval '%s', it must be at least 1s", value)
+		}
+		opts.Interval = interval
+	case "tolerance":
+		tolerance, err := time.ParseDuration(value)
+		if err != nil || tolerance < 0 {
+			return fmt.Errorf("invalid timesync tolerance: %s", value)
+		}
+		opts.Tolerance = tolerance
+	default:
+		return fmt.Errorf("Unknown option for timesync parameter: %s", key)
+	}
+
+	return nil
+}
+
+// Validate checks that the mandatory options are set.
+func (opts *Options) Validate() error {
+	if opts.VsockPort == 0 {
+		return fmt.Errorf("Missing 'vsockPort' option for timesync parameter")
+	}
+	if opts.Tolerance != 0 && opts.Interval == 0 {
+		return fmt.Errorf("the timesync 'tolerance' option needs an 'interval'")
+	}
+
+	return nil
+}
+
+// String returns opts in the format of Parse.
+func (opts *Options) String() string {
+	strs := []string{fmt.Sprintf("vsockPort=%d", opts.VsockPort)}
+	if opts.Agent {
+		strs = append(strs, "agent")
+	}
+	if opts.Interval != 0 {
+		strs = append(strs, fmt.Sprintf("interval=%s", opts.Interval))
+	}
+	if opts.Tolerance != 0 {
+		strs = append(strs, fmt.Sprintf("tolerance=%s", opts.Tolerance))
+	}
+
+	return strings.Join(strs, ",")
+}
+
+// EffectiveTolerance returns the tolerance of the drift correction,
+// DefaultTolerance when it's not set.
+func (opts *Options) EffectiveTolerance() time.Duration {
+	if opts.Tolerance == 0 {
+		return DefaultTolerance
+	}
+	return opts.Tolerance
+}
+
+// Drift returns how far the guest clock is ahead of the host clock, negative
+// when it's behind. guest was read from the guest between the before and
+// after host times, it's compared to the middle of this interval to account
+// for the round trip to the guest agent.
+func Drift(before time.Time, guest time.Time, after time.Time) time.Duration {
+	middle := before.Add(after.Sub(before) / 2)
+	return guest.Sub(middle)
+}
+
+// NeedsCorrection returns true if the guest clock must be set to correct
+// drift.
+func (opts *Options) NeedsCorrection(drift time.Duration) bool {
+	if drift < 0 {
+		drift = -drift
+	}
+	return drift > opts.EffectiveTolerance()
+}
@@ -0,0 +1,49 @@
+package timesync
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	opts, err := Parse("vsockPort=1234,agent,interval=30s,tolerance=250ms")
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	expected := Options{VsockPort: 1234, Agent: true, Interval: 30 * time.Second, Tolerance: 250 * time.Millisecond}
+	if *opts != expected {
+		t.Fatalf("unexpected options: %+v", opts)
+	}
+	if opts.String() != "vsockPort=1234,agent,interval=30s,tolerance=250ms" {
+		t.Fatalf("unexpected string: %s", opts.String())
+	}
+
+	opts, err = Parse("vsockPort=1234")
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if opts.Interval != 0 || opts.EffectiveTolerance() != DefaultTolerance {
+		t.Fatalf("unexpected options: %+v", opts)
+	}
+
+	for _, invalid := range []string{"", "agent", "vsockPort=1234,interval=10ms", "vsockPort=1234,tolerance=1s", "vsockPort=1234,period=1m", "vsockPort=abc"} {
+		if _, err := Parse(invalid); err == nil {
+			t.Fatalf("expected error for %s", invalid)
+		}
+	}
+}
+
+func TestDrift(t *testing.T) {
+	before := time.Unix(1000, 0)
+	after := before.Add(20 * time.Millisecond)
+	opts := Options{VsockPort: 1234, Interval: time.Minute}
+
+	drift := Drift(before, before.Add(10*time.Millisecond), after)
+	if drift != 0 || opts.NeedsCorrection(drift) {
+		t.Fatalf("unexpected drift %s", drift)
+	}
+	drift = Drift(before, before.Add(-2*time.Second), after)
+	if drift != -2010*time.Millisecond || !opts.NeedsCorrection(drift) {
+		t.Fatalf("unexpected drift %s", drift)
+	}
+}
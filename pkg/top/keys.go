@@ -0,0 +1,53 @@
+package top
+
+// Key is a key binding of the dashboard.
+type Key int
+
+const (
+	KeyNone Key = iota
+	KeyUp
+	KeyDown
+	KeyPause
+	KeyStop
+	KeyConsole
+	KeyQuit
+)
+
+// ParseKeys returns the key bindings typed in input, which is read from a
+// terminal in non-canonical mode. Unknown keys are ignored.
+func ParseKeys(input []byte) []Key {
+	keys := []Key{}
+	for i := 0; i < len(input); i++ {
+		key := KeyNone
+		switch input[i] {
+		case 'k':
+			key = KeyUp
+		case 'j':
+			key = KeyDown
+		case 'p', ' ':
+			key = KeyPause
+		case 's':
+			key = KeyStop
+		case 'c', '\r', '\n':
+			key = KeyConsole
+		case 'q', 0x03, 0x04:
+			key = KeyQuit
+		case 0x1b:
+			// arrow keys are sent as ESC [ A or ESC O A
+			if i+2 < len(input) && (input[i+1] == '[' || input[i+1] == 'O') {
+				switch input[i+2] {
+				case 'A':
+					key = KeyUp
+				case 'B':
+					key = KeyDown
+				}
+				i += 2
+			}
+		}
+		if key != KeyNone {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
@@ -0,0 +1,146 @@
+// Package top implements the 'vfkit top' dashboard, which shows the resource
+// usage of the running virtual machines and controls them with key bindings.
+package top
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/crc-org/vfkit/pkg/inventory"
+	"github.com/crc-org/vfkit/pkg/output"
+)
+
+// Row is a virtual machine of the dashboard. The rates are computed between
+// two samples, they are 0 for the first sample.
+type Row struct {
+	Name        string
+	State       string
+	CPUs        uint
+	GuestIP     string
+	MemoryBytes uint64
+	// CPUPercent is the CPU usage of the vfkit process, 100 for one fully
+	// used host CPU
+	CPUPercent        float64
+	DiskReadPerSec    float64
+	DiskWrittenPerSec float64
+}
+
+type sample struct {
+	time             time.Time
+	cpuTimeSeconds   float64
+	diskReadBytes    uint64
+	diskWrittenBytes uint64
+}
+
+// Sampler computes the rows of the dashboard from successive inventories.
+type Sampler struct {
+	previous map[string]sample
+}
+
+// NewSampler creates a Sampler with no previous sample.
+func NewSampler() *Sampler {
+	return &Sampler{previous: map[string]sample{}}
+}
+
+// Update returns the rows for entries, sampled at now. Stopped virtual
+// machines are skipped.
+func (s *Sampler) Update(entries []inventory.Entry, now time.Time) []Row {
+	rows := []Row{}
+	current := map[string]sample{}
+	for _, entry := range entries {
+		if entry.PID == 0 {
+			continue
+		}
+		row := Row{
+			Name:        entry.Name,
+			State:       entry.State,
+			CPUs:        entry.CPUs,
+			GuestIP:     entry.GuestIP,
+			MemoryBytes: entry.MemoryFootprintBytes,
+		}
+		cur := sample{
+			time:             now,
+			cpuTimeSeconds:   entry.CPUTimeSeconds,
+			diskReadBytes:    entry.DiskReadBytes,
+			diskWrittenBytes: entry.DiskWrittenBytes,
+		}
+		// the counters restart from 0 when the virtual machine restarts
+		if prev, ok := s.previous[entry.Name]; ok && cur.cpuTimeSeconds >= prev.cpuTimeSeconds {
+			if elapsed := now.Sub(prev.time).Seconds(); elapsed > 0 {
+				row.CPUPercent = (cur.cpuTimeSeconds - prev.cpuTimeSeconds) / elapsed * 100
+				if cur.diskReadBytes >= prev.diskReadBytes {
+					row.DiskReadPerSec = float64(cur.diskReadBytes-prev.diskReadBytes) / elapsed
+				}
+				if cur.diskWrittenBytes >= prev.diskWrittenBytes {
+					row.DiskWrittenPerSec = float64(cur.diskWrittenBytes-prev.diskWrittenBytes) / elapsed
+				}
+			}
+		}
+		current[entry.Name] = cur
+		rows = append(rows, row)
+	}
+	s.previous = current
+
+	return rows
+}
+
+// Table returns rows as a table, the row at index selected is marked with
+// '>'.
+func Table(rows []Row, selected int) *output.Table {
+	table := output.NewTable("", "NAME", "STATE", "CPUS", "CPU%", "MEMORY", "DISK READ/S", "DISK WRITE/S", "GUEST IP")
+	table.ColumnColor = func(column int, value string) output.Color {
+		if column == 2 {
+			return output.StateColor(value)
+		}
+		return output.NoColor
+	}
+	for i, row := range rows {
+		marker := ""
+		if i == selected {
+			marker = ">"
+		}
+		table.AddRow(
+			marker,
+			row.Name,
+			row.State,
+			fmt.Sprintf("%d", row.CPUs),
+			fmt.Sprintf("%.1f", row.CPUPercent),
+			formatBytes(float64(row.MemoryBytes)),
+			formatBytes(row.DiskReadPerSec),
+			formatBytes(row.DiskWrittenPerSec),
+			row.GuestIP,
+		)
+	}
+
+	return table
+}
+
+// keyHelp is shown at the bottom of the dashboard.
+const keyHelp = "up/down: select   p: pause/resume   s: stop   c: console   q: quit"
+
+// Render clears the terminal and draws the dashboard. status is a message
+// about the last action, it can be empty.
+func Render(w io.Writer, rows []Row, selected int, status string, color bool) error {
+	if _, err := fmt.Fprintf(w, "\x1b[H\x1b[2Jvfkit top - %s - %d running\n\n", time.Now().Format("15:04:05"), len(rows)); err != nil {
+		return err
+	}
+	if err := Table(rows, selected).Write(w, color); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "\n%s\n%s\n", status, keyHelp)
+	return err
+}
+
+func formatBytes(bytes float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	i := 0
+	for bytes >= 1024 && i < len(units)-1 {
+		bytes /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f%s", bytes, units[i])
+	}
+	return fmt.Sprintf("%.1f%s", bytes, units[i])
+}
@@ -0,0 +1,76 @@
+package top
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/crc-org/vfkit/pkg/inventory"
+)
+
+func TestSamplerUpdate(t *testing.T) {
+	sampler := NewSampler()
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	entries := []inventory.Entry{
+		{Name: "db", State: "stopped"},
+		{Name: "web", State: "running", PID: 42, CPUs: 2, MemoryFootprintBytes: 1 << 30, CPUTimeSeconds: 10, DiskReadBytes: 1000, DiskWrittenBytes: 500},
+	}
+	rows := sampler.Update(entries, start)
+	if len(rows) != 1 {
+		t.Fatalf("expected 1 row; got %d", len(rows))
+	}
+	if rows[0].CPUPercent != 0 || rows[0].DiskReadPerSec != 0 {
+		t.Fatalf("expected no rates for the first sample; got %+v", rows[0])
+	}
+
+	entries[1].CPUTimeSeconds = 13
+	entries[1].DiskReadBytes = 5000
+	entries[1].DiskWrittenBytes = 2500
+	rows = sampler.Update(entries, start.Add(2*time.Second))
+	expected := Row{
+		Name:              "web",
+		State:             "running",
+		CPUs:              2,
+		MemoryBytes:       1 << 30,
+		CPUPercent:        150,
+		DiskReadPerSec:    2000,
+		DiskWrittenPerSec: 1000,
+	}
+	if !reflect.DeepEqual(rows, []Row{expected}) {
+		t.Fatalf("expected %+v; got %+v", expected, rows)
+	}
+
+	// restarted virtual machine
+	entries[1].CPUTimeSeconds = 1
+	rows = sampler.Update(entries, start.Add(4*time.Second))
+	if rows[0].CPUPercent != 0 {
+		t.Fatalf("expected no CPU usage after a restart; got %f", rows[0].CPUPercent)
+	}
+}
+
+func TestTable(t *testing.T) {
+	rows := []Row{
+		{Name: "db", State: "paused", CPUs: 1, MemoryBytes: 512 << 20},
+		{Name: "web", State: "running", CPUs: 2, MemoryBytes: 1 << 30, CPUPercent: 12.34, DiskReadPerSec: 2048, DiskWrittenPerSec: 10, GuestIP: "192.168.64.3"},
+	}
+	var buf bytes.Buffer
+	if err := Table(rows, 1).Write(&buf, false); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	expected := `    NAME   STATE     CPUS   CPU%   MEMORY     DISK READ/S   DISK WRITE/S   GUEST IP
+    db     paused    1      0.0    512.0MiB   0B            0B
+>   web    running   2      12.3   1.0GiB     2.0KiB        10B            192.168.64.3
+`
+	if buf.String() != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	keys := ParseKeys([]byte("j\x1b[Ak\x1bOBpsxcq\x03"))
+	expected := []Key{KeyDown, KeyUp, KeyUp, KeyDown, KeyPause, KeyStop, KeyConsole, KeyQuit, KeyQuit}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("expected %v; got %v", expected, keys)
+	}
+}
@@ -0,0 +1,112 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InstanceRecord describes a running vfkit process. vfkit writes it in its
+// state directory so that a virtual machine manager which was restarted can
+// find the virtual machines it started and manage them again.
+type InstanceRecord struct {
+	PID        int       `json:"pid"`
+	RestfulURI string    `json:"restfulURI,omitempty"`
+	Version    string    `json:"version"`
+	StartTime  time.Time `json:"startTime"`
+
+	// The following fields describe the virtual machine for inventories
+	Name         string            `json:"name,omitempty"`
+	SerialNumber string            `json:"serialNumber,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	CPUs         uint              `json:"cpus,omitempty"`
+	MemoryBytes  uint64            `json:"memoryBytes,omitempty"`
+	Disks        []string          `json:"disks,omitempty"`
+	MACAddresses []string          `json:"macAddresses,omitempty"`
+	// ConfigDigest is the sha256 digest of the virtual machine
+	// configuration, it's the same for two processes started with the same
+	// devices and settings
+	ConfigDigest string `json:"configDigest,omitempty"`
+}
+
+// WriteInstanceRecord writes record to path. It fails if path describes
+// another vfkit process which is still running.
+func WriteInstanceRecord(path string, record *InstanceRecord) error {
+	if existing, err := ReadInstanceRecord(path); err == nil && existing.PID != record.PID {
+		return fmt.Errorf("%s is used by running vfkit process %d", path, existing.PID)
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// ReadInstanceRecord reads the record at path. An error is returned if the
+// process it describes is no longer running.
+func ReadInstanceRecord(path string) (*InstanceRecord, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var record InstanceRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("invalid instance record %s: %w", path, err)
+	}
+	if !ProcessRunning(record.PID) {
+		return nil, fmt.Errorf("stale instance record %s: process %d is not running", path, record.PID)
+	}
+
+	return &record, nil
+}
+
+// RegistryRecordPath returns the path of the record of the vfkit process pid
+// in the registry directory dir, see naming.RegistryDir.
+func RegistryRecordPath(dir string, pid int) string {
+	return filepath.Join(dir, strconv.Itoa(pid)+".json")
+}
+
+// ListInstanceRecords returns the records of the running vfkit processes
+// registered in dir, sorted by start time. The stale records of processes
+// which were killed before they could remove them are deleted.
+func ListInstanceRecords(dir string) ([]*InstanceRecord, error) {
+	dirEntries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return []*InstanceRecord{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	records := []*InstanceRecord{}
+	for _, dirEntry := range dirEntries {
+		if dirEntry.IsDir() || !strings.HasSuffix(dirEntry.Name(), ".json") {
+			continue
+		}
+		path := filepath.Join(dir, dirEntry.Name())
+		record, err := ReadInstanceRecord(path)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				_ = os.Remove(path)
+			}
+			continue
+		}
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].StartTime.Before(records[j].StartTime) })
+
+	return records, nil
+}
@@ -0,0 +1,75 @@
+package util
+
+import "strings"
+
+// SplitKernelCmdLine splits a kernel command line into its arguments.
+// Double-quoted values can contain spaces, as in `dyndbg="file drivers/* +p"`.
+func SplitKernelCmdLine(cmdline string) []string {
+	args := []string{}
+	var current strings.Builder
+	inQuotes := false
+	for _, c := range cmdline {
+		switch {
+		case c == '"':
+			inQuotes = !inQuotes
+			current.WriteRune(c)
+		case c == ' ' && !inQuotes:
+			if current.Len() != 0 {
+				args = append(args, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(c)
+		}
+	}
+	if current.Len() != 0 {
+		args = append(args, current.String())
+	}
+
+	return args
+}
+
+// KernelArg formats a kernel command line argument. The value is quoted if it
+// contains spaces, and only the key is used when the value is empty.
+func KernelArg(key string, value string) string {
+	switch {
+	case value == "":
+		return key
+	case strings.Contains(value, " "):
+		return key + `="` + value + `"`
+	default:
+		return key + "=" + value
+	}
+}
+
+// AppendKernelArg appends key=value to cmdline.
+func AppendKernelArg(cmdline string, key string, value string) string {
+	arg := KernelArg(key, value)
+	if cmdline == "" {
+		return arg
+	}
+	return cmdline + " " + arg
+}
+
+// SetKernelArg replaces the value of all the occurrences of key in cmdline.
+// key=value is appended when key is not in cmdline.
+func SetKernelArg(cmdline string, key string, value string) string {
+	args := SplitKernelCmdLine(cmdline)
+	found := false
+	result := make([]string, 0, len(args)+1)
+	for _, arg := range args {
+		if arg == key || strings.HasPrefix(arg, key+"=") {
+			if found {
+				continue
+			}
+			found = true
+			arg = KernelArg(key, value)
+		}
+		result = append(result, arg)
+	}
+	if !found {
+		result = append(result, KernelArg(key, value))
+	}
+
+	return strings.Join(result, " ")
+}
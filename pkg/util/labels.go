@@ -0,0 +1,24 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseLabels parses labels in the key=value format, such as the values of the
+// --label command line argument.
+func ParseLabels(labels []string) (map[string]string, error) {
+	parsed := map[string]string{}
+	for _, label := range labels {
+		splitLabel := strings.SplitN(label, "=", 2)
+		if len(splitLabel) != 2 || splitLabel[0] == "" {
+			return nil, fmt.Errorf("invalid label '%s', expected key=value", label)
+		}
+		if _, ok := parsed[splitLabel[0]]; ok {
+			return nil, fmt.Errorf("duplicate label '%s'", splitLabel[0])
+		}
+		parsed[splitLabel[0]] = splitLabel[1]
+	}
+
+	return parsed, nil
+}
@@ -0,0 +1,36 @@
+package util
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/docker/go-units"
+)
+
+// ParseMemorySize parses a memory size such as "2GiB", "512M" or "4096" and
+// returns it in bytes. Units are binary (1G is 1024 MiB), and for backwards
+// compatibility, sizes without a unit are in MiB.
+func ParseMemorySize(str string) (uint64, error) {
+	var bytes uint64
+	if mib, err := strconv.ParseUint(str, 10, 64); err == nil {
+		if mib > math.MaxUint64/units.MiB {
+			return 0, fmt.Errorf("memory size is too large: %s MiB", str)
+		}
+		bytes = mib * units.MiB
+	} else {
+		size, err := units.RAMInBytes(str)
+		if err != nil {
+			return 0, fmt.Errorf("invalid memory size: %s", str)
+		}
+		bytes = uint64(size)
+	}
+	if bytes == 0 {
+		return 0, fmt.Errorf("memory size must be greater than 0")
+	}
+	if bytes%units.MiB != 0 {
+		return 0, fmt.Errorf("memory size must be a multiple of 1 MiB: %s", str)
+	}
+
+	return bytes, nil
+}
@@ -0,0 +1,58 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// ProcessRunning returns true if a process with the given pid exists.
+func ProcessRunning(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := unix.Kill(pid, 0)
+	// EPERM means the process exists but belongs to another user
+	return err == nil || errors.Is(err, unix.EPERM)
+}
+
+// ReadPIDFile returns the pid stored in the pid file at path. An error is
+// returned if this process is no longer running.
+func ReadPIDFile(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid pid file %s: %w", path, err)
+	}
+	if !ProcessRunning(pid) {
+		return 0, fmt.Errorf("stale pid file %s: process %d is not running", path, pid)
+	}
+
+	return pid, nil
+}
+
+// WritePIDFile writes the pid of the current process to path. It fails if path
+// contains the pid of another process which is still running.
+func WritePIDFile(path string) error {
+	if pid, err := ReadPIDFile(path); err == nil && pid != os.Getpid() {
+		return fmt.Errorf("pid file %s is used by running process %d", path, pid)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	// readers must never see a partially written pid
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
@@ -0,0 +1,39 @@
+package util
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ReadOrCreateSerialNumber returns the serial number stored at path. A random
+// one, formatted as a UUID, is generated and stored the first time, so that
+// a virtual machine keeps the same serial number across runs.
+func ReadOrCreateSerialNumber(path string) (string, error) {
+	if data, err := os.ReadFile(path); err == nil {
+		if serial := strings.TrimSpace(string(data)); serial != "" {
+			return serial, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	uuid := make([]byte, 16)
+	if _, err := rand.Read(uuid); err != nil {
+		return "", err
+	}
+	// version 4, variant 1
+	uuid[6] = uuid[6]&0x0f | 0x40
+	uuid[8] = uuid[8]&0x3f | 0x80
+	serial := fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:])
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, []byte(serial+"\n"), 0644); err != nil {
+		return "", err
+	}
+
+	return serial, nil
+}
@@ -1,6 +1,9 @@
 package util
 
-import "strings"
+import (
+	"fmt"
+	"strings"
+)
 
 func TrimQuotes(str string) string {
 	if strings.HasPrefix(str, `"`) && strings.HasSuffix(str, `"`) {
@@ -9,3 +12,81 @@ func TrimQuotes(str string) string {
 
 	return str
 }
+
+// SplitOptions splits a comma separated list of options, such as the value of
+// the --device argument. Commas within double quotes do not separate options,
+// and a backslash escapes the next character within double quotes.
+func SplitOptions(str string) ([]string, error) {
+	opts := []string{}
+	builder := strings.Builder{}
+	withinQuotes := false
+	escaped := false
+	for _, c := range str {
+		switch {
+		case escaped:
+			escaped = false
+		case withinQuotes && c == '\\':
+			escaped = true
+		case c == '"':
+			withinQuotes = !withinQuotes
+		case !withinQuotes && c == ',':
+			opts = append(opts, builder.String())
+			builder.Reset()
+			continue
+		}
+		builder.WriteRune(c)
+	}
+	if withinQuotes {
+		return nil, fmt.Errorf("mismatched \" in option list: %s", str)
+	}
+
+	return append(opts, builder.String()), nil
+}
+
+// QuoteOptionValue returns value in a form which can be used in a comma
+// separated list of key=value options. Values with commas, spaces, equal
+// signs, double quotes or backslashes are enclosed in double quotes, and the
+// double quotes and backslashes they contain are escaped with a backslash.
+func QuoteOptionValue(value string) string {
+	if !strings.ContainsAny(value, ", \t\n=\"\\") {
+		return value
+	}
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+	return `"` + replacer.Replace(value) + `"`
+}
+
+// UnquoteOptionValue reverses QuoteOptionValue. Values which are not
+// enclosed in double quotes are returned unchanged.
+func UnquoteOptionValue(value string) string {
+	if len(value) < 2 || !strings.HasPrefix(value, `"`) || !strings.HasSuffix(value, `"`) {
+		return value
+	}
+	builder := strings.Builder{}
+	escaped := false
+	for _, c := range value[1 : len(value)-1] {
+		if !escaped && c == '\\' {
+			escaped = true
+			continue
+		}
+		escaped = false
+		builder.WriteRune(c)
+	}
+
+	return builder.String()
+}
+
+// ShellQuote returns args joined with spaces, each of them quoted for a POSIX
+// shell when needed, so that command lines can be logged and copy-pasted.
+func ShellQuote(args []string) string {
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		if arg != "" && strings.Trim(arg, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_=+,./:@%") == "" {
+			quoted = append(quoted, arg)
+			continue
+		}
+		quoted = append(quoted, "'"+strings.ReplaceAll(arg, "'", `'\''`)+"'")
+	}
+
+	return strings.Join(quoted, " ")
+}
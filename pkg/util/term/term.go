@@ -0,0 +1,49 @@
+// Package term changes the mode of the terminals used by the vfkit
+// subcommands and by the pseudo-terminals of the serial ports.
+package term
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// MakeRaw puts the terminal fd in raw mode, so that all the key presses,
+// including ^C, are read as is. The returned function restores the previous
+// mode.
+func MakeRaw(fd int) (func() error, error) {
+	return setMode(fd, func(termios *unix.Termios) {
+		termios.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+		termios.Oflag &^= unix.OPOST
+		termios.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+		termios.Cflag &^= unix.CSIZE | unix.PARENB
+		termios.Cflag |= unix.CS8
+	})
+}
+
+// MakeCbreak puts the terminal fd in non-canonical mode without echo, so
+// that key presses are read immediately. Signals and output processing are
+// kept. The returned function restores the previous mode.
+func MakeCbreak(fd int) (func() error, error) {
+	return setMode(fd, func(termios *unix.Termios) {
+		termios.Lflag &^= unix.ICANON | unix.ECHO
+	})
+}
+
+// setMode applies change to the termios of fd and makes reads return as soon
+// as one byte is available. The returned function restores the previous mode.
+func setMode(fd int, change func(*unix.Termios)) (func() error, error) {
+	termios, err := unix.IoctlGetTermios(fd, ioctlGetTermios)
+	if err != nil {
+		return nil, err
+	}
+	previous := *termios
+	change(termios)
+	termios.Cc[unix.VMIN] = 1
+	termios.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, ioctlSetTermios, termios); err != nil {
+		return nil, err
+	}
+
+	return func() error {
+		return unix.IoctlSetTermios(fd, ioctlSetTermios, &previous)
+	}, nil
+}
@@ -0,0 +1,34 @@
+package vf
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/crc-org/vfkit/pkg/rest/define"
+)
+
+// DiskManager keeps track of the virtio-blk disks of a virtual machine.
+//
+// Virtualization.framework has no hot-plug support for virtio-blk devices,
+// the storage devices of a virtual machine are fixed when it's created.
+type DiskManager struct {
+	lock  sync.Mutex
+	disks []define.Disk
+}
+
+// NewDiskManager creates a DiskManager for the disk images configured when
+// the virtual machine was started.
+func NewDiskManager(imagePaths []string) *DiskManager {
+	disks := []define.Disk{}
+	for i, imagePath := range imagePaths {
+		disks = append(disks, define.Disk{ID: fmt.Sprintf("disk%d", i), ImagePath: imagePath})
+	}
+	return &DiskManager{disks: disks}
+}
+
+// Disks returns the virtio-blk disks of the virtual machine.
+func (m *DiskManager) Disks() []define.Disk {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return append([]define.Disk{}, m.disks...)
+}
@@ -0,0 +1,41 @@
+package vf
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/crc-org/vfkit/pkg/agent"
+	"github.com/crc-org/vfkit/pkg/rest/define"
+)
+
+// GuestExecutor runs commands in the guest with the vfkit guest agent.
+type GuestExecutor struct {
+	vm *VirtualMachine
+}
+
+// NewGuestExecutor creates a GuestExecutor for vm, the guest agent is reached
+// on agent.DefaultVsockPort.
+func NewGuestExecutor(vm *VirtualMachine) *GuestExecutor {
+	return &GuestExecutor{vm: vm}
+}
+
+// Exec runs the command of req in the guest and writes its output to stdout
+// and stderr while it runs. It returns the exit code of the command.
+func (e *GuestExecutor) Exec(ctx context.Context, req define.ExecRequest, stdout io.Writer, stderr io.Writer) (int, error) {
+	params := agent.ExecParams{
+		Command: req.Command,
+		Env:     req.Env,
+		Dir:     req.Dir,
+		Stdin:   req.Stdin,
+		Timeout: time.Duration(req.TimeoutSeconds) * time.Second,
+	}
+	exitCode := -1
+	err := withGuestAgent(e.vm, func(client *agent.Client) error {
+		var err error
+		exitCode, err = client.ExecStream(ctx, params, stdout, stderr)
+		return err
+	})
+
+	return exitCode, err
+}
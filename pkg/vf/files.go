@@ -0,0 +1,48 @@
+package vf
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/crc-org/vfkit/pkg/agent"
+)
+
+// GuestFiles copies files to and from the guest with the vfkit guest agent.
+type GuestFiles struct {
+	vm *VirtualMachine
+}
+
+// NewGuestFiles creates a GuestFiles for vm, the guest agent is reached on
+// agent.DefaultVsockPort.
+func NewGuestFiles(vm *VirtualMachine) *GuestFiles {
+	return &GuestFiles{vm: vm}
+}
+
+// CopyTo copies the content of r to the file at guestPath in the guest.
+func (f *GuestFiles) CopyTo(ctx context.Context, r io.Reader, guestPath string, mode os.FileMode) error {
+	return withGuestAgent(f.vm, func(client *agent.Client) error {
+		return client.CopyTo(ctx, r, guestPath, mode)
+	})
+}
+
+// CopyFrom copies the content of the file at guestPath in the guest to w.
+func (f *GuestFiles) CopyFrom(ctx context.Context, guestPath string, w io.Writer) error {
+	return withGuestAgent(f.vm, func(client *agent.Client) error {
+		return client.CopyFrom(ctx, guestPath, w)
+	})
+}
+
+// withGuestAgent calls fn with a client connected to the guest agent of vm on
+// agent.DefaultVsockPort.
+func withGuestAgent(vm *VirtualMachine, fn func(client *agent.Client) error) error {
+	conn, err := ConnectVsockSync(vm.VirtualMachine, agent.DefaultVsockPort)
+	if err != nil {
+		return fmt.Errorf("guest agent unreachable: %w", err)
+	}
+	client := agent.NewClient(conn)
+	defer client.Close()
+
+	return fn(client)
+}
@@ -0,0 +1,97 @@
+package vf
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/Code-Hex/vz/v3"
+	"github.com/crc-org/vfkit/pkg/rest/define"
+	log "github.com/sirupsen/logrus"
+	"inet.af/tcpproxy"
+)
+
+type vsockForward struct {
+	define.VsockForward
+	proxy *tcpproxy.Proxy
+}
+
+// VsockForwarder proxies connections between vsock ports and host unix
+// sockets. Forwards can be added and removed while the virtual machine is
+// running, there can be at most one forward per vsock port.
+type VsockForwarder struct {
+	vm *vz.VirtualMachine
+
+	lock     sync.Mutex
+	forwards map[uint]*vsockForward
+}
+
+// NewVsockForwarder creates a forwarder for the virtio-vsock device of vm.
+func NewVsockForwarder(vm *vz.VirtualMachine) *VsockForwarder {
+	return &VsockForwarder{
+		vm:       vm,
+		forwards: map[uint]*vsockForward{},
+	}
+}
+
+// AddForward starts forwarding connections between forward.Port and
+// forward.SocketURL.
+func (f *VsockForwarder) AddForward(forward define.VsockForward) error {
+	if forward.Port == 0 {
+		return fmt.Errorf("missing vsock port")
+	}
+	if forward.SocketURL == "" {
+		return fmt.Errorf("missing unix socket path for vsock port %d", forward.Port)
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if _, ok := f.forwards[forward.Port]; ok {
+		return fmt.Errorf("vsock port %d is already forwarded", forward.Port)
+	}
+	var listenStr string
+	if forward.Listen {
+		listenStr = " (listening)"
+	}
+	log.Infof("Exposing vsock port %d on %s%s", forward.Port, forward.SocketURL, listenStr)
+	proxy, err := exposeVsock(f.vm, forward.Port, forward.SocketURL, forward.Listen)
+	if err != nil {
+		return fmt.Errorf("error exposing vsock port %d: %w", forward.Port, err)
+	}
+	f.forwards[forward.Port] = &vsockForward{
+		VsockForward: forward,
+		proxy:        proxy,
+	}
+
+	return nil
+}
+
+// RemoveForward stops forwarding connections for vsock port. Established
+// connections are not closed.
+func (f *VsockForwarder) RemoveForward(port uint) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	forward, ok := f.forwards[port]
+	if !ok {
+		return fmt.Errorf("vsock port %d is not forwarded", port)
+	}
+	delete(f.forwards, port)
+	log.Infof("Removing vsock port %d forward", port)
+
+	return forward.proxy.Close()
+}
+
+// Forwards returns the active forwards, sorted by port.
+func (f *VsockForwarder) Forwards() []define.VsockForward {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	forwards := make([]define.VsockForward, 0, len(f.forwards))
+	for _, forward := range f.forwards {
+		forwards = append(forwards, forward.VsockForward)
+	}
+	sort.Slice(forwards, func(i, j int) bool { return forwards[i].Port < forwards[j].Port })
+
+	return forwards
+}
@@ -0,0 +1,51 @@
+package vf
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+
+	"github.com/Code-Hex/vz/v3"
+	"inet.af/tcpproxy"
+)
+
+// publishPort listens for TCP connections on hostAddress, and proxies them to
+// the connection returned by dial.
+func publishPort(hostAddress string, guestAddress string, dial func(ctx context.Context) (net.Conn, error)) (io.Closer, error) {
+	var proxy tcpproxy.Proxy
+	proxy.AddRoute(hostAddress, &tcpproxy.DialProxy{
+		Addr: guestAddress,
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dial(ctx)
+		},
+	})
+	if err := proxy.Start(); err != nil {
+		return nil, err
+	}
+
+	return &proxy, nil
+}
+
+// PublishVsockPort forwards connections to the hostAddress TCP address to the
+// vsock port guestPort.
+func PublishVsockPort(vm *vz.VirtualMachine, hostAddress string, guestPort uint32) (io.Closer, error) {
+	return publishPort(hostAddress, fmt.Sprintf("vsock:%d", guestPort), func(_ context.Context) (net.Conn, error) {
+		return ConnectVsockSync(vm, uint(guestPort))
+	})
+}
+
+// PublishTCPPort forwards connections to the hostAddress TCP address to the
+// TCP port guestPort of the guest. guestIP is called for each connection, so
+// that the guest IP address can be looked up once it's known.
+func PublishTCPPort(hostAddress string, guestIP func() (net.IP, error), guestPort uint32) (io.Closer, error) {
+	return publishPort(hostAddress, fmt.Sprintf("guest:%d", guestPort), func(ctx context.Context) (net.Conn, error) {
+		ip, err := guestIP()
+		if err != nil {
+			return nil, err
+		}
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), strconv.FormatUint(uint64(guestPort), 10)))
+	})
+}
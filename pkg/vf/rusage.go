@@ -0,0 +1,97 @@
+package vf
+
+/*
+#include <errno.h>
+#include <libproc.h>
+#include <mach/mach.h>
+#include <mach/mach_time.h>
+#include <sys/resource.h>
+
+static int vf_pid_rusage(int pid, struct rusage_info_v4 *info) {
+	if (proc_pid_rusage(pid, RUSAGE_INFO_V4, (rusage_info_t *)info) != 0) {
+		return errno;
+	}
+	return 0;
+}
+
+static kern_return_t vf_host_vm_stats(vm_statistics64_data_t *stats, vm_size_t *pageSize) {
+	mach_msg_type_number_t count = HOST_VM_INFO64_COUNT;
+	mach_port_t host = mach_host_self();
+	kern_return_t ret = host_page_size(host, pageSize);
+	if (ret == KERN_SUCCESS) {
+		ret = host_statistics64(host, HOST_VM_INFO64, (host_info64_t)stats, &count);
+	}
+	mach_port_deallocate(mach_task_self(), host);
+	return ret;
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+)
+
+// processUsage contains the resource usage of a host process.
+type processUsage struct {
+	cpuTime time.Duration
+	// billedEnergy is the energy used by the process, including the work done
+	// on its behalf by other processes, in nanojoules
+	billedEnergy uint64
+	// physFootprint is the amount of memory used by the process, in bytes
+	physFootprint uint64
+	// residentSize is the resident memory of the process, including the
+	// memory shared with other processes, in bytes
+	residentSize uint64
+	// diskReadBytes and diskWrittenBytes are the storage I/O of the
+	// process
+	diskReadBytes    uint64
+	diskWrittenBytes uint64
+}
+
+// compressorUsage describes the memory compressor of the host.
+type compressorUsage struct {
+	// uncompressedBytes is the size of the pages stored in the compressor
+	uncompressedBytes uint64
+	// compressedBytes is the physical memory used by the compressor
+	compressedBytes uint64
+}
+
+// machTimeToDuration converts mach absolute time units to a time.Duration.
+func machTimeToDuration(machTime uint64) time.Duration {
+	var timebase C.mach_timebase_info_data_t
+	C.mach_timebase_info(&timebase)
+
+	return time.Duration(machTime * uint64(timebase.numer) / uint64(timebase.denom))
+}
+
+func getProcessUsage(pid int) (*processUsage, error) {
+	var info C.struct_rusage_info_v4
+	if errno := C.vf_pid_rusage(C.int(pid), &info); errno != 0 {
+		return nil, syscall.Errno(errno)
+	}
+
+	return &processUsage{
+		cpuTime:       machTimeToDuration(uint64(info.ri_user_time) + uint64(info.ri_system_time)),
+		billedEnergy:  uint64(info.ri_billed_energy),
+		physFootprint: uint64(info.ri_phys_footprint),
+		residentSize:  uint64(info.ri_resident_size),
+
+		diskReadBytes:    uint64(info.ri_diskio_bytesread),
+		diskWrittenBytes: uint64(info.ri_diskio_byteswritten),
+	}, nil
+}
+
+func getCompressorUsage() (*compressorUsage, error) {
+	var stats C.vm_statistics64_data_t
+	var pageSize C.vm_size_t
+	if ret := C.vf_host_vm_stats(&stats, &pageSize); ret != C.KERN_SUCCESS {
+		return nil, fmt.Errorf("failed to get host memory statistics: error %d", int(ret))
+	}
+
+	return &compressorUsage{
+		uncompressedBytes: uint64(stats.total_uncompressed_pages_in_compressor) * uint64(pageSize),
+		compressedBytes:   uint64(stats.compressor_page_count) * uint64(pageSize),
+	}, nil
+}
@@ -0,0 +1,31 @@
+package vf
+
+import (
+	"sync"
+
+	"github.com/crc-org/vfkit/pkg/rest/define"
+)
+
+// ShareManager keeps track of the virtio-fs shares of a virtual machine.
+//
+// Virtualization.framework can change the share of a running virtio-fs device
+// (VZVirtioFileSystemDevice.share, macOS 12 and newer), but this is not
+// available in the Code-Hex/vz version vfkit uses, the shares can only be
+// listed.
+type ShareManager struct {
+	lock   sync.Mutex
+	shares []define.Share
+}
+
+// NewShareManager creates a ShareManager for the shares configured when the
+// virtual machine was started.
+func NewShareManager(shares []define.Share) *ShareManager {
+	return &ShareManager{shares: shares}
+}
+
+// Shares returns the virtio-fs shares of the virtual machine.
+func (m *ShareManager) Shares() []define.Share {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return append([]define.Share{}, m.shares...)
+}
@@ -0,0 +1,111 @@
+package vf
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/crc-org/vfkit/pkg/events"
+	"github.com/crc-org/vfkit/pkg/rest/define"
+	"github.com/crc-org/vfkit/pkg/snapshot"
+	vmstate "github.com/crc-org/vfkit/pkg/vm"
+)
+
+// SnapshotManager manages the snapshots of the virtio-blk disks of a virtual
+// machine.
+//
+// Virtualization.framework has no API to quiesce the I/O of a disk, the
+// virtual machine is paused while a snapshot is taken so that the guest does
+// not write to the image during the clone. Snapshots are restored in place,
+// which is only safe when the virtual machine is stopped.
+type SnapshotManager struct {
+	vm     *VirtualMachine
+	stores map[string]*snapshot.Store
+}
+
+// NewSnapshotManager creates a SnapshotManager for the disks configured when
+// the virtual machine was started. The disk IDs are the ones of DiskManager.
+func NewSnapshotManager(vm *VirtualMachine, disks []define.Disk) *SnapshotManager {
+	stores := map[string]*snapshot.Store{}
+	for _, disk := range disks {
+		stores[disk.ID] = snapshot.NewStore(disk.ImagePath)
+	}
+	return &SnapshotManager{vm: vm, stores: stores}
+}
+
+func (m *SnapshotManager) store(diskID string) (*snapshot.Store, error) {
+	store, ok := m.stores[diskID]
+	if !ok {
+		return nil, fmt.Errorf("%w: no disk with ID '%s'", os.ErrNotExist, diskID)
+	}
+	return store, nil
+}
+
+// Snapshots returns the snapshots of the disk with the given ID.
+func (m *SnapshotManager) Snapshots(diskID string) ([]define.Snapshot, error) {
+	store, err := m.store(diskID)
+	if err != nil {
+		return nil, err
+	}
+	return store.List()
+}
+
+// CreateSnapshot snapshots the disk with the given ID. A running virtual
+// machine is paused while the image is cloned.
+func (m *SnapshotManager) CreateSnapshot(diskID string, name string) (*define.Snapshot, error) {
+	store, err := m.store(diskID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch state := m.vm.stateMachine.State(); state {
+	case vmstate.StateRunning:
+		if err := m.vm.Pause(); err != nil {
+			return nil, fmt.Errorf("failed to pause virtual machine: %w", err)
+		}
+		defer func() {
+			if err := m.vm.Resume(); err != nil {
+				m.vm.Logger.Errorf("failed to resume virtual machine after snapshot: %v", err)
+			}
+		}()
+	case vmstate.StateStarting, vmstate.StateStopping:
+		return nil, fmt.Errorf("%w (%s)", define.ErrInvalidState, state)
+	}
+
+	snap, err := store.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	m.vm.recordEvent(events.TypeSnapshotCreated, fmt.Sprintf("snapshot %s of disk %s created", snap.ID, diskID))
+
+	return snap, nil
+}
+
+// RestoreSnapshot replaces the content of the disk with the given ID by the
+// content of a snapshot. The virtual machine must be stopped.
+func (m *SnapshotManager) RestoreSnapshot(diskID string, snapshotID string) error {
+	store, err := m.store(diskID)
+	if err != nil {
+		return err
+	}
+	switch state := m.vm.stateMachine.State(); state {
+	case vmstate.StateStopped, vmstate.StateError, vmstate.StateFailed:
+	default:
+		return fmt.Errorf("%w (%s), the virtual machine must be stopped", define.ErrInvalidState, state)
+	}
+
+	if err := store.Restore(snapshotID); err != nil {
+		return err
+	}
+	m.vm.recordEvent(events.TypeSnapshotRestored, fmt.Sprintf("disk %s restored from snapshot %s", diskID, snapshotID))
+
+	return nil
+}
+
+// DeleteSnapshot removes a snapshot of the disk with the given ID.
+func (m *SnapshotManager) DeleteSnapshot(diskID string, snapshotID string) error {
+	store, err := m.store(diskID)
+	if err != nil {
+		return err
+	}
+	return store.Delete(snapshotID)
+}
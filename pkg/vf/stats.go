@@ -0,0 +1,105 @@
+package vf
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/crc-org/vfkit/pkg/rest/define"
+	log "github.com/sirupsen/logrus"
+)
+
+// powerInterval is how often the energy used by vfkit is measured to compute
+// its power usage.
+const powerInterval = 10 * time.Second
+
+// statsSampler computes power usage from energy measurements made every
+// powerInterval, so that the power reported by the /vm/stats and /metrics
+// endpoints does not depend on how often they are requested.
+type statsSampler struct {
+	lock       sync.Mutex
+	startTime  time.Time
+	lastTime   time.Time
+	lastEnergy uint64
+	// power is the average power between the last two measurements
+	power float64
+}
+
+func newStatsSampler() *statsSampler {
+	now := time.Now()
+	return &statsSampler{
+		startTime: now,
+		lastTime:  now,
+	}
+}
+
+// run measures the energy used by vfkit every powerInterval.
+func (sampler *statsSampler) run() {
+	ticker := time.NewTicker(powerInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		usage, err := getProcessUsage(os.Getpid())
+		if err != nil {
+			log.Debugf("failed to get vfkit energy usage: %v", err)
+			continue
+		}
+		sampler.record(now, usage.billedEnergy)
+	}
+}
+
+// record adds an energy measurement, in nanojoules.
+func (sampler *statsSampler) record(now time.Time, energy uint64) {
+	sampler.lock.Lock()
+	defer sampler.lock.Unlock()
+
+	if elapsed := now.Sub(sampler.lastTime).Seconds(); elapsed > 0 && energy >= sampler.lastEnergy {
+		sampler.power = float64(energy-sampler.lastEnergy) / 1e9 / elapsed
+	}
+	sampler.lastTime = now
+	sampler.lastEnergy = energy
+}
+
+// powerWatts returns the average power since vfkit started, and the average
+// power between the last two measurements. energy is the energy used so far,
+// in nanojoules. The power since vfkit started is returned for both until
+// the first measurement.
+func (sampler *statsSampler) powerWatts(now time.Time, energy uint64) (average float64, current float64) {
+	sampler.lock.Lock()
+	defer sampler.lock.Unlock()
+
+	if elapsed := now.Sub(sampler.startTime).Seconds(); elapsed > 0 {
+		average = float64(energy) / 1e9 / elapsed
+	}
+	if sampler.lastTime.Equal(sampler.startTime) {
+		return average, average
+	}
+	return average, sampler.power
+}
+
+func (sampler *statsSampler) sample() (*define.Stats, error) {
+	usage, err := getProcessUsage(os.Getpid())
+	if err != nil {
+		return nil, err
+	}
+
+	stats := define.Stats{
+		CPUTimeSeconds:       usage.cpuTime.Seconds(),
+		EnergyJoules:         float64(usage.billedEnergy) / 1e9,
+		MemoryFootprintBytes: usage.physFootprint,
+		ResidentMemoryBytes:  usage.residentSize,
+		DiskReadBytes:        usage.diskReadBytes,
+		DiskWrittenBytes:     usage.diskWrittenBytes,
+	}
+	if compressor, err := getCompressorUsage(); err == nil {
+		stats.HostCompressor = &define.CompressorStats{
+			UncompressedBytes: compressor.uncompressedBytes,
+			CompressedBytes:   compressor.compressedBytes,
+		}
+		if compressor.uncompressedBytes > compressor.compressedBytes {
+			stats.HostCompressor.SavedBytes = compressor.uncompressedBytes - compressor.compressedBytes
+		}
+	}
+	stats.AveragePowerWatts, stats.PowerWatts = sampler.powerWatts(time.Now(), usage.billedEnergy)
+
+	return &stats, nil
+}
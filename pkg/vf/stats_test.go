@@ -0,0 +1,27 @@
+package vf
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStatsSamplerPower(t *testing.T) {
+	start := time.Now()
+	sampler := &statsSampler{startTime: start, lastTime: start}
+
+	// the power since vfkit started is used until the first measurement
+	if average, current := sampler.powerWatts(start.Add(5*time.Second), 10e9); average != 2 || current != 2 {
+		t.Fatalf("unexpected power before the first measurement: %v %v", average, current)
+	}
+
+	sampler.record(start.Add(10*time.Second), 10e9)
+	sampler.record(start.Add(20*time.Second), 40e9)
+	// requests between measurements do not change the power of the
+	// other clients
+	for i := 0; i < 3; i++ {
+		average, current := sampler.powerWatts(start.Add(25*time.Second), 50e9)
+		if average != 2 || current != 3 {
+			t.Fatalf("unexpected power: %v %v", average, current)
+		}
+	}
+}
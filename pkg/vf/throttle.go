@@ -0,0 +1,75 @@
+package vf
+
+/*
+#include <errno.h>
+#include <sys/resource.h>
+
+static int vf_set_darwin_background(void) {
+	if (setpriority(PRIO_DARWIN_PROCESS, 0, PRIO_DARWIN_BG) != 0) {
+		return errno;
+	}
+	return 0;
+}
+
+static int vf_set_io_policy(int policy) {
+	if (setiopolicy_np(IOPOL_TYPE_DISK, IOPOL_SCOPE_PROCESS, policy) != 0) {
+		return errno;
+	}
+	return 0;
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"syscall"
+
+	"github.com/crc-org/vfkit/pkg/throttle"
+)
+
+// utilityNice is the nice value used for the utility QoS class.
+const utilityNice = 10
+
+// SetQoSClass applies the QoS class qos to the vfkit process. The background
+// class moves the process to the darwin background band, which also
+// throttles its disk and network I/O.
+func SetQoSClass(qos throttle.QoSClass) error {
+	switch qos {
+	case throttle.QoSDefault:
+		return nil
+	case throttle.QoSUtility:
+		return syscall.Setpriority(syscall.PRIO_PROCESS, 0, utilityNice)
+	case throttle.QoSBackground:
+		if errno := C.vf_set_darwin_background(); errno != 0 {
+			return syscall.Errno(errno)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown QoS class '%s'", qos)
+	}
+}
+
+// SetIOPriority sets the disk I/O policy of the vfkit process, which applies
+// to the disk images of the virtual machine.
+func SetIOPriority(priority throttle.IOPriority) error {
+	var policy C.int
+	switch priority {
+	case throttle.IOPriorityImportant:
+		policy = C.IOPOL_IMPORTANT
+	case throttle.IOPriorityStandard:
+		policy = C.IOPOL_STANDARD
+	case throttle.IOPriorityUtility:
+		policy = C.IOPOL_UTILITY
+	case throttle.IOPriorityThrottle:
+		policy = C.IOPOL_THROTTLE
+	case throttle.IOPriorityPassive:
+		policy = C.IOPOL_PASSIVE
+	default:
+		return fmt.Errorf("unknown I/O priority '%s'", priority)
+	}
+	if errno := C.vf_set_io_policy(policy); errno != 0 {
+		return syscall.Errno(errno)
+	}
+
+	return nil
+}
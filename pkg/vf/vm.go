@@ -0,0 +1,209 @@
+package vf
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Code-Hex/vz/v3"
+	"github.com/crc-org/vfkit/pkg/events"
+	"github.com/crc-org/vfkit/pkg/rest/define"
+	vmstate "github.com/crc-org/vfkit/pkg/vm"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultShutdownTimeout is how long Shutdown waits for the guest to stop
+// before forcefully stopping the virtual machine.
+const DefaultShutdownTimeout = 30 * time.Second
+
+// VirtualMachine wraps a vz.VirtualMachine and keeps track of its state using
+// a vm.StateMachine.
+type VirtualMachine struct {
+	*vz.VirtualMachine
+	// ShutdownTimeout is how long Shutdown waits for the guest to stop before
+	// forcefully stopping the virtual machine.
+	ShutdownTimeout time.Duration
+	// Logger is used for the messages about the virtual machine, it can be
+	// given fields identifying the virtual machine.
+	Logger *log.Entry
+	// Events records the shutdown and stop requests, it can be nil.
+	Events *events.Log
+
+	stateMachine *vmstate.StateMachine
+	statsSampler *statsSampler
+
+	stopRequestedLock sync.Mutex
+	stopRequested     bool
+}
+
+// NewVirtualMachine creates a new VirtualMachine for vzVM. The state of vzVM
+// is tracked from this point on.
+func NewVirtualMachine(vzVM *vz.VirtualMachine) *VirtualMachine {
+	vm := &VirtualMachine{
+		VirtualMachine:  vzVM,
+		ShutdownTimeout: DefaultShutdownTimeout,
+		Logger:          log.NewEntry(log.StandardLogger()),
+		stateMachine:    vmstate.NewStateMachine(),
+		statsSampler:    newStatsSampler(),
+	}
+	go vm.watchState()
+	go vm.statsSampler.run()
+
+	return vm
+}
+
+// StateMachine returns the state machine tracking the state of vm.
+func (vm *VirtualMachine) StateMachine() *vmstate.StateMachine {
+	return vm.stateMachine
+}
+
+// Stats returns the resource usage of the virtual machine, including an
+// estimate of its energy usage.
+func (vm *VirtualMachine) Stats() (*define.Stats, error) {
+	return vm.statsSampler.sample()
+}
+
+// vzStateToState converts a vz virtual machine state to a vm.State. The
+// second return value is false for transient states (pausing, resuming) which
+// have no equivalent in the vfkit state machine.
+func vzStateToState(vzState vz.VirtualMachineState) (vmstate.State, bool) {
+	switch vzState {
+	case vz.VirtualMachineStateStopped:
+		return vmstate.StateStopped, true
+	case vz.VirtualMachineStateRunning:
+		return vmstate.StateRunning, true
+	case vz.VirtualMachineStatePaused:
+		return vmstate.StatePaused, true
+	case vz.VirtualMachineStateError:
+		return vmstate.StateError, true
+	case vz.VirtualMachineStateStarting:
+		return vmstate.StateStarting, true
+	case vz.VirtualMachineStateStopping:
+		return vmstate.StateStopping, true
+	default:
+		return vmstate.StateStopped, false
+	}
+}
+
+// watchState forwards the state changes reported by Virtualization.framework
+// to the state machine.
+func (vm *VirtualMachine) watchState() {
+	for vzState := range vm.StateChangedNotify() {
+		state, ok := vzStateToState(vzState)
+		if !ok {
+			vm.Logger.Debugf("ignoring transient VM state %v", vzState)
+			continue
+		}
+		vm.Logger.WithField("state", state).Debug("virtual machine state changed")
+		if err := vm.stateMachine.SetState(state); err != nil {
+			vm.Logger.Debugf("%v", err)
+		}
+	}
+}
+
+// recordEvent records an event in vm.Events, if set.
+func (vm *VirtualMachine) recordEvent(eventType string, message string) {
+	if vm.Events == nil {
+		return
+	}
+	if _, err := vm.Events.Record(eventType, message, nil); err != nil {
+		vm.Logger.Warnf("failed to record %s event: %v", eventType, err)
+	}
+}
+
+func (vm *VirtualMachine) setStopRequested(stopRequested bool) {
+	vm.stopRequestedLock.Lock()
+	defer vm.stopRequestedLock.Unlock()
+	vm.stopRequested = stopRequested
+}
+
+// StopRequested returns true if the virtual machine was stopped using
+// RequestShutdown, Shutdown or Stop since it was last started. It returns
+// false when the guest shut itself down.
+func (vm *VirtualMachine) StopRequested() bool {
+	vm.stopRequestedLock.Lock()
+	defer vm.stopRequestedLock.Unlock()
+	return vm.stopRequested
+}
+
+// Start boots the virtual machine.
+func (vm *VirtualMachine) Start() error {
+	vm.setStopRequested(false)
+	if err := vm.stateMachine.SetState(vmstate.StateStarting); err != nil {
+		return err
+	}
+	vm.Logger.Debug("starting virtual machine")
+	if err := vm.VirtualMachine.Start(); err != nil {
+		vm.Logger.WithError(err).Debug("virtual machine failed to start")
+		_ = vm.stateMachine.SetState(vmstate.StateError)
+		return err
+	}
+
+	return nil
+}
+
+// RequestShutdown asks the guest to shut itself down, similar to pressing the
+// power button of a physical machine. The guest can ignore this request.
+func (vm *VirtualMachine) RequestShutdown() error {
+	if !vm.CanRequestStop() {
+		return fmt.Errorf("virtual machine cannot be asked to stop in its current state (%s)", vm.stateMachine.State())
+	}
+	vm.setStopRequested(true)
+	vm.recordEvent(events.TypeShutdownRequested, "guest shutdown requested")
+	if _, err := vm.RequestStop(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Stop forcefully stops the virtual machine without giving the guest a chance
+// to shut down cleanly.
+func (vm *VirtualMachine) Stop() error {
+	if !vm.CanStop() {
+		return fmt.Errorf("virtual machine cannot be stopped in its current state (%s)", vm.stateMachine.State())
+	}
+	vm.setStopRequested(true)
+	vm.recordEvent(events.TypeStopRequested, "virtual machine stop requested")
+
+	return vm.VirtualMachine.Stop()
+}
+
+// Shutdown asks the guest to shut down, and waits for up to ShutdownTimeout
+// for the virtual machine to stop. If the guest is not stopped by then, the
+// virtual machine is forcefully stopped.
+func (vm *VirtualMachine) Shutdown() error {
+	if err := vm.RequestShutdown(); err != nil {
+		vm.Logger.Debugf("guest shutdown request failed: %v", err)
+		return vm.Stop()
+	}
+	_ = vm.stateMachine.SetState(vmstate.StateStopping)
+
+	ctx, cancel := context.WithTimeout(context.Background(), vm.ShutdownTimeout)
+	defer cancel()
+	if _, err := vm.stateMachine.WaitForState(ctx, vmstate.StateStopped, vmstate.StateError); err == nil {
+		return nil
+	}
+	vm.Logger.Warnf("guest did not shut down after %s, forcing virtual machine stop", vm.ShutdownTimeout)
+
+	return vm.Stop()
+}
+
+// Pause suspends the execution of the virtual machine.
+func (vm *VirtualMachine) Pause() error {
+	if !vm.CanPause() {
+		return fmt.Errorf("virtual machine cannot be paused in its current state (%s)", vm.stateMachine.State())
+	}
+
+	return vm.VirtualMachine.Pause()
+}
+
+// Resume resumes the execution of a paused virtual machine.
+func (vm *VirtualMachine) Resume() error {
+	if !vm.CanResume() {
+		return fmt.Errorf("virtual machine cannot be resumed in its current state (%s)", vm.stateMachine.State())
+	}
+
+	return vm.VirtualMachine.Resume()
+}
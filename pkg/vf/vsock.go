@@ -13,6 +13,11 @@ import (
 )
 
 func ExposeVsock(vm *vz.VirtualMachine, port uint, vsockPath string, listen bool) error {
+	_, err := exposeVsock(vm, port, vsockPath, listen)
+	return err
+}
+
+func exposeVsock(vm *vz.VirtualMachine, port uint, vsockPath string, listen bool) (*tcpproxy.Proxy, error) {
 	if listen {
 		return listenVsock(vm, port, vsockPath)
 	} else {
@@ -32,7 +37,7 @@ func ConnectVsockSync(vm *vz.VirtualMachine, port uint) (net.Conn, error) {
 
 // connectVsock proxies connections from a host unix socket to a vsock port
 // This allows the host to initiate connections to the guest over vsock
-func connectVsock(vm *vz.VirtualMachine, port uint, vsockPath string) error {
+func connectVsock(vm *vz.VirtualMachine, port uint, vsockPath string) (*tcpproxy.Proxy, error) {
 
 	var proxy tcpproxy.Proxy
 	// listen for connections on the host unix socket
@@ -66,12 +71,15 @@ func connectVsock(vm *vz.VirtualMachine, port uint, vsockPath string) error {
 			}
 		},
 	})
-	return proxy.Start()
+	if err := proxy.Start(); err != nil {
+		return nil, err
+	}
+	return &proxy, nil
 }
 
 // listenVsock proxies connections from a vsock port to a host unix socket.
 // This allows the guest to initiate connections to the host over vsock
-func listenVsock(vm *vz.VirtualMachine, port uint, vsockPath string) error {
+func listenVsock(vm *vz.VirtualMachine, port uint, vsockPath string) (*tcpproxy.Proxy, error) {
 	var proxy tcpproxy.Proxy
 	// listen for connections on the vsock port
 	proxy.ListenFunc = func(_, laddr string) (net.Listener, error) {
@@ -112,6 +120,8 @@ func listenVsock(vm *vz.VirtualMachine, port uint, vsockPath string) error {
 			}
 		},
 	})
-	//defer proxy.Close()
-	return proxy.Start()
+	if err := proxy.Start(); err != nil {
+		return nil, err
+	}
+	return &proxy, nil
 }
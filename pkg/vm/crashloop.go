@@ -0,0 +1,36 @@
+package vm
+
+import (
+	"time"
+)
+
+// CrashLoopDetector detects virtual machines which keep failing after being
+// restarted.
+type CrashLoopDetector struct {
+	maxFailures int
+	window      time.Duration
+	failures    []time.Time
+}
+
+// NewCrashLoopDetector creates a detector which reports a crash loop when
+// there are more than maxFailures failures within window.
+func NewCrashLoopDetector(maxFailures int, window time.Duration) *CrashLoopDetector {
+	return &CrashLoopDetector{
+		maxFailures: maxFailures,
+		window:      window,
+	}
+}
+
+// RecordFailure records a failure which happened at time t. It returns true
+// when the virtual machine is in a crash loop and must not be restarted.
+func (d *CrashLoopDetector) RecordFailure(t time.Time) bool {
+	recent := d.failures[:0]
+	for _, failure := range d.failures {
+		if t.Sub(failure) < d.window {
+			recent = append(recent, failure)
+		}
+	}
+	d.failures = append(recent, t)
+
+	return len(d.failures) > d.maxFailures
+}
@@ -0,0 +1,22 @@
+package vm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCrashLoopDetector(t *testing.T) {
+	detector := NewCrashLoopDetector(2, time.Minute)
+	start := time.Now()
+
+	if detector.RecordFailure(start) || detector.RecordFailure(start.Add(10*time.Second)) {
+		t.Fatal("unexpected crash loop")
+	}
+	// the first failure is out of the window
+	if detector.RecordFailure(start.Add(65 * time.Second)) {
+		t.Fatal("unexpected crash loop")
+	}
+	if !detector.RecordFailure(start.Add(68 * time.Second)) {
+		t.Fatal("expected crash loop after 3 failures within 1 minute")
+	}
+}
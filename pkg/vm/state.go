@@ -0,0 +1,247 @@
+// Package vm tracks the lifecycle of a virtual machine started by vfkit.
+//
+// It provides a small state machine (starting, running, paused, stopping,
+// stopped, error, failed) which other parts of vfkit can subscribe to in order
+// to be notified of state changes.
+package vm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// State is the lifecycle state of a virtual machine.
+type State int
+
+const (
+	// StateStopped is the initial state, before the virtual machine is
+	// started, and its final state once it has stopped.
+	StateStopped State = iota
+	// StateStarting is used while the virtual machine is being configured and
+	// booted.
+	StateStarting
+	// StateRunning is used when the virtual machine is running.
+	StateRunning
+	// StatePaused is used when the virtual machine execution is suspended.
+	StatePaused
+	// StateStopping is used while the virtual machine is shutting down.
+	StateStopping
+	// StateError is used when the virtual machine encountered an unrecoverable
+	// error.
+	StateError
+	// StateFailed is used when vfkit gave up restarting a virtual machine
+	// which kept failing.
+	StateFailed
+)
+
+var stateNames = map[State]string{
+	StateStopped:  "stopped",
+	StateStarting: "starting",
+	StateRunning:  "running",
+	StatePaused:   "paused",
+	StateStopping: "stopping",
+	StateError:    "error",
+	StateFailed:   "failed",
+}
+
+func (state State) String() string {
+	if name, ok := stateNames[state]; ok {
+		return name
+	}
+	return fmt.Sprintf("State(%d)", int(state))
+}
+
+// ParseState converts the string representation of a state back to a State.
+func ParseState(str string) (State, error) {
+	for state, name := range stateNames {
+		if strings.EqualFold(name, str) {
+			return state, nil
+		}
+	}
+	return StateStopped, fmt.Errorf("unknown virtual machine state: %s", str)
+}
+
+func (state State) MarshalText() ([]byte, error) {
+	return []byte(state.String()), nil
+}
+
+func (state *State) UnmarshalText(text []byte) error {
+	parsed, err := ParseState(string(text))
+	if err != nil {
+		return err
+	}
+	*state = parsed
+	return nil
+}
+
+// validTransitions lists the states which can be reached from a given state.
+var validTransitions = map[State][]State{
+	StateStopped:  {StateStarting, StateFailed},
+	StateStarting: {StateRunning, StateStopped, StateError},
+	StateRunning:  {StatePaused, StateStopping, StateStopped, StateError},
+	StatePaused:   {StateRunning, StateStopping, StateStopped, StateError},
+	// the guest can ignore a stop request and keep running
+	StateStopping: {StateRunning, StateStopped, StateError},
+	StateError:    {StateStarting, StateStopped, StateFailed},
+	StateFailed:   {},
+}
+
+func canTransition(from, to State) bool {
+	for _, state := range validTransitions[from] {
+		if state == to {
+			return true
+		}
+	}
+	return false
+}
+
+// StateChange describes a transition of the virtual machine from one state to
+// another.
+type StateChange struct {
+	From State     `json:"from"`
+	To   State     `json:"to"`
+	Time time.Time `json:"time"`
+	// Message gives more details about the state change, it can be empty
+	Message string `json:"message,omitempty"`
+}
+
+// StateMachine keeps track of the current state of a virtual machine and
+// notifies subscribers when it changes. It is safe for concurrent use.
+type StateMachine struct {
+	mu          sync.Mutex
+	state       State
+	subscribers map[chan StateChange]struct{}
+}
+
+// NewStateMachine creates a new StateMachine in the StateStopped state.
+func NewStateMachine() *StateMachine {
+	return &StateMachine{
+		state:       StateStopped,
+		subscribers: map[chan StateChange]struct{}{},
+	}
+}
+
+// State returns the current state of the virtual machine.
+func (m *StateMachine) State() State {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.state
+}
+
+// SetState moves the state machine to newState and notifies all subscribers.
+// An error is returned if newState cannot be reached from the current state.
+// Setting the current state again is a no-op.
+func (m *StateMachine) SetState(newState State) error {
+	return m.SetStateWithMessage(newState, "")
+}
+
+// SetStateWithMessage is similar to SetState, message is sent to the
+// subscribers as part of the StateChange.
+func (m *StateMachine) SetStateWithMessage(newState State, message string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if newState == m.state {
+		return nil
+	}
+	if !canTransition(m.state, newState) {
+		return fmt.Errorf("invalid virtual machine state transition from %s to %s", m.state, newState)
+	}
+
+	change := StateChange{
+		From:    m.state,
+		To:      newState,
+		Time:    time.Now(),
+		Message: message,
+	}
+	m.state = newState
+	for ch := range m.subscribers {
+		// slow subscribers can miss intermediate changes, but they must not
+		// block the state machine
+		select {
+		case ch <- change:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// Subscribe returns a channel which will receive all future state changes.
+// The returned function must be called to unsubscribe when notifications are
+// no longer needed, it closes the channel.
+func (m *StateMachine) Subscribe() (<-chan StateChange, func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ch := make(chan StateChange, 16)
+	m.subscribers[ch] = struct{}{}
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			delete(m.subscribers, ch)
+			close(ch)
+		})
+	}
+
+	return ch, unsubscribe
+}
+
+// WaitForState blocks until the virtual machine reaches one of the states
+// passed as argument, or until ctx is done. It returns the state which was
+// reached.
+func (m *StateMachine) WaitForState(ctx context.Context, states ...State) (State, error) {
+	changes, unsubscribe := m.Subscribe()
+	defer unsubscribe()
+
+	isWanted := func(state State) bool {
+		for _, s := range states {
+			if s == state {
+				return true
+			}
+		}
+		return false
+	}
+
+	if current := m.State(); isWanted(current) {
+		return current, nil
+	}
+	for {
+		select {
+		case change := <-changes:
+			if isWanted(change.To) {
+				return change.To, nil
+			}
+		case <-ctx.Done():
+			return m.State(), ctx.Err()
+		}
+	}
+}
+
+// WaitForChange blocks until the virtual machine state is different from
+// state, or until ctx is done. It returns the current state.
+func (m *StateMachine) WaitForChange(ctx context.Context, state State) (State, error) {
+	changes, unsubscribe := m.Subscribe()
+	defer unsubscribe()
+
+	if current := m.State(); current != state {
+		return current, nil
+	}
+	for {
+		select {
+		case change := <-changes:
+			if change.To != state {
+				return change.To, nil
+			}
+		case <-ctx.Done():
+			return m.State(), ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package vm
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestStateTransitions(t *testing.T) {
+	machine := NewStateMachine()
+	if machine.State() != StateStopped {
+		t.Fatalf("expected initial state to be %s, got %s", StateStopped, machine.State())
+	}
+
+	for _, state := range []State{StateStarting, StateRunning, StatePaused, StateRunning, StateStopping, StateStopped} {
+		if err := machine.SetState(state); err != nil {
+			t.Fatalf("expected no error; got %v", err)
+		}
+		if machine.State() != state {
+			t.Fatalf("expected state to be %s, got %s", state, machine.State())
+		}
+	}
+
+	if err := machine.SetState(StatePaused); err == nil {
+		t.Fatalf("expected error for transition from %s to %s", StateStopped, StatePaused)
+	}
+}
+
+func TestStateSubscribe(t *testing.T) {
+	machine := NewStateMachine()
+	changes, unsubscribe := machine.Subscribe()
+
+	if err := machine.SetState(StateStarting); err != nil {
+		t.Fatalf("expected no error; got %v", err)
+	}
+	change := <-changes
+	if change.From != StateStopped || change.To != StateStarting {
+		t.Fatalf("unexpected state change: %+v", change)
+	}
+
+	unsubscribe()
+	if _, ok := <-changes; ok {
+		t.Fatalf("expected channel to be closed after unsubscribing")
+	}
+	// unsubscribing twice must not panic
+	unsubscribe()
+}
+
+func TestWaitForState(t *testing.T) {
+	machine := NewStateMachine()
+
+	go func() {
+		_ = machine.SetState(StateStarting)
+		_ = machine.SetState(StateRunning)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	state, err := machine.WaitForState(ctx, StateRunning, StateError)
+	if err != nil {
+		t.Fatalf("expected no error; got %v", err)
+	}
+	if state != StateRunning {
+		t.Fatalf("expected state to be %s, got %s", StateRunning, state)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := machine.WaitForChange(ctx, StateRunning); err == nil {
+		t.Fatalf("expected timeout error")
+	}
+}
+
+func TestParseState(t *testing.T) {
+	for state, name := range stateNames {
+		parsed, err := ParseState(name)
+		if err != nil {
+			t.Fatalf("expected no error; got %v", err)
+		}
+		if parsed != state {
+			t.Fatalf("expected %s to be parsed as %d, got %d", name, state, parsed)
+		}
+	}
+	if _, err := ParseState("invalid"); err == nil {
+		t.Fatalf("expected error when parsing invalid state")
+	}
+}
@@ -0,0 +1,45 @@
+package vm
+
+import (
+	"time"
+)
+
+// Watchdog detects guests which stopped answering heartbeats. It's armed by
+// the first heartbeat, so that guests which are still booting, or whose agent
+// is not started yet, are not considered hung.
+type Watchdog struct {
+	timeout       time.Duration
+	lastHeartbeat time.Time
+}
+
+// NewWatchdog creates a watchdog which expires when there is no heartbeat for
+// longer than timeout.
+func NewWatchdog(timeout time.Duration) *Watchdog {
+	return &Watchdog{
+		timeout: timeout,
+	}
+}
+
+// Heartbeat records a heartbeat answered by the guest at time t, and arms the
+// watchdog.
+func (w *Watchdog) Heartbeat(t time.Time) {
+	w.lastHeartbeat = t
+}
+
+// Disarm disarms the watchdog until the next heartbeat, for example while the
+// virtual machine is paused or restarting.
+func (w *Watchdog) Disarm() {
+	w.lastHeartbeat = time.Time{}
+}
+
+// Armed returns true if the watchdog received a heartbeat since it was
+// created or last disarmed.
+func (w *Watchdog) Armed() bool {
+	return !w.lastHeartbeat.IsZero()
+}
+
+// Expired returns true if the watchdog is armed and the last heartbeat is
+// older than the timeout at time t.
+func (w *Watchdog) Expired(t time.Time) bool {
+	return w.Armed() && t.Sub(w.lastHeartbeat) > w.timeout
+}
@@ -0,0 +1,33 @@
+package vm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWatchdog(t *testing.T) {
+	start := time.Now()
+	watchdog := NewWatchdog(30 * time.Second)
+
+	if watchdog.Expired(start.Add(time.Hour)) {
+		t.Fatalf("expected watchdog without heartbeat not to expire")
+	}
+
+	watchdog.Heartbeat(start)
+	if watchdog.Expired(start.Add(30 * time.Second)) {
+		t.Fatalf("expected watchdog not to expire within its timeout")
+	}
+	if !watchdog.Expired(start.Add(31 * time.Second)) {
+		t.Fatalf("expected watchdog to expire after its timeout")
+	}
+
+	watchdog.Heartbeat(start.Add(20 * time.Second))
+	if watchdog.Expired(start.Add(31 * time.Second)) {
+		t.Fatalf("expected heartbeat to reset the watchdog")
+	}
+
+	watchdog.Disarm()
+	if watchdog.Armed() || watchdog.Expired(start.Add(time.Hour)) {
+		t.Fatalf("expected disarmed watchdog not to expire")
+	}
+}
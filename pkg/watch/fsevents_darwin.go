@@ -0,0 +1,130 @@
+//go:build darwin && cgo
+// +build darwin,cgo
+
+package watch
+
+/*
+#cgo LDFLAGS: -framework CoreServices
+#include <stdlib.h>
+#include <CoreServices/CoreServices.h>
+#include <dispatch/dispatch.h>
+
+extern void watchFSEventsCallback(uintptr_t handle, size_t numEvents, char **paths);
+
+static void fsEventsCallback(ConstFSEventStreamRef stream, void *info, size_t numEvents, void *eventPaths,
+                             const FSEventStreamEventFlags eventFlags[], const FSEventStreamEventId eventIds[])
+{
+	watchFSEventsCallback((uintptr_t)info, numEvents, (char **)eventPaths);
+}
+
+static void noop(void *context)
+{
+}
+
+static FSEventStreamRef startStream(const char *path, uintptr_t handle, double latency, dispatch_queue_t queue)
+{
+	CFStringRef cfPath = CFStringCreateWithCString(NULL, path, kCFStringEncodingUTF8);
+	CFArrayRef paths = CFArrayCreate(NULL, (const void **)&cfPath, 1, &kCFTypeArrayCallBacks);
+	FSEventStreamContext context = {0, (void *)handle, NULL, NULL, NULL};
+	FSEventStreamRef stream = FSEventStreamCreate(NULL, fsEventsCallback, &context, paths,
+	                                              kFSEventStreamEventIdSinceNow, latency,
+	                                              kFSEventStreamCreateFlagFileEvents | kFSEventStreamCreateFlagNoDefer);
+	CFRelease(paths);
+	CFRelease(cfPath);
+	if (stream == NULL) {
+		return NULL;
+	}
+
+	FSEventStreamSetDispatchQueue(stream, queue);
+	if (!FSEventStreamStart(stream)) {
+		FSEventStreamInvalidate(stream);
+		FSEventStreamRelease(stream);
+		return NULL;
+	}
+	return stream;
+}
+
+static dispatch_queue_t newQueue(void)
+{
+	return dispatch_queue_create("org.crc-org.vfkit.watch", DISPATCH_QUEUE_SERIAL);
+}
+
+static void stopStream(FSEventStreamRef stream, dispatch_queue_t queue)
+{
+	FSEventStreamStop(stream);
+	FSEventStreamInvalidate(stream);
+	FSEventStreamRelease(stream);
+	// wait for the callbacks already scheduled on the queue
+	dispatch_sync_f(queue, NULL, noop);
+}
+
+static void releaseQueue(dispatch_queue_t queue)
+{
+	dispatch_release(queue);
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime/cgo"
+	"strings"
+	"unsafe"
+)
+
+// platformWatcher uses a FSEvents stream with file-level events.
+type platformWatcher struct {
+	stream C.FSEventStreamRef
+	queue  C.dispatch_queue_t
+	handle cgo.Handle
+	// FSEvents reports paths with symlinks resolved
+	realRoot string
+}
+
+//export watchFSEventsCallback
+func watchFSEventsCallback(handle C.uintptr_t, numEvents C.size_t, cPaths **C.char) {
+	w := cgo.Handle(handle).Value().(*Watcher)
+	paths := unsafe.Slice(cPaths, int(numEvents))
+	changed := make([]string, 0, len(paths))
+	for _, cPath := range paths {
+		path := C.GoString(cPath)
+		rel, err := filepath.Rel(w.realRoot, path)
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		changed = append(changed, rel)
+	}
+	// the callback runs on a serial dispatch queue, blocking it until the
+	// paths are consumed delays the next events
+	w.send(changed)
+}
+
+func (w *Watcher) start() error {
+	realRoot, err := filepath.EvalSymlinks(w.root)
+	if err != nil {
+		return err
+	}
+	w.realRoot = realRoot
+	w.handle = cgo.NewHandle(w)
+
+	cRoot := C.CString(realRoot)
+	defer C.free(unsafe.Pointer(cRoot))
+	w.queue = C.newQueue()
+	w.stream = C.startStream(cRoot, C.uintptr_t(w.handle), C.double(w.latency.Seconds()), w.queue)
+	if w.stream == nil {
+		C.releaseQueue(w.queue)
+		w.handle.Delete()
+		return fmt.Errorf("failed to watch %s with FSEvents", w.root)
+	}
+
+	return nil
+}
+
+func (w *Watcher) stop() {
+	// w.done is closed, a callback in progress returns without waiting for
+	// the paths to be consumed
+	C.stopStream(w.stream, w.queue)
+	C.releaseQueue(w.queue)
+	w.handle.Delete()
+}
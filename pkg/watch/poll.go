@@ -0,0 +1,80 @@
+//go:build !darwin || !cgo
+// +build !darwin !cgo
+
+package watch
+
+import (
+	"io/fs"
+	"path/filepath"
+	"time"
+)
+
+type fileState struct {
+	modTime int64
+	size    int64
+	mode    fs.FileMode
+}
+
+// platformWatcher periodically scans the watched tree and compares it with
+// the previous scan.
+type platformWatcher struct {
+	stopped chan struct{}
+}
+
+func (w *Watcher) scan() map[string]fileState {
+	files := map[string]fileState{}
+	_ = filepath.WalkDir(w.root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// the file may have been removed during the scan
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(w.root, path)
+		if err != nil || rel == "." {
+			return nil
+		}
+		files[rel] = fileState{modTime: info.ModTime().UnixNano(), size: info.Size(), mode: info.Mode()}
+		return nil
+	})
+	return files
+}
+
+func (w *Watcher) start() error {
+	w.stopped = make(chan struct{})
+	previous := w.scan()
+	go func() {
+		defer close(w.stopped)
+		ticker := time.NewTicker(w.latency)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.done:
+				return
+			case <-ticker.C:
+			}
+			current := w.scan()
+			changed := []string{}
+			for path, state := range current {
+				if prev, ok := previous[path]; !ok || prev != state {
+					changed = append(changed, path)
+				}
+			}
+			for path := range previous {
+				if _, ok := current[path]; !ok {
+					changed = append(changed, path)
+				}
+			}
+			previous = current
+			w.send(changed)
+		}
+	}()
+
+	return nil
+}
+
+func (w *Watcher) stop() {
+	<-w.stopped
+}
@@ -0,0 +1,92 @@
+// Package watch reports changes to the files of a host directory. It is used
+// to notify the guest of changes made on the host to virtio-fs shares, as
+// virtio-fs does not forward them to inotify in the guest.
+package watch
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultLatency is the default delay used to coalesce change events.
+const DefaultLatency = 100 * time.Millisecond
+
+// Watcher watches a directory tree and reports the files which changed in
+// it. Changes are coalesced: each value sent on Events is the list of the
+// paths, relative to the watched directory, which changed during the last
+// latency period.
+//
+// On macOS, FSEvents is used. Elsewhere, or when built without cgo, the
+// directory tree is scanned periodically.
+type Watcher struct {
+	root    string
+	latency time.Duration
+	events  chan []string
+	done    chan struct{}
+
+	closeOnce sync.Once
+	platformWatcher
+}
+
+// New starts watching root for changes.
+func New(root string, latency time.Duration) (*Watcher, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", root)
+	}
+	if latency <= 0 {
+		latency = DefaultLatency
+	}
+
+	w := &Watcher{
+		root:    root,
+		latency: latency,
+		events:  make(chan []string, 16),
+		done:    make(chan struct{}),
+	}
+	if err := w.start(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// Root returns the directory watched by w.
+func (w *Watcher) Root() string {
+	return w.root
+}
+
+// Events returns the channel on which the changed paths are sent. It is
+// closed when the watcher is closed.
+func (w *Watcher) Events() <-chan []string {
+	return w.events
+}
+
+// Close stops watching the directory.
+func (w *Watcher) Close() error {
+	w.closeOnce.Do(func() {
+		close(w.done)
+		w.stop()
+		close(w.events)
+	})
+	return nil
+}
+
+// send sends paths on the events channel, it must not be called after stop
+// returned.
+func (w *Watcher) send(paths []string) {
+	if len(paths) == 0 {
+		return
+	}
+	sort.Strings(paths)
+	select {
+	case w.events <- paths:
+	case <-w.done:
+	}
+}
@@ -0,0 +1,70 @@
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func waitForPath(t *testing.T, w *Watcher, path string) {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case paths := <-w.Events():
+			for _, p := range paths {
+				if p == path {
+					return
+				}
+			}
+		case <-timeout:
+			t.Fatalf("no event for %s", path)
+		}
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "src"), 0755); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	w, err := New(root, 20*time.Millisecond)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	defer w.Close()
+
+	path := filepath.Join("src", "main.go")
+	if err := os.WriteFile(filepath.Join(root, path), []byte("package main\n"), 0600); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	waitForPath(t, w, path)
+
+	if err := os.Remove(filepath.Join(root, path)); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	waitForPath(t, w, path)
+}
+
+func TestWatcherNotADirectory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if _, err := New(path, 0); err == nil {
+		t.Fatal("expected error when watching a file")
+	}
+}
+
+func TestWatcherClose(t *testing.T) {
+	w, err := New(t.TempDir(), 10*time.Millisecond)
+	if err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal("expected no error; got", err)
+	}
+	if _, ok := <-w.Events(); ok {
+		t.Fatal("expected events channel to be closed")
+	}
+}